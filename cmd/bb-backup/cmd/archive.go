@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	archiveFormat string
+	archiveRemove bool
+	archiveJSON   bool
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive <backup-path>",
+	Short: "Package completed backup runs into compressed archives",
+	Long: `Package completed backup runs into compressed archives, each with a
+checksum file.
+
+Given a workspace directory, every completed run (one with a
+manifest.json) not yet archived is written to <run-id>.tar.gz or
+<run-id>.zip next to its directory; given a run directory, just that run
+is. Each file is stored under a top-level <run-id>/ directory, and the
+archive's SHA-256 is written to <archive>.sha256 in the format of
+sha256sum, so "sha256sum -c" can check it too. The archive is read back
+before it is kept.
+
+--remove deletes each archived run's directory afterwards, except the run
+current points at. Archives are checked against their checksums before
+the directory of a run archived earlier is deleted.
+
+Set storage.archive (and storage.archive_remove) in the config to do this
+at the end of every backup. With storage.type s3 in the config, archives
+of runs in storage.path are uploaded to the bucket and removed run
+directories are deleted from it. Check archives with "bb-backup verify".
+
+Examples:
+  bb-backup archive /backups/my-workspace
+  bb-backup archive /backups/my-workspace --format zip --remove
+  bb-backup archive /backups/my-workspace/2024-01-15T10-30-00Z-9b07d3e1 --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runArchive,
+}
+
+func init() {
+	rootCmd.AddCommand(archiveCmd)
+
+	archiveCmd.Flags().StringVar(&archiveFormat, "format", config.ArchiveTarGz, "archive format: tar.gz or zip")
+	archiveCmd.Flags().BoolVar(&archiveRemove, "remove", false, "delete each run directory once archived")
+	archiveCmd.Flags().BoolVar(&archiveJSON, "json", false, "output as JSON")
+}
+
+func runArchive(_ *cobra.Command, args []string) error {
+	if archiveFormat != config.ArchiveTarGz && archiveFormat != config.ArchiveZip {
+		return fmt.Errorf("--format must be 'tar.gz' or 'zip', got '%s'", archiveFormat)
+	}
+	path := filepath.Clean(args[0])
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return fmt.Errorf("backup path is not a directory: %s", args[0])
+	}
+
+	workspaceDir := path
+	opts := backup.ArchiveOptions{Format: archiveFormat, Remove: archiveRemove}
+	if _, err := os.Stat(filepath.Join(path, "manifest.json")); err == nil {
+		workspaceDir, opts.RunID = filepath.Dir(path), filepath.Base(path)
+	}
+	store, err := archiveStorage(workspaceDir)
+	if err != nil {
+		return err
+	}
+	opts.Storage = store
+	results, err := backup.ArchiveRuns(workspaceDir, opts)
+	if err != nil {
+		return err
+	}
+
+	if archiveJSON {
+		if results == nil {
+			results = []backup.ArchiveResult{}
+		}
+		return writeJSON(results)
+	}
+	if len(results) == 0 {
+		fmt.Println("Nothing to archive.")
+		return nil
+	}
+	for _, r := range results {
+		switch {
+		case r.Existing:
+			fmt.Printf("Removed %s (already archived in %s)\n", r.RunID, r.Path)
+		case r.Removed:
+			fmt.Printf("Archived %s to %s (%d files, %s) and removed the directory\n", r.RunID, r.Path, r.Files, format.Bytes(r.Bytes))
+		default:
+			fmt.Printf("Archived %s to %s (%d files, %s)\n", r.RunID, r.Path, r.Files, format.Bytes(r.Bytes))
+		}
+	}
+	return nil
+}
+
+// archiveStorage returns the configured s3 storage when the workspace
+// directory is in its working copy, so archives reach the bucket; nil
+// otherwise.
+func archiveStorage(workspaceDir string) (storage.Storage, error) {
+	cfgPath := getConfigPath()
+	if cfgPath == "" {
+		return nil, nil
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading config from %s: %w", cfgPath, err)
+	}
+	if cfg.Storage.Type != "s3" {
+		return nil, nil
+	}
+	base, err := filepath.Abs(cfg.Storage.Path)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(base, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, nil
+	}
+	return backup.OpenStorage(cfg)
+}
@@ -2,16 +2,22 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/andy-wilson/bb-backup/internal/backup"
 	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
 	"github.com/andy-wilson/bb-backup/internal/logging"
+	"github.com/andy-wilson/bb-backup/internal/ui"
 	"github.com/spf13/cobra"
 )
 
@@ -31,6 +37,18 @@ var (
 	singleRepo      string
 	gitOnly         bool
 	metadataOnly    bool
+	progressMode    string
+	progressEvery   time.Duration
+	rerunID         string
+	groups          []string
+	includeProjects []string
+	progressFile    string
+	progressURL     string
+	unquarantine    []string
+	requarantine    []string
+	reposList       string
+	failOnRepoError bool
+	niceMode        bool
 )
 
 var backupCmd = &cobra.Command{
@@ -54,7 +72,13 @@ Backup modes:
 
 Progress output:
   --interactive    Interactive mode with progress bar and ETA
-  --json-progress  Output progress as JSON lines (for automation)
+  --progress plain Heartbeat lines instead of a bar (for CI logs; implies --interactive)
+                   The default "auto" uses plain output when the terminal lacks ANSI support
+  --json-progress  Output progress as JSON lines on stdout (for automation)
+  --progress-file  Append JSON progress lines to a file
+  --progress-url   POST each JSON progress event to a URL
+  These can be combined, e.g. --json-progress with --interactive streams JSON
+  on stdout while the bar is drawn on stderr
   --quiet          Suppress progress output
   --verbose        Show detailed debug output
 
@@ -62,8 +86,21 @@ Repository filtering:
   --repo "slug"        Backup only a single repository (for testing)
   --include "pattern"  Only include repos matching glob pattern
   --exclude "pattern"  Exclude repos matching glob pattern
+  --project "KEY"      Only list and backup repos in this project
+  --repos FILE         Backup exactly the repos listed in FILE, one slug per
+                       line (# comments allowed); "-" reads the list from stdin.
+                       Replaces include/exclude patterns from the config
   Patterns support * and ? wildcards (e.g., "core-*", "test-?-*")
 
+Exit status:
+  Failures exit with a status for their error code, e.g. 3 for AUTH_FAILED
+  and 8 for STORAGE_FULL (see the README). A run that finishes with some
+  repositories failed exits 0 unless --fail-on-repo-error is set (status 10)
+
+Quarantine (see backup.quarantine_after):
+  --unquarantine "slug"  Release a quarantined repo so this run tries it
+  --requarantine "slug"  Put a repo in quarantine, or back in it for longer
+
 Examples:
   bb-backup backup -c config.yaml
   bb-backup backup -w my-workspace -o /backups
@@ -73,6 +110,8 @@ Examples:
   bb-backup backup --git-only              # Fast: just git repos, no API calls per repo
   bb-backup backup --metadata-only         # Slow: just PRs/issues, respects rate limits
   bb-backup backup --repo my-single-repo
+  bb-backup backup --nice                  # Give way to other work while the host is busy
+  missing-repos.sh | bb-backup backup --repos -
   bb-backup backup --exclude "test-*" --exclude "archive-*"
   bb-backup backup --include "core-*" --include "platform-*"`,
 	RunE: runBackup,
@@ -91,43 +130,96 @@ func init() {
 	backupCmd.Flags().StringVar(&appPassword, "app-password", "", "Bitbucket app password")
 	backupCmd.Flags().BoolVar(&jsonProgress, "json-progress", false, "output progress as JSON lines")
 	backupCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "interactive mode with progress bar and ETA")
+	backupCmd.Flags().StringVar(&progressMode, "progress", "auto", "progress renderer: auto, bar, or plain")
+	backupCmd.Flags().StringVar(&progressFile, "progress-file", "", "also append JSON progress events to this file")
+	backupCmd.Flags().StringVar(&progressURL, "progress-url", "", "also POST JSON progress events to this URL")
+	backupCmd.Flags().DurationVar(&progressEvery, "progress-interval", ui.DefaultHeartbeatInterval, "interval between plain progress lines")
 	backupCmd.Flags().StringArrayVar(&excludeRepos, "exclude", nil, "exclude repos matching glob pattern")
 	backupCmd.Flags().StringArrayVar(&includeRepos, "include", nil, "only include repos matching glob pattern")
 	backupCmd.Flags().StringVar(&singleRepo, "repo", "", "backup only a single repository (for testing)")
+	backupCmd.Flags().StringVar(&reposList, "repos", "", "backup exactly the repositories listed in this file, one per line (- for stdin)")
 	backupCmd.Flags().BoolVar(&gitOnly, "git-only", false, "only backup git repositories (skip PRs, issues)")
 	backupCmd.Flags().BoolVar(&metadataOnly, "metadata-only", false, "only backup PRs, issues, metadata (skip git)")
+	backupCmd.Flags().StringArrayVar(&includeProjects, "project", nil, "only backup repos in this project key (repeatable)")
+	backupCmd.Flags().StringArrayVar(&groups, "group", nil, "only backup repos in the named config group (repeatable)")
+	backupCmd.Flags().StringArrayVar(&unquarantine, "unquarantine", nil, "release a quarantined repo so this run tries it (repeatable)")
+	backupCmd.Flags().StringArrayVar(&requarantine, "requarantine", nil, "put a repo in quarantine, or back in it for longer (repeatable)")
+	backupCmd.Flags().StringVar(&rerunID, "rerun", "", "continue an existing run directory by run ID, skipping repos it completed")
+	backupCmd.Flags().BoolVar(&niceMode, "nice", false, "run fewer workers while the system load is high (throttle.nice)")
+	backupCmd.Flags().BoolVar(&failOnRepoError, "fail-on-repo-error", false, "exit with status 10 (REPOS_FAILED) when any repository failed")
 }
 
-func runBackup(_ *cobra.Command, _ []string) error {
+func runBackup(cmd *cobra.Command, _ []string) error {
 	// Validate mutually exclusive flags
 	if gitOnly && metadataOnly {
 		return fmt.Errorf("--git-only and --metadata-only are mutually exclusive")
 	}
+	switch progressMode {
+	case "auto", "bar":
+	case "plain":
+		// Plain heartbeat output is an interactive renderer
+		interactive = true
+	default:
+		return fmt.Errorf("--progress must be auto, bar, or plain, got %q", progressMode)
+	}
+	if len(groups) > 0 && (len(includeRepos) > 0 || singleRepo != "") {
+		return fmt.Errorf("--group cannot be combined with --include or --repo")
+	}
+	var repoList []string
+	if reposList != "" {
+		if len(groups) > 0 || len(includeRepos) > 0 || len(excludeRepos) > 0 || singleRepo != "" {
+			return fmt.Errorf("--repos cannot be combined with --group, --include, --exclude, or --repo")
+		}
+		var err error
+		if repoList, err = readRepoList(reposList, cmd.InOrStdin()); err != nil {
+			return err
+		}
+	}
+	if progressURL != "" {
+		if u, err := url.Parse(progressURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("--progress-url must be an http or https URL, got %q", progressURL)
+		}
+	}
+	if rerunID != "" {
+		if err := backup.ValidateRunID(rerunID); err != nil {
+			return fmt.Errorf("invalid --rerun: %w", err)
+		}
+	}
 
-	// Load configuration
-	cfg, err := loadConfig()
+	// Load the configuration of each workspace to back up
+	cfgs, err := loadWorkspaceConfigs()
 	if err != nil {
 		return err
 	}
+	if rerunID != "" && len(cfgs) > 1 {
+		return errcode.New(errcode.ConfigInvalid, "--rerun needs --workspace when the config lists several workspaces")
+	}
+	if len(cfgs) == 1 {
+		_, err := backupWorkspace(cfgs[0], repoList)
+		return err
+	}
+
+	// Workspaces are backed up one after another; a failed one does not
+	// stop the rest, but an interrupt does
+	var errs []error
+	for _, cfg := range cfgs {
+		canceled, err := backupWorkspace(cfg, repoList)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("workspace %s: %w", cfg.WorkspaceAlias, err))
+		}
+		if canceled {
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
 
+// backupWorkspace runs a backup of one workspace's configuration. It
+// reports whether the run was interrupted.
+func backupWorkspace(cfg *config.Config, repoList []string) (canceled bool, err error) {
 	// Apply CLI overrides
 	applyOverrides(cfg)
 
-	// Set up context with cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Handle interrupt signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigCh
-		if !jsonProgress {
-			fmt.Println("\nReceived interrupt, shutting down gracefully...")
-		}
-		cancel()
-	}()
-
 	// Determine effective log level from CLI flags or config
 	effectiveLevel := cfg.Logging.Level
 	if verbose {
@@ -152,10 +244,15 @@ func runBackup(_ *cobra.Command, _ []string) error {
 		SuppressStderr: interactive, // In interactive mode, don't print errors to stderr (they break the progress bar)
 	})
 	if err != nil {
-		return fmt.Errorf("initializing logger: %w", err)
+		return false, fmt.Errorf("initializing logger: %w", err)
 	}
 	defer func() { _ = log.Close() }()
 
+	injector, err := loadFaults()
+	if err != nil {
+		return false, err
+	}
+
 	// Create and run backup
 	opts := backup.Options{
 		DryRun:       dryRun,
@@ -169,28 +266,147 @@ func runBackup(_ *cobra.Command, _ []string) error {
 		Logger:       log,
 		GitOnly:      gitOnly,
 		MetadataOnly: metadataOnly,
+
+		ProgressMode:     progressMode,
+		ProgressInterval: progressEvery,
+		ProgressFile:     progressFile,
+		ProgressURL:      progressURL,
+		RerunID:          rerunID,
+		Groups:           groups,
+		Repos:            repoList,
+		Unquarantine:     unquarantine,
+		Requarantine:     requarantine,
+		Faults:           injector,
+		FailOnRepoError:  failOnRepoError,
+		Version:          version,
+		Commit:           commit,
 	}
 
 	b, err := backup.New(cfg, opts)
 	if err != nil {
-		return fmt.Errorf("initializing backup: %w", err)
+		err = fmt.Errorf("initializing backup: %w", err)
+		if !dryRun {
+			if nerr := backup.NotifyFailure(cfg, err); nerr != nil {
+				log.Error("Failed to send notifications: %v", nerr)
+			}
+		}
+		return false, err
 	}
 
+	ctx, stop := handleInterrupts(b, jsonProgress)
+	defer stop()
+
 	if err := b.Run(ctx); err != nil {
-		return fmt.Errorf("running backup: %w", err)
+		return ctx.Err() != nil, fmt.Errorf("running backup: %w", err)
 	}
 
-	return nil
+	return ctx.Err() != nil, nil
 }
 
+// handleInterrupts returns a context that is cancelled on the first
+// CTRL-C or SIGTERM, so the run shuts down gracefully within
+// backup.shutdown_timeout_seconds. A second signal aborts the run at once:
+// the state file is saved, the progress display is stopped, and the
+// process exits with status 130.
+func handleInterrupts(b *backup.Backup, quiet bool) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigCh:
+		case <-done:
+			return
+		}
+		if !quiet {
+			fmt.Println("\nReceived interrupt, shutting down gracefully (press CTRL-C again to abort)...")
+		}
+		cancel()
+
+		select {
+		case <-sigCh:
+		case <-done:
+			return
+		}
+		if !quiet {
+			fmt.Println("\nReceived second interrupt, saving state and aborting")
+		}
+		b.Abort()
+		os.Exit(130)
+	}()
+	return ctx, func() {
+		signal.Stop(sigCh)
+		close(done)
+		cancel()
+	}
+}
+
+// readRepoList reads the repositories for --repos from path, or from stdin
+// when path is "-". An empty list is an error: it would otherwise mean
+// every repository.
+func readRepoList(path string, stdin io.Reader) ([]string, error) {
+	var repos []string
+	var err error
+	if path == "-" {
+		repos, err = backup.ReadPatterns(stdin, "stdin")
+	} else {
+		repos, err = backup.LoadPatternFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading --repos: %w", err)
+	}
+	if len(repos) == 0 {
+		name := path
+		if path == "-" {
+			name = "stdin"
+		}
+		return nil, fmt.Errorf("--repos: no repositories listed in %s", name)
+	}
+	return repos, nil
+}
+
+// loadConfig returns the configuration of the workspace to work on: the
+// workspaces entry --workspace names, when the config lists several.
 func loadConfig() (*config.Config, error) {
+	cfg, err := loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err = cfg.ForWorkspace(workspace)
+	if err != nil {
+		return nil, errcode.Wrap(errcode.ConfigInvalid, err)
+	}
+	return cfg, nil
+}
+
+// loadWorkspaceConfigs returns the configuration of each workspace to
+// back up: the one --workspace names, or every entry of workspaces.
+func loadWorkspaceConfigs() ([]*config.Config, error) {
+	if workspace != "" {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		return []*config.Config{cfg}, nil
+	}
+	cfg, err := loadConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.WorkspaceConfigs(), nil
+}
+
+// loadConfigFile loads the config file, or builds a config from flags and
+// environment variables without one.
+func loadConfigFile() (*config.Config, error) {
 	cfgPath := getConfigPath()
 
 	// If we have a config file, load it
 	if cfgPath != "" {
 		cfg, err := config.Load(cfgPath)
 		if err != nil {
-			return nil, fmt.Errorf("loading config from %s: %w", cfgPath, err)
+			return nil, errcode.Wrap(errcode.ConfigInvalid, fmt.Errorf("loading config from %s: %w", cfgPath, err))
 		}
 		return cfg, nil
 	}
@@ -200,7 +416,7 @@ func loadConfig() (*config.Config, error) {
 		workspace = os.Getenv("BITBUCKET_WORKSPACE")
 	}
 	if workspace == "" {
-		return nil, fmt.Errorf("no config file found and --workspace not specified")
+		return nil, errcode.New(errcode.ConfigInvalid, "no config file found and --workspace not specified")
 	}
 
 	// Build minimal config from flags
@@ -228,16 +444,13 @@ func loadConfig() (*config.Config, error) {
 
 	// Validate the assembled config
 	if err := cfg.Validate(); err != nil {
-		return nil, err
+		return nil, errcode.Wrap(errcode.ConfigInvalid, err)
 	}
 
 	return cfg, nil
 }
 
 func applyOverrides(cfg *config.Config) {
-	if workspace != "" {
-		cfg.Workspace = workspace
-	}
 	if outputDir != "" {
 		cfg.Storage.Path = outputDir
 	}
@@ -247,8 +460,12 @@ func applyOverrides(cfg *config.Config) {
 	if appPassword != "" {
 		cfg.Auth.AppPassword = appPassword
 	}
+	if niceMode {
+		cfg.Throttle.Nice = true
+	}
 	if parallel > 0 {
 		cfg.Parallelism.GitWorkers = parallel
+		cfg.Parallelism.AutoTune = false // An explicit --parallel wins over benchmark results
 	}
 
 	// Apply filter overrides
@@ -258,10 +475,14 @@ func applyOverrides(cfg *config.Config) {
 	if len(includeRepos) > 0 {
 		cfg.Backup.IncludeRepos = mergePatterns(cfg.Backup.IncludeRepos, includeRepos)
 	}
+	if len(includeProjects) > 0 {
+		cfg.Backup.IncludeProjects = mergePatterns(cfg.Backup.IncludeProjects, includeProjects)
+	}
 
 	// Single repo override (takes precedence over other filters)
 	if singleRepo != "" {
 		cfg.Backup.IncludeRepos = []string{singleRepo}
+		cfg.Backup.IncludeReposFile = ""
 		cfg.Backup.ExcludeRepos = []string{}
 	}
 }
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadRepoList_Stdin(t *testing.T) {
+	repos, err := readRepoList("-", strings.NewReader("core-api\n\n# from the CMDB diff\nweb-app  # owner: web\n"))
+	if err != nil {
+		t.Fatalf("readRepoList() error = %v", err)
+	}
+	if strings.Join(repos, ",") != "core-api,web-app" {
+		t.Errorf("readRepoList() = %v", repos)
+	}
+}
+
+func TestReadRepoList_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repos.txt")
+	os.WriteFile(path, []byte("core-api\n"), 0644)
+	repos, err := readRepoList(path, strings.NewReader("ignored\n"))
+	if err != nil {
+		t.Fatalf("readRepoList() error = %v", err)
+	}
+	if len(repos) != 1 || repos[0] != "core-api" {
+		t.Errorf("readRepoList() = %v", repos)
+	}
+}
+
+func TestReadRepoList_Empty(t *testing.T) {
+	// An empty list must not fall through to backing up everything
+	_, err := readRepoList("-", strings.NewReader("\n# nothing to do\n"))
+	if err == nil || !strings.Contains(err.Error(), "no repositories") {
+		t.Errorf("expected an error for an empty list, got %v", err)
+	}
+}
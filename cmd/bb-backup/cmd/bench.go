@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/bench"
+	"github.com/andy-wilson/bb-backup/internal/git"
+	"github.com/andy-wilson/bb-backup/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchSample  int
+	benchWorkers []int
+	benchEngines []string
+	benchWorkDir string
+	benchJSON    bool
+	benchNoSave  bool
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Benchmark clone throughput and recommend settings",
+	Long: `Clone a sample of repositories with different worker counts and git
+engines, measure throughput and API latency, and recommend parallelism and
+rate-limit settings for this environment.
+
+The sample is spread across the workspace's repository sizes. Clones go to a
+scratch directory that is removed after each trial; nothing is written to
+the backup tree except the results file (` + bench.FileName + `), which
+backups use when parallelism.auto_tune is enabled.
+
+Each trial makes real clones, so a benchmark uses bandwidth and API quota
+roughly equal to (sample size x worker counts x engines) clones.
+
+Examples:
+  bb-backup bench -c config.yaml
+  bb-backup bench --sample 8 --workers 2,4,8,16 --engines cli
+  bb-backup bench --json --no-save`,
+	RunE: runBench,
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+
+	benchCmd.Flags().IntVar(&benchSample, "sample", 5, "number of repositories to clone per trial")
+	benchCmd.Flags().IntSliceVar(&benchWorkers, "workers", []int{1, 2, 4, 8}, "worker counts to try")
+	benchCmd.Flags().StringSliceVar(&benchEngines, "engines", []string{"gogit", "cli"}, "git engines to try (gogit, cli)")
+	benchCmd.Flags().StringVar(&benchWorkDir, "workdir", "", "scratch directory for clones (default: system temp)")
+	benchCmd.Flags().BoolVar(&benchJSON, "json", false, "output results as JSON")
+	benchCmd.Flags().BoolVar(&benchNoSave, "no-save", false, "do not store results for parallelism.auto_tune")
+	benchCmd.Flags().StringVar(&username, "username", "", "Bitbucket username")
+	benchCmd.Flags().StringVar(&appPassword, "app-password", "", "Bitbucket app password")
+	benchCmd.Flags().StringVarP(&outputDir, "output", "o", "", "backup directory where results are stored (overrides config)")
+}
+
+func runBench(_ *cobra.Command, _ []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	applyOverrides(cfg)
+
+	effectiveLevel := cfg.Logging.Level
+	if verbose {
+		effectiveLevel = "debug"
+	} else if quiet {
+		effectiveLevel = "error"
+	}
+	log, err := logging.New(logging.Config{
+		Level:   effectiveLevel,
+		Format:  cfg.Logging.Format,
+		File:    cfg.Logging.File,
+		Console: cfg.Logging.File != "",
+	})
+	if err != nil {
+		return fmt.Errorf("initializing logger: %w", err)
+	}
+	defer func() { _ = log.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	authProvider := auth.FromConfig(cfg)
+	gitCreds := auth.GitCredentialFunc(authProvider)
+	cloners := make(map[string]bench.Cloner)
+	for _, engine := range benchEngines {
+		switch engine {
+		case "gogit":
+			cloners[engine] = git.NewGoGitClient(
+				git.WithCredentialFunc(gitCreds),
+				git.WithLogger(log.Debug),
+				git.WithSkipSizeCalc(),
+			).CloneMirror
+		case "cli":
+			if !git.IsGitCLIAvailable() {
+				log.Info("Skipping engine cli: git CLI not available")
+				continue
+			}
+			cloners[engine] = git.NewShellGitClient(
+				git.WithShellCredentialFunc(gitCreds),
+				git.WithShellLogger(log.Debug),
+			).CloneMirror
+		default:
+			return fmt.Errorf("unknown engine %q (want gogit or cli)", engine)
+		}
+	}
+	if len(cloners) == 0 {
+		return fmt.Errorf("no git engines available to benchmark")
+	}
+	for engine, clone := range cloners {
+		cloners[engine] = func(ctx context.Context, repoURL, destPath string) error {
+			return clone(ctx, cfg.Git.CloneURL(repoURL), destPath)
+		}
+	}
+
+	workDir := benchWorkDir
+	if workDir == "" {
+		workDir, err = os.MkdirTemp("", "bb-backup-bench-")
+		if err != nil {
+			return fmt.Errorf("creating scratch directory: %w", err)
+		}
+		defer func() { _ = os.RemoveAll(workDir) }()
+	}
+
+	client := api.NewClient(cfg, api.WithAuthProvider(authProvider), api.WithLogFunc(log.Debug))
+	log.Info("Fetching repositories for %s...", cfg.Workspace)
+	includePatterns, err := backup.IncludePatterns(cfg)
+	if err != nil {
+		return err
+	}
+	filter := backup.NewRepoFilter(includePatterns, cfg.Backup.ExcludeRepos)
+	allRepos, err := backup.ListRepositories(ctx, client, cfg, filter)
+	if err != nil {
+		return fmt.Errorf("fetching repositories: %w", err)
+	}
+	repos := filter.Filter(allRepos)
+
+	result, err := bench.Run(ctx, client, cfg.Workspace, repos, bench.Options{
+		Sample:  benchSample,
+		Workers: benchWorkers,
+		Cloners: cloners,
+		WorkDir: workDir,
+		Logf:    log.Info,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !benchNoSave {
+		path := filepath.Join(cfg.Storage.Path, bench.FileName)
+		if err := os.MkdirAll(cfg.Storage.Path, 0755); err != nil {
+			return fmt.Errorf("creating storage directory: %w", err)
+		}
+		if err := bench.Save(path, result); err != nil {
+			return err
+		}
+		log.Info("Results saved to %s", path)
+	}
+
+	if benchJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	printBenchResult(result)
+	return nil
+}
+
+func printBenchResult(result *bench.Result) {
+	fmt.Printf("Sample: %d repositories\n", len(result.Sample))
+	fmt.Printf("API latency: avg %.0f ms, p95 %.0f ms", result.API.AvgMillis, result.API.P95Millis)
+	if result.API.RateLimitCeiling > 0 {
+		fmt.Printf(" (limit %d/hour)", result.API.RateLimitCeiling)
+	}
+	fmt.Println()
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENGINE\tWORKERS\tSECONDS\tMB/S\tREPOS/MIN\tFAILURES")
+	for _, t := range result.Trials {
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%.2f\t%.1f\t%d\n",
+			t.Engine, t.Workers, t.Seconds, t.MBPerSec, t.ReposPerMin, t.Failures)
+	}
+	_ = w.Flush()
+
+	rec := result.Recommendation
+	fmt.Println()
+	if rec.GitEngine == "" {
+		fmt.Println("No trial completed without failures; no parallelism recommendation.")
+	} else {
+		fmt.Println("Recommended settings:")
+		fmt.Printf("  parallelism:\n    git_workers: %d\n", rec.GitWorkers)
+		fmt.Printf("  git:\n    engine: %q\n", rec.GitEngine)
+	}
+	fmt.Printf("  rate_limit:\n    requests_per_hour: %d\n", rec.RequestsPerHour)
+	if rec.BurstSize > 0 {
+		fmt.Printf("    burst_size: %d\n", rec.BurstSize)
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/andy-wilson/bb-backup/internal/browse"
+	"github.com/andy-wilson/bb-backup/internal/ui"
+	"github.com/spf13/cobra"
+)
+
+var browseCmd = &cobra.Command{
+	Use:   "browse [workspace-backup-path]",
+	Short: "Interactively browse projects, repositories, PRs, and issues in a backup",
+	Long: `Open a terminal browser over the JSON metadata in the latest backup.
+
+Navigate from projects to repositories to pull requests and issues, with a
+preview pane beside the list on terminals at least 80 columns wide. Press
+enter to open the full detail view of a PR or issue, including comments.
+Everything is read from disk, so this works when Bitbucket is unreachable.
+
+Keys:
+  up/down, j/k     move
+  enter, l         open
+  esc, h           back (esc first clears an active search)
+  /                search the current list
+  g/G, home/end    first/last row
+  q, ctrl-c        quit
+
+Examples:
+  bb-backup browse /backups/my-workspace`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBrowse,
+}
+
+func init() {
+	rootCmd.AddCommand(browseCmd)
+}
+
+func runBrowse(_ *cobra.Command, args []string) error {
+	if !ui.SupportsANSI(os.Stdout) || !ui.SupportsANSI(os.Stdin) {
+		return fmt.Errorf("browse requires an interactive terminal")
+	}
+
+	idx, err := browse.Load(args[0])
+	if err != nil {
+		return err
+	}
+	return browse.Run(idx, os.Stdin, os.Stdout)
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deltaOutput string
+	deltaRepos  []string
+	deltaJSON   bool
+)
+
+var bundleDeltaCmd = &cobra.Command{
+	Use:   "bundle-delta <prev-run> <curr-run> --output DIR",
+	Short: "Write what changed between two runs as git bundles and tarballs",
+	Long: `Write what changed between two backup runs to a directory, for shipping
+offsite each night without re-syncing whole mirrors.
+
+For each repository the newer run backed up, the output holds:
+  projects/<key>/<slug>.bundle               Commits new since the older run,
+                                             as an incremental git bundle
+  projects/<key>/<slug>.metadata.tar.gz      The newer run's metadata files
+                                             that are new or changed
+Personal repositories are under personal/<owner>/<slug>. Run-level files that
+changed (manifest, report, workspace and project metadata) are in
+metadata.tar.gz, and delta.json lists everything written together with
+each repository's ref updates, including deletions, which a bundle cannot
+carry.
+
+Runs are given by run ID, as "current", or as paths to run directories.
+Bundles are cut from the mirrors in latest/ using the refs each run
+recorded in refs.json; a repository without refs.json in the older run
+gets a bundle of its full history. The git CLI is required.
+
+Apply a bundle to the offsite mirror with:
+  git -C repo.git fetch /path/to/slug.bundle '+refs/*:refs/*'
+
+Exit codes:
+  0 - The delta was written for every repository
+  1 - One or more repositories failed, or the delta could not be written
+
+Examples:
+  bb-backup bundle-delta 2024-01-15T02-00-00Z-9b07d3e1 current --output /ship/2024-01-16
+  bb-backup bundle-delta /backups/ws/RUN1 /backups/ws/RUN2 -o ./delta --json`,
+	Args: cobra.ExactArgs(2),
+	RunE: runBundleDelta,
+}
+
+func init() {
+	rootCmd.AddCommand(bundleDeltaCmd)
+
+	bundleDeltaCmd.Flags().StringVarP(&deltaOutput, "output", "o", "", "directory to write the delta into (must be new or empty)")
+	bundleDeltaCmd.Flags().StringSliceVar(&deltaRepos, "repo", nil, "repository slug glob to include (repeatable; default: all)")
+	bundleDeltaCmd.Flags().BoolVar(&deltaJSON, "json", false, "output delta.json to stdout")
+	_ = bundleDeltaCmd.MarkFlagRequired("output")
+}
+
+func runBundleDelta(_ *cobra.Command, args []string) error {
+	var workspaceDir string
+	if !isRunPath(args[0]) || !isRunPath(args[1]) {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		workspaceDir = filepath.Join(cfg.Storage.Path, cfg.Workspace)
+	}
+	fromDir, err := resolveRunDir(workspaceDir, args[0])
+	if err != nil {
+		return err
+	}
+	toDir, err := resolveRunDir(workspaceDir, args[1])
+	if err != nil {
+		return err
+	}
+	if workspaceDir == "" {
+		workspaceDir = filepath.Dir(toDir)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	delta, err := backup.BundleDelta(ctx, workspaceDir, fromDir, toDir, backup.DeltaOptions{
+		Repos:     deltaRepos,
+		OutputDir: deltaOutput,
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range delta.Repositories {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if deltaJSON {
+		if err := writeJSON(delta); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range delta.Repositories {
+			switch {
+			case r.Error != "":
+				fmt.Printf("  ✗ %s: %s\n", r.Slug, r.Error)
+			case r.Bundle == "" && r.Metadata == "":
+				fmt.Printf("  = %s: unchanged\n", r.Slug)
+			default:
+				what := []string{}
+				if r.Bundle != "" {
+					kind := "bundle"
+					if r.Full {
+						kind = "full bundle"
+					}
+					what = append(what, fmt.Sprintf("%s, %d ref updates", kind, len(r.RefUpdates)))
+				}
+				if r.Metadata != "" {
+					what = append(what, fmt.Sprintf("%d metadata files", r.MetadataFiles))
+				}
+				fmt.Printf("  ✓ %s: %s (%s)\n", r.Slug, strings.Join(what, ", "), format.Bytes(r.Bytes))
+			}
+		}
+		fmt.Printf("\nWrote the delta from %s to %s for %d repositories to %s (%s)\n",
+			delta.From, delta.To, len(delta.Repositories), deltaOutput, format.Bytes(delta.Bytes))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d repositories could not be bundled", failed)
+	}
+	return nil
+}
+
+// isRunPath reports whether arg names a run directory by path rather than
+// by run ID.
+func isRunPath(arg string) bool {
+	return strings.ContainsRune(arg, os.PathSeparator) || strings.ContainsRune(arg, '/')
+}
+
+// resolveRunDir returns the directory of a run given by path, run ID, or
+// "current".
+func resolveRunDir(workspaceDir, arg string) (string, error) {
+	dir := arg
+	if !isRunPath(arg) {
+		if arg != backup.CurrentLinkName {
+			if err := backup.ValidateRunID(arg); err != nil {
+				return "", fmt.Errorf("invalid run %q: %w", arg, err)
+			}
+		}
+		dir = filepath.Join(workspaceDir, arg)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("locating run %s: %w", arg, err)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%s is not a run directory", arg)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+// checkAuthPreRun runs the --check-auth credential check in place of any
+// command: it exits 0 when every set of credentials works and returns an
+// error, so the command exits 1, when one does not.
+func checkAuthPreRun(_ *cobra.Command, _ []string) error {
+	if !checkAuth {
+		return nil
+	}
+	if err := runCheckAuth(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// runCheckAuth checks the API and git access of the top-level credentials
+// and each credentials set, printing one line per set.
+func runCheckAuth() error {
+	cfg, err := loadListConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	failed := 0
+	for _, check := range backup.CheckAuth(ctx, cfg) {
+		if !check.OK() {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: FAILED: %s\n", check.Credentials, check.Error)
+			continue
+		}
+		if quiet {
+			continue
+		}
+		switch {
+		case check.Git:
+			fmt.Printf("%s: API ok, git ok (%s)\n", check.Credentials, check.Repository)
+		default:
+			fmt.Printf("%s: API ok, git not checked (no repositories visible)\n", check.Credentials)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("credential check failed for %d of the configured credentials", failed)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportWorktree bool
+	exportRef      string
+	exportRepos    []string
+	exportOutput   string
+	exportTarball  string
+	exportJSON     bool
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [workspace-backup-path] --worktree (--output DIR | --tarball FILE)",
+	Short: "Export plain source trees from the backed-up mirrors",
+	Long: `Export checked-out source trees of repositories from the git mirrors in
+the latest backup, for consumers that need plain files rather than bare
+repositories.
+
+Trees are laid out as projects/<key>/<slug> and personal/<owner>/<slug>, either in
+a directory (--output, which must not already contain them) or in a single
+tar file (--tarball, gzipped when the name ends in .gz or .tgz). Submodules
+are not included. Repositories without the requested ref are reported and
+skipped.
+
+The backup path defaults to the workspace directory under storage.path.
+
+Exit codes:
+  0 - Every selected repository was exported
+  1 - One or more repositories could not be exported
+
+Examples:
+  bb-backup export --worktree --ref main --output /restore/src
+  bb-backup export --worktree --ref v2.0 --repo 'api-*' --tarball api.tar.gz
+  bb-backup export /backups/my-workspace --worktree --output ./trees --json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runExport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().BoolVar(&exportWorktree, "worktree", false, "export checked-out source trees")
+	exportCmd.Flags().StringVar(&exportRef, "ref", "", "branch, tag, or commit to export (default: each repository's default branch)")
+	exportCmd.Flags().StringSliceVar(&exportRepos, "repo", nil, "repository slug glob to export (repeatable; default: all)")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "directory to export into")
+	exportCmd.Flags().StringVar(&exportTarball, "tarball", "", "tar file to export into instead of a directory")
+	exportCmd.Flags().BoolVar(&exportJSON, "json", false, "output results as JSON")
+}
+
+func runExport(_ *cobra.Command, args []string) error {
+	if !exportWorktree {
+		return fmt.Errorf("nothing to export; pass --worktree")
+	}
+	if (exportOutput == "") == (exportTarball == "") {
+		return fmt.Errorf("pass exactly one of --output or --tarball")
+	}
+
+	var workspaceDir string
+	if len(args) == 1 {
+		workspaceDir = args[0]
+	} else {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		workspaceDir = filepath.Join(cfg.Storage.Path, cfg.Workspace)
+	}
+
+	results, err := backup.ExportWorktrees(workspaceDir, backup.WorktreeExportOptions{
+		Ref:       exportRef,
+		Repos:     exportRepos,
+		OutputDir: exportOutput,
+		Tarball:   exportTarball,
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+	if exportJSON {
+		if err := writeJSON(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Printf("  ✗ %s: %s\n", r.Slug, r.Error)
+				continue
+			}
+			fmt.Printf("  ✓ %s at %.12s -> %s\n", r.Slug, r.Commit, r.Path)
+		}
+		dest := exportOutput
+		if dest == "" {
+			dest = exportTarball
+		}
+		fmt.Printf("\nExported %d of %d repositories to %s\n", len(results)-failed, len(results), dest)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d repositories could not be exported", failed)
+	}
+	return nil
+}
@@ -20,6 +20,8 @@ var (
 	listJSON         bool
 	listExcludeRepos []string
 	listIncludeRepos []string
+	listProjects     []string
+	listGroups       []string
 )
 
 var listCmd = &cobra.Command{
@@ -37,6 +39,7 @@ Output formats:
 Repository filtering:
   --include "pattern"  Only include repos matching glob pattern
   --exclude "pattern"  Exclude repos matching glob pattern
+  --project "KEY"      Only list repos in this project
   Patterns support * and ? wildcards (e.g., "core-*", "test-?-*")
 
 Examples:
@@ -57,6 +60,8 @@ func init() {
 	listCmd.Flags().BoolVar(&listJSON, "json", false, "output as JSON")
 	listCmd.Flags().StringArrayVar(&listExcludeRepos, "exclude", nil, "exclude repos matching glob pattern")
 	listCmd.Flags().StringArrayVar(&listIncludeRepos, "include", nil, "only include repos matching glob pattern")
+	listCmd.Flags().StringArrayVar(&listProjects, "project", nil, "only list repos in this project key (repeatable)")
+	listCmd.Flags().StringArrayVar(&listGroups, "group", nil, "only list repos in the named config group (repeatable)")
 }
 
 // ListOutput represents the JSON output for the list command.
@@ -85,6 +90,10 @@ type RepositoryOutput struct {
 }
 
 func runList(_ *cobra.Command, _ []string) error {
+	if len(listGroups) > 0 && len(listIncludeRepos) > 0 {
+		return fmt.Errorf("--group and --include are mutually exclusive")
+	}
+
 	// Load configuration
 	cfg, err := loadListConfig()
 	if err != nil {
@@ -98,6 +107,18 @@ func runList(_ *cobra.Command, _ []string) error {
 	if len(listIncludeRepos) > 0 {
 		cfg.Backup.IncludeRepos = mergePatterns(cfg.Backup.IncludeRepos, listIncludeRepos)
 	}
+	if len(listProjects) > 0 {
+		cfg.Backup.IncludeProjects = mergePatterns(cfg.Backup.IncludeProjects, listProjects)
+	}
+	if len(listGroups) > 0 {
+		// Group patterns replace the configured includes, as in backup
+		patterns, err := cfg.GroupPatterns(listGroups)
+		if err != nil {
+			return err
+		}
+		cfg.Backup.IncludeRepos = patterns
+		cfg.Backup.IncludeReposFile = ""
+	}
 
 	// Determine effective log level from CLI flags or config
 	effectiveLevel := cfg.Logging.Level
@@ -168,6 +189,11 @@ func runList(_ *cobra.Command, _ []string) error {
 		}))
 	}
 	client := api.NewClient(cfg, clientOpts...)
+	if cfg.RateLimit.SharedStateFile != "" {
+		if err := client.RateLimiter().UseSharedBucket(cfg.RateLimit.SharedStateFile); err != nil {
+			return fmt.Errorf("enabling shared rate limit: %w", err)
+		}
+	}
 
 	if !listJSON && !showSpinner {
 		log.Info("Fetching workspace data for %s...", cfg.Workspace)
@@ -180,8 +206,11 @@ func runList(_ *cobra.Command, _ []string) error {
 	if log.IsDebug() && !listJSON {
 		log.Debug("Fetching projects...")
 	}
+	// Project and repository access tokens can't list projects; they are
+	// taken from the repositories instead
 	projects, err := client.GetProjects(ctx, cfg.Workspace)
-	if err != nil {
+	projectsFromRepos := backup.OutOfTokenScope(cfg, err)
+	if err != nil && !projectsFromRepos {
 		stopSpinner()
 		return fmt.Errorf("fetching projects: %w", err)
 	}
@@ -196,7 +225,14 @@ func runList(_ *cobra.Command, _ []string) error {
 	if log.IsDebug() && !listJSON {
 		log.Debug("Fetching repositories (this may take a while)...")
 	}
-	allRepos, err := client.GetRepositories(ctx, cfg.Workspace)
+	includePatterns, err := backup.IncludePatterns(cfg)
+	if err != nil {
+		stopSpinner()
+		return err
+	}
+	filter := backup.NewRepoFilter(includePatterns, cfg.Backup.ExcludeRepos)
+	projects, _ = backup.FilterProjects(projects, cfg.Backup.IncludeProjects)
+	allRepos, err := backup.ListRepositories(ctx, client, cfg, filter)
 	if err != nil {
 		stopSpinner()
 		return fmt.Errorf("fetching repositories: %w", err)
@@ -204,12 +240,14 @@ func runList(_ *cobra.Command, _ []string) error {
 	if log.IsDebug() && !listJSON {
 		log.Debug("Found %d repositories", len(allRepos))
 	}
+	if projectsFromRepos {
+		projects, _ = backup.FilterProjects(backup.ProjectsFromRepos(allRepos), cfg.Backup.IncludeProjects)
+	}
 
 	// Stop spinner before output
 	stopSpinner()
 
 	// Apply filters
-	filter := backup.NewRepoFilter(cfg.Backup.IncludeRepos, cfg.Backup.ExcludeRepos)
 	repos := filter.Filter(allRepos)
 	filteredOut := len(allRepos) - len(repos)
 
@@ -316,8 +354,8 @@ func loadListConfig() (*config.Config, error) {
 			return nil, fmt.Errorf("loading config from %s: %w", cfgPath, err)
 		}
 		// Apply workspace override if specified
-		if workspace != "" {
-			cfg.Workspace = workspace
+		if cfg, err = cfg.ForWorkspace(workspace); err != nil {
+			return nil, err
 		}
 		// Apply auth overrides
 		if username != "" {
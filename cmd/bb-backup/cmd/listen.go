@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/logging"
+	"github.com/andy-wilson/bb-backup/internal/webhook"
+	"github.com/spf13/cobra"
+)
+
+var listenAddr string
+
+var listenCmd = &cobra.Command{
+	Use:   "listen",
+	Short: "Back up repositories as Bitbucket webhooks report changes",
+	Long: `Run an HTTP receiver for Bitbucket webhooks and back up each repository
+they report a change to within minutes, instead of waiting for the next
+scheduled run.
+
+Point a workspace (or repository) webhook at http://HOST:PORT/webhook with
+these triggers:
+  Repository push                     Backs up the repository's git refs
+  Pull request created/updated/...    Backs up its pull requests and issues
+  Issue created/updated/...           (all metadata of the repository)
+On Data Center and Server, "Repository: push" and the pull request events
+are recognized the same way. Other events are acknowledged and ignored.
+
+Set a secret on the webhook and the same value as listen.secret, and
+deliveries not signed with it (X-Hub-Signature) are refused. Without a
+secret anyone who can reach the port can trigger backups.
+
+Changes arriving within listen.delay_seconds (default 30) of the first
+one are backed up together, one incremental run per kind of change with
+the repositories as --repos, so a burst of pushes costs one run. Runs
+never overlap; changes that arrive during one wait for the next. Each run
+writes its own run directory and report like any backup. A failed run is
+logged and the repository is tried again at its next change; scheduled
+backups and retry still cover anything missed while the receiver
+was down.
+
+With several workspaces in the config, each has its own receiver at
+listen.path followed by its alias, e.g. /webhook/engineering, and its
+runs go to its own output root. Workspaces back up independently of one
+another; --workspace serves just one, at listen.path itself.
+
+Examples:
+  bb-backup listen -c config.yaml
+  bb-backup listen -c config.yaml --addr 127.0.0.1:9000`,
+	Args: cobra.NoArgs,
+	RunE: runListen,
+}
+
+func init() {
+	rootCmd.AddCommand(listenCmd)
+
+	listenCmd.Flags().StringVar(&listenAddr, "addr", "", "host:port to receive webhooks on (overrides listen.addr)")
+}
+
+func runListen(_ *cobra.Command, _ []string) error {
+	cfgs, err := loadWorkspaceConfigs()
+	if err != nil {
+		return err
+	}
+	for _, cfg := range cfgs {
+		applyOverrides(cfg)
+	}
+	// Everything but the workspace sections is shared
+	cfg := cfgs[0]
+	if listenAddr != "" {
+		cfg.Listen.Addr = listenAddr
+	}
+
+	effectiveLevel := cfg.Logging.Level
+	if verbose {
+		effectiveLevel = "debug"
+	} else if quiet {
+		effectiveLevel = "error"
+	}
+	log, err := logging.New(logging.Config{
+		Level:   effectiveLevel,
+		Format:  cfg.Logging.Format,
+		File:    cfg.Logging.File,
+		Console: cfg.Logging.File != "",
+	})
+	if err != nil {
+		return fmt.Errorf("initializing logger: %w", err)
+	}
+	defer func() { _ = log.Close() }()
+
+	mux := http.NewServeMux()
+	receivers := make([]*webhook.Receiver, 0, len(cfgs))
+	for _, wcfg := range cfgs {
+		receiver := newListenReceiver(wcfg, log)
+		receivers = append(receivers, receiver)
+		if len(cfgs) == 1 {
+			mux.Handle(cfg.Listen.Path, receiver)
+			continue
+		}
+		p := path.Join(cfg.Listen.Path, wcfg.WorkspaceAlias)
+		mux.Handle(p, receiver)
+		log.Info("Webhooks for %s at %s", wcfg.Workspace, p)
+	}
+	if cfg.Listen.Secret == "" {
+		log.Warn("listen.secret is not set: webhook deliveries are not authenticated")
+	}
+
+	ln, err := net.Listen("tcp", cfg.Listen.Addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", cfg.Listen.Addr, err)
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	log.Info("Receiving webhooks at http://%s%s", ln.Addr(), cfg.Listen.Path)
+
+	runErr := make(chan error, len(receivers))
+	for _, receiver := range receivers {
+		go func(r *webhook.Receiver) { runErr <- r.Run(ctx) }(receiver)
+	}
+
+	select {
+	case err = <-serveErr:
+		stop()
+		for range receivers {
+			<-runErr
+		}
+		return fmt.Errorf("webhook receiver stopped: %w", err)
+	case <-ctx.Done():
+	}
+	log.Info("Shutting down webhook receiver")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+	var errs []error
+	for range receivers {
+		if err := <-runErr; err != nil && !errors.Is(err, context.Canceled) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newListenReceiver returns the webhook receiver for one workspace's
+// configuration.
+func newListenReceiver(cfg *config.Config, log *logging.Logger) *webhook.Receiver {
+	workspace := cfg.Workspace
+	if cfg.API.Type == config.APITypeServer {
+		// Data Center payloads name the project, not the workspace
+		workspace = ""
+	}
+	return webhook.NewReceiver(webhook.Options{
+		Workspace: workspace,
+		Secret:    cfg.Listen.Secret,
+		Delay:     time.Duration(cfg.Listen.DelaySeconds) * time.Second,
+		Backup: func(ctx context.Context, batch webhook.Batch) error {
+			return runListenBatch(ctx, cfg, log, batch)
+		},
+		Log: log.Info,
+	})
+}
+
+// runListenBatch backs up a batch of repositories reported by webhooks.
+func runListenBatch(ctx context.Context, cfg *config.Config, log *logging.Logger, batch webhook.Batch) error {
+	opts := backup.Options{
+		Verbose:      log.IsDebug(),
+		Quiet:        log.IsQuiet(),
+		Logger:       log,
+		GitOnly:      batch.Git && !batch.Metadata,
+		MetadataOnly: batch.Metadata && !batch.Git,
+		Repos:        batch.Repos,
+		Version:      version,
+		Commit:       commit,
+	}
+	b, err := backup.New(cfg, opts)
+	if err != nil {
+		return fmt.Errorf("initializing backup: %w", err)
+	}
+	return b.Run(ctx)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+var orphansJSON bool
+
+var orphansCmd = &cobra.Command{
+	Use:   "orphans [workspace-backup-path]",
+	Short: "Report content authored by users no longer in the workspace",
+	Long: `Report pull requests, issues, and comments in the latest backup whose
+author is not in the workspace member list captured with it.
+
+Use this when preparing exports or during offboarding audits to see which
+content belongs to former or deactivated members. The member list is saved
+as members.json by each backup run, so the report reflects membership at
+the time of the most recent backup.
+
+Examples:
+  bb-backup orphans /backups/my-workspace
+  bb-backup orphans /backups/my-workspace --json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runOrphans,
+}
+
+func init() {
+	rootCmd.AddCommand(orphansCmd)
+
+	orphansCmd.Flags().BoolVar(&orphansJSON, "json", false, "output as JSON")
+}
+
+func runOrphans(_ *cobra.Command, args []string) error {
+	report, err := backup.FindOrphanedAuthors(args[0])
+	if err != nil {
+		return err
+	}
+
+	if orphansJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Printf("Workspace: %s (%d current members)\n\n", report.Workspace, report.MemberCount)
+	if len(report.Authors) == 0 {
+		fmt.Println("No content authored by former members.")
+		return nil
+	}
+
+	fmt.Printf("Former members (%d):\n", len(report.Authors))
+	for _, a := range report.Authors {
+		name := a.DisplayName
+		if name == "" {
+			name = "(unknown user)"
+		}
+		if a.UUID != "" {
+			name += " " + a.UUID
+		}
+		fmt.Printf("  %s\n", name)
+		fmt.Printf("      %d pull requests, %d issues, %d comments\n", a.PullRequests, a.Issues, a.Comments)
+		fmt.Printf("      repositories: %s\n", strings.Join(a.Repositories, ", "))
+	}
+	fmt.Printf("\nTotal: %d items authored by former members\n", report.Total)
+	return nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/andy-wilson/bb-backup/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneJSON    bool
+	pruneDryRun  bool
+	pruneExplain bool
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete backup runs past their retention",
+	Long: `Delete expired data from the workspace's run directories according to
+the retention section of the config.
+
+Each repository's directory in a run is kept for the keep_days of its
+retention class, taken from backup.custom_metadata_file, or for
+retention.keep_days if it has none. A run whose repositories have all
+expired is removed entirely once it is older than retention.keep_days.
+
+retention.keep_runs rotates run directories by count instead. Git mirrors
+live only in latest/, so a run holds just that run's metadata; runs older
+than the newest keep_runs lose every repository without a retention
+class, whatever their age, and are removed once empty. latest/, the
+newest run, and the run current points at are never pruned.
+
+With policy.repository set, the retention in the policy file the last
+backup read (backup-policy.yaml in the workspace directory) applies over
+the config's.
+
+Every deletion is appended to prune-audit.ndjson in the workspace
+directory, with the rule that expired it.
+
+--dry-run works out the same deletions without making them or writing
+the audit log, to check a retention policy before enabling it. --explain
+lists each run or repository directory with its size and the rule that
+expired it.
+
+Examples:
+  bb-backup prune -c config.yaml
+  bb-backup prune -c config.yaml --dry-run --explain
+  bb-backup prune -c config.yaml --json`,
+	Args: cobra.NoArgs,
+	RunE: runPrune,
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().BoolVar(&pruneJSON, "json", false, "output as JSON")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "show what would be deleted without deleting it")
+	pruneCmd.Flags().BoolVar(&pruneExplain, "explain", false, "list each deletion with the rule that expired it")
+}
+
+func runPrune(_ *cobra.Command, _ []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Policy.Repository == "" && cfg.Retention.KeepDays == 0 && cfg.Retention.KeepRuns == 0 && len(cfg.Retention.Classes) == 0 {
+		return fmt.Errorf("no retention configured; set retention.keep_days, retention.keep_runs, or retention.classes in the config")
+	}
+
+	effectiveLevel := cfg.Logging.Level
+	if verbose {
+		effectiveLevel = "debug"
+	} else if quiet || pruneJSON {
+		effectiveLevel = "error"
+	}
+	log, err := logging.New(logging.Config{
+		Level:   effectiveLevel,
+		Format:  cfg.Logging.Format,
+		File:    cfg.Logging.File,
+		Console: cfg.Logging.File != "",
+	})
+	if err != nil {
+		return fmt.Errorf("initializing logger: %w", err)
+	}
+	defer func() { _ = log.Close() }()
+
+	result, err := backup.Prune(cfg, backup.PruneOptions{Log: log, DryRun: pruneDryRun})
+	if err != nil {
+		return err
+	}
+
+	if pruneJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	if len(result.Actions) == 0 {
+		fmt.Println("Nothing to prune.")
+		return nil
+	}
+	if pruneExplain {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PATH\tSIZE\tREASON\t")
+		for _, a := range result.Actions {
+			path := a.Path
+			if a.Repository == "" {
+				path += " (whole run)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", path, format.Bytes(a.Bytes), a.Reason())
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	if result.DryRun {
+		fmt.Printf("Would prune %d runs and %d repository directories from other runs, freeing %s\n",
+			result.Runs, result.Repositories, format.Bytes(result.Bytes))
+		fmt.Println("Dry run: nothing was deleted.")
+		return nil
+	}
+	fmt.Printf("Pruned %d runs and %d repository directories from other runs, freeing %s\n",
+		result.Runs, result.Repositories, format.Bytes(result.Bytes))
+	fmt.Printf("Audit log: %s\n", backup.PruneAuditFileName)
+	return nil
+}
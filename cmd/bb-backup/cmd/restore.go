@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+var (
+	restorePlanOnly   bool
+	restoreOnConflict string
+	restorePolicies   map[string]string
+	restoreNewSlugs   map[string]string
+	restorePrefix     string
+	restoreRepos      []string
+	restoreJSON       bool
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [workspace-backup-path]",
+	Short: "Push the backed-up mirrors back to the workspace",
+	Long: `Restore the git mirrors in the latest backup to the configured workspace.
+Each repository's branches and tags are pushed; pull requests, issues, and
+wikis are not restored.
+
+Repositories missing from the workspace are created at their slug, in their
+project. A repository that still exists is a collision, handled by a policy:
+
+  skip            leave the live repository alone (the default)
+  overwrite-refs  make its branches and tags match the backup, deleting
+                  branches and tags created since
+  new-slug        create a repository at the slug given with --new-slug
+  prefix          create a repository at the slug with --prefix before it
+  ask             ask for each collision before restoring
+
+--on-conflict sets the policy for every collision, --policy overrides it for
+one repository, and --new-slug implies new-slug for its repository. The plan
+is shown before anything is pushed, with how many refs an overwrite would
+create, update, and delete; --plan shows it and stops.
+
+The backup path defaults to the workspace directory under storage.path.
+
+Exit codes:
+  0 - Every planned repository was restored
+  1 - One or more repositories could not be restored
+
+Examples:
+  bb-backup restore --plan
+  bb-backup restore --on-conflict prefix --prefix recovered-
+  bb-backup restore --on-conflict ask
+  bb-backup restore --repo 'core-*' --policy core-api=overwrite-refs --new-slug core-web=core-web-2024`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().BoolVar(&restorePlanOnly, "plan", false, "show what would be restored and stop")
+	restoreCmd.Flags().StringVar(&restoreOnConflict, "on-conflict", backup.RestoreSkip, "policy for repositories that already exist: "+strings.Join(backup.RestorePolicies, ", "))
+	restoreCmd.Flags().StringToStringVar(&restorePolicies, "policy", nil, "policy for one repository, as slug=policy (repeatable)")
+	restoreCmd.Flags().StringToStringVar(&restoreNewSlugs, "new-slug", nil, "restore an existing repository to a new slug, as slug=new-slug (repeatable)")
+	restoreCmd.Flags().StringVar(&restorePrefix, "prefix", backup.DefaultRestorePrefix, "slug prefix for the prefix policy")
+	restoreCmd.Flags().StringSliceVar(&restoreRepos, "repo", nil, "repository slug glob to restore (repeatable; default: all)")
+	restoreCmd.Flags().BoolVar(&restoreJSON, "json", false, "output the plan and results as JSON")
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	workspaceDir := filepath.Join(cfg.Storage.Path, cfg.Workspace)
+	if len(args) == 1 {
+		workspaceDir = args[0]
+	}
+
+	restorer, err := backup.NewRestorer(cfg)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	plan, err := restorer.Plan(ctx, workspaceDir, backup.RestoreOptions{
+		Repos:      restoreRepos,
+		OnConflict: restoreOnConflict,
+		Policies:   restorePolicies,
+		NewSlugs:   restoreNewSlugs,
+		Prefix:     restorePrefix,
+	})
+	if err != nil {
+		return err
+	}
+
+	if !restorePlanOnly && len(plan.Undecided()) > 0 {
+		if err := askRestorePolicies(ctx, restorer, plan, cmd.InOrStdin(), cmd.ErrOrStderr()); err != nil {
+			return err
+		}
+	}
+
+	if restorePlanOnly {
+		if restoreJSON {
+			if err := writeJSON(plan); err != nil {
+				return err
+			}
+		} else {
+			printRestorePlan(plan)
+		}
+		if n := plan.Failed(); n > 0 {
+			return fmt.Errorf("%d repositories cannot be restored as planned", n)
+		}
+		return nil
+	}
+
+	if !restoreJSON {
+		printRestorePlan(plan)
+		fmt.Println()
+	}
+	if err := restorer.Restore(ctx, plan); err != nil {
+		return err
+	}
+
+	if restoreJSON {
+		if err := writeJSON(plan); err != nil {
+			return err
+		}
+	} else {
+		restored := 0
+		for _, a := range plan.Repositories {
+			switch {
+			case a.Error != "":
+				fmt.Printf("  ✗ %s: %s\n", a.Name(), a.Error)
+			case a.Restored:
+				restored++
+				fmt.Printf("  ✓ %s → %s\n", a.Name(), a.Target)
+			}
+		}
+		fmt.Printf("\nRestored %d of %d repositories to %s\n", restored, len(plan.Repositories), plan.Workspace)
+	}
+
+	if n := plan.Failed(); n > 0 {
+		return fmt.Errorf("%d repositories could not be restored", n)
+	}
+	return nil
+}
+
+// printRestorePlan shows each repository's action.
+func printRestorePlan(plan *backup.RestorePlan) {
+	fmt.Printf("Restore plan for %s: %d repositories, %d already exist\n\n", plan.Workspace, len(plan.Repositories), plan.Collisions())
+	for _, a := range plan.Repositories {
+		if a.Error != "" {
+			fmt.Printf("  ✗ %-15s %s: %s\n", valueOr(a.Action, "-"), a.Name(), a.Error)
+			continue
+		}
+		line := fmt.Sprintf("    %-15s %s", a.Action, a.Name())
+		switch {
+		case a.Action == backup.RestoreOverwriteRefs:
+			line += fmt.Sprintf(" (%d created, %d updated, %d deleted)", a.RefsCreated, a.RefsUpdated, a.RefsDeleted)
+		case a.Create && a.Target != a.Slug:
+			line += " → " + a.Target
+		}
+		fmt.Println(line)
+	}
+}
+
+// askRestorePolicies asks for the policy of each undecided collision,
+// reading answers from in, until each has one that can be carried out.
+func askRestorePolicies(ctx context.Context, restorer *backup.Restorer, plan *backup.RestorePlan, in io.Reader, out io.Writer) error {
+	answers := bufio.NewScanner(in)
+	ask := func(prompt string) (string, error) {
+		fmt.Fprint(out, prompt)
+		if !answers.Scan() {
+			if err := answers.Err(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("no answer for restore policy")
+		}
+		return strings.TrimSpace(answers.Text()), nil
+	}
+
+	for _, a := range plan.Undecided() {
+		for {
+			answer, err := ask(fmt.Sprintf("%s already exists in %s. [s]kip, [o]verwrite refs, [n]ew slug, [p]refix %s? ", a.Name(), plan.Workspace, restorePrefix))
+			if err != nil {
+				return err
+			}
+			var policy, target string
+			switch strings.ToLower(answer) {
+			case "s", "skip":
+				policy = backup.RestoreSkip
+			case "o", "overwrite", backup.RestoreOverwriteRefs:
+				policy = backup.RestoreOverwriteRefs
+			case "n", "new", backup.RestoreNewSlug:
+				policy = backup.RestoreNewSlug
+				if target, err = ask("New slug: "); err != nil {
+					return err
+				}
+			case "p", backup.RestorePrefix:
+				policy, target = backup.RestorePrefix, restorePrefix+a.Slug
+			default:
+				fmt.Fprintf(out, "Unknown answer %q\n", answer)
+				continue
+			}
+			if err := restorer.Decide(ctx, a, policy, target); err != nil {
+				fmt.Fprintf(out, "  %v\n", err)
+				continue
+			}
+			break
+		}
+	}
+	return nil
+}
@@ -1,12 +1,9 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
-	"os"
-	"os/signal"
 	"path/filepath"
-	"syscall"
+	"sort"
 
 	"github.com/andy-wilson/bb-backup/internal/backup"
 	"github.com/andy-wilson/bb-backup/internal/logging"
@@ -14,29 +11,34 @@ import (
 )
 
 var (
-	retryMaxRetry    int
-	retryClear       bool
-	retryInteractive bool
+	retryMaxRetry     int
+	retryClear        bool
+	retryList         bool
+	retryInteractive  bool
 	retryJSONProgress bool
 )
 
 var retryCmd = &cobra.Command{
-	Use:   "retry-failed",
-	Short: "Retry backup for previously failed repositories",
+	Use:     "retry",
+	Aliases: []string{"retry-failed"},
+	Short:   "Retry backup for previously failed repositories",
 	Long: `Retry backup for repositories that failed in a previous run.
 
 This command reads the state file to find repositories that failed
-during the last backup and attempts to back them up again.
+during the last backup and backs up just those again through the normal
+worker pool. Each one that succeeds is cleared from the state file's
+failed list; ones that fail again stay on it.
 
 Progress output:
   --interactive    Interactive mode with progress bar and ETA
   --json-progress  Output progress as JSON lines (for automation)
 
 Examples:
-  bb-backup retry-failed -c config.yaml
-  bb-backup retry-failed -i                # Interactive mode with progress bar
-  bb-backup retry-failed --retry 3
-  bb-backup retry-failed --clear           # Clear failed list without retrying`,
+  bb-backup retry -c config.yaml
+  bb-backup retry --list                   # Show failed repos without retrying
+  bb-backup retry -i                       # Interactive mode with progress bar
+  bb-backup retry --retry 3
+  bb-backup retry --clear                  # Clear failed list without retrying`,
 	RunE: runRetryFailed,
 }
 
@@ -45,11 +47,16 @@ func init() {
 
 	retryCmd.Flags().IntVar(&retryMaxRetry, "retry", 2, "max retry attempts per repo")
 	retryCmd.Flags().BoolVar(&retryClear, "clear", false, "clear failed repos list without retrying")
+	retryCmd.Flags().BoolVar(&retryList, "list", false, "list failed repos without retrying")
 	retryCmd.Flags().BoolVarP(&retryInteractive, "interactive", "i", false, "interactive mode with progress bar and ETA")
 	retryCmd.Flags().BoolVar(&retryJSONProgress, "json-progress", false, "output progress as JSON lines")
 }
 
 func runRetryFailed(_ *cobra.Command, _ []string) error {
+	if retryList && retryClear {
+		return fmt.Errorf("--list and --clear are mutually exclusive")
+	}
+
 	// Load configuration
 	cfg, err := loadConfig()
 	if err != nil {
@@ -76,9 +83,9 @@ func runRetryFailed(_ *cobra.Command, _ []string) error {
 		return nil
 	}
 
-	fmt.Printf("Found %d failed repositories:\n", len(failedRepos))
-	for _, repo := range failedRepos {
-		fmt.Printf("  - %s (failed at %s): %s\n", repo.Slug, repo.FailedAt, repo.Error)
+	printFailedRepos(failedRepos)
+	if retryList {
+		return nil
 	}
 
 	// If --clear flag, just clear the list
@@ -93,31 +100,13 @@ func runRetryFailed(_ *cobra.Command, _ []string) error {
 
 	fmt.Println("\nRetrying failed repositories...")
 
-	// Set up context with cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Handle interrupt signals
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigCh
-		if !retryJSONProgress {
-			fmt.Println("\nReceived interrupt, shutting down gracefully...")
-		}
-		cancel()
-	}()
-
-	// Build include list from failed repos
-	var includeRepos []string
+	// Back up exactly the failed repos, ignoring the configured include
+	// and exclude patterns; ones no longer in the workspace are reported
+	var slugs []string
 	for _, repo := range failedRepos {
-		includeRepos = append(includeRepos, repo.Slug)
+		slugs = append(slugs, repo.Slug)
 	}
 
-	// Override config to only include failed repos
-	cfg.Backup.IncludeRepos = includeRepos
-	cfg.Backup.ExcludeRepos = nil
-
 	// Determine effective log level
 	effectiveLevel := cfg.Logging.Level
 	if verbose {
@@ -148,6 +137,11 @@ func runRetryFailed(_ *cobra.Command, _ []string) error {
 	}
 	defer func() { _ = log.Close() }()
 
+	injector, err := loadFaults()
+	if err != nil {
+		return err
+	}
+
 	// Create and run backup
 	opts := backup.Options{
 		DryRun:       dryRun,
@@ -156,7 +150,11 @@ func runRetryFailed(_ *cobra.Command, _ []string) error {
 		JSONProgress: retryJSONProgress,
 		Interactive:  retryInteractive,
 		MaxRetry:     retryMaxRetry,
+		Repos:        slugs,
 		Logger:       log,
+		Faults:       injector,
+		Version:      version,
+		Commit:       commit,
 	}
 
 	b, err := backup.New(cfg, opts)
@@ -164,9 +162,36 @@ func runRetryFailed(_ *cobra.Command, _ []string) error {
 		return fmt.Errorf("initializing backup: %w", err)
 	}
 
+	ctx, stop := handleInterrupts(b, retryJSONProgress)
+	defer stop()
+
 	if err := b.Run(ctx); err != nil {
 		return fmt.Errorf("running retry backup: %w", err)
 	}
 
 	return nil
 }
+
+// printFailedRepos lists the failed repositories from the state file,
+// most recent failure first.
+func printFailedRepos(repos []backup.FailedRepo) {
+	sort.Slice(repos, func(i, j int) bool {
+		if repos[i].FailedAt != repos[j].FailedAt {
+			return repos[i].FailedAt > repos[j].FailedAt
+		}
+		return repos[i].Slug < repos[j].Slug
+	})
+
+	fmt.Printf("Found %d failed repositories:\n", len(repos))
+	for _, repo := range repos {
+		detail := "failed at " + repo.FailedAt
+		if repo.Consecutive > 1 {
+			detail += fmt.Sprintf(", %d runs in a row", repo.Consecutive)
+		}
+		msg := repo.Error
+		if repo.Code != "" {
+			msg = fmt.Sprintf("[%s] %s", repo.Code, msg)
+		}
+		fmt.Printf("  - %s (%s): %s\n", repo.Slug, detail, msg)
+	}
+}
@@ -2,14 +2,19 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/andy-wilson/bb-backup/internal/faults"
+	"github.com/andy-wilson/bb-backup/pkg/bbbackup"
+
 	"github.com/spf13/cobra"
 )
 
-// Build information, set via ldflags.
+// Build information, set via ldflags. An unset version is taken from
+// bbbackup.Version, so go install builds report their module version.
 var (
-	version   = "dev"
+	version   = ""
 	commit    = "unknown"
 	buildTime = "unknown"
 )
@@ -27,6 +32,8 @@ var (
 	workspace string
 	verbose   bool
 	quiet     bool
+	faultSpec string
+	checkAuth bool
 )
 
 // rootCmd represents the base command when called without any subcommands.
@@ -42,8 +49,14 @@ Examples:
   bb-backup backup -c config.yaml
   bb-backup backup -w my-workspace -o /backups --username user --app-password $TOKEN
   bb-backup backup --dry-run
-  bb-backup list -w my-workspace`,
-	SilenceUsage: true,
+  bb-backup list -w my-workspace
+  bb-backup --check-auth -c config.yaml`,
+	SilenceUsage:      true,
+	PersistentPreRunE: checkAuthPreRun,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		// Only reached without a subcommand; --check-auth exits before this
+		return cmd.Help()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -53,11 +66,28 @@ func Execute() error {
 }
 
 func init() {
+	if version == "" {
+		version = bbbackup.Version()
+	}
+
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default: ./bb-backup.yaml)")
-	rootCmd.PersistentFlags().StringVarP(&workspace, "workspace", "w", "", "workspace to backup (overrides config)")
+	rootCmd.PersistentFlags().StringVarP(&workspace, "workspace", "w", "", "workspace to back up, or the alias or slug of a workspaces entry (overrides config)")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose logging")
 	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "quiet mode (errors only)")
+	rootCmd.PersistentFlags().StringVar(&faultSpec, "faults", os.Getenv(faults.EnvVar),
+		"inject faults for testing, e.g. api_429=0.1,git=0.05 (env: "+faults.EnvVar+")")
+	rootCmd.PersistentFlags().BoolVar(&checkAuth, "check-auth", false,
+		"check the API and git access of the configured credentials, then exit without running the command")
+}
+
+// loadFaults parses the --faults spec; it returns nil when none is set.
+func loadFaults() (*faults.Injector, error) {
+	inj, err := faults.Parse(faultSpec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing --faults: %w", err)
+	}
+	return inj, nil
 }
 
 // getConfigPath returns the config file path, using default if not specified.
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sloJSON         bool
+	sloMaxDuration  string
+	sloMaxFailed    int
+	sloMaxStaleness string
+)
+
+var sloCmd = &cobra.Command{
+	Use:   "slo [workspace-backup-path]",
+	Short: "Check the latest backup run against SLO targets",
+	Long: `Evaluate the most recent completed backup run against the service level
+objectives in the slo section of the config:
+
+  max_duration       longest acceptable run time, e.g. "2h"
+  max_failed_repos   most failed repositories allowed in a run
+  max_staleness      oldest acceptable completed run, e.g. "26h"
+
+Flags override the config values. The backup path defaults to the
+workspace directory under storage.path.
+
+Exit codes:
+  0 - All objectives met
+  1 - One or more objectives missed, or no completed run found
+
+Examples:
+  bb-backup slo -c config.yaml
+  bb-backup slo /backups/my-workspace --max-staleness 26h --json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runSLO,
+}
+
+func init() {
+	rootCmd.AddCommand(sloCmd)
+
+	sloCmd.Flags().BoolVar(&sloJSON, "json", false, "output as JSON")
+	sloCmd.Flags().StringVar(&sloMaxDuration, "max-duration", "", "longest acceptable run time (overrides slo.max_duration)")
+	sloCmd.Flags().IntVar(&sloMaxFailed, "max-failed", -1, "most failed repositories allowed (overrides slo.max_failed_repos)")
+	sloCmd.Flags().StringVar(&sloMaxStaleness, "max-staleness", "", "oldest acceptable completed run (overrides slo.max_staleness)")
+}
+
+func runSLO(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	slo := cfg.SLO
+	if sloMaxDuration != "" {
+		slo.MaxDuration = sloMaxDuration
+	}
+	if cmd.Flags().Changed("max-failed") {
+		slo.MaxFailedRepos = sloMaxFailed
+	}
+	if sloMaxStaleness != "" {
+		slo.MaxStaleness = sloMaxStaleness
+	}
+	for _, value := range []string{sloMaxDuration, sloMaxStaleness} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q: use a positive Go duration such as 2h or 90m", value)
+		}
+	}
+	if !slo.Enabled() {
+		return fmt.Errorf("no SLO targets configured; set the slo section in the config or pass --max-duration, --max-failed, or --max-staleness")
+	}
+
+	workspaceDir := filepath.Join(cfg.Storage.Path, cfg.Workspace)
+	if len(args) == 1 {
+		workspaceDir = args[0]
+	}
+
+	manifest, runDir, err := backup.FindLatestRun(workspaceDir)
+	if err != nil {
+		return err
+	}
+	result, err := backup.EvaluateSLO(slo, manifest, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if sloJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Run: %s (%s)\n\n", result.RunID, runDir)
+		for _, c := range result.Checks {
+			mark := "✓"
+			if !c.Pass {
+				mark = "✗"
+			}
+			fmt.Printf("  %s %-17s %s\n", mark, c.Name, c.Detail)
+		}
+		fmt.Println()
+		if result.Pass {
+			fmt.Println("All objectives met")
+		}
+	}
+
+	if !result.Pass {
+		return fmt.Errorf("SLO missed: %s", strings.Join(result.Failed(), ", "))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsDurations bool
+	statsJSON      bool
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [workspace-backup-path]",
+	Short: "Show statistics from the backup state file",
+	Long: `Show statistics recorded in the state file of a workspace backup.
+
+With --durations, list each repository's recent backup times and mirror
+sizes, longest first. The last ` + fmt.Sprint(backup.RepoHistoryLength) + ` successful runs are kept per
+repository; they schedule long repositories first, drive the ETA, and
+flag repositories whose last backup took far longer than usual (a
+possible history rewrite or runaway artifacts).
+
+The backup path defaults to the workspace directory under storage.path.
+
+Examples:
+  bb-backup stats -c config.yaml
+  bb-backup stats --durations
+  bb-backup stats /backups/my-workspace --durations --json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runStats,
+}
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.Flags().BoolVar(&statsDurations, "durations", false, "show per-repository backup durations and sizes")
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "output as JSON")
+}
+
+func runStats(_ *cobra.Command, args []string) error {
+	var statePath string
+	if len(args) == 1 {
+		statePath = filepath.Join(args[0], backup.StateFileName)
+	} else {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		statePath = backup.GetStatePath(cfg.Storage.Path, cfg.Workspace)
+	}
+
+	state, err := backup.LoadState(statePath)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no state file found at %s", statePath)
+	}
+
+	if statsDurations {
+		return printDurations(state.DurationStats())
+	}
+
+	summary := struct {
+		Workspace       string `json:"workspace"`
+		Repositories    int    `json:"repositories"`
+		FailedRepos     int    `json:"failed_repos"`
+		Quarantined     int    `json:"quarantined"`
+		UsageBytes      int64  `json:"usage_bytes,omitempty"`
+		UsageMeasuredAt string `json:"usage_measured_at,omitempty"`
+		LastFullBackup  string `json:"last_full_backup,omitempty"`
+		LastIncremental string `json:"last_incremental,omitempty"`
+	}{
+		Workspace:       state.Workspace,
+		Repositories:    len(state.Repositories),
+		FailedRepos:     len(state.GetFailedRepos()),
+		Quarantined:     len(state.GetQuarantined()),
+		LastFullBackup:  state.LastFullBackup,
+		LastIncremental: state.LastIncremental,
+	}
+	if usage, ok := state.GetUsage(); ok {
+		summary.UsageBytes, summary.UsageMeasuredAt = usage.Bytes, usage.MeasuredAt
+	}
+	if statsJSON {
+		return writeJSON(summary)
+	}
+	fmt.Printf("Workspace:        %s\n", summary.Workspace)
+	fmt.Printf("Repositories:     %d\n", summary.Repositories)
+	fmt.Printf("Failed:           %d\n", summary.FailedRepos)
+	fmt.Printf("Quarantined:      %d\n", summary.Quarantined)
+	if summary.UsageMeasuredAt != "" {
+		fmt.Printf("Disk usage:       %s (measured %s)\n", format.Bytes(summary.UsageBytes), summary.UsageMeasuredAt)
+	}
+	fmt.Printf("Last full:        %s\n", valueOr(summary.LastFullBackup, "never"))
+	fmt.Printf("Last incremental: %s\n", valueOr(summary.LastIncremental, "never"))
+	return nil
+}
+
+// printDurations lists per-repository duration history.
+func printDurations(stats []backup.RepoDurations) error {
+	if statsJSON {
+		if stats == nil {
+			stats = []backup.RepoDurations{}
+		}
+		return writeJSON(stats)
+	}
+	if len(stats) == 0 {
+		fmt.Println("No duration history yet; it is recorded by backups from this version on.")
+		return nil
+	}
+
+	seconds := func(s float64) string {
+		return format.Duration(time.Duration(s * float64(time.Second)))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tRUNS\tLAST\tMEDIAN\tMAX\tSIZE\t")
+	ballooned := 0
+	for _, s := range stats {
+		flag := ""
+		if s.Ballooned {
+			flag = "ballooned"
+			ballooned++
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", s.Slug, s.Runs,
+			seconds(s.LastSeconds), seconds(s.MedianSeconds), seconds(s.MaxSeconds), format.Bytes(s.LastBytes), flag)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if ballooned > 0 {
+		fmt.Printf("\n%d repositories took far longer than usual on their last run; check for history rewrites or large new files.\n", ballooned)
+	}
+	return nil
+}
+
+// writeJSON prints v as indented JSON on stdout.
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// valueOr returns value, or fallback when it is empty.
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/spf13/cobra"
+)
+
+var (
+	trendsRuns int
+	trendsJSON bool
+)
+
+var trendsCmd = &cobra.Command{
+	Use:   "trends [workspace-backup-path]",
+	Short: "Show how recent backup runs compare",
+	Long: `Show the repository count, disk usage, duration, and failure rate of
+recent backup runs from the state file, and flag a last run that was much
+slower or failed much more than the ones before it, such as after a
+Bitbucket or bb-backup change. The last ` + fmt.Sprint(backup.RunHistoryLength) + ` runs are kept.
+
+The backup path defaults to the workspace directory under storage.path.
+
+Examples:
+  bb-backup trends -c config.yaml
+  bb-backup trends --runs 50
+  bb-backup trends /backups/my-workspace --json`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runTrends,
+}
+
+func init() {
+	rootCmd.AddCommand(trendsCmd)
+
+	trendsCmd.Flags().IntVar(&trendsRuns, "runs", 20, "number of recent runs to show (0 for all)")
+	trendsCmd.Flags().BoolVar(&trendsJSON, "json", false, "output as JSON")
+}
+
+func runTrends(_ *cobra.Command, args []string) error {
+	var statePath string
+	if len(args) == 1 {
+		statePath = filepath.Join(args[0], backup.StateFileName)
+	} else {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		statePath = backup.GetStatePath(cfg.Storage.Path, cfg.Workspace)
+	}
+
+	state, err := backup.LoadState(statePath)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("no state file found at %s", statePath)
+	}
+
+	runs := state.RecentRuns(trendsRuns)
+	trends := backup.RunTrends(runs)
+	if trendsJSON {
+		if runs == nil {
+			runs = []backup.RunStats{}
+		}
+		return writeJSON(struct {
+			Runs   []backup.RunStats `json:"runs"`
+			Trends *backup.Trends    `json:"trends,omitempty"`
+		}{runs, trends})
+	}
+	if trends == nil {
+		fmt.Println("No run history yet; it is recorded by backups from this version on.")
+		return nil
+	}
+
+	seconds := func(s float64) string {
+		return format.Duration(time.Duration(s * float64(time.Second)))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tTYPE\tREPOS\tBACKED UP\tFAILED\tDURATION\tUSAGE\t")
+	for _, r := range runs {
+		kind := "incremental"
+		if r.Full {
+			kind = "full"
+		}
+		usage := "-"
+		if r.UsageBytes > 0 {
+			usage = format.Bytes(r.UsageBytes)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d (%.0f%%)\t%s\t%s\t\n", r.StartedAt, kind, r.Repositories,
+			r.BackedUp, r.Failed, r.FailureRate()*100, seconds(r.DurationSeconds), usage)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nOver %d runs: repositories %d → %d", trends.Runs, trends.RepositoriesFirst, trends.RepositoriesLast)
+	if trends.UsageBytesLast > 0 {
+		fmt.Printf(", usage %s → %s", format.Bytes(trends.UsageBytesFirst), format.Bytes(trends.UsageBytesLast))
+	}
+	fmt.Println()
+	fmt.Printf("Last run took %s (median %s) with %.0f%% failed (median %.0f%%)\n",
+		seconds(trends.LastSeconds), seconds(trends.MedianSeconds), trends.LastFailureRate*100, trends.MedianFailureRate*100)
+	if trends.Slower {
+		fmt.Println("The last run was much slower than usual; check for API slowdowns, large new repositories, or history rewrites.")
+	}
+	if trends.MoreFailures {
+		fmt.Println("The last run failed more repositories than usual; see its report.json for errors.")
+	}
+	return nil
+}
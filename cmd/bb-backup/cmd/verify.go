@@ -1,19 +1,29 @@
 package cmd
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/git"
 	"github.com/spf13/cobra"
 )
 
 var (
-	verifyJSON    bool
-	verifyVerbose bool
+	verifyJSON          bool
+	verifyVerbose       bool
+	verifyAgeIdentities []string
 )
 
 var verifyCmd = &cobra.Command{
@@ -26,6 +36,26 @@ This command checks:
   - All referenced repositories exist
   - Git repositories pass fsck checks
   - All metadata JSON files are valid
+  - Refs and packs match each repository's integrity.json, and no refs
+    disappeared between the last two runs (reported as a warning)
+  - The state file tracks exactly the repositories in latest/, and no
+    PR or issue timestamp in it lies in the future (checked when the
+    path, or its parent, is a workspace directory with a state file)
+
+Metadata files may be gzip- or zstd-compressed or age-encrypted (e.g.
+1.json.zst.age); each file's encodings are detected from its content and
+decoded before the JSON inside is checked. zstd and age need the zstd and
+age commands, and age files an identity given with --age-identity.
+
+Given a bundle-delta output directory (one with delta.json), verify
+checks its git bundles and metadata tarballs instead: every object in a
+bundle that needs no missing prerequisites, and every JSON file in the
+tarballs.
+
+Given a run archive written by "bb-backup archive" or storage.archive
+(<run-id>.tar.gz or .zip), verify checks it against its .sha256 checksum
+file, reads it to the end, and checks every JSON file in it. Archives in a
+workspace directory are checked along with the rest of it.
 
 Exit codes:
   0 - All checks passed
@@ -34,7 +64,10 @@ Exit codes:
 Examples:
   bb-backup verify /backups/my-workspace
   bb-backup verify /backups/my-workspace --json
-  bb-backup verify /backups/my-workspace -v`,
+  bb-backup verify /backups/my-workspace -v
+  bb-backup verify /backups/my-workspace --age-identity ~/.config/bb-backup/key.txt
+  bb-backup verify /offsite/delta-2024-06-01
+  bb-backup verify /backups/my-workspace/2024-01-15T10-30-00Z-9b07d3e1.tar.gz`,
 	Args: cobra.ExactArgs(1),
 	RunE: runVerify,
 }
@@ -44,16 +77,19 @@ func init() {
 
 	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "output results as JSON")
 	verifyCmd.Flags().BoolVarP(&verifyVerbose, "verbose", "v", false, "show detailed output")
+	verifyCmd.Flags().StringArrayVar(&verifyAgeIdentities, "age-identity", nil, "age identity file for encrypted files (repeatable)")
 }
 
 // VerifyResult represents the result of verification.
 type VerifyResult struct {
-	Path         string         `json:"path"`
-	Valid        bool           `json:"valid"`
-	Manifest     *ManifestCheck `json:"manifest"`
-	Repositories []RepoCheck    `json:"repositories"`
-	Errors       []string       `json:"errors,omitempty"`
-	Summary      VerifySummary  `json:"summary"`
+	Path         string             `json:"path"`
+	Valid        bool               `json:"valid"`
+	Manifest     *ManifestCheck     `json:"manifest"`
+	Repositories []RepoCheck        `json:"repositories"`
+	State        *backup.StateCheck `json:"state,omitempty"`
+	Artifacts    []ArtifactCheck    `json:"artifacts,omitempty"` // Bundles and tarballs of a bundle-delta, or run archives
+	Errors       []string           `json:"errors,omitempty"`
+	Summary      VerifySummary      `json:"summary"`
 }
 
 // ManifestCheck represents manifest verification.
@@ -74,6 +110,7 @@ type RepoCheck struct {
 	JSONChecks []JSONCheck `json:"json_checks,omitempty"`
 	Valid      bool        `json:"valid"`
 	Errors     []string    `json:"errors,omitempty"`
+	Warnings   []string    `json:"warnings,omitempty"`
 }
 
 // GitCheck represents git fsck result.
@@ -85,9 +122,20 @@ type GitCheck struct {
 
 // JSONCheck represents a JSON file validation.
 type JSONCheck struct {
-	File  string `json:"file"`
-	Valid bool   `json:"valid"`
-	Error string `json:"error,omitempty"`
+	File     string `json:"file"`
+	Encoding string `json:"encoding,omitempty"` // e.g. "age+zstd", outermost first
+	Valid    bool   `json:"valid"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ArtifactCheck represents the check of a git bundle or metadata tarball.
+type ArtifactCheck struct {
+	File     string `json:"file"`
+	Kind     string `json:"kind"` // "bundle", "tarball", or "archive"
+	Encoding string `json:"encoding,omitempty"`
+	Valid    bool   `json:"valid"`
+	Error    string `json:"error,omitempty"`
+	Warning  string `json:"warning,omitempty"`
 }
 
 // VerifySummary contains summary statistics.
@@ -99,6 +147,9 @@ type VerifySummary struct {
 	ValidGit     int `json:"valid_git"`
 	TotalJSON    int `json:"total_json"`
 	ValidJSON    int `json:"valid_json"`
+
+	TotalArtifacts int `json:"total_artifacts,omitempty"`
+	ValidArtifacts int `json:"valid_artifacts,omitempty"`
 }
 
 // Manifest represents the backup manifest structure.
@@ -122,24 +173,57 @@ func runVerify(_ *cobra.Command, args []string) error {
 	}
 
 	// Check if backup path exists
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+	info, err := os.Stat(backupPath)
+	if os.IsNotExist(err) {
 		result.Valid = false
 		result.Errors = append(result.Errors, fmt.Sprintf("backup path does not exist: %s", backupPath))
 		return outputVerifyResult(result)
 	}
 
+	if err == nil && !info.IsDir() && backup.ArchiveFormat(backupPath) != "" {
+		addArtifact(result, verifyArchive(backupPath, backupPath))
+		return outputVerifyResult(result)
+	}
+
+	if _, err := os.Stat(filepath.Join(backupPath, backup.DeltaFileName)); err == nil {
+		verifyDelta(backupPath, result)
+		return outputVerifyResult(result)
+	}
+
 	// Check manifest
-	result.Manifest = verifyManifest(backupPath)
+	repoRoot, manifestDir := verifyPaths(backupPath)
+	result.Manifest = verifyManifest(manifestDir)
 	if !result.Manifest.Valid {
 		result.Valid = false
 	}
 
 	// If manifest is valid, verify repositories from it
 	if result.Manifest.Valid && result.Manifest.RepoCount > 0 {
-		verifyRepositoriesFromManifest(backupPath, result)
+		verifyRepositoriesFromManifest(manifestDir, repoRoot, result)
 	} else {
 		// Fall back to scanning directory structure
-		verifyRepositoriesFromDirectory(backupPath, result)
+		verifyRepositoriesFromDirectory(repoRoot, result)
+	}
+
+	// Cross-check the state file that drives incremental runs
+	if workspaceDir := stateWorkspaceDir(backupPath); workspaceDir != "" {
+		check, err := backup.CheckState(workspaceDir, time.Now())
+		if err != nil {
+			result.Valid = false
+			result.Errors = append(result.Errors, fmt.Sprintf("state file: %v", err))
+		} else if check != nil {
+			result.State = check
+			if !check.Valid() {
+				result.Valid = false
+			}
+		}
+	}
+
+	// Check run archives kept beside the runs
+	if archives, err := backup.ListArchives(backupPath); err == nil {
+		for _, name := range archives {
+			addArtifact(result, verifyArchive(filepath.Join(backupPath, name), name))
+		}
 	}
 
 	// Calculate summary
@@ -170,6 +254,44 @@ func runVerify(_ *cobra.Command, args []string) error {
 	return outputVerifyResult(result)
 }
 
+// stateWorkspaceDir returns the workspace directory whose state file
+// covers backupPath: the path itself, or its parent when the path is
+// latest/ or a run directory. It returns "" if neither has a state file.
+func stateWorkspaceDir(backupPath string) string {
+	for _, dir := range []string{backupPath, filepath.Dir(filepath.Clean(backupPath))} {
+		if _, err := os.Stat(filepath.Join(dir, backup.StateFileName)); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// verifyPaths returns where to check repositories and where to read the
+// manifest for backupPath. A run directory holds both. For a workspace
+// directory or its latest/ tree, repositories (and their git mirrors) are
+// checked in latest/ against the manifest of the run current points at.
+func verifyPaths(backupPath string) (repoRoot, manifestDir string) {
+	if _, err := os.Stat(filepath.Join(backupPath, "manifest.json")); err == nil {
+		return backupPath, backupPath
+	}
+	workspaceDir := backupPath
+	if filepath.Base(filepath.Clean(backupPath)) == backup.LatestDirName {
+		workspaceDir = filepath.Dir(filepath.Clean(backupPath))
+	}
+	latest := filepath.Join(workspaceDir, backup.LatestDirName)
+	if info, err := os.Stat(latest); err != nil || !info.IsDir() {
+		return backupPath, backupPath
+	}
+	target, err := os.Readlink(filepath.Join(workspaceDir, backup.CurrentLinkName))
+	if err != nil {
+		return latest, latest
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(workspaceDir, target)
+	}
+	return latest, target
+}
+
 func verifyManifest(backupPath string) *ManifestCheck {
 	check := &ManifestCheck{}
 
@@ -205,8 +327,8 @@ func verifyManifest(backupPath string) *ManifestCheck {
 	return check
 }
 
-func verifyRepositoriesFromManifest(backupPath string, result *VerifyResult) {
-	manifestPath := filepath.Join(backupPath, "manifest.json")
+func verifyRepositoriesFromManifest(manifestDir, backupPath string, result *VerifyResult) {
+	manifestPath := filepath.Join(manifestDir, "manifest.json")
 	data, err := os.ReadFile(manifestPath)
 	if err != nil {
 		return
@@ -251,15 +373,11 @@ func verifyRepositoriesFromDirectory(backupPath string, result *VerifyResult) {
 		}
 	}
 
-	// Scan personal repos
-	personalPath := filepath.Join(backupPath, "personal", "repositories")
-	if entries, err := os.ReadDir(personalPath); err == nil {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				repoPath := filepath.Join(personalPath, entry.Name())
-				repoCheck := verifyRepository(repoPath, entry.Name(), "")
-				result.Repositories = append(result.Repositories, repoCheck)
-			}
+	// Scan personal repos, under their owner or in the earlier layout
+	if personal, err := backup.ListPersonalRepos(backupPath); err == nil {
+		for _, repo := range personal {
+			repoCheck := verifyRepository(filepath.Join(backupPath, repo.Rel), repo.Slug, "")
+			result.Repositories = append(result.Repositories, repoCheck)
 		}
 	}
 }
@@ -288,9 +406,17 @@ func verifyRepository(repoPath, slug, project string) RepoCheck {
 		check.Errors = append(check.Errors, fmt.Sprintf("git: %s", check.GitCheck.Error))
 	}
 
-	// Check JSON files
+	// Compare against the integrity summary written by the last run
+	problems, warnings := verifyIntegrity(repoPath, check.GitCheck.Exists)
+	for _, p := range problems {
+		check.Valid = false
+		check.Errors = append(check.Errors, fmt.Sprintf("integrity: %s", p))
+	}
+	check.Warnings = append(check.Warnings, warnings...)
+
+	// Check JSON files, compressed or encrypted or not
 	jsonFiles := []string{
-		"repository.json",
+		artifactPath(repoPath, "repository.json"),
 	}
 
 	// Check for PR and issue directories
@@ -299,14 +425,14 @@ func verifyRepository(repoPath, slug, project string) RepoCheck {
 		// Check all PR JSON files
 		entries, _ := os.ReadDir(prDir)
 		for _, entry := range entries {
-			if strings.HasSuffix(entry.Name(), ".json") {
+			if !entry.IsDir() && isJSONArtifact(entry.Name()) {
 				jsonFiles = append(jsonFiles, filepath.Join("pull-requests", entry.Name()))
 			}
 			if entry.IsDir() {
-				// Check comments.json and activity.json
+				// Check comments.json, activity.json and tasks.json
 				prSubDir := filepath.Join("pull-requests", entry.Name())
-				for _, subFile := range []string{"comments.json", "activity.json"} {
-					subPath := filepath.Join(prSubDir, subFile)
+				for _, subFile := range []string{"comments.json", "activity.json", "tasks.json"} {
+					subPath := artifactPath(repoPath, filepath.Join(prSubDir, subFile))
 					if _, err := os.Stat(filepath.Join(repoPath, subPath)); err == nil {
 						jsonFiles = append(jsonFiles, subPath)
 					}
@@ -319,11 +445,11 @@ func verifyRepository(repoPath, slug, project string) RepoCheck {
 	if _, err := os.Stat(issueDir); err == nil {
 		entries, _ := os.ReadDir(issueDir)
 		for _, entry := range entries {
-			if strings.HasSuffix(entry.Name(), ".json") {
+			if !entry.IsDir() && isJSONArtifact(entry.Name()) {
 				jsonFiles = append(jsonFiles, filepath.Join("issues", entry.Name()))
 			}
 			if entry.IsDir() {
-				commentsPath := filepath.Join("issues", entry.Name(), "comments.json")
+				commentsPath := artifactPath(repoPath, filepath.Join("issues", entry.Name(), "comments.json"))
 				if _, err := os.Stat(filepath.Join(repoPath, commentsPath)); err == nil {
 					jsonFiles = append(jsonFiles, commentsPath)
 				}
@@ -343,6 +469,36 @@ func verifyRepository(repoPath, slug, project string) RepoCheck {
 	return check
 }
 
+// verifyIntegrity checks a repository against its integrity.json, if any.
+// Problems are differences between the recorded summary and the mirror on
+// disk; warnings are refs that disappeared between the last two runs,
+// which may be a legitimate branch deletion or force-push.
+func verifyIntegrity(repoPath string, hasGit bool) (problems, warnings []string) {
+	data, err := os.ReadFile(filepath.Join(repoPath, backup.IntegrityFileName))
+	if err != nil {
+		return nil, nil
+	}
+	var recorded git.Integrity
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return []string{fmt.Sprintf("invalid %s: %v", backup.IntegrityFileName, err)}, nil
+	}
+
+	if prev := recorded.Previous; prev != nil && recorded.RefCount < prev.RefCount {
+		warnings = append(warnings, fmt.Sprintf("%d refs removed in the run at %s (%d -> %d)",
+			prev.RefCount-recorded.RefCount, recorded.GeneratedAt, prev.RefCount, recorded.RefCount))
+	}
+
+	if hasGit {
+		current, err := git.ComputeIntegrity(filepath.Join(repoPath, "repo.git"))
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			problems = append(problems, recorded.Compare(current)...)
+		}
+	}
+	return problems, warnings
+}
+
 func verifyGitRepo(gitPath string) *GitCheck {
 	check := &GitCheck{}
 
@@ -387,6 +543,14 @@ func verifyJSONFile(filePath, relPath string) JSONCheck {
 		return check
 	}
 
+	data, encodings, err := backup.DecodeArtifact(context.Background(), data, verifyKeys())
+	check.Encoding = strings.Join(encodings, "+")
+	if err != nil {
+		check.Valid = false
+		check.Error = err.Error()
+		return check
+	}
+
 	var js json.RawMessage
 	if err := json.Unmarshal(data, &js); err != nil {
 		check.Valid = false
@@ -398,6 +562,193 @@ func verifyJSONFile(filePath, relPath string) JSONCheck {
 	return check
 }
 
+// verifyKeys returns the keys given for decrypting files.
+func verifyKeys() backup.ArtifactKeys {
+	return backup.ArtifactKeys{AgeIdentities: verifyAgeIdentities}
+}
+
+// isJSONArtifact reports whether name is a JSON file, possibly compressed
+// or encrypted.
+func isJSONArtifact(name string) bool {
+	return strings.HasSuffix(backup.ArtifactBaseName(name), ".json")
+}
+
+// artifactPath returns rel, or the compressed or encrypted variant of it
+// that exists under dir.
+func artifactPath(dir, rel string) string {
+	for _, name := range backup.ArtifactNames(rel) {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return name
+		}
+	}
+	return rel
+}
+
+// verifyDelta checks the bundles and metadata tarballs listed in a
+// bundle-delta output directory's delta.json.
+func verifyDelta(deltaDir string, result *VerifyResult) {
+	data, err := os.ReadFile(filepath.Join(deltaDir, backup.DeltaFileName))
+	if err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", backup.DeltaFileName, err))
+		return
+	}
+	var delta backup.Delta
+	if err := json.Unmarshal(data, &delta); err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: invalid JSON: %v", backup.DeltaFileName, err))
+		return
+	}
+
+	for _, repo := range delta.Repositories {
+		if repo.Bundle != "" {
+			addArtifact(result, verifyBundleFile(deltaDir, repo.Bundle))
+		}
+		if repo.Metadata != "" {
+			addArtifact(result, verifyTarball(deltaDir, repo.Metadata))
+		}
+	}
+	if delta.Metadata != "" {
+		addArtifact(result, verifyTarball(deltaDir, delta.Metadata))
+	}
+}
+
+// addArtifact records an artifact check in result.
+func addArtifact(result *VerifyResult, check ArtifactCheck) {
+	result.Artifacts = append(result.Artifacts, check)
+	result.Summary.TotalArtifacts++
+	if check.Valid {
+		result.Summary.ValidArtifacts++
+	} else {
+		result.Valid = false
+	}
+}
+
+// verifyBundleFile checks a git bundle, decoding it first if compressed
+// or encrypted.
+func verifyBundleFile(dir, rel string) ArtifactCheck {
+	check := ArtifactCheck{File: rel, Kind: "bundle"}
+	path := filepath.Join(dir, rel)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		check.Error = fmt.Sprintf("read error: %v", err)
+		return check
+	}
+	if backup.DetectEncoding(data) != "" {
+		decoded, encodings, err := backup.DecodeArtifact(context.Background(), data, verifyKeys())
+		check.Encoding = strings.Join(encodings, "+")
+		if err != nil {
+			check.Error = err.Error()
+			return check
+		}
+		tmp, err := os.CreateTemp("", "bb-backup-verify-*.bundle")
+		if err != nil {
+			check.Error = err.Error()
+			return check
+		}
+		defer os.Remove(tmp.Name()) //nolint:errcheck // scratch file
+		_, werr := tmp.Write(decoded)
+		if cerr := tmp.Close(); werr == nil {
+			werr = cerr
+		}
+		if werr != nil {
+			check.Error = werr.Error()
+			return check
+		}
+		path, data = tmp.Name(), decoded
+	}
+	if !backup.IsGitBundle(data) {
+		check.Error = "not a git bundle"
+		return check
+	}
+
+	complete, err := git.VerifyBundle(context.Background(), path, "")
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	if !complete {
+		check.Warning = "needs commits from an earlier run; only its header and refs were checked"
+	}
+	check.Valid = true
+	return check
+}
+
+// verifyTarball checks that a metadata tarball reads to the end and that
+// the JSON files in it are valid.
+func verifyTarball(dir, rel string) ArtifactCheck {
+	check := ArtifactCheck{File: rel, Kind: "tarball"}
+	data, err := os.ReadFile(filepath.Join(dir, rel))
+	if err != nil {
+		check.Error = fmt.Sprintf("read error: %v", err)
+		return check
+	}
+	data, encodings, err := backup.DecodeArtifact(context.Background(), data, verifyKeys())
+	check.Encoding = strings.Join(encodings, "+")
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			check.Error = fmt.Sprintf("reading tarball: %v", err)
+			return check
+		}
+		if err := verifyArchivedFile(hdr.Name, tr); err != nil {
+			check.Error = err.Error()
+			return check
+		}
+	}
+	check.Valid = true
+	return check
+}
+
+// verifyArchive checks a run archive against its checksum file, and that
+// it reads to the end and the JSON files in it are valid. file is the
+// name to report it under.
+func verifyArchive(path, file string) ArtifactCheck {
+	check := ArtifactCheck{File: file, Kind: "archive", Encoding: backup.ArchiveFormat(path)}
+	if _, err := backup.VerifyArchiveChecksum(path); errors.Is(err, fs.ErrNotExist) {
+		check.Warning = fmt.Sprintf("no %s checksum file; only its content was checked", backup.ArchiveChecksumSuffix)
+	} else if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	if err := backup.WalkArchive(path, verifyArchivedFile); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Valid = true
+	return check
+}
+
+// verifyArchivedFile reads a file in a tarball or archive to the end and,
+// if it is JSON, possibly compressed or encrypted, checks that it is
+// valid.
+func verifyArchivedFile(name string, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading %s: %v", name, err)
+	}
+	if !isJSONArtifact(name) {
+		return nil
+	}
+	content, _, err = backup.DecodeArtifact(context.Background(), content, verifyKeys())
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if !json.Valid(content) {
+		return fmt.Errorf("%s: invalid JSON", name)
+	}
+	return nil
+}
+
 func outputVerifyResult(result *VerifyResult) error {
 	if verifyJSON {
 		enc := json.NewEncoder(os.Stdout)
@@ -417,6 +768,10 @@ func outputVerifyResult(result *VerifyResult) error {
 
 func outputVerifyText(result *VerifyResult) {
 	fmt.Printf("Verifying backup: %s\n\n", result.Path)
+	if result.Manifest == nil {
+		outputArtifactsText(result)
+		return
+	}
 
 	// Manifest
 	fmt.Println("Manifest:")
@@ -442,6 +797,9 @@ func outputVerifyText(result *VerifyResult) {
 		}
 
 		fmt.Printf("  %s %s%s\n", status, repo.Slug, projectInfo)
+		for _, w := range repo.Warnings {
+			fmt.Printf("      warning: %s\n", w)
+		}
 
 		if verifyVerbose || !repo.Valid {
 			if repo.GitCheck != nil {
@@ -474,11 +832,33 @@ func outputVerifyText(result *VerifyResult) {
 		}
 	}
 
+	if s := result.State; s != nil {
+		status := "✓"
+		if !s.Valid() {
+			status = "✗"
+		}
+		fmt.Printf("\nState file:\n  %s %s (%d tracked, %d in latest/)\n", status, s.StateFile, s.Tracked, s.OnDisk)
+		for _, p := range s.Problems {
+			fmt.Printf("      %s\n", p)
+		}
+		for _, w := range s.Warnings {
+			fmt.Printf("      warning: %s\n", w)
+		}
+	}
+
+	if len(result.Artifacts) > 0 {
+		fmt.Printf("\nArchives (%d):\n", len(result.Artifacts))
+		printArtifacts(result.Artifacts)
+	}
+
 	// Summary
 	fmt.Println("\nSummary:")
 	fmt.Printf("  Repositories: %d valid, %d invalid\n", result.Summary.ValidRepos, result.Summary.InvalidRepos)
 	fmt.Printf("  Git repos:    %d/%d valid\n", result.Summary.ValidGit, result.Summary.TotalGit)
 	fmt.Printf("  JSON files:   %d/%d valid\n", result.Summary.ValidJSON, result.Summary.TotalJSON)
+	if result.Summary.TotalArtifacts > 0 {
+		fmt.Printf("  Archives:     %d/%d valid\n", result.Summary.ValidArtifacts, result.Summary.TotalArtifacts)
+	}
 
 	fmt.Println()
 	if result.Valid {
@@ -487,3 +867,46 @@ func outputVerifyText(result *VerifyResult) {
 		fmt.Println("Result: FAIL")
 	}
 }
+
+// outputArtifactsText prints the result of verifying a bundle-delta
+// directory or an archive, or a path that could not be verified at all.
+func outputArtifactsText(result *VerifyResult) {
+	for _, e := range result.Errors {
+		fmt.Printf("  ✗ %s\n", e)
+	}
+	if len(result.Artifacts) > 0 {
+		fmt.Printf("Artifacts (%d):\n", len(result.Artifacts))
+	}
+	printArtifacts(result.Artifacts)
+
+	fmt.Println("\nSummary:")
+	fmt.Printf("  Artifacts: %d/%d valid\n", result.Summary.ValidArtifacts, result.Summary.TotalArtifacts)
+	fmt.Println()
+	if result.Valid {
+		fmt.Println("Result: PASS")
+	} else {
+		fmt.Println("Result: FAIL")
+	}
+}
+
+// printArtifacts prints one line per artifact check, with its error or
+// warning.
+func printArtifacts(artifacts []ArtifactCheck) {
+	for _, a := range artifacts {
+		status := "✓"
+		if !a.Valid {
+			status = "✗"
+		}
+		encoding := ""
+		if a.Encoding != "" {
+			encoding = fmt.Sprintf(" [%s]", a.Encoding)
+		}
+		fmt.Printf("  %s %s (%s)%s\n", status, a.File, a.Kind, encoding)
+		if a.Error != "" {
+			fmt.Printf("      %s\n", a.Error)
+		}
+		if a.Warning != "" {
+			fmt.Printf("      warning: %s\n", a.Warning)
+		}
+	}
+}
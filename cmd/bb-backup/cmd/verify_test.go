@@ -1,11 +1,18 @@
 package cmd
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/storage"
 )
 
 func TestVerifyManifest_Valid(t *testing.T) {
@@ -121,6 +128,35 @@ func TestVerifyJSONFile_NotFound(t *testing.T) {
 	}
 }
 
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyJSONFile_Encoded(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	filePath := filepath.Join(tmpDir, "1.json.gz")
+	os.WriteFile(filePath, gzipData(t, []byte(`{"id": 1}`)), 0644)
+	check := verifyJSONFile(filePath, "1.json.gz")
+	if !check.Valid || check.Encoding != "gzip" {
+		t.Errorf("gzipped JSON = %+v", check)
+	}
+
+	filePath = filepath.Join(tmpDir, "1.json.age")
+	os.WriteFile(filePath, []byte("age-encryption.org/v1\n-> X25519 abc\n"), 0644)
+	check = verifyJSONFile(filePath, "1.json.age")
+	if check.Valid || check.Encoding != "age" || !strings.Contains(check.Error, "age identity") {
+		t.Errorf("encrypted JSON without a key = %+v", check)
+	}
+}
+
 func TestVerifyGitRepo_Valid(t *testing.T) {
 	// Check if git is available
 	if _, err := exec.LookPath("git"); err != nil {
@@ -255,6 +291,124 @@ func TestVerifyRepository_WithPRsAndIssues(t *testing.T) {
 	}
 }
 
+func TestVerifyRepository_EncodedFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	repoPath := filepath.Join(tmpDir, "repo-1")
+	prDir := filepath.Join(repoPath, "pull-requests")
+	os.MkdirAll(filepath.Join(prDir, "1"), 0755)
+	os.WriteFile(filepath.Join(repoPath, "repository.json.gz"), gzipData(t, []byte(`{}`)), 0644)
+	os.WriteFile(filepath.Join(prDir, "1.json.gz"), gzipData(t, []byte(`{"id": 1}`)), 0644)
+	os.WriteFile(filepath.Join(prDir, "1", "comments.json.gz"), gzipData(t, []byte(`[`)), 0644)
+
+	check := verifyRepository(repoPath, "repo-1", "")
+
+	files := make(map[string]JSONCheck)
+	for _, jc := range check.JSONChecks {
+		files[jc.File] = jc
+	}
+	if jc := files["repository.json.gz"]; !jc.Valid {
+		t.Errorf("repository.json.gz = %+v", jc)
+	}
+	if jc := files[filepath.Join("pull-requests", "1.json.gz")]; !jc.Valid || jc.Encoding != "gzip" {
+		t.Errorf("1.json.gz = %+v", jc)
+	}
+	if jc, ok := files[filepath.Join("pull-requests", "1", "comments.json.gz")]; !ok || jc.Valid {
+		t.Errorf("truncated comments.json.gz = %+v, %v; want checked and invalid", jc, ok)
+	}
+}
+
+func TestVerifyDelta(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	deltaDir := t.TempDir()
+	repoDir := filepath.Join(t.TempDir(), "repo")
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repoDir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	os.MkdirAll(repoDir, 0755)
+	run("init", "-q", "-b", "main")
+	run("commit", "-q", "--allow-empty", "-m", "one")
+	run("bundle", "create", "-q", filepath.Join(deltaDir, "core.bundle"), "main")
+
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for name, content := range map[string]string{"pull-requests/1.json": `{"id": 1}`, "repository.json": `{`} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	os.WriteFile(filepath.Join(deltaDir, "core.metadata.tar.gz"), gzipData(t, tarball.Bytes()), 0644)
+	os.WriteFile(filepath.Join(deltaDir, "delta.json"),
+		[]byte(`{"repositories":[{"slug":"core","bundle":"core.bundle","metadata":"core.metadata.tar.gz"}]}`), 0644)
+
+	result := &VerifyResult{Path: deltaDir, Valid: true}
+	verifyDelta(deltaDir, result)
+
+	if len(result.Artifacts) != 2 || result.Summary.TotalArtifacts != 2 {
+		t.Fatalf("artifacts = %+v", result.Artifacts)
+	}
+	if bundle := result.Artifacts[0]; !bundle.Valid || bundle.Kind != "bundle" || bundle.Warning != "" {
+		t.Errorf("bundle = %+v", bundle)
+	}
+	if tarball := result.Artifacts[1]; tarball.Valid || tarball.Encoding != "gzip" || !strings.Contains(tarball.Error, "repository.json") {
+		t.Errorf("tarball = %+v; want the invalid repository.json named", tarball)
+	}
+	if result.Valid {
+		t.Error("a delta with an invalid tarball should fail")
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	wsDir := t.TempDir()
+	runID := "2025-01-15T10-00-00Z-9b07d3e1"
+	repoDir := filepath.Join(wsDir, runID, "projects", "CORE", "repositories", "core")
+	os.MkdirAll(repoDir, 0755)
+	os.WriteFile(filepath.Join(wsDir, runID, "manifest.json"), []byte(`{"workspace": "ws"}`), 0644)
+	os.WriteFile(filepath.Join(repoDir, "repository.json"), []byte(`{"slug": "core"}`), 0644)
+
+	store, err := storage.NewLocal(wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := backup.ArchiveRun(store, ".", runID, "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(wsDir, result.Path)
+	if check := verifyArchive(path, result.Path); !check.Valid || check.Kind != "archive" || check.Encoding != "zip" {
+		t.Errorf("verifyArchive() = %+v", check)
+	}
+
+	// A corrupt checksum file fails the archive; a missing one only warns
+	os.WriteFile(path+backup.ArchiveChecksumSuffix, []byte("0000  "+result.Path+"\n"), 0644)
+	if check := verifyArchive(path, result.Path); check.Valid || !strings.Contains(check.Error, "checksum mismatch") {
+		t.Errorf("verifyArchive() = %+v, want a checksum mismatch", check)
+	}
+	os.Remove(path + backup.ArchiveChecksumSuffix)
+	if check := verifyArchive(path, result.Path); !check.Valid || check.Warning == "" {
+		t.Errorf("verifyArchive() = %+v, want valid with a warning", check)
+	}
+
+	// Invalid JSON inside is named
+	os.WriteFile(filepath.Join(repoDir, "repository.json"), []byte(`{`), 0644)
+	os.Remove(path)
+	if result, err = backup.ArchiveRun(store, ".", runID, "tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	check := verifyArchive(filepath.Join(wsDir, result.Path), result.Path)
+	if check.Valid || !strings.Contains(check.Error, "repository.json: invalid JSON") {
+		t.Errorf("verifyArchive() = %+v, want the invalid repository.json named", check)
+	}
+}
+
 func TestVerifyRepositoriesFromDirectory(t *testing.T) {
 	// Check if git is available
 	if _, err := exec.LookPath("git"); err != nil {
@@ -298,3 +452,60 @@ func TestVerifyRepositoriesFromDirectory(t *testing.T) {
 		t.Error("expected to find personal-repo")
 	}
 }
+
+func TestVerifyIntegrity_RefsRemovedBetweenRuns(t *testing.T) {
+	repoDir := t.TempDir()
+	summary := `{"generated_at":"2024-01-16T10:30:00Z","ref_count":3,"refs_sha256":"b",
+		"previous":{"generated_at":"2024-01-15T10:30:00Z","ref_count":5,"refs_sha256":"a"}}`
+	if err := os.WriteFile(filepath.Join(repoDir, "integrity.json"), []byte(summary), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, warnings := verifyIntegrity(repoDir, false)
+	if len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "2 refs removed") {
+		t.Errorf("expected ref removal warning, got %v", warnings)
+	}
+}
+
+func TestStateWorkspaceDir(t *testing.T) {
+	wsDir := t.TempDir()
+	latest := filepath.Join(wsDir, "latest")
+	os.MkdirAll(latest, 0755)
+	if got := stateWorkspaceDir(latest); got != "" {
+		t.Errorf("without a state file: %q", got)
+	}
+
+	os.WriteFile(filepath.Join(wsDir, ".bb-backup-state.json"), []byte(`{}`), 0644)
+	for _, path := range []string{wsDir, latest, latest + "/"} {
+		if got := stateWorkspaceDir(path); got != wsDir {
+			t.Errorf("stateWorkspaceDir(%q) = %q, want %q", path, got, wsDir)
+		}
+	}
+}
+
+func TestVerifyPaths(t *testing.T) {
+	wsDir := t.TempDir()
+	latest := filepath.Join(wsDir, "latest")
+	run := filepath.Join(wsDir, "2024-01-15T10-30-00Z-abcd1234")
+	os.MkdirAll(latest, 0755)
+	os.MkdirAll(run, 0755)
+	os.WriteFile(filepath.Join(run, "manifest.json"), []byte(`{}`), 0644)
+
+	// Without a current link, latest/ is checked on its own
+	if repos, manifest := verifyPaths(wsDir); repos != latest || manifest != latest {
+		t.Errorf("without current: verifyPaths = %q, %q", repos, manifest)
+	}
+
+	os.Symlink(filepath.Base(run), filepath.Join(wsDir, "current"))
+	for _, path := range []string{wsDir, latest, latest + "/"} {
+		if repos, manifest := verifyPaths(path); repos != latest || manifest != run {
+			t.Errorf("verifyPaths(%q) = %q, %q, want %q, %q", path, repos, manifest, latest, run)
+		}
+	}
+	if repos, manifest := verifyPaths(run); repos != run || manifest != run {
+		t.Errorf("verifyPaths(run) = %q, %q", repos, manifest)
+	}
+}
@@ -5,10 +5,11 @@ import (
 	"os"
 
 	"github.com/andy-wilson/bb-backup/cmd/bb-backup/cmd"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
 )
 
 func main() {
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(errcode.ExitStatus(err))
 	}
 }
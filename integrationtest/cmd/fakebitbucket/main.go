@@ -0,0 +1,42 @@
+// Package main runs the fake Bitbucket server used by the integration
+// tests as a standalone process, e.g. under docker-compose.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/andy-wilson/bb-backup/integrationtest/fakebitbucket"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	fixturesPath := flag.String("fixtures", "testdata/fixtures.json", "fixtures file")
+	gitRoot := flag.String("git-root", "/srv/git", "directory holding the seeded repositories")
+	pageLen := flag.Int("pagelen", fakebitbucket.DefaultPageLen, "largest page the API returns")
+	flag.Parse()
+
+	if err := run(*addr, *fixturesPath, *gitRoot, *pageLen); err != nil {
+		fmt.Fprintln(os.Stderr, "fakebitbucket:", err)
+		os.Exit(1)
+	}
+}
+
+func run(addr, fixturesPath, gitRoot string, pageLen int) error {
+	fixtures, err := fakebitbucket.LoadFixtures(fixturesPath)
+	if err != nil {
+		return err
+	}
+	if err := fakebitbucket.Seed(fixtures, gitRoot); err != nil {
+		return err
+	}
+	handler, err := fakebitbucket.New(fixtures, gitRoot, fakebitbucket.WithPageLen(pageLen)).Handler()
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving workspace %s on %s (API %s, git %s)", fixtures.Workspace, addr, fakebitbucket.APIPrefix, fakebitbucket.GitPrefix)
+	return http.ListenAndServe(addr, handler)
+}
@@ -0,0 +1,153 @@
+// Package fakebitbucket is a small stand-in for Bitbucket Cloud used by the
+// integration tests: it serves a workspace's API resources from fixtures
+// and its repositories over git's smart HTTP protocol.
+package fakebitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Fixtures describes the workspace the fake serves.
+type Fixtures struct {
+	Workspace    string       `json:"workspace"`
+	Projects     []Project    `json:"projects"`
+	Repositories []Repository `json:"repositories"`
+}
+
+// Project is a workspace project.
+type Project struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// Repository is a repository and its pull requests and issues. Repositories
+// without a project are personal. Commits seed the git repository, one per
+// message, when it does not exist yet, and Wiki seeds its wiki the same way
+// at <slug>.git/wiki.
+type Repository struct {
+	Slug         string   `json:"slug"`
+	Project      string   `json:"project,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	HasIssues    bool     `json:"has_issues"`
+	Commits      []string `json:"commits"`
+	Wiki         []string `json:"wiki,omitempty"`
+	PullRequests []Item   `json:"pull_requests,omitempty"`
+	Issues       []Item   `json:"issues,omitempty"`
+}
+
+// Item is a pull request or an issue.
+type Item struct {
+	ID        int      `json:"id"`
+	Title     string   `json:"title"`
+	State     string   `json:"state"`
+	UpdatedOn string   `json:"updated_on"` // RFC 3339
+	Comments  []string `json:"comments,omitempty"`
+}
+
+// Owner returns the account that owns repo, the first part of its full
+// name. The fake serves every repository under the workspace.
+func (f *Fixtures) Owner(repo Repository) string {
+	return f.Workspace
+}
+
+// LoadFixtures reads fixtures from a JSON file.
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixtures: %w", err)
+	}
+	var f Fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing fixtures %s: %w", path, err)
+	}
+	if f.Workspace == "" {
+		return nil, fmt.Errorf("fixtures %s: workspace is required", path)
+	}
+	return &f, nil
+}
+
+// Seed creates a bare git repository under gitRoot for each fixture
+// repository that does not have one yet, with one commit per message.
+// Pushes over HTTP are enabled so tests can add commits between runs.
+func Seed(f *Fixtures, gitRoot string) error {
+	for _, repo := range f.Repositories {
+		bare := filepath.Join(gitRoot, f.Workspace, repo.Slug+".git")
+		if _, err := os.Stat(bare); err == nil {
+			continue
+		}
+		if err := seedRepository(bare, repo.Commits); err != nil {
+			return fmt.Errorf("seeding %s: %w", repo.Slug, err)
+		}
+		if len(repo.Wiki) == 0 {
+			continue
+		}
+		if err := seedRepository(filepath.Join(bare, "wiki"), repo.Wiki); err != nil {
+			return fmt.Errorf("seeding wiki of %s: %w", repo.Slug, err)
+		}
+	}
+	return nil
+}
+
+// seedRepository creates the bare repository at bare from a scratch work
+// tree with one commit per message.
+func seedRepository(bare string, commits []string) error {
+	work, err := os.MkdirTemp("", "fakebitbucket-seed-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work) //nolint:errcheck // scratch directory
+
+	if err := Git(work, "init", "--quiet", "--initial-branch=main"); err != nil {
+		return err
+	}
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, msg := range commits {
+		if err := os.WriteFile(filepath.Join(work, "CHANGES"), []byte(fmt.Sprintf("%d %s\n", i+1, msg)), 0644); err != nil {
+			return err
+		}
+		if err := Git(work, "add", "CHANGES"); err != nil {
+			return err
+		}
+		stamp := date.Add(time.Duration(i) * time.Hour).Format(time.RFC3339)
+		if err := gitWithEnv(work, []string{"GIT_AUTHOR_DATE=" + stamp, "GIT_COMMITTER_DATE=" + stamp}, "commit", "--quiet", "-m", msg); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(bare), 0755); err != nil {
+		return err
+	}
+	if err := Git("", "clone", "--quiet", "--bare", work, bare); err != nil {
+		return err
+	}
+	return Git(bare, "config", "http.receivepack", "true")
+}
+
+// Git runs a git command in dir with a fixed identity and no user or
+// system config, so results do not depend on the machine.
+func Git(dir string, args ...string) error {
+	return gitWithEnv(dir, nil, args...)
+}
+
+func gitWithEnv(dir string, env []string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_CONFIG_GLOBAL="+os.DevNull,
+		"GIT_AUTHOR_NAME=Fixture User",
+		"GIT_AUTHOR_EMAIL=fixture@example.com",
+		"GIT_COMMITTER_NAME=Fixture User",
+		"GIT_COMMITTER_EMAIL=fixture@example.com",
+	)
+	cmd.Env = append(cmd.Env, env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %v: %w: %s", args, err, out)
+	}
+	return nil
+}
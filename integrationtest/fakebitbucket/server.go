@@ -0,0 +1,530 @@
+package fakebitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIPrefix is the path the API is served under, mirroring
+// https://api.bitbucket.org/2.0.
+const APIPrefix = "/2.0"
+
+// GitPrefix is the path repositories are served under, as
+// <GitPrefix>/<workspace>/<slug>.git.
+const GitPrefix = "/git"
+
+// DefaultPageLen caps page sizes well below Bitbucket's so every listing
+// of more than two items exercises pagination.
+const DefaultPageLen = 2
+
+// updatedSincePattern extracts the timestamp from an updated_on>"..." query.
+var updatedSincePattern = regexp.MustCompile(`updated_on\s*>\s*"([^"]+)"`)
+
+// Server serves fixtures as the Bitbucket Cloud API and the seeded
+// repositories under gitRoot over smart HTTP. Repositories created through
+// the API are added to the fixtures.
+type Server struct {
+	fixtures *Fixtures
+	gitRoot  string
+	pageLen  int
+
+	mu sync.Mutex // Guards fixtures.Repositories
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithPageLen sets the largest page the API returns.
+func WithPageLen(n int) Option {
+	return func(s *Server) {
+		s.pageLen = n
+	}
+}
+
+// New creates a Server for fixtures whose repositories were seeded into
+// gitRoot.
+func New(f *Fixtures, gitRoot string, opts ...Option) *Server {
+	s := &Server{fixtures: f, gitRoot: gitRoot, pageLen: DefaultPageLen}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Handler returns the HTTP handler for the API and git endpoints. It
+// fails if git-http-backend cannot be found.
+func (s *Server) Handler() (http.Handler, error) {
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		return nil, fmt.Errorf("locating git-http-backend: %w", err)
+	}
+	git := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		Root: GitPrefix,
+		Env:  []string{"GIT_PROJECT_ROOT=" + s.gitRoot, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(GitPrefix+"/", git)
+	mux.HandleFunc("GET "+APIPrefix+"/workspaces/{ws}", s.workspace)
+	mux.HandleFunc("GET "+APIPrefix+"/workspaces/{ws}/members", s.members)
+	mux.HandleFunc("GET "+APIPrefix+"/workspaces/{ws}/projects", s.projects)
+	mux.HandleFunc("GET "+APIPrefix+"/workspaces/{ws}/projects/{key}", s.project)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}", s.repositories)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}", s.repository)
+	mux.HandleFunc("POST "+APIPrefix+"/repositories/{ws}/{slug}", s.createRepository)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/pullrequests", s.pullRequests)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/pullrequests/{id}", s.pullRequest)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/pullrequests/{id}/comments", s.pullRequestComments)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/pullrequests/{id}/activity", s.emptyList)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/pullrequests/{id}/tasks", s.emptyList)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/issues", s.issues)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/issues/{id}", s.issue)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/issues/{id}/comments", s.issueComments)
+	mux.HandleFunc("GET "+APIPrefix+"/repositories/{ws}/{slug}/issues/{id}/changes", s.emptyList)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no fake for "+r.Method+" "+r.URL.Path)
+	})
+	return mux, nil
+}
+
+func (s *Server) workspace(w http.ResponseWriter, r *http.Request) {
+	if !s.inWorkspace(w, r) {
+		return
+	}
+	ws := s.fixtures.Workspace
+	writeJSON(w, map[string]interface{}{
+		"type":       "workspace",
+		"uuid":       uuid(ws),
+		"name":       ws,
+		"slug":       ws,
+		"is_private": true,
+		"links":      map[string]interface{}{"self": link(baseURL(r) + APIPrefix + "/workspaces/" + ws)},
+		"created_on": "2024-01-01T00:00:00+00:00",
+		"updated_on": "2024-01-01T00:00:00+00:00",
+	})
+}
+
+func (s *Server) members(w http.ResponseWriter, r *http.Request) {
+	if !s.inWorkspace(w, r) {
+		return
+	}
+	s.paginate(w, r, []interface{}{map[string]interface{}{
+		"type": "workspace_membership",
+		"user": fixtureUser(),
+	}})
+}
+
+func (s *Server) projects(w http.ResponseWriter, r *http.Request) {
+	if !s.inWorkspace(w, r) {
+		return
+	}
+	values := make([]interface{}, 0, len(s.fixtures.Projects))
+	for _, p := range s.fixtures.Projects {
+		values = append(values, s.projectJSON(r, p))
+	}
+	s.paginate(w, r, values)
+}
+
+func (s *Server) project(w http.ResponseWriter, r *http.Request) {
+	if !s.inWorkspace(w, r) {
+		return
+	}
+	for _, p := range s.fixtures.Projects {
+		if p.Key == r.PathValue("key") {
+			writeJSON(w, s.projectJSON(r, p))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "project not found")
+}
+
+func (s *Server) repositories(w http.ResponseWriter, r *http.Request) {
+	if !s.inWorkspace(w, r) {
+		return
+	}
+	repos := s.repositoryList()
+	values := make([]interface{}, 0, len(repos))
+	for _, repo := range repos {
+		values = append(values, s.repositoryJSON(r, repo))
+	}
+	s.paginate(w, r, values)
+}
+
+func (s *Server) repository(w http.ResponseWriter, r *http.Request) {
+	if repo, ok := s.findRepository(w, r); ok {
+		writeJSON(w, s.repositoryJSON(r, repo))
+	}
+}
+
+// createRepository creates an empty repository that accepts pushes, in
+// the project named in the request body, if any.
+func (s *Server) createRepository(w http.ResponseWriter, r *http.Request) {
+	if !s.inWorkspace(w, r) {
+		return
+	}
+	var body struct {
+		Description string `json:"description"`
+		Project     *struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	repo := Repository{Slug: r.PathValue("slug"), Description: body.Description}
+	if body.Project != nil {
+		repo.Project = body.Project.Key
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.fixtures.Repositories {
+		if existing.Slug == repo.Slug {
+			writeError(w, http.StatusBadRequest, "Repository with this Slug and Owner already exists.")
+			return
+		}
+	}
+	bare := filepath.Join(s.gitRoot, s.fixtures.Workspace, repo.Slug+".git")
+	if err := Git("", "init", "--quiet", "--bare", bare); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := Git(bare, "config", "http.receivepack", "true"); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.fixtures.Repositories = append(s.fixtures.Repositories, repo)
+	writeJSON(w, s.repositoryJSON(r, repo))
+}
+
+func (s *Server) pullRequests(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.findRepository(w, r)
+	if !ok {
+		return
+	}
+	state := r.URL.Query().Get("state")
+	var values []interface{}
+	for _, pr := range filterUpdated(r, repo.PullRequests) {
+		if state == "" || strings.EqualFold(pullRequestState(pr), state) {
+			values = append(values, s.pullRequestJSON(r, repo, pr))
+		}
+	}
+	s.paginate(w, r, values)
+}
+
+func (s *Server) pullRequest(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.findRepository(w, r)
+	if !ok {
+		return
+	}
+	if pr, ok := findItem(w, r, repo.PullRequests); ok {
+		writeJSON(w, s.pullRequestJSON(r, repo, pr))
+	}
+}
+
+func (s *Server) pullRequestComments(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.findRepository(w, r)
+	if !ok {
+		return
+	}
+	if pr, ok := findItem(w, r, repo.PullRequests); ok {
+		s.paginate(w, r, commentsJSON("pullrequest_comment", pr))
+	}
+}
+
+func (s *Server) issues(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.findRepository(w, r)
+	if !ok {
+		return
+	}
+	if !repo.HasIssues {
+		writeError(w, http.StatusNotFound, "repository has no issue tracker")
+		return
+	}
+	var values []interface{}
+	for _, issue := range filterUpdated(r, repo.Issues) {
+		values = append(values, s.issueJSON(r, repo, issue))
+	}
+	s.paginate(w, r, values)
+}
+
+func (s *Server) issue(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.findRepository(w, r)
+	if !ok {
+		return
+	}
+	if issue, ok := findItem(w, r, repo.Issues); ok {
+		writeJSON(w, s.issueJSON(r, repo, issue))
+	}
+}
+
+func (s *Server) issueComments(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.findRepository(w, r)
+	if !ok {
+		return
+	}
+	if issue, ok := findItem(w, r, repo.Issues); ok {
+		s.paginate(w, r, commentsJSON("issue_comment", issue))
+	}
+}
+
+func (s *Server) emptyList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.findRepository(w, r); ok {
+		s.paginate(w, r, nil)
+	}
+}
+
+// inWorkspace reports whether the request is for the fixture workspace,
+// answering 404 if not.
+func (s *Server) inWorkspace(w http.ResponseWriter, r *http.Request) bool {
+	if r.PathValue("ws") != s.fixtures.Workspace {
+		writeError(w, http.StatusNotFound, "workspace not found")
+		return false
+	}
+	return true
+}
+
+func (s *Server) findRepository(w http.ResponseWriter, r *http.Request) (Repository, bool) {
+	if !s.inWorkspace(w, r) {
+		return Repository{}, false
+	}
+	for _, repo := range s.repositoryList() {
+		if repo.Slug == r.PathValue("slug") {
+			return repo, true
+		}
+	}
+	writeError(w, http.StatusNotFound, "repository not found")
+	return Repository{}, false
+}
+
+// repositoryList returns the fixture repositories and those created since.
+func (s *Server) repositoryList() []Repository {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Repository(nil), s.fixtures.Repositories...)
+}
+
+func findItem(w http.ResponseWriter, r *http.Request, items []Item) (Item, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		for _, item := range items {
+			if item.ID == id {
+				return item, true
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "not found")
+	return Item{}, false
+}
+
+// filterUpdated applies a q=updated_on>"..." filter, as incremental runs
+// send, to items.
+func filterUpdated(r *http.Request, items []Item) []Item {
+	m := updatedSincePattern.FindStringSubmatch(r.URL.Query().Get("q"))
+	if m == nil {
+		return items
+	}
+	since, err := time.Parse(time.RFC3339, m[1])
+	if err != nil {
+		return items
+	}
+	var filtered []Item
+	for _, item := range items {
+		if t, err := time.Parse(time.RFC3339, item.UpdatedOn); err == nil && t.After(since) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// paginate writes the page of values the request asks for, linking to the
+// next one the way Bitbucket does.
+func (s *Server) paginate(w http.ResponseWriter, r *http.Request, values []interface{}) {
+	query := r.URL.Query()
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageLen, err := strconv.Atoi(query.Get("pagelen"))
+	if err != nil || pageLen < 1 || pageLen > s.pageLen {
+		pageLen = s.pageLen
+	}
+
+	start := min((page-1)*pageLen, len(values))
+	end := min(start+pageLen, len(values))
+	body := map[string]interface{}{
+		"size":    len(values),
+		"page":    page,
+		"pagelen": pageLen,
+		"values":  append([]interface{}{}, values[start:end]...),
+	}
+	if end < len(values) {
+		query.Set("page", strconv.Itoa(page+1))
+		next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		body["next"] = baseURL(r) + next.String()
+	}
+	writeJSON(w, body)
+}
+
+func (s *Server) projectJSON(r *http.Request, p Project) map[string]interface{} {
+	return map[string]interface{}{
+		"type":  "project",
+		"uuid":  uuid("project/" + p.Key),
+		"key":   p.Key,
+		"name":  p.Name,
+		"links": map[string]interface{}{"self": link(baseURL(r) + APIPrefix + "/workspaces/" + s.fixtures.Workspace + "/projects/" + p.Key)},
+	}
+}
+
+func (s *Server) repositoryJSON(r *http.Request, repo Repository) map[string]interface{} {
+	base := baseURL(r)
+	fullName := s.fixtures.Owner(repo) + "/" + repo.Slug
+	v := map[string]interface{}{
+		"type":        "repository",
+		"uuid":        uuid("repository/" + repo.Slug),
+		"name":        repo.Slug,
+		"slug":        repo.Slug,
+		"full_name":   fullName,
+		"description": repo.Description,
+		"is_private":  true,
+		"scm":         "git",
+		"has_issues":  repo.HasIssues,
+		"has_wiki":    len(repo.Wiki) > 0,
+		"mainbranch":  map[string]interface{}{"type": "branch", "name": "main"},
+		"owner":       fixtureUser(),
+		"links": map[string]interface{}{
+			"self":  link(base + APIPrefix + "/repositories/" + fullName),
+			"html":  link(base + "/" + fullName),
+			"clone": []interface{}{map[string]interface{}{"name": "https", "href": base + GitPrefix + "/" + fullName + ".git"}},
+		},
+		"created_on": "2024-01-01T00:00:00+00:00",
+		"updated_on": "2024-01-01T00:00:00+00:00",
+	}
+	for _, p := range s.fixtures.Projects {
+		if p.Key == repo.Project {
+			v["project"] = s.projectJSON(r, p)
+		}
+	}
+	return v
+}
+
+func (s *Server) pullRequestJSON(r *http.Request, repo Repository, pr Item) map[string]interface{} {
+	return map[string]interface{}{
+		"type":          "pullrequest",
+		"id":            pr.ID,
+		"title":         pr.Title,
+		"description":   pr.Title,
+		"state":         pullRequestState(pr),
+		"author":        fixtureUser(),
+		"source":        map[string]interface{}{"branch": map[string]interface{}{"name": fmt.Sprintf("feature-%d", pr.ID)}},
+		"destination":   map[string]interface{}{"branch": map[string]interface{}{"name": "main"}},
+		"comment_count": len(pr.Comments),
+		"created_on":    pr.UpdatedOn,
+		"updated_on":    pr.UpdatedOn,
+		"links": map[string]interface{}{
+			"self": link(fmt.Sprintf("%s%s/repositories/%s/%s/pullrequests/%d", baseURL(r), APIPrefix, s.fixtures.Workspace, repo.Slug, pr.ID)),
+		},
+	}
+}
+
+func (s *Server) issueJSON(r *http.Request, repo Repository, issue Item) map[string]interface{} {
+	state := issue.State
+	if state == "" {
+		state = "new"
+	}
+	return map[string]interface{}{
+		"type":       "issue",
+		"id":         issue.ID,
+		"title":      issue.Title,
+		"state":      state,
+		"kind":       "bug",
+		"priority":   "major",
+		"content":    map[string]interface{}{"raw": issue.Title},
+		"reporter":   fixtureUser(),
+		"created_on": issue.UpdatedOn,
+		"updated_on": issue.UpdatedOn,
+		"links": map[string]interface{}{
+			"self": link(fmt.Sprintf("%s%s/repositories/%s/%s/issues/%d", baseURL(r), APIPrefix, s.fixtures.Workspace, repo.Slug, issue.ID)),
+		},
+	}
+}
+
+func commentsJSON(kind string, item Item) []interface{} {
+	values := make([]interface{}, 0, len(item.Comments))
+	for i, text := range item.Comments {
+		values = append(values, map[string]interface{}{
+			"type":       kind,
+			"id":         item.ID*100 + i + 1,
+			"content":    map[string]interface{}{"raw": text},
+			"user":       fixtureUser(),
+			"created_on": item.UpdatedOn,
+			"updated_on": item.UpdatedOn,
+		})
+	}
+	return values
+}
+
+func pullRequestState(pr Item) string {
+	if pr.State == "" {
+		return "OPEN"
+	}
+	return strings.ToUpper(pr.State)
+}
+
+func fixtureUser() map[string]interface{} {
+	return map[string]interface{}{
+		"type":         "user",
+		"uuid":         uuid("user/fixture"),
+		"display_name": "Fixture User",
+		"nickname":     "fixture",
+	}
+}
+
+// uuid returns a stable Bitbucket-style UUID for name.
+func uuid(name string) string {
+	var sum uint64
+	for _, c := range name {
+		sum = sum*31 + uint64(c)
+	}
+	return fmt.Sprintf("{00000000-0000-4000-8000-%012x}", sum&0xffffffffffff)
+}
+
+func link(href string) map[string]interface{} {
+	return map[string]interface{}{"href": href}
+}
+
+// baseURL returns the scheme and host the request was made to, so links
+// work however the server is reached (httptest, docker-compose, ...).
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]interface{}{"message": msg},
+	})
+}
@@ -0,0 +1,505 @@
+//go:build integration
+
+// Package integrationtest runs the bb-backup binary end to end against a
+// fake Bitbucket: backup, incremental backup, verify, prune, and restore.
+//
+//	go test -tags=integration ./integrationtest/...
+//
+// The fake is started in-process unless BB_BACKUP_INTEGRATION_URL points at
+// one already running, e.g. from docker-compose.yml.
+package integrationtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/integrationtest/fakebitbucket"
+)
+
+// URLEnvVar points the tests at an external fake Bitbucket server.
+const URLEnvVar = "BB_BACKUP_INTEGRATION_URL"
+
+// binary is the bb-backup build under test.
+var binary string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "bb-backup-integration-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	binary = filepath.Join(dir, "bb-backup")
+	build := exec.Command("go", "build", "-o", binary, "../cmd/bb-backup")
+	if out, err := build.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "building bb-backup: %v\n%s", err, out)
+		os.Exit(1)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// harness is one scenario's fake server and backup workspace.
+type harness struct {
+	t        *testing.T
+	server   string // Base URL of the fake, without a trailing slash
+	storage  string
+	config   string
+	fixtures *fakebitbucket.Fixtures
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	fixtures, err := fakebitbucket.LoadFixtures(filepath.Join("testdata", "fixtures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, storage: t.TempDir(), fixtures: fixtures}
+
+	if u := os.Getenv(URLEnvVar); u != "" {
+		h.server = strings.TrimRight(u, "/")
+	} else {
+		gitRoot := t.TempDir()
+		if err := fakebitbucket.Seed(fixtures, gitRoot); err != nil {
+			t.Fatal(err)
+		}
+		handler, err := fakebitbucket.New(fixtures, gitRoot).Handler()
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		h.server = srv.URL
+	}
+
+	h.config = filepath.Join(t.TempDir(), "bb-backup.yaml")
+	cfg := fmt.Sprintf(`workspace: %q
+auth:
+  method: api_token
+  username: integration
+  email: integration@example.com
+  api_token: not-a-real-token
+storage:
+  type: local
+  path: %q
+rate_limit:
+  requests_per_hour: 360000
+  burst_size: 100
+backup:
+  include_prs: true
+  include_pr_comments: true
+  include_pr_activity: true
+  include_issues: true
+  include_issue_comments: true
+  include_wikis: true
+retention:
+  keep_days: 30
+logging:
+  level: info
+`, fixtures.Workspace, h.storage)
+	if err := os.WriteFile(h.config, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// run runs bb-backup with the harness's config and returns its stdout.
+func (h *harness) run(args ...string) (string, error) {
+	h.t.Helper()
+	return h.runWithStdin("", args...)
+}
+
+// runWithStdin runs bb-backup with stdin as its standard input.
+func (h *harness) runWithStdin(stdin string, args ...string) (string, error) {
+	h.t.Helper()
+	cmd := exec.Command(binary, append([]string{"-c", h.config}, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Env = append(os.Environ(), "BB_BACKUP_API_URL="+h.server+fakebitbucket.APIPrefix, "BB_BACKUP_FAULTS=")
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		h.t.Logf("bb-backup %s: %v\nstdout:\n%s\nstderr:\n%s", strings.Join(args, " "), err, stdout.String(), stderr.String())
+	}
+	return stdout.String(), err
+}
+
+// mustRun runs bb-backup and fails the test if it fails.
+func (h *harness) mustRun(args ...string) string {
+	h.t.Helper()
+	out, err := h.run(args...)
+	if err != nil {
+		h.t.Fatalf("bb-backup %s failed", strings.Join(args, " "))
+	}
+	return out
+}
+
+func (h *harness) workspaceDir() string {
+	return filepath.Join(h.storage, h.fixtures.Workspace)
+}
+
+// repoDir returns a repository's directory in latest/. Personal
+// repositories are stored under their owner.
+func (h *harness) repoDir(repo fakebitbucket.Repository) string {
+	if repo.Project == "" {
+		return filepath.Join(h.workspaceDir(), "latest", "personal", h.fixtures.Owner(repo), "repositories", repo.Slug)
+	}
+	return filepath.Join(h.workspaceDir(), "latest", "projects", repo.Project, "repositories", repo.Slug)
+}
+
+// ageRun rewrites the started_at recorded in a run's manifest and report,
+// which prune trusts over the run directory's name.
+func (h *harness) ageRun(run string, started time.Time) {
+	h.t.Helper()
+	rewritten := 0
+	for _, name := range []string{"manifest.json", "report.json"} {
+		path := filepath.Join(h.workspaceDir(), run, name)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			h.t.Fatalf("parsing %s: %v", path, err)
+		}
+		doc["started_at"] = started.Format(time.RFC3339)
+		if data, err = json.Marshal(doc); err != nil {
+			h.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			h.t.Fatal(err)
+		}
+		rewritten++
+	}
+	if rewritten == 0 {
+		h.t.Fatalf("run %s has no manifest or report to age", run)
+	}
+}
+
+// remote returns a repository's clone URL on the fake.
+func (h *harness) remote(slug string) string {
+	return h.server + fakebitbucket.GitPrefix + "/" + h.fixtures.Workspace + "/" + slug + ".git"
+}
+
+// runs returns the run directories in the workspace, oldest first.
+func (h *harness) runs() []string {
+	h.t.Helper()
+	entries, err := os.ReadDir(h.workspaceDir())
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	var runs []string
+	for _, e := range entries {
+		if e.IsDir() && len(e.Name()) > 4 && e.Name()[4] == '-' && !strings.HasPrefix(e.Name(), "latest") {
+			runs = append(runs, e.Name())
+		}
+	}
+	return runs
+}
+
+func TestBackupVerifyPruneRestore(t *testing.T) {
+	h := newHarness(t)
+	core := h.fixtures.Repositories[0]
+
+	// Full backup of every fixture repository and its metadata
+	h.mustRun("backup", "--full")
+	for _, repo := range h.fixtures.Repositories {
+		dir := h.repoDir(repo)
+		if _, err := os.Stat(filepath.Join(dir, "repository.json")); err != nil {
+			t.Errorf("%s: repository.json missing: %v", repo.Slug, err)
+		}
+		assertSameRefs(t, h.remote(repo.Slug), filepath.Join(dir, "repo.git"))
+		if len(repo.Wiki) > 0 {
+			assertSameRefs(t, h.remote(repo.Slug)+"/wiki", filepath.Join(dir, "wiki.git"))
+		} else if _, err := os.Stat(filepath.Join(dir, "wiki.git")); !os.IsNotExist(err) {
+			t.Errorf("%s: wiki.git exists for a repository without a wiki", repo.Slug)
+		}
+	}
+	for _, pr := range core.PullRequests {
+		assertFileExists(t, filepath.Join(h.repoDir(core), "pull-requests", fmt.Sprint(pr.ID)+".json"))
+	}
+	for _, issue := range core.Issues {
+		assertFileExists(t, filepath.Join(h.repoDir(core), "issues", fmt.Sprint(issue.ID)+".json"))
+	}
+
+	// A push between runs reaches latest/ through an incremental run
+	message := "Integration change " + time.Now().UTC().Format(time.RFC3339Nano)
+	pushCommit(t, h.remote(core.Slug), message)
+	time.Sleep(time.Second) // Run IDs are to the second
+	h.mustRun("backup")
+	assertSameRefs(t, h.remote(core.Slug), filepath.Join(h.repoDir(core), "repo.git"))
+	if runs := h.runs(); len(runs) != 2 {
+		t.Fatalf("expected 2 runs after two backups, got %v", runs)
+	}
+
+	verify := h.verify()
+	if !verify.Valid {
+		t.Fatalf("verify after backups is invalid: %+v", verify)
+	}
+	if verify.State == nil || verify.State.Tracked != len(h.fixtures.Repositories) {
+		t.Errorf("verify state check = %+v, want %d tracked repositories", verify.State, len(h.fixtures.Repositories))
+	}
+
+	// Age the first run past retention.keep_days; prune removes it and
+	// leaves the newest run and latest/ alone
+	runs := h.runs()
+	h.ageRun(runs[0], time.Now().UTC().AddDate(0, 0, -60))
+	var pruned struct {
+		Runs int `json:"runs_removed"`
+	}
+	if err := json.Unmarshal([]byte(h.mustRun("prune", "--json")), &pruned); err != nil {
+		t.Fatalf("parsing prune output: %v", err)
+	}
+	if pruned.Runs != 1 {
+		t.Errorf("prune removed %d runs, want 1", pruned.Runs)
+	}
+	if got := h.runs(); len(got) != 1 || got[0] != runs[1] {
+		t.Errorf("runs after prune = %v, want [%s]", got, runs[1])
+	}
+	assertFileExists(t, filepath.Join(h.workspaceDir(), "prune-audit.ndjson"))
+	if verify := h.verify(); !verify.Valid {
+		t.Fatalf("verify after prune is invalid: %+v", verify)
+	}
+
+	// Restore: clone the mirror, then push it to a new remote
+	mirror := filepath.Join(h.repoDir(core), "repo.git")
+	work := filepath.Join(t.TempDir(), core.Slug)
+	git(t, "", "clone", "--quiet", mirror, work)
+	if log := gitOutput(t, work, "log", "--format=%s"); !strings.Contains(log, message) {
+		t.Errorf("restored clone is missing the pushed commit %q:\n%s", message, log)
+	}
+	newRemote := filepath.Join(t.TempDir(), "restored.git")
+	git(t, "", "init", "--quiet", "--bare", newRemote)
+	git(t, mirror, "push", "--quiet", "--mirror", newRemote)
+	assertSameRefs(t, h.remote(core.Slug), newRemote)
+}
+
+func TestBackupRerunIsIncremental(t *testing.T) {
+	h := newHarness(t)
+	h.mustRun("backup")
+	time.Sleep(time.Second)
+	h.mustRun("backup")
+
+	// Nothing changed, so the second run fetched no pull requests
+	runs := h.runs()
+	core := h.fixtures.Repositories[0]
+	dir := filepath.Join(h.workspaceDir(), runs[len(runs)-1], "projects", core.Project, "repositories", core.Slug, "pull-requests")
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		t.Errorf("incremental run saved %d pull request files, want none", len(entries))
+	}
+	if verify := h.verify(); !verify.Valid {
+		t.Fatalf("verify is invalid: %+v", verify)
+	}
+}
+
+func TestBackupReposFromStdin(t *testing.T) {
+	h := newHarness(t)
+	if _, err := h.runWithStdin("core-worker\n# not today: core-api\ndotfiles\n", "backup", "--repos", "-"); err != nil {
+		t.Fatal("bb-backup backup --repos - failed")
+	}
+	for _, repo := range h.fixtures.Repositories {
+		_, err := os.Stat(filepath.Join(h.repoDir(repo), "repo.git"))
+		listed := repo.Slug == "core-worker" || repo.Slug == "dotfiles"
+		if listed && err != nil {
+			t.Errorf("%s was listed but not backed up: %v", repo.Slug, err)
+		}
+		if !listed && err == nil {
+			t.Errorf("%s was backed up but not listed", repo.Slug)
+		}
+	}
+
+	// An empty list is refused rather than backing up everything
+	if _, err := h.runWithStdin("\n", "backup", "--repos", "-"); err == nil {
+		t.Error("backup with an empty --repos list succeeded")
+	}
+}
+
+func TestBundleDeltaShipsNewCommits(t *testing.T) {
+	h := newHarness(t)
+	core := h.fixtures.Repositories[0]
+	h.mustRun("backup", "--full")
+	// Keep an offsite copy of the mirror as of the first run
+	offsite := filepath.Join(t.TempDir(), "offsite.git")
+	git(t, "", "clone", "--quiet", "--mirror", filepath.Join(h.repoDir(core), "repo.git"), offsite)
+
+	message := "Delta change " + time.Now().UTC().Format(time.RFC3339Nano)
+	pushCommit(t, h.remote(core.Slug), message)
+	time.Sleep(time.Second)
+	h.mustRun("backup")
+
+	runs := h.runs()
+	out := filepath.Join(t.TempDir(), "delta")
+	var delta struct {
+		Repositories []struct {
+			Slug   string `json:"slug"`
+			Bundle string `json:"bundle"`
+		} `json:"repositories"`
+	}
+	if err := json.Unmarshal([]byte(h.mustRun("bundle-delta", runs[0], runs[1], "--output", out, "--json")), &delta); err != nil {
+		t.Fatalf("parsing bundle-delta output: %v", err)
+	}
+	bundles := 0
+	for _, r := range delta.Repositories {
+		if r.Bundle == "" {
+			continue
+		}
+		bundles++
+		if r.Slug != core.Slug {
+			t.Errorf("%s has a bundle but did not change", r.Slug)
+			continue
+		}
+		git(t, offsite, "fetch", "--quiet", filepath.Join(out, r.Bundle), "+refs/*:refs/*")
+	}
+	if bundles != 1 {
+		t.Fatalf("bundle-delta wrote %d bundles, want 1: %+v", bundles, delta)
+	}
+	assertSameRefs(t, h.remote(core.Slug), offsite)
+}
+
+// restorePlan is the part of `restore --json` the tests check.
+type restorePlan struct {
+	Repositories []struct {
+		Slug        string `json:"slug"`
+		Exists      bool   `json:"exists"`
+		Action      string `json:"action"`
+		Target      string `json:"target"`
+		RefsUpdated int    `json:"refs_updated"`
+		Restored    bool   `json:"restored"`
+	} `json:"repositories"`
+}
+
+func TestRestoreCollisions(t *testing.T) {
+	h := newHarness(t)
+	core := h.fixtures.Repositories[0]
+	h.mustRun("backup", "--full")
+	mirror := filepath.Join(h.repoDir(core), "repo.git")
+
+	// The live repository moves on after the backup
+	pushCommit(t, h.remote(core.Slug), "After the backup")
+
+	parse := func(out string) restorePlan {
+		t.Helper()
+		var plan restorePlan
+		if err := json.Unmarshal([]byte(out), &plan); err != nil {
+			t.Fatalf("parsing restore output: %v\n%s", err, out)
+		}
+		return plan
+	}
+
+	// Every repository survived, so the plan skips them all by default
+	plan := parse(h.mustRun("restore", "--plan", "--json"))
+	if len(plan.Repositories) != len(h.fixtures.Repositories) {
+		t.Fatalf("plan has %d repositories, want %d", len(plan.Repositories), len(h.fixtures.Repositories))
+	}
+	for _, a := range plan.Repositories {
+		if !a.Exists || a.Action != "skip" {
+			t.Errorf("%s: planned %q (exists %v), want a skipped collision", a.Slug, a.Action, a.Exists)
+		}
+	}
+
+	// An overwrite is planned with the branch it would move back
+	plan = parse(h.mustRun("restore", "--plan", "--json", "--repo", core.Slug, "--policy", core.Slug+"=overwrite-refs"))
+	if len(plan.Repositories) != 1 || plan.Repositories[0].RefsUpdated != 1 {
+		t.Errorf("overwrite plan = %+v, want one updated ref", plan.Repositories)
+	}
+
+	// A prefix policy restores next to the live repository
+	plan = parse(h.mustRun("restore", "--json", "--repo", core.Slug, "--on-conflict", "prefix", "--prefix", "recovered-"))
+	if len(plan.Repositories) != 1 || !plan.Repositories[0].Restored || plan.Repositories[0].Target != "recovered-"+core.Slug {
+		t.Fatalf("prefix restore = %+v", plan.Repositories)
+	}
+	assertSameRefs(t, mirror, h.remote("recovered-"+core.Slug))
+
+	// Asked interactively, overwriting puts the live repository back
+	if _, err := h.runWithStdin("o\n", "restore", "--repo", core.Slug, "--on-conflict", "ask"); err != nil {
+		t.Fatal("interactive restore failed")
+	}
+	assertSameRefs(t, mirror, h.remote(core.Slug))
+}
+
+// verifyResult is the part of `verify --json` the tests check.
+type verifyResult struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+	State  *struct {
+		Tracked  int      `json:"tracked"`
+		Problems []string `json:"problems"`
+	} `json:"state"`
+}
+
+// verify runs verify on the workspace directory.
+func (h *harness) verify() verifyResult {
+	h.t.Helper()
+	out, _ := h.run("verify", "--json", h.workspaceDir())
+	var result verifyResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		h.t.Fatalf("parsing verify output: %v\n%s", err, out)
+	}
+	return result
+}
+
+// pushCommit adds a commit to the remote repository over HTTP.
+func pushCommit(t *testing.T, remote, message string) {
+	t.Helper()
+	work := filepath.Join(t.TempDir(), "push")
+	git(t, "", "clone", "--quiet", remote, work)
+	if err := os.WriteFile(filepath.Join(work, "CHANGES"), []byte(message+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, work, "commit", "--quiet", "-am", message)
+	git(t, work, "push", "--quiet", "origin", "HEAD")
+}
+
+// assertSameRefs fails unless the two repositories have identical branches
+// and tags.
+func assertSameRefs(t *testing.T, want, got string) {
+	t.Helper()
+	refs := func(repo string) string {
+		var lines []string
+		for _, line := range strings.Split(gitOutput(t, "", "ls-remote", "--heads", "--tags", repo), "\n") {
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	if w, g := refs(want), refs(got); w != g {
+		t.Errorf("refs of %s differ from %s:\nwant:\n%s\ngot:\n%s", got, want, w, g)
+	}
+}
+
+func assertFileExists(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected %s: %v", path, err)
+	}
+}
+
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	if err := fakebitbucket.Git(dir, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %v: %v", args, err)
+	}
+	return string(out)
+}
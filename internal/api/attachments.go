@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// bitbucketHosts serve images and attachments embedded in pull request
+// and issue descriptions.
+var bitbucketHosts = []string{"bitbucket.org", "api.bitbucket.org", "bytebucket.org"}
+
+// TrustedURL reports whether rawURL is served by Bitbucket (or by the
+// client's API host), so the client's credentials may be sent to it.
+func (c *Client) TrustedURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if base, err := url.Parse(c.baseURL); err == nil && strings.EqualFold(base.Hostname(), host) {
+		return true
+	}
+	if u.Scheme != "https" {
+		return false
+	}
+	for _, h := range bitbucketHosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// GetURL downloads an absolute URL, such as an image embedded in a pull
+// request description, with the client's credentials, rate limiting, and
+// retries. URLs that are not TrustedURL are refused.
+func (c *Client) GetURL(ctx context.Context, rawURL string) ([]byte, error) {
+	rawURL = c.apiURL(rawURL)
+	if !c.TrustedURL(rawURL) {
+		return nil, fmt.Errorf("refusing to send credentials to %s: not a Bitbucket URL", rawURL)
+	}
+	return c.doAccept(ctx, http.MethodGet, rawURL, "*/*", nil)
+}
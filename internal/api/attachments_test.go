@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestTrustedURL(t *testing.T) {
+	c := NewClient(&config.Config{}, WithBaseURL("http://127.0.0.1:8080/2.0"))
+	for rawURL, want := range map[string]bool{
+		"https://bitbucket.org/repo/abc/images/1-shot.png":         true,
+		"https://BITBUCKET.org/ws/repo/issues/attachments/1/a.txt": true,
+		"https://bytebucket.org/ws/repo/raw/main/logo.png":         true,
+		"http://bitbucket.org/repo/abc/images/1-shot.png":          false,
+		"https://bitbucket.org.example.com/images/x.png":           false,
+		"https://example.com/images/x.png":                         false,
+		"http://127.0.0.1:8080/ws/repo/images/x.png":               true,
+		"ftp://bitbucket.org/x":                                    false,
+	} {
+		if got := c.TrustedURL(rawURL); got != want {
+			t.Errorf("TrustedURL(%q) = %v, want %v", rawURL, got, want)
+		}
+	}
+}
+
+func TestGetURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "*/*" {
+			t.Errorf("Accept = %q", r.Header.Get("Accept"))
+		}
+		_, _ = w.Write([]byte("\x89PNG"))
+	}))
+	defer server.Close()
+
+	c := NewClient(config.Default(), WithBaseURL(server.URL))
+	data, err := c.GetURL(context.Background(), server.URL+"/repo/1/images/shot.png")
+	if err != nil || string(data) != "\x89PNG" {
+		t.Fatalf("GetURL = %q, %v", data, err)
+	}
+	if _, err := c.GetURL(context.Background(), "https://example.com/images/x.png"); err == nil {
+		t.Error("expected an error for a URL outside Bitbucket")
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"sync/atomic"
+)
+
+// cacheablePaths match resources that don't change meaningfully within a
+// run, so their GET responses are fetched once per client: workspaces,
+// projects, and user profiles.
+var cacheablePaths = []*regexp.Regexp{
+	regexp.MustCompile(`^/workspaces/[^/?]+$`),
+	regexp.MustCompile(`^/workspaces/[^/?]+/projects/[^/?]+$`),
+	regexp.MustCompile(`^/users/[^/?]+$`),
+	regexp.MustCompile(`^/user$`),
+}
+
+// isCacheable reports whether GET responses for path may be cached.
+func isCacheable(path string) bool {
+	for _, re := range cacheablePaths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseCache keeps the bodies of successful GETs for cacheable paths
+// and collapses concurrent requests for the same URL into one. Errors are
+// shared with requests waiting on the same call but not cached; a caller
+// whose context ends does not fail the others (see get).
+type responseCache struct {
+	mu       sync.Mutex
+	entries  map[string][]byte
+	inflight map[string]*cacheCall
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+// cacheCall is a request in flight that other callers can wait on.
+type cacheCall struct {
+	done chan struct{}
+	body []byte
+	err  error
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{
+		entries:  make(map[string][]byte),
+		inflight: make(map[string]*cacheCall),
+	}
+}
+
+// get returns the cached body for key, waits for an identical request in
+// flight, or calls fetch. Callers get their own copy of the body. A waiter
+// stops waiting when its own ctx is done, and fetches again itself when
+// the request it waited on failed only because that caller's context
+// ended.
+func (rc *responseCache) get(ctx context.Context, key string, fetch func() ([]byte, error)) ([]byte, error) {
+	for {
+		rc.mu.Lock()
+		if body, ok := rc.entries[key]; ok {
+			rc.mu.Unlock()
+			rc.hits.Add(1)
+			return append([]byte(nil), body...), nil
+		}
+		if call, ok := rc.inflight[key]; ok {
+			rc.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if isContextError(call.err) && ctx.Err() == nil {
+				continue
+			}
+			rc.hits.Add(1)
+			return append([]byte(nil), call.body...), call.err
+		}
+		call := &cacheCall{done: make(chan struct{})}
+		rc.inflight[key] = call
+		rc.mu.Unlock()
+		rc.misses.Add(1)
+
+		call.body, call.err = fetch()
+
+		rc.mu.Lock()
+		delete(rc.inflight, key)
+		if call.err == nil {
+			rc.entries[key] = call.body
+		}
+		rc.mu.Unlock()
+		close(call.done)
+		return append([]byte(nil), call.body...), call.err
+	}
+}
+
+// isContextError reports whether err comes from a canceled or expired
+// context rather than from the API.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// CacheStats returns how many cacheable GETs were answered from the
+// in-run cache (or by joining an identical request in flight) and how
+// many went to the API.
+func (c *Client) CacheStats() (hits, misses int64) {
+	if c.cache == nil {
+		return 0, 0
+	}
+	return c.cache.hits.Load(), c.cache.misses.Load()
+}
+
+// WithoutResponseCache disables the in-run cache, for callers such as
+// long-lived watchers whose client outlives a single run.
+func WithoutResponseCache() ClientOption {
+	return func(c *Client) {
+		c.cache = nil
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsCacheable(t *testing.T) {
+	tests := map[string]bool{
+		"/workspaces/ws":                        true,
+		"/workspaces/ws/projects/CORE":          true,
+		"/users/{1234}":                         true,
+		"/user":                                 true,
+		"/workspaces/ws/projects":               false,
+		"/workspaces/ws/members":                false,
+		"/repositories/ws/api":                  false,
+		"/repositories/ws/api/pullrequests":     false,
+		"/workspaces/ws/projects/CORE?fields=x": false,
+	}
+	for path, want := range tests {
+		if got := isCacheable(path); got != want {
+			t.Errorf("isCacheable(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestClient_CachesImmutableGets(t *testing.T) {
+	var requests sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := requests.LoadOrStore(r.URL.Path, new(int32))
+		if atomic.AddInt32(n.(*int32), 1) == 1 && r.URL.Path == "/workspaces/flaky" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(20 * time.Millisecond) // let concurrent callers pile up
+		w.Write([]byte(`{"key": "CORE", "slug": "ws"}`))
+	}))
+	defer server.Close()
+	count := func(path string) int32 {
+		n, ok := requests.Load(path)
+		if !ok {
+			return 0
+		}
+		return atomic.LoadInt32(n.(*int32))
+	}
+
+	client := NewClient(testConfig(), WithBaseURL(server.URL))
+	ctx := context.Background()
+
+	// Concurrent identical requests collapse into one
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.GetWorkspace(ctx, "ws"); err != nil {
+				t.Errorf("GetWorkspace() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetProject(ctx, "ws", "CORE"); err != nil {
+			t.Fatalf("GetProject() error = %v", err)
+		}
+		if _, err := client.GetRepository(ctx, "ws", "api"); err != nil {
+			t.Fatalf("GetRepository() error = %v", err)
+		}
+	}
+	if count("/workspaces/ws") != 1 || count("/workspaces/ws/projects/CORE") != 1 {
+		t.Errorf("cacheable requests = %d, %d; want 1 each", count("/workspaces/ws"), count("/workspaces/ws/projects/CORE"))
+	}
+	if count("/repositories/ws/api") != 2 {
+		t.Errorf("repository requests = %d, want 2 (not cached)", count("/repositories/ws/api"))
+	}
+	if hits, misses := client.CacheStats(); hits != 8 || misses != 2 {
+		t.Errorf("CacheStats() = %d hits, %d misses; want 8, 2", hits, misses)
+	}
+
+	// Errors are not cached
+	if _, err := client.GetWorkspace(ctx, "flaky"); err == nil {
+		t.Fatal("expected the first flaky request to fail")
+	}
+	if _, err := client.GetWorkspace(ctx, "flaky"); err != nil {
+		t.Errorf("retry after an error = %v, want a fresh request", err)
+	}
+
+	uncached := NewClient(testConfig(), WithBaseURL(server.URL), WithoutResponseCache())
+	uncached.GetProject(ctx, "ws", "CORE")
+	if count("/workspaces/ws/projects/CORE") != 2 {
+		t.Error("WithoutResponseCache should fetch every time")
+	}
+}
+
+func TestResponseCache_WaiterRetriesAfterCancel(t *testing.T) {
+	rc := newResponseCache()
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	first := make(chan error, 1)
+	go func() {
+		_, err := rc.get(ctx, "key", func() ([]byte, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, fmt.Errorf("fetching: %w", ctx.Err())
+		})
+		first <- err
+	}()
+	<-started
+
+	// A waiter whose own context is fine fetches again when the caller it
+	// waited on is canceled
+	waiter := make(chan []byte, 1)
+	go func() {
+		body, err := rc.get(context.Background(), "key", func() ([]byte, error) {
+			return []byte("body"), nil
+		})
+		if err != nil {
+			t.Errorf("waiter error = %v", err)
+		}
+		waiter <- body
+	}()
+	time.Sleep(20 * time.Millisecond) // let the waiter join the call in flight
+	cancel()
+
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller error = %v, want context.Canceled", err)
+	}
+	if body := <-waiter; string(body) != "body" {
+		t.Errorf("waiter body = %q, want body", body)
+	}
+
+	// A waiter whose own context ends stops waiting
+	rc.inflight["slow"] = &cacheCall{done: make(chan struct{})}
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+	if _, err := rc.get(expired, "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expired waiter error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -1,25 +1,45 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/andy-wilson/bb-backup/internal/auth"
 	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/faults"
+	"github.com/andy-wilson/bb-backup/internal/format"
 )
 
 const (
 	// BaseURL is the Bitbucket Cloud API v2 base URL.
 	BaseURL = "https://api.bitbucket.org/2.0"
 
+	// BaseURLEnvVar overrides BaseURL for every client, so a built binary
+	// can be pointed at a mock server such as the integration tests' fake.
+	BaseURLEnvVar = "BB_BACKUP_API_URL"
+
 	// DefaultTimeout is the default HTTP request timeout.
 	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxIdleConnsPerHost is the number of keep-alive connections
+	// held open to the API host. A large backup makes tens of thousands of
+	// small requests to one host, far beyond net/http's default of 2.
+	DefaultMaxIdleConnsPerHost = 32
+
+	// DefaultIdleConnTimeout is how long an idle API connection is kept.
+	DefaultIdleConnTimeout = 90 * time.Second
 )
 
 // contextKey is a custom type for context keys to avoid collisions.
@@ -86,15 +106,25 @@ type ProgressFunc func(page int, itemsSoFar int)
 // LogFunc is called to log debug messages.
 type LogFunc func(msg string, args ...interface{})
 
-// Client is a Bitbucket Cloud API client with built-in rate limiting.
+// Client is a Bitbucket API client with built-in rate limiting. Requests
+// go to Bitbucket Cloud or, with api.type server, Data Center; the
+// endpoints that differ between them are served by its Provider.
 type Client struct {
+	// Provider serves the endpoints that differ between Cloud and Data
+	// Center, for api.type
+	Provider
+
 	httpClient   *http.Client
 	baseURL      string
-	username     string
-	password     string // password, API token, or access token
+	auth         auth.Provider
 	rateLimiter  *RateLimiter
 	progressFunc ProgressFunc
 	logFunc      LogFunc
+
+	maintenance      *maintenanceGate
+	cache            *responseCache // nil when disabled
+	rateLimitCeiling atomic.Int64   // Last X-RateLimit-Limit seen (0 = never)
+	usage            usageCounter   // Requests sent, for the run's quota report
 }
 
 // ClientOption is a function that configures a Client.
@@ -107,6 +137,14 @@ func WithHTTPClient(c *http.Client) ClientOption {
 	}
 }
 
+// WithAuthProvider sets where request credentials come from. The default
+// is auth.FromConfig for the client's config.
+func WithAuthProvider(p auth.Provider) ClientOption {
+	return func(client *Client) {
+		client.auth = p
+	}
+}
+
 // WithBaseURL sets a custom base URL (useful for testing).
 func WithBaseURL(url string) ClientOption {
 	return func(client *Client) {
@@ -128,6 +166,16 @@ func WithLogFunc(f LogFunc) ClientOption {
 	}
 }
 
+// WithFaults routes requests through a fault injector so API errors and
+// slow responses can be simulated. A nil injector leaves the client as is.
+func WithFaults(inj *faults.Injector) ClientOption {
+	return func(c *Client) {
+		if inj != nil {
+			c.httpClient.Transport = inj.Transport(c.httpClient.Transport)
+		}
+	}
+}
+
 // NewClient creates a new Bitbucket API client from configuration.
 func NewClient(cfg *config.Config, opts ...ClientOption) *Client {
 	rlConfig := RateLimiterConfig{
@@ -139,17 +187,28 @@ func NewClient(cfg *config.Config, opts ...ClientOption) *Client {
 		MaxBackoffSeconds:      cfg.RateLimit.MaxBackoffSeconds,
 	}
 
-	// Get the appropriate credentials for API calls
-	username, password := cfg.GetAPICredentials()
-
 	c := &Client{
 		httpClient: &http.Client{
-			Timeout: DefaultTimeout,
+			Timeout:   DefaultTimeout,
+			Transport: newTransport(),
 		},
-		baseURL:     BaseURL,
-		username:    username,
-		password:    password,
+		baseURL:     defaultBaseURL(cfg.API.BaseURL),
+		auth:        auth.FromConfig(cfg),
 		rateLimiter: NewRateLimiter(rlConfig),
+		cache:       newResponseCache(),
+		maintenance: &maintenanceGate{
+			budget:    time.Duration(cfg.RateLimit.MaxMaintenanceWaitMinutes) * time.Minute,
+			statusURL: cfg.RateLimit.StatusPageURL,
+			client:    &http.Client{Timeout: 10 * time.Second},
+		},
+	}
+	c.Provider = &cloudProvider{c: c}
+
+	if cfg.API.Type == config.APITypeServer {
+		// Bitbucket's status page only covers Cloud
+		c.baseURL += ServerAPIPath
+		c.maintenance.statusURL = ""
+		c.Provider = newServerProvider(c)
 	}
 
 	for _, opt := range opts {
@@ -159,6 +218,68 @@ func NewClient(cfg *config.Config, opts ...ClientOption) *Client {
 	return c
 }
 
+// defaultBaseURL returns the API base URL from BB_BACKUP_API_URL, then
+// the configured api.base_url, or BaseURL when both are unset.
+func defaultBaseURL(configured string) string {
+	if u := os.Getenv(BaseURLEnvVar); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	if configured != "" {
+		return strings.TrimRight(configured, "/")
+	}
+	return BaseURL
+}
+
+// apiURL maps an absolute URL returned by the API, such as a pagination
+// next link, onto the client's base URL. Bitbucket builds those links
+// from its own host, so following them as is would bypass a proxy.
+func (c *Client) apiURL(rawURL string) string {
+	if c.baseURL == BaseURL {
+		return rawURL
+	}
+	rest, ok := strings.CutPrefix(rawURL, BaseURL)
+	if !ok || (rest != "" && rest[0] != '/' && rest[0] != '?') {
+		return rawURL
+	}
+	return c.baseURL + rest
+}
+
+// newTransport returns the HTTP transport used for API requests, tuned for
+// many small JSON responses from a single host. Compression stays enabled so
+// net/http advertises Accept-Encoding: gzip and decodes bodies transparently;
+// requests must not set that header themselves or decoding is skipped.
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ForceAttemptHTTP2 = true
+	t.DisableCompression = false
+	t.MaxIdleConns = 2 * DefaultMaxIdleConnsPerHost
+	t.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	t.IdleConnTimeout = DefaultIdleConnTimeout
+	return t
+}
+
+// transferNote describes how a response was transferred for debug logs.
+func transferNote(resp *http.Response) string {
+	note := resp.Proto
+	if resp.Uncompressed {
+		note += ", gzip"
+	}
+	return note
+}
+
+// RateLimitCeiling returns the hourly request limit most recently reported
+// by the API in X-RateLimit-Limit, or 0 if the API has not reported one.
+func (c *Client) RateLimitCeiling() int {
+	return int(c.rateLimitCeiling.Load())
+}
+
+// observeRateLimit records the server-reported request ceiling.
+func (c *Client) observeRateLimit(resp *http.Response) {
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil && limit > 0 {
+		c.rateLimitCeiling.Store(int64(limit))
+	}
+}
+
 // RateLimiter returns the rate limiter for this client.
 // This allows other components to share the same rate limiting.
 func (c *Client) RateLimiter() *RateLimiter {
@@ -193,12 +314,47 @@ func (e *APIError) Error() string {
 	return fmt.Sprintf("bitbucket API error (status %d): %s", e.StatusCode, e.Message)
 }
 
+// ErrorCode classifies the error by its status code.
+func (e *APIError) ErrorCode() errcode.Code {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return errcode.AuthFailed
+	case e.StatusCode == http.StatusForbidden:
+		return errcode.PermissionDenied
+	case e.StatusCode == http.StatusNotFound:
+		return errcode.NotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return errcode.RateLimited
+	case e.StatusCode >= 500:
+		return errcode.APIUnavailable
+	}
+	return errcode.APIError
+}
+
 // Get performs a GET request to the given path.
 // The path should be relative to the API base URL (e.g., "/workspaces/myworkspace").
+// Workspaces, projects, and user profiles are fetched once per client.
 func (c *Client) Get(ctx context.Context, path string) ([]byte, error) {
+	if c.cache != nil && isCacheable(path) {
+		return c.cache.get(ctx, c.baseURL+path, func() ([]byte, error) {
+			return c.do(ctx, http.MethodGet, path, nil)
+		})
+	}
 	return c.do(ctx, http.MethodGet, path, nil)
 }
 
+// Post sends payload as JSON to the given path and returns the response
+// body.
+func (c *Client) Post(ctx context.Context, path string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding request: %w", err)
+	}
+	header := http.Header{"Accept": {"application/json"}, "Content-Type": {"application/json"}}
+	body, _, err := c.doRequest(ctx, http.MethodPost, c.baseURL+path, header, bytes.NewReader(data))
+	return body, err
+}
+
 // GetPaginated fetches all pages of a paginated endpoint and returns all values.
 // Uses streaming JSON decoding to reduce memory allocations.
 func (c *Client) GetPaginated(ctx context.Context, path string) ([]json.RawMessage, error) {
@@ -228,22 +384,65 @@ func (c *Client) GetPaginated(ctx context.Context, path string) ([]json.RawMessa
 			c.progressFunc(page, len(allValues))
 		}
 
-		currentURL = nextURL
+		next, err := c.nextPageURL(currentURL, nextURL)
+		if err != nil {
+			return nil, err
+		}
+		currentURL = next
 	}
 
 	return allValues, nil
 }
 
+// ErrUntrustedNextLink is returned when a paginated response links to a
+// next page outside the client's base URL.
+var ErrUntrustedNextLink = errors.New("pagination link leaves the API base URL")
+
+// nextPageURL checks a page's next link before it is followed, so a
+// misbehaving or compromised response cannot send credentials elsewhere.
+// Links naming Bitbucket are first rewritten through the base URL; the
+// result must then share its scheme and host and stay under its path, and
+// must not carry credentials or point back at the current page.
+func (c *Client) nextPageURL(currentURL, next string) (string, error) {
+	if next == "" {
+		return "", nil
+	}
+	base, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing base URL: %w", err)
+	}
+	u, err := base.Parse(c.apiURL(next))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrUntrustedNextLink, next, err)
+	}
+	u.Fragment = ""
+	basePath := strings.TrimRight(base.Path, "/")
+	if u.Scheme != base.Scheme || !strings.EqualFold(u.Host, base.Host) || u.User != nil ||
+		(u.Path != basePath && !strings.HasPrefix(u.Path, basePath+"/")) {
+		return "", fmt.Errorf("%w: %s", ErrUntrustedNextLink, next)
+	}
+	if u.String() == currentURL {
+		return "", fmt.Errorf("pagination link repeats the current page: %s", next)
+	}
+	return u.String(), nil
+}
+
 // getPaginatedPage fetches a single page and returns values + next URL.
 // Uses streaming JSON decoding for efficiency.
 func (c *Client) getPaginatedPage(ctx context.Context, fullURL string) ([]json.RawMessage, string, error) {
 	attempt := 0
+	refreshed := false
 	prefix := workerPrefix(ctx)
 	for {
 		attempt++
 
+		// Hold off while Bitbucket is down for maintenance
+		if err := c.maintenance.wait(ctx); err != nil {
+			return nil, "", err
+		}
+
 		// Wait for rate limiter
-		c.rateLimiter.Wait()
+		c.rateLimiter.WaitPriority(GetPriority(ctx))
 
 		// Log the request
 		if c.logFunc != nil {
@@ -257,8 +456,12 @@ func (c *Client) getPaginatedPage(ctx context.Context, fullURL string) ([]json.R
 			return nil, "", fmt.Errorf("creating request: %w", err)
 		}
 
-		// Set authentication
-		req.SetBasicAuth(c.username, c.password)
+		// Set authentication; the provider may have rotated the credentials
+		creds, err := c.auth.APICredentials(ctx)
+		if err != nil {
+			return nil, "", errcode.Wrap(errcode.AuthFailed, fmt.Errorf("getting credentials: %w", err))
+		}
+		creds.Apply(req)
 		req.Header.Set("Accept", "application/json")
 
 		resp, err := c.httpClient.Do(req)
@@ -266,6 +469,8 @@ func (c *Client) getPaginatedPage(ctx context.Context, fullURL string) ([]json.R
 			return nil, "", fmt.Errorf("executing request: %w", err)
 		}
 		defer resp.Body.Close() //nolint:errcheck // closing response body
+		c.observeRateLimit(resp)
+		c.usage.record(req.URL.Path, resp)
 
 		elapsed := time.Since(startTime)
 
@@ -283,14 +488,12 @@ func (c *Client) getPaginatedPage(ctx context.Context, fullURL string) ([]json.R
 			}
 
 			// Check for Retry-After header
-			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-				if seconds, err := strconv.Atoi(retryAfter); err == nil {
-					backoff = time.Duration(seconds) * time.Second
-				}
+			if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				backoff = d
 			}
 
 			if c.logFunc != nil {
-				c.logFunc("%s  Rate limited: retry %d after %s backoff", prefix, attempt, backoff.Round(time.Second))
+				c.logFunc("%s  Rate limited: retry %d after %s backoff", prefix, attempt, format.Duration(backoff))
 			}
 
 			select {
@@ -301,6 +504,22 @@ func (c *Client) getPaginatedPage(ctx context.Context, fullURL string) ([]json.R
 			}
 		}
 
+		// Wait out maintenance rather than failing the request
+		if c.maintenance.onServerError(ctx, resp) {
+			if c.logFunc != nil {
+				c.logFunc("%s  Server unavailable (%d): retry %d after maintenance pause", prefix, resp.StatusCode, attempt)
+			}
+			continue
+		}
+
+		// Credentials may have expired mid-run; refresh once and retry
+		if resp.StatusCode == http.StatusUnauthorized && !refreshed {
+			refreshed = true
+			if c.refreshCredentials(ctx, creds) {
+				continue
+			}
+		}
+
 		// Handle other errors - need to read body for error message
 		if resp.StatusCode >= 400 {
 			respBody, _ := io.ReadAll(resp.Body)
@@ -333,9 +552,9 @@ func (c *Client) getPaginatedPage(ctx context.Context, fullURL string) ([]json.R
 
 		// Log response details
 		if c.logFunc != nil {
-			c.logFunc("%s  → %d %s (took %s, %d items)", prefix,
+			c.logFunc("%s  → %d %s (took %s, %d items, %s)", prefix,
 				resp.StatusCode, http.StatusText(resp.StatusCode),
-				elapsed.Round(time.Millisecond), len(values))
+				format.Duration(elapsed), len(values), transferNote(resp))
 
 			// Log rate limit headers if present
 			if limit := resp.Header.Get("X-RateLimit-Limit"); limit != "" {
@@ -359,13 +578,48 @@ func (c *Client) do(ctx context.Context, method, path string, body io.Reader) ([
 
 // doURL performs an HTTP request to an absolute URL.
 func (c *Client) doURL(ctx context.Context, method, fullURL string, body io.Reader) ([]byte, error) {
+	return c.doAccept(ctx, method, fullURL, "application/json", body)
+}
+
+// doAccept performs an HTTP request to an absolute URL, asking for the
+// given media type.
+func (c *Client) doAccept(ctx context.Context, method, fullURL, accept string, body io.Reader) ([]byte, error) {
+	respBody, _, err := c.doRequest(ctx, method, fullURL, http.Header{"Accept": {accept}}, body)
+	return respBody, err
+}
+
+// doRequest performs an HTTP request to an absolute URL with the given
+// headers and returns the body and the final response, whose body is
+// already closed.
+func (c *Client) doRequest(ctx context.Context, method, fullURL string, header http.Header, body io.Reader) ([]byte, *http.Response, error) {
+	return c.doRequestTo(ctx, method, fullURL, header, body, nil)
+}
+
+// doRequestTo is doRequest, except that a successful response is handed to
+// consume, when set, to read its body as it arrives instead of returning
+// it. consume returns how much it read. Error responses are read as usual.
+func (c *Client) doRequestTo(ctx context.Context, method, fullURL string, header http.Header, body io.Reader, consume func(*http.Response) (int64, error)) ([]byte, *http.Response, error) {
+	// A retried request sends the body again
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, nil, fmt.Errorf("reading request body: %w", err)
+		}
+	}
 	attempt := 0
+	refreshed := false
 	prefix := workerPrefix(ctx)
 	for {
 		attempt++
 
+		// Hold off while Bitbucket is down for maintenance
+		if err := c.maintenance.wait(ctx); err != nil {
+			return nil, nil, err
+		}
+
 		// Wait for rate limiter
-		c.rateLimiter.Wait()
+		c.rateLimiter.WaitPriority(GetPriority(ctx))
 
 		// Log the request
 		if c.logFunc != nil {
@@ -374,34 +628,53 @@ func (c *Client) doURL(ctx context.Context, method, fullURL string, body io.Read
 
 		startTime := time.Now()
 
-		req, err := http.NewRequestWithContext(ctx, method, fullURL, body)
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
 		if err != nil {
-			return nil, fmt.Errorf("creating request: %w", err)
+			return nil, nil, fmt.Errorf("creating request: %w", err)
 		}
 
-		// Set authentication
-		req.SetBasicAuth(c.username, c.password)
-		req.Header.Set("Accept", "application/json")
+		// Set authentication; the provider may have rotated the credentials
+		creds, err := c.auth.APICredentials(ctx)
+		if err != nil {
+			return nil, nil, errcode.Wrap(errcode.AuthFailed, fmt.Errorf("getting credentials: %w", err))
+		}
+		creds.Apply(req)
+		for key, values := range header {
+			req.Header[key] = values
+		}
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("executing request: %w", err)
+			return nil, nil, fmt.Errorf("executing request: %w", err)
 		}
 		defer resp.Body.Close() //nolint:errcheck // closing response body
-
-		// Read response body
-		respBody, err := io.ReadAll(resp.Body)
+		c.observeRateLimit(resp)
+		c.usage.record(req.URL.Path, resp)
+
+		// Read response body, or let consume stream it
+		var respBody []byte
+		var size int64
+		if consume != nil && resp.StatusCode < 400 {
+			size, err = consume(resp)
+		} else {
+			respBody, err = io.ReadAll(resp.Body)
+			size = int64(len(respBody))
+		}
 		if err != nil {
-			return nil, fmt.Errorf("reading response: %w", err)
+			return nil, nil, fmt.Errorf("reading response: %w", err)
 		}
 
 		elapsed := time.Since(startTime)
 
 		// Log response details
 		if c.logFunc != nil {
-			c.logFunc("%s  → %d %s (took %s, %s)", prefix,
+			c.logFunc("%s  → %d %s (took %s, %s, %s)", prefix,
 				resp.StatusCode, http.StatusText(resp.StatusCode),
-				elapsed.Round(time.Millisecond), formatBytes(len(respBody)))
+				format.Duration(elapsed), format.Bytes(size), transferNote(resp))
 
 			// Log rate limit headers if present
 			if limit := resp.Header.Get("X-RateLimit-Limit"); limit != "" {
@@ -418,41 +691,62 @@ func (c *Client) doURL(ctx context.Context, method, fullURL string, body io.Read
 				if c.logFunc != nil {
 					c.logFunc("%s  Rate limited: max retries (%d) reached, giving up", prefix, attempt)
 				}
-				return nil, &APIError{
+				return nil, nil, &APIError{
 					StatusCode: resp.StatusCode,
 					Message:    "rate limit exceeded, max retries reached",
 				}
 			}
 
 			// Check for Retry-After header
-			if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-				if seconds, err := strconv.Atoi(retryAfter); err == nil {
-					backoff = time.Duration(seconds) * time.Second
-				}
+			if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				backoff = d
 			}
 
 			if c.logFunc != nil {
-				c.logFunc("%s  Rate limited: retry %d after %s backoff", prefix, attempt, backoff.Round(time.Second))
+				c.logFunc("%s  Rate limited: retry %d after %s backoff", prefix, attempt, format.Duration(backoff))
 			}
 
 			select {
 			case <-ctx.Done():
-				return nil, ctx.Err()
+				return nil, nil, ctx.Err()
 			case <-time.After(backoff):
 				continue
 			}
 		}
 
+		// Wait out maintenance rather than failing the request
+		if c.maintenance.onServerError(ctx, resp) {
+			if c.logFunc != nil {
+				c.logFunc("%s  Server unavailable (%d): retry %d after maintenance pause", prefix, resp.StatusCode, attempt)
+			}
+			continue
+		}
+
+		// Credentials may have expired mid-run; refresh once and retry
+		if resp.StatusCode == http.StatusUnauthorized && !refreshed {
+			refreshed = true
+			if c.refreshCredentials(ctx, creds) {
+				continue
+			}
+		}
+
 		// Handle other errors
 		if resp.StatusCode >= 400 {
 			var apiErr Error
 			if err := json.Unmarshal(respBody, &apiErr); err == nil && apiErr.Error.Message != "" {
-				return nil, &APIError{
+				return nil, nil, &APIError{
 					StatusCode: resp.StatusCode,
 					Message:    apiErr.Error.Message,
 				}
 			}
-			return nil, &APIError{
+			var serverErr serverErrors
+			if err := json.Unmarshal(respBody, &serverErr); err == nil && len(serverErr.Errors) > 0 && serverErr.Errors[0].Message != "" {
+				return nil, nil, &APIError{
+					StatusCode: resp.StatusCode,
+					Message:    serverErr.Errors[0].Message,
+				}
+			}
+			return nil, nil, &APIError{
 				StatusCode: resp.StatusCode,
 				Message:    string(respBody),
 			}
@@ -460,22 +754,28 @@ func (c *Client) doURL(ctx context.Context, method, fullURL string, body io.Read
 
 		// Success
 		c.rateLimiter.OnSuccess()
-		return respBody, nil
+		return respBody, resp, nil
 	}
 }
 
-// formatBytes formats a byte count as a human-readable string.
-func formatBytes(bytes int) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
+// refreshCredentials asks the auth provider for new credentials after the
+// server rejected creds with a 401, and reports whether the request should
+// be retried.
+func (c *Client) refreshCredentials(ctx context.Context, creds auth.Credentials) bool {
+	err := auth.RefreshRejected(ctx, c.auth, creds)
+	if errors.Is(err, auth.ErrRefreshUnsupported) {
+		return false
+	}
+	if err != nil {
+		if c.logFunc != nil {
+			c.logFunc("%s  Credential refresh failed: %v", workerPrefix(ctx), err)
+		}
+		return false
 	}
-	div, exp := unit, 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	if c.logFunc != nil {
+		c.logFunc("%s  Credentials rejected (401): retrying with refreshed credentials", workerPrefix(ctx))
 	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMG"[exp])
+	return true
 }
 
 // BuildURL constructs a URL with query parameters.
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/format"
+)
+
+// DownloadChunkSize is the most a download asks for in one Range request.
+const DownloadChunkSize = 8 << 20
+
+// PartialSuffix marks a download in progress. A file with it is never a
+// complete artifact; the next download of the same file resumes it.
+const PartialSuffix = ".part"
+
+// ErrChecksumMismatch is returned when a downloaded file does not match
+// the SHA-256 it was expected to have.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrTooLarge is returned when a file is bigger than the most a download
+// accepts.
+var ErrTooLarge = errors.New("file too large")
+
+// Download fetches a trusted absolute URL into dest in chunks of
+// DownloadChunkSize using Range requests, with the client's credentials,
+// rate limiting, and retries. Data is written to dest+PartialSuffix, and a
+// partial file left by an interrupted download is resumed from its size.
+// The finished file is checked against wantSHA256 (hex) when given, else
+// against a SHA-256 the server sends in a Digest header, and only renamed
+// to dest once it is complete and verified. A file that fails the check
+// is removed so the next attempt starts over. It returns the file's size.
+func (c *Client) Download(ctx context.Context, rawURL, dest, wantSHA256 string) (int64, error) {
+	rawURL = c.apiURL(rawURL)
+	if !c.TrustedURL(rawURL) {
+		return 0, fmt.Errorf("refusing to send credentials to %s: not a Bitbucket URL", rawURL)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, fmt.Errorf("creating directory for %s: %w", dest, err)
+	}
+
+	part := dest + PartialSuffix
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("opening %s: %w", part, err)
+	}
+	defer f.Close() //nolint:errcheck // closed explicitly on success
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", part, err)
+	}
+	digest, err := c.downloadChunks(ctx, rawURL, f, offset)
+	if err != nil {
+		return 0, err
+	}
+	want := strings.ToLower(wantSHA256)
+	if want == "" {
+		want = digest
+	}
+	return finishDownload(f, rawURL, dest, want)
+}
+
+// DownloadStream fetches a trusted absolute URL into dest like Download,
+// but in one request whose body is written to disk as it arrives, so a
+// large file is never held in memory and costs a single request against
+// the rate limit. A partial file is resumed with an open-ended Range
+// request. When maxSize is positive, a file known or found to be bigger
+// fails with ErrTooLarge and its partial file is removed. The file is
+// checked against a SHA-256 from the server's Digest header, if any.
+func (c *Client) DownloadStream(ctx context.Context, rawURL, dest string, maxSize int64) (int64, error) {
+	rawURL = c.apiURL(rawURL)
+	if !c.TrustedURL(rawURL) {
+		return 0, fmt.Errorf("refusing to send credentials to %s: not a Bitbucket URL", rawURL)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, fmt.Errorf("creating directory for %s: %w", dest, err)
+	}
+
+	part := dest + PartialSuffix
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("opening %s: %w", part, err)
+	}
+	defer f.Close() //nolint:errcheck // closed explicitly on success
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", part, err)
+	}
+	digest, err := c.streamTo(ctx, rawURL, f, offset, maxSize)
+	if errors.Is(err, ErrTooLarge) {
+		_ = f.Close()
+		_ = os.Remove(part)
+		return 0, fmt.Errorf("%w: %s is over %s", ErrTooLarge, rawURL, format.Bytes(maxSize))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return finishDownload(f, rawURL, dest, digest)
+}
+
+// streamTo writes rawURL's content from offset to the end of the file
+// into f and returns the hex SHA-256 from the server's Digest header, if
+// any. A server that ignores Range sends the whole file, which replaces
+// what f held.
+func (c *Client) streamTo(ctx context.Context, rawURL string, f *os.File, offset, maxSize int64) (string, error) {
+	if maxSize > 0 && offset > maxSize {
+		return "", ErrTooLarge
+	}
+	header := http.Header{"Accept": {"*/*"}}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	var digest string
+	consume := func(resp *http.Response) (int64, error) {
+		digest = digestSHA256(resp.Header.Get("Digest"))
+		start, total := int64(0), resp.ContentLength
+		if resp.StatusCode == http.StatusPartialContent {
+			var ok bool
+			start, total, ok = parseContentRange(resp.Header.Get("Content-Range"))
+			if !ok || start != offset {
+				return 0, fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+			}
+		}
+		if maxSize > 0 && total > maxSize {
+			return 0, ErrTooLarge
+		}
+		if err := f.Truncate(start); err != nil {
+			return 0, fmt.Errorf("writing %s: %w", f.Name(), err)
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("writing %s: %w", f.Name(), err)
+		}
+		var w io.Writer = f
+		if maxSize > 0 {
+			w = &cappedWriter{w: f, left: maxSize - start}
+		}
+		return io.Copy(w, resp.Body)
+	}
+	_, _, err := c.doRequestTo(ctx, http.MethodGet, rawURL, header, nil, consume)
+	if err != nil {
+		// Asking past the end means an earlier attempt got everything
+		var apiErr *APIError
+		if offset > 0 && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return "", nil
+		}
+		return "", err
+	}
+	return digest, nil
+}
+
+// cappedWriter fails with ErrTooLarge instead of writing more than left
+// bytes.
+type cappedWriter struct {
+	w    io.Writer
+	left int64
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > c.left {
+		return 0, ErrTooLarge
+	}
+	c.left -= int64(len(p))
+	return c.w.Write(p)
+}
+
+// finishDownload closes a complete partial file, checks it against the
+// hex SHA-256 want when given, and renames it to dest. A file that fails
+// the check is removed. It returns the file's size.
+func finishDownload(f *os.File, rawURL, dest, want string) (int64, error) {
+	part := f.Name()
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("writing %s: %w", part, err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("writing %s: %w", part, err)
+	}
+
+	size, got, err := fileSHA256(part)
+	if err != nil {
+		return 0, err
+	}
+	if want != "" && got != want {
+		_ = os.Remove(part)
+		return 0, fmt.Errorf("%w: %s has sha256 %s, want %s", ErrChecksumMismatch, rawURL, got, want)
+	}
+	if err := os.Rename(part, dest); err != nil {
+		return 0, fmt.Errorf("renaming %s: %w", part, err)
+	}
+	return size, nil
+}
+
+// downloadChunks appends rawURL's content from offset to f until the end
+// of the file and returns the hex SHA-256 from the server's Digest header,
+// if any. A server that ignores Range sends the whole file, which replaces
+// what f held.
+func (c *Client) downloadChunks(ctx context.Context, rawURL string, f *os.File, offset int64) (string, error) {
+	var digest string
+	for {
+		header := http.Header{
+			"Accept": {"*/*"},
+			"Range":  {fmt.Sprintf("bytes=%d-%d", offset, offset+DownloadChunkSize-1)},
+		}
+		data, resp, err := c.doRequest(ctx, http.MethodGet, rawURL, header, nil)
+		if err != nil {
+			// Asking past the end means an earlier attempt got everything
+			var apiErr *APIError
+			if offset > 0 && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+				return digest, nil
+			}
+			return "", err
+		}
+		if d := digestSHA256(resp.Header.Get("Digest")); d != "" {
+			digest = d
+		}
+
+		if resp.StatusCode != http.StatusPartialContent {
+			if err := f.Truncate(0); err != nil {
+				return "", fmt.Errorf("writing %s: %w", f.Name(), err)
+			}
+			if _, err := f.WriteAt(data, 0); err != nil {
+				return "", fmt.Errorf("writing %s: %w", f.Name(), err)
+			}
+			return digest, nil
+		}
+
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return "", fmt.Errorf("downloading %s: unexpected Content-Range %q for offset %d", rawURL, resp.Header.Get("Content-Range"), offset)
+		}
+		if _, err := f.WriteAt(data, offset); err != nil {
+			return "", fmt.Errorf("writing %s: %w", f.Name(), err)
+		}
+		offset += int64(len(data))
+		if len(data) == 0 || (total >= 0 && offset >= total) || (total < 0 && len(data) < DownloadChunkSize) {
+			return digest, nil
+		}
+	}
+}
+
+// parseContentRange parses "bytes start-end/total" and returns start and
+// total, which is -1 when the server does not know it.
+func parseContentRange(value string) (start, total int64, ok bool) {
+	spec, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	span, size, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+	first, _, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if size == "*" {
+		return start, -1, true
+	}
+	total, err = strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
+
+// digestSHA256 returns the hex SHA-256 from a Digest header such as
+// "sha-256=<base64>", or "" when it has none.
+func digestSHA256(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		algo, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !strings.EqualFold(algo, "sha-256") {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != sha256.Size {
+			return ""
+		}
+		return hex.EncodeToString(sum)
+	}
+	return ""
+}
+
+// fileSHA256 returns the size and hex SHA-256 of a file.
+func fileSHA256(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("verifying %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck // read-only
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("verifying %s: %w", path, err)
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
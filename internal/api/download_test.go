@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestDownload_Resume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), DownloadChunkSize/5) // two chunks
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "attachments", "file.bin")
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// An earlier attempt got the first 100 bytes
+	if err := os.WriteFile(dest+PartialSuffix, content[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(content)
+	c := NewClient(config.Default(), WithBaseURL(server.URL))
+	size, err := c.Download(context.Background(), server.URL+"/file.bin", dest, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("downloaded file differs (err %v)", err)
+	}
+	if _, err := os.Stat(dest + PartialSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+	want := []string{"bytes=100-8388707", "bytes=8388708-16777315"}
+	if strings.Join(ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("ranges = %v, want %v", ranges, want)
+	}
+}
+
+func TestDownload_IgnoredRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("whole file"))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(dest+PartialSuffix, []byte("stale data from elsewhere"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(config.Default(), WithBaseURL(server.URL))
+	if _, err := c.Download(context.Background(), server.URL+"/file.txt", dest, ""); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "whole file" {
+		t.Errorf("file = %q, want the full response", got)
+	}
+}
+
+func TestDownload_ChecksumMismatch(t *testing.T) {
+	other := sha256.Sum256([]byte("something else"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(other[:]))
+		w.Write([]byte("corrupted"))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.txt")
+	c := NewClient(config.Default(), WithBaseURL(server.URL))
+	_, err := c.Download(context.Background(), server.URL+"/file.txt", dest, "")
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Download() error = %v, want ErrChecksumMismatch", err)
+	}
+	for _, p := range []string{dest, dest + PartialSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s exists after a failed check: %v", filepath.Base(p), err)
+		}
+	}
+}
+
+func TestDownload_Untrusted(t *testing.T) {
+	c := NewClient(config.Default(), WithBaseURL("http://127.0.0.1:8080/2.0"))
+	dest := filepath.Join(t.TempDir(), "file.txt")
+	if _, err := c.Download(context.Background(), "https://evil.example.com/file.txt", dest, ""); err == nil {
+		t.Error("Download() sent credentials to an untrusted host")
+	}
+}
+
+func TestDownloadStream_Resume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), DownloadChunkSize/5)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(dest+PartialSuffix, content[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(config.Default(), WithBaseURL(server.URL))
+	size, err := c.DownloadStream(context.Background(), server.URL+"/file.bin", dest, int64(len(content)))
+	if err != nil {
+		t.Fatalf("DownloadStream() error = %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("size = %d, want %d", size, len(content))
+	}
+	if got, err := os.ReadFile(dest); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("downloaded file differs (err %v)", err)
+	}
+	// One request for the rest of the file
+	if strings.Join(ranges, ",") != "bytes=100-" {
+		t.Errorf("ranges = %v, want [bytes=100-]", ranges)
+	}
+}
+
+func TestDownloadStream_TooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Content-Length, so the cap is hit while writing
+		w.(http.Flusher).Flush()
+		w.Write(bytes.Repeat([]byte("x"), 4096))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	c := NewClient(config.Default(), WithBaseURL(server.URL))
+	_, err := c.DownloadStream(context.Background(), server.URL+"/file.bin", dest, 1000)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("DownloadStream() error = %v, want ErrTooLarge", err)
+	}
+	for _, p := range []string{dest, dest + PartialSuffix} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s exists after a refused download: %v", filepath.Base(p), err)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		in           string
+		start, total int64
+		ok           bool
+	}{
+		{"bytes 0-99/200", 0, 200, true},
+		{"bytes 100-199/*", 100, -1, true},
+		{"bytes */200", 0, 0, false},
+		{"items 0-1/2", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, total, ok := parseContentRange(tt.in)
+		if start != tt.start || total != tt.total || ok != tt.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v; want %d, %d, %v", tt.in, start, total, ok, tt.start, tt.total, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// RepoDownload is a file uploaded to a repository's Downloads section,
+// such as a release artifact. It is not part of the git history.
+type RepoDownload struct {
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Downloads int    `json:"downloads"`
+	CreatedOn string `json:"created_on"`
+	User      *User  `json:"user,omitempty"`
+	Links     Links  `json:"links"`
+}
+
+// ListDownloads lists the files in a repository's Downloads section.
+// Each file's content is at Links.Self.Href.
+func (p *cloudProvider) ListDownloads(ctx context.Context, workspace, repoSlug string) ([]RepoDownload, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := fmt.Sprintf("/repositories/%s/%s/downloads", workspace, repoSlug)
+	values, err := p.c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching downloads for %s/%s: %w", workspace, repoSlug, err)
+	}
+
+	downloads := make([]RepoDownload, 0, len(values))
+	for _, v := range values {
+		var d RepoDownload
+		if err := json.Unmarshal(v, &d); err != nil {
+			return nil, fmt.Errorf("parsing download: %w", err)
+		}
+		downloads = append(downloads, d)
+	}
+	return downloads, nil
+}
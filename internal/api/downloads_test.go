@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_ListDownloads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2.0/repositories/workspace/repo/downloads" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"values":[
+			{"type":"download","name":"app-1.2.0.tar.gz","size":2048,"downloads":7,"created_on":"2024-03-01T10:00:00+00:00",
+			 "user":{"display_name":"Ada"},"links":{"self":{"href":"https://api.bitbucket.org/2.0/repositories/workspace/repo/downloads/app-1.2.0.tar.gz"}}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(testConfig(), WithBaseURL(server.URL+"/2.0"))
+	downloads, err := client.ListDownloads(context.Background(), "workspace", "repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(downloads) != 1 {
+		t.Fatalf("expected 1 download, got %d", len(downloads))
+	}
+	if d := downloads[0]; d.Name != "app-1.2.0.tar.gz" || d.Size != 2048 || d.Downloads != 7 || d.Links.Self.Href == "" {
+		t.Errorf("download = %+v", d)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// DeployKey is an SSH key with access to a single repository, for
+// deployment tooling. Key is the public key.
+type DeployKey struct {
+	Type      string `json:"type"`
+	ID        int    `json:"id"`
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	Comment   string `json:"comment,omitempty"`
+	CreatedOn string `json:"created_on"`
+	LastUsed  string `json:"last_used,omitempty"`
+	Links     Links  `json:"links"`
+}
+
+// Webhook is a repository webhook subscription. Bitbucket reports only
+// whether a secret is set, but Secret is kept so one returned anyway can
+// be blanked before the webhook is saved.
+type Webhook struct {
+	Type                 string   `json:"type"`
+	UUID                 string   `json:"uuid"`
+	URL                  string   `json:"url"`
+	Description          string   `json:"description"`
+	SubjectType          string   `json:"subject_type"`
+	Active               bool     `json:"active"`
+	Events               []string `json:"events"`
+	SkipCertVerification bool     `json:"skip_cert_verification"`
+	SecretSet            bool     `json:"secret_set"`
+	Secret               string   `json:"secret,omitempty"`
+	CreatedAt            string   `json:"created_at"`
+	Links                Links    `json:"links"`
+}
+
+// GetDeployKeys fetches a repository's deploy keys. Listing them needs
+// repository admin; without it the error wraps ErrPoliciesRestricted.
+func (c *Client) GetDeployKeys(ctx context.Context, workspace, repoSlug string) ([]DeployKey, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := fmt.Sprintf("/repositories/%s/%s/deploy-keys", workspace, repoSlug)
+	values, err := c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, policiesError("deploy keys", workspace, repoSlug, err)
+	}
+
+	keys := make([]DeployKey, 0, len(values))
+	for _, v := range values {
+		var k DeployKey
+		if err := json.Unmarshal(v, &k); err != nil {
+			return nil, fmt.Errorf("parsing deploy key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// GetWebhooks fetches a repository's webhooks. Listing them needs
+// repository admin; without it the error wraps ErrPoliciesRestricted.
+func (c *Client) GetWebhooks(ctx context.Context, workspace, repoSlug string) ([]Webhook, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := fmt.Sprintf("/repositories/%s/%s/hooks", workspace, repoSlug)
+	values, err := c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, policiesError("webhooks", workspace, repoSlug, err)
+	}
+
+	hooks := make([]Webhook, 0, len(values))
+	for _, v := range values {
+		var h Webhook
+		if err := json.Unmarshal(v, &h); err != nil {
+			return nil, fmt.Errorf("parsing webhook: %w", err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_GetIntegrations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2.0/repositories/workspace/repo/deploy-keys":
+			_, _ = w.Write([]byte(`{"values":[
+				{"type":"deploy_key","id":12,"key":"ssh-ed25519 AAAAC3Nza","label":"ci","comment":"ci@build","created_on":"2024-01-02T03:04:05Z","last_used":null}]}`))
+		case "/2.0/repositories/workspace/repo/hooks":
+			_, _ = w.Write([]byte(`{"values":[
+				{"type":"webhook_subscription","uuid":"{abc}","url":"https://ci.example.com/hook","description":"CI",
+				 "subject_type":"repository","active":true,"events":["repo:push","pullrequest:created"],"secret_set":true}]}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"message":"Access denied"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(testConfig(), WithBaseURL(server.URL+"/2.0"))
+
+	keys, err := client.GetDeployKeys(context.Background(), "workspace", "repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != 12 || keys[0].Label != "ci" || keys[0].Key != "ssh-ed25519 AAAAC3Nza" || keys[0].LastUsed != "" {
+		t.Errorf("deploy keys = %+v", keys)
+	}
+
+	hooks, err := client.GetWebhooks(context.Background(), "workspace", "repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hooks) != 1 || hooks[0].UUID != "{abc}" || !hooks[0].Active || !hooks[0].SecretSet || len(hooks[0].Events) != 2 {
+		t.Errorf("webhooks = %+v", hooks)
+	}
+
+	if _, err := client.GetDeployKeys(context.Background(), "workspace", "locked"); !errors.Is(err, ErrPoliciesRestricted) {
+		t.Errorf("expected ErrPoliciesRestricted for a 403, got %v", err)
+	}
+	if _, err := client.GetWebhooks(context.Background(), "workspace", "locked"); !errors.Is(err, ErrPoliciesRestricted) {
+		t.Errorf("expected ErrPoliciesRestricted for a 403, got %v", err)
+	}
+}
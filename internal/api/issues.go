@@ -7,6 +7,11 @@ import (
 	"fmt"
 )
 
+// ErrIssueTrackerRestricted is wrapped by issue listing errors when the
+// tracker exists but the credentials may not read it (HTTP 403). Callers
+// can treat it like a disabled tracker.
+var ErrIssueTrackerRestricted = errors.New("issue tracker is restricted")
+
 // Issue represents a Bitbucket issue.
 type Issue struct {
 	Type       string      `json:"type"`
@@ -28,6 +33,9 @@ type Issue struct {
 	EditedOn   string      `json:"edited_on,omitempty"`
 	Links      Links       `json:"links"`
 	Repository *Repository `json:"repository,omitempty"`
+
+	// Raw holds the API payload verbatim so unknown fields survive a save.
+	Raw json.RawMessage `json:"-"`
 }
 
 // Milestone represents a project milestone.
@@ -58,6 +66,9 @@ type IssueComment struct {
 	User      *User    `json:"user"`
 	Issue     *Issue   `json:"issue,omitempty"`
 	Links     Links    `json:"links"`
+
+	// Raw holds the API payload verbatim so unknown fields survive a save.
+	Raw json.RawMessage `json:"-"`
 }
 
 // IssueChange represents a change to an issue.
@@ -70,6 +81,9 @@ type IssueChange struct {
 	Changes   *IssueChangeDetail `json:"changes,omitempty"`
 	Message   *Content           `json:"message,omitempty"`
 	Links     Links              `json:"links"`
+
+	// Raw holds the API payload verbatim so unknown fields survive a save.
+	Raw json.RawMessage `json:"-"`
 }
 
 // IssueChangeDetail contains the specific changes made.
@@ -91,10 +105,27 @@ type ChangeValue struct {
 	New string `json:"new"`
 }
 
+// IssuesPath returns the API path listing a repository's issues.
+func IssuesPath(workspace, repoSlug string) string {
+	return fmt.Sprintf("/repositories/%s/%s/issues", workspace, repoSlug)
+}
+
+// IssuesUpdatedSincePath returns the API path listing issues updated after
+// the given timestamp.
+func IssuesUpdatedSincePath(workspace, repoSlug, since string) string {
+	return fmt.Sprintf("/repositories/%s/%s/issues?q=updated_on>%%22%s%%22", workspace, repoSlug, since)
+}
+
+// IssueCommentsPath returns the API path listing comments on an issue.
+func IssueCommentsPath(workspace, repoSlug string, issueID int) string {
+	return fmt.Sprintf("/repositories/%s/%s/issues/%d/comments", workspace, repoSlug, issueID)
+}
+
 // GetIssues fetches all issues for a repository.
 // Returns empty slice if issue tracker is disabled.
 func (c *Client) GetIssues(ctx context.Context, workspace, repoSlug string) ([]Issue, error) {
-	path := fmt.Sprintf("/repositories/%s/%s/issues", workspace, repoSlug)
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := IssuesPath(workspace, repoSlug)
 	values, err := c.GetPaginated(ctx, path)
 	if err != nil {
 		// Check if it's a 404 - issue tracker might be disabled
@@ -102,6 +133,9 @@ func (c *Client) GetIssues(ctx context.Context, workspace, repoSlug string) ([]I
 		if errors.As(err, &apiErr) && apiErr.StatusCode == 404 {
 			return []Issue{}, nil
 		}
+		if errors.As(err, &apiErr) && apiErr.StatusCode == 403 {
+			return nil, fmt.Errorf("fetching issues for %s/%s: %w: %w", workspace, repoSlug, ErrIssueTrackerRestricted, err)
+		}
 		return nil, fmt.Errorf("fetching issues for %s/%s: %w", workspace, repoSlug, err)
 	}
 
@@ -135,7 +169,8 @@ func (c *Client) GetIssue(ctx context.Context, workspace, repoSlug string, issue
 
 // GetIssueComments fetches all comments on an issue.
 func (c *Client) GetIssueComments(ctx context.Context, workspace, repoSlug string, issueID int) ([]IssueComment, error) {
-	path := fmt.Sprintf("/repositories/%s/%s/issues/%d/comments", workspace, repoSlug, issueID)
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := IssueCommentsPath(workspace, repoSlug, issueID)
 	values, err := c.GetPaginated(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching issue comments: %w", err)
@@ -155,6 +190,7 @@ func (c *Client) GetIssueComments(ctx context.Context, workspace, repoSlug strin
 
 // GetIssueChanges fetches the change history for an issue.
 func (c *Client) GetIssueChanges(ctx context.Context, workspace, repoSlug string, issueID int) ([]IssueChange, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
 	path := fmt.Sprintf("/repositories/%s/%s/issues/%d/changes", workspace, repoSlug, issueID)
 	values, err := c.GetPaginated(ctx, path)
 	if err != nil {
@@ -176,7 +212,8 @@ func (c *Client) GetIssueChanges(ctx context.Context, workspace, repoSlug string
 // GetIssuesUpdatedSince fetches issues updated after the given timestamp.
 // Useful for incremental backups.
 func (c *Client) GetIssuesUpdatedSince(ctx context.Context, workspace, repoSlug, since string) ([]Issue, error) {
-	path := fmt.Sprintf("/repositories/%s/%s/issues?q=updated_on>%%22%s%%22", workspace, repoSlug, since)
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := IssuesUpdatedSincePath(workspace, repoSlug, since)
 	values, err := c.GetPaginated(ctx, path)
 	if err != nil {
 		// Check if it's a 404 - issue tracker might be disabled
@@ -184,6 +221,9 @@ func (c *Client) GetIssuesUpdatedSince(ctx context.Context, workspace, repoSlug,
 		if errors.As(err, &apiErr) && apiErr.StatusCode == 404 {
 			return []Issue{}, nil
 		}
+		if errors.As(err, &apiErr) && apiErr.StatusCode == 403 {
+			return nil, fmt.Errorf("fetching updated issues: %w: %w", ErrIssueTrackerRestricted, err)
+		}
 		return nil, fmt.Errorf("fetching updated issues: %w", err)
 	}
 
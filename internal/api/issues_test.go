@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -88,6 +89,31 @@ func TestClient_GetIssues_TrackerDisabled(t *testing.T) {
 	}
 }
 
+func TestClient_GetIssues_TrackerRestricted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"type": "error", "error": {"message": "You do not have access to this issue tracker"}}`))
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	client := NewClient(cfg, WithBaseURL(server.URL+"/2.0"))
+
+	_, err := client.GetIssues(context.Background(), "workspace", "repo")
+	if !errors.Is(err, ErrIssueTrackerRestricted) {
+		t.Errorf("GetIssues() error = %v, want ErrIssueTrackerRestricted", err)
+	}
+
+	_, err = client.GetIssuesUpdatedSince(context.Background(), "workspace", "repo", "2024-01-01T00:00:00Z")
+	if !errors.Is(err, ErrIssueTrackerRestricted) {
+		t.Errorf("GetIssuesUpdatedSince() error = %v, want ErrIssueTrackerRestricted", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected wrapped APIError with status 403, got %v", err)
+	}
+}
+
 func TestClient_GetIssue(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/2.0/repositories/workspace/repo/issues/42" {
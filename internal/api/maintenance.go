@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maintenanceRecheck is how often the status page is consulted, and how
+// long a pause lasts when an announced window has no end time.
+const maintenanceRecheck = time.Minute
+
+// MaintenanceFunc is told when the client pauses all requests for a server
+// outage, with the time requests resume and the reason.
+type MaintenanceFunc func(until time.Time, reason string)
+
+// maintenanceGate pauses every request sharing a client while Bitbucket is
+// down for maintenance, so a run waits it out instead of failing each
+// repository in turn. Pauses come from Retry-After on 5xx responses or an
+// active window on the status page, and are capped by a per-run budget.
+type maintenanceGate struct {
+	mu        sync.Mutex
+	until     time.Time
+	used      time.Duration // Total pause granted so far
+	budget    time.Duration // 0 disables pausing
+	statusURL string
+	lastCheck time.Time
+	notify    MaintenanceFunc
+	client    *http.Client
+}
+
+// WithMaintenanceFunc sets a callback for maintenance pauses.
+func WithMaintenanceFunc(f MaintenanceFunc) ClientOption {
+	return func(c *Client) {
+		c.maintenance.notify = f
+	}
+}
+
+// WaitForMaintenance blocks while requests are paused for maintenance.
+// Git operations call it so clones don't fail during the window either.
+func (c *Client) WaitForMaintenance(ctx context.Context) error {
+	return c.maintenance.wait(ctx)
+}
+
+// wait blocks until any current pause has ended.
+func (g *maintenanceGate) wait(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		left := time.Until(g.until)
+		g.mu.Unlock()
+		if left <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(left):
+		}
+	}
+}
+
+// onServerError decides whether a 5xx response should be retried after a
+// pause. It honors Retry-After, and otherwise asks the status page whether
+// maintenance is under way. It returns false once the budget is spent.
+func (g *maintenanceGate) onServerError(ctx context.Context, resp *http.Response) bool {
+	if g.budget <= 0 || !isMaintenanceStatus(resp.StatusCode) {
+		return false
+	}
+	if d, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		return g.pause(d, fmt.Sprintf("%d %s with Retry-After", resp.StatusCode, http.StatusText(resp.StatusCode)))
+	}
+	if name, until, ok := g.checkStatusPage(ctx); ok {
+		d := maintenanceRecheck
+		if left := time.Until(until); left > 0 && left < d {
+			d = left
+		}
+		return g.pause(d, "scheduled maintenance: "+name)
+	}
+	return false
+}
+
+// pause stops requests for d, extending a current pause rather than
+// stacking on it, within what is left of the budget.
+func (g *maintenanceGate) pause(d time.Duration, reason string) bool {
+	g.mu.Lock()
+	now := time.Now()
+	until := now.Add(d)
+	if !until.After(g.until) {
+		// Another worker already paused at least this long
+		g.mu.Unlock()
+		return true
+	}
+	extra := until.Sub(now)
+	if g.until.After(now) {
+		extra = until.Sub(g.until)
+	}
+	if g.used+extra > g.budget {
+		g.mu.Unlock()
+		return false
+	}
+	g.used += extra
+	g.until = until
+	notify := g.notify
+	g.mu.Unlock()
+
+	if notify != nil {
+		notify(until, reason)
+	}
+	return true
+}
+
+// statusPageMaintenances is the subset of a Statuspage
+// scheduled-maintenances/active.json response that is used.
+type statusPageMaintenances struct {
+	ScheduledMaintenances []struct {
+		Name           string `json:"name"`
+		Status         string `json:"status"`
+		ScheduledUntil string `json:"scheduled_until"`
+	} `json:"scheduled_maintenances"`
+}
+
+// checkStatusPage returns the name and scheduled end of a maintenance
+// window in progress. The page is fetched at most once per
+// maintenanceRecheck; errors count as no maintenance.
+func (g *maintenanceGate) checkStatusPage(ctx context.Context) (string, time.Time, bool) {
+	g.mu.Lock()
+	if g.statusURL == "" || time.Since(g.lastCheck) < maintenanceRecheck {
+		g.mu.Unlock()
+		return "", time.Time{}, false
+	}
+	g.lastCheck = time.Now()
+	g.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.statusURL, nil)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	defer resp.Body.Close() //nolint:errcheck // closing response body
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, false
+	}
+	var page statusPageMaintenances
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return "", time.Time{}, false
+	}
+	for _, m := range page.ScheduledMaintenances {
+		if m.Status == "in_progress" || m.Status == "verifying" {
+			until, _ := time.Parse(time.RFC3339, m.ScheduledUntil)
+			return m.Name, until, true
+		}
+	}
+	return "", time.Time{}, false
+}
+
+// isMaintenanceStatus reports whether a status code means the service is
+// temporarily unavailable rather than broken for this request.
+func isMaintenanceStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// retryAfter parses a Retry-After header in either delay-seconds or
+// HTTP-date form.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
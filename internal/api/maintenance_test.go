@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 16, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"30", 30 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Tue, 16 Jan 2024 10:02:00 GMT", 2 * time.Minute, true},
+		{"Tue, 16 Jan 2024 09:00:00 GMT", 0, true}, // already passed
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// unavailableServer answers 503 with the given Retry-After for the first
+// failures requests, then 200.
+func unavailableServer(t *testing.T, failures int32, retryAfter string) (*httptest.Server, *int32) {
+	t.Helper()
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&count, 1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"type": "error", "error": {"message": "Down for maintenance"}}`))
+			return
+		}
+		w.Write([]byte(`{"values": [], "status": "ok"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func TestClient_ServerRetryAfter(t *testing.T) {
+	server, count := unavailableServer(t, 2, "1")
+	cfg := testConfig()
+	cfg.RateLimit.MaxMaintenanceWaitMinutes = 5
+
+	var pauses int32
+	client := NewClient(cfg, WithBaseURL(server.URL), WithMaintenanceFunc(func(time.Time, string) {
+		atomic.AddInt32(&pauses, 1)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.Get(ctx, "/test"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if atomic.LoadInt32(count) != 3 || atomic.LoadInt32(&pauses) != 2 {
+		t.Errorf("requests = %d, pauses = %d; want 3, 2", *count, pauses)
+	}
+	if took := time.Since(start); took < 2*time.Second {
+		t.Errorf("took %s, want Retry-After honored", took)
+	}
+
+	// Paginated requests wait too
+	server, _ = unavailableServer(t, 1, "1")
+	client = NewClient(cfg, WithBaseURL(server.URL))
+	if _, err := client.GetPaginated(ctx, "/list"); err != nil {
+		t.Fatalf("GetPaginated() error = %v", err)
+	}
+}
+
+func TestClient_ServerErrorWithoutPause(t *testing.T) {
+	tests := []struct {
+		name       string
+		budget     int
+		retryAfter string
+	}{
+		{"pausing disabled", 0, "1"},
+		{"no Retry-After or status page", 5, ""},
+		{"Retry-After beyond budget", 1, "120"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, count := unavailableServer(t, 1, tt.retryAfter)
+			cfg := testConfig()
+			cfg.RateLimit.MaxMaintenanceWaitMinutes = tt.budget
+			client := NewClient(cfg, WithBaseURL(server.URL))
+
+			_, err := client.Get(context.Background(), "/test")
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("error = %v, want the 503", err)
+			}
+			if atomic.LoadInt32(count) != 1 {
+				t.Errorf("requests = %d, want 1", *count)
+			}
+		})
+	}
+}
+
+func TestClient_StatusPageMaintenance(t *testing.T) {
+	server, count := unavailableServer(t, 1, "")
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		until := time.Now().Add(time.Second).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, `{"scheduled_maintenances": [
+			{"name": "Old", "status": "completed"},
+			{"name": "Database upgrade", "status": "in_progress", "scheduled_until": %q}]}`, until)
+	}))
+	defer status.Close()
+
+	cfg := testConfig()
+	cfg.RateLimit.MaxMaintenanceWaitMinutes = 5
+	cfg.RateLimit.StatusPageURL = status.URL
+	var reason string
+	client := NewClient(cfg, WithBaseURL(server.URL), WithMaintenanceFunc(func(_ time.Time, r string) {
+		reason = r
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.Get(ctx, "/test"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if atomic.LoadInt32(count) != 2 {
+		t.Errorf("requests = %d, want 2", *count)
+	}
+	if reason != "scheduled maintenance: Database upgrade" {
+		t.Errorf("reason = %q", reason)
+	}
+}
+
+func TestMaintenanceGate_SharedPause(t *testing.T) {
+	g := &maintenanceGate{budget: time.Minute}
+	if !g.pause(30*time.Second, "a") || !g.pause(10*time.Second, "b") {
+		t.Fatal("pauses within budget should be granted")
+	}
+	// The shorter pause is covered by the longer one and uses no budget
+	if g.used != 30*time.Second {
+		t.Errorf("used = %s, want 30s", g.used)
+	}
+	if g.pause(2*time.Minute, "c") {
+		t.Error("pause beyond the budget should be refused")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("wait() = %v, want context.Canceled", err)
+	}
+}
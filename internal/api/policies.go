@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrPoliciesRestricted is wrapped by policy listing errors when the
+// credentials may not read a repository's settings (HTTP 403); reading
+// branch restrictions needs repository admin.
+var ErrPoliciesRestricted = errors.New("repository settings are restricted")
+
+// BranchRestriction is a branch permission or merge check. Kind says which:
+// push, force, delete, and restrict_merges limit who may change matching
+// branches; the require_* and similar kinds are merge checks, with Value
+// holding the count for kinds such as require_approvals_to_merge.
+type BranchRestriction struct {
+	Type            string  `json:"type"`
+	ID              int     `json:"id"`
+	Kind            string  `json:"kind"`
+	BranchMatchKind string  `json:"branch_match_kind"` // "glob" or "branching_model"
+	BranchType      string  `json:"branch_type,omitempty"`
+	Pattern         string  `json:"pattern"`
+	Value           *int    `json:"value,omitempty"`
+	Users           []User  `json:"users,omitempty"`
+	Groups          []Group `json:"groups,omitempty"`
+	Links           Links   `json:"links"`
+}
+
+// Group is a workspace user group.
+type Group struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	FullSlug string `json:"full_slug,omitempty"`
+}
+
+// DefaultReviewer is a reviewer added to every new pull request, from the
+// repository's settings or inherited from its project.
+type DefaultReviewer struct {
+	Type         string `json:"type"`
+	ReviewerType string `json:"reviewer_type"` // "repository" or "project"
+	User         *User  `json:"user"`
+}
+
+// BranchingModel is a repository's effective branching model: its
+// development and production branches and the prefixes of its branch
+// types, which branch restrictions can target by type.
+type BranchingModel struct {
+	Type        string                `json:"type"`
+	Development *BranchingModelBranch `json:"development,omitempty"`
+	Production  *BranchingModelBranch `json:"production,omitempty"`
+	BranchTypes []BranchType          `json:"branch_types"`
+	Links       Links                 `json:"links"`
+}
+
+// BranchingModelBranch is the development or production branch of a
+// branching model. Branch is nil when the named branch does not exist.
+type BranchingModelBranch struct {
+	Name          string  `json:"name"`
+	UseMainbranch bool    `json:"use_mainbranch"`
+	Branch        *Branch `json:"branch,omitempty"`
+}
+
+// BranchType is a kind of branch in a branching model, e.g. "feature"
+// for branches named with the prefix "feature/".
+type BranchType struct {
+	Kind   string `json:"kind"`
+	Prefix string `json:"prefix"`
+}
+
+// GetBranchRestrictions fetches a repository's branch permissions and
+// merge checks.
+func (c *Client) GetBranchRestrictions(ctx context.Context, workspace, repoSlug string) ([]BranchRestriction, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := fmt.Sprintf("/repositories/%s/%s/branch-restrictions", workspace, repoSlug)
+	values, err := c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, policiesError("branch restrictions", workspace, repoSlug, err)
+	}
+
+	restrictions := make([]BranchRestriction, 0, len(values))
+	for _, v := range values {
+		var r BranchRestriction
+		if err := json.Unmarshal(v, &r); err != nil {
+			return nil, fmt.Errorf("parsing branch restriction: %w", err)
+		}
+		restrictions = append(restrictions, r)
+	}
+	return restrictions, nil
+}
+
+// GetEffectiveDefaultReviewers fetches the default reviewers that apply to
+// a repository's pull requests, including those inherited from its project.
+func (c *Client) GetEffectiveDefaultReviewers(ctx context.Context, workspace, repoSlug string) ([]DefaultReviewer, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := fmt.Sprintf("/repositories/%s/%s/effective-default-reviewers", workspace, repoSlug)
+	values, err := c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, policiesError("default reviewers", workspace, repoSlug, err)
+	}
+
+	reviewers := make([]DefaultReviewer, 0, len(values))
+	for _, v := range values {
+		var r DefaultReviewer
+		if err := json.Unmarshal(v, &r); err != nil {
+			return nil, fmt.Errorf("parsing default reviewer: %w", err)
+		}
+		reviewers = append(reviewers, r)
+	}
+	return reviewers, nil
+}
+
+// GetBranchingModel fetches the branching model as applied to a
+// repository, whether set on the repository or inherited from its project.
+func (c *Client) GetBranchingModel(ctx context.Context, workspace, repoSlug string) (*BranchingModel, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := fmt.Sprintf("/repositories/%s/%s/branching-model", workspace, repoSlug)
+	body, err := c.Get(ctx, path)
+	if err != nil {
+		return nil, policiesError("branching model", workspace, repoSlug, err)
+	}
+
+	var m BranchingModel
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("parsing branching model: %w", err)
+	}
+	return &m, nil
+}
+
+func policiesError(what, workspace, repoSlug string, err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == 403 {
+		return fmt.Errorf("fetching %s for %s/%s: %w: %w", what, workspace, repoSlug, ErrPoliciesRestricted, err)
+	}
+	return fmt.Errorf("fetching %s for %s/%s: %w", what, workspace, repoSlug, err)
+}
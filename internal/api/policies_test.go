@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient_GetBranchRestrictions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/2.0/repositories/workspace/repo/branch-restrictions":
+			_, _ = w.Write([]byte(`{"values":[
+				{"type":"branchrestriction","id":1,"kind":"push","branch_match_kind":"glob","pattern":"main",
+				 "users":[{"display_name":"Ada"}],"groups":[{"name":"Admins","slug":"admins"}]},
+				{"type":"branchrestriction","id":2,"kind":"require_approvals_to_merge","branch_match_kind":"branching_model","branch_type":"production","value":2}]}`))
+		case "/2.0/repositories/workspace/repo/effective-default-reviewers":
+			_, _ = w.Write([]byte(`{"values":[{"type":"default_reviewer_and_type","reviewer_type":"project","user":{"display_name":"Grace"}}]}`))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"message":"Access denied"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(testConfig(), WithBaseURL(server.URL+"/2.0"))
+
+	restrictions, err := client.GetBranchRestrictions(context.Background(), "workspace", "repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(restrictions) != 2 {
+		t.Fatalf("expected 2 restrictions, got %d", len(restrictions))
+	}
+	if r := restrictions[0]; r.Kind != "push" || len(r.Users) != 1 || len(r.Groups) != 1 || r.Groups[0].Name != "Admins" {
+		t.Errorf("push restriction = %+v", r)
+	}
+	if r := restrictions[1]; r.BranchType != "production" || r.Value == nil || *r.Value != 2 {
+		t.Errorf("approvals check = %+v", r)
+	}
+
+	reviewers, err := client.GetEffectiveDefaultReviewers(context.Background(), "workspace", "repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(reviewers) != 1 || reviewers[0].ReviewerType != "project" || reviewers[0].User.DisplayName != "Grace" {
+		t.Errorf("reviewers = %+v", reviewers)
+	}
+
+	if _, err := client.GetBranchRestrictions(context.Background(), "workspace", "locked"); !errors.Is(err, ErrPoliciesRestricted) {
+		t.Errorf("expected ErrPoliciesRestricted for a 403, got %v", err)
+	}
+}
+
+func TestClient_GetBranchingModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2.0/repositories/workspace/repo/branching-model" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"message":"Access denied"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"type":"branching_model",
+			"development":{"name":"develop","use_mainbranch":false,"branch":{"type":"branch","name":"develop"}},
+			"production":{"name":"","use_mainbranch":true},
+			"branch_types":[{"kind":"feature","prefix":"feature/"},{"kind":"release","prefix":"release/"}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(testConfig(), WithBaseURL(server.URL+"/2.0"))
+
+	model, err := client.GetBranchingModel(context.Background(), "workspace", "repo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if model.Development == nil || model.Development.Branch == nil || model.Development.Branch.Name != "develop" {
+		t.Errorf("development = %+v", model.Development)
+	}
+	if model.Production == nil || !model.Production.UseMainbranch || model.Production.Branch != nil {
+		t.Errorf("production = %+v", model.Production)
+	}
+	if len(model.BranchTypes) != 2 || model.BranchTypes[0].Prefix != "feature/" {
+		t.Errorf("branch types = %+v", model.BranchTypes)
+	}
+
+	if _, err := client.GetBranchingModel(context.Background(), "workspace", "locked"); !errors.Is(err, ErrPoliciesRestricted) {
+		t.Errorf("expected ErrPoliciesRestricted for a 403, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package api //nolint:revive // package name is intentional
+
+import "context"
+
+// Priority classifies an API request for the rate limiter's scheduler.
+// When tokens are scarce, waiting requests are served by weighted round
+// robin across classes so bulk pagination cannot starve enumeration calls.
+type Priority int
+
+const (
+	// PriorityLow is for bulk per-item fetches such as PR and issue pages,
+	// comments, and activity.
+	PriorityLow Priority = iota
+	// PriorityNormal is the default for requests without a class.
+	PriorityNormal
+	// PriorityHigh is for cheap calls the rest of the run depends on:
+	// workspace, project, and repository enumeration.
+	PriorityHigh
+
+	numPriorities
+)
+
+// priorityWeights sets the share of tokens each class receives when all
+// classes have waiters, indexed by Priority.
+var priorityWeights = [numPriorities]int{
+	PriorityLow:    1,
+	PriorityNormal: 3,
+	PriorityHigh:   6,
+}
+
+// String returns the class name used in logs.
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// priorityKey is the context key for request priority.
+const priorityKey contextKey = "priority"
+
+// WithPriority returns a context whose API requests use the given class.
+// It overrides the class a client method would otherwise pick.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey, p)
+}
+
+// GetPriority extracts the request priority from context, returns
+// PriorityNormal if not set.
+func GetPriority(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey).(Priority); ok && p >= 0 && p < numPriorities {
+		return p
+	}
+	return PriorityNormal
+}
+
+// withDefaultPriority sets p unless the caller already chose a class.
+func withDefaultPriority(ctx context.Context, p Priority) context.Context {
+	if _, ok := ctx.Value(priorityKey).(Priority); ok {
+		return ctx
+	}
+	return WithPriority(ctx, p)
+}
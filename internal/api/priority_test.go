@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetPriority(t *testing.T) {
+	ctx := context.Background()
+	if got := GetPriority(ctx); got != PriorityNormal {
+		t.Errorf("GetPriority(empty) = %v, want normal", got)
+	}
+
+	ctx = WithPriority(ctx, PriorityHigh)
+	if got := GetPriority(ctx); got != PriorityHigh {
+		t.Errorf("GetPriority() = %v, want high", got)
+	}
+
+	// A method default must not override an explicit caller choice.
+	if got := GetPriority(withDefaultPriority(ctx, PriorityLow)); got != PriorityHigh {
+		t.Errorf("withDefaultPriority overrode caller: got %v", got)
+	}
+	if got := GetPriority(withDefaultPriority(context.Background(), PriorityLow)); got != PriorityLow {
+		t.Errorf("withDefaultPriority() = %v, want low", got)
+	}
+}
+
+func TestRateLimiter_NextWaiterWeighted(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{RequestsPerHour: 3600, BurstSize: 1})
+
+	owner := make(map[chan struct{}]Priority)
+	for i := 0; i < 20; i++ {
+		for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+			ch := make(chan struct{})
+			owner[ch] = p
+			rl.queues[p] = append(rl.queues[p], ch)
+			rl.queued++
+		}
+	}
+
+	// One full round of weights (1+3+6) serves each class in proportion.
+	counts := make(map[Priority]int)
+	for i := 0; i < 10; i++ {
+		counts[owner[rl.nextWaiterLocked()]]++
+	}
+	if counts[PriorityHigh] != 6 || counts[PriorityNormal] != 3 || counts[PriorityLow] != 1 {
+		t.Errorf("weighted counts = %v, want high=6 normal=3 low=1", counts)
+	}
+
+	// Low priority is never starved: once other queues drain it is served.
+	rl.queues[PriorityHigh] = nil
+	rl.queues[PriorityNormal] = nil
+	rl.queued = len(rl.queues[PriorityLow])
+	if got := owner[rl.nextWaiterLocked()]; got != PriorityLow {
+		t.Errorf("expected low waiter, got %v", got)
+	}
+}
+
+func TestRateLimiter_WaitPriorityConcurrent(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{
+		RequestsPerHour: 360000, // 100 per second
+		BurstSize:       1,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(p Priority) {
+			defer wg.Done()
+			rl.WaitPriority(p)
+		}(Priority(i % int(numPriorities)))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiters were not all served")
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.queued != 0 {
+		t.Errorf("expected empty queues, got %d waiting", rl.queued)
+	}
+}
@@ -18,6 +18,9 @@ type Project struct {
 	CreatedOn   string `json:"created_on"`
 	UpdatedOn   string `json:"updated_on"`
 	Owner       *User  `json:"owner,omitempty"`
+
+	// Raw holds the API payload verbatim; it is written in raw mode.
+	Raw json.RawMessage `json:"-"`
 }
 
 // User represents a Bitbucket user.
@@ -32,37 +35,39 @@ type User struct {
 }
 
 // GetProjects fetches all projects in a workspace.
-func (c *Client) GetProjects(ctx context.Context, workspace string) ([]Project, error) {
+func (p *cloudProvider) GetProjects(ctx context.Context, workspace string) ([]Project, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
 	path := fmt.Sprintf("/workspaces/%s/projects", workspace)
-	values, err := c.GetPaginated(ctx, path)
+	values, err := p.c.GetPaginated(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching projects for workspace %s: %w", workspace, err)
 	}
 
 	projects := make([]Project, 0, len(values))
 	for _, v := range values {
-		var p Project
-		if err := json.Unmarshal(v, &p); err != nil {
+		var project Project
+		if err := json.Unmarshal(v, &project); err != nil {
 			return nil, fmt.Errorf("parsing project: %w", err)
 		}
-		projects = append(projects, p)
+		projects = append(projects, project)
 	}
 
 	return projects, nil
 }
 
 // GetProject fetches a single project by key.
-func (c *Client) GetProject(ctx context.Context, workspace, projectKey string) (*Project, error) {
+func (p *cloudProvider) GetProject(ctx context.Context, workspace, projectKey string) (*Project, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
 	path := fmt.Sprintf("/workspaces/%s/projects/%s", workspace, projectKey)
-	body, err := c.Get(ctx, path)
+	body, err := p.c.Get(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching project %s/%s: %w", workspace, projectKey, err)
 	}
 
-	var p Project
-	if err := json.Unmarshal(body, &p); err != nil {
+	var project Project
+	if err := json.Unmarshal(body, &project); err != nil {
 		return nil, fmt.Errorf("parsing project response: %w", err)
 	}
 
-	return &p, nil
+	return &project, nil
 }
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+)
+
+// Provider serves the endpoints whose paths and models differ between
+// Bitbucket Cloud and Data Center. Both return the Cloud types; a Data
+// Center provider maps its responses onto them and ignores the workspace
+// argument, which only names the backup there. A Client embeds the
+// provider for its api.type, so these are also methods of the Client.
+type Provider interface {
+	// GetWorkspace fetches metadata for a workspace.
+	GetWorkspace(ctx context.Context, workspace string) (*Workspace, error)
+	// GetWorkspaceMembers fetches the current members of a workspace.
+	// Users who have left or been deactivated are no longer listed.
+	GetWorkspaceMembers(ctx context.Context, workspace string) ([]WorkspaceMembership, error)
+
+	// GetProjects fetches all projects in a workspace.
+	GetProjects(ctx context.Context, workspace string) ([]Project, error)
+	// GetProject fetches a single project by key.
+	GetProject(ctx context.Context, workspace, projectKey string) (*Project, error)
+
+	// GetRepositories fetches all repositories in a workspace.
+	GetRepositories(ctx context.Context, workspace string) ([]Repository, error)
+	// GetRepository fetches a single repository.
+	GetRepository(ctx context.Context, workspace, repoSlug string) (*Repository, error)
+	// QueryRepositories fetches the repositories in a workspace that
+	// match a Bitbucket query expression, e.g. `slug ~ "core-"`.
+	QueryRepositories(ctx context.Context, workspace, query string) ([]Repository, error)
+	// SampleRepository fetches one repository the credentials can read,
+	// or nil when they can read none. It is a cheap check that the API
+	// accepts them.
+	SampleRepository(ctx context.Context, workspace string) (*Repository, error)
+	// CreateRepository creates an empty git repository with repo's slug,
+	// name, description, privacy, and fork policy, in its project or,
+	// without one, for its owner.
+	CreateRepository(ctx context.Context, workspace string, repo *Repository) (*Repository, error)
+	// GetFileContent fetches the raw content of a file in a repository
+	// at ref, a branch, tag, or commit.
+	GetFileContent(ctx context.Context, workspace, repoSlug, ref, filePath string) ([]byte, error)
+	// ListDownloads lists the files in a repository's Downloads section.
+	// Each file's content is at Links.Self.Href.
+	ListDownloads(ctx context.Context, workspace, repoSlug string) ([]RepoDownload, error)
+
+	// GetAllPullRequests fetches a repository's pull requests in all
+	// states.
+	GetAllPullRequests(ctx context.Context, workspace, repoSlug string) ([]PullRequest, error)
+	// GetPullRequestsUpdatedSince fetches the pull requests updated after
+	// since, an RFC 3339 timestamp. Useful for incremental backups.
+	GetPullRequestsUpdatedSince(ctx context.Context, workspace, repoSlug, since string) ([]PullRequest, error)
+	// GetPullRequest fetches a single pull request by ID.
+	GetPullRequest(ctx context.Context, workspace, repoSlug string, prID int) (*PullRequest, error)
+	// GetPullRequestComments fetches all comments on a pull request.
+	GetPullRequestComments(ctx context.Context, workspace, repoSlug string, prID int) ([]PRComment, error)
+	// GetPullRequestActivity fetches all activity on a pull request.
+	GetPullRequestActivity(ctx context.Context, workspace, repoSlug string, prID int) ([]PRActivity, error)
+	// GetPullRequestTasks fetches all tasks on a pull request.
+	GetPullRequestTasks(ctx context.Context, workspace, repoSlug string, prID int) ([]PRTask, error)
+}
+
+// cloudProvider talks to Bitbucket Cloud for a Client.
+type cloudProvider struct {
+	c *Client
+}
+
+var (
+	_ Provider = (*cloudProvider)(nil)
+	_ Provider = (*serverProvider)(nil)
+)
@@ -29,6 +29,9 @@ type PullRequest struct {
 	Participants      []Participant `json:"participants,omitempty"`
 	TaskCount         int           `json:"task_count"`
 	CommentCount      int           `json:"comment_count"`
+
+	// Raw holds the API payload verbatim so unknown fields survive a save.
+	Raw json.RawMessage `json:"-"`
 }
 
 // Commit represents a git commit.
@@ -78,6 +81,9 @@ type PRComment struct {
 	Parent    *PRComment `json:"parent,omitempty"`
 	Inline    *Inline    `json:"inline,omitempty"`
 	Links     Links      `json:"links"`
+
+	// Raw holds the API payload verbatim so unknown fields survive a save.
+	Raw json.RawMessage `json:"-"`
 }
 
 // Content represents rendered content.
@@ -102,6 +108,9 @@ type PRActivity struct {
 	Update   *PRUpdate   `json:"update,omitempty"`
 	Comment  *PRComment  `json:"comment,omitempty"`
 	Changes  *PRChanges  `json:"changes_requested,omitempty"`
+
+	// Raw holds the API payload verbatim so unknown fields survive a save.
+	Raw json.RawMessage `json:"-"`
 }
 
 // PRApproval represents an approval on a PR.
@@ -129,14 +138,63 @@ type PRChanges struct {
 	User *User  `json:"user"`
 }
 
-// GetPullRequests fetches all pull requests for a repository.
-// State can be: OPEN, MERGED, DECLINED, SUPERSEDED, or empty for all.
-func (c *Client) GetPullRequests(ctx context.Context, workspace, repoSlug, state string) ([]PullRequest, error) {
+// PRTask represents a task (checklist item) on a pull request.
+type PRTask struct {
+	ID         int        `json:"id"`
+	State      string     `json:"state"`
+	Content    *Content   `json:"content"`
+	Creator    *User      `json:"creator"`
+	Pending    bool       `json:"pending"`
+	ResolvedOn string     `json:"resolved_on,omitempty"`
+	ResolvedBy *User      `json:"resolved_by,omitempty"`
+	CreatedOn  string     `json:"created_on"`
+	UpdatedOn  string     `json:"updated_on"`
+	Comment    *PRComment `json:"comment,omitempty"`
+	Links      Links      `json:"links"`
+
+	// Raw holds the API payload verbatim so unknown fields survive a save.
+	Raw json.RawMessage `json:"-"`
+}
+
+// PullRequestStates lists the states fetched by GetAllPullRequests.
+var PullRequestStates = []string{"OPEN", "MERGED", "DECLINED", "SUPERSEDED"}
+
+// PullRequestsPath returns the API path listing a repository's pull requests,
+// optionally filtered by state.
+func PullRequestsPath(workspace, repoSlug, state string) string {
 	path := fmt.Sprintf("/repositories/%s/%s/pullrequests", workspace, repoSlug)
 	if state != "" {
 		path = fmt.Sprintf("%s?state=%s", path, state)
 	}
+	return path
+}
+
+// PullRequestsUpdatedSincePath returns the API path listing pull requests
+// updated after the given timestamp.
+func PullRequestsUpdatedSincePath(workspace, repoSlug, since string) string {
+	return fmt.Sprintf("/repositories/%s/%s/pullrequests?q=updated_on>%%22%s%%22", workspace, repoSlug, since)
+}
+
+// PullRequestCommentsPath returns the API path listing comments on a pull request.
+func PullRequestCommentsPath(workspace, repoSlug string, prID int) string {
+	return fmt.Sprintf("/repositories/%s/%s/pullrequests/%d/comments", workspace, repoSlug, prID)
+}
+
+// PullRequestActivityPath returns the API path listing activity on a pull request.
+func PullRequestActivityPath(workspace, repoSlug string, prID int) string {
+	return fmt.Sprintf("/repositories/%s/%s/pullrequests/%d/activity", workspace, repoSlug, prID)
+}
 
+// PullRequestTasksPath returns the API path listing tasks on a pull request.
+func PullRequestTasksPath(workspace, repoSlug string, prID int) string {
+	return fmt.Sprintf("/repositories/%s/%s/pullrequests/%d/tasks", workspace, repoSlug, prID)
+}
+
+// GetPullRequests fetches all pull requests for a repository.
+// State can be: OPEN, MERGED, DECLINED, SUPERSEDED, or empty for all.
+func (c *Client) GetPullRequests(ctx context.Context, workspace, repoSlug, state string) ([]PullRequest, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := PullRequestsPath(workspace, repoSlug, state)
 	values, err := c.GetPaginated(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching pull requests for %s/%s: %w", workspace, repoSlug, err)
@@ -155,8 +213,8 @@ func (c *Client) GetPullRequests(ctx context.Context, workspace, repoSlug, state
 }
 
 // GetAllPullRequests fetches all pull requests in all states concurrently.
-func (c *Client) GetAllPullRequests(ctx context.Context, workspace, repoSlug string) ([]PullRequest, error) {
-	states := []string{"OPEN", "MERGED", "DECLINED", "SUPERSEDED"}
+func (p *cloudProvider) GetAllPullRequests(ctx context.Context, workspace, repoSlug string) ([]PullRequest, error) {
+	states := PullRequestStates
 
 	type result struct {
 		prs []PullRequest
@@ -171,7 +229,7 @@ func (c *Client) GetAllPullRequests(ctx context.Context, workspace, repoSlug str
 		wg.Add(1)
 		go func(idx int, st string) {
 			defer wg.Done()
-			prs, err := c.GetPullRequests(ctx, workspace, repoSlug, st)
+			prs, err := p.c.GetPullRequests(ctx, workspace, repoSlug, st)
 			results[idx] = result{prs: prs, err: err}
 		}(i, state)
 	}
@@ -191,9 +249,9 @@ func (c *Client) GetAllPullRequests(ctx context.Context, workspace, repoSlug str
 }
 
 // GetPullRequest fetches a single pull request by ID.
-func (c *Client) GetPullRequest(ctx context.Context, workspace, repoSlug string, prID int) (*PullRequest, error) {
+func (p *cloudProvider) GetPullRequest(ctx context.Context, workspace, repoSlug string, prID int) (*PullRequest, error) {
 	path := fmt.Sprintf("/repositories/%s/%s/pullrequests/%d", workspace, repoSlug, prID)
-	body, err := c.Get(ctx, path)
+	body, err := p.c.Get(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching pull request %d: %w", prID, err)
 	}
@@ -207,9 +265,10 @@ func (c *Client) GetPullRequest(ctx context.Context, workspace, repoSlug string,
 }
 
 // GetPullRequestComments fetches all comments on a pull request.
-func (c *Client) GetPullRequestComments(ctx context.Context, workspace, repoSlug string, prID int) ([]PRComment, error) {
-	path := fmt.Sprintf("/repositories/%s/%s/pullrequests/%d/comments", workspace, repoSlug, prID)
-	values, err := c.GetPaginated(ctx, path)
+func (p *cloudProvider) GetPullRequestComments(ctx context.Context, workspace, repoSlug string, prID int) ([]PRComment, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := PullRequestCommentsPath(workspace, repoSlug, prID)
+	values, err := p.c.GetPaginated(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching PR comments: %w", err)
 	}
@@ -227,9 +286,10 @@ func (c *Client) GetPullRequestComments(ctx context.Context, workspace, repoSlug
 }
 
 // GetPullRequestActivity fetches all activity on a pull request.
-func (c *Client) GetPullRequestActivity(ctx context.Context, workspace, repoSlug string, prID int) ([]PRActivity, error) {
-	path := fmt.Sprintf("/repositories/%s/%s/pullrequests/%d/activity", workspace, repoSlug, prID)
-	values, err := c.GetPaginated(ctx, path)
+func (p *cloudProvider) GetPullRequestActivity(ctx context.Context, workspace, repoSlug string, prID int) ([]PRActivity, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := PullRequestActivityPath(workspace, repoSlug, prID)
+	values, err := p.c.GetPaginated(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching PR activity: %w", err)
 	}
@@ -246,12 +306,34 @@ func (c *Client) GetPullRequestActivity(ctx context.Context, workspace, repoSlug
 	return activities, nil
 }
 
+// GetPullRequestTasks fetches all tasks on a pull request.
+func (p *cloudProvider) GetPullRequestTasks(ctx context.Context, workspace, repoSlug string, prID int) ([]PRTask, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path := PullRequestTasksPath(workspace, repoSlug, prID)
+	values, err := p.c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching PR tasks: %w", err)
+	}
+
+	tasks := make([]PRTask, 0, len(values))
+	for _, v := range values {
+		var task PRTask
+		if err := json.Unmarshal(v, &task); err != nil {
+			return nil, fmt.Errorf("parsing PR task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
 // GetPullRequestsUpdatedSince fetches PRs updated after the given timestamp.
 // Useful for incremental backups.
-func (c *Client) GetPullRequestsUpdatedSince(ctx context.Context, workspace, repoSlug, since string) ([]PullRequest, error) {
+func (p *cloudProvider) GetPullRequestsUpdatedSince(ctx context.Context, workspace, repoSlug, since string) ([]PullRequest, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
 	// Use query parameter to filter by updated_on
-	path := fmt.Sprintf("/repositories/%s/%s/pullrequests?q=updated_on>%%22%s%%22", workspace, repoSlug, since)
-	values, err := c.GetPaginated(ctx, path)
+	path := PullRequestsUpdatedSincePath(workspace, repoSlug, since)
+	values, err := p.c.GetPaginated(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching updated pull requests: %w", err)
 	}
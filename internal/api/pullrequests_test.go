@@ -262,3 +262,57 @@ func TestClient_GetPullRequestActivity(t *testing.T) {
 		t.Error("expected second activity to be an update")
 	}
 }
+
+func TestClient_GetPullRequestTasks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2.0/repositories/workspace/repo/pullrequests/1/tasks" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		resp := map[string]interface{}{
+			"size":    2,
+			"page":    1,
+			"pagelen": 10,
+			"values": []map[string]interface{}{
+				{
+					"id":    10,
+					"state": "RESOLVED",
+					"content": map[string]interface{}{
+						"raw": "Security sign-off",
+					},
+					"resolved_by": map[string]interface{}{
+						"display_name": "Reviewer",
+					},
+				},
+				{
+					"id":    11,
+					"state": "UNRESOLVED",
+					"content": map[string]interface{}{
+						"raw": "Update docs",
+					},
+				},
+			},
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	client := NewClient(cfg, WithBaseURL(server.URL+"/2.0"))
+
+	tasks, err := client.GetPullRequestTasks(context.Background(), "workspace", "repo", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tasks) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(tasks))
+	}
+
+	if tasks[0].State != "RESOLVED" || tasks[0].ResolvedBy == nil {
+		t.Errorf("expected first task to be resolved with resolver, got %+v", tasks[0])
+	}
+
+	if tasks[1].Content.Raw != "Update docs" {
+		t.Errorf("expected content 'Update docs', got '%s'", tasks[1].Content.Raw)
+	}
+}
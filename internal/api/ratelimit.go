@@ -27,6 +27,20 @@ type RateLimiter struct {
 
 	// Current backoff state
 	consecutiveFailures int
+
+	// shared, when set, replaces the local bucket with one coordinated
+	// across processes through a state file.
+	shared *sharedBucket
+
+	// Priority scheduling of callers waiting for a token
+	queues      [numPriorities][]chan struct{}
+	credits     [numPriorities]int
+	queued      int
+	dispatching bool
+
+	// Callers that had to queue for a token, and how long they waited
+	waits  int64
+	waited time.Duration
 }
 
 // RateLimiterConfig holds configuration for the rate limiter.
@@ -56,29 +70,113 @@ func NewRateLimiter(cfg RateLimiterConfig) *RateLimiter {
 }
 
 // Wait blocks until a token is available, then consumes one token.
-// Returns an error if the context is cancelled.
+// It is equivalent to WaitPriority(PriorityNormal).
 func (r *RateLimiter) Wait() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.WaitPriority(PriorityNormal)
+}
 
-	r.refill()
+// WaitPriority blocks until a token is available for a request of class p,
+// then consumes one token. Requests are served immediately while tokens
+// remain; once callers have to queue, tokens are handed out by weighted
+// round robin across classes so no class is starved.
+func (r *RateLimiter) WaitPriority(p Priority) {
+	if p < 0 || p >= numPriorities {
+		p = PriorityNormal
+	}
 
-	// If we have tokens, use one immediately
-	if r.tokens >= 1 {
-		r.tokens--
+	r.mu.Lock()
+	if r.queued == 0 && r.takeLocked() == 0 {
+		r.mu.Unlock()
 		return
 	}
 
-	// Calculate how long until we have a token
-	deficit := 1 - r.tokens
-	waitTime := time.Duration(deficit/r.refillRate*1000) * time.Millisecond
-
+	ready := make(chan struct{})
+	r.queues[p] = append(r.queues[p], ready)
+	r.queued++
+	if !r.dispatching {
+		r.dispatching = true
+		go r.dispatch()
+	}
 	r.mu.Unlock()
-	time.Sleep(waitTime)
+
+	start := time.Now()
+	<-ready
 	r.mu.Lock()
+	r.waits++
+	r.waited += time.Since(start)
+	r.mu.Unlock()
+}
+
+// dispatch hands tokens to queued waiters until the queues drain.
+func (r *RateLimiter) dispatch() {
+	for {
+		r.mu.Lock()
+		if r.queued == 0 {
+			r.dispatching = false
+			r.mu.Unlock()
+			return
+		}
+
+		wait := r.takeLocked()
+		if wait == 0 {
+			close(r.nextWaiterLocked())
+			r.mu.Unlock()
+			continue
+		}
+		r.mu.Unlock()
+
+		time.Sleep(wait)
+	}
+}
+
+// nextWaiterLocked removes and returns the next waiter using smooth
+// weighted round robin over the non-empty queues.
+// Must be called with mutex held and at least one waiter queued.
+func (r *RateLimiter) nextWaiterLocked() chan struct{} {
+	total := 0
+	best := -1
+	for p := range r.queues {
+		if len(r.queues[p]) == 0 {
+			continue
+		}
+		r.credits[p] += priorityWeights[p]
+		total += priorityWeights[p]
+		if best < 0 || r.credits[p] > r.credits[best] {
+			best = p
+		}
+	}
+	r.credits[best] -= total
+
+	ready := r.queues[best][0]
+	r.queues[best] = r.queues[best][1:]
+	r.queued--
+	return ready
+}
+
+// takeLocked consumes a token if one is available and returns zero,
+// otherwise it returns how long until the next token. The shared bucket is
+// used when configured; if its state file cannot be used the local bucket
+// takes over rather than stalling the backup.
+// Must be called with mutex held.
+func (r *RateLimiter) takeLocked() time.Duration {
+	if r.shared != nil {
+		if wait, err := r.shared.take(time.Now()); err == nil {
+			return wait
+		}
+	}
 
 	r.refill()
-	r.tokens--
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0
+	}
+
+	deficit := 1 - r.tokens
+	wait := time.Duration(deficit/r.refillRate*1000) * time.Millisecond
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return wait
 }
 
 // refill adds tokens based on time elapsed since last refill.
@@ -146,3 +244,35 @@ func (r *RateLimiter) GetRetryCount() int {
 func (r *RateLimiter) MaxRetries() int {
 	return r.maxRetries
 }
+
+// RateLimiterStats is a snapshot of the limiter's state for monitoring.
+type RateLimiterStats struct {
+	Tokens              float64 `json:"tokens"`     // Tokens in the local bucket
+	BurstSize           float64 `json:"burst_size"` // Bucket capacity
+	RequestsPerHour     float64 `json:"requests_per_hour"`
+	Queued              int     `json:"queued"`               // Callers waiting for a token
+	ConsecutiveFailures int     `json:"consecutive_failures"` // 429s since the last success
+	Shared              bool    `json:"shared"`               // Tokens come from a shared state file
+
+	// Waits counts callers that had to queue for a token, and
+	// WaitedSeconds the time they spent queued in total
+	Waits         int64   `json:"waits"`
+	WaitedSeconds float64 `json:"waited_seconds"`
+}
+
+// Stats returns the limiter's current state.
+func (r *RateLimiter) Stats() RateLimiterStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refill()
+	return RateLimiterStats{
+		Tokens:              r.tokens,
+		BurstSize:           r.maxTokens,
+		RequestsPerHour:     r.refillRate * 3600,
+		Queued:              r.queued,
+		ConsecutiveFailures: r.consecutiveFailures,
+		Shared:              r.shared != nil,
+		Waits:               r.waits,
+		WaitedSeconds:       r.waited.Seconds(),
+	}
+}
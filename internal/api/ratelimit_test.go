@@ -1,6 +1,8 @@
 package api
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -216,3 +218,101 @@ func TestRateLimiter_Refill(t *testing.T) {
 	}
 	rl.mu.Unlock()
 }
+
+func TestRateLimiter_SharedBucket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.json")
+	cfg := RateLimiterConfig{
+		RequestsPerHour:        3600,
+		BurstSize:              3,
+		MaxRetries:             3,
+		RetryBackoffSeconds:    1,
+		RetryBackoffMultiplier: 2.0,
+		MaxBackoffSeconds:      60,
+	}
+
+	first := NewRateLimiter(cfg)
+	second := NewRateLimiter(cfg)
+	if err := first.UseSharedBucket(path); err != nil {
+		t.Fatalf("UseSharedBucket() error = %v", err)
+	}
+	if err := second.UseSharedBucket(path); err != nil {
+		t.Fatalf("UseSharedBucket() error = %v", err)
+	}
+
+	// Two limiters drain one bucket of three tokens between them.
+	first.Wait()
+	second.Wait()
+	first.Wait()
+
+	now := time.Now()
+	wait, err := first.shared.take(now)
+	if err != nil {
+		t.Fatalf("take() error = %v", err)
+	}
+	if wait <= 0 {
+		t.Errorf("expected shared bucket to be empty, got wait %v", wait)
+	}
+
+	// Local buckets are untouched while sharing.
+	if first.tokens != 3 || second.tokens != 3 {
+		t.Errorf("local tokens changed: %f, %f", first.tokens, second.tokens)
+	}
+}
+
+func TestRateLimiter_SharedBucketCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ratelimit.json")
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rl := NewRateLimiter(RateLimiterConfig{RequestsPerHour: 3600, BurstSize: 2})
+	if err := rl.UseSharedBucket(path); err != nil {
+		t.Fatalf("UseSharedBucket() error = %v", err)
+	}
+
+	wait, err := rl.shared.take(time.Now())
+	if err != nil {
+		t.Fatalf("take() error = %v", err)
+	}
+	if wait != 0 {
+		t.Errorf("expected a fresh bucket after a corrupt file, got wait %v", wait)
+	}
+}
+
+func TestRateLimiter_Stats(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{
+		RequestsPerHour: 36, // Slow enough that no token refills during the test
+		BurstSize:       3,
+		MaxRetries:      3,
+	})
+	rl.Wait()
+	rl.OnRateLimited()
+
+	stats := rl.Stats()
+	if stats.Tokens < 1.9 || stats.Tokens > 2.1 {
+		t.Errorf("Tokens = %f, want about 2", stats.Tokens)
+	}
+	if stats.BurstSize != 3 || stats.RequestsPerHour != 36 {
+		t.Errorf("BurstSize, RequestsPerHour = %f, %f, want 3, 36", stats.BurstSize, stats.RequestsPerHour)
+	}
+	if stats.ConsecutiveFailures != 1 || stats.Shared {
+		t.Errorf("Stats() = %+v, want one failure on a local bucket", stats)
+	}
+}
+
+func TestRateLimiter_StatsCountsWaits(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{
+		RequestsPerHour: 36000, // A token every 100ms
+		BurstSize:       1,
+		MaxRetries:      3,
+	})
+	rl.Wait()
+	if stats := rl.Stats(); stats.Waits != 0 {
+		t.Errorf("Waits = %d after a token from the bucket, want 0", stats.Waits)
+	}
+	rl.Wait()
+	stats := rl.Stats()
+	if stats.Waits != 1 || stats.WaitedSeconds < 0.05 {
+		t.Errorf("Waits, WaitedSeconds = %d, %f, want 1 wait of about 0.1s", stats.Waits, stats.WaitedSeconds)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import "encoding/json"
+
+// Bitbucket adds fields to its payloads over time. Types persisted as part
+// of a backup keep the payload they were decoded from and write it back out
+// unchanged, so fields the typed structs don't know about are not lost.
+// The typed fields are still populated for use in memory. Workspaces,
+// projects, and repositories record their payload too but keep the typed
+// encoding unless the backup runs in raw mode.
+
+// unmarshalRaw decodes data into v and records a copy of data in raw.
+func unmarshalRaw(data []byte, v interface{}, raw *json.RawMessage) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	*raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// marshalRaw returns raw when set, otherwise the encoding of v.
+func marshalRaw(raw json.RawMessage, v interface{}) ([]byte, error) {
+	if len(raw) > 0 {
+		return raw, nil
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a pull request and keeps its raw payload.
+func (p *PullRequest) UnmarshalJSON(data []byte) error {
+	type plain PullRequest
+	return unmarshalRaw(data, (*plain)(p), &p.Raw)
+}
+
+// MarshalJSON writes the raw payload when available.
+func (p PullRequest) MarshalJSON() ([]byte, error) {
+	type plain PullRequest
+	return marshalRaw(p.Raw, plain(p))
+}
+
+// UnmarshalJSON decodes a PR comment and keeps its raw payload.
+func (c *PRComment) UnmarshalJSON(data []byte) error {
+	type plain PRComment
+	return unmarshalRaw(data, (*plain)(c), &c.Raw)
+}
+
+// MarshalJSON writes the raw payload when available.
+func (c PRComment) MarshalJSON() ([]byte, error) {
+	type plain PRComment
+	return marshalRaw(c.Raw, plain(c))
+}
+
+// UnmarshalJSON decodes a PR activity entry and keeps its raw payload.
+func (a *PRActivity) UnmarshalJSON(data []byte) error {
+	type plain PRActivity
+	return unmarshalRaw(data, (*plain)(a), &a.Raw)
+}
+
+// MarshalJSON writes the raw payload when available.
+func (a PRActivity) MarshalJSON() ([]byte, error) {
+	type plain PRActivity
+	return marshalRaw(a.Raw, plain(a))
+}
+
+// UnmarshalJSON decodes a PR task and keeps its raw payload.
+func (t *PRTask) UnmarshalJSON(data []byte) error {
+	type plain PRTask
+	return unmarshalRaw(data, (*plain)(t), &t.Raw)
+}
+
+// MarshalJSON writes the raw payload when available.
+func (t PRTask) MarshalJSON() ([]byte, error) {
+	type plain PRTask
+	return marshalRaw(t.Raw, plain(t))
+}
+
+// UnmarshalJSON decodes an issue and keeps its raw payload.
+func (i *Issue) UnmarshalJSON(data []byte) error {
+	type plain Issue
+	return unmarshalRaw(data, (*plain)(i), &i.Raw)
+}
+
+// MarshalJSON writes the raw payload when available.
+func (i Issue) MarshalJSON() ([]byte, error) {
+	type plain Issue
+	return marshalRaw(i.Raw, plain(i))
+}
+
+// UnmarshalJSON decodes an issue comment and keeps its raw payload.
+func (c *IssueComment) UnmarshalJSON(data []byte) error {
+	type plain IssueComment
+	return unmarshalRaw(data, (*plain)(c), &c.Raw)
+}
+
+// MarshalJSON writes the raw payload when available.
+func (c IssueComment) MarshalJSON() ([]byte, error) {
+	type plain IssueComment
+	return marshalRaw(c.Raw, plain(c))
+}
+
+// UnmarshalJSON decodes an issue change and keeps its raw payload.
+func (c *IssueChange) UnmarshalJSON(data []byte) error {
+	type plain IssueChange
+	return unmarshalRaw(data, (*plain)(c), &c.Raw)
+}
+
+// MarshalJSON writes the raw payload when available.
+func (c IssueChange) MarshalJSON() ([]byte, error) {
+	type plain IssueChange
+	return marshalRaw(c.Raw, plain(c))
+}
+
+// UnmarshalJSON decodes a workspace and keeps its raw payload.
+func (w *Workspace) UnmarshalJSON(data []byte) error {
+	type plain Workspace
+	return unmarshalRaw(data, (*plain)(w), &w.Raw)
+}
+
+// UnmarshalJSON decodes a project and keeps its raw payload.
+func (p *Project) UnmarshalJSON(data []byte) error {
+	type plain Project
+	return unmarshalRaw(data, (*plain)(p), &p.Raw)
+}
+
+// UnmarshalJSON decodes a repository and keeps its raw payload.
+func (r *Repository) UnmarshalJSON(data []byte) error {
+	type plain Repository
+	return unmarshalRaw(data, (*plain)(r), &r.Raw)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPullRequest_PreservesUnknownFields(t *testing.T) {
+	payload := `{"id":7,"title":"Add feature","draft":true,"queued":{"position":2}}`
+
+	var pr PullRequest
+	if err := json.Unmarshal([]byte(payload), &pr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pr.ID != 7 || pr.Title != "Add feature" {
+		t.Errorf("typed fields not populated: %+v", pr)
+	}
+
+	out, err := json.Marshal(pr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != payload {
+		t.Errorf("expected payload to round-trip verbatim, got %s", out)
+	}
+}
+
+func TestIssue_NestedRawInSlice(t *testing.T) {
+	payload := `[{"id":1,"title":"Bug","votes_detail":{"up":3}},{"id":2,"title":"Other"}]`
+
+	var issues []Issue
+	if err := json.Unmarshal([]byte(payload), &issues); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, err := json.Marshal(issues)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(out), `"votes_detail":{"up":3}`) {
+		t.Errorf("expected unknown field to survive, got %s", out)
+	}
+}
+
+func TestPullRequest_MarshalWithoutRaw(t *testing.T) {
+	pr := PullRequest{ID: 3, Title: "Constructed"}
+
+	out, err := json.Marshal(pr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(out), `"title":"Constructed"`) {
+		t.Errorf("expected typed encoding, got %s", out)
+	}
+	if strings.Contains(string(out), "Raw") {
+		t.Errorf("raw field should not be encoded, got %s", out)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strings"
 )
 
 // Repository represents a Bitbucket repository.
@@ -19,6 +21,7 @@ type Repository struct {
 	Language    string   `json:"language"`
 	HasIssues   bool     `json:"has_issues"`
 	HasWiki     bool     `json:"has_wiki"`
+	IsArchived  bool     `json:"is_archived"`
 	SCM         string   `json:"scm"`
 	Size        int64    `json:"size"`
 	Links       Links    `json:"links"`
@@ -27,6 +30,13 @@ type Repository struct {
 	Owner       *User    `json:"owner,omitempty"`
 	CreatedOn   string   `json:"created_on"`
 	UpdatedOn   string   `json:"updated_on"`
+
+	// Parent is the repository a fork was forked from (nil otherwise); only
+	// its identifying fields are set
+	Parent *Repository `json:"parent,omitempty"`
+
+	// Raw holds the API payload verbatim; it is written in raw mode.
+	Raw json.RawMessage `json:"-"`
 }
 
 // Branch represents a git branch.
@@ -36,9 +46,10 @@ type Branch struct {
 }
 
 // GetRepositories fetches all repositories in a workspace.
-func (c *Client) GetRepositories(ctx context.Context, workspace string) ([]Repository, error) {
+func (p *cloudProvider) GetRepositories(ctx context.Context, workspace string) ([]Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
 	path := fmt.Sprintf("/repositories/%s", workspace)
-	values, err := c.GetPaginated(ctx, path)
+	values, err := p.c.GetPaginated(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching repositories for workspace %s: %w", workspace, err)
 	}
@@ -56,9 +67,10 @@ func (c *Client) GetRepositories(ctx context.Context, workspace string) ([]Repos
 }
 
 // GetRepository fetches a single repository.
-func (c *Client) GetRepository(ctx context.Context, workspace, repoSlug string) (*Repository, error) {
+func (p *cloudProvider) GetRepository(ctx context.Context, workspace, repoSlug string) (*Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
 	path := fmt.Sprintf("/repositories/%s/%s", workspace, repoSlug)
-	body, err := c.Get(ctx, path)
+	body, err := p.c.Get(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching repository %s/%s: %w", workspace, repoSlug, err)
 	}
@@ -71,14 +83,99 @@ func (c *Client) GetRepository(ctx context.Context, workspace, repoSlug string)
 	return &r, nil
 }
 
+// CreateRepository creates an empty git repository like repo. Without a
+// project, Cloud puts it in the workspace's default project.
+func (p *cloudProvider) CreateRepository(ctx context.Context, workspace string, repo *Repository) (*Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	payload := map[string]interface{}{
+		"scm":         "git",
+		"name":        repo.Name,
+		"description": repo.Description,
+		"is_private":  repo.IsPrivate,
+	}
+	if repo.Name == "" {
+		payload["name"] = repo.Slug
+	}
+	if repo.ForkPolicy != "" {
+		payload["fork_policy"] = repo.ForkPolicy
+	}
+	if repo.Project != nil {
+		payload["project"] = map[string]string{"key": repo.Project.Key}
+	}
+	path := fmt.Sprintf("/repositories/%s/%s", workspace, url.PathEscape(repo.Slug))
+	body, err := p.c.Post(ctx, path, payload)
+	if err != nil {
+		return nil, fmt.Errorf("creating repository %s/%s: %w", workspace, repo.Slug, err)
+	}
+
+	var r Repository
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("parsing repository response: %w", err)
+	}
+
+	return &r, nil
+}
+
+// GetFileContent fetches the raw content of a file in a repository at
+// ref, a branch, tag, or commit.
+func (p *cloudProvider) GetFileContent(ctx context.Context, workspace, repoSlug, ref, filePath string) ([]byte, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	segments := strings.Split(filePath, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	path := fmt.Sprintf("/repositories/%s/%s/src/%s/%s", workspace, repoSlug, url.PathEscape(ref), strings.Join(segments, "/"))
+	body, err := p.c.Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s at %s in %s/%s: %w", filePath, ref, workspace, repoSlug, err)
+	}
+	return body, nil
+}
+
+// SampleRepository fetches one repository the credentials can read, or
+// nil when they can read none. It is a cheap check that the API accepts
+// them.
+func (p *cloudProvider) SampleRepository(ctx context.Context, workspace string) (*Repository, error) {
+	path := fmt.Sprintf("/repositories/%s?pagelen=1", url.PathEscape(workspace))
+	body, err := p.c.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var page struct {
+		Values []json.RawMessage `json:"values"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("parsing repositories: %w", err)
+	}
+	if len(page.Values) == 0 {
+		return nil, nil
+	}
+	var r Repository
+	if err := json.Unmarshal(page.Values[0], &r); err != nil {
+		return nil, fmt.Errorf("parsing repositories: %w", err)
+	}
+	return &r, nil
+}
+
 // GetProjectRepositories fetches all repositories in a specific project.
 func (c *Client) GetProjectRepositories(ctx context.Context, workspace, projectKey string) ([]Repository, error) {
-	// Use query parameter to filter by project
-	path := fmt.Sprintf("/repositories/%s?q=project.key=\"%s\"", workspace, projectKey)
-	values, err := c.GetPaginated(ctx, path)
+	repos, err := c.QueryRepositories(ctx, workspace, `project.key="`+projectKey+`"`)
 	if err != nil {
 		return nil, fmt.Errorf("fetching repositories for project %s/%s: %w", workspace, projectKey, err)
 	}
+	return repos, nil
+}
+
+// QueryRepositories fetches the repositories in a workspace that match a
+// Bitbucket query expression, e.g. `slug ~ "core-"`.
+func (p *cloudProvider) QueryRepositories(ctx context.Context, workspace, query string) ([]Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	path := fmt.Sprintf("/repositories/%s?q=%s", workspace, url.QueryEscape(query))
+	values, err := p.c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("querying repositories for workspace %s: %w", workspace, err)
+	}
 
 	repos := make([]Repository, 0, len(values))
 	for _, v := range values {
@@ -94,6 +191,7 @@ func (c *Client) GetProjectRepositories(ctx context.Context, workspace, projectK
 
 // GetPersonalRepositories fetches repositories that don't belong to any project.
 func (c *Client) GetPersonalRepositories(ctx context.Context, workspace string) ([]Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
 	// Fetch all repositories and filter those without a project
 	allRepos, err := c.GetRepositories(ctx, workspace)
 	if err != nil {
@@ -119,3 +217,13 @@ func (r *Repository) CloneURL() string {
 	}
 	return ""
 }
+
+// SSHCloneURL returns the SSH clone URL for a repository.
+func (r *Repository) SSHCloneURL() string {
+	for _, link := range r.Links.Clone {
+		if link.Name == "ssh" {
+			return link.Href
+		}
+	}
+	return ""
+}
@@ -0,0 +1,827 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bitbucket Data Center and Server (api.type: server) have a REST API of
+// their own under ServerAPIPath. Its pagination, URLs, and models differ
+// from Cloud's, so a client for it has a serverProvider as its Provider,
+// which maps the responses onto the Cloud types used by the rest of
+// bb-backup. Repositories live in projects rather than a
+// workspace: the workspace argument only names the backup, and a
+// repository's project is looked up from its slug. Data Center has no
+// issue trackers, wikis, or Downloads, so repositories report none.
+
+// ServerAPIPath is where Data Center serves its REST API, under
+// api.base_url.
+const ServerAPIPath = "/rest/api/1.0"
+
+// serverPageLimit is the number of values asked for per page.
+const serverPageLimit = 100
+
+// serverPage is one page of a Data Center list response.
+type serverPage struct {
+	Values        []json.RawMessage `json:"values"`
+	IsLastPage    bool              `json:"isLastPage"`
+	NextPageStart *int              `json:"nextPageStart"`
+}
+
+// serverErrors is the body of a Data Center error response.
+type serverErrors struct {
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// serverLinks are Data Center hypermedia links, where self is a list.
+type serverLinks struct {
+	Self  []Link `json:"self"`
+	Clone []Link `json:"clone"`
+}
+
+// html returns the first self link, the resource's page in the web UI.
+func (l serverLinks) html() Link {
+	if len(l.Self) == 0 {
+		return Link{}
+	}
+	return Link{Href: l.Self[0].Href}
+}
+
+type serverUser struct {
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Slug        string      `json:"slug"`
+	DisplayName string      `json:"displayName"`
+	Links       serverLinks `json:"links"`
+}
+
+type serverProject struct {
+	ID          int         `json:"id"`
+	Key         string      `json:"key"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Public      bool        `json:"public"`
+	Type        string      `json:"type"` // NORMAL, or PERSONAL for a user's repositories
+	Owner       *serverUser `json:"owner,omitempty"`
+	Links       serverLinks `json:"links"`
+}
+
+type serverRepository struct {
+	ID          int               `json:"id"`
+	Slug        string            `json:"slug"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	ScmID       string            `json:"scmId"`
+	Forkable    bool              `json:"forkable"`
+	Public      bool              `json:"public"`
+	Archived    bool              `json:"archived"`
+	Project     serverProject     `json:"project"`
+	Origin      *serverRepository `json:"origin,omitempty"` // The parent of a fork
+	Links       serverLinks       `json:"links"`
+}
+
+type serverRef struct {
+	ID           string            `json:"id"`
+	DisplayID    string            `json:"displayId"`
+	LatestCommit string            `json:"latestCommit"`
+	Repository   *serverRepository `json:"repository,omitempty"`
+}
+
+type serverParticipant struct {
+	User     *serverUser `json:"user"`
+	Role     string      `json:"role"`
+	Approved bool        `json:"approved"`
+	Status   string      `json:"status"` // APPROVED, NEEDS_WORK, or UNAPPROVED
+}
+
+type serverPullRequest struct {
+	ID           int                 `json:"id"`
+	Title        string              `json:"title"`
+	Description  string              `json:"description"`
+	State        string              `json:"state"`
+	CreatedDate  int64               `json:"createdDate"`
+	UpdatedDate  int64               `json:"updatedDate"`
+	ClosedDate   int64               `json:"closedDate,omitempty"`
+	FromRef      serverRef           `json:"fromRef"`
+	ToRef        serverRef           `json:"toRef"`
+	Author       serverParticipant   `json:"author"`
+	Reviewers    []serverParticipant `json:"reviewers"`
+	Participants []serverParticipant `json:"participants"`
+	Properties   struct {
+		CommentCount  int `json:"commentCount"`
+		OpenTaskCount int `json:"openTaskCount"`
+		MergeCommit   *struct {
+			ID string `json:"id"`
+		} `json:"mergeCommit,omitempty"`
+	} `json:"properties"`
+	Links serverLinks `json:"links"`
+}
+
+type serverComment struct {
+	ID          int             `json:"id"`
+	Text        string          `json:"text"`
+	Author      *serverUser     `json:"author"`
+	CreatedDate int64           `json:"createdDate"`
+	UpdatedDate int64           `json:"updatedDate"`
+	Severity    string          `json:"severity"` // BLOCKER comments are tasks
+	State       string          `json:"state"`    // OPEN or RESOLVED
+	Comments    []serverComment `json:"comments"` // Replies
+}
+
+type serverCommentAnchor struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+}
+
+type serverActivity struct {
+	ID            int                  `json:"id"`
+	CreatedDate   int64                `json:"createdDate"`
+	User          *serverUser          `json:"user"`
+	Action        string               `json:"action"`
+	CommentAction string               `json:"commentAction,omitempty"`
+	Comment       *serverComment       `json:"comment,omitempty"`
+	CommentAnchor *serverCommentAnchor `json:"commentAnchor,omitempty"`
+}
+
+// serverProvider talks to a Data Center instance for a Client.
+type serverProvider struct {
+	c *Client
+
+	// projects maps repository slugs to the keys of the projects holding
+	// them, from listings; ambiguous marks slugs in more than one project
+	mu        sync.Mutex
+	projects  map[string]string
+	ambiguous map[string][]string
+}
+
+func newServerProvider(c *Client) *serverProvider {
+	return &serverProvider{c: c, projects: make(map[string]string), ambiguous: make(map[string][]string)}
+}
+
+// getPaginated fetches every page of a Data Center list endpoint.
+func (s *serverProvider) getPaginated(ctx context.Context, path string) ([]json.RawMessage, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	var all []json.RawMessage
+	start := 0
+	for page := 1; ; page++ {
+		body, err := s.c.Get(ctx, fmt.Sprintf("%s%sstart=%d&limit=%d", path, separator, start, serverPageLimit))
+		if err != nil {
+			return nil, err
+		}
+		var p serverPage
+		if err := json.Unmarshal(body, &p); err != nil {
+			return nil, fmt.Errorf("parsing paginated response: %w", err)
+		}
+		all = append(all, p.Values...)
+		if s.c.progressFunc != nil {
+			s.c.progressFunc(page, len(all))
+		}
+		if p.IsLastPage || p.NextPageStart == nil || *p.NextPageStart <= start {
+			return all, nil
+		}
+		start = *p.NextPageStart
+	}
+}
+
+// repoPath returns the API path of a repository from its slug.
+func (s *serverProvider) repoPath(ctx context.Context, repoSlug string) (string, error) {
+	key, err := s.projectOf(ctx, repoSlug)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("/projects/%s/repos/%s", url.PathEscape(key), url.PathEscape(repoSlug)), nil
+}
+
+// projectOf returns the key of the project holding a repository. A slug
+// not seen in a listing yet is looked for in every project's repository
+// listing, since Data Center can only filter repositories by name, which
+// may differ from the slug.
+func (s *serverProvider) projectOf(ctx context.Context, repoSlug string) (string, error) {
+	s.mu.Lock()
+	key, ok := s.projects[repoSlug]
+	s.mu.Unlock()
+	if !ok {
+		if err := s.listProjectRepositories(ctx); err != nil {
+			return "", err
+		}
+		s.mu.Lock()
+		key, ok = s.projects[repoSlug]
+		s.mu.Unlock()
+	}
+	if !ok {
+		return "", &APIError{StatusCode: 404, Message: fmt.Sprintf("repository %s not found", repoSlug)}
+	}
+	s.mu.Lock()
+	keys := s.ambiguous[repoSlug]
+	s.mu.Unlock()
+	if len(keys) > 0 {
+		return "", fmt.Errorf("repository slug %s is in projects %s; limit backup.include_projects to one of them", repoSlug, strings.Join(keys, ", "))
+	}
+	return key, nil
+}
+
+// listProjectRepositories lists the repositories of every project, so
+// their projects are remembered.
+func (s *serverProvider) listProjectRepositories(ctx context.Context) error {
+	projects, err := s.GetProjects(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, p := range projects {
+		if _, err := s.listRepositories(ctx, "/projects/"+url.PathEscape(p.Key)+"/repos"); err != nil {
+			return fmt.Errorf("fetching repositories for project %s: %w", p.Key, err)
+		}
+	}
+	return nil
+}
+
+// remember records which project holds each listed repository.
+func (s *serverProvider) remember(repos []serverRepository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range repos {
+		key, ok := s.projects[r.Slug]
+		switch {
+		case !ok:
+			s.projects[r.Slug] = r.Project.Key
+		case key != r.Project.Key:
+			keys := s.ambiguous[r.Slug]
+			if len(keys) == 0 {
+				keys = []string{key}
+			}
+			if !containsString(keys, r.Project.Key) {
+				keys = append(keys, r.Project.Key)
+				sort.Strings(keys)
+			}
+			s.ambiguous[r.Slug] = keys
+		}
+	}
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *serverProvider) GetWorkspace(ctx context.Context, workspace string) (*Workspace, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	body, err := s.c.Get(ctx, "/application-properties")
+	if err != nil {
+		return nil, fmt.Errorf("fetching server properties: %w", err)
+	}
+	var props struct {
+		DisplayName string `json:"displayName"`
+		Version     string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &props); err != nil {
+		return nil, fmt.Errorf("parsing server properties: %w", err)
+	}
+	name := workspace
+	if props.DisplayName != "" {
+		name = props.DisplayName
+	}
+	return &Workspace{Type: "workspace", Name: name, Slug: workspace, IsPrivate: true}, nil
+}
+
+func (s *serverProvider) GetProjects(ctx context.Context, _ string) ([]Project, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	values, err := s.getPaginated(ctx, "/projects")
+	if err != nil {
+		return nil, fmt.Errorf("fetching projects: %w", err)
+	}
+	projects := make([]Project, 0, len(values))
+	for _, v := range values {
+		var p serverProject
+		if err := json.Unmarshal(v, &p); err != nil {
+			return nil, fmt.Errorf("parsing project: %w", err)
+		}
+		projects = append(projects, p.toCloud())
+	}
+	return projects, nil
+}
+
+func (s *serverProvider) GetProject(ctx context.Context, _, projectKey string) (*Project, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	body, err := s.c.Get(ctx, "/projects/"+url.PathEscape(projectKey))
+	if err != nil {
+		return nil, fmt.Errorf("fetching project %s: %w", projectKey, err)
+	}
+	var p serverProject
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("parsing project response: %w", err)
+	}
+	project := p.toCloud()
+	return &project, nil
+}
+
+// listRepositories fetches repositories from a list path and remembers
+// their projects.
+func (s *serverProvider) listRepositories(ctx context.Context, path string) ([]Repository, error) {
+	values, err := s.getPaginated(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	listed := make([]serverRepository, 0, len(values))
+	for _, v := range values {
+		var r serverRepository
+		if err := json.Unmarshal(v, &r); err != nil {
+			return nil, fmt.Errorf("parsing repository: %w", err)
+		}
+		listed = append(listed, r)
+	}
+	s.remember(listed)
+
+	repos := make([]Repository, 0, len(listed))
+	for _, r := range listed {
+		repos = append(repos, r.toCloud())
+	}
+	return repos, nil
+}
+
+func (s *serverProvider) GetRepositories(ctx context.Context, _ string) ([]Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	return s.listRepositories(ctx, "/repos")
+}
+
+// QueryRepositories lists repositories for a Cloud query expression. Only
+// a leading project.key="KEY" condition narrows the listing; other
+// conditions are left to the caller's filters.
+func (s *serverProvider) QueryRepositories(ctx context.Context, _, query string) ([]Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	if rest, ok := strings.CutPrefix(query, `project.key="`); ok {
+		if key, _, ok := strings.Cut(rest, `"`); ok {
+			return s.listRepositories(ctx, "/projects/"+url.PathEscape(key)+"/repos")
+		}
+	}
+	return s.listRepositories(ctx, "/repos")
+}
+
+func (s *serverProvider) GetRepository(ctx context.Context, _, repoSlug string) (*Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	path, err := s.repoPath(ctx, repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.c.Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching repository %s: %w", repoSlug, err)
+	}
+	var r serverRepository
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("parsing repository response: %w", err)
+	}
+	repo := r.toCloud()
+	return &repo, nil
+}
+
+// CreateRepository creates a repository in repo's project, or in its
+// owner's personal project. Data Center derives the slug from the name, so
+// the name is what is sent.
+func (s *serverProvider) CreateRepository(ctx context.Context, _ string, repo *Repository) (*Repository, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	var key string
+	switch {
+	case repo.Project != nil:
+		key = repo.Project.Key
+	case repo.Owner != nil && repo.Owner.Username != "":
+		key = "~" + strings.ToUpper(repo.Owner.Username)
+	default:
+		return nil, fmt.Errorf("creating repository %s: no project or owner", repo.Slug)
+	}
+	name := repo.Name
+	if name == "" {
+		name = repo.Slug
+	}
+	payload := map[string]interface{}{
+		"name":        name,
+		"scmId":       "git",
+		"description": repo.Description,
+		"forkable":    repo.ForkPolicy != "no_forks",
+		"public":      !repo.IsPrivate,
+	}
+	body, err := s.c.Post(ctx, "/projects/"+url.PathEscape(key)+"/repos", payload)
+	if err != nil {
+		return nil, fmt.Errorf("creating repository %s in %s: %w", repo.Slug, key, err)
+	}
+	var r serverRepository
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("parsing repository response: %w", err)
+	}
+	s.remember([]serverRepository{r})
+	created := r.toCloud()
+	return &created, nil
+}
+
+func (s *serverProvider) GetFileContent(ctx context.Context, _, repoSlug, ref, filePath string) ([]byte, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
+	path, err := s.repoPath(ctx, repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	segments := strings.Split(filePath, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	body, err := s.c.Get(ctx, path+"/raw/"+strings.Join(segments, "/")+"?at="+url.QueryEscape(ref))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s at %s in %s: %w", filePath, ref, repoSlug, err)
+	}
+	return body, nil
+}
+
+func (s *serverProvider) SampleRepository(ctx context.Context, _ string) (*Repository, error) {
+	body, err := s.c.Get(ctx, "/repos?limit=1")
+	if err != nil {
+		return nil, err
+	}
+	var p serverPage
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("parsing repositories: %w", err)
+	}
+	if len(p.Values) == 0 {
+		return nil, nil
+	}
+	var r serverRepository
+	if err := json.Unmarshal(p.Values[0], &r); err != nil {
+		return nil, fmt.Errorf("parsing repositories: %w", err)
+	}
+	repo := r.toCloud()
+	return &repo, nil
+}
+
+// ListDownloads returns none: Data Center has no Downloads section.
+func (s *serverProvider) ListDownloads(context.Context, string, string) ([]RepoDownload, error) {
+	return nil, nil
+}
+
+// GetWorkspaceMembers returns the instance's users, as Data Center has
+// no workspace membership.
+func (s *serverProvider) GetWorkspaceMembers(ctx context.Context, _ string) ([]WorkspaceMembership, error) {
+	values, err := s.getPaginated(ctx, "/users")
+	if err != nil {
+		return nil, fmt.Errorf("fetching users: %w", err)
+	}
+	members := make([]WorkspaceMembership, 0, len(values))
+	for _, v := range values {
+		var u serverUser
+		if err := json.Unmarshal(v, &u); err != nil {
+			return nil, fmt.Errorf("parsing user: %w", err)
+		}
+		members = append(members, WorkspaceMembership{Type: "workspace_membership", User: *u.toCloud()})
+	}
+	return members, nil
+}
+
+// GetAllPullRequests fetches every pull request of a repository, in all
+// states.
+func (s *serverProvider) GetAllPullRequests(ctx context.Context, _, repoSlug string) ([]PullRequest, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	path, err := s.repoPath(ctx, repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.getPaginated(ctx, path+"/pull-requests?state=ALL")
+	if err != nil {
+		return nil, fmt.Errorf("fetching pull requests for %s: %w", repoSlug, err)
+	}
+	prs := make([]PullRequest, 0, len(values))
+	for _, v := range values {
+		var pr serverPullRequest
+		if err := json.Unmarshal(v, &pr); err != nil {
+			return nil, fmt.Errorf("parsing pull request: %w", err)
+		}
+		prs = append(prs, pr.toCloud())
+	}
+	return prs, nil
+}
+
+// GetPullRequestsUpdatedSince returns the pull requests updated after
+// since. Data Center can't filter by update time, so every pull request
+// is listed and the rest dropped.
+func (s *serverProvider) GetPullRequestsUpdatedSince(ctx context.Context, workspace, repoSlug, since string) ([]PullRequest, error) {
+	cutoff, err := time.Parse(time.RFC3339Nano, since)
+	if err != nil {
+		return nil, fmt.Errorf("parsing update time %q: %w", since, err)
+	}
+	prs, err := s.GetAllPullRequests(ctx, workspace, repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	updated := prs[:0]
+	for _, pr := range prs {
+		if t, err := time.Parse(time.RFC3339Nano, pr.UpdatedOn); err == nil && t.After(cutoff) {
+			updated = append(updated, pr)
+		}
+	}
+	return updated, nil
+}
+
+func (s *serverProvider) GetPullRequest(ctx context.Context, _, repoSlug string, prID int) (*PullRequest, error) {
+	path, err := s.repoPath(ctx, repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.c.Get(ctx, fmt.Sprintf("%s/pull-requests/%d", path, prID))
+	if err != nil {
+		return nil, fmt.Errorf("fetching pull request %d: %w", prID, err)
+	}
+	var pr serverPullRequest
+	if err := json.Unmarshal(body, &pr); err != nil {
+		return nil, fmt.Errorf("parsing pull request response: %w", err)
+	}
+	converted := pr.toCloud()
+	return &converted, nil
+}
+
+// getActivities fetches a pull request's activity stream, oldest first.
+// Data Center returns comments, tasks, and reviews through it.
+func (s *serverProvider) getActivities(ctx context.Context, repoSlug string, prID int) ([]serverActivity, error) {
+	path, err := s.repoPath(ctx, repoSlug)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.getPaginated(ctx, fmt.Sprintf("%s/pull-requests/%d/activities", path, prID))
+	if err != nil {
+		return nil, fmt.Errorf("fetching activity for PR %d: %w", prID, err)
+	}
+	activities := make([]serverActivity, 0, len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		var a serverActivity
+		if err := json.Unmarshal(values[i], &a); err != nil {
+			return nil, fmt.Errorf("parsing activity: %w", err)
+		}
+		activities = append(activities, a)
+	}
+	return activities, nil
+}
+
+// GetPullRequestComments returns a pull request's comments and their
+// replies, flattened with Parent set as in Cloud. Tasks are left out.
+func (s *serverProvider) GetPullRequestComments(ctx context.Context, _, repoSlug string, prID int) ([]PRComment, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	activities, err := s.getActivities(ctx, repoSlug, prID)
+	if err != nil {
+		return nil, err
+	}
+	var comments []PRComment
+	for _, a := range activities {
+		if a.Action != "COMMENTED" || a.CommentAction != "ADDED" || a.Comment == nil || a.Comment.Severity == "BLOCKER" {
+			continue
+		}
+		comments = appendServerComments(comments, *a.Comment, nil, a.CommentAnchor)
+	}
+	return comments, nil
+}
+
+// appendServerComments appends a comment and its replies.
+func appendServerComments(comments []PRComment, sc serverComment, parent *PRComment, anchor *serverCommentAnchor) []PRComment {
+	comment := PRComment{
+		Type:      "pullrequest_comment",
+		ID:        sc.ID,
+		CreatedOn: serverTime(sc.CreatedDate),
+		UpdatedOn: serverTime(sc.UpdatedDate),
+		Content:   &Content{Type: "rendered", Raw: sc.Text, Markup: "markdown"},
+		User:      sc.Author.toCloud(),
+	}
+	if parent != nil {
+		comment.Parent = &PRComment{ID: parent.ID}
+	}
+	if anchor != nil && anchor.Path != "" {
+		comment.Inline = &Inline{Path: anchor.Path}
+		if anchor.Line > 0 {
+			line := anchor.Line
+			comment.Inline.To = &line
+		}
+	}
+	comments = append(comments, comment)
+	for _, reply := range sc.Comments {
+		comments = appendServerComments(comments, reply, &comment, nil)
+	}
+	return comments
+}
+
+// GetPullRequestActivity maps a pull request's approvals, reviews, and
+// state changes onto Cloud activity entries.
+func (s *serverProvider) GetPullRequestActivity(ctx context.Context, _, repoSlug string, prID int) ([]PRActivity, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	activities, err := s.getActivities(ctx, repoSlug, prID)
+	if err != nil {
+		return nil, err
+	}
+	var out []PRActivity
+	for _, a := range activities {
+		date, user := serverTime(a.CreatedDate), a.User.toCloud()
+		switch a.Action {
+		case "APPROVED":
+			out = append(out, PRActivity{Approval: &PRApproval{Date: date, User: user}})
+		case "REVIEWED":
+			out = append(out, PRActivity{Changes: &PRChanges{Date: date, User: user}})
+		case "COMMENTED":
+			if a.CommentAction == "ADDED" && a.Comment != nil {
+				comments := appendServerComments(nil, *a.Comment, nil, a.CommentAnchor)
+				out = append(out, PRActivity{Comment: &comments[0]})
+			}
+		case "OPENED", "REOPENED", "UPDATED", "RESCOPED", "MERGED", "DECLINED":
+			out = append(out, PRActivity{Update: &PRUpdate{Date: date, Author: user, State: serverUpdateState(a.Action)}})
+		}
+	}
+	return out, nil
+}
+
+// GetPullRequestTasks returns a pull request's tasks, which Data Center
+// keeps as BLOCKER comments.
+func (s *serverProvider) GetPullRequestTasks(ctx context.Context, _, repoSlug string, prID int) ([]PRTask, error) {
+	ctx = withDefaultPriority(ctx, PriorityLow)
+	activities, err := s.getActivities(ctx, repoSlug, prID)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []PRTask
+	for _, a := range activities {
+		if a.Action != "COMMENTED" || a.CommentAction != "ADDED" || a.Comment == nil || a.Comment.Severity != "BLOCKER" {
+			continue
+		}
+		c := a.Comment
+		task := PRTask{
+			ID:        c.ID,
+			State:     "UNRESOLVED",
+			Content:   &Content{Type: "rendered", Raw: c.Text, Markup: "markdown"},
+			Creator:   c.Author.toCloud(),
+			CreatedOn: serverTime(c.CreatedDate),
+			UpdatedOn: serverTime(c.UpdatedDate),
+		}
+		if c.State == "RESOLVED" {
+			task.State = "RESOLVED"
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// serverUpdateState returns the Cloud pull request state an activity
+// action leaves behind.
+func serverUpdateState(action string) string {
+	switch action {
+	case "MERGED", "DECLINED":
+		return action
+	}
+	return "OPEN"
+}
+
+// serverTime formats Data Center's milliseconds since the epoch like
+// Cloud's timestamps, or "" for none.
+func serverTime(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+}
+
+func (u *serverUser) toCloud() *User {
+	if u == nil {
+		return nil
+	}
+	return &User{
+		Type:        "user",
+		UUID:        fmt.Sprintf("{%d}", u.ID),
+		Username:    u.Slug,
+		DisplayName: u.DisplayName,
+		Nickname:    u.Name,
+		Links:       Links{HTML: u.Links.html()},
+	}
+}
+
+func (p serverProject) toCloud() Project {
+	return Project{
+		Type:        "project",
+		UUID:        fmt.Sprintf("{%d}", p.ID),
+		Key:         p.Key,
+		Name:        p.Name,
+		Description: p.Description,
+		IsPrivate:   !p.Public,
+		Links:       Links{HTML: p.Links.html()},
+		Owner:       p.Owner.toCloud(),
+	}
+}
+
+func (r serverRepository) toCloud() Repository {
+	repo := Repository{
+		Type:        "repository",
+		UUID:        fmt.Sprintf("{%d}", r.ID),
+		Name:        r.Name,
+		Slug:        r.Slug,
+		FullName:    r.Project.Key + "/" + r.Slug,
+		Description: r.Description,
+		IsPrivate:   !r.Public,
+		IsArchived:  r.Archived,
+		SCM:         r.ScmID,
+		Links:       Links{HTML: r.Links.html()},
+	}
+	if !r.Forkable {
+		repo.ForkPolicy = "no_forks"
+	}
+	// Data Center names its HTTP(S) clone link "http"
+	for _, link := range r.Links.Clone {
+		name := link.Name
+		if name == "http" {
+			name = "https"
+		}
+		repo.Links.Clone = append(repo.Links.Clone, Link{Href: link.Href, Name: name})
+	}
+	if r.Project.Type == "PERSONAL" {
+		// Like Cloud repositories outside any project
+		repo.Owner = r.Project.Owner.toCloud()
+		if repo.Owner == nil {
+			repo.Owner = &User{Type: "user", Username: strings.ToLower(strings.TrimPrefix(r.Project.Key, "~"))}
+		}
+		repo.FullName = repo.Owner.Username + "/" + r.Slug
+	} else {
+		project := r.Project.toCloud()
+		repo.Project = &project
+	}
+	if r.Origin != nil {
+		repo.Parent = &Repository{
+			Type:     "repository",
+			UUID:     fmt.Sprintf("{%d}", r.Origin.ID),
+			Slug:     r.Origin.Slug,
+			FullName: r.Origin.Project.Key + "/" + r.Origin.Slug,
+		}
+	}
+	return repo
+}
+
+func (pr serverPullRequest) toCloud() PullRequest {
+	out := PullRequest{
+		Type:         "pullrequest",
+		ID:           pr.ID,
+		Title:        pr.Title,
+		Description:  pr.Description,
+		State:        pr.State,
+		Author:       pr.Author.User.toCloud(),
+		CreatedOn:    serverTime(pr.CreatedDate),
+		UpdatedOn:    serverTime(pr.UpdatedDate),
+		Source:       pr.FromRef.toCloud(),
+		Destination:  pr.ToRef.toCloud(),
+		Links:        Links{HTML: pr.Links.html()},
+		Summary:      &PRSummary{Type: "rendered", Raw: pr.Description, Markup: "markdown"},
+		TaskCount:    pr.Properties.OpenTaskCount,
+		CommentCount: pr.Properties.CommentCount,
+	}
+	if mc := pr.Properties.MergeCommit; mc != nil && mc.ID != "" {
+		out.MergeCommit = &Commit{Type: "commit", Hash: mc.ID}
+	}
+	for _, r := range pr.Reviewers {
+		if u := r.User.toCloud(); u != nil {
+			out.Reviewers = append(out.Reviewers, *u)
+		}
+	}
+	for _, p := range append(append([]serverParticipant(nil), pr.Reviewers...), pr.Participants...) {
+		state := ""
+		switch p.Status {
+		case "APPROVED":
+			state = "approved"
+		case "NEEDS_WORK":
+			state = "changes_requested"
+		}
+		out.Participants = append(out.Participants, Participant{
+			Type:     "participant",
+			User:     p.User.toCloud(),
+			Role:     p.Role,
+			Approved: p.Approved,
+			State:    state,
+		})
+	}
+	return out
+}
+
+func (r serverRef) toCloud() *PREndpoint {
+	endpoint := &PREndpoint{
+		Branch: &Branch{Name: r.DisplayID},
+		Commit: &Commit{Type: "commit", Hash: r.LatestCommit},
+	}
+	if r.Repository != nil {
+		endpoint.Repository = &Repository{
+			Type:     "repository",
+			UUID:     fmt.Sprintf("{%d}", r.Repository.ID),
+			Slug:     r.Repository.Slug,
+			Name:     r.Repository.Name,
+			FullName: r.Repository.Project.Key + "/" + r.Repository.Slug,
+		}
+	}
+	return endpoint
+}
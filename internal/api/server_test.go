@@ -0,0 +1,229 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// newServerTestClient returns a Data Center client for a fake instance
+// serving routes, keyed by path and query under ServerAPIPath.
+func newServerTestClient(t *testing.T, routes map[string]string) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.EscapedPath(), ServerAPIPath)
+		if r.URL.RawQuery != "" {
+			key += "?" + r.URL.RawQuery
+		}
+		body, ok := routes[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"No route for ` + key + `"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := testConfig()
+	cfg.API = config.APIConfig{Type: config.APITypeServer, BaseURL: server.URL}
+	return NewClient(cfg)
+}
+
+const serverTestRepos = `{"isLastPage":true,"values":[
+	{"id":1,"slug":"core","name":"Core","scmId":"git","forkable":true,"public":false,
+	 "project":{"id":10,"key":"ENG","name":"Engineering","type":"NORMAL"},
+	 "links":{"self":[{"href":"https://bb.example.com/projects/ENG/repos/core/browse"}],
+	  "clone":[{"href":"https://bb.example.com/scm/eng/core.git","name":"http"},{"href":"ssh://git@bb.example.com:7999/eng/core.git","name":"ssh"}]}},
+	{"id":2,"slug":"core-fork","name":"core-fork","scmId":"git","forkable":false,"public":false,
+	 "project":{"id":11,"key":"~ADA","name":"Ada","type":"PERSONAL","owner":{"id":5,"name":"ada","slug":"ada","displayName":"Ada"}},
+	 "origin":{"id":1,"slug":"core","project":{"key":"ENG"}},
+	 "links":{"clone":[{"href":"https://bb.example.com/scm/~ada/core-fork.git","name":"http"}]}}]}`
+
+func TestServer_Repositories(t *testing.T) {
+	client := newServerTestClient(t, map[string]string{
+		"/repos?start=0&limit=100":    serverTestRepos,
+		"/projects/ENG/repos/core":    `{"id":1,"slug":"core","name":"Core","project":{"id":10,"key":"ENG","type":"NORMAL"}}`,
+		"/projects?start=0&limit=100": `{"isLastPage":false,"nextPageStart":1,"values":[{"id":10,"key":"ENG","name":"Engineering"}]}`,
+		"/projects?start=1&limit=100": `{"isLastPage":true,"values":[{"id":12,"key":"OPS","name":"Operations","public":true}]}`,
+	})
+	ctx := context.Background()
+
+	repos, err := client.GetRepositories(ctx, "acme")
+	if err != nil {
+		t.Fatalf("GetRepositories() error = %v", err)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("got %d repositories, want 2", len(repos))
+	}
+	core := repos[0]
+	if core.UUID != "{1}" || core.FullName != "ENG/core" || core.Project == nil || core.Project.Key != "ENG" || !core.IsPrivate {
+		t.Errorf("core = %+v", core)
+	}
+	if got := core.CloneURL(); got != "https://bb.example.com/scm/eng/core.git" {
+		t.Errorf("CloneURL() = %q", got)
+	}
+	if core.Links.HTML.Href == "" || core.SSHCloneURL() == "" {
+		t.Errorf("links = %+v", core.Links)
+	}
+	fork := repos[1]
+	if fork.Project != nil || fork.Owner == nil || fork.Owner.Username != "ada" || fork.FullName != "ada/core-fork" {
+		t.Errorf("personal repository = %+v", fork)
+	}
+	if fork.Parent == nil || fork.Parent.FullName != "ENG/core" || fork.ForkPolicy != "no_forks" {
+		t.Errorf("fork parent = %+v, policy %q", fork.Parent, fork.ForkPolicy)
+	}
+
+	// The listing tells the client which project holds each slug
+	repo, err := client.GetRepository(ctx, "acme", "core")
+	if err != nil {
+		t.Fatalf("GetRepository() error = %v", err)
+	}
+	if repo.Name != "Core" {
+		t.Errorf("GetRepository() = %+v", repo)
+	}
+
+	projects, err := client.GetProjects(ctx, "acme")
+	if err != nil {
+		t.Fatalf("GetProjects() error = %v", err)
+	}
+	if len(projects) != 2 || projects[1].Key != "OPS" || projects[1].IsPrivate {
+		t.Errorf("projects = %+v", projects)
+	}
+}
+
+func TestServer_RepositoryLookup(t *testing.T) {
+	client := newServerTestClient(t, map[string]string{
+		"/projects?start=0&limit=100": `{"isLastPage":true,"values":[{"id":20,"key":"WEB"},{"id":21,"key":"MOB"}]}`,
+		"/projects/WEB/repos?start=0&limit=100": `{"isLastPage":true,"values":[
+			{"id":3,"slug":"app","name":"App","project":{"key":"WEB","type":"NORMAL"}},
+			{"id":5,"slug":"lib","name":"Shared Library","project":{"key":"WEB","type":"NORMAL"}}]}`,
+		"/projects/MOB/repos?start=0&limit=100":                 `{"isLastPage":true,"values":[{"id":4,"slug":"app","name":"App","project":{"key":"MOB","type":"NORMAL"}}]}`,
+		"/projects/WEB/repos/lib/raw/docs/READ%20ME.md?at=main": "hello",
+	})
+	ctx := context.Background()
+
+	body, err := client.GetFileContent(ctx, "acme", "lib", "main", "docs/READ ME.md")
+	if err != nil || string(body) != "hello" {
+		t.Errorf("GetFileContent() = %q, %v", body, err)
+	}
+
+	_, err = client.GetRepository(ctx, "acme", "app")
+	if err == nil || !strings.Contains(err.Error(), "MOB, WEB") {
+		t.Errorf("expected ambiguous slug error, got %v", err)
+	}
+
+	_, err = client.GetRepository(ctx, "acme", "missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown slug, got %v", err)
+	}
+}
+
+func TestServer_PullRequests(t *testing.T) {
+	client := newServerTestClient(t, map[string]string{
+		"/projects?start=0&limit=100":           `{"isLastPage":true,"values":[{"id":10,"key":"ENG"}]}`,
+		"/projects/ENG/repos?start=0&limit=100": serverTestRepos,
+		"/projects/ENG/repos/core/pull-requests?state=ALL&start=0&limit=100": `{"isLastPage":true,"values":[
+			{"id":7,"title":"Add cache","description":"Speeds things up","state":"MERGED",
+			 "createdDate":1700000000000,"updatedDate":1700000600000,
+			 "fromRef":{"displayId":"feature/cache","latestCommit":"abc123"},
+			 "toRef":{"displayId":"main","latestCommit":"def456"},
+			 "author":{"user":{"id":5,"name":"ada","slug":"ada","displayName":"Ada"}},
+			 "reviewers":[{"user":{"id":6,"slug":"bob"},"role":"REVIEWER","approved":true,"status":"APPROVED"}],
+			 "properties":{"commentCount":2,"openTaskCount":1,"mergeCommit":{"id":"fff000"}}},
+			{"id":6,"title":"Old","state":"DECLINED","updatedDate":1600000000000,
+			 "fromRef":{"displayId":"old"},"toRef":{"displayId":"main"},"author":{"user":{"id":5}}}]}`,
+		"/projects/ENG/repos/core/pull-requests/7/activities?start=0&limit=100": `{"isLastPage":true,"values":[
+			{"id":4,"createdDate":1700000500000,"user":{"id":6,"slug":"bob"},"action":"APPROVED"},
+			{"id":3,"createdDate":1700000400000,"user":{"id":5,"slug":"ada"},"action":"COMMENTED","commentAction":"ADDED",
+			 "comment":{"id":30,"text":"Add a test","severity":"BLOCKER","state":"RESOLVED","author":{"id":5},"createdDate":1700000400000}},
+			{"id":2,"createdDate":1700000300000,"user":{"id":6,"slug":"bob"},"action":"COMMENTED","commentAction":"ADDED",
+			 "commentAnchor":{"path":"cache.go","line":12},
+			 "comment":{"id":20,"text":"Why here?","severity":"NORMAL","author":{"id":6,"slug":"bob"},"createdDate":1700000300000,
+			  "comments":[{"id":21,"text":"Locality","author":{"id":5,"slug":"ada"},"createdDate":1700000350000}]}},
+			{"id":1,"createdDate":1700000000000,"user":{"id":5,"slug":"ada"},"action":"OPENED"}]}`,
+	})
+	ctx := context.Background()
+
+	prs, err := client.GetAllPullRequests(ctx, "acme", "core")
+	if err != nil {
+		t.Fatalf("GetAllPullRequests() error = %v", err)
+	}
+	if len(prs) != 2 {
+		t.Fatalf("got %d pull requests, want 2", len(prs))
+	}
+	pr := prs[0]
+	if pr.Source.Branch.Name != "feature/cache" || pr.Destination.Commit.Hash != "def456" || pr.MergeCommit.Hash != "fff000" {
+		t.Errorf("refs = %+v -> %+v", pr.Source, pr.Destination)
+	}
+	if pr.CreatedOn != "2023-11-14T22:13:20Z" || pr.Author.Username != "ada" || pr.CommentCount != 2 || pr.TaskCount != 1 {
+		t.Errorf("pull request = %+v", pr)
+	}
+	if len(pr.Participants) != 1 || !pr.Participants[0].Approved || pr.Participants[0].State != "approved" {
+		t.Errorf("participants = %+v", pr.Participants)
+	}
+
+	updated, err := client.GetPullRequestsUpdatedSince(ctx, "acme", "core", "2023-01-01T00:00:00+00:00")
+	if err != nil || len(updated) != 1 || updated[0].ID != 7 {
+		t.Errorf("GetPullRequestsUpdatedSince() = %+v, %v", updated, err)
+	}
+
+	comments, err := client.GetPullRequestComments(ctx, "acme", "core", 7)
+	if err != nil {
+		t.Fatalf("GetPullRequestComments() error = %v", err)
+	}
+	if len(comments) != 2 {
+		t.Fatalf("got %d comments, want 2 (tasks left out)", len(comments))
+	}
+	if c := comments[0]; c.ID != 20 || c.Inline == nil || c.Inline.Path != "cache.go" || *c.Inline.To != 12 {
+		t.Errorf("inline comment = %+v", c)
+	}
+	if c := comments[1]; c.ID != 21 || c.Parent == nil || c.Parent.ID != 20 {
+		t.Errorf("reply = %+v", c)
+	}
+
+	tasks, err := client.GetPullRequestTasks(ctx, "acme", "core", 7)
+	if err != nil || len(tasks) != 1 || tasks[0].State != "RESOLVED" || tasks[0].Content.Raw != "Add a test" {
+		t.Errorf("GetPullRequestTasks() = %+v, %v", tasks, err)
+	}
+
+	activity, err := client.GetPullRequestActivity(ctx, "acme", "core", 7)
+	if err != nil {
+		t.Fatalf("GetPullRequestActivity() error = %v", err)
+	}
+	if len(activity) != 4 || activity[0].Update == nil || activity[0].Update.State != "OPEN" || activity[3].Approval == nil {
+		t.Errorf("activity = %+v", activity)
+	}
+}
+
+func TestServer_CreateRepository(t *testing.T) {
+	client := newServerTestClient(t, map[string]string{
+		"/projects/~ADA/repos": `{"id":9,"slug":"notes","name":"notes","project":{"key":"~ADA","type":"PERSONAL","owner":{"id":5,"slug":"ada"}}}`,
+	})
+	ctx := context.Background()
+
+	repo, err := client.CreateRepository(ctx, "acme", &Repository{Slug: "notes", Owner: &User{Username: "ada"}})
+	if err != nil {
+		t.Fatalf("CreateRepository() error = %v", err)
+	}
+	if repo.FullName != "ada/notes" {
+		t.Errorf("created %+v", repo)
+	}
+	if _, err := client.CreateRepository(ctx, "acme", &Repository{Slug: "orphan"}); err == nil {
+		t.Error("expected an error for a repository without a project or owner")
+	}
+}
+
+func TestServer_ErrorMessage(t *testing.T) {
+	client := newServerTestClient(t, map[string]string{})
+	_, err := client.GetProject(context.Background(), "acme", "NOPE")
+	if err == nil || !strings.Contains(err.Error(), "No route for /projects/NOPE") {
+		t.Errorf("expected the server's error message, got %v", err)
+	}
+}
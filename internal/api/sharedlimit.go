@@ -0,0 +1,136 @@
+package api //nolint:revive // package name is intentional
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sharedBucketState is the on-disk token bucket shared by cooperating processes.
+type sharedBucketState struct {
+	Tokens     float64 `json:"tokens"`
+	LastRefill int64   `json:"last_refill_unix_nano"`
+}
+
+// sharedBucket coordinates a token bucket through a lock-protected state file
+// so several bb-backup processes on one host draw from a single quota.
+type sharedBucket struct {
+	path       string
+	maxTokens  float64
+	refillRate float64
+}
+
+// UseSharedBucket switches the limiter to a token bucket stored in path.
+// Every process pointing at the same file shares one requests_per_hour
+// budget instead of each assuming the full account quota. The file is
+// created if missing; an error is returned if it cannot be locked.
+func (r *RateLimiter) UseSharedBucket(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating shared rate limit directory: %w", err)
+	}
+
+	b := &sharedBucket{
+		path:       path,
+		maxTokens:  r.maxTokens,
+		refillRate: r.refillRate,
+	}
+	// Take the lock once up front so misconfiguration surfaces at startup.
+	if err := b.withLock(func(*os.File) error { return nil }); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.shared = b
+	r.mu.Unlock()
+	return nil
+}
+
+// take attempts to consume a token from the shared bucket. It returns zero
+// on success, or how long to wait before a token will be available.
+func (b *sharedBucket) take(now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	err := b.withLock(func(f *os.File) error {
+		state, err := readSharedState(f, b.maxTokens, now)
+		if err != nil {
+			return err
+		}
+
+		elapsed := now.Sub(time.Unix(0, state.LastRefill)).Seconds()
+		if elapsed > 0 {
+			state.Tokens = math.Min(b.maxTokens, state.Tokens+elapsed*b.refillRate)
+			state.LastRefill = now.UnixNano()
+		}
+
+		if state.Tokens >= 1 {
+			state.Tokens--
+		} else {
+			deficit := 1 - state.Tokens
+			wait = time.Duration(deficit/b.refillRate*1000) * time.Millisecond
+			if wait <= 0 {
+				wait = time.Millisecond
+			}
+		}
+
+		return writeSharedState(f, state)
+	})
+	return wait, err
+}
+
+// withLock opens the state file, holds an exclusive lock while fn runs, and
+// releases it afterwards. Each call uses its own descriptor so goroutines in
+// the same process serialize on the lock just like separate processes.
+func (b *sharedBucket) withLock(fn func(*os.File) error) error {
+	f, err := os.OpenFile(b.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening shared rate limit file: %w", err)
+	}
+	defer f.Close()
+
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("locking shared rate limit file: %w", err)
+	}
+	defer func() { _ = unlockFile(f) }()
+
+	return fn(f)
+}
+
+// readSharedState loads the bucket state, starting a full bucket when the
+// file is empty or unreadable (e.g. left truncated by a crashed process).
+func readSharedState(f *os.File, maxTokens float64, now time.Time) (sharedBucketState, error) {
+	fresh := sharedBucketState{Tokens: maxTokens, LastRefill: now.UnixNano()}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fresh, fmt.Errorf("reading shared rate limit file: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fresh, fmt.Errorf("reading shared rate limit file: %w", err)
+	}
+	if len(data) == 0 {
+		return fresh, nil
+	}
+
+	var state sharedBucketState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fresh, nil
+	}
+	return state, nil
+}
+
+func writeSharedState(f *os.File, state sharedBucketState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encoding shared rate limit state: %w", err)
+	}
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("truncating shared rate limit file: %w", err)
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("writing shared rate limit file: %w", err)
+	}
+	return nil
+}
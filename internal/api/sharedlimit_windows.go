@@ -0,0 +1,18 @@
+//go:build windows
+
+package api //nolint:revive // package name is intentional
+
+import (
+	"errors"
+	"os"
+)
+
+var errSharedLockUnsupported = errors.New("shared rate limiting is not supported on Windows")
+
+func lockFile(*os.File) error {
+	return errSharedLockUnsupported
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
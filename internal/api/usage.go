@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Endpoint categories used to break down API usage.
+const (
+	EndpointWorkspace    = "workspace"
+	EndpointRepositories = "repositories"
+	EndpointPullRequests = "pull_requests"
+	EndpointIssues       = "issues"
+	EndpointOther        = "other"
+)
+
+// Usage summarizes the API requests a client has sent. Every HTTP request
+// counts, including retries; responses served from the in-run cache do not.
+type Usage struct {
+	Requests    int            `json:"requests"`
+	RateLimited int            `json:"rate_limited,omitempty"` // 429 responses
+	ByEndpoint  map[string]int `json:"by_endpoint"`
+
+	// Limit and Remaining are the last X-RateLimit-Limit and
+	// X-RateLimit-Remaining seen; Remaining is nil if never reported
+	Limit     int  `json:"limit,omitempty"`
+	Remaining *int `json:"remaining,omitempty"`
+}
+
+// usageCounter accumulates Usage across concurrent requests.
+type usageCounter struct {
+	mu    sync.Mutex
+	usage Usage
+}
+
+// record counts a response to a request for path.
+func (u *usageCounter) record(path string, resp *http.Response) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.usage.Requests++
+	if u.usage.ByEndpoint == nil {
+		u.usage.ByEndpoint = make(map[string]int)
+	}
+	u.usage.ByEndpoint[endpointCategory(path)]++
+	if resp.StatusCode == http.StatusTooManyRequests {
+		u.usage.RateLimited++
+	}
+	if limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil && limit > 0 {
+		u.usage.Limit = limit
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		u.usage.Remaining = &remaining
+	}
+}
+
+// snapshot returns a copy of the usage so far.
+func (u *usageCounter) snapshot() Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	s := u.usage
+	s.ByEndpoint = make(map[string]int, len(u.usage.ByEndpoint))
+	for k, v := range u.usage.ByEndpoint {
+		s.ByEndpoint[k] = v
+	}
+	if u.usage.Remaining != nil {
+		remaining := *u.usage.Remaining
+		s.Remaining = &remaining
+	}
+	return s
+}
+
+// endpointCategory maps a request path to its usage category. Pull request
+// and issue sub-resources (comments, activity, tasks) count with their parent.
+func endpointCategory(path string) string {
+	switch {
+	case strings.Contains(path, "/pullrequests"):
+		return EndpointPullRequests
+	case strings.Contains(path, "/issues"):
+		return EndpointIssues
+	case strings.Contains(path, "/repositories/"):
+		return EndpointRepositories
+	case strings.Contains(path, "/workspaces/") || strings.HasSuffix(path, "/user") || strings.Contains(path, "/users/"):
+		return EndpointWorkspace
+	}
+	return EndpointOther
+}
+
+// Usage returns the API requests this client has sent so far.
+func (c *Client) Usage() Usage {
+	return c.usage.snapshot()
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_Usage(t *testing.T) {
+	var remaining atomic.Int32
+	remaining.Store(1000)
+	var limited atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining.Add(-1))))
+		if r.URL.Path == "/repositories/ws/repo/issues" && !limited.Swap(true) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"values": []}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(testConfig(), WithBaseURL(server.URL), WithoutResponseCache())
+	ctx := context.Background()
+	for _, path := range []string{
+		"/repositories/ws",
+		"/repositories/ws/repo/pullrequests",
+		"/repositories/ws/repo/pullrequests/1/comments",
+		"/repositories/ws/repo/issues",
+		"/workspaces/ws",
+	} {
+		if _, err := client.GetPaginated(ctx, path); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	usage := client.Usage()
+	if usage.Requests != 6 || usage.RateLimited != 1 {
+		t.Errorf("expected 6 requests with 1 rate limited, got %+v", usage)
+	}
+	want := map[string]int{
+		EndpointRepositories: 1,
+		EndpointPullRequests: 2,
+		EndpointIssues:       2,
+		EndpointWorkspace:    1,
+	}
+	for category, n := range want {
+		if usage.ByEndpoint[category] != n {
+			t.Errorf("%s: expected %d requests, got %d", category, n, usage.ByEndpoint[category])
+		}
+	}
+	if usage.Limit != 1000 || usage.Remaining == nil || *usage.Remaining != 994 {
+		t.Errorf("expected the last rate limit headers, got limit %d remaining %v", usage.Limit, usage.Remaining)
+	}
+}
+
+func TestEndpointCategory(t *testing.T) {
+	tests := map[string]string{
+		"/2.0/repositories/ws/repo":                     EndpointRepositories,
+		"/2.0/repositories/ws/repo/refs/branches":       EndpointRepositories,
+		"/2.0/repositories/ws/repo/pullrequests/1/diff": EndpointPullRequests,
+		"/2.0/repositories/ws/repo/issues/3/comments":   EndpointIssues,
+		"/2.0/workspaces/ws/members":                    EndpointWorkspace,
+		"/2.0/user":                                     EndpointWorkspace,
+		"/2.0/snippets/ws":                              EndpointOther,
+	}
+	for path, want := range tests {
+		if got := endpointCategory(path); got != want {
+			t.Errorf("endpointCategory(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -16,6 +16,9 @@ type Workspace struct {
 	Links     Links  `json:"links"`
 	CreatedOn string `json:"created_on"`
 	UpdatedOn string `json:"updated_on"`
+
+	// Raw holds the API payload verbatim; it is written in raw mode.
+	Raw json.RawMessage `json:"-"`
 }
 
 // Links contains hypermedia links.
@@ -35,9 +38,10 @@ type Link struct {
 }
 
 // GetWorkspace fetches metadata for a workspace.
-func (c *Client) GetWorkspace(ctx context.Context, workspace string) (*Workspace, error) {
+func (p *cloudProvider) GetWorkspace(ctx context.Context, workspace string) (*Workspace, error) {
+	ctx = withDefaultPriority(ctx, PriorityHigh)
 	path := fmt.Sprintf("/workspaces/%s", workspace)
-	body, err := c.Get(ctx, path)
+	body, err := p.c.Get(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("fetching workspace %s: %w", workspace, err)
 	}
@@ -49,3 +53,30 @@ func (c *Client) GetWorkspace(ctx context.Context, workspace string) (*Workspace
 
 	return &ws, nil
 }
+
+// WorkspaceMembership links a user to a workspace.
+type WorkspaceMembership struct {
+	Type string `json:"type"`
+	User User   `json:"user"`
+}
+
+// GetWorkspaceMembers fetches the current members of a workspace.
+// Users who have left or been deactivated are no longer listed.
+func (p *cloudProvider) GetWorkspaceMembers(ctx context.Context, workspace string) ([]WorkspaceMembership, error) {
+	path := fmt.Sprintf("/workspaces/%s/members", workspace)
+	values, err := p.c.GetPaginated(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("fetching workspace members: %w", err)
+	}
+
+	members := make([]WorkspaceMembership, 0, len(values))
+	for _, v := range values {
+		var m WorkspaceMembership
+		if err := json.Unmarshal(v, &m); err != nil {
+			return nil, fmt.Errorf("parsing workspace member: %w", err)
+		}
+		members = append(members, m)
+	}
+
+	return members, nil
+}
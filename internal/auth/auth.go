@@ -0,0 +1,225 @@
+// Package auth supplies credentials for Bitbucket API requests and git
+// operations, and replaces them when they expire during a long run.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// ErrRefreshUnsupported is returned by Refresh when a provider's
+// credentials are fixed, so a rejected credential can't be replaced.
+var ErrRefreshUnsupported = errors.New("credentials cannot be refreshed")
+
+// Credentials authenticate a single API request or git operation.
+type Credentials struct {
+	Username string
+	Password string
+
+	// Expiry is when the credentials stop working; zero means unknown or
+	// never
+	Expiry time.Time
+
+	// Bearer sends Password as a Bearer token instead of basic auth, as
+	// the API expects for access tokens
+	Bearer bool
+}
+
+// Apply sets the credentials on an HTTP request.
+func (c Credentials) Apply(req *http.Request) {
+	if c.Bearer {
+		req.Header.Set("Authorization", "Bearer "+c.Password)
+		return
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+}
+
+// apiCredentials returns the API credentials for a config. Access tokens
+// have no user behind them: the API takes them as Bearer tokens, while git
+// takes them as the password of x-token-auth.
+func apiCredentials(cfg *config.Config) Credentials {
+	user, pass := cfg.GetAPICredentials()
+	return Credentials{Username: user, Password: pass, Bearer: cfg.Auth.Method == "access_token"}
+}
+
+// Provider supplies credentials. Callers ask for credentials before each
+// request or git operation rather than holding on to them, so a provider
+// can rotate them mid-run.
+type Provider interface {
+	// APICredentials returns credentials for the next API request.
+	APICredentials(ctx context.Context) (Credentials, error)
+
+	// GitCredentials returns credentials for the next git operation
+	// over HTTPS.
+	GitCredentials(ctx context.Context) (Credentials, error)
+
+	// Refresh replaces the current credentials, for example after the
+	// server rejected them. Providers with fixed credentials return
+	// ErrRefreshUnsupported.
+	Refresh(ctx context.Context) error
+}
+
+// RefreshHook is called with the new API credentials after a provider
+// replaces its credentials.
+type RefreshHook func(Credentials)
+
+// Option configures a Provider built by FromConfig.
+type Option func(*options)
+
+type options struct {
+	onRefresh RefreshHook
+	tokenURL  string
+}
+
+// WithRefreshHook sets a function called after each refresh, e.g. to log
+// when the new credentials expire.
+func WithRefreshHook(hook RefreshHook) Option {
+	return func(o *options) {
+		o.onRefresh = hook
+	}
+}
+
+// FromConfig returns the provider for the configured auth settings: an
+// OAuthProvider for the oauth method, a CommandProvider when
+// auth.credential_command is set, otherwise the fixed credentials from the
+// config. The command is not run until credentials
+// are first needed. With credential sets or repo_tokens configured, it
+// returns a Router over a provider for each.
+func FromConfig(cfg *config.Config, opts ...Option) Provider {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	names := cfg.CredentialSetNames()
+	if len(names) == 0 {
+		return fromAuth(cfg, o)
+	}
+	sets := make(map[string]Provider, len(names))
+	for _, name := range names {
+		sets[name] = fromAuth(cfg.WithCredentialSet(name), o)
+	}
+	return NewRouter(fromAuth(cfg, o), sets)
+}
+
+func fromAuth(cfg *config.Config, o options) Provider {
+	if cfg.Auth.Method == "oauth" {
+		return newOAuthProvider(cfg, o)
+	}
+	if cfg.Auth.CredentialCommand != "" {
+		return newCommandProvider(cfg, o)
+	}
+	return NewStatic(cfg)
+}
+
+type setKey struct{}
+
+// WithSet returns a context whose API requests and git operations use the
+// named credential set; "" selects the main credentials.
+func WithSet(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, setKey{}, name)
+}
+
+// SetFrom returns the credential set selected in ctx, or "".
+func SetFrom(ctx context.Context) string {
+	name, _ := ctx.Value(setKey{}).(string)
+	return name
+}
+
+// Router is a Provider that hands out the credentials of the set selected
+// in each call's context (see WithSet), falling back to the main
+// credentials for contexts without one.
+type Router struct {
+	main Provider
+	sets map[string]Provider
+}
+
+// NewRouter returns a Router over a main provider and named sets.
+func NewRouter(main Provider, sets map[string]Provider) *Router {
+	return &Router{main: main, sets: sets}
+}
+
+func (r *Router) pick(ctx context.Context) Provider {
+	if p, ok := r.sets[SetFrom(ctx)]; ok {
+		return p
+	}
+	return r.main
+}
+
+// APICredentials returns API credentials from the selected set.
+func (r *Router) APICredentials(ctx context.Context) (Credentials, error) {
+	return r.pick(ctx).APICredentials(ctx)
+}
+
+// GitCredentials returns git credentials from the selected set.
+func (r *Router) GitCredentials(ctx context.Context) (Credentials, error) {
+	return r.pick(ctx).GitCredentials(ctx)
+}
+
+// Refresh refreshes the selected set.
+func (r *Router) Refresh(ctx context.Context) error {
+	return r.pick(ctx).Refresh(ctx)
+}
+
+// GitCredentialFunc adapts a provider to the credential callback used by
+// the git clients.
+func GitCredentialFunc(p Provider) func(context.Context) (string, string, error) {
+	return func(ctx context.Context) (string, string, error) {
+		creds, err := p.GitCredentials(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		return creds.Username, creds.Password, nil
+	}
+}
+
+// refreshMu serializes RefreshRejected so requests rejected together
+// refresh once.
+var refreshMu sync.Mutex
+
+// RefreshRejected refreshes p after the server rejected the credentials in
+// rejected. When several requests fail with the same expired token, the
+// first refreshes and the rest find the secret already replaced and return
+// nil straight away. It returns ErrRefreshUnsupported if p can't refresh.
+func RefreshRejected(ctx context.Context, p Provider, rejected Credentials) error {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	if current, err := p.APICredentials(ctx); err == nil && current.Password != rejected.Password {
+		return nil
+	}
+	return p.Refresh(ctx)
+}
+
+// Static is a Provider with fixed credentials.
+type Static struct {
+	api, git Credentials
+}
+
+// NewStatic returns a provider with the credentials in the config.
+func NewStatic(cfg *config.Config) *Static {
+	gitUser, gitPass := cfg.GetGitCredentials()
+	return &Static{
+		api: apiCredentials(cfg),
+		git: Credentials{Username: gitUser, Password: gitPass},
+	}
+}
+
+// APICredentials returns the configured API credentials.
+func (s *Static) APICredentials(context.Context) (Credentials, error) {
+	return s.api, nil
+}
+
+// GitCredentials returns the configured git credentials.
+func (s *Static) GitCredentials(context.Context) (Credentials, error) {
+	return s.git, nil
+}
+
+// Refresh always fails; configured credentials can't change mid-run.
+func (s *Static) Refresh(context.Context) error {
+	return ErrRefreshUnsupported
+}
@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestStatic(t *testing.T) {
+	cfg := &config.Config{Auth: config.AuthConfig{
+		Method:   "api_token",
+		Username: "alice",
+		Email:    "alice@example.com",
+		APIToken: "secret",
+	}}
+	p := FromConfig(cfg)
+
+	api, err := p.APICredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api.Username != "alice@example.com" || api.Password != "secret" {
+		t.Errorf("APICredentials() = %+v", api)
+	}
+	git, err := p.GitCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if git.Username != "alice" || git.Password != "secret" {
+		t.Errorf("GitCredentials() = %+v", git)
+	}
+	if err := p.Refresh(context.Background()); !errors.Is(err, ErrRefreshUnsupported) {
+		t.Errorf("Refresh() error = %v, want ErrRefreshUnsupported", err)
+	}
+}
+
+func TestCredentials_Apply(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	Credentials{Username: "x-token-auth", Password: "tok"}.Apply(req)
+	user, pass, ok := req.BasicAuth()
+	if !ok || user != "x-token-auth" || pass != "tok" {
+		t.Errorf("BasicAuth() = %q, %q, %v", user, pass, ok)
+	}
+}
+
+func TestStatic_AccessToken(t *testing.T) {
+	cfg := &config.Config{Auth: config.AuthConfig{Method: "access_token", AccessToken: "tok"}}
+	p := NewStatic(cfg)
+
+	// The API takes access tokens as Bearer tokens, git as x-token-auth
+	api, _ := p.APICredentials(context.Background())
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	api.Apply(req)
+	if got := req.Header.Get("Authorization"); got != "Bearer tok" {
+		t.Errorf("Authorization = %q, want Bearer tok", got)
+	}
+	git, _ := p.GitCredentials(context.Background())
+	if git.Username != "x-token-auth" || git.Password != "tok" || git.Bearer {
+		t.Errorf("GitCredentials() = %+v", git)
+	}
+}
+
+func TestCommandProvider(t *testing.T) {
+	// Each run prints the next token from a counter file
+	counter := filepath.Join(t.TempDir(), "n")
+	cfg := &config.Config{Auth: config.AuthConfig{
+		Method:            "access_token",
+		CredentialCommand: `n=$(cat ` + counter + ` 2>/dev/null || echo 0); n=$((n+1)); echo $n > ` + counter + `; echo "tok-$n"`,
+	}}
+	var refreshed []string
+	p := FromConfig(cfg, WithRefreshHook(func(c Credentials) {
+		refreshed = append(refreshed, c.Password)
+	}))
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		c, err := p.APICredentials(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Username != "x-token-auth" || c.Password != "tok-1" {
+			t.Errorf("APICredentials() = %+v, want cached tok-1", c)
+		}
+	}
+
+	if err := p.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	c, err := p.GitCredentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Password != "tok-2" {
+		t.Errorf("GitCredentials() after refresh = %+v, want tok-2", c)
+	}
+	if strings.Join(refreshed, ",") != "tok-2" {
+		t.Errorf("refresh hook saw %v, want [tok-2]", refreshed)
+	}
+}
+
+func TestCommandProvider_RenewsBeforeExpiry(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "n")
+	// Tokens expire in one minute, inside the renewal window, so every
+	// request runs the command again
+	cfg := &config.Config{Auth: config.AuthConfig{
+		Method:            "app_password",
+		Username:          "bob",
+		CredentialCommand: `n=$(cat ` + counter + ` 2>/dev/null || echo 0); n=$((n+1)); echo $n > ` + counter + `; echo "{\"token\": \"pw-$n\", \"expires_in\": 60}"`,
+	}}
+	p := FromConfig(cfg)
+
+	ctx := context.Background()
+	first, err := p.APICredentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.APICredentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Password != "pw-1" || second.Password != "pw-2" {
+		t.Errorf("passwords = %q, %q, want pw-1, pw-2", first.Password, second.Password)
+	}
+	if first.Username != "bob" || first.Expiry.IsZero() {
+		t.Errorf("credentials = %+v, want user bob with an expiry", first)
+	}
+}
+
+func TestCommandProvider_Error(t *testing.T) {
+	cfg := &config.Config{Auth: config.AuthConfig{
+		Method:            "access_token",
+		CredentialCommand: "echo denied >&2; exit 1",
+	}}
+	_, err := FromConfig(cfg).APICredentials(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Errorf("APICredentials() error = %v, want command failure with stderr", err)
+	}
+}
+
+func TestParseCommandOutput(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		out        string
+		wantToken  string
+		wantExpiry time.Time
+		wantErr    bool
+	}{
+		{name: "plain token", out: "abc123\n", wantToken: "abc123"},
+		{name: "first line only", out: "abc123\nextra\n", wantToken: "abc123"},
+		{name: "expires_in", out: `{"token": "t", "expires_in": 3600}`, wantToken: "t", wantExpiry: now.Add(time.Hour)},
+		{name: "expires_at", out: `{"token": "t", "expires_at": "2025-01-15T11:30:00Z"}`, wantToken: "t", wantExpiry: now.Add(90 * time.Minute)},
+		{name: "empty", out: "  \n", wantErr: true},
+		{name: "no token", out: `{"expires_in": 60}`, wantErr: true},
+		{name: "bad expires_at", out: `{"token": "t", "expires_at": "soon"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, expiry, err := parseCommandOutput([]byte(tt.out), now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if token != tt.wantToken || !expiry.Equal(tt.wantExpiry) {
+				t.Errorf("got %q, %v; want %q, %v", token, expiry, tt.wantToken, tt.wantExpiry)
+			}
+		})
+	}
+}
+
+func TestGitCredentialFunc(t *testing.T) {
+	cfg := &config.Config{Auth: config.AuthConfig{Method: "access_token", AccessToken: "tok"}}
+	user, pass, err := GitCredentialFunc(FromConfig(cfg))(context.Background())
+	if err != nil || user != "x-token-auth" || pass != "tok" {
+		t.Errorf("GitCredentialFunc() = %q, %q, %v", user, pass, err)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	cfg := &config.Config{
+		Auth: config.AuthConfig{Method: "access_token", AccessToken: "main"},
+		Credentials: []config.CredentialSet{{
+			Name:       "finance",
+			Projects:   []string{"FIN"},
+			AuthConfig: config.AuthConfig{Method: "access_token", AccessToken: "fin"},
+		}},
+	}
+	p := FromConfig(cfg)
+	for _, tt := range []struct {
+		set, want string
+	}{
+		{"", "main"},
+		{"finance", "fin"},
+		{"unknown", "main"},
+	} {
+		ctx := WithSet(context.Background(), tt.set)
+		api, err := p.APICredentials(ctx)
+		if err != nil || api.Password != tt.want {
+			t.Errorf("APICredentials(set %q) = %+v, %v, want token %q", tt.set, api, err, tt.want)
+		}
+		_, pass, err := GitCredentialFunc(p)(ctx)
+		if err != nil || pass != tt.want {
+			t.Errorf("git credentials (set %q) = %q, %v, want %q", tt.set, pass, err, tt.want)
+		}
+	}
+}
+
+func TestRefreshRejected_Once(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "n")
+	cfg := &config.Config{Auth: config.AuthConfig{
+		Method:            "access_token",
+		CredentialCommand: `n=$(cat ` + counter + ` 2>/dev/null || echo 0); n=$((n+1)); echo $n > ` + counter + `; echo "tok-$n"`,
+	}}
+	p := FromConfig(cfg)
+	ctx := context.Background()
+	rejected, err := p.APICredentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Several requests rejected with the same token refresh it once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := RefreshRejected(ctx, p, rejected); err != nil {
+				t.Errorf("RefreshRejected() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c, _ := p.APICredentials(ctx)
+	if c.Password != "tok-2" {
+		t.Errorf("password after refresh = %q, want tok-2", c.Password)
+	}
+
+	static := &config.Config{Auth: config.AuthConfig{Method: "access_token", AccessToken: "tok"}}
+	if err := RefreshRejected(ctx, FromConfig(static), Credentials{Password: "tok"}); !errors.Is(err, ErrRefreshUnsupported) {
+		t.Errorf("RefreshRejected() on static = %v, want ErrRefreshUnsupported", err)
+	}
+}
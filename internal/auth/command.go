@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+const (
+	// commandTimeout bounds a single run of the credential command.
+	commandTimeout = 30 * time.Second
+
+	// refreshBefore is how long before expiry credentials are replaced, so
+	// a token doesn't lapse in the middle of a long clone.
+	refreshBefore = 5 * time.Minute
+)
+
+// CommandProvider gets the secret for the configured auth method from an
+// external command (auth.credential_command), such as a vault lookup or a
+// script minting a short-lived access token.
+//
+// The command is run through "sh -c". It prints either the secret alone or
+// a JSON object:
+//
+//	{"token": "...", "expires_in": 3600}
+//	{"token": "...", "expires_at": "2025-01-15T11:30:00Z"}
+//
+// The result is cached until shortly before it expires, then the command
+// runs again. Usernames come from the config as for fixed credentials.
+type CommandProvider struct {
+	cfg       *config.Config
+	onRefresh RefreshHook
+
+	mu      sync.Mutex
+	current *commandResult
+}
+
+type commandResult struct {
+	api, git Credentials
+}
+
+// commandOutput is the JSON form of the command's output.
+type commandOutput struct {
+	Token     string `json:"token"`
+	ExpiresIn int    `json:"expires_in"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func newCommandProvider(cfg *config.Config, o options) *CommandProvider {
+	return &CommandProvider{cfg: cfg, onRefresh: o.onRefresh}
+}
+
+// APICredentials returns the current API credentials, running the command
+// first if there are none or they are about to expire.
+func (p *CommandProvider) APICredentials(ctx context.Context) (Credentials, error) {
+	r, err := p.get(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return r.api, nil
+}
+
+// GitCredentials returns the current git credentials, running the command
+// first if there are none or they are about to expire.
+func (p *CommandProvider) GitCredentials(ctx context.Context) (Credentials, error) {
+	r, err := p.get(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return r.git, nil
+}
+
+// Refresh runs the command again and replaces the current credentials.
+func (p *CommandProvider) Refresh(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.runLocked(ctx)
+}
+
+func (p *CommandProvider) get(ctx context.Context) (*commandResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == nil || expiring(p.current.api.Expiry, time.Now()) {
+		if err := p.runLocked(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return p.current, nil
+}
+
+// expiring reports whether credentials expiring at expiry should be
+// replaced now.
+func expiring(expiry, now time.Time) bool {
+	return !expiry.IsZero() && now.Add(refreshBefore).After(expiry)
+}
+
+func (p *CommandProvider) runLocked(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", p.cfg.Auth.CredentialCommand)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running auth.credential_command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	token, expiry, err := parseCommandOutput(stdout.Bytes(), time.Now())
+	if err != nil {
+		return fmt.Errorf("auth.credential_command: %w", err)
+	}
+
+	// Usernames depend on the method, so derive both from a config with
+	// the secret filled in
+	withSecret := *p.cfg
+	switch withSecret.Auth.Method {
+	case "api_token":
+		withSecret.Auth.APIToken = token
+	case "access_token":
+		withSecret.Auth.AccessToken = token
+	default:
+		withSecret.Auth.AppPassword = token
+	}
+	api := apiCredentials(&withSecret)
+	api.Expiry = expiry
+	gitUser, gitPass := withSecret.GetGitCredentials()
+	first := p.current == nil
+	p.current = &commandResult{
+		api: api,
+		git: Credentials{Username: gitUser, Password: gitPass, Expiry: expiry},
+	}
+	if !first && p.onRefresh != nil {
+		p.onRefresh(p.current.api)
+	}
+	return nil
+}
+
+// parseCommandOutput reads the token and its expiry from the command's
+// output: a JSON object, or the token alone on the first line.
+func parseCommandOutput(out []byte, now time.Time) (string, time.Time, error) {
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) == 0 {
+		return "", time.Time{}, fmt.Errorf("command printed nothing")
+	}
+	if trimmed[0] != '{' {
+		token, _, _ := strings.Cut(string(trimmed), "\n")
+		return strings.TrimSpace(token), time.Time{}, nil
+	}
+
+	var o commandOutput
+	if err := json.Unmarshal(trimmed, &o); err != nil {
+		return "", time.Time{}, fmt.Errorf("parsing command output: %w", err)
+	}
+	if o.Token == "" {
+		return "", time.Time{}, fmt.Errorf("command output has no token")
+	}
+	var expiry time.Time
+	switch {
+	case o.ExpiresAt != "":
+		t, err := time.Parse(time.RFC3339, o.ExpiresAt)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("parsing expires_at: %w", err)
+		}
+		expiry = t
+	case o.ExpiresIn > 0:
+		expiry = now.Add(time.Duration(o.ExpiresIn) * time.Second)
+	}
+	return o.Token, expiry, nil
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+// TokenURL is Bitbucket's OAuth 2.0 token endpoint.
+const TokenURL = "https://bitbucket.org/site/oauth2/access_token"
+
+// tokenTimeout bounds a single token request.
+const tokenTimeout = 30 * time.Second
+
+// WithTokenURL sets where OAuth access tokens are requested, in place of
+// TokenURL (useful for testing).
+func WithTokenURL(u string) Option {
+	return func(o *options) {
+		o.tokenURL = u
+	}
+}
+
+// OAuthProvider gets access tokens for an OAuth consumer (auth.method
+// oauth) through the client credentials grant, authenticating with the
+// consumer's key and secret. Tokens are sent to the API as Bearer tokens
+// and to git as the password of x-token-auth. A token is cached until
+// shortly before it expires, then a new one is requested.
+type OAuthProvider struct {
+	cfg       *config.Config
+	tokenURL  string
+	client    *http.Client
+	onRefresh RefreshHook
+
+	mu      sync.Mutex
+	current *commandResult
+}
+
+// tokenResponse is the token endpoint's reply.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+
+	// Set instead when the request is refused
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func newOAuthProvider(cfg *config.Config, o options) *OAuthProvider {
+	tokenURL := o.tokenURL
+	if tokenURL == "" {
+		tokenURL = TokenURL
+	}
+	return &OAuthProvider{
+		cfg:       cfg,
+		tokenURL:  tokenURL,
+		client:    &http.Client{Timeout: tokenTimeout},
+		onRefresh: o.onRefresh,
+	}
+}
+
+// APICredentials returns the current access token as a Bearer token,
+// requesting one first if there is none or it is about to expire.
+func (p *OAuthProvider) APICredentials(ctx context.Context) (Credentials, error) {
+	r, err := p.get(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return r.api, nil
+}
+
+// GitCredentials returns the current access token for git, requesting one
+// first if there is none or it is about to expire.
+func (p *OAuthProvider) GitCredentials(ctx context.Context) (Credentials, error) {
+	r, err := p.get(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return r.git, nil
+}
+
+// Refresh requests a new access token and replaces the current one.
+func (p *OAuthProvider) Refresh(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requestLocked(ctx)
+}
+
+func (p *OAuthProvider) get(ctx context.Context) (*commandResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == nil || expiring(p.current.api.Expiry, time.Now()) {
+		if err := p.requestLocked(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return p.current, nil
+}
+
+func (p *OAuthProvider) requestLocked(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, tokenTimeout)
+	defer cancel()
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("creating OAuth token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(p.cfg.Auth.ClientID, p.cfg.Auth.ClientSecret)
+
+	now := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("requesting OAuth access token: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("reading OAuth token response: %w", err)
+	}
+
+	var token tokenResponse
+	jsonErr := json.Unmarshal(body, &token)
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if jsonErr == nil && token.Error != "" {
+			msg = token.Error
+			if token.ErrorDescription != "" {
+				msg += ": " + token.ErrorDescription
+			}
+		}
+		return errcode.Errorf(errcode.AuthFailed, "OAuth token request failed (status %d): %s", resp.StatusCode, msg)
+	}
+	if jsonErr != nil {
+		return fmt.Errorf("parsing OAuth token response: %w", jsonErr)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("OAuth token response has no access_token")
+	}
+
+	var expiry time.Time
+	if token.ExpiresIn > 0 {
+		expiry = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	git := Credentials{Username: "x-token-auth", Password: token.AccessToken, Expiry: expiry}
+	if p.cfg.Auth.GitPassword != "" {
+		git = Credentials{Username: p.cfg.Auth.GitUsername, Password: p.cfg.Auth.GitPassword}
+	}
+	first := p.current == nil
+	p.current = &commandResult{
+		api: Credentials{Username: "x-token-auth", Password: token.AccessToken, Expiry: expiry, Bearer: true},
+		git: git,
+	}
+	if !first && p.onRefresh != nil {
+		p.onRefresh(p.current.api)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// newTokenServer returns a token endpoint handing out tok-1, tok-2, ...
+// that expire after expiresIn seconds, and the number of tokens issued.
+func newTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "key" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"Invalid OAuth client credentials"}`))
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		n := issued.Add(1)
+		_, _ = fmt.Fprintf(w, `{"access_token":"tok-%d","expires_in":%d,"token_type":"bearer"}`, n, expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return server, &issued
+}
+
+func oauthConfig(secret string) *config.Config {
+	return &config.Config{Auth: config.AuthConfig{Method: "oauth", ClientID: "key", ClientSecret: secret}}
+}
+
+func TestOAuthProvider(t *testing.T) {
+	server, issued := newTokenServer(t, 7200)
+	var refreshed []string
+	p := FromConfig(oauthConfig("secret"), WithTokenURL(server.URL), WithRefreshHook(func(c Credentials) {
+		refreshed = append(refreshed, c.Password)
+	}))
+
+	ctx := context.Background()
+	api, err := p.APICredentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !api.Bearer || api.Password != "tok-1" || api.Expiry.IsZero() {
+		t.Errorf("APICredentials() = %+v, want Bearer tok-1 with an expiry", api)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	api.Apply(req)
+	if got := req.Header.Get("Authorization"); got != "Bearer tok-1" {
+		t.Errorf("Authorization = %q", got)
+	}
+	git, err := p.GitCredentials(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if git.Username != "x-token-auth" || git.Password != "tok-1" {
+		t.Errorf("GitCredentials() = %+v", git)
+	}
+	if issued.Load() != 1 {
+		t.Errorf("issued %d tokens, want 1 cached token", issued.Load())
+	}
+
+	// A token the API rejected is replaced once
+	if err := RefreshRejected(ctx, p, api); err != nil {
+		t.Fatal(err)
+	}
+	if err := RefreshRejected(ctx, p, api); err != nil {
+		t.Fatal(err)
+	}
+	if api, _ := p.APICredentials(ctx); api.Password != "tok-2" {
+		t.Errorf("APICredentials() after refresh = %+v, want tok-2", api)
+	}
+	if strings.Join(refreshed, ",") != "tok-2" {
+		t.Errorf("refresh hook saw %v, want [tok-2]", refreshed)
+	}
+}
+
+func TestOAuthProvider_RenewsBeforeExpiry(t *testing.T) {
+	// Tokens expire in one minute, inside the renewal window
+	server, issued := newTokenServer(t, 60)
+	p := FromConfig(oauthConfig("secret"), WithTokenURL(server.URL))
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := p.APICredentials(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if issued.Load() != 2 {
+		t.Errorf("issued %d tokens, want 2", issued.Load())
+	}
+}
+
+func TestOAuthProvider_Error(t *testing.T) {
+	server, _ := newTokenServer(t, 7200)
+	p := FromConfig(oauthConfig("wrong"), WithTokenURL(server.URL))
+
+	_, err := p.APICredentials(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid_client: Invalid OAuth client credentials") {
+		t.Errorf("APICredentials() error = %v, want the endpoint's error", err)
+	}
+}
+
+func TestOAuthProvider_GitOverride(t *testing.T) {
+	server, _ := newTokenServer(t, 7200)
+	cfg := oauthConfig("secret")
+	cfg.Auth.GitUsername, cfg.Auth.GitPassword = "reader", "pw"
+	p := FromConfig(cfg, WithTokenURL(server.URL))
+
+	git, err := p.GitCredentials(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if git.Username != "reader" || git.Password != "pw" {
+		t.Errorf("GitCredentials() = %+v, want the git_username override", git)
+	}
+}
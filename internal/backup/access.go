@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// fetchWorkspace fetches the workspace metadata at the start of a run.
+// Repository and project access tokens can't read the workspace itself and
+// get a 403 or 404; for those it returns nil without error so the run can
+// back up whatever the token can see. Other credentials must be able to
+// read the workspace.
+func (b *Backup) fetchWorkspace(ctx context.Context) (*api.Workspace, error) {
+	workspace, err := b.provider.GetWorkspace(ctx, b.cfg.Workspace)
+	if err == nil {
+		if b.cfg.Auth.Method == "access_token" {
+			b.log.Debug("Access token can read workspace %s (workspace access token)", b.cfg.Workspace)
+		}
+		return workspace, nil
+	}
+
+	if !OutOfTokenScope(b.cfg, err) {
+		return nil, err
+	}
+	b.log.Info("Access token can't read workspace %s; treating it as a project or repository access token", b.cfg.Workspace)
+	b.log.Info("Workspace metadata, members, and the project list are skipped; backing up the repositories the token can see")
+	return nil, nil
+}
+
+// OutOfTokenScope reports whether err is an access token being refused
+// something outside its project or repository (403 or 404), as opposed to
+// a failure that should stop the run.
+func OutOfTokenScope(cfg *config.Config, err error) bool {
+	var apiErr *api.APIError
+	return cfg.Auth.Method == "access_token" && errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusNotFound)
+}
+
+// ProjectsFromRepos returns the projects referenced by repositories, for
+// tokens that can list repositories but not projects. Only the fields
+// embedded in the repository are known.
+func ProjectsFromRepos(repos []api.Repository) []api.Project {
+	seen := make(map[string]bool)
+	var projects []api.Project
+	for _, r := range repos {
+		if r.Project == nil || seen[r.Project.Key] {
+			continue
+		}
+		seen[r.Project.Key] = true
+		projects = append(projects, *r.Project)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Key < projects[j].Key })
+	return projects
+}
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestFetchWorkspace_LimitedAccessToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": {"message": "Access denied"}}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		method  string
+		wantErr bool
+	}{
+		{method: "access_token", wantErr: false},
+		{method: "app_password", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Workspace = "ws"
+			cfg.Auth = config.AuthConfig{Method: tt.method, Username: "u", AppPassword: "p", AccessToken: "tok"}
+			b := newRunTestBackup(t, "")
+			b.cfg = cfg
+			setClient(b, api.NewClient(cfg, api.WithBaseURL(server.URL)))
+
+			ws, err := b.fetchWorkspace(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchWorkspace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ws != nil {
+				t.Errorf("fetchWorkspace() = %+v, want nil", ws)
+			}
+		})
+	}
+}
+
+func TestOutOfTokenScope(t *testing.T) {
+	token := &config.Config{Auth: config.AuthConfig{Method: "access_token"}}
+	user := &config.Config{Auth: config.AuthConfig{Method: "api_token"}}
+	forbidden := fmt.Errorf("fetching: %w", &api.APIError{StatusCode: http.StatusForbidden})
+	unauthorized := &api.APIError{StatusCode: http.StatusUnauthorized}
+
+	if !OutOfTokenScope(token, forbidden) {
+		t.Error("403 with an access token should be out of scope")
+	}
+	if OutOfTokenScope(user, forbidden) {
+		t.Error("403 with user credentials should not be out of scope")
+	}
+	if OutOfTokenScope(token, unauthorized) {
+		t.Error("401 is a credential failure, not a scope limit")
+	}
+	if OutOfTokenScope(token, nil) {
+		t.Error("nil error should not be out of scope")
+	}
+}
+
+func TestProjectsFromRepos(t *testing.T) {
+	repos := []api.Repository{
+		{Slug: "b", Project: &api.Project{Key: "WEB", Name: "Web"}},
+		{Slug: "a", Project: &api.Project{Key: "CORE", Name: "Core"}},
+		{Slug: "c", Project: &api.Project{Key: "WEB", Name: "Web"}},
+		{Slug: "personal"},
+	}
+	projects := ProjectsFromRepos(repos)
+	if len(projects) != 2 || projects[0].Key != "CORE" || projects[1].Key != "WEB" {
+		t.Errorf("ProjectsFromRepos() = %+v, want CORE, WEB", projects)
+	}
+}
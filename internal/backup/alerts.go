@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// AlertEventHistoryRewrite is the event name for force-push alerts.
+const AlertEventHistoryRewrite = "history_rewrite"
+
+// alertTimeout bounds each alert delivery so a dead endpoint cannot hold
+// up the end of a run.
+const alertTimeout = 30 * time.Second
+
+// AlertPayload is the JSON document sent to alerts.webhook_url and written
+// to the stdin of alerts.command.
+type AlertPayload struct {
+	Event        string         `json:"event"`
+	Workspace    string         `json:"workspace"`
+	RunID        string         `json:"run_id"`
+	Repositories []RepoRewrites `json:"repositories"`
+}
+
+// RepoRewrites groups the history rewrites seen in one repository.
+type RepoRewrites struct {
+	Project  string           `json:"project,omitempty"`
+	Repo     string           `json:"repo"`
+	Rewrites []git.RefRewrite `json:"rewrites"`
+}
+
+// rewriteAlert builds the alert payload from the run report, or returns
+// nil if the run saw no rewrites.
+func (b *Backup) rewriteAlert() *AlertPayload {
+	b.report.mu.Lock()
+	defer b.report.mu.Unlock()
+
+	var repos []RepoRewrites
+	for _, r := range b.report.Repositories {
+		if len(r.RefRewrites) > 0 {
+			repos = append(repos, RepoRewrites{Project: r.Project, Repo: r.Slug, Rewrites: r.RefRewrites})
+		}
+	}
+	if len(repos) == 0 {
+		return nil
+	}
+	return &AlertPayload{
+		Event:        AlertEventHistoryRewrite,
+		Workspace:    b.cfg.Workspace,
+		RunID:        b.runID,
+		Repositories: repos,
+	}
+}
+
+// sendAlerts delivers history rewrite alerts to the configured targets.
+// Delivery failures are logged; they never fail the backup.
+func (b *Backup) sendAlerts() {
+	alerts := b.cfg.Alerts
+	if alerts.WebhookURL == "" && alerts.Command == "" {
+		return
+	}
+	payload := b.rewriteAlert()
+	if payload == nil {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		b.log.Error("Failed to encode alert: %v", err)
+		return
+	}
+
+	if alerts.WebhookURL != "" {
+		if err := postAlert(alerts.WebhookURL, data); err != nil {
+			b.log.Error("Failed to send alert webhook: %v", err)
+		} else {
+			b.log.Debug("Sent %s alert to webhook", payload.Event)
+		}
+	}
+	if alerts.Command != "" {
+		if err := runAlertCommand(alerts.Command, data); err != nil {
+			b.log.Error("Alert command failed: %v", err)
+		}
+	}
+}
+
+// postAlert POSTs the payload as JSON and expects a 2xx response.
+func postAlert(url string, data []byte) error {
+	client := &http.Client{Timeout: alertTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// runAlertCommand runs command through "sh -c" with the payload on stdin.
+func runAlertCommand(command string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+func newAlertTestBackup(t *testing.T) *Backup {
+	t.Helper()
+	b := newRunTestBackup(t, "")
+	b.runID = "2024-01-15T10-30-00Z-abcd1234"
+	b.report.Add(RepoReport{Slug: "quiet", Status: RepoStatusOK})
+	b.report.Add(RepoReport{Slug: "api", Project: "CORE", Status: RepoStatusOK, RefRewrites: []git.RefRewrite{
+		{Name: "refs/heads/main", Kind: git.RewriteForcePush, Old: "aaa", New: "bbb"},
+	}})
+	return b
+}
+
+func TestRewriteAlert(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.report.Add(RepoReport{Slug: "quiet", Status: RepoStatusOK})
+	if b.rewriteAlert() != nil {
+		t.Error("expected no alert without rewrites")
+	}
+
+	payload := newAlertTestBackup(t).rewriteAlert()
+	if payload == nil || payload.Event != AlertEventHistoryRewrite || len(payload.Repositories) != 1 {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+	if r := payload.Repositories[0]; r.Project != "CORE" || r.Repo != "api" || r.Rewrites[0].Kind != git.RewriteForcePush {
+		t.Errorf("unexpected repository entry: %+v", r)
+	}
+}
+
+func TestSendAlerts(t *testing.T) {
+	var received AlertPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "alert.json")
+	b := newAlertTestBackup(t)
+	b.cfg.Alerts.WebhookURL = server.URL
+	b.cfg.Alerts.Command = "cat > " + out
+	b.sendAlerts()
+
+	if received.RunID != b.runID || received.Workspace != "ws" {
+		t.Errorf("webhook payload = %+v", received)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("alert command output: %v", err)
+	}
+	var fromCommand AlertPayload
+	if err := json.Unmarshal(data, &fromCommand); err != nil || len(fromCommand.Repositories) != 1 {
+		t.Errorf("command payload = %s (%v)", data, err)
+	}
+}
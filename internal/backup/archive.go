@@ -0,0 +1,534 @@
+package backup
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// ArchiveChecksumSuffix is appended to an archive's name for its checksum
+// file, which holds one line in the format of sha256sum, so the archive
+// can also be checked with "sha256sum -c".
+const ArchiveChecksumSuffix = ".sha256"
+
+// archiveFormats are the formats of storage.archive, in the order
+// archives are looked for.
+var archiveFormats = []string{config.ArchiveTarGz, config.ArchiveZip}
+
+// errStopArchiveWalk ends WalkArchive early without an error.
+var errStopArchiveWalk = errors.New("stop walking archive")
+
+// ArchiveOptions controls ArchiveRuns.
+type ArchiveOptions struct {
+	Format string // config.ArchiveTarGz (the default) or config.ArchiveZip
+	Remove bool   // Delete each archived run's directory, except the run current points at
+	RunID  string // Archive only this run; empty archives every completed run
+	Skip   string // Run to leave alone, e.g. one that was interrupted
+	Log    Logger // nil logs nothing
+
+	// Storage, if set, is the storage whose base path holds the workspace
+	// directory. Archives are written and run directories deleted through
+	// it, so with s3 storage they reach the bucket too. nil works on the
+	// workspace directory alone.
+	Storage storage.Storage
+}
+
+// ArchiveResult describes a run archived, or a run directory removed after
+// an earlier archive, by ArchiveRuns.
+type ArchiveResult struct {
+	RunID    string `json:"run_id"`
+	Path     string `json:"path"` // Relative to the workspace directory
+	SHA256   string `json:"sha256"`
+	Files    int    `json:"files,omitempty"`
+	Bytes    int64  `json:"bytes"`              // Size of the archive
+	Existing bool   `json:"existing,omitempty"` // Archived before this call
+	Removed  bool   `json:"removed,omitempty"`  // The run directory was deleted
+}
+
+// ArchiveRuns packages the completed runs in a workspace directory, those
+// with a manifest.json, into archives next to their directories. Runs
+// already archived in any format are not archived again. With
+// opts.Remove, each archived run's directory is then deleted, after
+// checking an earlier archive against its checksum; the run current points
+// at keeps its directory until a later run takes its place.
+func ArchiveRuns(workspaceDir string, opts ArchiveOptions) ([]ArchiveResult, error) {
+	log := opts.Log
+	if log == nil {
+		log = &defaultLogger{quiet: true}
+	}
+	store, workspace := opts.Storage, "."
+	if store == nil {
+		local, err := storage.NewLocal(workspaceDir)
+		if err != nil {
+			return nil, err
+		}
+		store = local
+	} else if rel, ok := storageRel(store, workspaceDir); ok {
+		workspace = rel
+	} else {
+		return nil, fmt.Errorf("%s is outside storage %s", workspaceDir, store.BasePath())
+	}
+	entries, err := os.ReadDir(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+	current := currentRunID(workspaceDir)
+
+	var results []ArchiveResult
+	found := false
+	for _, e := range entries {
+		id := e.Name()
+		if !e.IsDir() || ValidateRunID(id) != nil || strings.HasPrefix(id, LatestDirName) || id == opts.Skip {
+			continue
+		}
+		if opts.RunID != "" && id != opts.RunID {
+			continue
+		}
+		found = true
+		if _, err := os.Stat(filepath.Join(workspaceDir, id, "manifest.json")); err != nil {
+			if opts.RunID != "" {
+				return nil, fmt.Errorf("run %s is not complete: it has no manifest.json", id)
+			}
+			log.Debug("Not archiving run %s: it has no manifest.json", id)
+			continue
+		}
+
+		var result *ArchiveResult
+		if existing := runArchives(workspaceDir, id); len(existing) > 0 {
+			if !opts.Remove || id == current {
+				continue
+			}
+			// Only a sound archive may stand in for the directory
+			path := existing[0]
+			sum, err := VerifyArchiveChecksum(path)
+			if err != nil {
+				return results, fmt.Errorf("checking archive of run %s: %w", id, err)
+			}
+			result = &ArchiveResult{RunID: id, Path: filepath.Base(path), SHA256: sum, Existing: true}
+			if info, err := os.Stat(path); err == nil {
+				result.Bytes = info.Size()
+			}
+		} else {
+			if result, err = ArchiveRun(store, workspace, id, opts.Format); err != nil {
+				return results, err
+			}
+			log.Info("Archived run %s to %s (%d files)", id, result.Path, result.Files)
+		}
+
+		if opts.Remove && id != current {
+			if err := store.Delete(filepath.Join(workspace, id)); err != nil {
+				return results, fmt.Errorf("removing run %s after archiving: %w", id, err)
+			}
+			result.Removed = true
+			log.Info("Removed run directory %s; its backup is in %s", id, result.Path)
+		}
+		results = append(results, *result)
+	}
+	if opts.RunID != "" && !found {
+		return nil, fmt.Errorf("run %s not found in %s", opts.RunID, workspaceDir)
+	}
+	return results, nil
+}
+
+// archiveRuns archives this run and any earlier completed runs not yet
+// archived, for storage.archive. An interrupted run is left as a directory
+// for a rerun to finish, and a rerun replaces the run's earlier archive.
+// Failures are logged and never fail the backup.
+func (b *Backup) archiveRuns(ctx context.Context) {
+	opts := ArchiveOptions{Format: b.cfg.Storage.Archive, Remove: b.cfg.Storage.ArchiveRemove, Log: b.log, Storage: b.storage}
+	if ctx.Err() != nil {
+		opts.Skip = b.runID
+	} else if b.opts.RerunID != "" && len(runArchives(b.workspaceDir(), b.runID)) > 0 {
+		if _, err := ArchiveRun(b.storage, b.cfg.Workspace, b.runID, opts.Format); err != nil {
+			b.log.Error("Failed to archive run %s: %v", b.runID, err)
+			return
+		}
+	}
+	if _, err := ArchiveRuns(b.workspaceDir(), opts); err != nil {
+		b.log.Error("Failed to archive runs: %v", err)
+	}
+}
+
+// ArchiveRun writes the run directory runID in workspace, relative to
+// store, to <runID>.tar.gz or <runID>.zip beside it, with every file under
+// a top-level runID/ directory, and writes its checksum file. The archive
+// is built in the store's working directory and read back in full before
+// it replaces any earlier one, so a finished archive is always readable;
+// it is then written to store, which copies it to a remote replica such as
+// S3. manifest.json and report.json come first so a run can be dated
+// without reading the whole archive.
+func ArchiveRun(store storage.Storage, workspace, runID, format string) (*ArchiveResult, error) {
+	if err := ValidateRunID(runID); err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = config.ArchiveTarGz
+	}
+	workspaceDir := filepath.Join(store.BasePath(), workspace)
+	runDir := filepath.Join(workspaceDir, runID)
+	files, err := archiveFiles(runDir)
+	if err != nil {
+		return nil, err
+	}
+
+	name := runID + "." + format
+	dest := filepath.Join(workspaceDir, name)
+	tmp := dest + ".partial"
+	sum, err := writeArchive(tmp, runDir, runID, format, files)
+	if err == nil {
+		err = walkArchive(tmp, format, func(_ string, r io.Reader) error {
+			_, err := io.Copy(io.Discard, r)
+			return err
+		})
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("archiving run %s: %w", runID, err)
+	}
+
+	// The checksum goes first: an archive is only ever seen with its checksum
+	checksum := fmt.Sprintf("%s  %s\n", sum, name)
+	if err := store.Write(filepath.Join(workspace, name+ArchiveChecksumSuffix), []byte(checksum)); err != nil {
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("writing checksum of run %s: %w", runID, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("archiving run %s: %w", runID, err)
+	}
+	if err := storage.WriteFile(store, filepath.Join(workspace, name), dest); err != nil {
+		return nil, fmt.Errorf("archiving run %s: %w", runID, err)
+	}
+
+	result := &ArchiveResult{RunID: runID, Path: name, SHA256: sum, Files: len(files)}
+	if info, err := os.Stat(dest); err == nil {
+		result.Bytes = info.Size()
+	}
+	return result, nil
+}
+
+// archiveFiles lists the regular files in a run directory, relative to it
+// and slash-separated, with manifest.json and report.json first.
+func archiveFiles(runDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(runDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(runDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing run files: %w", err)
+	}
+	first := map[string]int{"manifest.json": 1, ReportFileName: 2}
+	sort.SliceStable(files, func(i, j int) bool {
+		fi, fj := first[files[i]], first[files[j]]
+		return fi != 0 && (fj == 0 || fi < fj)
+	})
+	return files, nil
+}
+
+// writeArchive writes files from runDir to an archive at path and returns
+// its SHA-256 checksum in hex.
+func writeArchive(path, runDir, runID, format string, files []string) (sum string, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("creating archive: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("closing archive: %w", cerr)
+		}
+	}()
+	h := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(f, h))
+
+	if format == config.ArchiveZip {
+		err = writeZip(w, runDir, runID, files)
+	} else {
+		err = writeTarGz(w, runDir, runID, files)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("writing archive: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func writeTarGz(w io.Writer, runDir, runID string, files []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, rel := range files {
+		path := filepath.Join(runDir, filepath.FromSlash(rel))
+		err := copyArchiveFile(path, func(info os.FileInfo) (io.Writer, error) {
+			hdr := &tar.Header{
+				Name:    runID + "/" + rel,
+				Mode:    int64(info.Mode().Perm()),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+				Format:  tar.FormatPAX,
+			}
+			return tw, tw.WriteHeader(hdr)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("compressing archive: %w", err)
+	}
+	return nil
+}
+
+func writeZip(w io.Writer, runDir, runID string, files []string) error {
+	zw := zip.NewWriter(w)
+	for _, rel := range files {
+		path := filepath.Join(runDir, filepath.FromSlash(rel))
+		err := copyArchiveFile(path, func(info os.FileInfo) (io.Writer, error) {
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return nil, err
+			}
+			hdr.Name = runID + "/" + rel
+			hdr.Method = zip.Deflate
+			return zw.CreateHeader(hdr)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	return nil
+}
+
+// copyArchiveFile copies the file at path into the writer that start
+// returns for it after writing the entry's header.
+func copyArchiveFile(path string, start func(os.FileInfo) (io.Writer, error)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck // read-only
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := start(info)
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", path, err)
+	}
+	if _, err := io.CopyN(w, f, info.Size()); err != nil {
+		return fmt.Errorf("adding %s: %w", path, err)
+	}
+	return nil
+}
+
+// ArchiveFormat returns the format of an archive named like ArchiveRun's,
+// or "" if name is not one.
+func ArchiveFormat(name string) string {
+	for _, format := range archiveFormats {
+		if strings.HasSuffix(name, "."+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// ListArchives returns the names of the run archives in a workspace
+// directory, sorted.
+func ListArchives(workspaceDir string) ([]string, error) {
+	entries, err := os.ReadDir(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		format := ArchiveFormat(name)
+		if !e.Type().IsRegular() || format == "" {
+			continue
+		}
+		if ValidateRunID(strings.TrimSuffix(name, "."+format)) == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// runArchives returns the paths of the run's archives in workspaceDir.
+func runArchives(workspaceDir, runID string) []string {
+	var paths []string
+	for _, format := range archiveFormats {
+		path := filepath.Join(workspaceDir, runID+"."+format)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// WalkArchive calls fn for each regular file in a tar.gz or zip archive,
+// with its name in the archive and a reader for its content. It stops at
+// the first error, including a corrupt archive, and returns it.
+func WalkArchive(path string, fn func(name string, r io.Reader) error) error {
+	format := ArchiveFormat(path)
+	if format == "" {
+		return fmt.Errorf("%s is not a .tar.gz or .zip archive", path)
+	}
+	return walkArchive(path, format, fn)
+}
+
+func walkArchive(path, format string, fn func(name string, r io.Reader) error) error {
+	err := walkArchiveEntries(path, format, fn)
+	if errors.Is(err, errStopArchiveWalk) {
+		return nil
+	}
+	return err
+}
+
+func walkArchiveEntries(path, format string, fn func(name string, r io.Reader) error) error {
+	if format == config.ArchiveZip {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("opening archive: %w", err)
+		}
+		defer zr.Close() //nolint:errcheck // read-only
+		for _, zf := range zr.File {
+			if !zf.Mode().IsRegular() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", zf.Name, err)
+			}
+			err = fn(zf.Name, rc)
+			_ = rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening archive: %w", err)
+	}
+	defer f.Close() //nolint:errcheck // read-only
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("reading archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// VerifyArchiveChecksum checks an archive against its checksum file and
+// returns the checksum. A missing checksum file is reported as an error
+// matching fs.ErrNotExist.
+func VerifyArchiveChecksum(path string) (string, error) {
+	data, err := os.ReadFile(path + ArchiveChecksumSuffix)
+	if err != nil {
+		return "", fmt.Errorf("reading checksum: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s%s is empty", filepath.Base(path), ArchiveChecksumSuffix)
+	}
+	want := strings.ToLower(fields[0])
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close() //nolint:errcheck // read-only
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("reading archive: %w", err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return "", fmt.Errorf("checksum mismatch: archive is %s, %s%s says %s", got, filepath.Base(path), ArchiveChecksumSuffix, want)
+	}
+	return want, nil
+}
+
+// archiveStartTime returns when the run in an archive started, from the
+// manifest.json or report.json inside it or else from its run ID (see
+// runStartTime).
+func archiveStartTime(path, runID, layout string) (time.Time, bool) {
+	var started time.Time
+	_ = WalkArchive(path, func(name string, r io.Reader) error {
+		if name != runID+"/manifest.json" && name != runID+"/"+ReportFileName {
+			// Both come first in the archive
+			return errStopArchiveWalk
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if t, ok := recordedStartTime(data); ok {
+			started = t
+			return errStopArchiveWalk
+		}
+		return nil
+	})
+	if !started.IsZero() {
+		return started, true
+	}
+	return runIDTime(runID, layout)
+}
+
+// currentRunID returns the run the workspace's current link points at, or
+// "" if there is none.
+func currentRunID(workspaceDir string) string {
+	target, err := os.Readlink(filepath.Join(workspaceDir, CurrentLinkName))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
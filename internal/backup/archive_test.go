@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestArchiveRun(t *testing.T) {
+	for _, format := range []string{config.ArchiveTarGz, config.ArchiveZip} {
+		t.Run(format, func(t *testing.T) {
+			wsDir := t.TempDir()
+			id := writeRun(t, wsDir, time.Now(), 0, "api", "web")
+
+			result, err := ArchiveRun(newLocalStore(t, wsDir), ".", id, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(wsDir, id+"."+format)
+			if result.Path != id+"."+format || result.Files != 3 || result.Bytes == 0 {
+				t.Errorf("ArchiveRun() = %+v", result)
+			}
+
+			checksum, err := os.ReadFile(path + ArchiveChecksumSuffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := result.SHA256 + "  " + result.Path + "\n"; string(checksum) != want {
+				t.Errorf("checksum file = %q, want %q", checksum, want)
+			}
+			if sum, err := VerifyArchiveChecksum(path); err != nil || sum != result.SHA256 {
+				t.Errorf("VerifyArchiveChecksum() = %q, %v", sum, err)
+			}
+
+			var names []string
+			content := make(map[string]string)
+			err = WalkArchive(path, func(name string, r io.Reader) error {
+				data, err := io.ReadAll(r)
+				names = append(names, name)
+				content[name] = string(data)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(names) != 3 || names[0] != id+"/manifest.json" {
+				t.Errorf("archive holds %v, want manifest.json first", names)
+			}
+			if got := content[id+"/projects/CORE/repositories/web/repository.json"]; got != `{"slug":"web"}` {
+				t.Errorf("web/repository.json = %q", got)
+			}
+			if _, err := os.Stat(path + ".partial"); !os.IsNotExist(err) {
+				t.Error("the partial archive was left behind")
+			}
+		})
+	}
+}
+
+func TestVerifyArchiveChecksum_Mismatch(t *testing.T) {
+	wsDir := t.TempDir()
+	id := writeRun(t, wsDir, time.Now(), 0, "api")
+	result, err := ArchiveRun(newLocalStore(t, wsDir), ".", id, config.ArchiveTarGz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(wsDir, result.Path)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte("junk"))
+	_ = f.Close()
+	if _, err := VerifyArchiveChecksum(path); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("VerifyArchiveChecksum() error = %v, want a mismatch", err)
+	}
+
+	_ = os.Remove(path + ArchiveChecksumSuffix)
+	if _, err := VerifyArchiveChecksum(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("VerifyArchiveChecksum() error = %v, want a missing checksum file", err)
+	}
+}
+
+func TestArchiveRuns(t *testing.T) {
+	wsDir := t.TempDir()
+	now := time.Now()
+	older := writeRun(t, wsDir, now, 2, "api")
+	current := writeRun(t, wsDir, now, 1, "api")
+	unfinished := writeRun(t, wsDir, now, 0, "api")
+	_ = os.Remove(filepath.Join(wsDir, unfinished, "manifest.json"))
+	if err := os.Symlink(current, filepath.Join(wsDir, CurrentLinkName)); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := ArchiveRuns(wsDir, ArchiveOptions{Format: config.ArchiveZip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].RunID != older || results[1].RunID != current {
+		t.Fatalf("ArchiveRuns() = %+v, want the two completed runs", results)
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, unfinished+".zip")); !os.IsNotExist(err) {
+		t.Error("a run without a manifest should not be archived")
+	}
+
+	// Archived runs are not archived again, but lose their directories
+	results, err = ArchiveRuns(wsDir, ArchiveOptions{Format: config.ArchiveTarGz, Remove: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].RunID != older || !results[0].Existing || !results[0].Removed {
+		t.Errorf("ArchiveRuns() = %+v, want the older run's directory removed", results)
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, older)); !os.IsNotExist(err) {
+		t.Error("the older run's directory should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, current)); err != nil {
+		t.Error("the current run's directory should be kept")
+	}
+	if archives, _ := ListArchives(wsDir); len(archives) != 2 {
+		t.Errorf("ListArchives() = %v, want the two zip archives", archives)
+	}
+
+	if _, err := ArchiveRuns(wsDir, ArchiveOptions{RunID: unfinished}); err == nil {
+		t.Error("archiving an unfinished run by ID should fail")
+	}
+}
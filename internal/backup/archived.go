@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"os"
+	"sort"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// RepoStatusSkipped marks an archived repository that an incremental run
+// did not revisit because its backup cannot have changed.
+const RepoStatusSkipped = "skipped"
+
+// planArchived applies backup.archived_repos to the repositories of a run.
+// "skip" drops archived repositories; "last" moves them after the active
+// ones so fresh data lands first. In incremental runs, archived
+// repositories that were already archived at their last backup are not
+// revisited: they are read-only, so nothing can have changed. It returns
+// the repositories to process, the number dropped by the skip policy, and
+// the number carried over unchanged.
+func (b *Backup) planArchived(repos []api.Repository) (planned []api.Repository, dropped, unchanged int) {
+	mode := b.cfg.Backup.ArchivedRepos
+	planned = repos[:0:0]
+	for _, repo := range repos {
+		if !repo.IsArchived {
+			planned = append(planned, repo)
+			continue
+		}
+		if mode == "skip" {
+			dropped++
+			continue
+		}
+		if !b.opts.Full && b.archivedBackupCurrent(&repo) {
+			unchanged++
+			entry := RepoReport{Slug: repo.Slug, Status: RepoStatusSkipped, Archived: true}
+			if repo.Project != nil {
+				entry.Project = repo.Project.Key
+			}
+			b.report.Add(entry)
+			continue
+		}
+		planned = append(planned, repo)
+	}
+
+	if mode == "last" {
+		sort.SliceStable(planned, func(i, j int) bool {
+			return !planned[i].IsArchived && planned[j].IsArchived
+		})
+	}
+	return planned, dropped, unchanged
+}
+
+// archivedBackupCurrent reports whether a repository was already archived
+// at its last successful backup and its mirror is still present.
+func (b *Backup) archivedBackupCurrent(repo *api.Repository) bool {
+	state, ok := b.state.GetRepoState(b.repoStateKey(repo))
+	if !ok || !state.Archived {
+		return false
+	}
+	_, err := os.Stat(b.storage.BasePath() + "/" + b.getLatestGitPath(repo))
+	return err == nil || b.opts.MetadataOnly
+}
@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func archivedTestRepos() []api.Repository {
+	return []api.Repository{
+		{Slug: "old", IsArchived: true},
+		{Slug: "api"},
+		{Slug: "legacy", IsArchived: true, Project: &api.Project{Key: "CORE"}},
+		{Slug: "web"},
+	}
+}
+
+func slugs(repos []api.Repository) string {
+	var s string
+	for i, r := range repos {
+		if i > 0 {
+			s += ","
+		}
+		s += r.Slug
+	}
+	return s
+}
+
+func TestPlanArchived_Modes(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    string
+		dropped int
+	}{
+		{"include", "old,api,legacy,web", 0},
+		{"last", "api,web,old,legacy", 0},
+		{"skip", "api,web", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			b := newRunTestBackup(t, "")
+			b.state = NewState("ws")
+			b.cfg.Backup.ArchivedRepos = tt.mode
+
+			planned, dropped, unchanged := b.planArchived(archivedTestRepos())
+			if got := slugs(planned); got != tt.want {
+				t.Errorf("planned = %s, want %s", got, tt.want)
+			}
+			if dropped != tt.dropped || unchanged != 0 {
+				t.Errorf("dropped, unchanged = %d, %d", dropped, unchanged)
+			}
+		})
+	}
+}
+
+func TestPlanArchived_SkipsCurrentArchivedBackups(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	b.cfg.Backup.ArchivedRepos = "include"
+
+	// "legacy" was archived at its last backup and its mirror exists;
+	// "old" was archived since, so it needs one more pass
+	b.state.UpdateRepository("legacy", "{1}", "CORE", "")
+	b.state.SetRepoArchived("legacy", true)
+	b.state.UpdateRepository("old", "{2}", "", "")
+	legacy := &api.Repository{Slug: "legacy", Project: &api.Project{Key: "CORE"}}
+	if err := os.MkdirAll(filepath.Join(b.storage.BasePath(), b.getLatestGitPath(legacy)), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	planned, _, unchanged := b.planArchived(archivedTestRepos())
+	if got := slugs(planned); got != "old,api,web" || unchanged != 1 {
+		t.Fatalf("planned = %s, unchanged = %d", got, unchanged)
+	}
+	if len(b.report.Repositories) != 1 || b.report.Repositories[0].Status != RepoStatusSkipped || !b.report.Repositories[0].Archived {
+		t.Errorf("unexpected report entries: %+v", b.report.Repositories)
+	}
+
+	// A full backup revisits everything
+	b.opts.Full = true
+	if planned, _, _ := b.planArchived(archivedTestRepos()); len(planned) != 4 {
+		t.Errorf("full backup planned %s", slugs(planned))
+	}
+}
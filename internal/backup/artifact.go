@@ -0,0 +1,166 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Encodings an artifact can be wrapped in, recognized by their leading
+// bytes rather than the file name.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+	EncodingAge  = "age"
+)
+
+// maxArtifactLayers bounds how many encodings are peeled off one file, so
+// a file that decodes to itself cannot loop forever.
+const maxArtifactLayers = 4
+
+var (
+	gzipMagic      = []byte{0x1f, 0x8b}
+	zstdMagic      = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	ageMagic       = []byte("age-encryption.org/v1\n")
+	ageArmorMagic  = []byte("-----BEGIN AGE ENCRYPTED FILE-----")
+	gitBundleMagic = [][]byte{[]byte("# v2 git bundle\n"), []byte("# v3 git bundle\n")}
+)
+
+// artifactSuffixes are the file name suffixes of encoded artifacts, in the
+// order they are applied: compression, then encryption.
+var artifactSuffixes = []string{".gz", ".zst", ".age"}
+
+// ErrMissingKey is returned when an artifact is encrypted and no key was
+// given to decrypt it.
+var ErrMissingKey = errors.New("no key given")
+
+// ErrMissingCodec is returned when decoding an artifact needs a tool that
+// is not installed.
+var ErrMissingCodec = errors.New("codec not available")
+
+// ArtifactKeys holds what decoding artifacts may need.
+type ArtifactKeys struct {
+	AgeIdentities []string // age identity files, passed to age -d -i
+}
+
+// DetectEncoding returns the encoding of data from its leading bytes, or
+// "" when it is not encoded.
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return EncodingGzip
+	case bytes.HasPrefix(data, zstdMagic):
+		return EncodingZstd
+	case bytes.HasPrefix(data, ageMagic), bytes.HasPrefix(data, ageArmorMagic):
+		return EncodingAge
+	}
+	return ""
+}
+
+// IsGitBundle reports whether data starts with a git bundle header.
+func IsGitBundle(data []byte) bool {
+	for _, magic := range gitBundleMagic {
+		if bytes.HasPrefix(data, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// ArtifactBaseName strips encoding suffixes from a file name, e.g.
+// "1.json.zst.age" to "1.json".
+func ArtifactBaseName(name string) string {
+	for {
+		trimmed := name
+		for _, suffix := range artifactSuffixes {
+			trimmed = strings.TrimSuffix(trimmed, suffix)
+		}
+		if trimmed == name {
+			return name
+		}
+		name = trimmed
+	}
+}
+
+// ArtifactNames returns the names a file may be stored under: as is,
+// compressed, encrypted, or both.
+func ArtifactNames(name string) []string {
+	names := []string{name}
+	for _, compressed := range []string{"", ".gz", ".zst"} {
+		if compressed != "" {
+			names = append(names, name+compressed)
+		}
+		names = append(names, name+compressed+".age")
+	}
+	return names
+}
+
+// DecodeArtifact peels every encoding off data and returns the content
+// with the encodings found, outermost first. gzip is decoded in process;
+// zstd and age need the zstd and age commands, and age the identities in
+// keys. Errors name the encoding and wrap ErrMissingKey or ErrMissingCodec
+// when that is what is missing.
+func DecodeArtifact(ctx context.Context, data []byte, keys ArtifactKeys) ([]byte, []string, error) {
+	var encodings []string
+	for len(encodings) < maxArtifactLayers {
+		encoding := DetectEncoding(data)
+		if encoding == "" {
+			return data, encodings, nil
+		}
+		encodings = append(encodings, encoding)
+
+		var err error
+		switch encoding {
+		case EncodingGzip:
+			data, err = gunzip(data)
+		case EncodingZstd:
+			data, err = decodeWith(ctx, data, "zstd", "-d", "-c", "-q")
+		case EncodingAge:
+			if len(keys.AgeIdentities) == 0 {
+				return nil, encodings, fmt.Errorf("age-encrypted: %w; pass an age identity file", ErrMissingKey)
+			}
+			args := []string{"-d"}
+			for _, id := range keys.AgeIdentities {
+				args = append(args, "-i", id)
+			}
+			data, err = decodeWith(ctx, data, "age", args...)
+		}
+		if err != nil {
+			return nil, encodings, fmt.Errorf("%s: %w", encoding, err)
+		}
+	}
+	return nil, encodings, fmt.Errorf("more than %d layers of encoding", maxArtifactLayers)
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close() //nolint:errcheck // read-only
+	return io.ReadAll(zr)
+}
+
+// decodeWith pipes data through a decoding command.
+func decodeWith(ctx context.Context, data []byte, name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, fmt.Errorf("%w: decoding needs the %s command, which is not in PATH", ErrMissingCodec, name)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", name, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return stdout.Bytes(), nil
+}
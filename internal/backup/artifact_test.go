@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeArtifact(t *testing.T) {
+	ctx := context.Background()
+	plain := []byte(`{"id":1}`)
+
+	got, encodings, err := DecodeArtifact(ctx, plain, ArtifactKeys{})
+	if err != nil || !bytes.Equal(got, plain) || len(encodings) != 0 {
+		t.Errorf("plain = %q, %v, %v", got, encodings, err)
+	}
+
+	got, encodings, err = DecodeArtifact(ctx, gzipBytes(t, gzipBytes(t, plain)), ArtifactKeys{})
+	if err != nil || !bytes.Equal(got, plain) || !reflect.DeepEqual(encodings, []string{EncodingGzip, EncodingGzip}) {
+		t.Errorf("gzip twice = %q, %v, %v", got, encodings, err)
+	}
+
+	_, encodings, err = DecodeArtifact(ctx, []byte("age-encryption.org/v1\n-> X25519 abc\n"), ArtifactKeys{})
+	if !errors.Is(err, ErrMissingKey) || !reflect.DeepEqual(encodings, []string{EncodingAge}) {
+		t.Errorf("age without identities = %v, %v; want ErrMissingKey", encodings, err)
+	}
+	if _, err := exec.LookPath("age"); err != nil {
+		_, _, err = DecodeArtifact(ctx, []byte("-----BEGIN AGE ENCRYPTED FILE-----\n"), ArtifactKeys{AgeIdentities: []string{"key.txt"}})
+		if !errors.Is(err, ErrMissingCodec) {
+			t.Errorf("age without the age command = %v; want ErrMissingCodec", err)
+		}
+	}
+}
+
+func TestDecodeArtifact_Zstd(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd not installed")
+	}
+	plain := []byte(`{"id":2}`)
+	cmd := exec.Command("zstd", "-c", "-q")
+	cmd.Stdin = bytes.NewReader(plain)
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, encodings, err := DecodeArtifact(context.Background(), compressed, ArtifactKeys{})
+	if err != nil || !bytes.Equal(got, plain) || !reflect.DeepEqual(encodings, []string{EncodingZstd}) {
+		t.Errorf("zstd = %q, %v, %v", got, encodings, err)
+	}
+}
+
+func TestArtifactNames(t *testing.T) {
+	for name, want := range map[string]string{
+		"1.json":         "1.json",
+		"1.json.gz":      "1.json",
+		"1.json.zst.age": "1.json",
+		"notes.txt.age":  "notes.txt",
+	} {
+		if got := ArtifactBaseName(name); got != want {
+			t.Errorf("ArtifactBaseName(%q) = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range ArtifactNames("comments.json") {
+		if ArtifactBaseName(name) != "comments.json" {
+			t.Errorf("ArtifactNames() includes %q", name)
+		}
+	}
+	if !IsGitBundle([]byte("# v2 git bundle\nabc refs/heads/main\n")) || IsGitBundle([]byte("{}")) {
+		t.Error("IsGitBundle() misread the header")
+	}
+}
@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// Files written beside a pull request's or issue's comments with
+// backup.include_attachments.
+const (
+	AttachmentsDirName  = "attachments"
+	DescriptionFileName = "description.md"
+)
+
+// embeddedURLPattern matches absolute URLs in Markdown or HTML. Brackets,
+// parentheses, and quotes end a URL so ![alt](url) and src="url" work.
+var embeddedURLPattern = regexp.MustCompile(`https?://[^\s()<>"'\[\]]+`)
+
+// unsafeFileChars are replaced in attachment file names.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// attachmentURLs returns the Bitbucket-hosted images and attachments
+// linked from text, in order of first appearance. Other links are left
+// alone: they do not die with the workspace and may not be ours to fetch.
+func (b *Backup) attachmentURLs(text string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, u := range embeddedURLPattern.FindAllString(text, -1) {
+		u = strings.TrimRight(u, ".,;:!?")
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		parsed, err := url.Parse(u)
+		if err != nil || !b.client.TrustedURL(u) {
+			continue
+		}
+		if strings.Contains(parsed.Path, "/images/") || strings.Contains(parsed.Path, "/attachments/") {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// attachmentFileName names the local copy of an attachment: a short hash
+// of the URL, so names never collide, then the URL's own file name.
+func attachmentFileName(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	prefix := hex.EncodeToString(sum[:])[:12]
+
+	name := "attachment"
+	if u, err := url.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			name = unsafeFileChars.ReplaceAllString(base, "_")
+		}
+	}
+	if len(name) > 64 {
+		name = name[len(name)-64:]
+	}
+	return prefix + "-" + name
+}
+
+// saveAttachments downloads the attachments linked from a description
+// into an attachments/ directory under each of dirs and writes a rendered
+// copy of the description, with the links pointing at the local files, to
+// description.md. The first of dirs is the run's copy; attachments already
+// in a later one (latest/) are copied from there rather than downloaded
+// again. Downloads go to the last of dirs, where an interrupted one is
+// resumed by the next run. Failed downloads are logged and keep their
+// original link.
+func (b *Backup) saveAttachments(ctx context.Context, what, text string, dirs ...string) error {
+	if !b.cfg.Backup.IncludeAttachments || len(dirs) == 0 {
+		return nil
+	}
+	urls := b.attachmentURLs(text)
+	if len(urls) == 0 {
+		return nil
+	}
+	prefix := api.LogPrefix(ctx)
+
+	local := make(map[string]string, len(urls))
+	for _, u := range urls {
+		name := attachmentFileName(u)
+		data := b.existingAttachment(dirs[1:], name)
+		downloadDir := ""
+		if data == nil {
+			var err error
+			downloadDir = dirs[len(dirs)-1]
+			data, err = b.downloadAttachment(ctx, u, filepath.Join(downloadDir, AttachmentsDirName, name))
+			if err != nil {
+				if !b.shuttingDown.Load() && !isContextCanceled(err) {
+					b.log.Error("%sFailed to download attachment %s for %s: %v", prefix, u, what, err)
+				}
+				continue
+			}
+		}
+		for _, dir := range dirs {
+			if dir == downloadDir {
+				continue
+			}
+			if err := b.storage.Write(filepath.Join(dir, AttachmentsDirName, name), data); err != nil {
+				return fmt.Errorf("saving attachment %s for %s: %w", name, what, err)
+			}
+		}
+		local[u] = AttachmentsDirName + "/" + name
+	}
+	if len(local) == 0 {
+		return nil
+	}
+
+	rendered := embeddedURLPattern.ReplaceAllStringFunc(text, func(u string) string {
+		trimmed := strings.TrimRight(u, ".,;:!?")
+		if rel, ok := local[trimmed]; ok {
+			return rel + u[len(trimmed):]
+		}
+		return u
+	})
+	for _, dir := range dirs {
+		if err := b.storage.Write(filepath.Join(dir, DescriptionFileName), []byte(rendered)); err != nil {
+			return fmt.Errorf("saving %s for %s: %w", DescriptionFileName, what, err)
+		}
+	}
+	b.log.Debug("%sSaved %d attachments for %s", prefix, len(local), what)
+	return nil
+}
+
+// downloadAttachment downloads an attachment to rel, a path in storage,
+// and returns its content. The file only appears at rel once complete.
+// The client writes it under storage.path; from there it is copied to
+// storage, which with s3 storage uploads it to the bucket.
+func (b *Backup) downloadAttachment(ctx context.Context, rawURL, rel string) ([]byte, error) {
+	dest := filepath.Join(b.storage.BasePath(), rel)
+	if _, err := b.client.Download(ctx, rawURL, dest, ""); err != nil {
+		return nil, err
+	}
+	if err := storage.WriteFile(b.storage, rel, dest); err != nil {
+		// Without the local copy the next run downloads it again
+		_ = os.Remove(dest)
+		return nil, fmt.Errorf("storing downloaded attachment: %w", err)
+	}
+	data, err := b.storage.Read(rel)
+	if err != nil {
+		return nil, fmt.Errorf("reading downloaded attachment: %w", err)
+	}
+	return data, nil
+}
+
+// existingAttachment returns an attachment already saved under one of
+// dirs, or nil.
+func (b *Backup) existingAttachment(dirs []string, name string) []byte {
+	for _, dir := range dirs {
+		if data, err := b.storage.Read(filepath.Join(dir, AttachmentsDirName, name)); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// visibleText returns text as the privacy policy would save it at the
+// given field path, e.g. "content", "raw" for an issue's body, so a
+// rendered copy never holds what the JSON leaves out. Dropped fields
+// come back empty.
+func (b *Backup) visibleText(text string, field ...string) string {
+	if b.privacy == nil {
+		return text
+	}
+	var v interface{} = text
+	for i := len(field) - 1; i >= 0; i-- {
+		v = map[string]interface{}{field[i]: v}
+	}
+	v = b.privacy.walk("", v)
+	for _, key := range field {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[key]
+	}
+	s, _ := v.(string)
+	return s
+}
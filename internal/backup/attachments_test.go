@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+func TestSaveAttachments(t *testing.T) {
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		if strings.HasSuffix(r.URL.Path, "/missing.png") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("image:" + r.URL.Path))
+	}))
+	defer server.Close()
+
+	b := newRunTestBackup(t, "")
+	b.cfg = config.Default()
+	b.cfg.Workspace = "ws"
+	b.cfg.Backup.IncludeAttachments = true
+	b.cfg.RateLimit.RequestsPerHour = 36000
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+
+	shot := server.URL + "/repo/1/images/abc-shot.png"
+	missing := server.URL + "/repo/1/images/missing.png"
+	text := "See ![shot](" + shot + ").\n<img src=\"" + shot + "\">\n" +
+		"Broken: " + missing + "\nDocs: https://example.com/images/x.png\n"
+
+	runDir, latestDir := "run/pull-requests/1", "latest/pull-requests/1"
+	if err := b.saveAttachments(context.Background(), "PR #1", text, runDir, latestDir); err != nil {
+		t.Fatal(err)
+	}
+	if n := downloads.Load(); n != 2 {
+		t.Errorf("downloads = %d, want 2", n)
+	}
+
+	name := attachmentFileName(shot)
+	base := b.storage.BasePath()
+	for _, dir := range []string{runDir, latestDir} {
+		data, err := os.ReadFile(filepath.Join(base, dir, AttachmentsDirName, name))
+		if err != nil || string(data) != "image:/repo/1/images/abc-shot.png" {
+			t.Fatalf("attachment in %s = %q, %v", dir, data, err)
+		}
+		rendered, err := os.ReadFile(filepath.Join(base, dir, DescriptionFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "See ![shot](attachments/" + name + ").\n<img src=\"attachments/" + name + "\">\n" +
+			"Broken: " + missing + "\nDocs: https://example.com/images/x.png\n"
+		if string(rendered) != want {
+			t.Errorf("rendered copy in %s:\n%s\nwant:\n%s", dir, rendered, want)
+		}
+	}
+
+	// The next run copies the attachment from latest/ instead
+	downloads.Store(0)
+	if err := b.saveAttachments(context.Background(), "PR #1", text, "run2/pull-requests/1", latestDir); err != nil {
+		t.Fatal(err)
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("downloads on the second run = %d, want 1 (the missing image)", n)
+	}
+	if _, err := os.Stat(filepath.Join(base, "run2/pull-requests/1", AttachmentsDirName, name)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSaveAttachments_Replicated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	b := newRunTestBackup(t, "")
+	b.cfg = config.Default()
+	b.cfg.Workspace = "ws"
+	b.cfg.Backup.IncludeAttachments = true
+	b.cfg.RateLimit.RequestsPerHour = 36000
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+	replica := newLocalStore(t, t.TempDir())
+	b.storage = storage.NewReplicated(b.storage, replica)
+
+	shot := server.URL + "/repo/1/images/abc-shot.png"
+	runDir, latestDir := "run/pull-requests/1", "latest/pull-requests/1"
+	if err := b.saveAttachments(context.Background(), "PR #1", "See "+shot, runDir, latestDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{runDir, latestDir} {
+		data, err := replica.Read(filepath.Join(dir, AttachmentsDirName, attachmentFileName(shot)))
+		if err != nil || string(data) != "image" {
+			t.Errorf("replica attachment in %s = %q, %v", dir, data, err)
+		}
+	}
+}
+
+func TestSaveAttachments_Disabled(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL("http://127.0.0.1:1")))
+	if err := b.saveAttachments(context.Background(), "PR #1", "https://bitbucket.org/repo/1/images/a.png", "run/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(b.storage.BasePath(), "run/1")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing written, got %v", err)
+	}
+}
+
+func TestAttachmentFileName(t *testing.T) {
+	a := attachmentFileName("https://bitbucket.org/repo/1/images/my shot (1).png?x=1")
+	if !strings.HasSuffix(a, "-my_shot_1_.png") {
+		t.Errorf("name = %q", a)
+	}
+	if b := attachmentFileName("https://bitbucket.org/repo/2/images/my shot (1).png"); a == b {
+		t.Error("different URLs got the same name")
+	}
+}
+
+func TestVisibleText(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	if got := b.visibleText("body", "content", "raw"); got != "body" {
+		t.Errorf("without a policy: %q", got)
+	}
+	b.privacy = newPrivacyFilter(config.PrivacyConfig{DropFields: []string{"content"}})
+	if got := b.visibleText("body", "content", "raw"); got != "" {
+		t.Errorf("with content dropped: %q", got)
+	}
+	if got := b.visibleText("body", "description"); got != "body" {
+		t.Errorf("description with content dropped: %q", got)
+	}
+}
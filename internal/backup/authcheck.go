@@ -0,0 +1,87 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// DefaultCredentials names the top-level auth section in AuthCheck results.
+const DefaultCredentials = "default"
+
+// AuthCheck is the outcome of checking one set of credentials.
+type AuthCheck struct {
+	Credentials string `json:"credentials"` // DefaultCredentials or a credentials set name
+	API         bool   `json:"api"`
+	Git         bool   `json:"git"`
+	Repository  string `json:"repository,omitempty"` // The repository git access was checked on
+	Error       string `json:"error,omitempty"`
+}
+
+// OK reports whether the credentials passed every check.
+func (c AuthCheck) OK() bool {
+	return c.Error == ""
+}
+
+// CheckAuth validates the configured credentials without backing anything
+// up: for the top-level auth section and each credentials set, it lists
+// one repository of the workspace through the API, then lists that
+// repository's refs over git. A set that can see no repositories passes
+// the API check and skips the git check.
+func CheckAuth(ctx context.Context, cfg *config.Config) []AuthCheck {
+	provider := auth.FromConfig(cfg)
+	client := api.NewClient(cfg, api.WithAuthProvider(provider))
+	gitClient := git.NewGoGitClient(git.WithCredentialFunc(auth.GitCredentialFunc(provider)))
+
+	names := append([]string{DefaultCredentials}, cfg.CredentialSetNames()...)
+
+	checks := make([]AuthCheck, 0, len(names))
+	for _, name := range names {
+		setCtx := ctx
+		if name != DefaultCredentials {
+			setCtx = auth.WithSet(ctx, name)
+		}
+		checks = append(checks, checkCredentials(setCtx, client.Provider, gitClient, cfg, name))
+	}
+	return checks
+}
+
+// checkCredentials runs the API and git checks for the credentials
+// selected in ctx.
+func checkCredentials(ctx context.Context, provider api.Provider, gitClient *git.GoGitClient, cfg *config.Config, name string) AuthCheck {
+	check := AuthCheck{Credentials: name}
+
+	var repo *api.Repository
+	var err error
+	if slug, ok := cfg.RepoTokenSlug(name); ok {
+		// A repository access token can only read its own repository
+		repo, err = provider.GetRepository(ctx, cfg.Workspace, slug)
+	} else {
+		repo, err = provider.SampleRepository(ctx, cfg.Workspace)
+	}
+	if err != nil {
+		check.Error = fmt.Sprintf("API: %v", err)
+		return check
+	}
+	check.API = true
+	if repo == nil {
+		return check
+	}
+
+	check.Repository = repo.Slug
+	cloneURL := cfg.Git.CloneURL(repo.CloneURL())
+	if cloneURL == "" {
+		check.Error = fmt.Sprintf("git: repository %s has no HTTPS clone URL", repo.Slug)
+		return check
+	}
+	if _, err := gitClient.ListRemoteRefs(ctx, cloneURL); err != nil {
+		check.Error = fmt.Sprintf("git: %v", err)
+		return check
+	}
+	check.Git = true
+	return check
+}
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestCheckAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		switch {
+		case r.URL.Path != "/repositories/ws" || r.URL.Query().Get("pagelen") != "1":
+			http.NotFound(w, r)
+		case user == "rotated":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"message":"Invalid credentials"}}`))
+		case user == "bot":
+			// The clone URL has nothing listening, so the git check fails
+			w.Write([]byte(`{"values":[{"slug":"api","links":{"clone":[{"name":"https","href":"http://127.0.0.1:1/ws/api.git"}]}}]}`))
+		default:
+			w.Write([]byte(`{"values":[]}`))
+		}
+	}))
+	defer server.Close()
+	t.Setenv(api.BaseURLEnvVar, server.URL)
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.MaxRetries = 0
+	cfg.Auth = config.AuthConfig{Method: "app_password", Username: "user", AppPassword: "pass"}
+	cfg.Credentials = []config.CredentialSet{
+		{Name: "old", AuthConfig: config.AuthConfig{Method: "app_password", Username: "rotated", AppPassword: "pass"}},
+		{Name: "ci", AuthConfig: config.AuthConfig{Method: "app_password", Username: "bot", AppPassword: "pass"}},
+	}
+
+	checks := CheckAuth(context.Background(), cfg)
+	if len(checks) != 3 {
+		t.Fatalf("got %d checks, want 3: %+v", len(checks), checks)
+	}
+
+	if c := checks[0]; c.Credentials != DefaultCredentials || !c.OK() || !c.API || c.Git {
+		t.Errorf("default check = %+v, want API only", c)
+	}
+	if c := checks[1]; c.Credentials != "old" || c.OK() || c.API || !strings.HasPrefix(c.Error, "API:") {
+		t.Errorf("old check = %+v, want an API failure", c)
+	}
+	if c := checks[2]; c.Credentials != "ci" || c.OK() || !c.API || c.Repository != "api" || !strings.HasPrefix(c.Error, "git:") {
+		t.Errorf("ci check = %+v, want a git failure on api", c)
+	}
+}
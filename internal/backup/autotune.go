@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"path/filepath"
+
+	"github.com/andy-wilson/bb-backup/internal/bench"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// applyBenchResults sets git_workers from the results of the last
+// `bb-backup bench` in the storage directory. Missing or unusable results
+// leave the configured value in place.
+func applyBenchResults(cfg *config.Config, log Logger) {
+	path := filepath.Join(cfg.Storage.Path, bench.FileName)
+	result, err := bench.Load(path)
+	if err != nil {
+		log.Debug("Auto-tune: no benchmark results (%v), keeping %d git workers", err, cfg.Parallelism.GitWorkers)
+		return
+	}
+	if result.Workspace != cfg.Workspace {
+		log.Debug("Auto-tune: benchmark results are for workspace %s, ignoring", result.Workspace)
+		return
+	}
+	workers := result.Recommendation.GitWorkers
+	if workers <= 0 {
+		log.Debug("Auto-tune: benchmark made no parallelism recommendation")
+		return
+	}
+	log.Info("Auto-tune: using %d git workers from benchmark of %s", workers, result.RanAt)
+	cfg.Parallelism.GitWorkers = workers
+}
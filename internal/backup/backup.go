@@ -9,15 +9,23 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
 	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/faults"
+	"github.com/andy-wilson/bb-backup/internal/format"
 	"github.com/andy-wilson/bb-backup/internal/git"
+	"github.com/andy-wilson/bb-backup/internal/notify"
+	"github.com/andy-wilson/bb-backup/internal/scan"
 	"github.com/andy-wilson/bb-backup/internal/storage"
+	"github.com/andy-wilson/bb-backup/internal/ui"
 )
 
 // bufferPool is a sync.Pool for reusing bytes.Buffer in JSON marshaling.
@@ -41,6 +49,44 @@ type Options struct {
 	Logger       Logger // Optional external logger
 	GitOnly      bool   // Only backup git repositories (skip PRs, issues)
 	MetadataOnly bool   // Only backup PRs, issues (skip git operations)
+
+	// ProgressMode selects the interactive renderer: "auto" (bar when the
+	// terminal supports ANSI, plain otherwise), "bar", or "plain".
+	ProgressMode     string
+	ProgressInterval time.Duration // Heartbeat interval for plain progress (0 = default)
+	ProgressFile     string        // Also append JSON progress events to this file
+	ProgressURL      string        // Also POST JSON progress events to this URL
+
+	// RerunID continues an existing run directory instead of starting a
+	// new one; repositories that already succeeded in it are skipped.
+	RerunID string
+
+	// Groups restricts the run to the repositories matched by the named
+	// config groups. Group patterns replace include_repos; excludes still
+	// apply.
+	Groups []string
+
+	// Repos restricts the run to exactly these repositories, e.g. a list
+	// piped in by other tooling. They replace include_repos,
+	// include_repos_file, and exclude_repos.
+	Repos []string
+
+	// Unquarantine releases the named repositories from quarantine so this
+	// run tries them; Requarantine puts them in (or back in) quarantine.
+	Unquarantine []string
+	Requarantine []string
+
+	// FailOnRepoError makes Run return an error coded REPOS_FAILED when
+	// any repository failed, instead of nil.
+	FailOnRepoError bool
+
+	// Faults injects artificial API, git, and worker failures for testing
+	// retry and shutdown handling (nil = disabled).
+	Faults *faults.Injector
+
+	// Version and Commit identify the bb-backup build in the manifest.
+	Version string
+	Commit  string
 }
 
 // Backup orchestrates the backup process.
@@ -48,14 +94,52 @@ type Backup struct {
 	cfg            *config.Config
 	opts           Options
 	client         *api.Client
+	provider       api.Provider // The client's Cloud or Data Center endpoints
+	auth           auth.Provider
 	storage        storage.Storage
+	writes         *storage.Buffered // Coalesces writes on high-latency storage (nil if disabled)
 	log            Logger
 	state          *State
 	filter         *RepoFilter
+	custom         *CustomMetadata // Operator-provided repository metadata (nil if none)
 	progress       *Progress
 	gitClient      *git.GoGitClient
 	shellGitClient *git.ShellGitClient // Fallback for when go-git fails
+	gitCLIVersion  string              // git --version, empty if git is not installed
+	runLog         *runLog             // Copy of the log for the run directory (nil if logging.bundle is off)
+	jobLog         *jobLog             // Holds each job's lines until it finishes (nil if logging.buffer_jobs is off)
+	scanner        scan.Scanner        // Content policy scanner (nil if disabled)
+	packScanner    *scan.PackScanner   // Malware scanner for fetched objects (nil if disabled)
+	privacy        *privacyFilter      // Data minimization for saved entities (nil if disabled)
+	fieldMasks     fieldMasks          // Fields dropped from saved entities, by kind
+	budgets        *runtimeBudgets     // Runtime budgets of groups and projects (nil if none)
+	notifier       *notify.Notifier    // Run summary notifications (nil if none are set)
+	summary        *RunSummary         // Set when the run finishes
+	report         *Report             // Per-repo outcomes for this run
+	runID          string              // Names this run's directory under the workspace
+	runDir         string              // This run's directory, relative to the storage base
+	enumeratedAt   time.Time           // When the repository list was fetched
+	moves          repoMoves           // Repositories found in a different project
+	repoDirs       map[string]string   // Directory names of case-colliding repositories, by state key
+	caseCollisions []CaseCollision     // Repositories given a directory other than their slug
+	stagingLatest  bool                // Latest updates go to latest.tmp until published
+	changes        *ChangeFeed         // Entities created or updated this run (nil in dry run)
 	shuttingDown   atomic.Bool         // Set when graceful shutdown starts
+	panics         atomic.Int64        // Panics recovered this run, each with a crash report
+
+	// counters are the run's totals so far, for Prometheus metrics
+	counters runCounters
+
+	// reposByUUID holds the listed repositories, for finding forks'
+	// parents (nil unless git.fork_alternates is set)
+	reposByUUID map[string]*api.Repository
+
+	// pool is the running worker pool, for metrics
+	pool atomic.Pointer[workerPool]
+
+	// live is the progress display while repositories are processed, for
+	// Abort, which runs on the signal handler's goroutine
+	live atomic.Pointer[Progress]
 }
 
 // Logger interface for backup logging.
@@ -89,6 +173,11 @@ func (l *defaultLogger) Error(msg string, args ...interface{}) {
 
 // New creates a new Backup instance.
 func New(cfg *config.Config, opts Options) (*Backup, error) {
+	if len(cfg.Workspaces) > 0 {
+		// Each entry has its own storage and state; see cfg.WorkspaceConfigs
+		return nil, errcode.New(errcode.ConfigInvalid, "the config lists several workspaces; back up one at a time")
+	}
+
 	// Use provided logger or create default (needed before API client)
 	var log Logger
 	if opts.Logger != nil {
@@ -100,24 +189,74 @@ func New(cfg *config.Config, opts Options) (*Backup, error) {
 		}
 	}
 
+	// Keep a copy of the log for the run directory
+	var bundle *runLog
+	if cfg.Logging.Bundle && !opts.DryRun {
+		bundle = newRunLog(log, opts.Verbose)
+		log = bundle
+	}
+
+	// Write each job's lines as one block rather than interleaved
+	var jobs *jobLog
+	if cfg.Logging.BufferJobs {
+		jobs = newJobLog(log)
+		log = jobs
+	}
+
 	// Log authentication method being used
 	log.Debug("Using authentication method: %s", cfg.Auth.Method)
+	authProvider := auth.FromConfig(cfg, auth.WithRefreshHook(func(c auth.Credentials) {
+		if c.Expiry.IsZero() {
+			log.Info("Credentials refreshed")
+			return
+		}
+		log.Info("Credentials refreshed (valid until %s)", c.Expiry.Local().Format("15:04:05"))
+	}))
+	if cfg.Auth.CredentialCommand != "" {
+		log.Debug("Credentials come from auth.credential_command")
+	}
 
 	// Create API client with logging
 	clientOpts := []api.ClientOption{
+		api.WithAuthProvider(authProvider),
 		api.WithLogFunc(log.Debug),
+		api.WithMaintenanceFunc(func(until time.Time, reason string) {
+			log.Info("Bitbucket unavailable (%s); pausing for %s until %s",
+				reason, format.Duration(time.Until(until).Round(time.Second)), until.Format("15:04:05"))
+		}),
+	}
+	if opts.Faults.Enabled() {
+		log.Info("Fault injection enabled")
+		clientOpts = append(clientOpts, api.WithFaults(opts.Faults))
 	}
 	client := api.NewClient(cfg, clientOpts...)
+	if cfg.RateLimit.SharedStateFile != "" {
+		if err := client.RateLimiter().UseSharedBucket(cfg.RateLimit.SharedStateFile); err != nil {
+			return nil, fmt.Errorf("enabling shared rate limit: %w", err)
+		}
+		log.Debug("Sharing rate limit through %s", cfg.RateLimit.SharedStateFile)
+	}
 
-	store, err := storage.NewLocal(cfg.Storage.Path)
+	var storeOpts []storage.LocalOption
+	if cfg.Storage.VerifyWrites {
+		storeOpts = append(storeOpts, storage.WithVerifyWrites())
+	}
+	store, err := OpenStorage(cfg, storeOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("initializing storage: %w", err)
 	}
 
+	if cfg.Parallelism.AutoTune {
+		applyBenchResults(cfg, log)
+	}
+
 	// Load existing state for incremental backups
 	var state *State
 	if !opts.Full {
 		statePath := GetStatePath(cfg.Storage.Path, cfg.Workspace)
+		if err := fetchRemoteState(store, statePath); err != nil {
+			return nil, fmt.Errorf("loading state: %w", err)
+		}
 		state, err = LoadState(statePath)
 		if err != nil {
 			return nil, fmt.Errorf("loading state: %w", err)
@@ -135,12 +274,33 @@ func New(cfg *config.Config, opts Options) (*Backup, error) {
 	}
 
 	// Create repo filter with logging
-	filter := NewRepoFilterWithLog(cfg.Backup.IncludeRepos, cfg.Backup.ExcludeRepos, log.Debug)
+	includePatterns, excludePatterns, err := filterPatterns(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Repos) > 0 {
+		log.Info("Backing up %d listed repositories", len(opts.Repos))
+	} else if len(opts.Groups) > 0 {
+		log.Info("Backing up groups %s (%d patterns)", strings.Join(opts.Groups, ", "), len(includePatterns))
+	} else if cfg.Backup.IncludeReposFile != "" {
+		log.Info("Loaded %d include patterns from %s", len(includePatterns)-len(cfg.Backup.IncludeRepos), cfg.Backup.IncludeReposFile)
+	}
+	filter := NewRepoFilterWithLog(includePatterns, excludePatterns, log.Debug)
+
+	// Custom metadata is read on every run, like include_repos_file
+	var custom *CustomMetadata
+	if cfg.Backup.CustomMetadataFile != "" {
+		custom, err = LoadCustomMetadata(cfg.Backup.CustomMetadataFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug("Loaded custom metadata for %d repository patterns from %s", len(custom.Repositories), cfg.Backup.CustomMetadataFile)
+	}
 
 	// Create go-git client with credentials and rate limiting
-	gitUser, gitPass := cfg.GetGitCredentials()
+	gitCreds := auth.GitCredentialFunc(authProvider)
 	gitClient := git.NewGoGitClient(
-		git.WithCredentials(gitUser, gitPass),
+		git.WithCredentialFunc(gitCreds),
 		git.WithLogger(log.Debug),
 		git.WithRateLimit(client.RateLimiter().Wait),
 		git.WithSkipSizeCalc(), // Skip expensive directory size calculation during backup
@@ -148,41 +308,122 @@ func New(cfg *config.Config, opts Options) (*Backup, error) {
 
 	// Create shell git client as fallback (may be nil if git CLI not available)
 	var shellGitClient *git.ShellGitClient
+	var gitCLIVersion string
 	if git.IsGitCLIAvailable() {
 		shellGitClient = git.NewShellGitClient(
-			git.WithShellCredentials(gitUser, gitPass),
+			git.WithShellCredentialFunc(gitCreds),
 			git.WithShellLogger(log.Debug),
+			git.WithShellSSHKey(cfg.Git.SSHKeyPath),
 		)
 		log.Debug("Git CLI available, will use as fallback for go-git failures")
+		if gitCLIVersion, err = git.GetVersion(); err != nil {
+			log.Debug("Could not read git version: %v", err)
+		}
 	} else {
 		log.Debug("Git CLI not available, no fallback for go-git failures")
 	}
+	if cfg.Git.Engine == gitEngineCLI && shellGitClient == nil {
+		return nil, fmt.Errorf("git.engine is \"cli\" but git CLI is not available")
+	}
+	log.Debug("Git engine: %s (%d overrides)", cfg.Git.Engine, len(cfg.Git.Overrides))
+
+	// Create content policy scanner if enabled
+	var scanner scan.Scanner
+	if cfg.Scan.Enabled {
+		scanner, err = scan.New(cfg.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("initializing scanner: %w", err)
+		}
+		log.Debug("Content scanning enabled (scanner: %s)", scanner.Name())
+	}
+	var packScanner *scan.PackScanner
+	if cfg.Scan.PackCommand != "" {
+		packScanner = scan.NewPackScanner(cfg.Scan.PackCommand)
+		log.Debug("Scanning fetched objects with %q", cfg.Scan.PackCommand)
+	}
+
+	notifier, err := notify.New(cfg.Notifications)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Backup{
 		cfg:            cfg,
 		opts:           opts,
 		client:         client,
+		provider:       client.Provider,
+		auth:           authProvider,
 		storage:        store,
 		log:            log,
 		state:          state,
 		filter:         filter,
+		custom:         custom,
 		gitClient:      gitClient,
 		shellGitClient: shellGitClient,
+		gitCLIVersion:  gitCLIVersion,
+		runLog:         bundle,
+		jobLog:         jobs,
+		scanner:        scanner,
+		packScanner:    packScanner,
+		privacy:        newPrivacyFilter(cfg.Privacy),
+		fieldMasks:     newFieldMasks(cfg.Backup.FieldMasks),
+		budgets:        newRuntimeBudgets(cfg),
+		notifier:       notifier,
+		report:         NewReport(cfg.Workspace),
 	}, nil
 }
 
+// filterPatterns returns the include and exclude patterns for a run:
+// opts.Repos alone if given, else the patterns of opts.Groups or the
+// configured includes, with the configured excludes.
+func filterPatterns(cfg *config.Config, opts Options) (includes, excludes []string, err error) {
+	if len(opts.Repos) > 0 {
+		return opts.Repos, nil, nil
+	}
+	if len(opts.Groups) > 0 {
+		includes, err = cfg.GroupPatterns(opts.Groups)
+	} else {
+		includes, err = IncludePatterns(cfg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return includes, cfg.Backup.ExcludeRepos, nil
+}
+
 // Run executes the backup process.
-func (b *Backup) Run(ctx context.Context) error {
+func (b *Backup) Run(ctx context.Context) (err error) {
 	startTime := time.Now()
-	b.log.Info("Starting backup for workspace: %s", b.cfg.Workspace)
+	b.report.StartedAt = startTime.UTC().Format(time.RFC3339Nano)
+	b.counters.started = startTime
+	defer func() {
+		b.pushMetrics(err)
+		b.sendNotifications(err)
+	}()
+	workspaceName := b.cfg.Workspace
+	if alias := b.cfg.WorkspaceAlias; alias != "" && alias != workspaceName {
+		workspaceName += " (" + alias + ")"
+	}
+	b.log.Info("Starting backup for workspace: %s", workspaceName)
+	b.lowerPriority()
 
 	// In interactive mode, print status to console since logs go to file only
 	if b.opts.Interactive {
-		fmt.Fprintf(os.Stderr, "Starting backup for workspace: %s\n", b.cfg.Workspace)
+		fmt.Fprintf(os.Stderr, "Starting backup for workspace: %s\n", workspaceName)
+	}
+
+	b.publishMetrics()
+	defer b.serveMetrics()()
+
+	if err := b.checkQuota(); err != nil {
+		return err
 	}
 
 	if b.opts.DryRun {
 		b.log.Info("DRY RUN - no changes will be made")
+	} else if b.cfg.Storage.HighLatency {
+		b.bufferWrites()
+		defer b.closeWrites()
 	}
 
 	if b.opts.Incremental && b.state.HasPreviousBackup() {
@@ -212,42 +453,113 @@ func (b *Backup) Run(ctx context.Context) error {
 		}
 	}
 
-	// Create backup directory with timestamp
-	backupDir := filepath.Join(b.cfg.Workspace, startTime.Format("2006-01-02T15-04-05Z"))
+	// Create the run directory, keyed by run ID
+	runID, err := b.resolveRunID(startTime)
+	if err != nil {
+		return err
+	}
+	b.runID = runID
+	b.report.RunID = runID
+	backupDir := filepath.Join(b.cfg.Workspace, runID)
+	b.runDir = backupDir
+	if b.opts.RerunID != "" {
+		b.log.Info("Continuing run %s", runID)
+	} else {
+		b.log.Info("Run ID: %s", runID)
+	}
+	if b.opts.Interactive {
+		fmt.Fprintf(os.Stderr, "Run: %s\n", runID)
+	}
+	if b.runLog != nil {
+		if err := b.runLog.open(b.storage, backupDir); err != nil {
+			return err
+		}
+		defer func() {
+			if err := b.runLog.close(); err != nil {
+				b.log.Error("%v", err)
+			}
+		}()
+	}
+	if err := b.applyPolicy(ctx); err != nil {
+		return err
+	}
+	if b.cfg.Backup.AtomicLatest && !b.opts.DryRun {
+		if err := b.stageLatest(); err != nil {
+			return err
+		}
+	}
+	if !b.opts.DryRun {
+		changes, err := OpenChangeFeed(b.storage, filepath.Join(backupDir, ChangesFileName))
+		if err != nil {
+			return err
+		}
+		b.changes = changes
+		defer func() {
+			if err := b.changes.Close(); err != nil {
+				b.log.Error("Failed to close %s: %v", ChangesFileName, err)
+			}
+		}()
+	}
 
 	// Fetch workspace metadata
 	b.log.Info("Fetching workspace metadata...")
 	if b.opts.Interactive {
 		fmt.Fprint(os.Stderr, "Fetching workspace metadata... ")
 	}
-	workspace, err := b.client.GetWorkspace(ctx, b.cfg.Workspace)
+	workspace, err := b.fetchWorkspace(ctx)
 	if err != nil {
 		return fmt.Errorf("fetching workspace: %w", err)
 	}
 	if b.opts.Interactive {
 		fmt.Fprintln(os.Stderr, "done")
 	}
+	// A token limited to a project or repository sees only its repositories
+	limitedToken := workspace == nil
 
 	if !b.opts.DryRun {
-		if err := b.saveJSON(backupDir, "workspace.json", workspace); err != nil {
-			return fmt.Errorf("saving workspace metadata: %w", err)
+		if workspace != nil {
+			if err := b.saveEntity(backupDir, "workspace.json", b.rawOrTyped(workspace, workspace.Raw)); err != nil {
+				return fmt.Errorf("saving workspace metadata: %w", err)
+			}
 		}
-	}
-	b.log.Debug("Workspace: %s (%s)", workspace.Name, workspace.UUID)
+		b.updateCurrentLink(runID)
 
-	// Fetch projects
-	b.log.Info("Fetching projects...")
-	if b.opts.Interactive {
-		fmt.Fprint(os.Stderr, "Fetching projects... ")
+		// Members are captured so offboarding reports can find content
+		// authored by users who have since left; not fatal if unavailable
+		if !limitedToken {
+			if err := b.backupMembers(ctx, backupDir); err != nil && !isContextCanceled(err) {
+				b.log.Error("Failed to back up workspace members: %v", err)
+			}
+		}
 	}
-	projects, err := b.client.GetProjects(ctx, b.cfg.Workspace)
-	if err != nil {
-		return fmt.Errorf("fetching projects: %w", err)
+	if workspace != nil {
+		b.log.Debug("Workspace: %s (%s)", workspace.Name, workspace.UUID)
 	}
-	if b.opts.Interactive {
-		fmt.Fprintf(os.Stderr, "found %d\n", len(projects))
+
+	// Fetch projects; limited tokens take them from the repositories below
+	var projects []api.Project
+	if !limitedToken {
+		b.log.Info("Fetching projects...")
+		if b.opts.Interactive {
+			fmt.Fprint(os.Stderr, "Fetching projects... ")
+		}
+		projects, err = b.provider.GetProjects(ctx, b.cfg.Workspace)
+		if err != nil {
+			return fmt.Errorf("fetching projects: %w", err)
+		}
+		if b.opts.Interactive {
+			fmt.Fprintf(os.Stderr, "found %d\n", len(projects))
+		}
+		b.log.Info("Found %d projects", len(projects))
+		if include := b.cfg.Backup.IncludeProjects; len(include) > 0 {
+			var missing []string
+			projects, missing = FilterProjects(projects, include)
+			for _, key := range missing {
+				b.log.Info("Project %s in backup.include_projects was not found in the workspace", key)
+			}
+			b.log.Info("Backing up %d projects listed in backup.include_projects", len(projects))
+		}
 	}
-	b.log.Info("Found %d projects", len(projects))
 
 	// Fetch repositories
 	var repos []api.Repository
@@ -258,10 +570,14 @@ func (b *Backup) Run(ctx context.Context) error {
 		if b.opts.Interactive {
 			fmt.Fprintf(os.Stderr, "Fetching repository %s... ", singleRepoSlug)
 		}
-		repo, err := b.client.GetRepository(ctx, b.cfg.Workspace, singleRepoSlug)
+		setCtx := auth.WithSet(ctx, b.cfg.CredentialSetFor("", singleRepoSlug))
+		repo, err := b.provider.GetRepository(setCtx, b.cfg.Workspace, singleRepoSlug)
 		if err != nil {
 			return fmt.Errorf("fetching repository %s: %w", singleRepoSlug, err)
 		}
+		if !inIncludedProjects(repo, b.cfg.Backup.IncludeProjects) {
+			return fmt.Errorf("repository %s is not in a project listed in backup.include_projects", singleRepoSlug)
+		}
 		repos = []api.Repository{*repo}
 		if b.opts.Interactive {
 			fmt.Fprintln(os.Stderr, "done")
@@ -272,13 +588,27 @@ func (b *Backup) Run(ctx context.Context) error {
 		if b.opts.Interactive {
 			fmt.Fprint(os.Stderr, "Fetching repositories... ")
 		}
-		allRepos, err := b.client.GetRepositories(ctx, b.cfg.Workspace)
+		if q := b.filter.SlugQuery(); q != "" {
+			b.log.Debug("Listing repositories matching %s", q)
+		}
+		allRepos, err := ListRepositories(ctx, b.provider, b.cfg, b.filter)
 		if err != nil {
 			return fmt.Errorf("fetching repositories: %w", err)
 		}
 
+		// Directories are assigned over the whole listing so that
+		// filters don't change which repository keeps its slug
+		b.caseCollisions = b.assignRepoDirs(allRepos)
+		b.indexForkParents(allRepos)
+		for _, c := range b.caseCollisions {
+			b.log.Info("Repository %s differs from %s only by case; storing it in %s", c.Slug, c.CollidesWith, c.Dir)
+		}
+
 		// Apply filters
 		repos = b.filter.Filter(allRepos)
+		for _, slug := range MissingRepos(b.opts.Repos, repos) {
+			b.log.Error("Repository %s in the repository list was not found in the workspace", slug)
+		}
 		included, excluded := b.filter.FilteredCount(allRepos)
 		if excluded > 0 {
 			if b.opts.Interactive {
@@ -293,6 +623,38 @@ func (b *Backup) Run(ctx context.Context) error {
 		}
 	}
 
+	b.enumeratedAt = time.Now()
+
+	if limitedToken {
+		projects, _ = FilterProjects(ProjectsFromRepos(repos), b.cfg.Backup.IncludeProjects)
+		b.log.Info("Found %d projects in the token's repositories", len(projects))
+	}
+
+	// When resuming a run, skip repositories it already completed
+	skipped := 0
+	if b.opts.RerunID != "" {
+		repos, skipped = b.skipCompletedInRun(backupDir, repos)
+		if skipped > 0 {
+			b.log.Info("Skipping %d repositories already completed in run %s", skipped, runID)
+		}
+	}
+
+	// Apply the archived repository policy
+	repos, archivedDropped, archivedUnchanged := b.planArchived(repos)
+	if archivedDropped > 0 {
+		b.log.Info("Skipping %d archived repositories (backup.archived_repos: skip)", archivedDropped)
+	}
+	if archivedUnchanged > 0 {
+		b.log.Info("Skipping %d archived repositories with a current backup", archivedUnchanged)
+	}
+	b.applyQuarantineControls()
+	repos, quarantined := b.planQuarantine(repos)
+	if quarantined > 0 {
+		b.log.Info("Skipping %d quarantined repositories (release with --unquarantine)", quarantined)
+	}
+	b.scheduleLongestFirst(repos)
+	b.scheduleDeferredFirst(repos)
+
 	// Pre-scan to count existing vs new repos
 	existingCount, newCount := b.countExistingRepos(backupDir, repos, projects)
 
@@ -304,10 +666,42 @@ func (b *Backup) Run(ctx context.Context) error {
 			fmt.Fprintf(os.Stderr, "\nProcessing %d repositories...\n", len(repos))
 		}
 	}
-	b.progress = NewProgress(len(repos), b.opts.JSONProgress, b.opts.Quiet, b.opts.Interactive)
+	var progressOpts []ProgressOption
+	if b.usePlainProgress() {
+		progressOpts = append(progressOpts, WithPlainProgress(b.opts.ProgressInterval))
+	}
+	if b.opts.ProgressFile != "" {
+		sink, err := OpenProgressFile(b.opts.ProgressFile)
+		if err != nil {
+			return err
+		}
+		progressOpts = append(progressOpts, WithProgressSink(sink))
+	}
+	if b.opts.ProgressURL != "" {
+		progressOpts = append(progressOpts, WithProgressSink(NewHTTPProgressSink(b.opts.ProgressURL, nil)))
+	}
+	if b.cfg.Progress.WebhookURL != "" {
+		progressOpts = append(progressOpts, WithProgressSink(NewWebhookProgressSink(b.cfg.Progress, b.cfg.Workspace, b.runID, nil)))
+	}
+	if expected := b.expectedDurations(repos); len(expected) > 0 {
+		slugs := make([]string, len(repos))
+		for i, r := range repos {
+			slugs[i] = r.Slug
+		}
+		progressOpts = append(progressOpts, WithExpectedDurations(slugs, expected, b.cfg.Parallelism.GitWorkers))
+	}
+	b.progress = NewProgress(len(repos), b.opts.JSONProgress, b.opts.Quiet, b.opts.Interactive, progressOpts...)
+	b.live.Store(b.progress)
+	defer func() {
+		b.live.Store(nil)
+		if err := b.progress.Close(); err != nil {
+			b.log.Error("Closing progress output: %v", err)
+		}
+	}()
 
 	// Track stats
-	stats := &backupStats{}
+	stats := &backupStats{Repos: skipped + archivedUnchanged, Archived: archivedUnchanged, Quarantined: quarantined}
+	stats.Skipped = skipped + archivedDropped + archivedUnchanged + quarantined
 
 	// Process projects
 	for _, project := range projects {
@@ -320,7 +714,7 @@ func (b *Backup) Run(ctx context.Context) error {
 		projectDir := filepath.Join(backupDir, "projects", project.Key)
 
 		if !b.opts.DryRun {
-			if err := b.saveJSON(projectDir, "project.json", project); err != nil {
+			if err := b.saveEntity(projectDir, "project.json", b.rawOrTyped(project, project.Raw)); err != nil {
 				return fmt.Errorf("saving project %s metadata: %w", project.Key, err)
 			}
 			b.state.UpdateProject(project.Key, project.UUID)
@@ -329,13 +723,34 @@ func (b *Backup) Run(ctx context.Context) error {
 	}
 
 	// Process repositories with parallel workers
+	processStart := time.Now()
 	if err := b.processRepositories(ctx, backupDir, repos, projects, stats); err != nil {
 		return err
 	}
+	processing := time.Since(processStart)
+
+	// Publishing and readers of the run directory work on the files
+	// themselves, so pending writes must be out first
+	b.flushWrites()
+
+	// Swap in the staged latest tree only after every repository finished
+	if b.stagingLatest && ctx.Err() == nil {
+		if err := b.publishLatest(); err != nil {
+			return fmt.Errorf("publishing latest: %w", err)
+		}
+		b.repointPublishedForks(ctx)
+	}
+
+	// Measure disk usage before saving state, which caches it
+	var usage *WorkspaceUsage
+	if !b.opts.DryRun {
+		usage = b.measureUsage()
+	}
 
 	// Save state file
 	if !b.opts.DryRun {
-		if b.opts.Full || !b.state.HasPreviousBackup() {
+		full := b.opts.Full || !b.state.HasPreviousBackup()
+		if full {
 			b.state.MarkFullBackup()
 			b.log.Debug("State: marked full backup complete")
 		} else {
@@ -343,10 +758,12 @@ func (b *Backup) Run(ctx context.Context) error {
 			b.log.Debug("State: marked incremental backup complete")
 		}
 
+		b.recordRunStats(startTime, full, stats, usage)
+
 		statePath := GetStatePath(b.cfg.Storage.Path, b.cfg.Workspace)
 		b.log.Debug("State: saving to %s (%d projects, %d repos)",
 			statePath, len(b.state.Projects), len(b.state.Repositories))
-		if err := b.state.Save(statePath); err != nil {
+		if err := b.saveState(statePath); err != nil {
 			b.log.Error("Failed to save state file: %v", err)
 		}
 	}
@@ -354,14 +771,36 @@ func (b *Backup) Run(ctx context.Context) error {
 	// Generate manifest
 	if !b.opts.DryRun {
 		manifest := b.createManifest(startTime, stats)
+		manifest.StorageUsage = usage
 		if err := b.saveJSON(backupDir, "manifest.json", manifest); err != nil {
 			return fmt.Errorf("saving manifest: %w", err)
 		}
+
+		b.report.CompletedAt = manifest.CompletedAt
+		b.report.APIUsage = manifest.APIUsage
+		b.annotateReport()
+		b.report.Sort()
+		if err := b.saveJSON(backupDir, ReportFileName, b.report); err != nil {
+			return fmt.Errorf("saving report: %w", err)
+		}
+
+		if b.cfg.SLO.Enabled() {
+			b.evaluateSLO(backupDir, manifest)
+		}
+	}
+	b.flushWrites()
+
+	if !b.opts.DryRun && b.cfg.Storage.Archive != "" {
+		b.archiveRuns(ctx)
 	}
 
 	// Print summary
 	elapsed := time.Since(startTime)
-	b.log.Info("Backup completed in %s", elapsed.Round(time.Second))
+	b.log.Info("Backup completed in %s", format.Duration(elapsed))
+	if hits, misses := b.client.CacheStats(); hits > 0 {
+		b.log.Debug("API response cache: %d requests saved, %d fetched", hits, misses)
+	}
+	b.logAPIUsage(b.apiUsage(elapsed))
 	if stats.Interrupted > 0 {
 		b.log.Info("Stats: %d projects, %d repos, %d PRs, %d issues, %d failed, %d interrupted",
 			stats.Projects, stats.Repos, stats.PullRequests, stats.Issues, stats.Failed, stats.Interrupted)
@@ -370,8 +809,36 @@ func (b *Backup) Run(ctx context.Context) error {
 			stats.Projects, stats.Repos, stats.PullRequests, stats.Issues, stats.Failed)
 	}
 
+	if stats.Deferred > 0 {
+		b.log.Info("Deferred %d repositories to the next run: their runtime budgets were used up", stats.Deferred)
+	}
+
+	if n := b.changes.Count(); n > 0 {
+		b.log.Info("Changes: %d entities created or updated, listed in %s", n, ChangesFileName)
+	}
+
+	if findings := b.report.FindingCount(); findings > 0 {
+		b.log.Info("Content scan: %d findings recorded in %s", findings, ReportFileName)
+	}
+
+	if rewrites := b.report.RewriteCount(); rewrites > 0 {
+		b.log.Info("History rewrites: %d force-pushes, moved tags, or deleted branches recorded in %s", rewrites, ReportFileName)
+		if !b.opts.DryRun {
+			b.sendAlerts()
+		}
+	}
+
+	if n := b.panics.Load(); n > 0 {
+		b.log.Error("Recovered %d panics; crash reports are in %s/", n, filepath.Join(b.runDir, CrashesDirName))
+	}
+
+	if injected := b.opts.Faults.Summary(); injected != "" {
+		b.log.Info("Injected faults: %s", injected)
+	}
+
+	b.summary = b.runSummary(stats, processStart.Sub(startTime), processing, elapsed)
 	if b.progress != nil {
-		b.progress.Summary()
+		b.progress.Summary(b.summary)
 	}
 
 	// List failed repos if any
@@ -387,27 +854,38 @@ func (b *Backup) Run(ctx context.Context) error {
 				fmt.Fprintf(os.Stderr, "Failed repos: %s\n", strings.Join(names, ", "))
 			}
 		}
+		if b.opts.FailOnRepoError {
+			return reposFailedError(stats.Failed, b.report.FailureCodes())
+		}
 	}
 
 	return nil
 }
 
+// reposFailedError reports the repositories that failed, counted by code,
+// e.g. "3 repositories failed (AUTH_FAILED: 1, GIT_TIMEOUT: 2)".
+func reposFailedError(failed int, codes map[errcode.Code]int) error {
+	counts := make([]string, 0, len(codes))
+	for code, n := range codes {
+		counts = append(counts, fmt.Sprintf("%s: %d", code, n))
+	}
+	sort.Strings(counts)
+	noun := "repositories"
+	if failed == 1 {
+		noun = "repository"
+	}
+	return errcode.Errorf(errcode.ReposFailed, "%d %s failed (%s)", failed, noun, strings.Join(counts, ", "))
+}
+
 // processRepositories processes all repositories with parallel workers.
 func (b *Backup) processRepositories(ctx context.Context, backupDir string, repos []api.Repository, projects []api.Project, stats *backupStats) error {
 	b.log.Debug("processRepositories: starting with %d repos", len(repos))
 
-	// Group repos by project
-	reposByProject := make(map[string][]api.Repository)
-	var personalRepos []api.Repository
-
-	for _, repo := range repos {
-		if repo.Project != nil {
-			reposByProject[repo.Project.Key] = append(reposByProject[repo.Project.Key], repo)
-		} else {
-			personalRepos = append(personalRepos, repo)
-		}
+	// Repos whose project was not listed have nowhere to go
+	projectKeys := make(map[string]bool, len(projects))
+	for _, project := range projects {
+		projectKeys[project.Key] = true
 	}
-	b.log.Debug("processRepositories: %d project repos, %d personal repos", len(repos)-len(personalRepos), len(personalRepos))
 
 	// Create worker pool
 	workers := b.cfg.Parallelism.GitWorkers
@@ -417,32 +895,33 @@ func (b *Backup) processRepositories(ctx context.Context, backupDir string, repo
 	totalJobs := len(repos)
 	b.log.Debug("processRepositories: starting worker pool with %d workers for %d jobs (max retry: %d)", workers, totalJobs, b.opts.MaxRetry)
 	pool := newWorkerPool(workers, totalJobs, b.opts.MaxRetry, b.log.Debug)
+	if limit := b.cfg.Parallelism.MaxPerProject; limit > 0 && limit < workers {
+		b.log.Debug("processRepositories: at most %d repositories per project at a time", limit)
+		pool.limitPerProject(limit)
+	}
+	if b.cfg.Throttle.Adaptive() && workers > 1 {
+		pool.gate = newWorkerGate(workers)
+		governCtx, stopGoverning := context.WithCancel(ctx)
+		defer stopGoverning()
+		go b.governWorkers(governCtx, pool.gate, workers)
+	}
+	b.pool.Store(pool)
 	pool.start(ctx, b)
 
-	// Submit jobs for project repos
+	// Submit jobs in the planned order (longest first, archived last)
 	jobCount := 0
-	for _, project := range projects {
-		projectDir := filepath.Join(backupDir, "projects", project.Key)
-		for _, repo := range reposByProject[project.Key] {
-			jobID := generateJobID()
-			b.log.Debug("[%s] Submitting job for %s (project: %s)", jobID, repo.Slug, project.Key)
-			pool.submit(repoJob{
-				baseDir:  projectDir,
-				repo:     &repo,
-				maxRetry: b.opts.MaxRetry,
-				jobID:    jobID,
-			})
-			jobCount++
-		}
-	}
-
-	// Submit jobs for personal repos
-	personalDir := filepath.Join(backupDir, "personal")
-	for _, repo := range personalRepos {
+	for _, repo := range repos {
+		baseDir := filepath.Join(backupDir, personalDirName, b.repoOwner(&repo))
+		if repo.Project != nil {
+			if !projectKeys[repo.Project.Key] {
+				continue
+			}
+			baseDir = filepath.Join(backupDir, "projects", repo.Project.Key)
+		}
 		jobID := generateJobID()
-		b.log.Debug("[%s] Submitting job for %s (personal)", jobID, repo.Slug)
+		b.log.Debug("[%s] Submitting job for %s (project: %q)", jobID, repo.Slug, repoProjectKey(&repo))
 		pool.submit(repoJob{
-			baseDir:  personalDir,
+			baseDir:  baseDir,
 			repo:     &repo,
 			maxRetry: b.opts.MaxRetry,
 			jobID:    jobID,
@@ -475,14 +954,51 @@ func (b *Backup) processRepositories(ctx context.Context, backupDir string, repo
 	resultCount := 0
 	statePath := GetStatePath(b.cfg.Storage.Path, b.cfg.Workspace)
 	go func() {
-		for result := range pool.results {
+		// Checkpoint on a timer too, so a run dominated by a few large
+		// repositories does not go hours without saving state
+		var checkpointTick <-chan time.Time
+		var checkpointTicker *time.Ticker
+		interval := time.Duration(b.cfg.Backup.CheckpointIntervalSeconds) * time.Second
+		if !b.opts.DryRun && interval > 0 {
+			checkpointTicker = time.NewTicker(interval)
+			defer checkpointTicker.Stop()
+			checkpointTick = checkpointTicker.C
+		}
+
+	results:
+		for {
+			var result repoResult
+			select {
+			case r, ok := <-pool.results:
+				if !ok {
+					break results
+				}
+				result = r
+			case <-checkpointTick:
+				b.checkpointState(statePath, fmt.Sprintf("%s elapsed, %d repos processed", format.Duration(interval), resultCount))
+				continue
+			}
+
 			pool.markResultRead()
 			resultCount++
 			b.log.Debug("processRepositories: received result %d/%d for %s", resultCount, jobCount, result.repo.Slug)
+			var budgetErr *budgetError
+			if errors.As(result.err, &budgetErr) {
+				stats.Deferred++
+				b.state.DeferRepo(result.repo.Slug, budgetErr.budget)
+				entry := result.repoReport(RepoStatusDeferred)
+				entry.Code = ""
+				b.report.Add(entry)
+				if !b.shuttingDown.Load() && b.progress != nil {
+					b.progress.Defer(result.repo.Slug, result.err)
+				}
+				continue
+			}
 			if result.err != nil {
 				// Check if this was just an interrupt/cancellation (not a real failure)
 				if isContextCanceled(result.err) {
 					stats.Interrupted++
+					b.report.Add(result.repoReport(RepoStatusInterrupted))
 					// Don't log each interrupted repo - just count them silently
 					// Don't update progress bar during shutdown (already stopped)
 					continue
@@ -493,29 +1009,60 @@ func (b *Backup) processRepositories(ctx context.Context, backupDir string, repo
 					b.log.Error("Failed to backup repo %s: %v", result.repo.Slug, result.err)
 				}
 				stats.Failed++
+				b.counters.failed.Add(1)
 
 				// Track failed repo in state
 				projectKey := ""
 				if result.repo.Project != nil {
 					projectKey = result.repo.Project.Key
 				}
-				b.state.AddFailedRepo(result.repo.Slug, projectKey, result.err.Error(), b.opts.MaxRetry+1)
+				b.state.AddFailedRepo(result.repo.Slug, projectKey, result.err, b.opts.MaxRetry+1)
+				b.state.ClearDeferred(result.repo.Slug)
+				b.quarantineFailed(result.repo.Slug, result.err.Error())
+				b.report.Add(result.repoReport(RepoStatusFailed))
 
 				if !b.shuttingDown.Load() && b.progress != nil {
 					b.progress.Fail(result.repo.Slug, result.err)
 				}
 			} else {
 				stats.Repos++
+				stats.BackedUp++
 				stats.PullRequests += result.stats.PullRequests
 				stats.Issues += result.stats.Issues
+				if result.stats.Wiki {
+					stats.Wikis++
+				}
+				stats.Downloads += result.stats.Downloads
+				stats.Bytes += result.stats.Bytes
+				stats.Phases.add(result.stats.Phases)
+				b.counters.add(result.stats)
 
 				// Update state and remove from failed list if previously failed
 				projectKey := ""
 				if result.repo.Project != nil {
 					projectKey = result.repo.Project.Key
 				}
-				b.state.UpdateRepository(result.repo.Slug, result.repo.UUID, projectKey)
+				stateKey, owner := b.repoStateKey(result.repo), ""
+				if projectKey == "" {
+					owner = b.repoOwner(result.repo)
+				}
+				dir := b.repoDirName(result.repo)
+				if dir == result.repo.Slug {
+					dir = ""
+				}
+				b.state.UpdateRepository(stateKey, result.repo.UUID, projectKey, owner)
+				b.state.SetRepoArchived(stateKey, result.repo.IsArchived)
+				b.state.SetRepoDir(stateKey, dir)
+				if result.repo.IsArchived {
+					stats.Archived++
+				}
 				b.state.RemoveFailedRepo(result.repo.Slug) // Clear from failed list on success
+				b.state.ClearDeferred(result.repo.Slug)
+				if b.state.ReleaseQuarantine(result.repo.Slug) {
+					b.log.Info("Repository %s succeeded and left quarantine", result.repo.Slug)
+				}
+				b.recordRepoRun(&result)
+				b.report.Add(result.repoReport(RepoStatusOK))
 
 				if !b.shuttingDown.Load() && b.progress != nil {
 					b.progress.Complete(result.repo.Slug)
@@ -523,11 +1070,10 @@ func (b *Backup) processRepositories(ctx context.Context, backupDir string, repo
 			}
 
 			// Periodic state checkpoint for crash recovery
-			if !b.opts.DryRun && resultCount%CheckpointInterval == 0 {
-				if err := b.state.Save(statePath); err != nil {
-					b.log.Debug("State checkpoint failed: %v", err)
-				} else {
-					b.log.Debug("State checkpoint saved (%d repos processed)", resultCount)
+			if every := b.cfg.Backup.CheckpointRepos; !b.opts.DryRun && every > 0 && resultCount%every == 0 {
+				b.checkpointState(statePath, fmt.Sprintf("%d repos processed", resultCount))
+				if checkpointTicker != nil {
+					checkpointTicker.Reset(interval)
 				}
 			}
 		}
@@ -544,7 +1090,9 @@ func (b *Backup) processRepositories(ctx context.Context, backupDir string, repo
 		close(waitDone)
 	}()
 
-	// If context is cancelled, wait max 5 seconds for graceful shutdown
+	// If context is cancelled, wait up to backup.shutdown_timeout_seconds
+	// for in-flight repositories to finish
+	shutdownTimeout := time.Duration(b.cfg.Backup.ShutdownTimeoutSeconds) * time.Second
 	select {
 	case <-waitDone:
 		b.log.Debug("processRepositories: workers finished normally")
@@ -553,15 +1101,15 @@ func (b *Backup) processRepositories(ctx context.Context, backupDir string, repo
 		b.shuttingDown.Store(true)
 
 		// Stop the progress bar immediately to avoid noise
-		if b.progress != nil && b.progress.progressBar != nil {
-			b.progress.progressBar.Stop()
+		if b.progress != nil {
+			b.progress.Shutdown()
 		}
 
-		b.log.Debug("processRepositories: context cancelled, waiting up to 5s for workers...")
+		b.log.Debug("processRepositories: context cancelled, waiting up to %s for workers...", shutdownTimeout)
 		select {
 		case <-waitDone:
 			b.log.Debug("processRepositories: workers finished after cancellation")
-		case <-time.After(5 * time.Second):
+		case <-time.After(shutdownTimeout):
 			b.log.Debug("processRepositories: timeout waiting for workers, forcing shutdown")
 			// Force close results channel so result collector can exit
 			pool.closeResults()
@@ -585,6 +1133,48 @@ func (b *Backup) processRepositories(ctx context.Context, backupDir string, repo
 	return nil
 }
 
+// RunID returns the name of this run's directory under the workspace. It
+// is empty until Run has started the run.
+func (b *Backup) RunID() string {
+	return b.runID
+}
+
+// Summary returns the totals of the finished run, or nil if Run has not
+// finished or stopped before processing repositories.
+func (b *Backup) Summary() *RunSummary {
+	return b.summary
+}
+
+// Abort stops an interrupted run at once, for a second CTRL-C: it stops
+// the progress display so the terminal is left clean, saves the state
+// file so finished repositories are not fetched again (unless latest/ is
+// staged, see saveState), and writes out buffered files. The caller is expected to exit right after; work still
+// in flight is abandoned.
+func (b *Backup) Abort() {
+	b.shuttingDown.Store(true)
+	if p := b.live.Load(); p != nil {
+		p.Shutdown()
+		_ = p.Close()
+	}
+	if b.opts.DryRun || b.state == nil {
+		return
+	}
+	if err := b.saveState(GetStatePath(b.cfg.Storage.Path, b.cfg.Workspace)); err != nil {
+		b.log.Error("Failed to save state file: %v", err)
+	}
+	b.flushWrites()
+}
+
+// checkpointState saves the state file mid-run for crash recovery.
+// Failures are logged; the final save at the end of the run reports them.
+func (b *Backup) checkpointState(statePath, reason string) {
+	if err := b.saveState(statePath); err != nil {
+		b.log.Debug("State checkpoint failed: %v", err)
+		return
+	}
+	b.log.Debug("State checkpoint saved (%s)", reason)
+}
+
 func (b *Backup) saveJSON(dir, filename string, data interface{}) error {
 	// Get buffer from pool
 	buf := bufferPool.Get().(*bytes.Buffer)
@@ -599,43 +1189,83 @@ func (b *Backup) saveJSON(dir, filename string, data interface{}) error {
 	}
 
 	fullPath := filepath.Join(dir, filename)
-	b.log.Debug("Writing %s (%s)", fullPath, formatBytes(int64(buf.Len())))
+	b.log.Debug("Writing %s (%s)", fullPath, format.Bytes(int64(buf.Len())))
 
 	return b.storage.Write(fullPath, buf.Bytes())
 }
 
-// formatBytes formats a byte count as a human-readable string.
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
+// unchangedInLatest reports whether the entity file filename in dir
+// (relative to storage) is byte-identical to data as saveEntity would
+// write it. Comments and activity are not compared; Bitbucket bumps
+// updated_on when they change.
+func (b *Backup) unchangedInLatest(dir, filename string, data interface{}) bool {
+	existing, err := b.storage.Read(filepath.Join(dir, filename))
+	if err != nil {
+		return false
 	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	if data, err = b.renderEntity(dir, filename, data); err != nil {
+		return false
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return false
+	}
+	return bytes.Equal(existing, buf.Bytes())
+}
+
+// usePlainProgress reports whether interactive progress should use plain
+// heartbeat lines rather than the ANSI progress bar.
+func (b *Backup) usePlainProgress() bool {
+	switch b.opts.ProgressMode {
+	case "plain":
+		return true
+	case "bar":
+		return false
+	default:
+		return !ui.SupportsANSI(os.Stderr)
 	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMG"[exp])
 }
 
 func (b *Backup) createManifest(startTime time.Time, stats *backupStats) *Manifest {
 	return &Manifest{
 		Version:     "1.0",
+		RunID:       b.runID,
 		Workspace:   b.cfg.Workspace,
-		StartedAt:   startTime.UTC().Format(time.RFC3339),
+		StartedAt:   startTime.UTC().Format(time.RFC3339Nano),
 		CompletedAt: time.Now().UTC().Format(time.RFC3339),
 		Stats: ManifestStats{
 			Projects:     stats.Projects,
 			Repositories: stats.Repos,
 			PullRequests: stats.PullRequests,
 			Issues:       stats.Issues,
+			Wikis:        stats.Wikis,
+			Downloads:    stats.Downloads,
 			Failed:       stats.Failed,
+			Archived:     stats.Archived,
+			Quarantined:  stats.Quarantined,
+			Deferred:     stats.Deferred,
+			Panics:       int(b.panics.Load()),
 		},
 		Options: ManifestOptions{
 			Full:        b.opts.Full,
 			Incremental: b.opts.Incremental,
 			DryRun:      b.opts.DryRun,
+			Rerun:       b.opts.RerunID != "",
 		},
+		Groups:         b.opts.Groups,
+		Tools:          b.toolVersions(),
+		Config:         b.cfg.Fingerprint(),
+		Moves:          b.moves.list(),
+		CaseCollisions: b.caseCollisions,
+		FieldMasks:     b.cfg.Backup.FieldMasks,
+		Privacy:        b.privacyPolicy(),
+		APIUsage:       b.apiUsage(time.Since(startTime)),
 	}
 }
 
@@ -644,8 +1274,21 @@ type backupStats struct {
 	Repos        int
 	PullRequests int
 	Issues       int
+	Wikis        int
+	Downloads    int
 	Failed       int
 	Interrupted  int
+	Archived     int
+	Quarantined  int
+	Deferred     int
+
+	// For the run summary: repositories backed up by this run (Repos also
+	// counts ones carried over), repositories not run, mirror bytes, and
+	// worker time per phase
+	BackedUp int
+	Skipped  int
+	Bytes    int64
+	Phases   phaseTimes
 }
 
 // isContextCanceled checks if an error is due to context cancellation.
@@ -670,12 +1313,7 @@ func (b *Backup) countExistingRepos(backupDir string, repos []api.Repository, pr
 
 	for _, repo := range repos {
 		// Check the latest directory for existing git repos
-		var gitPath string
-		if repo.Project != nil && repo.Project.Key != "" {
-			gitPath = filepath.Join(basePath, b.cfg.Workspace, "latest", "projects", repo.Project.Key, "repositories", repo.Slug, "repo.git")
-		} else {
-			gitPath = filepath.Join(basePath, b.cfg.Workspace, "latest", "personal", "repositories", repo.Slug, "repo.git")
-		}
+		gitPath := filepath.Join(basePath, b.getLatestGitPath(&repo))
 
 		if isValidGitRepo(gitPath) {
 			existing++
@@ -704,11 +1342,26 @@ func isValidGitRepo(path string) bool {
 // Manifest describes a backup.
 type Manifest struct {
 	Version     string          `json:"version"`
+	RunID       string          `json:"run_id"`
 	Workspace   string          `json:"workspace"`
-	StartedAt   string          `json:"started_at"`
+	StartedAt   string          `json:"started_at"` // UTC, RFC 3339 with nanoseconds
 	CompletedAt string          `json:"completed_at"`
 	Stats       ManifestStats   `json:"stats"`
 	Options     ManifestOptions `json:"options"`
+	Groups      []string        `json:"groups,omitempty"`
+	Tools       ManifestTools   `json:"tools"`
+	Config      string          `json:"config_fingerprint"`
+	Moves       []RepoMove      `json:"moved_repositories,omitempty"`
+	// CaseCollisions are repositories stored under a disambiguated
+	// directory because their path differs from another's only by case
+	CaseCollisions []CaseCollision `json:"case_collisions,omitempty"`
+	// FieldMasks are the fields dropped from saved entities, by kind
+	FieldMasks map[string][]string `json:"field_masks,omitempty"`
+	Privacy    *PrivacyPolicy      `json:"privacy,omitempty"`
+	APIUsage   *APIUsage           `json:"api_usage,omitempty"`
+	// StorageUsage is the workspace's disk usage measured at the end of
+	// the run, before this manifest and the report were written
+	StorageUsage *WorkspaceUsage `json:"storage_usage,omitempty"`
 }
 
 // ManifestStats contains backup statistics.
@@ -717,7 +1370,16 @@ type ManifestStats struct {
 	Repositories int `json:"repositories"`
 	PullRequests int `json:"pull_requests"`
 	Issues       int `json:"issues"`
+	Wikis        int `json:"wikis,omitempty"`
+	Downloads    int `json:"downloads,omitempty"`
 	Failed       int `json:"failed"`
+	Archived     int `json:"archived,omitempty"`
+	Quarantined  int `json:"quarantined,omitempty"`
+	// Deferred counts repositories left for the next run by runtime budgets
+	Deferred int `json:"deferred,omitempty"`
+	// Panics counts panics recovered in workers and go-git; each has a
+	// report in crashes/
+	Panics int `json:"panics,omitempty"`
 }
 
 // ManifestOptions records the backup options used.
@@ -725,4 +1387,5 @@ type ManifestOptions struct {
 	Full        bool `json:"full"`
 	Incremental bool `json:"incremental"`
 	DryRun      bool `json:"dry_run"`
+	Rerun       bool `json:"rerun,omitempty"`
 }
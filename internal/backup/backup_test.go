@@ -2,36 +2,20 @@ package backup
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
-)
-
-func TestFormatBytes(t *testing.T) {
-	tests := []struct {
-		bytes    int64
-		expected string
-	}{
-		{0, "0 B"},
-		{100, "100 B"},
-		{1023, "1023 B"},
-		{1024, "1.0 KB"},
-		{1536, "1.5 KB"},
-		{1048576, "1.0 MB"},
-		{1572864, "1.5 MB"},
-		{1073741824, "1.0 GB"},
-	}
 
-	for _, tt := range tests {
-		t.Run(tt.expected, func(t *testing.T) {
-			got := formatBytes(tt.bytes)
-			if got != tt.expected {
-				t.Errorf("formatBytes(%d) = %q, want %q", tt.bytes, got, tt.expected)
-			}
-		})
-	}
-}
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
 
 func TestIsContextCanceled(t *testing.T) {
 	tests := []struct {
@@ -117,3 +101,89 @@ func TestDefaultLogger_Quiet(t *testing.T) {
 	l.Debug("debug message")
 	l.Error("error message")
 }
+
+// lossyStorage fails verification for writes under a path fragment.
+type lossyStorage struct {
+	storage.Storage
+	lose string
+}
+
+func (s *lossyStorage) Write(path string, data []byte) error {
+	if strings.Contains(path, s.lose) {
+		return fmt.Errorf("%w: %s has 0 bytes on disk", storage.ErrWriteVerification, path)
+	}
+	return s.Storage.Write(path, data)
+}
+
+func TestBackupPullRequests_WriteVerificationFailsRepo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var values []json.RawMessage
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/pullrequests") && r.URL.Query().Get("state") == "OPEN":
+			values = []json.RawMessage{json.RawMessage(`{"id":1,"updated_on":"2025-01-02T00:00:00Z"}`)}
+		case strings.HasSuffix(r.URL.Path, "/pullrequests/1/comments"):
+			values = []json.RawMessage{json.RawMessage(`{"id":5,"content":{"raw":"hi"}}`)}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+	cfg.Backup.IncludePRActivity = false
+
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &api.Repository{Slug: "repo"}
+
+	// A lost PR file or a lost comments file both stop the repository
+	for _, lose := range []string{"/1.json", "comments.json"} {
+		b := &Backup{
+			cfg:     cfg,
+			storage: &lossyStorage{Storage: local, lose: lose},
+			log:     &defaultLogger{quiet: true},
+			state:   NewState("ws"),
+		}
+		setClient(b, api.NewClient(cfg, api.WithBaseURL(server.URL)))
+		_, _, err := b.backupPullRequestsWorker(context.Background(), "run/repositories/repo", "ws/latest/personal/repositories/repo", repo)
+		if !errors.Is(err, storage.ErrWriteVerification) {
+			t.Errorf("losing %s: expected ErrWriteVerification, got %v", lose, err)
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.cfg.Storage.Path = b.storage.BasePath()
+	b.state = NewState("ws")
+	b.state.UpdateRepository("api", "{1}", "CORE", "")
+
+	sink := &recordingSink{}
+	b.progress = NewProgress(2, false, true, false, WithProgressSink(sink))
+	b.live.Store(b.progress)
+
+	b.Abort()
+
+	if !b.shuttingDown.Load() {
+		t.Error("Abort did not enter shutdown mode")
+	}
+	if sink.closed != 1 || len(sink.events) != 1 || sink.events[0].Type != ProgressEventShutdown {
+		t.Errorf("progress not stopped: closed %d, events %+v", sink.closed, sink.events)
+	}
+	state, err := LoadState(GetStatePath(b.cfg.Storage.Path, "ws"))
+	if err != nil || state == nil {
+		t.Fatalf("state not saved: %v", err)
+	}
+	if _, ok := state.GetRepoState("api"); !ok {
+		t.Error("saved state is missing api")
+	}
+
+	// Later events must not redraw the stopped display
+	b.progress.Complete("api")
+	if len(sink.events) != 1 {
+		t.Errorf("events after abort: %+v", sink.events[1:])
+	}
+}
@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyFileName is the copy of the backup policy read from
+// policy.repository. The workspace directory keeps the last good copy,
+// which prune applies and runs fall back to when the repository can't be
+// read; each run's directory keeps the copy that run applied.
+const PolicyFileName = "backup-policy.yaml"
+
+// Policy is a backup policy file, kept in a repository of the workspace
+// so that changes to it are reviewed as pull requests:
+//
+//	include_repos: ["core-*"]
+//	exclude_repos: ["*-sandbox"]
+//	retention:
+//	  keep_days: 90
+//	  keep_runs: 30
+//	  classes:
+//	    critical:
+//	      keep_days: 365
+//	repositories:
+//	  core-api:
+//	    owner: platform-team
+//	    retention_class: critical
+//
+// Patterns add to the config's. Retention values that are set replace the
+// config's, with classes merged by name. repositories is custom metadata
+// as in backup.custom_metadata_file; its fields win over the file's.
+type Policy struct {
+	IncludeRepos []string                `yaml:"include_repos"`
+	ExcludeRepos []string                `yaml:"exclude_repos"`
+	Retention    PolicyRetention         `yaml:"retention"`
+	Repositories map[string]CustomFields `yaml:"repositories"`
+}
+
+// PolicyRetention is the retention section of a policy file. Values left
+// unset keep the config's.
+type PolicyRetention struct {
+	KeepDays *int                                   `yaml:"keep_days"`
+	KeepRuns *int                                   `yaml:"keep_runs"`
+	Classes  map[string]config.RetentionClassConfig `yaml:"classes"`
+}
+
+// ParsePolicy parses and checks a policy file. Unknown keys are errors, so
+// a misspelt setting fails review-time testing rather than doing nothing.
+func ParsePolicy(data []byte) (*Policy, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var p Policy
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing policy: %w", err)
+	}
+
+	var errs []string
+	checkPatterns := func(key string, patterns []string) {
+		for i, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+				errs = append(errs, fmt.Sprintf("%s[%d] is not a valid glob: '%s'", key, i, pattern))
+			}
+		}
+	}
+	checkPatterns("include_repos", p.IncludeRepos)
+	checkPatterns("exclude_repos", p.ExcludeRepos)
+	if p.Retention.KeepDays != nil && *p.Retention.KeepDays < 0 {
+		errs = append(errs, "retention.keep_days must be 0 (keep forever) or more")
+	}
+	if p.Retention.KeepRuns != nil && *p.Retention.KeepRuns < 0 {
+		errs = append(errs, "retention.keep_runs must be 0 (no limit) or more")
+	}
+	classNames := make([]string, 0, len(p.Retention.Classes))
+	for name := range p.Retention.Classes {
+		classNames = append(classNames, name)
+	}
+	sort.Strings(classNames)
+	for _, name := range classNames {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, "retention.classes must not contain an empty class name")
+		}
+		if p.Retention.Classes[name].KeepDays < 0 {
+			errs = append(errs, fmt.Sprintf("retention.classes.%s.keep_days must be 0 (keep forever) or more", name))
+		}
+	}
+	patterns := make([]string, 0, len(p.Repositories))
+	for pattern := range p.Repositories {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	checkPatterns("repositories", patterns)
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("policy validation failed:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return &p, nil
+}
+
+// LoadCachedPolicy reads the copy of the policy kept in a workspace
+// directory. It returns nil if there is none.
+func LoadCachedPolicy(workspaceDir string) (*Policy, error) {
+	data, err := os.ReadFile(filepath.Join(workspaceDir, PolicyFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", PolicyFileName, err)
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", PolicyFileName, err)
+	}
+	return p, nil
+}
+
+// Apply merges the policy's patterns and retention into cfg.
+func (p *Policy) Apply(cfg *config.Config) {
+	if p == nil {
+		return
+	}
+	cfg.Backup.IncludeRepos = append(append([]string(nil), cfg.Backup.IncludeRepos...), p.IncludeRepos...)
+	cfg.Backup.ExcludeRepos = append(append([]string(nil), cfg.Backup.ExcludeRepos...), p.ExcludeRepos...)
+	if p.Retention.KeepDays != nil {
+		cfg.Retention.KeepDays = *p.Retention.KeepDays
+	}
+	if p.Retention.KeepRuns != nil {
+		cfg.Retention.KeepRuns = *p.Retention.KeepRuns
+	}
+	if len(p.Retention.Classes) > 0 {
+		classes := make(map[string]config.RetentionClassConfig, len(cfg.Retention.Classes)+len(p.Retention.Classes))
+		for name, class := range cfg.Retention.Classes {
+			classes[name] = class
+		}
+		for name, class := range p.Retention.Classes {
+			classes[name] = class
+		}
+		cfg.Retention.Classes = classes
+	}
+}
+
+// MergeCustom returns custom with the policy's repositories laid over it.
+// Neither is modified.
+func (p *Policy) MergeCustom(custom *CustomMetadata) *CustomMetadata {
+	if p == nil || len(p.Repositories) == 0 {
+		return custom
+	}
+	merged := &CustomMetadata{Repositories: make(map[string]CustomFields)}
+	if custom != nil {
+		for pattern, fields := range custom.Repositories {
+			merged.Repositories[pattern] = fields
+		}
+	}
+	for pattern, fields := range p.Repositories {
+		combined := make(CustomFields, len(merged.Repositories[pattern])+len(fields))
+		for k, v := range merged.Repositories[pattern] {
+			combined[k] = v
+		}
+		for k, v := range fields {
+			combined[k] = v
+		}
+		merged.Repositories[pattern] = combined
+	}
+	return merged
+}
+
+// applyPolicy reads the policy file from policy.repository and applies it
+// to this run, rebuilding the repository filter if it adds patterns. A
+// policy that can't be fetched or doesn't parse is replaced by the last
+// good copy, with an error logged, so a bad push or an outage doesn't
+// change what is backed up; with no copy to fall back on the run fails.
+func (b *Backup) applyPolicy(ctx context.Context) error {
+	pc := b.cfg.Policy
+	if pc.Repository == "" {
+		return nil
+	}
+
+	source := fmt.Sprintf("%s in %s", pc.Path, pc.Repository)
+	data, err := b.fetchPolicy(ctx)
+	var policy *Policy
+	if err == nil {
+		policy, err = ParsePolicy(data)
+	}
+	if err != nil {
+		workspaceDir := filepath.Join(b.storage.BasePath(), b.cfg.Workspace)
+		cached, cacheErr := os.ReadFile(filepath.Join(workspaceDir, PolicyFileName))
+		if cacheErr != nil {
+			return fmt.Errorf("reading policy %s: %w", source, err)
+		}
+		if policy, cacheErr = ParsePolicy(cached); cacheErr != nil {
+			return fmt.Errorf("reading policy %s: %w", source, err)
+		}
+		b.log.Error("Failed to read policy %s, using the copy from the last run: %v", source, err)
+		data = cached
+	} else if !b.opts.DryRun {
+		if err := b.storage.Write(filepath.Join(b.cfg.Workspace, PolicyFileName), data); err != nil {
+			return fmt.Errorf("saving %s: %w", PolicyFileName, err)
+		}
+	}
+	if !b.opts.DryRun {
+		if err := b.storage.Write(filepath.Join(b.runDir, PolicyFileName), data); err != nil {
+			return fmt.Errorf("saving %s: %w", PolicyFileName, err)
+		}
+	}
+
+	policy.Apply(b.cfg)
+	b.custom = policy.MergeCustom(b.custom)
+	if len(policy.IncludeRepos) > 0 || len(policy.ExcludeRepos) > 0 {
+		includes, excludes, err := filterPatterns(b.cfg, b.opts)
+		if err != nil {
+			return err
+		}
+		b.filter = NewRepoFilterWithLog(includes, excludes, b.log.Debug)
+	}
+	b.log.Info("Applied policy %s: %d include and %d exclude patterns, %d repository entries",
+		source, len(policy.IncludeRepos), len(policy.ExcludeRepos), len(policy.Repositories))
+	return nil
+}
+
+// fetchPolicy returns the policy file's content at policy.ref, or at the
+// repository's main branch when no ref is set.
+func (b *Backup) fetchPolicy(ctx context.Context) ([]byte, error) {
+	pc := b.cfg.Policy
+	ref := pc.Ref
+	if ref == "" {
+		repo, err := b.provider.GetRepository(ctx, b.cfg.Workspace, pc.Repository)
+		if err != nil {
+			return nil, err
+		}
+		if repo.MainBranch == nil || repo.MainBranch.Name == "" {
+			return nil, fmt.Errorf("repository %s has no main branch; set policy.ref", pc.Repository)
+		}
+		ref = repo.MainBranch.Name
+	}
+	return b.provider.GetFileContent(ctx, b.cfg.Workspace, pc.Repository, ref, pc.Path)
+}
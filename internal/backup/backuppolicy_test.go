@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+const testPolicy = `
+include_repos: ["core-*"]
+exclude_repos: ["*-sandbox"]
+retention:
+  keep_runs: 2
+  classes:
+    critical:
+      keep_days: 365
+repositories:
+  core-api:
+    retention_class: critical
+`
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	if len(p.IncludeRepos) != 1 || p.Retention.KeepRuns == nil || *p.Retention.KeepRuns != 2 || p.Retention.KeepDays != nil {
+		t.Errorf("ParsePolicy() = %+v", p)
+	}
+
+	if p, err := ParsePolicy(nil); err != nil || p == nil {
+		t.Errorf("an empty policy should parse, got %v, %v", p, err)
+	}
+
+	for name, data := range map[string]string{
+		"unknown key":   "exclude_repo: [x]\n",
+		"bad pattern":   "include_repos: [\"[\"]\n",
+		"negative days": "retention:\n  keep_days: -1\n",
+		"negative runs": "retention:\n  keep_runs: -1\n",
+		"bad class":     "retention:\n  classes:\n    archive:\n      keep_days: -5\n",
+		"bad repo glob": "repositories:\n  \"[\":\n    owner: x\n",
+	} {
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	p, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.Backup.ExcludeRepos = []string{"legacy-*"}
+	cfg.Retention = config.RetentionConfig{
+		KeepDays: 30,
+		KeepRuns: 10,
+		Classes:  map[string]config.RetentionClassConfig{"archive": {KeepDays: 3650}, "critical": {KeepDays: 90}},
+	}
+	classes := cfg.Retention.Classes
+
+	p.Apply(cfg)
+	if got := strings.Join(cfg.Backup.ExcludeRepos, ","); got != "legacy-*,*-sandbox" {
+		t.Errorf("exclude_repos = %s", got)
+	}
+	if got := strings.Join(cfg.Backup.IncludeRepos, ","); got != "core-*" {
+		t.Errorf("include_repos = %s", got)
+	}
+	if cfg.Retention.KeepDays != 30 || cfg.Retention.KeepRuns != 2 {
+		t.Errorf("retention = %+v, want keep_days kept and keep_runs replaced", cfg.Retention)
+	}
+	if cfg.Retention.Classes["critical"].KeepDays != 365 || cfg.Retention.Classes["archive"].KeepDays != 3650 {
+		t.Errorf("classes = %+v", cfg.Retention.Classes)
+	}
+	if classes["critical"].KeepDays != 90 {
+		t.Error("Apply() modified the config's class map in place")
+	}
+
+	custom := &CustomMetadata{Repositories: map[string]CustomFields{
+		"core-api": {CustomKeyOwner: "platform", CustomKeyRetentionClass: "archive"},
+	}}
+	merged := p.MergeCustom(custom)
+	if fields := merged.For("core-api"); fields.RetentionClass() != "critical" || fields[CustomKeyOwner] != "platform" {
+		t.Errorf("merged custom fields = %v", fields)
+	}
+	if custom.Repositories["core-api"].RetentionClass() != "archive" {
+		t.Error("MergeCustom() modified the custom metadata in place")
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	var policy, status = testPolicy, http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repositories/ws/backup-policy":
+			_, _ = w.Write([]byte(`{"slug":"backup-policy","mainbranch":{"name":"trunk"}}`))
+		case "/repositories/ws/backup-policy/src/trunk/.bbbackup.yaml":
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(policy))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	newBackup := func(dir string) *Backup {
+		b := newRunTestBackup(t, "")
+		if dir != "" {
+			store, err := storage.NewLocal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.storage = store
+		}
+		b.cfg = config.Default()
+		b.cfg.Workspace = "ws"
+		b.cfg.RateLimit.RequestsPerHour = 36000
+		b.cfg.Policy.Repository = "backup-policy"
+		setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+		b.runDir = filepath.Join("ws", "2024-01-15T10-30-00Z-abcd1234")
+		b.filter = NewRepoFilter(nil, nil)
+		return b
+	}
+
+	b := newBackup("")
+	if err := b.applyPolicy(context.Background()); err != nil {
+		t.Fatalf("applyPolicy() error = %v", err)
+	}
+	if b.filter.ShouldInclude("legacy-tool") || !b.filter.ShouldInclude("core-web") || b.filter.ShouldInclude("core-sandbox") {
+		t.Error("the filter should follow the policy's patterns")
+	}
+	if b.custom.For("core-api").RetentionClass() != "critical" {
+		t.Errorf("custom metadata = %v", b.custom.For("core-api"))
+	}
+	base := b.storage.BasePath()
+	for _, path := range []string{filepath.Join(base, "ws", PolicyFileName), filepath.Join(base, b.runDir, PolicyFileName)} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != testPolicy {
+			t.Errorf("%s = %q, %v", path, data, err)
+		}
+	}
+
+	// A broken push falls back to the last good copy
+	policy = "include_repos: [\n"
+	b = newBackup(base)
+	b.runDir = filepath.Join("ws", "2024-01-16T10-30-00Z-abcd1234")
+	if err := b.applyPolicy(context.Background()); err != nil {
+		t.Fatalf("applyPolicy() with a cached copy error = %v", err)
+	}
+	if !b.filter.ShouldInclude("core-web") || b.filter.ShouldInclude("legacy-tool") {
+		t.Error("the cached policy should apply")
+	}
+	if data, _ := os.ReadFile(filepath.Join(base, "ws", PolicyFileName)); string(data) != testPolicy {
+		t.Error("a broken policy should not replace the cached copy")
+	}
+
+	// Without a copy to fall back on, the run fails
+	status = http.StatusNotFound
+	b = newBackup("")
+	if err := b.applyPolicy(context.Background()); err == nil {
+		t.Error("expected an error without a policy or a cached copy")
+	}
+}
+
+func TestPrune_CachedPolicy(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	cfg.Retention.KeepDays = 0
+	cfg.Policy.Repository = "backup-policy"
+	now := time.Now()
+	oldest := writeRun(t, wsDir, now, 3, "web")
+	writeRun(t, wsDir, now, 2, "web")
+	writeRun(t, wsDir, now, 1, "web")
+
+	// Until a backup has kept a copy of the policy, the config's applies
+	result, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Actions) != 0 {
+		t.Fatalf("Prune() without a cached policy deleted %d paths", len(result.Actions))
+	}
+
+	if err := os.WriteFile(filepath.Join(wsDir, PolicyFileName), []byte(testPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, oldest)); err == nil || result.Runs != 1 {
+		t.Errorf("the run past the policy's keep_runs should be removed (%d runs removed)", result.Runs)
+	}
+	if cfg.Retention.KeepRuns != 0 {
+		t.Error("Prune() modified the caller's config")
+	}
+}
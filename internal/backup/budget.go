@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// RepoStatusDeferred marks a repository not backed up because the runtime
+// budget of its group or project ran out. The next run backs it up first.
+const RepoStatusDeferred = "deferred"
+
+// budgetError is the result of a repository deferred by an exhausted
+// runtime budget.
+type budgetError struct {
+	budget string
+}
+
+func (e *budgetError) Error() string {
+	return "deferred: runtime budget of " + e.budget + " used up"
+}
+
+// runtimeBudget is the worker time a budget's repositories have used so
+// far this run.
+type runtimeBudget struct {
+	name    string
+	limit   time.Duration
+	project string      // Set for a project budget
+	filter  *RepoFilter // Set for a group budget
+	spent   time.Duration
+	running map[string]time.Time // Start of each attempt in progress, by job ID
+}
+
+// matches reports whether a repository counts against the budget.
+func (rb *runtimeBudget) matches(repo *api.Repository) bool {
+	if rb.filter != nil {
+		return rb.filter.ShouldInclude(repo.Slug)
+	}
+	return repo.Project != nil && repo.Project.Key == rb.project
+}
+
+// used returns the time spent as of now, including attempts in progress.
+func (rb *runtimeBudget) used(now time.Time) time.Duration {
+	used := rb.spent
+	for _, start := range rb.running {
+		used += now.Sub(start)
+	}
+	return used
+}
+
+// runtimeBudgets tracks the budgets configured under budgets. A nil
+// *runtimeBudgets has no budgets.
+type runtimeBudgets struct {
+	mu      sync.Mutex
+	budgets []*runtimeBudget
+}
+
+// newRuntimeBudgets returns the tracker for cfg's budgets, or nil if there
+// are none.
+func newRuntimeBudgets(cfg *config.Config) *runtimeBudgets {
+	if len(cfg.Budgets) == 0 {
+		return nil
+	}
+	r := &runtimeBudgets{}
+	for _, b := range cfg.Budgets {
+		rb := &runtimeBudget{
+			name:    b.Name(),
+			limit:   time.Duration(b.Minutes) * time.Minute,
+			project: b.Project,
+			running: make(map[string]time.Time),
+		}
+		if b.Group != "" {
+			rb.filter = NewRepoFilter(cfg.Groups[b.Group], nil)
+		}
+		r.budgets = append(r.budgets, rb)
+	}
+	return r
+}
+
+// exhausted returns the name of a budget covering the repository that is
+// used up, or "" if the repository may start.
+func (r *runtimeBudgets) exhausted(repo *api.Repository) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, rb := range r.budgets {
+		if rb.matches(repo) && rb.used(now) >= rb.limit {
+			return rb.name
+		}
+	}
+	return ""
+}
+
+// start charges the time from now until stop to the budgets covering the
+// repository.
+func (r *runtimeBudgets) start(jobID string, repo *api.Repository) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, rb := range r.budgets {
+		if rb.matches(repo) {
+			rb.running[jobID] = now
+		}
+	}
+}
+
+// stop ends the attempt begun by start.
+func (r *runtimeBudgets) stop(jobID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, rb := range r.budgets {
+		if started, ok := rb.running[jobID]; ok {
+			rb.spent += now.Sub(started)
+			delete(rb.running, jobID)
+		}
+	}
+}
+
+// DeferRepo records that a repository was deferred by the named budget.
+func (s *State) DeferRepo(slug, budget string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Deferred == nil {
+		s.Deferred = make(map[string]string)
+	}
+	s.Deferred[slug] = budget
+}
+
+// ClearDeferred forgets that a repository was deferred, once a run has
+// tried it.
+func (s *State) ClearDeferred(slug string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Deferred, slug)
+}
+
+// isDeferred reports whether the last run deferred a repository.
+func (s *State) isDeferred(slug string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.Deferred[slug]
+	return ok
+}
+
+// scheduleDeferredFirst moves repositories deferred by earlier runs to the
+// front of the plan, keeping their order otherwise, so a budget that keeps
+// running out still reaches every repository in turn.
+func (b *Backup) scheduleDeferredFirst(repos []api.Repository) {
+	deferred := 0
+	for i := range repos {
+		if b.state.isDeferred(repos[i].Slug) {
+			deferred++
+		}
+	}
+	if deferred == 0 {
+		return
+	}
+	b.log.Info("Backing up %d repositories deferred by earlier runs first", deferred)
+	sort.SliceStable(repos, func(i, j int) bool {
+		return b.state.isDeferred(repos[i].Slug) && !b.state.isDeferred(repos[j].Slug)
+	})
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestRuntimeBudgets(t *testing.T) {
+	cfg := &config.Config{
+		Groups: map[string][]string{"archive": {"legacy-*"}},
+		Budgets: []config.RuntimeBudget{
+			{Group: "archive", Minutes: 30},
+			{Project: "OPS", Minutes: 10},
+		},
+	}
+	budgets := newRuntimeBudgets(cfg)
+	legacy := &api.Repository{Slug: "legacy-billing"}
+	ops := &api.Repository{Slug: "deploy", Project: &api.Project{Key: "OPS"}}
+	core := &api.Repository{Slug: "core", Project: &api.Project{Key: "CORE"}}
+
+	for _, repo := range []*api.Repository{legacy, ops, core} {
+		if name := budgets.exhausted(repo); name != "" {
+			t.Errorf("%s: budget %s used up before anything ran", repo.Slug, name)
+		}
+	}
+
+	// An attempt in progress counts against its budget as it runs
+	budgets.start("job-1", legacy)
+	budgets.budgets[0].running["job-1"] = time.Now().Add(-31 * time.Minute)
+	if name := budgets.exhausted(legacy); name != "group archive" {
+		t.Errorf("exhausted(legacy) = %q while over budget, want group archive", name)
+	}
+	budgets.stop("job-1")
+	if spent := budgets.budgets[0].spent; spent < 31*time.Minute {
+		t.Errorf("spent = %s after stop, want at least 31m", spent)
+	}
+	if name := budgets.exhausted(&api.Repository{Slug: "legacy-crm"}); name != "group archive" {
+		t.Errorf("exhausted(legacy-crm) = %q, want group archive", name)
+	}
+	if name := budgets.exhausted(ops); name != "" {
+		t.Errorf("exhausted(ops) = %q, want another budget unaffected", name)
+	}
+	if name := budgets.exhausted(core); name != "" {
+		t.Errorf("exhausted(core) = %q, want repositories without a budget unaffected", name)
+	}
+
+	var none *runtimeBudgets
+	none.start("job-2", core)
+	none.stop("job-2")
+	if newRuntimeBudgets(&config.Config{}) != nil || none.exhausted(core) != "" {
+		t.Error("no budgets should defer nothing")
+	}
+}
+
+func TestProcessJob_DefersOverBudget(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.cfg.Budgets = []config.RuntimeBudget{{Project: "OLD", Minutes: 1}}
+	b.budgets = newRuntimeBudgets(b.cfg)
+	b.budgets.budgets[0].spent = time.Minute
+
+	pool := newWorkerPool(1, 1, 0, nil)
+	repo := &api.Repository{Slug: "attic", Project: &api.Project{Key: "OLD"}}
+	pool.processJob(context.Background(), b, 1, repoJob{repo: repo, jobID: "job-1"})
+	result := <-pool.results
+	var budgetErr *budgetError
+	if !errors.As(result.err, &budgetErr) || budgetErr.budget != "project OLD" {
+		t.Fatalf("result error = %v, want deferral by project OLD", result.err)
+	}
+}
+
+func TestScheduleDeferredFirst(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	b.state.DeferRepo("c", "group archive")
+	b.state.DeferRepo("e", "group archive")
+	repos := []api.Repository{{Slug: "a"}, {Slug: "b"}, {Slug: "c"}, {Slug: "d"}, {Slug: "e"}}
+
+	b.scheduleDeferredFirst(repos)
+	if got := slugs(repos); got != "c,e,a,b,d" {
+		t.Errorf("planned %s, want deferred repositories first", got)
+	}
+
+	b.state.ClearDeferred("c")
+	if b.state.isDeferred("c") || !b.state.isDeferred("e") {
+		t.Errorf("Deferred = %v after clearing c", b.state.Deferred)
+	}
+}
@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// ChangesFileName is the per-run feed of entities created or updated.
+const ChangesFileName = "changes.ndjson"
+
+// Entity kinds recorded in the change feed.
+const (
+	ChangeKindRepository  = "repository"
+	ChangeKindPullRequest = "pull_request"
+	ChangeKindIssue       = "issue"
+	ChangeKindRef         = "ref"
+)
+
+// Change actions recorded in the change feed.
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// Change is one line of changes.ndjson. Before and After hold the entity's
+// updated_on timestamps, or commit hashes for refs; Before is empty for
+// created entities and After is empty for deleted refs.
+type Change struct {
+	Time    string `json:"time"`
+	Kind    string `json:"kind"`
+	Action  string `json:"action"`
+	Project string `json:"project,omitempty"`
+	Repo    string `json:"repo"`
+	ID      string `json:"id"`
+	Path    string `json:"path,omitempty"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+// ChangeFeed appends Change records to a run's changes.ndjson as they happen.
+// A nil *ChangeFeed discards records.
+type ChangeFeed struct {
+	mu    sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+	n     int
+	store storage.Storage
+	path  string
+}
+
+// OpenChangeFeed opens (or, for a rerun, appends to) the feed at path,
+// relative to store. Records are appended to the file in the store's
+// working directory; Close then writes the finished feed to store, which
+// copies it to a remote replica such as S3.
+func OpenChangeFeed(store storage.Storage, path string) (*ChangeFeed, error) {
+	local := filepath.Join(store.BasePath(), path)
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return nil, fmt.Errorf("creating directory for %s: %w", ChangesFileName, err)
+	}
+	f, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", ChangesFileName, err)
+	}
+	return &ChangeFeed{file: f, enc: json.NewEncoder(f), store: store, path: path}, nil
+}
+
+// Record appends a change, stamping the time if unset.
+func (f *ChangeFeed) Record(c Change) error {
+	if f == nil {
+		return nil
+	}
+	if c.Time == "" {
+		c.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.enc.Encode(c); err != nil {
+		return fmt.Errorf("writing %s: %w", ChangesFileName, err)
+	}
+	f.n++
+	return nil
+}
+
+// Count returns the number of changes recorded by this process.
+func (f *ChangeFeed) Count() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+// Close closes the feed and writes it to the store.
+func (f *ChangeFeed) Close() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return storage.WriteFile(f.store, f.path, f.file.Name())
+}
+
+// readUpdatedOn returns whether a previously saved entity exists at path
+// (relative to storage) and its updated_on value, used as the before marker.
+func (b *Backup) readUpdatedOn(path string) (bool, string) {
+	if b.changes == nil {
+		return false, ""
+	}
+	data, err := b.storage.Read(path)
+	if err != nil {
+		return false, ""
+	}
+	var rec rawRecord
+	_ = json.Unmarshal(data, &rec)
+	return true, rec.UpdatedOn
+}
+
+// recordEntity logs a saved entity to the change feed if it is new or its
+// updated_on moved; rewrites of unchanged entities are not changes.
+func (b *Backup) recordEntity(kind string, repo *api.Repository, id, path string, existed bool, before, after string) {
+	if b.changes == nil {
+		return
+	}
+	action := ChangeCreated
+	if existed {
+		if before == after {
+			return
+		}
+		action = ChangeUpdated
+	} else {
+		before = ""
+	}
+	b.recordChange(Change{
+		Kind:    kind,
+		Action:  action,
+		Project: repoProjectKey(repo),
+		Repo:    repo.Slug,
+		ID:      id,
+		Path:    path,
+		Before:  before,
+		After:   after,
+	})
+}
+
+// recordRefs logs each ref created, advanced, or deleted by a fetch.
+func (b *Backup) recordRefs(repo *api.Repository, updates []git.RefUpdate) {
+	for _, u := range updates {
+		action := ChangeUpdated
+		switch {
+		case u.Old == "":
+			action = ChangeCreated
+		case u.New == "":
+			action = ChangeDeleted
+		}
+		b.recordChange(Change{
+			Kind:    ChangeKindRef,
+			Action:  action,
+			Project: repoProjectKey(repo),
+			Repo:    repo.Slug,
+			ID:      u.Name,
+			Before:  u.Old,
+			After:   u.New,
+		})
+	}
+}
+
+func (b *Backup) recordChange(c Change) {
+	if err := b.changes.Record(c); err != nil {
+		b.log.Error("Failed to record change: %v", err)
+	}
+}
+
+func repoProjectKey(repo *api.Repository) string {
+	if repo.Project != nil {
+		return repo.Project.Key
+	}
+	return ""
+}
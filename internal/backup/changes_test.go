@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+func readChanges(t *testing.T, path string) []Change {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var changes []Change
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var c Change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			t.Fatalf("invalid ndjson line %q: %v", scanner.Text(), err)
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func TestChangeFeed_NilIsNoop(t *testing.T) {
+	var feed *ChangeFeed
+	if err := feed.Record(Change{Kind: ChangeKindIssue}); err != nil {
+		t.Errorf("Record() on nil feed = %v", err)
+	}
+	if feed.Count() != 0 || feed.Close() != nil {
+		t.Error("nil feed should count nothing and close cleanly")
+	}
+}
+
+func TestRecordEntityAndRefs(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	path := filepath.Join(b.storage.BasePath(), "ws", "run-1", ChangesFileName)
+	feed, err := OpenChangeFeed(b.storage, filepath.Join("ws", "run-1", ChangesFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.changes = feed
+
+	repo := &api.Repository{Slug: "repo", Project: &api.Project{Key: "PROJ"}}
+	prFile := "ws/latest/projects/PROJ/repositories/repo/pull-requests/1.json"
+
+	// New PR, then an unchanged rewrite, then an update
+	existed, before := b.readUpdatedOn(prFile)
+	b.recordEntity(ChangeKindPullRequest, repo, "1", prFile, existed, before, "2024-01-01T00:00:00Z")
+	if err := b.storage.Write(prFile, []byte(`{"id":1,"updated_on":"2024-01-01T00:00:00Z"}`)); err != nil {
+		t.Fatal(err)
+	}
+	existed, before = b.readUpdatedOn(prFile)
+	b.recordEntity(ChangeKindPullRequest, repo, "1", prFile, existed, before, "2024-01-01T00:00:00Z")
+	b.recordEntity(ChangeKindPullRequest, repo, "1", prFile, existed, before, "2024-02-01T00:00:00Z")
+
+	b.recordRefs(repo, []git.RefUpdate{
+		{Name: "refs/heads/main", Old: "aaa", New: "bbb"},
+		{Name: "refs/heads/new", New: "ccc"},
+		{Name: "refs/heads/gone", Old: "ddd"},
+	})
+
+	if err := feed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Count() != 5 {
+		t.Errorf("Count() = %d, want 5", feed.Count())
+	}
+
+	changes := readChanges(t, path)
+	if len(changes) != 5 {
+		t.Fatalf("got %d changes, want 5: %+v", len(changes), changes)
+	}
+
+	want := []struct{ kind, action, before, after string }{
+		{ChangeKindPullRequest, ChangeCreated, "", "2024-01-01T00:00:00Z"},
+		{ChangeKindPullRequest, ChangeUpdated, "2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z"},
+		{ChangeKindRef, ChangeUpdated, "aaa", "bbb"},
+		{ChangeKindRef, ChangeCreated, "", "ccc"},
+		{ChangeKindRef, ChangeDeleted, "ddd", ""},
+	}
+	for i, w := range want {
+		c := changes[i]
+		if c.Kind != w.kind || c.Action != w.action || c.Before != w.before || c.After != w.after {
+			t.Errorf("change %d = %+v, want %+v", i, c, w)
+		}
+		if c.Repo != "repo" || c.Project != "PROJ" || c.Time == "" {
+			t.Errorf("change %d missing repo/project/time: %+v", i, c)
+		}
+	}
+}
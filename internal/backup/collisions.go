@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// Bitbucket Server slugs may differ only by case, which on a
+// case-insensitive filesystem (macOS, Windows) puts two repositories in
+// the same directory. Repositories whose directories would fold to the same
+// name are told apart deterministically: a repository already backed up
+// under its slug keeps it, otherwise the first by UUID does, and the others
+// get slug~<first 8 characters of their UUID>. The directory a repository
+// is given is kept in the state file so later runs that see only some of
+// the colliding repositories use it too, and each run records its renames
+// in manifest.json.
+
+// CaseCollision is a repository stored under a directory other than its
+// slug because its path differs from another repository's only by case.
+type CaseCollision struct {
+	Slug         string `json:"slug"`
+	UUID         string `json:"uuid"`
+	Dir          string `json:"dir"`           // Directory name used instead of the slug
+	CollidesWith string `json:"collides_with"` // Slug of the repository that kept the name
+}
+
+// repoContainer returns the directory, relative to a run or latest/, that
+// holds a repository's repositories/ folder.
+func (b *Backup) repoContainer(repo *api.Repository) string {
+	if projectKey := repoProjectKey(repo); projectKey != "" {
+		return path.Join("projects", projectKey)
+	}
+	return path.Join(personalDirName, b.repoOwner(repo))
+}
+
+// assignRepoDirs finds repositories whose directories would collide on a
+// case-insensitive filesystem and gives every one but the first a
+// disambiguated directory. repos should be the whole listing, before
+// filters, so the outcome doesn't depend on which repositories a run
+// selects.
+func (b *Backup) assignRepoDirs(repos []api.Repository) []CaseCollision {
+	groups := make(map[string][]*api.Repository)
+	for i := range repos {
+		repo := &repos[i]
+		key := strings.ToLower(path.Join(b.repoContainer(repo), repo.Slug))
+		groups[key] = append(groups[key], repo)
+	}
+
+	dirs := make(map[string]string)
+	var collisions []CaseCollision
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if pi, pj := b.hasPlainDir(group[i]), b.hasPlainDir(group[j]); pi != pj {
+				return pi
+			}
+			if group[i].UUID != group[j].UUID {
+				return group[i].UUID < group[j].UUID
+			}
+			return group[i].FullName < group[j].FullName
+		})
+		for _, repo := range group[1:] {
+			dir := disambiguatedDir(repo)
+			dirs[b.repoStateKey(repo)] = dir
+			collisions = append(collisions, CaseCollision{
+				Slug:         repo.Slug,
+				UUID:         repo.UUID,
+				Dir:          dir,
+				CollidesWith: group[0].Slug,
+			})
+		}
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].Dir < collisions[j].Dir })
+
+	b.repoDirs = dirs
+	return collisions
+}
+
+// hasPlainDir reports whether the state records a repository as backed up
+// under its slug.
+func (b *Backup) hasPlainDir(repo *api.Repository) bool {
+	dir, ok := b.previousDir(repo)
+	return ok && dir == ""
+}
+
+// previousDir returns the directory name the state records for a
+// repository, empty for its slug, and whether the state knows it.
+func (b *Backup) previousDir(repo *api.Repository) (string, bool) {
+	if b.state == nil {
+		return "", false
+	}
+	prev, ok := b.state.GetRepoState(b.repoStateKey(repo))
+	if !ok || prev.UUID != repo.UUID {
+		return "", false
+	}
+	return prev.Dir, true
+}
+
+// disambiguatedDir returns the directory name for a repository that lost a
+// case collision.
+func disambiguatedDir(repo *api.Repository) string {
+	id := strings.Trim(repo.UUID, "{}")
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	if id == "" {
+		id = strings.ToLower(strings.ReplaceAll(repo.FullName, "/", "-"))
+	}
+	return repo.Slug + "~" + id
+}
+
+// repoDirName returns the directory name of a repository under its
+// repositories/ folder: its slug, unless it was disambiguated in this run
+// or an earlier one.
+func (b *Backup) repoDirName(repo *api.Repository) string {
+	if dir, ok := b.repoDirs[b.repoStateKey(repo)]; ok {
+		return dir
+	}
+	if dir, _ := b.previousDir(repo); dir != "" {
+		return dir
+	}
+	return repo.Slug
+}
@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func TestAssignRepoDirs(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+
+	core := &api.Project{Key: "CORE"}
+	repos := []api.Repository{
+		{Slug: "Tools", UUID: "{bbbbbbbb-0000-0000-0000-000000000002}", FullName: "ws/Tools", Project: core},
+		{Slug: "tools", UUID: "{aaaaaaaa-0000-0000-0000-000000000001}", FullName: "ws/tools", Project: core},
+		{Slug: "TOOLS", UUID: "{cccccccc-0000-0000-0000-000000000003}", FullName: "ws/TOOLS", Project: &api.Project{Key: "OTHER"}},
+		{Slug: "api", UUID: "{dddddddd-0000-0000-0000-000000000004}", FullName: "ws/api", Project: core},
+	}
+
+	collisions := b.assignRepoDirs(repos)
+	if len(collisions) != 1 {
+		t.Fatalf("collisions = %+v, want one", collisions)
+	}
+	want := CaseCollision{Slug: "Tools", UUID: repos[0].UUID, Dir: "Tools~bbbbbbbb", CollidesWith: "tools"}
+	if collisions[0] != want {
+		t.Errorf("collision = %+v, want %+v", collisions[0], want)
+	}
+	for i, wantDir := range []string{"Tools~bbbbbbbb", "tools", "TOOLS", "api"} {
+		if got := b.repoDirName(&repos[i]); got != wantDir {
+			t.Errorf("repoDirName(%s) = %q, want %q", repos[i].FullName, got, wantDir)
+		}
+	}
+}
+
+func TestAssignRepoDirs_KeepsExistingDirs(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+
+	// The repository with the higher UUID was backed up under its slug
+	// before the other appeared, so it keeps the name
+	first := api.Repository{Slug: "Tools", UUID: "{bbbbbbbb}", FullName: "ws/Tools"}
+	newer := api.Repository{Slug: "tools", UUID: "{aaaaaaaa}", FullName: "ws/tools"}
+	b.state.UpdateRepository("Tools", first.UUID, "", "ws")
+
+	b.assignRepoDirs([]api.Repository{newer, first})
+	if got := b.repoDirName(&first); got != "Tools" {
+		t.Errorf("repoDirName(Tools) = %q, want its slug", got)
+	}
+	if got := b.repoDirName(&newer); got != "tools~aaaaaaaa" {
+		t.Errorf("repoDirName(tools) = %q, want tools~aaaaaaaa", got)
+	}
+
+	// A later run that lists only the disambiguated repository still
+	// uses its directory from the state
+	b.state.UpdateRepository("tools", newer.UUID, "", "ws")
+	b.state.SetRepoDir("tools", "tools~aaaaaaaa")
+	b.assignRepoDirs([]api.Repository{newer})
+	if got := b.repoDirName(&newer); got != "tools~aaaaaaaa" {
+		t.Errorf("repoDirName(tools) on its own = %q, want tools~aaaaaaaa", got)
+	}
+}
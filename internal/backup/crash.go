@@ -0,0 +1,85 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/faults"
+)
+
+// CrashesDirName is the directory in a run holding one crash report per
+// recovered panic.
+const CrashesDirName = "crashes"
+
+// CrashReport describes a panic recovered while backing up a repository,
+// with what is needed to report it upstream (usually to go-git).
+type CrashReport struct {
+	Time       string        `json:"time"`
+	RunID      string        `json:"run_id"`
+	JobID      string        `json:"job_id,omitempty"`
+	Repository string        `json:"repository"`
+	Project    string        `json:"project,omitempty"`
+	Attempt    int           `json:"attempt,omitempty"` // 1 for the first try
+	Where      string        `json:"where"`             // "worker", "go-git clone", or "go-git fetch"
+	Panic      string        `json:"panic"`
+	Injected   bool          `json:"injected,omitempty"` // Raised by --faults, not a real bug
+	Stack      string        `json:"stack"`
+	Tools      ManifestTools `json:"tools"`
+	OS         string        `json:"os"`
+	Arch       string        `json:"arch"`
+}
+
+// attemptKey carries a job's attempt number to panic handlers below the
+// worker.
+type attemptKey struct{}
+
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+func attemptFrom(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptKey{}).(int)
+	return attempt
+}
+
+// recordPanic counts a recovered panic for the manifest and writes its
+// crash report to crashes/ in the run directory. It returns the report's
+// path, or "" if none was written.
+func (b *Backup) recordPanic(ctx context.Context, where string, repo *api.Repository, recovered interface{}, stack []byte) string {
+	n := b.panics.Add(1)
+
+	msg := fmt.Sprint(recovered)
+	report := CrashReport{
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		RunID:      b.runID,
+		JobID:      api.GetJobID(ctx),
+		Repository: repo.Slug,
+		Attempt:    attemptFrom(ctx),
+		Where:      where,
+		Panic:      msg,
+		Injected:   strings.HasPrefix(msg, faults.ErrInjected.Error()),
+		Stack:      string(stack),
+		Tools:      b.toolVersions(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+	}
+	if repo.Project != nil {
+		report.Project = repo.Project.Key
+	}
+	if b.opts.DryRun || b.runDir == "" {
+		return ""
+	}
+
+	dir := filepath.Join(b.runDir, CrashesDirName)
+	name := fmt.Sprintf("%03d-%s.json", n, repo.Slug)
+	if err := b.saveJSON(dir, name, report); err != nil {
+		b.log.Error("Failed to write crash report for %s: %v", repo.Slug, err)
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
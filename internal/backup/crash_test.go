@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/faults"
+)
+
+func TestRecordPanic(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.runID = "2024-01-15T10-30-00Z-abcd1234"
+	b.runDir = filepath.Join("ws", b.runID)
+	b.opts.Version = "1.2.3"
+
+	ctx := withAttempt(api.WithJobID(context.Background(), "job-1"), 2)
+	repo := &api.Repository{Slug: "core-api", Project: &api.Project{Key: "CORE"}}
+	path := b.recordPanic(ctx, "go-git fetch", repo, "index out of range", []byte("goroutine 1 [running]:\n..."))
+	if want := filepath.Join(b.runDir, CrashesDirName, "001-core-api.json"); path != want {
+		t.Fatalf("recordPanic() = %q, want %q", path, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(b.storage.BasePath(), path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report CrashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Repository != "core-api" || report.Project != "CORE" || report.JobID != "job-1" || report.Attempt != 2 {
+		t.Errorf("report identifies %+v", report)
+	}
+	if report.Where != "go-git fetch" || report.Panic != "index out of range" || !strings.HasPrefix(report.Stack, "goroutine 1") {
+		t.Errorf("report describes %+v", report)
+	}
+	if report.Injected || report.Tools.BBBackup != "1.2.3" || report.Tools.Go == "" || report.OS == "" {
+		t.Errorf("report context %+v", report)
+	}
+
+	// Injected panics are reported, but marked
+	path = b.recordPanic(context.Background(), "worker", repo, fmt.Sprintf("%v: panic in worker", faults.ErrInjected), nil)
+	data, _ = os.ReadFile(filepath.Join(b.storage.BasePath(), path))
+	if err := json.Unmarshal(data, &report); err != nil || !report.Injected {
+		t.Errorf("injected panic not marked: %s", data)
+	}
+	if n := b.panics.Load(); n != 2 {
+		t.Errorf("panics = %d, want 2", n)
+	}
+}
+
+func TestRecordPanic_DryRun(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.runDir = "ws/run"
+	b.opts.DryRun = true
+
+	if path := b.recordPanic(context.Background(), "worker", &api.Repository{Slug: "repo"}, "boom", nil); path != "" {
+		t.Errorf("dry run wrote a crash report: %s", path)
+	}
+	if b.panics.Load() != 1 {
+		t.Error("dry run panic not counted")
+	}
+}
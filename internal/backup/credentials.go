@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
+)
+
+// gitCredentials returns the credentials git operations will use next.
+func (b *Backup) gitCredentials(ctx context.Context) (auth.Credentials, error) {
+	if b.auth == nil {
+		return auth.NewStatic(b.cfg).GitCredentials(ctx)
+	}
+	return b.auth.GitCredentials(ctx)
+}
+
+// refreshGitCredentials refreshes the credentials after git rejected used
+// and reports whether the operation should be retried. Fixed credentials
+// can't be refreshed, so the failure stands.
+func (b *Backup) refreshGitCredentials(ctx context.Context, used auth.Credentials) bool {
+	if b.auth == nil {
+		return false
+	}
+	err := auth.RefreshRejected(ctx, b.auth, used)
+	if errors.Is(err, auth.ErrRefreshUnsupported) {
+		return false
+	}
+	if err != nil {
+		b.log.Error("%sCredential refresh failed: %v", api.LogPrefix(ctx), err)
+		return false
+	}
+	return true
+}
+
+// isCredentialError checks if a clone/fetch error looks like the server
+// rejecting the credentials, as when a short-lived token expires.
+func isCredentialError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := strings.ToLower(err.Error())
+	patterns := []string{
+		"authentication",
+		"could not read username",
+		"invalid credentials",
+		"401",
+	}
+	for _, pattern := range patterns {
+		if strings.Contains(errStr, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// tokenProvider hands out tok-N, moving to the next token on each refresh.
+type tokenProvider struct {
+	n         atomic.Int32
+	refreshes atomic.Int32
+	fixed     bool
+}
+
+func (p *tokenProvider) APICredentials(context.Context) (auth.Credentials, error) {
+	return auth.Credentials{Username: "x-token-auth", Password: fmt.Sprintf("tok-%d", p.n.Load()+1)}, nil
+}
+
+func (p *tokenProvider) GitCredentials(ctx context.Context) (auth.Credentials, error) {
+	return p.APICredentials(ctx)
+}
+
+func (p *tokenProvider) Refresh(context.Context) error {
+	if p.fixed {
+		return auth.ErrRefreshUnsupported
+	}
+	p.refreshes.Add(1)
+	p.n.Add(1)
+	return nil
+}
+
+// newGitAuthServer serves a bare repository over smart HTTP with
+// git http-backend, accepting only the given password.
+func newGitAuthServer(t *testing.T, password string) string {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not installed")
+	}
+
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	for _, args := range [][]string{
+		{"init", "-q", src},
+		{"-C", src, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+		{"clone", "-q", "--bare", src, filepath.Join(root, "repo.git")},
+	} {
+		if out, err := exec.Command(gitPath, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, ok := r.BasicAuth(); !ok || pass != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/repo.git"
+}
+
+func newGitAuthBackup(t *testing.T, p *tokenProvider) *Backup {
+	t.Helper()
+	b := newRunTestBackup(t, "")
+	b.cfg.Git.Engine = gitEngineGoGit
+	b.auth = p
+	b.gitClient = git.NewGoGitClient(git.WithCredentialFunc(auth.GitCredentialFunc(p)))
+	return b
+}
+
+func TestBackupGitRepo_RefreshesRejectedCredentials(t *testing.T) {
+	repoURL := newGitAuthServer(t, "tok-2")
+	p := &tokenProvider{}
+	b := newGitAuthBackup(t, p)
+	repo := &api.Repository{Slug: "repo", Links: api.Links{Clone: []api.Link{{Name: "https", Href: repoURL}}}}
+
+	if _, _, err := b.backupGitRepo(context.Background(), "", repo); err != nil {
+		t.Fatalf("backupGitRepo() error = %v", err)
+	}
+	if got := p.refreshes.Load(); got != 1 {
+		t.Errorf("refreshes = %d, want 1", got)
+	}
+	if !isValidGitRepo(filepath.Join(b.storage.BasePath(), b.getLatestGitPath(repo))) {
+		t.Error("mirror not cloned after refresh")
+	}
+}
+
+func TestBackupGitRepo_RefreshesOnce(t *testing.T) {
+	repoURL := newGitAuthServer(t, "never")
+	p := &tokenProvider{}
+	b := newGitAuthBackup(t, p)
+	repo := &api.Repository{Slug: "repo", Links: api.Links{Clone: []api.Link{{Name: "https", Href: repoURL}}}}
+
+	if _, _, err := b.backupGitRepo(context.Background(), "", repo); err == nil {
+		t.Fatal("expected error with rejected credentials")
+	}
+	if got := p.refreshes.Load(); got != 1 {
+		t.Errorf("refreshes = %d, want 1", got)
+	}
+}
+
+func TestBackupGitRepo_FixedCredentialsNotRetried(t *testing.T) {
+	repoURL := newGitAuthServer(t, "tok-2")
+	b := newGitAuthBackup(t, &tokenProvider{fixed: true})
+	repo := &api.Repository{Slug: "repo", Links: api.Links{Clone: []api.Link{{Name: "https", Href: repoURL}}}}
+
+	_, _, err := b.backupGitRepo(context.Background(), "", repo)
+	if err == nil || !isCredentialError(err) {
+		t.Fatalf("backupGitRepo() error = %v, want credential error", err)
+	}
+}
+
+func TestIsCredentialError(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{"authentication required", true},
+		{"fatal: could not read Username for 'https://bitbucket.org'", true},
+		{"The requested URL returned error: 401", true},
+		{"The requested URL returned error: 403", false},
+		{"proxyconnect tcp: connection refused", false},
+	}
+	for _, tt := range tests {
+		if got := isCredentialError(fmt.Errorf("%s", tt.err)); got != tt.want {
+			t.Errorf("isCredentialError(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	if isCredentialError(nil) {
+		t.Error("isCredentialError(nil) = true")
+	}
+}
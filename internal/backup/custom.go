@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CustomFileName is the operator-provided metadata written next to
+// repository.json.
+const CustomFileName = "custom.json"
+
+// Well-known custom metadata keys. Any other string keys are kept as well.
+const (
+	CustomKeyOwner          = "owner"
+	CustomKeyClassification = "classification"
+	CustomKeyRetentionClass = "retention_class"
+)
+
+// CustomFields is the operator-provided metadata for one repository, e.g.
+// its owner, data classification, and retention class.
+type CustomFields map[string]string
+
+// RetentionClass returns the repository's retention class, if it has one.
+func (f CustomFields) RetentionClass() string {
+	return f[CustomKeyRetentionClass]
+}
+
+// CustomMetadata maps repositories to custom fields, as read from
+// backup.custom_metadata_file. Keys are slugs or glob patterns.
+type CustomMetadata struct {
+	Repositories map[string]CustomFields `yaml:"repositories"`
+}
+
+// LoadCustomMetadata reads a custom metadata file (YAML or JSON):
+//
+//	repositories:
+//	  core-api:
+//	    owner: platform-team
+//	    classification: confidential
+//	    retention_class: critical
+//	  "legacy-*":
+//	    retention_class: archive
+func LoadCustomMetadata(path string) (*CustomMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading custom metadata: %w", err)
+	}
+	var m CustomMetadata
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing custom metadata %s: %w", path, err)
+	}
+	for pattern := range m.Repositories {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("custom metadata %s: invalid pattern %q: %w", path, pattern, err)
+		}
+	}
+	return &m, nil
+}
+
+// For returns the custom fields for a repository, or nil if none apply.
+// Every matching entry contributes: patterns first, in sorted order, then
+// the entry for the exact slug, so specific values override broad ones.
+func (m *CustomMetadata) For(slug string) CustomFields {
+	if m == nil || len(m.Repositories) == 0 {
+		return nil
+	}
+	patterns := make([]string, 0, len(m.Repositories))
+	for pattern := range m.Repositories {
+		if pattern != slug && strings.ContainsAny(pattern, "*?[") {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+
+	var fields CustomFields
+	merge := func(from CustomFields) {
+		for k, v := range from {
+			if fields == nil {
+				fields = make(CustomFields)
+			}
+			fields[k] = v
+		}
+	}
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, slug); matched {
+			merge(m.Repositories[pattern])
+		}
+	}
+	merge(m.Repositories[slug])
+	return fields
+}
+
+// saveCustom writes a repository's custom metadata to custom.json in each
+// of dirs. Repositories without any get no file.
+func (b *Backup) saveCustom(slug string, dirs ...string) error {
+	fields := b.custom.For(slug)
+	if len(fields) == 0 {
+		return nil
+	}
+	for _, dir := range dirs {
+		if err := b.saveJSON(dir, CustomFileName, fields); err != nil {
+			return fmt.Errorf("saving %s: %w", CustomFileName, err)
+		}
+	}
+	return nil
+}
+
+// annotateReport adds each repository's custom metadata to the report.
+func (b *Backup) annotateReport() {
+	if b.custom == nil {
+		return
+	}
+	b.report.mu.Lock()
+	defer b.report.mu.Unlock()
+	for i := range b.report.Repositories {
+		entry := &b.report.Repositories[i]
+		entry.Custom = b.custom.For(entry.Slug)
+	}
+}
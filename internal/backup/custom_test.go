@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCustomMetadata(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "custom.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCustomMetadata(t *testing.T) {
+	m, err := LoadCustomMetadata(writeCustomMetadata(t, `
+repositories:
+  "*":
+    classification: internal
+  "core-*":
+    owner: platform-team
+    retention_class: critical
+  core-billing:
+    owner: billing-team
+    classification: confidential
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]CustomFields{
+		"core-billing": {"owner": "billing-team", "classification": "confidential", "retention_class": "critical"},
+		"core-api":     {"owner": "platform-team", "classification": "internal", "retention_class": "critical"},
+		"website":      {"classification": "internal"},
+	}
+	for slug, want := range tests {
+		got := m.For(slug)
+		if len(got) != len(want) {
+			t.Errorf("For(%s) = %v, want %v", slug, got, want)
+			continue
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("For(%s)[%s] = %q, want %q", slug, k, got[k], v)
+			}
+		}
+	}
+	if got := m.For("core-api").RetentionClass(); got != "critical" {
+		t.Errorf("RetentionClass() = %q, want critical", got)
+	}
+
+	var none *CustomMetadata
+	if got := none.For("core-api"); got != nil {
+		t.Errorf("nil metadata For() = %v", got)
+	}
+}
+
+func TestLoadCustomMetadata_Errors(t *testing.T) {
+	if _, err := LoadCustomMetadata(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if _, err := LoadCustomMetadata(writeCustomMetadata(t, "repositories:\n  \"[core\":\n    owner: x\n")); err == nil {
+		t.Error("expected an error for a bad pattern")
+	}
+	if _, err := LoadCustomMetadata(writeCustomMetadata(t, "repositories: [1, 2]\n")); err == nil {
+		t.Error("expected an error for malformed content")
+	}
+}
+
+func TestSaveCustom(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.custom = &CustomMetadata{Repositories: map[string]CustomFields{
+		"core-*": {"owner": "platform-team"},
+	}}
+
+	if err := b.saveCustom("core-api", "latest/core-api", "run/core-api"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.saveCustom("website", "latest/website"); err != nil {
+		t.Fatal(err)
+	}
+
+	base := b.storage.BasePath()
+	for _, dir := range []string{"latest/core-api", "run/core-api"} {
+		data, err := os.ReadFile(filepath.Join(base, dir, CustomFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields CustomFields
+		if err := json.Unmarshal(data, &fields); err != nil || fields["owner"] != "platform-team" {
+			t.Errorf("%s/%s = %s, %v", dir, CustomFileName, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(base, "latest/website", CustomFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected no %s for a repository without metadata, got %v", CustomFileName, err)
+	}
+
+	b.report.Add(RepoReport{Slug: "core-api", Status: RepoStatusOK})
+	b.report.Add(RepoReport{Slug: "website", Status: RepoStatusOK})
+	b.annotateReport()
+	if got := b.report.Repositories[0].Custom; got["owner"] != "platform-team" {
+		t.Errorf("report custom = %v", got)
+	}
+	if got := b.report.Repositories[1].Custom; got != nil {
+		t.Errorf("report custom for website = %v, want none", got)
+	}
+}
@@ -0,0 +1,336 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// RefsFileName is the per-repository snapshot of the mirror's refs written
+// to the run directory after each fetch. Git lives only in latest/, so it
+// is what lets bundle-delta tell what a run added.
+const RefsFileName = "refs.json"
+
+// DeltaFileName describes a delta written by BundleDelta.
+const DeltaFileName = "delta.json"
+
+// RefSnapshot is the content of refs.json.
+type RefSnapshot struct {
+	GeneratedAt string            `json:"generated_at"`
+	Refs        map[string]string `json:"refs"`
+}
+
+// saveRefs records the mirror's refs in the run's directory for the
+// repository. Failures are logged and never fail the backup.
+func (b *Backup) saveRefs(ctx context.Context, repoDir, gitPath string) {
+	refs, err := git.ReadRefs(gitPath)
+	if err != nil {
+		b.log.Error("%sFailed to read refs of %s: %v", api.LogPrefix(ctx), gitPath, err)
+		return
+	}
+	snapshot := RefSnapshot{GeneratedAt: time.Now().UTC().Format(time.RFC3339), Refs: refs}
+	if err := b.saveJSON(repoDir, RefsFileName, snapshot); err != nil {
+		b.log.Error("%sFailed to save refs snapshot: %v", api.LogPrefix(ctx), err)
+	}
+}
+
+// DeltaOptions controls BundleDelta.
+type DeltaOptions struct {
+	Repos     []string // Slug glob patterns; empty includes every repository
+	OutputDir string   // Must not exist or be empty
+}
+
+// Delta is the content of delta.json: what changed between two runs and
+// where in the output directory it was written.
+type Delta struct {
+	From          string      `json:"from"`
+	To            string      `json:"to"`
+	CreatedAt     string      `json:"created_at"`
+	Repositories  []DeltaRepo `json:"repositories"`
+	Metadata      string      `json:"metadata,omitempty"` // Tarball of run-level files that changed
+	MetadataFiles int         `json:"metadata_files,omitempty"`
+	Bytes         int64       `json:"bytes"` // Everything written
+}
+
+// DeltaRepo is one repository's part of a delta. Paths are relative to
+// the output directory. Full is set when the older run has no refs
+// snapshot for the repository, so the bundle holds its whole history.
+type DeltaRepo struct {
+	Project       string          `json:"project,omitempty"`
+	Slug          string          `json:"slug"`
+	Bundle        string          `json:"bundle,omitempty"`
+	Full          bool            `json:"full,omitempty"`
+	RefUpdates    []git.RefUpdate `json:"ref_updates,omitempty"`
+	Metadata      string          `json:"metadata,omitempty"`
+	MetadataFiles int             `json:"metadata_files,omitempty"`
+	Bytes         int64           `json:"bytes"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// BundleDelta writes what changed in a workspace backup between the runs
+// in fromDir and toDir to opts.OutputDir, for shipping offsite without
+// re-syncing whole mirrors. For each repository with a mirror in latest/,
+// it writes a git bundle of the commits the newer run's refs have beyond
+// the older run's, and a gzipped tarball of the newer run's metadata files
+// that are new or differ from the older run's. Run-level files that
+// changed go in metadata.tar.gz, and a summary in delta.json.
+//
+// Repositories the newer run did not back up are left out. Ref deletions
+// and refs moved to commits the older run already had cannot be carried
+// by a bundle; they are listed in ref_updates in delta.json. A repository
+// that fails is recorded and the rest carry on; the error is for problems
+// with the whole delta.
+func BundleDelta(ctx context.Context, workspaceDir, fromDir, toDir string, opts DeltaOptions) (*Delta, error) {
+	if opts.OutputDir == "" {
+		return nil, fmt.Errorf("an output directory is required")
+	}
+	if entries, err := os.ReadDir(opts.OutputDir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty; write the delta into a new directory", opts.OutputDir)
+	}
+	from, okFrom := runStartTime(fromDir, runTimeFormat)
+	to, okTo := runStartTime(toDir, runTimeFormat)
+	if okFrom && okTo && !from.Before(to) {
+		return nil, fmt.Errorf("%s did not start before %s", filepath.Base(fromDir), filepath.Base(toDir))
+	}
+	mirrors, err := FindMirrors(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating output directory: %w", err)
+	}
+
+	delta := &Delta{
+		From:         filepath.Base(fromDir),
+		To:           filepath.Base(toDir),
+		CreatedAt:    time.Now().UTC().Format(time.RFC3339),
+		Repositories: []DeltaRepo{},
+	}
+	filter := NewRepoFilter(opts.Repos, nil)
+	for _, m := range mirrors {
+		if !filter.ShouldInclude(m.Slug) {
+			continue
+		}
+		rel := mirrorRunPath(m)
+		if _, err := os.Stat(filepath.Join(toDir, rel)); err != nil {
+			continue
+		}
+		repo := bundleRepoDelta(ctx, m, filepath.Join(fromDir, rel), filepath.Join(toDir, rel), opts.OutputDir)
+		delta.Bytes += repo.Bytes
+		delta.Repositories = append(delta.Repositories, repo)
+	}
+
+	// Run-level files: manifest, report, workspace and project metadata
+	isRepoFile := func(rel string) bool {
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		return (len(parts) > 3 && parts[0] == "projects" && parts[2] == "repositories") ||
+			(len(parts) > 2 && parts[0] == personalDirName && parts[1] == "repositories") ||
+			(len(parts) > 3 && parts[0] == personalDirName && parts[2] == "repositories")
+	}
+	n, size, err := tarChangedFiles(fromDir, toDir, isRepoFile, filepath.Join(opts.OutputDir, "metadata.tar.gz"))
+	if err != nil {
+		return delta, fmt.Errorf("writing run metadata: %w", err)
+	}
+	if n > 0 {
+		delta.Metadata, delta.MetadataFiles = "metadata.tar.gz", n
+		delta.Bytes += size
+	}
+
+	data, err := json.MarshalIndent(delta, "", "  ")
+	if err != nil {
+		return delta, fmt.Errorf("encoding %s: %w", DeltaFileName, err)
+	}
+	if err := os.WriteFile(filepath.Join(opts.OutputDir, DeltaFileName), append(data, '\n'), 0644); err != nil {
+		return delta, fmt.Errorf("writing %s: %w", DeltaFileName, err)
+	}
+	return delta, nil
+}
+
+// mirrorRunPath returns a repository's directory relative to a run or
+// latest/ directory.
+func mirrorRunPath(m Mirror) string {
+	if m.Project == "" {
+		return personalRepoRel(m.Owner, m.Slug)
+	}
+	return filepath.Join("projects", m.Project, "repositories", m.Slug)
+}
+
+// bundleRepoDelta writes one repository's bundle and metadata tarball.
+func bundleRepoDelta(ctx context.Context, m Mirror, fromRepoDir, toRepoDir, outputDir string) DeltaRepo {
+	result := DeltaRepo{Project: m.Project, Slug: m.Slug}
+	fail := func(err error) DeltaRepo {
+		result.Error = err.Error()
+		return result
+	}
+
+	to, err := readRefSnapshot(toRepoDir)
+	if errors.Is(err, os.ErrNotExist) {
+		// Metadata-only runs have no refs; ship their metadata alone
+		to = nil
+	} else if err != nil {
+		return fail(err)
+	}
+	if to != nil {
+		from, err := readRefSnapshot(fromRepoDir)
+		if errors.Is(err, os.ErrNotExist) {
+			result.Full = true
+			from = &RefSnapshot{}
+		} else if err != nil {
+			return fail(err)
+		}
+		result.RefUpdates = git.DiffRefs(from.Refs, to.Refs)
+
+		if len(result.RefUpdates) > 0 {
+			prerequisites := make([]string, 0, len(from.Refs))
+			seen := make(map[string]bool, len(from.Refs))
+			for _, hash := range from.Refs {
+				if !seen[hash] {
+					seen[hash] = true
+					prerequisites = append(prerequisites, hash)
+				}
+			}
+			sort.Strings(prerequisites)
+
+			bundle := m.RelPath() + ".bundle"
+			err := git.CreateBundle(ctx, m.Path, to.Refs, prerequisites, filepath.Join(outputDir, bundle))
+			switch {
+			case err == nil:
+				result.Bundle = bundle
+				if info, err := os.Stat(filepath.Join(outputDir, bundle)); err == nil {
+					result.Bytes += info.Size()
+				}
+			case !errors.Is(err, git.ErrEmptyBundle):
+				return fail(fmt.Errorf("bundling %s: %w", m.Slug, err))
+			}
+		}
+	}
+
+	tarball := m.RelPath() + ".metadata.tar.gz"
+	n, size, err := tarChangedFiles(fromRepoDir, toRepoDir, nil, filepath.Join(outputDir, tarball))
+	if err != nil {
+		return fail(fmt.Errorf("writing metadata of %s: %w", m.Slug, err))
+	}
+	if n > 0 {
+		result.Metadata, result.MetadataFiles = tarball, n
+		result.Bytes += size
+	}
+	return result
+}
+
+func readRefSnapshot(repoDir string) (*RefSnapshot, error) {
+	data, err := os.ReadFile(filepath.Join(repoDir, RefsFileName))
+	if err != nil {
+		return nil, err
+	}
+	var snapshot RefSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filepath.Join(repoDir, RefsFileName), err)
+	}
+	return &snapshot, nil
+}
+
+// tarChangedFiles writes the files under toDir that are missing from
+// fromDir or differ from it to a gzipped tarball at dest, skipping those
+// for which skip, given the path relative to toDir, is true. Nothing is
+// written when no file changed. It returns the files and bytes written.
+func tarChangedFiles(fromDir, toDir string, skip func(rel string) bool, dest string) (int, int64, error) {
+	var changed []string
+	err := filepath.WalkDir(toDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(toDir, path)
+		if err != nil {
+			return err
+		}
+		if skip != nil && skip(rel) {
+			return nil
+		}
+		current, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if previous, err := os.ReadFile(filepath.Join(fromDir, rel)); err == nil && bytes.Equal(previous, current) {
+			return nil
+		}
+		changed = append(changed, rel)
+		return nil
+	})
+	if err != nil || len(changed) == 0 {
+		return 0, 0, err
+	}
+
+	if err := writeTarball(dest, toDir, changed); err != nil {
+		return 0, 0, err
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(changed), info.Size(), nil
+}
+
+// writeTarball writes the files, relative to dir, to a gzipped tarball.
+func writeTarball(dest, dir string, files []string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("creating tarball: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("closing tarball: %w", cerr)
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	defer func() {
+		if cerr := gz.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("compressing tarball: %w", cerr)
+		}
+	}()
+	tw := tar.NewWriter(gz)
+	defer func() {
+		if cerr := tw.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("writing tarball: %w", cerr)
+		}
+	}()
+
+	for _, rel := range files {
+		if err := addTarFile(tw, filepath.Join(dir, rel), filepath.ToSlash(rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addTarFile(tw *tar.Writer, path, name string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: info.ModTime(), Format: tar.FormatPAX}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+func TestBundleDelta(t *testing.T) {
+	if !git.IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+	wsDir := t.TempDir()
+	repoRel := filepath.Join("projects", "CORE", "repositories", "core-api")
+	mirror := filepath.Join(wsDir, LatestDirName, repoRel, "repo.git")
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	work := filepath.Join(t.TempDir(), "work")
+	run("init", "-q", "-b", "main", work)
+	run("-C", work, "commit", "-q", "--allow-empty", "-m", "one")
+	one := run("-C", work, "rev-parse", "HEAD")
+	run("-C", work, "commit", "-q", "--allow-empty", "-m", "two")
+	two := run("-C", work, "rev-parse", "HEAD")
+	run("clone", "-q", "--mirror", work, mirror)
+
+	now := time.Now()
+	writeRepoRun := func(daysAgo int, refs map[string]string, files map[string]string) string {
+		t.Helper()
+		id := NewRunID(now.Add(-time.Duration(daysAgo) * 24 * time.Hour))
+		dir := filepath.Join(wsDir, id, repoRel)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(RefSnapshot{Refs: refs})
+		files[RefsFileName] = string(data)
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(wsDir, id, "manifest.json"), []byte(`{"run":"`+id+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filepath.Join(wsDir, id)
+	}
+	from := writeRepoRun(2, map[string]string{"refs/heads/main": one, "refs/heads/old": one},
+		map[string]string{"repository.json": `{"slug":"core-api"}`, "pull-requests/1.json": `{"id":1}`})
+	to := writeRepoRun(1, map[string]string{"refs/heads/main": two},
+		map[string]string{"repository.json": `{"slug":"core-api"}`, "pull-requests/2.json": `{"id":2}`})
+
+	out := filepath.Join(t.TempDir(), "delta")
+	delta, err := BundleDelta(context.Background(), wsDir, from, to, DeltaOptions{OutputDir: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Repositories) != 1 {
+		t.Fatalf("repositories = %+v", delta.Repositories)
+	}
+	repo := delta.Repositories[0]
+	if repo.Error != "" || repo.Full || repo.Bundle != filepath.Join("projects", "CORE", "core-api.bundle") {
+		t.Fatalf("repository delta = %+v", repo)
+	}
+	var deleted bool
+	for _, u := range repo.RefUpdates {
+		deleted = deleted || (u.Name == "refs/heads/old" && u.New == "")
+	}
+	if len(repo.RefUpdates) != 2 || !deleted {
+		t.Errorf("ref updates = %+v, want main advanced and old deleted", repo.RefUpdates)
+	}
+	if heads := run("bundle", "list-heads", filepath.Join(out, repo.Bundle)); !strings.Contains(heads, two+" refs/heads/main") {
+		t.Errorf("bundle heads = %q", heads)
+	}
+
+	// Only the files that changed, and refs.json, are shipped
+	if got := tarNames(t, filepath.Join(out, repo.Metadata)); strings.Join(got, ",") != "pull-requests/2.json,refs.json" {
+		t.Errorf("metadata tarball holds %v", got)
+	}
+	if got := tarNames(t, filepath.Join(out, delta.Metadata)); strings.Join(got, ",") != "manifest.json" {
+		t.Errorf("run metadata tarball holds %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(out, DeltaFileName)); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := BundleDelta(context.Background(), wsDir, from, to, DeltaOptions{OutputDir: out}); err == nil {
+		t.Error("BundleDelta into a non-empty directory should fail")
+	}
+	if _, err := BundleDelta(context.Background(), wsDir, to, from, DeltaOptions{OutputDir: filepath.Join(t.TempDir(), "x")}); err == nil {
+		t.Error("BundleDelta with the runs swapped should fail")
+	}
+}
+
+func tarNames(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+func TestBackupIssuesWorker_SkipsUnchanged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var values []json.RawMessage
+		if strings.HasSuffix(r.URL.Path, "/issues") {
+			values = []json.RawMessage{
+				json.RawMessage(`{"id":1,"title":"Same","updated_on":"2025-01-02T00:00:00Z"}`),
+				json.RawMessage(`{"id":2,"title":"Edited","updated_on":"2025-01-03T00:00:00Z"}`),
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+	cfg.Backup.IncludeIssueComments = false
+
+	tmpDir := t.TempDir()
+	store, err := storage.NewLocal(tmpDir)
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	b := &Backup{
+		cfg:     cfg,
+		storage: store,
+		log:     &defaultLogger{quiet: true},
+		state:   NewState("ws"),
+	}
+	setClient(b, api.NewClient(cfg, api.WithBaseURL(server.URL)))
+	b.state.UpdateRepository("repo", "{uuid}", "", "")
+	b.state.SetRepoLastIssueUpdated("repo", "2025-01-02T00:00:00Z")
+
+	latestDir := "ws/latest/personal/repositories/repo"
+	// Decode like the client does so the saved copy keeps the raw payload
+	var same api.Issue
+	if err := json.Unmarshal([]byte(`{"id":1,"title":"Same","updated_on":"2025-01-02T00:00:00Z"}`), &same); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.saveJSON(latestDir+"/issues", "1.json", &same); err != nil {
+		t.Fatal(err)
+	}
+	old := api.Issue{ID: 2, Title: "Original", UpdatedOn: "2025-01-01T00:00:00Z"}
+	if err := b.saveJSON(latestDir+"/issues", "2.json", &old); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &api.Repository{Slug: "repo", FullName: "ws/repo", HasIssues: true}
+	count, unchanged, err := b.backupIssuesWorker(context.Background(), "run/repositories/repo", latestDir, repo)
+	if err != nil {
+		t.Fatalf("backupIssuesWorker() error = %v", err)
+	}
+	if count != 1 || unchanged != 1 {
+		t.Fatalf("expected 1 saved and 1 unchanged, got %d and %d", count, unchanged)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "run/repositories/repo/issues/1.json")); !os.IsNotExist(err) {
+		t.Error("unchanged issue should not be written to the run directory")
+	}
+	data, err := os.ReadFile(filepath.Join(tmpDir, latestDir, "issues/2.json"))
+	if err != nil || !strings.Contains(string(data), "Edited") {
+		t.Errorf("changed issue not updated in latest: %s (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "run/repositories/repo/issues/2.json")); err != nil {
+		t.Errorf("changed issue missing from run directory: %v", err)
+	}
+}
+
+func TestUnchangedInLatest_Missing(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	if b.unchangedInLatest("ws/latest", "missing.json", &api.Issue{ID: 1}) {
+		t.Error("missing file must count as changed")
+	}
+}
@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// Files of a repository's Downloads section, such as release artifacts,
+// are saved with backup.include_downloads. They are not in the git
+// history, so this is the only copy a backup has of them.
+const (
+	DownloadsDirName       = "downloads"      // The files, next to repository.json in latest/
+	DownloadsIndexFileName = "downloads.json" // Describes the files, in the run's and latest/ directories
+)
+
+// Why a file in the Downloads section was not saved, in downloads.json.
+const (
+	DownloadSkippedFiltered = "filtered"  // Excluded by downloads_include or downloads_exclude
+	DownloadSkippedTooLarge = "too_large" // Over downloads_max_size
+	DownloadSkippedFailed   = "failed"    // The download failed; the next run tries again
+)
+
+// DownloadEntry is a file in a repository's Downloads section as listed in
+// downloads.json.
+type DownloadEntry struct {
+	api.RepoDownload
+	File    string `json:"file,omitempty"`    // Saved copy, relative to the repository's directory
+	Skipped string `json:"skipped,omitempty"` // Why it was not saved
+}
+
+// downloadFileName names the local copy of a file in the Downloads
+// section.
+func downloadFileName(name string) string {
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	if strings.Trim(name, ".") == "" {
+		name = "_" + name
+	}
+	return name
+}
+
+// wantDownload reports whether a file name passes backup.downloads_include
+// and backup.downloads_exclude.
+func (b *Backup) wantDownload(name string) bool {
+	for _, pattern := range b.cfg.Backup.DownloadsExclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if len(b.cfg.Backup.DownloadsInclude) == 0 {
+		return true
+	}
+	for _, pattern := range b.cfg.Backup.DownloadsInclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// backupDownloads saves the files in a repository's Downloads section to
+// downloads/ in latest/, streaming each to disk and from there to storage,
+// and writes downloads.json to the run's and latest/ directories. A file
+// already saved with the listed size is kept rather than downloaded again,
+// and files removed from Bitbucket stay in downloads/. A failed file is
+// logged and noted in downloads.json; the partial file is resumed by the
+// next run. It returns how many files the repository's downloads/ holds
+// from the listing.
+func (b *Backup) backupDownloads(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) (int, error) {
+	prefix := api.LogPrefix(ctx)
+	listed, err := b.provider.ListDownloads(ctx, b.cfg.Workspace, repo.Slug)
+	if err != nil {
+		return 0, err
+	}
+	maxSize := b.cfg.Backup.DownloadsMaxBytes()
+
+	entries := make([]DownloadEntry, 0, len(listed))
+	saved := 0
+	for _, d := range listed {
+		entry := DownloadEntry{RepoDownload: d}
+		switch {
+		case !b.wantDownload(d.Name):
+			entry.Skipped = DownloadSkippedFiltered
+		case maxSize > 0 && d.Size > maxSize:
+			entry.Skipped = DownloadSkippedTooLarge
+		default:
+			rel := DownloadsDirName + "/" + downloadFileName(d.Name)
+			dest := filepath.Join(b.storage.BasePath(), latestRepoDir, rel)
+			if info, err := os.Stat(dest); err != nil || info.Size() != d.Size {
+				if _, err := b.client.DownloadStream(ctx, d.Links.Self.Href, dest, maxSize); err != nil {
+					if isContextCanceled(err) {
+						return saved, err
+					}
+					entry.Skipped = DownloadSkippedFailed
+					if errors.Is(err, api.ErrTooLarge) {
+						entry.Skipped = DownloadSkippedTooLarge
+					} else if !b.shuttingDown.Load() {
+						b.log.Error("%sFailed to download %s from the Downloads of %s: %v", prefix, d.Name, repo.Slug, err)
+					}
+					break
+				}
+				// The file was written under storage.path; with s3 storage
+				// this copies it to the bucket. One that did not get there is
+				// removed so the next run downloads it again.
+				if err := storage.WriteFile(b.storage, filepath.Join(latestRepoDir, rel), dest); err != nil {
+					_ = os.Remove(dest)
+					entry.Skipped = DownloadSkippedFailed
+					b.log.Error("%sFailed to store %s from the Downloads of %s: %v", prefix, d.Name, repo.Slug, err)
+					break
+				}
+			}
+			entry.File = rel
+			saved++
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, dir := range []string{repoDir, latestRepoDir} {
+		if err := b.saveEntity(dir, DownloadsIndexFileName, entries); err != nil {
+			return saved, fmt.Errorf("saving %s: %w", DownloadsIndexFileName, err)
+		}
+	}
+	if len(listed) > 0 {
+		b.log.Debug("%sSaved %d of %d downloads for %s", prefix, saved, len(listed), repo.Slug)
+	}
+	return saved, nil
+}
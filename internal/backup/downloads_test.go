@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+func TestBackupDownloads(t *testing.T) {
+	var fetches atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repositories/ws/api/downloads" {
+			file := func(name string, size int) string {
+				return `{"name":"` + name + `","size":` + strconv.Itoa(size) + `,"links":{"self":{"href":"` + server.URL + `/files/` + name + `"}}}`
+			}
+			_, _ = w.Write([]byte(`{"values":[` + file("app.zip", 5) + `,` + file("huge.zip", 9) + `,` +
+				file("notes.txt", 4) + `,` + file("gone.zip", 1) + `]}`))
+			return
+		}
+		fetches.Add(1)
+		if strings.HasSuffix(r.URL.Path, "/gone.zip") {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	b := newRunTestBackup(t, "")
+	b.cfg = config.Default()
+	b.cfg.Workspace = "ws"
+	b.cfg.Backup.DownloadsMaxSize = "8B"
+	b.cfg.Backup.DownloadsInclude = []string{"*.zip"}
+	b.cfg.RateLimit.RequestsPerHour = 36000
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+
+	repo := &api.Repository{Slug: "api"}
+	runDir, latestDir := "run/repositories/api", "latest/repositories/api"
+	saved, err := b.backupDownloads(context.Background(), runDir, latestDir, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != 1 {
+		t.Errorf("saved = %d, want 1", saved)
+	}
+	if got, _ := os.ReadFile(filepath.Join(b.storage.BasePath(), latestDir, "downloads", "app.zip")); string(got) != "hello" {
+		t.Errorf("app.zip = %q", got)
+	}
+
+	data, err := os.ReadFile(filepath.Join(b.storage.BasePath(), runDir, DownloadsIndexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []DownloadEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app.zip": "", "huge.zip": DownloadSkippedTooLarge, "notes.txt": DownloadSkippedFiltered, "gone.zip": DownloadSkippedFailed}
+	for _, e := range entries {
+		if e.Skipped != want[e.Name] {
+			t.Errorf("%s skipped = %q, want %q", e.Name, e.Skipped, want[e.Name])
+		}
+	}
+	if entries[0].File != "downloads/app.zip" {
+		t.Errorf("app.zip file = %q", entries[0].File)
+	}
+
+	// A file already saved with the listed size is not fetched again
+	fetches.Store(0)
+	if _, err := b.backupDownloads(context.Background(), runDir, latestDir, repo); err != nil {
+		t.Fatal(err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetches on the second run = %d, want 1 (the failed file)", n)
+	}
+}
+
+func TestDownloadFileName(t *testing.T) {
+	tests := map[string]string{
+		"app-1.2.0.tar.gz": "app-1.2.0.tar.gz",
+		"my release.zip":   "my_release.zip",
+		"../etc/passwd":    ".._etc_passwd",
+		"..":               "_..",
+	}
+	for in, want := range tests {
+		if got := downloadFileName(in); got != want {
+			t.Errorf("downloadFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBackupDownloads_Replicated(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repositories/ws/api/downloads" {
+			_, _ = w.Write([]byte(`{"values":[{"name":"app.zip","size":5,"links":{"self":{"href":"` + server.URL + `/files/app.zip"}}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	b := newRunTestBackup(t, "")
+	b.cfg = config.Default()
+	b.cfg.Workspace = "ws"
+	b.cfg.RateLimit.RequestsPerHour = 36000
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+	replica := newLocalStore(t, t.TempDir())
+	b.storage = storage.NewReplicated(b.storage, replica)
+
+	latestDir := "latest/repositories/api"
+	if _, err := b.backupDownloads(context.Background(), "run/repositories/api", latestDir, &api.Repository{Slug: "api"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := replica.Read(latestDir + "/downloads/app.zip")
+	if err != nil || string(got) != "hello" {
+		t.Errorf("replica app.zip = %q (%v), want hello", got, err)
+	}
+}
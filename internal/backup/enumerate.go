@@ -0,0 +1,146 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// ListRepositories fetches the repositories a backup considers, before the
+// include and exclude patterns are applied. With backup.include_projects set
+// each project is listed with a project-scoped query instead of paging
+// through every repository in the workspace; personal repositories are then
+// left out. Include patterns that translate to a query (see
+// RepoFilter.SlugQuery) narrow the listing further. With credential sets
+// configured, each set lists the workspace too and contributes the
+// repositories mapped to it that the main credentials cannot see. Those in
+// repo_tokens that are still missing are fetched one by one with their
+// tokens, which cannot list the workspace.
+func ListRepositories(ctx context.Context, provider api.Provider, cfg *config.Config, filter *RepoFilter) ([]api.Repository, error) {
+	repos, err := listRepositories(ctx, provider, cfg, filter)
+	if err != nil || (len(cfg.Credentials) == 0 && len(cfg.RepoTokens) == 0) {
+		return repos, err
+	}
+
+	seen := make(map[string]bool, len(repos))
+	for _, repo := range repos {
+		seen[repo.Slug] = true
+	}
+	for _, set := range cfg.Credentials {
+		setRepos, err := listRepositories(auth.WithSet(ctx, set.Name), provider, cfg, filter)
+		if err != nil {
+			return nil, fmt.Errorf("listing repositories with credentials %s: %w", set.Name, err)
+		}
+		for _, repo := range setRepos {
+			if seen[repo.Slug] || cfg.CredentialSetFor(repoProjectKey(&repo), repo.Slug) != set.Name {
+				continue
+			}
+			seen[repo.Slug] = true
+			repos = append(repos, repo)
+		}
+	}
+	for _, name := range cfg.CredentialSetNames() {
+		slug, ok := cfg.RepoTokenSlug(name)
+		if !ok || seen[slug] || !filter.ShouldInclude(slug) {
+			continue
+		}
+		repo, err := provider.GetRepository(auth.WithSet(ctx, name), cfg.Workspace, slug)
+		if err != nil {
+			return nil, fmt.Errorf("fetching repository %s with its repo_tokens token: %w", slug, err)
+		}
+		if !inIncludedProjects(repo, cfg.Backup.IncludeProjects) {
+			continue
+		}
+		seen[slug] = true
+		repos = append(repos, *repo)
+	}
+	return repos, nil
+}
+
+func listRepositories(ctx context.Context, provider api.Provider, cfg *config.Config, filter *RepoFilter) ([]api.Repository, error) {
+	slugQuery := filter.SlugQuery()
+	keys := projectKeys(cfg.Backup.IncludeProjects)
+	if len(keys) == 0 {
+		if slugQuery == "" {
+			return provider.GetRepositories(ctx, cfg.Workspace)
+		}
+		return provider.QueryRepositories(ctx, cfg.Workspace, slugQuery)
+	}
+
+	var repos []api.Repository
+	for _, key := range keys {
+		query := `project.key="` + key + `"`
+		if slugQuery != "" {
+			query += " AND " + slugQuery
+		}
+		projectRepos, err := provider.QueryRepositories(ctx, cfg.Workspace, query)
+		if err != nil {
+			return nil, fmt.Errorf("fetching repositories for project %s: %w", key, err)
+		}
+		repos = append(repos, projectRepos...)
+	}
+	return repos, nil
+}
+
+// FilterProjects returns the projects named in backup.include_projects, or
+// all of them when it is empty, along with any configured keys that match
+// no project. Keys are compared case-insensitively, as Bitbucket does.
+func FilterProjects(projects []api.Project, include []string) ([]api.Project, []string) {
+	keys := projectKeys(include)
+	if len(keys) == 0 {
+		return projects, nil
+	}
+
+	var kept []api.Project
+	found := make(map[string]bool)
+	for _, p := range projects {
+		for _, key := range keys {
+			if strings.EqualFold(p.Key, key) {
+				kept = append(kept, p)
+				found[key] = true
+				break
+			}
+		}
+	}
+	var missing []string
+	for _, key := range keys {
+		if !found[key] {
+			missing = append(missing, key)
+		}
+	}
+	return kept, missing
+}
+
+// inIncludedProjects reports whether a repository belongs to one of the
+// included projects, or true when no projects are configured.
+func inIncludedProjects(repo *api.Repository, include []string) bool {
+	if len(include) == 0 {
+		return true
+	}
+	for _, key := range include {
+		if strings.EqualFold(repoProjectKey(repo), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectKeys returns the configured keys without duplicates, so a key
+// listed twice is not enumerated twice.
+func projectKeys(include []string) []string {
+	seen := make(map[string]bool, len(include))
+	var keys []string
+	for _, key := range include {
+		upper := strings.ToUpper(key)
+		if seen[upper] {
+			continue
+		}
+		seen[upper] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestListRepositories_IncludeProjects(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.Query().Get("q"))
+		mu.Unlock()
+		var values []api.Repository
+		switch r.URL.Query().Get("q") {
+		case `project.key="CORE"`:
+			values = []api.Repository{{Slug: "api", Project: &api.Project{Key: "CORE"}}}
+		case `project.key="WEB"`:
+			values = []api.Repository{{Slug: "site", Project: &api.Project{Key: "WEB"}}}
+		default:
+			values = []api.Repository{{Slug: "api"}, {Slug: "site"}, {Slug: "other"}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+	client := api.NewClient(cfg, api.WithBaseURL(server.URL))
+
+	repos, err := ListRepositories(context.Background(), client, cfg, NewRepoFilter(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 3 || queries[0] != "" {
+		t.Fatalf("without include_projects expected the workspace listing, got %s (q=%q)", slugs(repos), queries[0])
+	}
+
+	queries = nil
+	cfg.Backup.IncludeProjects = []string{"CORE", "WEB", "core"}
+	repos, err = ListRepositories(context.Background(), client, cfg, NewRepoFilter(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "api,site"; slugs(repos) != want {
+		t.Errorf("expected %s, got %s", want, slugs(repos))
+	}
+	if want := []string{`project.key="CORE"`, `project.key="WEB"`}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("expected one query per project, got %q", queries)
+	}
+}
+
+func TestFilterProjects(t *testing.T) {
+	projects := []api.Project{{Key: "CORE"}, {Key: "WEB"}, {Key: "OPS"}}
+
+	kept, missing := FilterProjects(projects, nil)
+	if len(kept) != 3 || missing != nil {
+		t.Errorf("no include list should keep everything, got %v, %v", kept, missing)
+	}
+
+	kept, missing = FilterProjects(projects, []string{"web", "GONE"})
+	if len(kept) != 1 || kept[0].Key != "WEB" {
+		t.Errorf("expected only WEB, got %v", kept)
+	}
+	if !reflect.DeepEqual(missing, []string{"GONE"}) {
+		t.Errorf("expected GONE to be reported missing, got %v", missing)
+	}
+
+	if !inIncludedProjects(&api.Repository{Project: &api.Project{Key: "CORE"}}, []string{"core"}) {
+		t.Error("repository in an included project was rejected")
+	}
+	if inIncludedProjects(&api.Repository{}, []string{"CORE"}) {
+		t.Error("personal repository should be outside include_projects")
+	}
+}
+
+func TestListRepositories_SlugQuery(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		values := []api.Repository{{Slug: "core-api", Project: &api.Project{Key: "CORE"}}}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+	client := api.NewClient(cfg, api.WithBaseURL(server.URL))
+	filter := NewRepoFilter([]string{"core-*"}, nil)
+
+	if _, err := ListRepositories(context.Background(), client, cfg, filter); err != nil {
+		t.Fatal(err)
+	}
+	if query != `slug ~ "core-"` {
+		t.Errorf("expected the include pattern pushed down, got q=%q", query)
+	}
+
+	cfg.Backup.IncludeProjects = []string{"CORE"}
+	if _, err := ListRepositories(context.Background(), client, cfg, filter); err != nil {
+		t.Fatal(err)
+	}
+	if query != `project.key="CORE" AND slug ~ "core-"` {
+		t.Errorf("expected project and slug terms combined, got q=%q", query)
+	}
+}
+
+func TestListRepositories_RepoTokens(t *testing.T) {
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repositories/ws":
+			json.NewEncoder(w).Encode(map[string]interface{}{"values": []api.Repository{{Slug: "api"}, {Slug: "site"}}})
+		case "/repositories/ws/site", "/repositories/ws/vault", "/repositories/ws/skipped":
+			fetched = append(fetched, r.URL.Path+" "+r.Header.Get("Authorization"))
+			json.NewEncoder(w).Encode(api.Repository{Slug: strings.TrimPrefix(r.URL.Path, "/repositories/ws/")})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+	cfg.RepoTokens = map[string]string{"site": "site-token", "vault": "vault-token", "skipped": "skipped-token"}
+	client := api.NewClient(cfg, api.WithBaseURL(server.URL))
+
+	repos, err := ListRepositories(context.Background(), client, cfg, NewRepoFilter(nil, []string{"skipped"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "api,site,vault"; slugs(repos) != want {
+		t.Errorf("expected %s, got %s", want, slugs(repos))
+	}
+	// Listed and excluded repositories are not fetched again
+	if want := []string{"/repositories/ws/vault Bearer vault-token"}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %q, want %q", fetched, want)
+	}
+}
+
+// listingProvider serves a fixed repository listing; the other endpoints
+// are left unimplemented.
+type listingProvider struct {
+	api.Provider
+	repos []api.Repository
+}
+
+func (p *listingProvider) GetRepositories(context.Context, string) ([]api.Repository, error) {
+	return p.repos, nil
+}
+
+func TestListRepositories_Provider(t *testing.T) {
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	provider := &listingProvider{repos: []api.Repository{{Slug: "api"}, {Slug: "site"}}}
+
+	repos, err := ListRepositories(context.Background(), provider, cfg, NewRepoFilter(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "api,site"; slugs(repos) != want {
+		t.Errorf("expected %s, got %s", want, slugs(repos))
+	}
+}
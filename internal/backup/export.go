@@ -0,0 +1,202 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// Mirror is a repository's git mirror in a workspace backup's latest/ tree.
+// Project is empty for personal repositories; Owner is set for personal
+// repositories stored under their owner.
+type Mirror struct {
+	Project string
+	Owner   string
+	Slug    string
+	Path    string
+}
+
+// RelPath returns the repository's directory relative to a backup root:
+// projects/<key>/<slug>, personal/<owner>/<slug>, or personal/<slug>.
+func (m Mirror) RelPath() string {
+	if m.Project == "" {
+		return filepath.Join(personalDirName, m.Owner, m.Slug)
+	}
+	return filepath.Join("projects", m.Project, m.Slug)
+}
+
+// FindMirrors lists the git mirrors under a workspace backup's latest/
+// directory, sorted by project and slug.
+func FindMirrors(workspaceDir string) ([]Mirror, error) {
+	latest, err := filepath.EvalSymlinks(filepath.Join(workspaceDir, LatestDirName))
+	if err != nil {
+		return nil, fmt.Errorf("locating latest backup: %w", err)
+	}
+
+	var mirrors []Mirror
+	collect := func(project, reposDir string) error {
+		entries, err := os.ReadDir(reposDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, e := range entries {
+			gitPath := filepath.Join(reposDir, e.Name(), "repo.git")
+			if _, err := os.Stat(gitPath); e.IsDir() && err == nil {
+				mirrors = append(mirrors, Mirror{Project: project, Slug: e.Name(), Path: gitPath})
+			}
+		}
+		return nil
+	}
+
+	projects, err := os.ReadDir(filepath.Join(latest, "projects"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading projects: %w", err)
+	}
+	for _, p := range projects {
+		if !p.IsDir() {
+			continue
+		}
+		if err := collect(p.Name(), filepath.Join(latest, "projects", p.Name(), "repositories")); err != nil {
+			return nil, fmt.Errorf("reading project %s: %w", p.Name(), err)
+		}
+	}
+	personal, err := ListPersonalRepos(latest)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range personal {
+		gitPath := filepath.Join(latest, r.Rel, "repo.git")
+		if _, err := os.Stat(gitPath); err == nil {
+			mirrors = append(mirrors, Mirror{Owner: r.Owner, Slug: r.Slug, Path: gitPath})
+		}
+	}
+
+	sort.Slice(mirrors, func(i, j int) bool {
+		if mirrors[i].Project != mirrors[j].Project {
+			return mirrors[i].Project < mirrors[j].Project
+		}
+		if mirrors[i].Owner != mirrors[j].Owner {
+			return mirrors[i].Owner < mirrors[j].Owner
+		}
+		return mirrors[i].Slug < mirrors[j].Slug
+	})
+	return mirrors, nil
+}
+
+// WorktreeExportOptions selects what ExportWorktrees writes and where.
+// Exactly one of OutputDir and Tarball is set.
+type WorktreeExportOptions struct {
+	Ref       string   // Branch, tag, or commit; empty for each default branch
+	Repos     []string // Slug glob patterns; empty exports every repository
+	OutputDir string   // Directory to check trees out into
+	Tarball   string   // Tar file to write instead; gzipped if it ends in .gz or .tgz
+}
+
+// WorktreeExport is the outcome for one repository.
+type WorktreeExport struct {
+	Project string `json:"project,omitempty"`
+	Slug    string `json:"slug"`
+	Path    string `json:"path"`
+	Commit  string `json:"commit,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ExportWorktrees checks out a plain source tree of each selected mirror,
+// laid out as projects/<key>/<slug> and personal/<owner>/<slug>, for consumers that
+// need files rather than bare repositories. A repository without the ref
+// is reported and skipped; the error is for problems with the whole export.
+func ExportWorktrees(workspaceDir string, opts WorktreeExportOptions) ([]WorktreeExport, error) {
+	if (opts.OutputDir == "") == (opts.Tarball == "") {
+		return nil, fmt.Errorf("exactly one of an output directory or a tarball is required")
+	}
+	mirrors, err := FindMirrors(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	filter := NewRepoFilter(opts.Repos, nil)
+	selected := mirrors[:0]
+	for _, m := range mirrors {
+		if filter.ShouldInclude(m.Slug) {
+			selected = append(selected, m)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no repositories with a git mirror match")
+	}
+
+	if opts.OutputDir != "" {
+		results := make([]WorktreeExport, 0, len(selected))
+		for _, m := range selected {
+			dest := filepath.Join(opts.OutputDir, m.RelPath())
+			if _, err := os.Stat(dest); err == nil {
+				return results, fmt.Errorf("%s already exists; export into an empty directory", dest)
+			}
+			commit, err := git.ExportWorktree(m.Path, opts.Ref, dest)
+			results = append(results, worktreeResult(m, dest, commit, err))
+		}
+		return results, nil
+	}
+	return archiveWorktrees(selected, opts)
+}
+
+// archiveWorktrees writes the selected trees into a single tar file.
+func archiveWorktrees(mirrors []Mirror, opts WorktreeExportOptions) (results []WorktreeExport, err error) {
+	f, err := os.Create(opts.Tarball)
+	if err != nil {
+		return nil, fmt.Errorf("creating tarball: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("closing tarball: %w", cerr)
+		}
+	}()
+
+	var w io.Writer = f
+	if strings.HasSuffix(opts.Tarball, ".gz") || strings.HasSuffix(opts.Tarball, ".tgz") {
+		gz := gzip.NewWriter(f)
+		defer func() {
+			if cerr := gz.Close(); err == nil && cerr != nil {
+				err = fmt.Errorf("compressing tarball: %w", cerr)
+			}
+		}()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer func() {
+		if cerr := tw.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("writing tarball: %w", cerr)
+		}
+	}()
+
+	for _, m := range mirrors {
+		prefix := filepath.ToSlash(m.RelPath())
+		commit, err := git.ArchiveWorktree(m.Path, opts.Ref, prefix, tw)
+		if err != nil && !errors.Is(err, git.ErrRefNotFound) {
+			// The failure may leave a partial entry in the stream, so the
+			// archive can't be trusted past this point
+			return results, fmt.Errorf("archiving %s: %w", m.Slug, err)
+		}
+		results = append(results, worktreeResult(m, prefix, commit, err))
+	}
+	return results, nil
+}
+
+// worktreeResult builds the export outcome for a mirror.
+func worktreeResult(m Mirror, path, commit string, err error) WorktreeExport {
+	r := WorktreeExport{Project: m.Project, Slug: m.Slug, Path: path, Commit: commit}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindMirrors(t *testing.T) {
+	ws := t.TempDir()
+	latest := filepath.Join(ws, LatestDirName)
+	for _, dir := range []string{
+		"projects/CORE/repositories/web/repo.git",
+		"projects/CORE/repositories/api/repo.git",
+		"projects/CORE/repositories/metadata-only", // no mirror
+		"personal/repositories/tool/repo.git",
+		"personal/alice/repositories/tool/repo.git",
+	} {
+		if err := os.MkdirAll(filepath.Join(latest, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mirrors, err := FindMirrors(ws)
+	if err != nil {
+		t.Fatalf("FindMirrors() error = %v", err)
+	}
+	var got []string
+	for _, m := range mirrors {
+		got = append(got, m.RelPath())
+	}
+	want := []string{"personal/tool", "personal/alice/tool", "projects/CORE/api", "projects/CORE/web"}
+	if len(got) != len(want) {
+		t.Fatalf("mirrors = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != filepath.FromSlash(want[i]) {
+			t.Errorf("mirrors[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	if _, err := ExportWorktrees(ws, WorktreeExportOptions{}); err == nil {
+		t.Error("ExportWorktrees without a destination should fail")
+	}
+	if _, err := ExportWorktrees(ws, WorktreeExportOptions{OutputDir: t.TempDir(), Repos: []string{"nope-*"}}); err == nil {
+		t.Error("ExportWorktrees matching nothing should fail")
+	}
+}
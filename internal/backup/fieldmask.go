@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// Field masks (backup.field_masks) remove fields that are never read,
+// such as rendered HTML and links blocks, from saved pull requests, issues,
+// and their sub-resources. Each mask is a dot-separated path from the
+// entity's root: "*" matches any one key and "**" any number of keys,
+// and arrays are transparent, so "participants.user.links" drops the links
+// of every participant and "**.links" every links block.
+
+// fieldMasks holds the parsed masks of each entity kind.
+type fieldMasks map[string][][]string
+
+// newFieldMasks parses the configured masks, or returns nil if there are
+// none.
+func newFieldMasks(masks map[string][]string) fieldMasks {
+	var m fieldMasks
+	for kind, paths := range masks {
+		for _, p := range paths {
+			if m == nil {
+				m = make(fieldMasks)
+			}
+			m[kind] = append(m[kind], strings.Split(p, "."))
+		}
+	}
+	return m
+}
+
+// entityKind returns the field mask kind of a saved entity file from its
+// path, or "" for files masks don't apply to.
+func entityKind(dir, filename string) string {
+	parent := filepath.Base(dir)
+	grandparent := filepath.Base(filepath.Dir(dir))
+	switch {
+	case parent == "pull-requests" && isIDFile(filename):
+		return config.FieldMaskPullRequests
+	case parent == "issues" && isIDFile(filename):
+		return config.FieldMaskIssues
+	case grandparent != "pull-requests" && grandparent != "issues":
+		return ""
+	case filename == "comments.json":
+		return config.FieldMaskComments
+	case filename == "activity.json" && grandparent == "pull-requests":
+		return config.FieldMaskActivity
+	case filename == "tasks.json" && grandparent == "pull-requests":
+		return config.FieldMaskTasks
+	}
+	return ""
+}
+
+// isIDFile reports whether filename is <number>.json.
+func isIDFile(filename string) bool {
+	id, ok := strings.CutSuffix(filename, ".json")
+	if !ok || id == "" {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// applyFieldMasks returns an entity with the fields matching its kind's
+// masks removed, as generic JSON, or unchanged if none apply.
+func (b *Backup) applyFieldMasks(kind string, data interface{}) (interface{}, error) {
+	masks := b.fieldMasks[kind]
+	if len(masks) == 0 {
+		return data, nil
+	}
+	v, err := genericJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	maskValue(v, masks)
+	return v, nil
+}
+
+// maskValue removes the fields of v matched by masks, each the rest of a
+// path relative to v.
+func maskValue(v interface{}, masks [][]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		masks = expandAnyDepth(masks)
+		for key, value := range v {
+			var next [][]string
+			drop := false
+			for _, mask := range masks {
+				switch {
+				case mask[0] == "**":
+					// Stays active one level down
+					next = append(next, mask)
+				case mask[0] == "*" || mask[0] == key:
+					if len(mask) == 1 {
+						drop = true
+					} else {
+						next = append(next, mask[1:])
+					}
+				}
+			}
+			if drop {
+				delete(v, key)
+			} else if len(next) > 0 {
+				maskValue(value, next)
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			maskValue(elem, masks)
+		}
+	}
+}
+
+// expandAnyDepth adds, for each mask starting with "**", the rest of the
+// mask, so "**" can also match no keys at all.
+func expandAnyDepth(masks [][]string) [][]string {
+	out := masks
+	for _, mask := range masks {
+		for len(mask) > 1 && mask[0] == "**" {
+			mask = mask[1:]
+			// Copy first: masks may be shared with other workers
+			out = append(out[:len(out):len(out)], mask)
+		}
+	}
+	return out
+}
@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestEntityKind(t *testing.T) {
+	tests := []struct {
+		dir, file, want string
+	}{
+		{"run/projects/CORE/repositories/api/pull-requests", "12.json", config.FieldMaskPullRequests},
+		{"run/projects/CORE/repositories/api/pull-requests/12", "comments.json", config.FieldMaskComments},
+		{"run/projects/CORE/repositories/api/pull-requests/12", "activity.json", config.FieldMaskActivity},
+		{"run/projects/CORE/repositories/api/pull-requests/12", "tasks.json", config.FieldMaskTasks},
+		{"run/projects/CORE/repositories/api/issues", "3.json", config.FieldMaskIssues},
+		{"run/projects/CORE/repositories/api/issues/3", "comments.json", config.FieldMaskComments},
+		{"run/projects/CORE/repositories/api/issues/3", "activity.json", ""},
+		{"run/projects/CORE/repositories/api", "repository.json", ""},
+		{"run/projects/CORE/repositories/api/pull-requests", "index.json", ""},
+	}
+	for _, tt := range tests {
+		if got := entityKind(tt.dir, tt.file); got != tt.want {
+			t.Errorf("entityKind(%s, %s) = %q, want %q", filepath.Base(tt.dir), tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestMaskValue(t *testing.T) {
+	input := `{
+  "id": 12,
+  "links": {"self": {"href": "x"}},
+  "summary": {"raw": "See #1", "markup": "markdown", "html": "p See #1"},
+  "author": {"display_name": "Ada", "links": {"avatar": {"href": "y"}}},
+  "participants": [{"user": {"display_name": "Ada", "links": {}}, "role": "REVIEWER"}],
+  "rendered": {"title": {"html": "a"}, "description": {"html": "b", "raw": "c"}}
+}`
+	tests := []struct {
+		name  string
+		masks []string
+		want  string
+	}{
+		{"exact path", []string{"summary.html"},
+			`{"author":{"display_name":"Ada","links":{"avatar":{"href":"y"}}},"id":12,"links":{"self":{"href":"x"}},"participants":[{"role":"REVIEWER","user":{"display_name":"Ada","links":{}}}],"rendered":{"description":{"html":"b","raw":"c"},"title":{"html":"a"}},"summary":{"markup":"markdown","raw":"See #1"}}`},
+		{"through arrays", []string{"participants.user.links", "rendered"},
+			`{"author":{"display_name":"Ada","links":{"avatar":{"href":"y"}}},"id":12,"links":{"self":{"href":"x"}},"participants":[{"role":"REVIEWER","user":{"display_name":"Ada"}}],"summary":{"html":"p See #1","markup":"markdown","raw":"See #1"}}`},
+		{"one level wildcard", []string{"rendered.*.html"},
+			`{"author":{"display_name":"Ada","links":{"avatar":{"href":"y"}}},"id":12,"links":{"self":{"href":"x"}},"participants":[{"role":"REVIEWER","user":{"display_name":"Ada","links":{}}}],"rendered":{"description":{"raw":"c"},"title":{}},"summary":{"html":"p See #1","markup":"markdown","raw":"See #1"}}`},
+		{"any depth", []string{"**.links", "**.html"},
+			`{"author":{"display_name":"Ada"},"id":12,"participants":[{"role":"REVIEWER","user":{"display_name":"Ada"}}],"rendered":{"description":{"raw":"c"},"title":{}},"summary":{"markup":"markdown","raw":"See #1"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masks := newFieldMasks(map[string][]string{config.FieldMaskPullRequests: tt.masks})
+			var v interface{}
+			if err := json.Unmarshal([]byte(input), &v); err != nil {
+				t.Fatal(err)
+			}
+			maskValue(v, masks[config.FieldMaskPullRequests])
+			got, _ := json.Marshal(v)
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveEntity_FieldMasks(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.fieldMasks = newFieldMasks(map[string][]string{
+		config.FieldMaskPullRequests: {"summary.html", "links"},
+	})
+
+	pr := json.RawMessage(`{"id": 12, "links": {"self": {"href": "x"}}, "summary": {"raw": "r", "html": "<p>r</p>"}}`)
+	dir := "ws/run/projects/CORE/repositories/api/pull-requests"
+	if err := b.saveEntity(dir, "12.json", pr); err != nil {
+		t.Fatal(err)
+	}
+	// Other entities are saved as they are
+	if err := b.saveEntity("ws/run/projects/CORE/repositories/api", "repository.json", pr); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(b.storage.BasePath(), dir, "12.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "links") || strings.Contains(string(saved), "html") || !strings.Contains(string(saved), `"raw"`) {
+		t.Errorf("masked PR = %s", saved)
+	}
+	repo, err := os.ReadFile(filepath.Join(b.storage.BasePath(), "ws/run/projects/CORE/repositories/api/repository.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(repo), "links") {
+		t.Errorf("repository.json was masked: %s", repo)
+	}
+}
+
+func TestUnchangedInLatest_FieldMasks(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.fieldMasks = newFieldMasks(map[string][]string{
+		config.FieldMaskPullRequests: {"summary.html", "links"},
+	})
+
+	pr := json.RawMessage(`{"id": 12, "links": {"self": {"href": "x"}}, "summary": {"raw": "r", "html": "<p>r</p>"}}`)
+	dir := "ws/latest/projects/CORE/repositories/api/pull-requests"
+	if err := b.saveEntity(dir, "12.json", pr); err != nil {
+		t.Fatal(err)
+	}
+	// Incremental runs compare against the masked copy
+	if !b.unchangedInLatest(dir, "12.json", pr) {
+		t.Error("an unchanged pull request should match its masked copy")
+	}
+	edited := json.RawMessage(`{"id": 12, "links": {"self": {"href": "x"}}, "summary": {"raw": "edited", "html": "<p>edited</p>"}}`)
+	if b.unchangedInLatest(dir, "12.json", edited) {
+		t.Error("an edited pull request should not match")
+	}
+}
@@ -1,9 +1,15 @@
 package backup
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
 )
 
 // LogFunc is called to log debug messages.
@@ -114,3 +120,103 @@ func (f *RepoFilter) SingleRepoSlug() string {
 
 	return pattern
 }
+
+// SlugQuery returns a Bitbucket query expression that narrows repository
+// enumeration to the include patterns, or "" when they can't all be
+// expressed. Exact slugs become `slug = "x"` and prefix globs such as
+// "core-*" become `slug ~ "core-"`; any other pattern (a leading or inner
+// wildcard, ?, or a character class) disables the query so no matching
+// repository is missed. The query only narrows the listing; Filter is still
+// applied to the result, and exclude patterns are always applied locally.
+func (f *RepoFilter) SlugQuery() string {
+	if len(f.includePatterns) == 0 {
+		return ""
+	}
+	terms := make([]string, 0, len(f.includePatterns))
+	for _, pattern := range f.includePatterns {
+		literal, prefix := strings.CutSuffix(pattern, "*")
+		if literal == "" || strings.ContainsAny(literal, "*?[\\\"") {
+			return ""
+		}
+		if prefix {
+			terms = append(terms, `slug ~ "`+literal+`"`)
+		} else {
+			terms = append(terms, `slug = "`+literal+`"`)
+		}
+	}
+	if len(terms) == 1 {
+		return terms[0]
+	}
+	return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+// LoadPatternFile reads repository slugs or glob patterns from a file, one
+// per line. Blank lines and # comments (whole-line or trailing) are ignored.
+func LoadPatternFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening pattern file: %w", err)
+	}
+	defer f.Close() //nolint:errcheck // read-only file
+
+	return ReadPatterns(f, path)
+}
+
+// ReadPatterns reads repository slugs or glob patterns, one per line, as
+// LoadPatternFile does. name identifies the source in errors.
+func ReadPatterns(r io.Reader, name string) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q: %w", name, lineNum, line, err)
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	return patterns, nil
+}
+
+// MissingRepos returns the exact slugs among patterns that match none of
+// repos, in order. Glob patterns are never reported.
+func MissingRepos(patterns []string, repos []api.Repository) []string {
+	found := make(map[string]bool, len(repos))
+	for _, r := range repos {
+		found[r.Slug] = true
+	}
+	var missing []string
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, "*?[\\") && !found[p] {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// IncludePatterns returns the inline include_repos patterns together with
+// those read from include_repos_file. The file is read on every call so an
+// externally maintained allow list is picked up at the start of each run.
+func IncludePatterns(cfg *config.Config) ([]string, error) {
+	if cfg.Backup.IncludeReposFile == "" {
+		return cfg.Backup.IncludeRepos, nil
+	}
+	filePatterns, err := LoadPatternFile(cfg.Backup.IncludeReposFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading include_repos_file: %w", err)
+	}
+	patterns := make([]string, 0, len(cfg.Backup.IncludeRepos)+len(filePatterns))
+	patterns = append(patterns, cfg.Backup.IncludeRepos...)
+	return append(patterns, filePatterns...), nil
+}
@@ -1,9 +1,13 @@
 package backup
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
 )
 
 func TestRepoFilter_NoPatterns(t *testing.T) {
@@ -162,3 +166,124 @@ func TestRepoFilter_FilteredCount(t *testing.T) {
 		t.Errorf("expected 3 excluded, got %d", excluded)
 	}
 }
+
+func TestLoadPatternFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repos.txt")
+	content := `# Maintained by the platform team
+core-api
+  platform-*   # all platform repos
+
+legacy-?
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	patterns, err := LoadPatternFile(path)
+	if err != nil {
+		t.Fatalf("LoadPatternFile() error = %v", err)
+	}
+	want := []string{"core-api", "platform-*", "legacy-?"}
+	if len(patterns) != len(want) {
+		t.Fatalf("LoadPatternFile() = %v, want %v", patterns, want)
+	}
+	for i := range want {
+		if patterns[i] != want[i] {
+			t.Errorf("pattern %d = %q, want %q", i, patterns[i], want[i])
+		}
+	}
+}
+
+func TestReadPatterns(t *testing.T) {
+	patterns, err := ReadPatterns(strings.NewReader("core-api\r\n\nweb-*\n"), "stdin")
+	if err != nil {
+		t.Fatalf("ReadPatterns() error = %v", err)
+	}
+	if strings.Join(patterns, ",") != "core-api,web-*" {
+		t.Errorf("ReadPatterns() = %v", patterns)
+	}
+
+	_, err = ReadPatterns(strings.NewReader("bad-["), "stdin")
+	if err == nil || !strings.Contains(err.Error(), "stdin:1:") {
+		t.Errorf("expected error naming stdin:1, got %v", err)
+	}
+}
+
+func TestMissingRepos(t *testing.T) {
+	repos := []api.Repository{{Slug: "core-api"}, {Slug: "web-app"}}
+	missing := MissingRepos([]string{"core-api", "retired", "web-*", "legacy-?"}, repos)
+	if len(missing) != 1 || missing[0] != "retired" {
+		t.Errorf("MissingRepos() = %v, want [retired]", missing)
+	}
+	if missing := MissingRepos(nil, repos); len(missing) != 0 {
+		t.Errorf("MissingRepos(nil) = %v", missing)
+	}
+}
+
+func TestLoadPatternFile_Errors(t *testing.T) {
+	if _, err := LoadPatternFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected error for missing file")
+	}
+
+	path := filepath.Join(t.TempDir(), "bad.txt")
+	if err := os.WriteFile(path, []byte("ok\nbad-[\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadPatternFile(path)
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("expected error naming line 2, got %v", err)
+	}
+}
+
+func TestIncludePatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repos.txt")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Backup: config.BackupConfig{
+		IncludeRepos:     []string{"inline"},
+		IncludeReposFile: path,
+	}}
+	patterns, err := IncludePatterns(cfg)
+	if err != nil {
+		t.Fatalf("IncludePatterns() error = %v", err)
+	}
+	if len(patterns) != 2 || patterns[0] != "inline" || patterns[1] != "from-file" {
+		t.Errorf("IncludePatterns() = %v", patterns)
+	}
+	if len(cfg.Backup.IncludeRepos) != 1 {
+		t.Error("IncludePatterns must not modify the config")
+	}
+
+	// The file is re-read on each call
+	if err := os.WriteFile(path, []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, _ = IncludePatterns(cfg)
+	if patterns[1] != "changed" {
+		t.Errorf("expected reloaded pattern, got %v", patterns)
+	}
+}
+
+func TestRepoFilter_SlugQuery(t *testing.T) {
+	tests := []struct {
+		include []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"core-*"}, `slug ~ "core-"`},
+		{[]string{"api"}, `slug = "api"`},
+		{[]string{"core-*", "api"}, `(slug ~ "core-" OR slug = "api")`},
+		{[]string{"core-*", "*-api"}, ""},
+		{[]string{"core-?"}, ""},
+		{[]string{"[ab]*"}, ""},
+		{[]string{"*"}, ""},
+		{[]string{`we"ird*`}, ""},
+	}
+	for _, tt := range tests {
+		if got := NewRepoFilter(tt.include, []string{"test-*"}).SlugQuery(); got != tt.want {
+			t.Errorf("SlugQuery(%q) = %q, want %q", tt.include, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// With git.fork_alternates, a fork's mirror borrows objects from its
+// parent's mirror through git alternates instead of keeping its own copy
+// of the history they share. Only parents in the same listing are used.
+
+// indexForkParents records the listed repositories by UUID so forks can
+// find their parent's mirror.
+func (b *Backup) indexForkParents(repos []api.Repository) {
+	if !b.cfg.Git.ForkAlternates {
+		return
+	}
+	b.reposByUUID = make(map[string]*api.Repository, len(repos))
+	for i := range repos {
+		if repos[i].UUID != "" {
+			b.reposByUUID[repos[i].UUID] = &repos[i]
+		}
+	}
+}
+
+// forkParentPath returns the path of a fork's parent mirror, or "" when
+// the repository is not a fork of a listed repository with a mirror.
+// Alternates hold absolute paths, so the parent is always named through
+// latest/, even while backup.atomic_latest stages the run in latest.tmp:
+// the staging tree is renamed when it is published and removed by the
+// next publish. A parent that is only in the staging tree is used once it
+// has been published.
+func (b *Backup) forkParentPath(repo *api.Repository) string {
+	if repo.Parent == nil || repo.Parent.UUID == "" {
+		return ""
+	}
+	parent, ok := b.reposByUUID[repo.Parent.UUID]
+	if !ok || parent.UUID == repo.UUID {
+		return ""
+	}
+	path := filepath.Join(b.storage.BasePath(), b.cfg.Workspace, LatestDirName, b.latestRepoRel(parent), "repo.git")
+	if !isValidGitRepo(path) {
+		return ""
+	}
+	return path
+}
+
+// repointAlternates updates a fork mirror's alternates when its parent's
+// mirror has moved, such as to another project, so the fork's objects
+// stay readable before it is fetched.
+func (b *Backup) repointAlternates(ctx context.Context, forkPath string, repo *api.Repository) {
+	if b.opts.DryRun || !isValidGitRepo(forkPath) {
+		return
+	}
+	dirs, err := git.Alternates(forkPath)
+	if err != nil || len(dirs) == 0 {
+		return
+	}
+	parentPath := b.forkParentPath(repo)
+	if parentPath == "" {
+		return
+	}
+	objects, err := git.ObjectsDir(parentPath)
+	if err != nil || (len(dirs) == 1 && dirs[0] == objects) {
+		return
+	}
+	prefix := api.LogPrefix(ctx)
+	if err := git.SetAlternates(forkPath, objects); err != nil {
+		b.log.Error("%sFailed to update alternates of %s: %v", prefix, repo.Slug, err)
+		return
+	}
+	b.log.Info("%sPointed %s at its parent's moved mirror", prefix, repo.Slug)
+}
+
+// repointPublishedForks repoints the alternates of the listed forks after
+// a staged latest tree is published. Parents that moved during the run
+// only appear at their new path in latest/ once it is published.
+func (b *Backup) repointPublishedForks(ctx context.Context) {
+	if !b.cfg.Git.ForkAlternates {
+		return
+	}
+	for _, repo := range b.reposByUUID {
+		if repo.Parent == nil {
+			continue
+		}
+		b.repointAlternates(ctx, filepath.Join(b.storage.BasePath(), b.getLatestGitPath(repo)), repo)
+	}
+}
+
+// shareForkObjects makes a fork's mirror borrow its parent's objects the
+// first time both are backed up. Errors are logged only: the fork's
+// mirror is complete on its own until the repack succeeds.
+func (b *Backup) shareForkObjects(ctx context.Context, forkPath string, repo *api.Repository) {
+	if !b.cfg.Git.ForkAlternates || b.opts.DryRun || b.shellGitClient == nil || !isValidGitRepo(forkPath) {
+		return
+	}
+	if dirs, err := git.Alternates(forkPath); err != nil || len(dirs) > 0 {
+		return
+	}
+	parentPath := b.forkParentPath(repo)
+	if parentPath == "" {
+		return
+	}
+
+	prefix := api.LogPrefix(ctx)
+	before := git.DirSize(forkPath)
+	if err := b.shellGitClient.ShareObjects(ctx, forkPath, parentPath); err != nil {
+		if !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to share objects of fork %s with %s: %v", prefix, repo.Slug, repo.Parent.FullName, err)
+		}
+		return
+	}
+	b.log.Info("%sFork %s now shares objects with %s (%s saved)", prefix, repo.Slug, repo.Parent.FullName,
+		format.Bytes(before-git.DirSize(forkPath)))
+}
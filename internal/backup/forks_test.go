@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+func TestRepointAlternates(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.cfg.Git.ForkAlternates = true
+	repos := []api.Repository{
+		{UUID: "{p}", Slug: "api", Project: &api.Project{Key: "NEW"}},
+		{UUID: "{f}", Slug: "api-fork", Project: &api.Project{Key: "CORE"}, Parent: &api.Repository{UUID: "{p}"}},
+		{UUID: "{o}", Slug: "other", Project: &api.Project{Key: "CORE"}, Parent: &api.Repository{UUID: "{elsewhere}"}},
+	}
+	b.indexForkParents(repos)
+
+	mirror := func(repo *api.Repository) string {
+		path := filepath.Join(b.storage.BasePath(), b.getLatestGitPath(repo))
+		if err := os.MkdirAll(filepath.Join(path, "objects"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	parent, fork, other := mirror(&repos[0]), mirror(&repos[1]), mirror(&repos[2])
+
+	// The parent used to be in project OLD
+	for _, path := range []string{fork, other} {
+		if err := git.SetAlternates(path, "/old/projects/OLD/repositories/api/repo.git/objects"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.repointAlternates(context.Background(), fork, &repos[1])
+	b.repointAlternates(context.Background(), other, &repos[2])
+
+	want, _ := git.ObjectsDir(parent)
+	if dirs, _ := git.Alternates(fork); len(dirs) != 1 || dirs[0] != want {
+		t.Errorf("fork alternates = %v, want [%s]", dirs, want)
+	}
+	// A fork whose parent wasn't listed is left alone
+	if dirs, _ := git.Alternates(other); len(dirs) != 1 || dirs[0] != "/old/projects/OLD/repositories/api/repo.git/objects" {
+		t.Errorf("other alternates = %v, want them unchanged", dirs)
+	}
+}
+
+func TestShareForkObjects_AtomicLatest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	b := newRunTestBackup(t, "")
+	b.cfg.Git.ForkAlternates = true
+	b.cfg.Backup.AtomicLatest = true
+	b.shellGitClient = git.NewShellGitClient()
+	repos := []api.Repository{
+		{UUID: "{p}", Slug: "api", Project: &api.Project{Key: "CORE"}},
+		{UUID: "{f}", Slug: "api-fork", Project: &api.Project{Key: "CORE"}, Parent: &api.Repository{UUID: "{p}"}},
+	}
+	b.indexForkParents(repos)
+	parent, fork := &repos[0], &repos[1]
+
+	runGit := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	src := filepath.Join(t.TempDir(), "src")
+	runGit("init", "-q", "-b", "main", src)
+	for _, msg := range []string{"one", "two", "three"} {
+		writeTestFile(t, filepath.Join(src, msg+".txt"), strings.Repeat(msg, 1000))
+		runGit("-C", src, "add", ".")
+		runGit("-C", src, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "-m", msg)
+	}
+	staged := func(repo *api.Repository) string {
+		return filepath.Join(b.storage.BasePath(), b.getLatestGitPath(repo))
+	}
+	publish := func(runID string, during func()) {
+		t.Helper()
+		b.runID = runID
+		if err := b.stageLatest(); err != nil {
+			t.Fatal(err)
+		}
+		during()
+		if err := b.publishLatest(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// First run: both mirrors are new, so the parent isn't in latest/ yet
+	publish("run-1", func() {
+		runGit("clone", "-q", "--mirror", src, staged(parent))
+		runGit("clone", "-q", "--mirror", "--no-local", staged(parent), staged(fork))
+		b.shareForkObjects(context.Background(), staged(fork), fork)
+		if dirs, _ := git.Alternates(staged(fork)); len(dirs) != 0 {
+			t.Errorf("fork borrows from an unpublished parent: %v", dirs)
+		}
+	})
+
+	// Second run: the staged fork borrows from the parent through latest/
+	publish("run-2", func() {
+		b.shareForkObjects(context.Background(), staged(fork), fork)
+	})
+	latestFork := filepath.Join(b.storage.BasePath(), "ws", LatestDirName, b.latestRepoRel(fork), "repo.git")
+	want := filepath.Join(b.storage.BasePath(), "ws", LatestDirName, b.latestRepoRel(parent), "repo.git", "objects")
+	if dirs, _ := git.Alternates(latestFork); len(dirs) != 1 || dirs[0] != want {
+		t.Fatalf("fork alternates = %v, want [%s]", dirs, want)
+	}
+
+	// Third run: the generation the fork was repacked in is removed, and
+	// the fork's objects stay readable
+	publish("run-3", func() {})
+	if _, err := os.Stat(filepath.Join(b.storage.BasePath(), "ws", latestGenPrefix+"run-2")); !os.IsNotExist(err) {
+		t.Fatalf("run-2 generation still exists: %v", err)
+	}
+	if out, err := exec.Command("git", "-C", latestFork, "fsck", "--connectivity-only").CombinedOutput(); err != nil {
+		t.Errorf("git fsck of the published fork: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"sort"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/format"
+)
+
+// RepoHistoryLength is the number of runs kept per repository in the state
+// file.
+const RepoHistoryLength = 10
+
+// Thresholds for flagging a repository whose backup took much longer than
+// usual: enough history to trust the median, a large ratio, and an absolute
+// floor so quick repositories don't trip it on noise.
+const (
+	balloonMinRuns  = 3
+	balloonFactor   = 3.0
+	balloonMinDelta = time.Minute
+)
+
+// RepoRun records the duration and mirror size of one successful backup.
+type RepoRun struct {
+	At      string  `json:"at"`
+	Seconds float64 `json:"seconds"`
+	Bytes   int64   `json:"bytes,omitempty"`
+}
+
+// RecordRepoRun appends a run to a repository's history, keeping the last
+// RepoHistoryLength. The repository must already be in the state.
+func (s *State) RecordRepoRun(slug string, run RepoRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.Repositories[slug]
+	if !ok {
+		return
+	}
+	repo.History = append(repo.History, run)
+	if n := len(repo.History); n > RepoHistoryLength {
+		repo.History = append([]RepoRun(nil), repo.History[n-RepoHistoryLength:]...)
+	}
+	s.Repositories[slug] = repo
+}
+
+// ExpectedDuration returns the median duration of a repository's recorded
+// runs, or false if it has none.
+func (s *State) ExpectedDuration(slug string) (time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := s.Repositories[slug].History
+	if len(history) == 0 {
+		return 0, false
+	}
+	return medianDuration(history), true
+}
+
+// medianDuration returns the median of the runs' durations.
+func medianDuration(runs []RepoRun) time.Duration {
+	secs := make([]float64, len(runs))
+	for i, r := range runs {
+		secs[i] = r.Seconds
+	}
+	return time.Duration(median(secs) * float64(time.Second))
+}
+
+// median returns the median of values, which must not be empty.
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// ballooned reports whether a run took far longer than a repository's
+// history, which can mean a history rewrite or runaway artifacts.
+func ballooned(history []RepoRun, took time.Duration) (time.Duration, bool) {
+	if len(history) < balloonMinRuns {
+		return 0, false
+	}
+	usual := medianDuration(history)
+	if took-usual < balloonMinDelta || float64(took) < balloonFactor*float64(usual) {
+		return usual, false
+	}
+	return usual, true
+}
+
+// recordRepoRun stores a successful repository backup in its history and
+// flags it if it took far longer than usual. It must run after
+// State.UpdateRepository for the repository.
+func (b *Backup) recordRepoRun(result *repoResult) {
+	if b.opts.DryRun || result.stats.Duration <= 0 {
+		return
+	}
+	slug := result.repo.Slug
+	key := b.repoStateKey(result.repo)
+	prev, _ := b.state.GetRepoState(key)
+	if usual, slow := ballooned(prev.History, result.stats.Duration); slow {
+		result.stats.Ballooned = true
+		b.log.Info("Repository %s took %s, %.0fx its usual %s; check for a history rewrite or runaway artifacts",
+			slug, format.Duration(result.stats.Duration), float64(result.stats.Duration)/float64(usual), format.Duration(usual))
+	}
+	b.state.RecordRepoRun(key, RepoRun{
+		At:      time.Now().UTC().Format(time.RFC3339),
+		Seconds: result.stats.Duration.Seconds(),
+		Bytes:   result.stats.Bytes,
+	})
+}
+
+// scheduleLongestFirst orders repositories by their usual backup duration,
+// longest first, so a long repository does not start last and hold up the
+// end of the run. Repositories without history are assumed long (a first
+// clone is the slowest case). With backup.archived_repos "last", archived
+// repositories stay after active ones.
+func (b *Backup) scheduleLongestFirst(repos []api.Repository) {
+	expected := make(map[string]time.Duration, len(repos))
+	known := make(map[string]bool, len(repos))
+	for i := range repos {
+		r := &repos[i]
+		expected[r.Slug], known[r.Slug] = b.state.ExpectedDuration(b.repoStateKey(r))
+	}
+	archivedLast := b.cfg.Backup.ArchivedRepos == "last"
+	sort.SliceStable(repos, func(i, j int) bool {
+		a, c := repos[i], repos[j]
+		if archivedLast && a.IsArchived != c.IsArchived {
+			return !a.IsArchived
+		}
+		if known[a.Slug] != known[c.Slug] {
+			return !known[a.Slug]
+		}
+		return expected[a.Slug] > expected[c.Slug]
+	})
+}
+
+// expectedDurations returns the usual duration of each repository that has
+// history, for ETA estimates.
+func (b *Backup) expectedDurations(repos []api.Repository) map[string]time.Duration {
+	expected := make(map[string]time.Duration)
+	for i := range repos {
+		r := &repos[i]
+		if d, ok := b.state.ExpectedDuration(b.repoStateKey(r)); ok {
+			expected[r.Slug] = d
+		}
+	}
+	return expected
+}
+
+// RepoDurations summarizes a repository's recorded runs for
+// `bb-backup stats --durations`.
+type RepoDurations struct {
+	Slug          string  `json:"slug"`
+	Project       string  `json:"project,omitempty"`
+	Runs          int     `json:"runs"`
+	LastSeconds   float64 `json:"last_seconds"`
+	MedianSeconds float64 `json:"median_seconds"`
+	MaxSeconds    float64 `json:"max_seconds"`
+	LastBytes     int64   `json:"last_bytes,omitempty"`
+	LastRun       string  `json:"last_run"`
+	// Ballooned is set when the last run took far longer than the ones
+	// before it
+	Ballooned bool `json:"ballooned,omitempty"`
+}
+
+// DurationStats summarizes the history of every repository that has one,
+// longest median first.
+func (s *State) DurationStats() []RepoDurations {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats []RepoDurations
+	for slug, repo := range s.Repositories {
+		n := len(repo.History)
+		if n == 0 {
+			continue
+		}
+		last := repo.History[n-1]
+		d := RepoDurations{
+			Slug:          slug,
+			Project:       repo.ProjectKey,
+			Runs:          n,
+			LastSeconds:   last.Seconds,
+			MedianSeconds: medianDuration(repo.History).Seconds(),
+			LastBytes:     last.Bytes,
+			LastRun:       last.At,
+		}
+		for _, r := range repo.History {
+			d.MaxSeconds = max(d.MaxSeconds, r.Seconds)
+		}
+		_, d.Ballooned = ballooned(repo.History[:n-1], time.Duration(last.Seconds*float64(time.Second)))
+		stats = append(stats, d)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].MedianSeconds != stats[j].MedianSeconds {
+			return stats[i].MedianSeconds > stats[j].MedianSeconds
+		}
+		return stats[i].Slug < stats[j].Slug
+	})
+	return stats
+}
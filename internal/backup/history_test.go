@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func recordRuns(s *State, slug string, seconds ...float64) {
+	for _, sec := range seconds {
+		s.RecordRepoRun(slug, RepoRun{At: "2024-01-01T00:00:00Z", Seconds: sec})
+	}
+}
+
+func TestRecordRepoRun_KeepsLastN(t *testing.T) {
+	s := NewState("ws")
+	recordRuns(s, "missing", 1) // not in state: ignored
+	if _, ok := s.GetRepoState("missing"); ok {
+		t.Fatal("RecordRepoRun created a repository")
+	}
+
+	s.UpdateRepository("api", "{1}", "", "")
+	for i := 1; i <= RepoHistoryLength+3; i++ {
+		recordRuns(s, "api", float64(i))
+	}
+	history := s.Repositories["api"].History
+	if len(history) != RepoHistoryLength {
+		t.Fatalf("history length = %d, want %d", len(history), RepoHistoryLength)
+	}
+	if history[0].Seconds != 4 || history[len(history)-1].Seconds != float64(RepoHistoryLength+3) {
+		t.Errorf("history = %v, want the most recent runs", history)
+	}
+
+	// A later successful backup keeps the history
+	s.UpdateRepository("api", "{1}", "", "")
+	if len(s.Repositories["api"].History) != RepoHistoryLength {
+		t.Error("UpdateRepository dropped the history")
+	}
+}
+
+func TestExpectedDuration_Median(t *testing.T) {
+	s := NewState("ws")
+	s.UpdateRepository("api", "{1}", "", "")
+	if _, ok := s.ExpectedDuration("api"); ok {
+		t.Error("ExpectedDuration without history should report false")
+	}
+	recordRuns(s, "api", 10, 300, 20)
+	if d, _ := s.ExpectedDuration("api"); d != 20*time.Second {
+		t.Errorf("odd median = %s, want 20s", d)
+	}
+	recordRuns(s, "api", 40)
+	if d, _ := s.ExpectedDuration("api"); d != 30*time.Second {
+		t.Errorf("even median = %s, want 30s", d)
+	}
+}
+
+func TestBallooned(t *testing.T) {
+	history := []RepoRun{{Seconds: 60}, {Seconds: 50}, {Seconds: 70}}
+	tests := []struct {
+		name    string
+		history []RepoRun
+		took    time.Duration
+		want    bool
+	}{
+		{"too little history", history[:2], time.Hour, false},
+		{"usual", history, 70 * time.Second, false},
+		{"slower but under factor", history, 150 * time.Second, false},
+		{"ballooned", history, 10 * time.Minute, true},
+		{"fast repo under the absolute floor", []RepoRun{{Seconds: 1}, {Seconds: 1}, {Seconds: 1}}, 30 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := ballooned(tt.history, tt.took); got != tt.want {
+				t.Errorf("ballooned = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordRepoRun_FlagsBalloon(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	b.state.UpdateRepository("api", "{1}", "", "")
+	recordRuns(b.state, "api", 60, 60, 60)
+
+	result := repoResult{repo: &api.Repository{Slug: "api"}, stats: repoStats{Duration: 20 * time.Minute, Bytes: 1024}}
+	b.recordRepoRun(&result)
+	if !result.stats.Ballooned {
+		t.Error("20m after a 1m history should be flagged")
+	}
+	history := b.state.Repositories["api"].History
+	if last := history[len(history)-1]; last.Seconds != 1200 || last.Bytes != 1024 {
+		t.Errorf("recorded run = %+v", last)
+	}
+	if entry := result.repoReport(RepoStatusOK); !entry.Ballooned || entry.DurationSeconds != 1200 {
+		t.Errorf("report entry = %+v", entry)
+	}
+}
+
+func TestScheduleLongestFirst(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	for slug, sec := range map[string]float64{"small": 5, "big": 600, "mid": 60, "old": 900} {
+		b.state.UpdateRepository(slug, "{}", "", "")
+		recordRuns(b.state, slug, sec)
+	}
+	repos := []api.Repository{{Slug: "small"}, {Slug: "mid"}, {Slug: "new"}, {Slug: "old", IsArchived: true}, {Slug: "big"}}
+
+	b.cfg.Backup.ArchivedRepos = "last"
+	b.scheduleLongestFirst(repos)
+	if got := slugs(repos); got != "new,big,mid,small,old" {
+		t.Errorf("archived last: order = %s", got)
+	}
+
+	b.cfg.Backup.ArchivedRepos = "include"
+	b.scheduleLongestFirst(repos)
+	if got := slugs(repos); got != "new,old,big,mid,small" {
+		t.Errorf("include: order = %s", got)
+	}
+}
+
+func TestDurationStats(t *testing.T) {
+	s := NewState("ws")
+	s.UpdateRepository("api", "{1}", "CORE", "")
+	s.UpdateRepository("web", "{2}", "", "")
+	s.UpdateRepository("idle", "{3}", "", "")
+	recordRuns(s, "api", 60, 60, 60, 900)
+	recordRuns(s, "web", 100, 120)
+
+	stats := s.DurationStats()
+	if len(stats) != 2 {
+		t.Fatalf("got %d entries, want 2 (repos without history are omitted)", len(stats))
+	}
+	if stats[0].Slug != "web" || stats[1].Slug != "api" {
+		t.Errorf("order = %s, %s; want longest median first", stats[0].Slug, stats[1].Slug)
+	}
+	core := stats[1]
+	if core.Runs != 4 || core.LastSeconds != 900 || core.MaxSeconds != 900 || core.Project != "CORE" || !core.Ballooned {
+		t.Errorf("api = %+v", core)
+	}
+	if stats[0].Ballooned {
+		t.Error("web should not be flagged")
+	}
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// Files next to repository.json holding a repository's integrations, so a
+// restore can recreate them.
+const (
+	DeployKeysFileName = "deploy-keys.json"
+	WebhooksFileName   = "webhooks.json"
+)
+
+// redactedValue replaces secrets in saved webhooks.
+const redactedValue = "REDACTED"
+
+// secretParamPattern matches query parameters of webhook URLs whose values
+// are likely credentials, e.g. ?token= or &api_key=.
+var secretParamPattern = regexp.MustCompile(`(?i)token|secret|passw|key|sig|auth`)
+
+// saveIntegrations fetches a repository's deploy keys and webhooks and
+// writes them next to repository.json in the run's and latest/
+// directories. Webhook secrets and credentials in webhook URLs are
+// redacted first. Either list is skipped with a note when the credentials
+// may not read it (403; both need repository admin); other failures are
+// returned.
+func (b *Backup) saveIntegrations(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) error {
+	prefix := api.LogPrefix(ctx)
+
+	integrations := []struct {
+		file  string
+		what  string
+		fetch func() (interface{}, error)
+	}{
+		{DeployKeysFileName, "deploy keys", func() (interface{}, error) {
+			return b.client.GetDeployKeys(ctx, b.cfg.Workspace, repo.Slug)
+		}},
+		{WebhooksFileName, "webhooks", func() (interface{}, error) {
+			hooks, err := b.client.GetWebhooks(ctx, b.cfg.Workspace, repo.Slug)
+			return redactWebhooks(hooks), err
+		}},
+	}
+
+	for _, in := range integrations {
+		data, err := in.fetch()
+		if errors.Is(err, api.ErrPoliciesRestricted) {
+			b.log.Info("%sSkipping %s for %s: the credentials may not read them (403)", prefix, in.what, repo.Slug)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, dir := range []string{repoDir, latestRepoDir} {
+			if err := b.saveEntity(dir, in.file, data); err != nil {
+				return fmt.Errorf("saving %s: %w", in.file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// redactWebhooks blanks the secrets of webhooks, keeping secret_set so a
+// restore knows to supply a new one, and redacts credentials in their URLs.
+func redactWebhooks(hooks []api.Webhook) []api.Webhook {
+	for i := range hooks {
+		h := &hooks[i]
+		if h.Secret != "" {
+			h.SecretSet = true
+			h.Secret = ""
+		}
+		h.URL = redactURL(h.URL)
+	}
+	return hooks
+}
+
+// redactURL replaces the user info and the values of credential-like
+// query parameters in a URL. URLs that do not parse are returned as they
+// are.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	changed := false
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedValue)
+		} else {
+			u.User = url.User(redactedValue)
+		}
+		changed = true
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name, values := range query {
+			if !secretParamPattern.MatchString(name) {
+				continue
+			}
+			for i := range values {
+				values[i] = redactedValue
+			}
+			changed = true
+		}
+		if changed {
+			u.RawQuery = query.Encode()
+		}
+	}
+	if !changed {
+		return raw
+	}
+	return u.String()
+}
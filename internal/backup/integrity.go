@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// IntegrityFileName is the per-repository integrity summary written next to
+// repository.json in the run directory and latest/.
+const IntegrityFileName = "integrity.json"
+
+// saveIntegrity records the mirror's integrity summary, carrying forward
+// the previous run's ref fingerprint so verify can flag refs that vanished
+// between runs. Failures are logged and never fail the backup.
+func (b *Backup) saveIntegrity(ctx context.Context, repoDir, latestRepoDir, gitPath string) {
+	prefix := api.LogPrefix(ctx)
+
+	summary, err := git.ComputeIntegrity(gitPath)
+	if err != nil {
+		b.log.Error("%sFailed to compute integrity summary for %s: %v", prefix, gitPath, err)
+		return
+	}
+
+	if data, err := b.storage.Read(latestRepoDir + "/" + IntegrityFileName); err == nil {
+		var prev git.Integrity
+		if json.Unmarshal(data, &prev) == nil && prev.RefsSHA256 != "" {
+			summary.Previous = &git.IntegrityPrior{
+				GeneratedAt: prev.GeneratedAt,
+				RefCount:    prev.RefCount,
+				RefsSHA256:  prev.RefsSHA256,
+			}
+			if summary.RefCount < prev.RefCount {
+				b.log.Info("%s%d refs removed since the previous run (%d -> %d)", prefix, prev.RefCount-summary.RefCount, prev.RefCount, summary.RefCount)
+			}
+		}
+	}
+
+	for _, dir := range []string{repoDir, latestRepoDir} {
+		if err := b.saveJSON(dir, IntegrityFileName, summary); err != nil {
+			b.log.Error("%sFailed to save integrity summary: %v", prefix, err)
+		}
+	}
+}
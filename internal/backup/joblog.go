@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobLogMaxLines caps the lines held for one job. A job that logs more is
+// written out in several blocks rather than growing without bound.
+const jobLogMaxLines = 5000
+
+// jobLog holds the Info and Debug lines of each running job and writes
+// them to the next Logger as one contiguous block when the job finishes,
+// so the logs of parallel workers don't interleave (logging.buffer_jobs).
+// A line belongs to a job when it starts with the job's "[id] " prefix;
+// other lines, and errors, are written straight away. Held lines keep the
+// time they were logged after the prefix, since the block is stamped when
+// it is written.
+type jobLog struct {
+	next Logger
+
+	mu   sync.Mutex
+	jobs map[string][]jobLogLine
+}
+
+type jobLogLine struct {
+	debug bool
+	text  string // Formatted, with the original time after the job prefix
+}
+
+func newJobLog(next Logger) *jobLog {
+	return &jobLog{next: next, jobs: make(map[string][]jobLogLine)}
+}
+
+func (l *jobLog) Info(msg string, args ...interface{}) {
+	l.log(false, msg, args)
+}
+
+func (l *jobLog) Debug(msg string, args ...interface{}) {
+	l.log(true, msg, args)
+}
+
+func (l *jobLog) Error(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next.Error(msg, args...)
+}
+
+func (l *jobLog) log(debug bool, msg string, args []interface{}) {
+	formatted := fmt.Sprintf(msg, args...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id, rest, ok := jobLinePrefix(formatted)
+	if _, running := l.jobs[id]; !ok || !running {
+		if debug {
+			l.next.Debug("%s", formatted)
+		} else {
+			l.next.Info("%s", formatted)
+		}
+		return
+	}
+	text := fmt.Sprintf("[%s] %s %s", id, time.Now().UTC().Format("15:04:05.000"), rest)
+	l.jobs[id] = append(l.jobs[id], jobLogLine{debug: debug, text: text})
+	if len(l.jobs[id]) >= jobLogMaxLines {
+		l.writeLocked(id)
+	}
+}
+
+// start begins holding the lines of a job. A nil jobLog does nothing.
+func (l *jobLog) start(id string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.jobs[id]; !ok {
+		l.jobs[id] = nil
+	}
+}
+
+// finish writes out a job's held lines and stops holding them.
+func (l *jobLog) finish(id string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writeLocked(id)
+	delete(l.jobs, id)
+}
+
+// writeLocked writes out a job's held lines. l.mu must be held, which
+// keeps other lines out of the block.
+func (l *jobLog) writeLocked(id string) {
+	for _, line := range l.jobs[id] {
+		if line.debug {
+			l.next.Debug("%s", line.text)
+		} else {
+			l.next.Info("%s", line.text)
+		}
+	}
+	if l.jobs[id] != nil {
+		l.jobs[id] = l.jobs[id][:0]
+	}
+}
+
+// jobLinePrefix splits "[id] message" into its job ID and message.
+func jobLinePrefix(line string) (id, rest string, ok bool) {
+	if !strings.HasPrefix(line, "[") {
+		return "", "", false
+	}
+	end := strings.Index(line, "] ")
+	if end < 2 {
+		return "", "", false
+	}
+	return line[1:end], line[end+2:], true
+}
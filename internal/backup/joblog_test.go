@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// linesLogger records lines as "LEVEL text".
+type linesLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *linesLogger) add(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(msg, args...))
+}
+
+func (l *linesLogger) Info(msg string, args ...interface{})  { l.add("INFO", msg, args) }
+func (l *linesLogger) Debug(msg string, args ...interface{}) { l.add("DEBUG", msg, args) }
+func (l *linesLogger) Error(msg string, args ...interface{}) { l.add("ERROR", msg, args) }
+
+func TestJobLog(t *testing.T) {
+	next := &linesLogger{}
+	l := newJobLog(next)
+
+	l.start("aaaa")
+	l.start("bbbb")
+	l.Debug("[%s] Processing: %s", "aaaa", "api")
+	l.Info("[bbbb] Fetching web")
+	l.Info("Run-level line")
+	l.Error("[%s] Failed to backup PRs for %s: %v", "aaaa", "api", "boom")
+	l.Info("[cccc] Not a running job")
+	l.Info("[aaaa] Saved %d PRs", 3)
+	l.finish("bbbb")
+	l.finish("aaaa")
+	l.Info("[aaaa] After the job finished")
+
+	stamp := regexp.MustCompile(` \d\d:\d\d:\d\d\.\d{3} `)
+	var got []string
+	for _, line := range next.lines {
+		got = append(got, stamp.ReplaceAllString(line, " T "))
+	}
+	want := []string{
+		"INFO Run-level line",
+		"ERROR [aaaa] Failed to backup PRs for api: boom",
+		"INFO [cccc] Not a running job",
+		"INFO [bbbb] T Fetching web",
+		"DEBUG [aaaa] T Processing: api",
+		"INFO [aaaa] T Saved 3 PRs",
+		"INFO [aaaa] After the job finished",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("lines:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestJobLog_LongJobWritesBlocks(t *testing.T) {
+	next := &linesLogger{}
+	l := newJobLog(next)
+	l.start("aaaa")
+	for i := 0; i < jobLogMaxLines+1; i++ {
+		l.Debug("[aaaa] line %d", i)
+	}
+	if len(next.lines) != jobLogMaxLines {
+		t.Errorf("%d lines written before the job finished, want %d", len(next.lines), jobLogMaxLines)
+	}
+	l.finish("aaaa")
+	if len(next.lines) != jobLogMaxLines+1 {
+		t.Errorf("%d lines written, want %d", len(next.lines), jobLogMaxLines+1)
+	}
+
+	var nilLog *jobLog
+	nilLog.start("x")
+	nilLog.finish("x")
+}
@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// MetricsVar is the expvar variable the running backup publishes its
+// internals under.
+const MetricsVar = "bb_backup"
+
+var (
+	metricsOnce sync.Once
+	metricsRun  atomic.Pointer[Backup] // The backup MetricsVar reports on
+)
+
+// runMetrics is the JSON published under MetricsVar.
+type runMetrics struct {
+	Workspace   string               `json:"workspace"`
+	RunID       string               `json:"run_id,omitempty"`
+	Pool        *poolMetrics         `json:"pool,omitempty"` // Absent before repositories are processed
+	RateLimiter api.RateLimiterStats `json:"rate_limiter"`
+	HTTP        api.Usage            `json:"http"`
+	CacheHits   int64                `json:"cache_hits"`
+	CacheMisses int64                `json:"cache_misses"`
+	Panics      int64                `json:"panics"`
+}
+
+// poolMetrics are the worker pool's counters.
+type poolMetrics struct {
+	Workers       int    `json:"workers"`
+	Active        int64  `json:"active"`
+	JobsSubmitted int64  `json:"jobs_submitted"`
+	JobsProcessed int64  `json:"jobs_processed"`
+	JobsRetried   int64  `json:"jobs_retried"`
+	JobsQueued    int    `json:"jobs_queued"`
+	ResultsQueued int64  `json:"results_queued"`
+	ResultsRead   int64  `json:"results_read"`
+	LastActivity  string `json:"last_activity,omitempty"`
+}
+
+// metrics returns the pool's counters.
+func (p *workerPool) metrics() *poolMetrics {
+	m := &poolMetrics{
+		Workers:       p.workers,
+		Active:        p.activeWorkers.Load(),
+		JobsSubmitted: p.jobsSubmitted.Load(),
+		JobsProcessed: p.jobsProcessed.Load(),
+		JobsRetried:   p.jobsRetried.Load(),
+		JobsQueued:    len(p.jobs),
+		ResultsQueued: p.resultsQueued.Load(),
+		ResultsRead:   p.resultsRead.Load(),
+	}
+	if last := p.lastActivity.Load(); last > 0 {
+		m.LastActivity = time.Unix(last, 0).UTC().Format(time.RFC3339)
+	}
+	return m
+}
+
+// metrics returns a snapshot of the run's internals.
+func (b *Backup) metrics() runMetrics {
+	m := runMetrics{
+		Workspace: b.cfg.Workspace,
+		RunID:     b.runID,
+		Panics:    b.panics.Load(),
+	}
+	if pool := b.pool.Load(); pool != nil {
+		m.Pool = pool.metrics()
+	}
+	if b.client != nil {
+		m.RateLimiter = b.client.RateLimiter().Stats()
+		m.HTTP = b.client.Usage()
+		m.CacheHits, m.CacheMisses = b.client.CacheStats()
+	}
+	return m
+}
+
+// publishMetrics makes this backup the one reported under MetricsVar. The
+// variable is registered once per process, so later runs replace earlier
+// ones.
+func (b *Backup) publishMetrics() {
+	metricsOnce.Do(func() {
+		expvar.Publish(MetricsVar, expvar.Func(func() any {
+			if run := metricsRun.Load(); run != nil {
+				return run.metrics()
+			}
+			return nil
+		}))
+	})
+	metricsRun.Store(b)
+}
+
+// serveMetrics serves expvar at /debug/vars and Prometheus metrics at
+// /metrics on metrics.listen until the returned function is called. A listener that can't be opened is logged
+// rather than failing the backup.
+func (b *Backup) serveMetrics() func() {
+	addr := b.cfg.Metrics.Listen
+	if addr == "" {
+		return func() {}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		b.log.Error("Metrics listener on %s failed: %v", addr, err)
+		return func() {}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", b.servePrometheus)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.log.Error("Metrics listener on %s stopped: %v", addr, err)
+		}
+	}()
+	b.log.Info("Serving metrics at http://%s/debug/vars and /metrics", ln.Addr())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+}
@@ -0,0 +1,45 @@
+package backup
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestPublishMetrics(t *testing.T) {
+	cfg := &config.Config{Workspace: "ws", RateLimit: config.RateLimitConfig{RequestsPerHour: 1000, BurstSize: 10}}
+	b := &Backup{
+		cfg:    cfg,
+		client: api.NewClient(cfg),
+		log:    &defaultLogger{quiet: true},
+		runID:  "run-1",
+	}
+	b.publishMetrics()
+
+	pool := newWorkerPool(2, 5, 0, nil)
+	pool.submit(repoJob{repo: &api.Repository{Slug: "a"}})
+	b.pool.Store(pool)
+
+	var got runMetrics
+	if err := json.Unmarshal([]byte(expvar.Get(MetricsVar).String()), &got); err != nil {
+		t.Fatalf("decoding %s: %v", MetricsVar, err)
+	}
+	if got.Workspace != "ws" || got.RunID != "run-1" {
+		t.Errorf("metrics = %+v, want workspace ws and run run-1", got)
+	}
+	if got.Pool == nil || got.Pool.Workers != 2 || got.Pool.JobsSubmitted != 1 || got.Pool.JobsQueued != 1 {
+		t.Errorf("pool metrics = %+v, want 2 workers and 1 queued job", got.Pool)
+	}
+	if got.RateLimiter.BurstSize != 10 {
+		t.Errorf("rate limiter burst = %f, want 10", got.RateLimiter.BurstSize)
+	}
+
+	// A second run takes over the variable
+	(&Backup{cfg: &config.Config{Workspace: "other"}}).publishMetrics()
+	if err := json.Unmarshal([]byte(expvar.Get(MetricsVar).String()), &got); err != nil || got.Workspace != "other" {
+		t.Errorf("metrics after a second publish = %+v, %v", got, err)
+	}
+}
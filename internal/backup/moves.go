@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// projectRecheckAge is how old the repository listing must be before a
+// worker re-reads a repository's project. Moves during a short run are
+// unlikely; in a run lasting hours they are not, and the extra request per
+// repository is small next to its pull request and issue listings.
+const projectRecheckAge = 10 * time.Minute
+
+// RepoMove records a repository found in a different project than the
+// previous run or the repository listing placed it in. An empty project
+// key means personal (no project).
+type RepoMove struct {
+	Slug string `json:"slug"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Relocated is true when the existing copy in latest/, including the
+	// mirror, was moved to the new project path
+	Relocated bool `json:"relocated"`
+}
+
+// repoMoves collects moves seen by workers during a run.
+type repoMoves struct {
+	mu    sync.Mutex
+	moves []RepoMove
+}
+
+// add records a move, replacing an earlier one for the same repository
+// (a retried job sees the move again).
+func (m *repoMoves) add(move RepoMove) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.moves {
+		if m.moves[i].Slug == move.Slug {
+			m.moves[i] = move
+			return
+		}
+	}
+	m.moves = append(m.moves, move)
+}
+
+// list returns the moves sorted by slug.
+func (m *repoMoves) list() []RepoMove {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	moves := append([]RepoMove(nil), m.moves...)
+	sort.Slice(moves, func(i, j int) bool { return moves[i].Slug < moves[j].Slug })
+	return moves
+}
+
+// runRepoBaseDir returns the run directory that holds a repository's
+// repositories/ folder: its project's, or its owner's for personal
+// repositories.
+func (b *Backup) runRepoBaseDir(repo *api.Repository) string {
+	if projectKey := repoProjectKey(repo); projectKey != "" {
+		return filepath.Join(b.runDir, "projects", projectKey)
+	}
+	return filepath.Join(b.runDir, personalDirName, b.repoOwner(repo))
+}
+
+// reconcileProject makes sure a repository is backed up under the project
+// it is in now. If the listing is old, the repository is re-read so a move
+// during the run is seen; repo is updated in place. If the copy in latest/
+// from the last backup sits under a different project, it is moved to the
+// new path so the mirror is fetched rather than cloned a second time. It
+// returns the run directory base to use and the move, if any.
+func (b *Backup) reconcileProject(ctx context.Context, baseDir string, repo *api.Repository) (string, *RepoMove) {
+	prefix := api.LogPrefix(ctx)
+	listed := repoProjectKey(repo)
+	from, moved := listed, false
+
+	if !b.enumeratedAt.IsZero() && time.Since(b.enumeratedAt) > projectRecheckAge {
+		fresh, err := b.provider.GetRepository(ctx, b.cfg.Workspace, repo.Slug)
+		if err != nil {
+			b.log.Debug("%sCould not re-read repository %s: %v", prefix, repo.Slug, err)
+		} else if repoProjectKey(fresh) != listed {
+			b.log.Info("%sRepository %s moved from project %q to %q during the run", prefix, repo.Slug, listed, repoProjectKey(fresh))
+			*repo = *fresh
+			baseDir = b.runRepoBaseDir(fresh)
+			moved = true
+		}
+	}
+	current := repoProjectKey(repo)
+
+	// Only trust the recorded project when its latest/ copy exists; state
+	// files from older versions may not record project keys
+	if prev, ok := b.state.GetRepoState(b.repoStateKey(repo)); ok && prev.ProjectKey != current {
+		if _, err := os.Stat(b.latestRepoPath(prev.ProjectKey, repo)); err == nil {
+			from, moved = prev.ProjectKey, true
+		}
+	}
+	if !moved {
+		return baseDir, nil
+	}
+
+	move := RepoMove{Slug: repo.Slug, From: from, To: current}
+	if !b.opts.DryRun {
+		move.Relocated = b.relocateLatest(ctx, repo, from, current)
+	}
+	b.moves.add(move)
+	return baseDir, &move
+}
+
+// latestRepoPath returns the absolute latest/ directory of a repository in
+// the given project, or under its owner when the key is empty.
+func (b *Backup) latestRepoPath(projectKey string, repo *api.Repository) string {
+	if projectKey == "" {
+		return filepath.Join(b.storage.BasePath(), b.latestRoot(), personalRepoRel(b.repoOwner(repo), b.repoDirName(repo)))
+	}
+	return filepath.Join(b.storage.BasePath(), b.latestRoot(), "projects", projectKey, "repositories", b.repoDirName(repo))
+}
+
+// relocateLatest moves a repository's directory in latest/ from one project
+// to another. An existing copy at the destination is left alone.
+func (b *Backup) relocateLatest(ctx context.Context, repo *api.Repository, from, to string) bool {
+	prefix := api.LogPrefix(ctx)
+	slug := repo.Slug
+	oldDir, newDir := b.latestRepoPath(from, repo), b.latestRepoPath(to, repo)
+
+	if _, err := os.Stat(oldDir); err != nil {
+		return false
+	}
+	if _, err := os.Stat(newDir); err == nil {
+		b.log.Info("%sRepository %s moved to %q but %s already exists; leaving the old copy in place", prefix, slug, to, newDir)
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		b.log.Error("%sFailed to relocate %s: %v", prefix, slug, err)
+		return false
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		b.log.Error("%sFailed to relocate %s: %v", prefix, slug, err)
+		return false
+	}
+	b.log.Info("%sRelocated %s in latest/ from project %q to %q", prefix, slug, from, to)
+	if err := b.replicateMove(oldDir, newDir); err != nil {
+		b.log.Error("%sFailed to relocate %s in the bucket: %v", prefix, slug, err)
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestReconcileProject_MovedSinceLastRun(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	b.state.UpdateRepository("api", "{uuid}", "OLD", "")
+	b.runDir = "ws/run"
+
+	oldMirror := filepath.Join(b.storage.BasePath(), "ws/latest/projects/OLD/repositories/api/repo.git")
+	if err := os.MkdirAll(oldMirror, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &api.Repository{Slug: "api", Project: &api.Project{Key: "NEW"}}
+	baseDir, move := b.reconcileProject(context.Background(), "ws/run/projects/NEW", repo)
+	if baseDir != "ws/run/projects/NEW" {
+		t.Errorf("baseDir = %q, want unchanged", baseDir)
+	}
+	if move == nil || move.From != "OLD" || move.To != "NEW" || !move.Relocated {
+		t.Fatalf("unexpected move %+v", move)
+	}
+	if _, err := os.Stat(filepath.Join(b.storage.BasePath(), "ws/latest/projects/NEW/repositories/api/repo.git")); err != nil {
+		t.Errorf("mirror not relocated: %v", err)
+	}
+	if _, err := os.Stat(oldMirror); !os.IsNotExist(err) {
+		t.Errorf("old mirror still present: %v", err)
+	}
+	if moves := b.moves.list(); len(moves) != 1 || moves[0].Slug != "api" {
+		t.Errorf("moves = %+v, want one for api", moves)
+	}
+}
+
+func TestReconcileProject_UnrecordedProject(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	// Older state files have no project key and no personal copy exists
+	b.state.UpdateRepository("api", "{uuid}", "", "")
+
+	repo := &api.Repository{Slug: "api", Project: &api.Project{Key: "CORE"}}
+	if _, move := b.reconcileProject(context.Background(), "ws/run/projects/CORE", repo); move != nil {
+		t.Errorf("unexpected move %+v", move)
+	}
+}
+
+func TestReconcileProject_MovedDuringRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"slug":"api","project":{"key":"NEW"}}`))
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+
+	b := newRunTestBackup(t, "")
+	b.cfg = cfg
+	setClient(b, api.NewClient(cfg, api.WithBaseURL(server.URL)))
+	b.state = NewState("ws")
+	b.runDir = "ws/run"
+	b.enumeratedAt = time.Now().Add(-time.Hour)
+
+	repo := &api.Repository{Slug: "api", Project: &api.Project{Key: "OLD"}}
+	baseDir, move := b.reconcileProject(context.Background(), "ws/run/projects/OLD", repo)
+	if baseDir != filepath.Join("ws/run", "projects", "NEW") {
+		t.Errorf("baseDir = %q, want ws/run/projects/NEW", baseDir)
+	}
+	if repo.Project.Key != "NEW" {
+		t.Errorf("repo not refreshed: project %q", repo.Project.Key)
+	}
+	if move == nil || move.From != "OLD" || move.To != "NEW" || move.Relocated {
+		t.Errorf("unexpected move %+v", move)
+	}
+}
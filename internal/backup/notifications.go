@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/notify"
+)
+
+// notifyTimeout bounds sending a run's notifications, retries included.
+const notifyTimeout = 5 * time.Minute
+
+// notificationSummary describes the finished run for notifications.
+func (b *Backup) notificationSummary(runErr error) notify.Summary {
+	now := time.Now()
+	s := notify.Summary{
+		Workspace:       b.cfg.Workspace,
+		Alias:           b.cfg.WorkspaceAlias,
+		RunID:           b.runID,
+		StartedAt:       b.report.StartedAt,
+		CompletedAt:     now.UTC().Format(time.RFC3339),
+		DurationSeconds: now.Sub(b.counters.started).Seconds(),
+	}
+	if sum := b.summary; sum != nil {
+		s.Succeeded, s.Failed, s.Skipped = sum.Succeeded, sum.Failed, sum.Skipped
+		s.Deferred, s.Interrupted = sum.Deferred, sum.Interrupted
+		s.PullRequests, s.Issues, s.Bytes = sum.PullRequests, sum.Issues, sum.Bytes
+	} else {
+		// The run stopped before its summary; report what it got through
+		s.Succeeded, s.Failed = int(b.counters.backedUp.Load()), int(b.counters.failed.Load())
+		s.PullRequests, s.Issues = int(b.counters.pullRequests.Load()), int(b.counters.issues.Load())
+		s.Bytes = b.counters.bytes.Load()
+	}
+
+	b.report.mu.Lock()
+	for _, r := range b.report.Repositories {
+		if r.Status == RepoStatusFailed {
+			s.FailedRepos = append(s.FailedRepos, notify.FailedRepo{Slug: r.Slug, Project: r.Project, Error: r.Error, Code: r.Code})
+		}
+	}
+	b.report.mu.Unlock()
+
+	if runErr != nil {
+		s.Error, s.Code = runErr.Error(), errcode.Of(runErr)
+	}
+	s.Success = runErr == nil && s.Failed == 0
+	s.Event = notify.EventFailed
+	if s.Success {
+		s.Event = notify.EventSucceeded
+	}
+	return s
+}
+
+// sendNotifications sends the run's summary to the targets under
+// notifications. Dry runs send nothing, and delivery failures are logged
+// without failing the backup.
+func (b *Backup) sendNotifications(runErr error) {
+	if b.notifier == nil || b.opts.DryRun {
+		return
+	}
+	s := b.notificationSummary(runErr)
+	if !b.notifier.Wants(s.Success) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := b.notifier.Send(ctx, s); err != nil {
+		b.log.Error("Failed to send notifications: %v", err)
+		return
+	}
+	b.log.Debug("Sent %s notifications", s.Event)
+}
+
+// NotifyFailure sends notifications for a backup that failed before it
+// could run, e.g. because its storage could not be opened.
+func NotifyFailure(cfg *config.Config, runErr error) error {
+	n, err := notify.New(cfg.Notifications)
+	if err != nil || n == nil || !n.Wants(false) {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	return n.Send(ctx, notify.Summary{
+		Event:       notify.EventFailed,
+		Workspace:   cfg.Workspace,
+		Alias:       cfg.WorkspaceAlias,
+		Error:       runErr.Error(),
+		Code:        errcode.Of(runErr),
+		CompletedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/notify"
+)
+
+func TestSendNotifications(t *testing.T) {
+	var got []notify.Summary
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var s notify.Summary
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			t.Errorf("decoding notification: %v", err)
+		}
+		got = append(got, s)
+	}))
+	defer srv.Close()
+
+	b := newRunTestBackup(t, "")
+	b.runID = "run-1"
+	b.cfg.Notifications = config.NotificationsConfig{When: config.NotifyFailure, Webhook: config.WebhookNotification{URL: srv.URL}}
+	notifier, err := notify.New(b.cfg.Notifications)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.notifier = notifier
+	b.counters.started = time.Now().Add(-time.Minute)
+
+	// A clean run is not notified under when: failure
+	b.summary = &RunSummary{Succeeded: 3, PullRequests: 7}
+	b.sendNotifications(nil)
+	if len(got) != 0 {
+		t.Fatalf("sent %d notifications for a clean run", len(got))
+	}
+
+	b.summary = &RunSummary{Succeeded: 2, Failed: 1, Deferred: 4}
+	b.report.Add(RepoReport{Slug: "api", Project: "CORE", Status: RepoStatusFailed, Error: "fetch timed out", Code: errcode.GitTimeout})
+	b.report.Add(RepoReport{Slug: "web", Status: RepoStatusOK})
+	b.sendNotifications(errcode.New(errcode.ReposFailed, "1 repository failed"))
+	if len(got) != 1 {
+		t.Fatalf("sent %d notifications, want 1", len(got))
+	}
+	s := got[0]
+	if s.Event != notify.EventFailed || s.Success || s.RunID != "run-1" || s.Code != errcode.ReposFailed || s.Deferred != 4 || s.DurationSeconds < 60 {
+		t.Errorf("summary = %+v", s)
+	}
+	if len(s.FailedRepos) != 1 || s.FailedRepos[0].Slug != "api" || s.FailedRepos[0].Code != errcode.GitTimeout {
+		t.Errorf("failed repos = %+v", s.FailedRepos)
+	}
+
+	// A run that stopped early reports its counters
+	b.summary = nil
+	b.counters.add(repoStats{PullRequests: 5})
+	b.sendNotifications(errors.New("listing repositories: boom"))
+	if len(got) != 2 || got[1].Succeeded != 1 || got[1].PullRequests != 5 || got[1].Code != errcode.Unknown {
+		t.Errorf("early failure summary = %+v", got[len(got)-1])
+	}
+}
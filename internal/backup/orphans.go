@@ -0,0 +1,247 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// MembersFileName is the workspace member list saved in each run directory
+// and in latest/.
+const MembersFileName = "members.json"
+
+// backupMembers saves the current workspace member list to the run
+// directory and latest/.
+func (b *Backup) backupMembers(ctx context.Context, backupDir string) error {
+	members, err := b.provider.GetWorkspaceMembers(ctx, b.cfg.Workspace)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{backupDir, b.latestRoot()} {
+		if err := b.saveEntity(dir, MembersFileName, members); err != nil {
+			return fmt.Errorf("saving %s: %w", MembersFileName, err)
+		}
+	}
+	b.log.Debug("Saved %d workspace members", len(members))
+	return nil
+}
+
+// OrphanReport lists content in a backup authored by users who are not in
+// the workspace member list captured with it.
+type OrphanReport struct {
+	Workspace   string           `json:"workspace"`
+	MemberCount int              `json:"member_count"`
+	Authors     []OrphanedAuthor `json:"authors"`
+	Total       int              `json:"total"`
+}
+
+// OrphanedAuthor summarizes the content of one former member.
+type OrphanedAuthor struct {
+	UUID         string   `json:"uuid,omitempty"`
+	AccountID    string   `json:"account_id,omitempty"`
+	DisplayName  string   `json:"display_name"`
+	PullRequests int      `json:"pull_requests"`
+	Issues       int      `json:"issues"`
+	Comments     int      `json:"comments"`
+	Repositories []string `json:"repositories"`
+}
+
+// total returns the number of items authored.
+func (a OrphanedAuthor) total() int {
+	return a.PullRequests + a.Issues + a.Comments
+}
+
+// authorRef is the subset of a Bitbucket user object needed to match
+// authors against members.
+type authorRef struct {
+	UUID        string `json:"uuid"`
+	AccountID   string `json:"account_id"`
+	DisplayName string `json:"display_name"`
+}
+
+// key identifies a user, preferring the UUID. Users deleted from Bitbucket
+// can lose both identifiers and are grouped by display name.
+func (u authorRef) key() string {
+	switch {
+	case u.UUID != "":
+		return u.UUID
+	case u.AccountID != "":
+		return "account:" + u.AccountID
+	default:
+		return "name:" + u.DisplayName
+	}
+}
+
+// FindOrphanedAuthors scans the pull requests, issues, and comments under
+// a workspace backup's latest/ directory and reports those whose author is
+// missing from its members.json.
+func FindOrphanedAuthors(workspaceDir string) (*OrphanReport, error) {
+	latest, err := filepath.EvalSymlinks(filepath.Join(workspaceDir, LatestDirName))
+	if err != nil {
+		return nil, fmt.Errorf("locating latest backup: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(latest, MembersFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s not found in %s; run a backup with this version first", MembersFileName, latest)
+		}
+		return nil, fmt.Errorf("reading members: %w", err)
+	}
+	var members []api.WorkspaceMembership
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("parsing members: %w", err)
+	}
+	current := make(map[string]bool, len(members)*2)
+	for _, m := range members {
+		if m.User.UUID != "" {
+			current[m.User.UUID] = true
+		}
+		if m.User.AccountID != "" {
+			current["account:"+m.User.AccountID] = true
+		}
+	}
+	isMember := func(u authorRef) bool {
+		return (u.UUID != "" && current[u.UUID]) || (u.AccountID != "" && current["account:"+u.AccountID])
+	}
+
+	report := &OrphanReport{
+		Workspace:   filepath.Base(filepath.Clean(workspaceDir)),
+		MemberCount: len(members),
+	}
+	authors := make(map[string]*OrphanedAuthor)
+	repos := make(map[string]map[string]bool)
+	record := func(u authorRef, repo, kind string) {
+		if isMember(u) {
+			return
+		}
+		key := u.key()
+		a, ok := authors[key]
+		if !ok {
+			a = &OrphanedAuthor{UUID: u.UUID, AccountID: u.AccountID, DisplayName: u.DisplayName}
+			authors[key] = a
+			repos[key] = make(map[string]bool)
+		}
+		switch kind {
+		case "pull_request":
+			a.PullRequests++
+		case "issue":
+			a.Issues++
+		default:
+			a.Comments++
+		}
+		repos[key][repo] = true
+	}
+
+	err = filepath.WalkDir(latest, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "repo.git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(latest, path)
+		if err != nil {
+			return err
+		}
+		repo, kind := classifyEntityFile(filepath.ToSlash(rel))
+		if kind == "" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", rel, err)
+		}
+
+		if kind == "comments" {
+			var comments []struct {
+				User *authorRef `json:"user"`
+			}
+			if json.Unmarshal(data, &comments) != nil {
+				return nil
+			}
+			for _, c := range comments {
+				if c.User != nil {
+					record(*c.User, repo, kind)
+				}
+			}
+			return nil
+		}
+
+		var entity struct {
+			Author   *authorRef `json:"author"`
+			Reporter *authorRef `json:"reporter"`
+		}
+		if json.Unmarshal(data, &entity) != nil {
+			return nil
+		}
+		if kind == "pull_request" && entity.Author != nil {
+			record(*entity.Author, repo, kind)
+		} else if kind == "issue" && entity.Reporter != nil {
+			record(*entity.Reporter, repo, kind)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning latest backup: %w", err)
+	}
+
+	for key, a := range authors {
+		for repo := range repos[key] {
+			a.Repositories = append(a.Repositories, repo)
+		}
+		sort.Strings(a.Repositories)
+		report.Authors = append(report.Authors, *a)
+		report.Total += a.total()
+	}
+	sort.Slice(report.Authors, func(i, j int) bool {
+		a, b := report.Authors[i], report.Authors[j]
+		if a.total() != b.total() {
+			return a.total() > b.total()
+		}
+		return a.DisplayName < b.DisplayName
+	})
+
+	return report, nil
+}
+
+// classifyEntityFile maps a path relative to latest/ to its repository
+// ("KEY/slug", or "owner/slug" or "slug" for personal repos) and entity
+// kind: pull_request, issue, or comments. Other files return an empty kind.
+func classifyEntityFile(rel string) (repo, kind string) {
+	parts := strings.Split(rel, "/")
+	var rest []string
+	switch {
+	case len(parts) > 4 && parts[0] == "projects" && parts[2] == "repositories":
+		repo, rest = parts[1]+"/"+parts[3], parts[4:]
+	case len(parts) > 3 && parts[0] == "personal" && parts[1] == "repositories":
+		repo, rest = parts[2], parts[3:]
+	case len(parts) > 4 && parts[0] == "personal" && parts[2] == "repositories":
+		repo, rest = parts[1]+"/"+parts[3], parts[4:]
+	default:
+		return "", ""
+	}
+
+	switch {
+	case len(rest) == 2 && rest[0] == "pull-requests":
+		return repo, "pull_request"
+	case len(rest) == 2 && rest[0] == "issues":
+		return repo, "issue"
+	case len(rest) == 3 && (rest[0] == "pull-requests" || rest[0] == "issues") && rest[2] == "comments.json":
+		return repo, "comments"
+	}
+	return "", ""
+}
@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindOrphanedAuthors(t *testing.T) {
+	ws := t.TempDir()
+	latest := filepath.Join(ws, LatestDirName)
+	writeTestFile(t, filepath.Join(latest, MembersFileName),
+		`[{"type":"workspace_membership","user":{"uuid":"{alice}","display_name":"Alice"}}]`)
+
+	repo := filepath.Join(latest, "projects", "CORE", "repositories", "api")
+	writeTestFile(t, filepath.Join(repo, "pull-requests", "1.json"),
+		`{"id":1,"author":{"uuid":"{bob}","display_name":"Bob"}}`)
+	writeTestFile(t, filepath.Join(repo, "pull-requests", "2.json"),
+		`{"id":2,"author":{"uuid":"{alice}","display_name":"Alice"}}`)
+	writeTestFile(t, filepath.Join(repo, "pull-requests", "2", "comments.json"),
+		`[{"id":7,"user":{"uuid":"{bob}","display_name":"Bob"}},{"id":8,"user":{"uuid":"{alice}"}}]`)
+	writeTestFile(t, filepath.Join(repo, "pull-requests", "2", "activity.json"),
+		`[{"user":{"uuid":"{carol}"}}]`)
+	writeTestFile(t, filepath.Join(latest, "personal", "repositories", "notes", "issues", "3.json"),
+		`{"id":3,"reporter":{"display_name":"Former user"}}`)
+	writeTestFile(t, filepath.Join(repo, "repo.git", "pull-requests", "9.json"), `{"author":{"uuid":"{x}"}}`)
+
+	report, err := FindOrphanedAuthors(ws)
+	if err != nil {
+		t.Fatalf("FindOrphanedAuthors() error = %v", err)
+	}
+	if report.MemberCount != 1 || report.Total != 3 || len(report.Authors) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	bob := report.Authors[0]
+	if bob.UUID != "{bob}" || bob.PullRequests != 1 || bob.Comments != 1 {
+		t.Errorf("unexpected entry for Bob: %+v", bob)
+	}
+	if strings.Join(bob.Repositories, ",") != "CORE/api" {
+		t.Errorf("Bob repositories = %v", bob.Repositories)
+	}
+	former := report.Authors[1]
+	if former.DisplayName != "Former user" || former.Issues != 1 || former.Repositories[0] != "notes" {
+		t.Errorf("unexpected entry for deleted user: %+v", former)
+	}
+}
+
+func TestFindOrphanedAuthors_NoMembers(t *testing.T) {
+	ws := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(ws, LatestDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindOrphanedAuthors(ws); err == nil || !strings.Contains(err.Error(), MembersFileName) {
+		t.Errorf("expected missing members error, got %v", err)
+	}
+}
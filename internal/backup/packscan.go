@@ -0,0 +1,199 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+// Pack scanning (scan.pack_command) runs a malware scanner against the
+// object files each fetch adds to a mirror. Before the fetch the mirror is
+// snapshotted: object files, which git never rewrites, are hard-linked and
+// the rest copied. If the scanner flags the new files, or cannot scan
+// them, the new files are moved to the run's quarantine directory for
+// review and the snapshot replaces the mirror, so latest/ keeps the last
+// good copy and the repository fails.
+
+// ErrUpdateQuarantined is returned for a repository whose fetched objects
+// were flagged by scan.pack_command.
+var ErrUpdateQuarantined = errcode.New(errcode.Quarantined, "update quarantined by pack scan")
+
+// snapshotSuffix names a mirror's pre-fetch snapshot.
+const snapshotSuffix = ".prescan"
+
+// quarantineDirName holds a repository's quarantined objects in its run
+// directory.
+const quarantineDirName = "quarantine"
+
+// PackScan records a scan of the objects a fetch added.
+type PackScan struct {
+	Files   int    `json:"files"`
+	Flagged bool   `json:"flagged,omitempty"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// QuarantineDir holds the new objects of a rejected update, relative to
+	// the storage base
+	QuarantineDir string `json:"quarantine_dir,omitempty"`
+}
+
+// snapshotMirror snapshots the mirror at gitPath before a fetch and
+// returns the snapshot's path, or "" if there is no mirror yet.
+func snapshotMirror(gitPath string) (string, error) {
+	snapshot := gitPath + snapshotSuffix
+	// Left behind by an interrupted run
+	if err := os.RemoveAll(snapshot); err != nil {
+		return "", fmt.Errorf("removing old snapshot: %w", err)
+	}
+	if _, err := os.Stat(gitPath); os.IsNotExist(err) {
+		return "", nil
+	}
+	err := filepath.WalkDir(gitPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(gitPath, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(snapshot, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dest, 0755)
+		case !d.Type().IsRegular():
+			return nil
+		case isObjectFile(rel):
+			if err := os.Link(path, dest); err == nil {
+				return nil
+			}
+			// Cross-device or unsupported; fall back to a copy
+		}
+		return copyFile(path, dest)
+	})
+	if err != nil {
+		_ = os.RemoveAll(snapshot)
+		return "", fmt.Errorf("snapshotting mirror: %w", err)
+	}
+	return snapshot, nil
+}
+
+// isObjectFile reports whether a path relative to the mirror is a pack or
+// loose object, which git writes once and never changes.
+func isObjectFile(rel string) bool {
+	dir := filepath.Dir(rel)
+	if filepath.Base(dir) == "pack" {
+		dir = filepath.Dir(dir)
+	} else if len(filepath.Base(dir)) != 2 {
+		return false
+	} else {
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Base(dir) == "objects"
+}
+
+// newObjectFiles returns the object files in the mirror at gitPath that
+// the snapshot lacks, sorted. With no snapshot every object file is new.
+func newObjectFiles(gitPath, snapshot string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(gitPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(gitPath, path)
+		if err != nil || !isObjectFile(rel) {
+			return err
+		}
+		if snapshot != "" {
+			if _, err := os.Stat(filepath.Join(snapshot, rel)); err == nil {
+				return nil
+			}
+		}
+		files = append(files, path)
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// scanPacks scans the objects the last fetch added to the mirror at
+// gitPath and rolls the mirror back to snapshot if they are rejected. It
+// returns the scan record, nil if nothing was added, and
+// ErrUpdateQuarantined for a rejected update.
+func (b *Backup) scanPacks(ctx context.Context, repoDir, gitPath, snapshot string, repo *api.Repository) (*PackScan, error) {
+	prefix := api.LogPrefix(ctx)
+	files, err := newObjectFiles(gitPath, snapshot)
+	if err != nil {
+		err = fmt.Errorf("listing new objects: %w", err)
+	} else if len(files) == 0 {
+		discardSnapshot(snapshot)
+		return nil, nil
+	}
+
+	result := &PackScan{Files: len(files)}
+	if err == nil {
+		if b.progress != nil && !b.shuttingDown.Load() {
+			b.progress.UpdateStatus(fmt.Sprintf("scanning objects: %s (%d files)", repo.Slug, len(files)))
+		}
+		b.log.Debug("%sScanning %d new object files of %s", prefix, len(files), repo.Slug)
+		result.Flagged, result.Output, err = b.packScanner.Scan(ctx, gitPath, files)
+	}
+	if err == nil && !result.Flagged {
+		discardSnapshot(snapshot)
+		return result, nil
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	quarantineDir := repoDir + "/" + quarantineDirName
+	if qerr := quarantineUpdate(gitPath, snapshot, files, filepath.Join(b.storage.BasePath(), quarantineDir)); qerr != nil {
+		return result, fmt.Errorf("%w, but rolling back the mirror failed: %v", ErrUpdateQuarantined, qerr)
+	}
+	result.QuarantineDir = quarantineDir
+	if result.Flagged {
+		b.log.Error("%sPack scan flagged new objects in %s; kept the previous mirror and moved %d files to %s: %s",
+			prefix, repo.Slug, len(files), quarantineDir, result.Output)
+		return result, ErrUpdateQuarantined
+	}
+	b.log.Error("%sPack scan of %s failed; kept the previous mirror and moved %d files to %s: %v",
+		prefix, repo.Slug, len(files), quarantineDir, err)
+	return result, fmt.Errorf("%w: %v", ErrUpdateQuarantined, err)
+}
+
+// quarantineUpdate moves the new object files to dir and puts the
+// snapshot back in place of the mirror, or removes the mirror if it was
+// cloned by this fetch.
+func quarantineUpdate(gitPath, snapshot string, files []string, dir string) error {
+	for _, f := range files {
+		rel, err := filepath.Rel(gitPath, f)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(f, dest); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(gitPath); err != nil {
+		return err
+	}
+	if snapshot == "" {
+		return nil
+	}
+	return os.Rename(snapshot, gitPath)
+}
+
+// discardSnapshot removes a snapshot no longer needed.
+func discardSnapshot(snapshot string) {
+	if snapshot != "" {
+		_ = os.RemoveAll(snapshot)
+	}
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/scan"
+)
+
+// writeMirrorFiles creates files under a fake mirror, relative path to
+// content.
+func writeMirrorFiles(t *testing.T, gitPath string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		p := filepath.Join(gitPath, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanPacks_Quarantine(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.packScanner = scan.NewPackScanner(`echo "$1: Eicar-Signature FOUND"; exit 1`)
+	gitPath := filepath.Join(b.storage.BasePath(), "ws/latest/projects/CORE/api/repo.git")
+	writeMirrorFiles(t, gitPath, map[string]string{
+		"objects/pack/pack-old.pack": "old",
+		"refs/heads/main":            "1111",
+	})
+
+	snapshot, err := snapshotMirror(gitPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fetch adds a pack and a loose object and rewrites a ref in place
+	writeMirrorFiles(t, gitPath, map[string]string{
+		"objects/pack/pack-new.pack": "new",
+		"objects/ab/cdef":            "loose",
+		"refs/heads/main":            "2222",
+	})
+
+	repoDir := "ws/2024-01-15T10-30-00Z/projects/CORE/repositories/api"
+	result, err := b.scanPacks(context.Background(), repoDir, gitPath, snapshot, &api.Repository{Slug: "api"})
+	if !errors.Is(err, ErrUpdateQuarantined) {
+		t.Fatalf("scanPacks() error = %v, want ErrUpdateQuarantined", err)
+	}
+	if result == nil || !result.Flagged || result.Files != 2 || result.QuarantineDir != repoDir+"/quarantine" {
+		t.Fatalf("scanPacks() = %+v", result)
+	}
+
+	if ref, _ := os.ReadFile(filepath.Join(gitPath, "refs/heads/main")); string(ref) != "1111" {
+		t.Errorf("ref = %q, want the pre-fetch value", ref)
+	}
+	if _, err := os.Stat(filepath.Join(gitPath, "objects/pack/pack-old.pack")); err != nil {
+		t.Errorf("old pack missing: %v", err)
+	}
+	for _, rel := range []string{"objects/pack/pack-new.pack", "objects/ab/cdef"} {
+		if _, err := os.Stat(filepath.Join(gitPath, rel)); !os.IsNotExist(err) {
+			t.Errorf("%s still in the mirror", rel)
+		}
+		if _, err := os.Stat(filepath.Join(b.storage.BasePath(), result.QuarantineDir, rel)); err != nil {
+			t.Errorf("%s not quarantined: %v", rel, err)
+		}
+	}
+	if _, err := os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Error("snapshot left behind")
+	}
+}
+
+func TestScanPacks_Clean(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.packScanner = scan.NewPackScanner("exit 0")
+	gitPath := filepath.Join(b.storage.BasePath(), "repo.git")
+
+	// A first clone has no snapshot
+	snapshot, err := snapshotMirror(gitPath)
+	if err != nil || snapshot != "" {
+		t.Fatalf("snapshotMirror() = %q, %v; want no snapshot", snapshot, err)
+	}
+	writeMirrorFiles(t, gitPath, map[string]string{"objects/pack/pack-1.pack": "new", "HEAD": "ref: refs/heads/main"})
+
+	result, err := b.scanPacks(context.Background(), "ws/run/api", gitPath, snapshot, &api.Repository{Slug: "api"})
+	if err != nil {
+		t.Fatalf("scanPacks() error = %v", err)
+	}
+	if result == nil || result.Flagged || result.Files != 1 {
+		t.Errorf("scanPacks() = %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(gitPath, "objects/pack/pack-1.pack")); err != nil {
+		t.Errorf("clean pack removed: %v", err)
+	}
+}
+
+func TestIsObjectFile(t *testing.T) {
+	tests := map[string]bool{
+		"objects/pack/pack-1.pack":      true,
+		"objects/pack/pack-1.idx":       true,
+		"objects/ab/cdef0123":           true,
+		".git/objects/ab/cdef0123":      true,
+		"objects/info/commit-graph":     false,
+		"objects/info/packs":            false,
+		"refs/heads/ab":                 false,
+		"packed-refs":                   false,
+		".git/objects/pack/pack-2.pack": true,
+	}
+	for rel, want := range tests {
+		if got := isObjectFile(rel); got != want {
+			t.Errorf("isObjectFile(%s) = %v, want %v", rel, got, want)
+		}
+	}
+}
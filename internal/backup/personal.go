@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// Repositories outside any project are stored under
+// personal/<owner>/repositories/<slug>, where owner is the account that
+// owns the repository, so repositories of different users that share a
+// slug don't collide. Earlier versions wrote personal/repositories/<slug>;
+// readers accept both, and a backup moves a repository's latest/ copy to
+// the new layout the first time it sees the repository.
+
+// personalDirName is the directory for repositories outside any project.
+const personalDirName = "personal"
+
+// RepoOwner returns the account that owns a repository: the first part of
+// its full name, else the owner's username or nickname, else workspace.
+func RepoOwner(repo *api.Repository, workspace string) string {
+	if owner, _, ok := strings.Cut(repo.FullName, "/"); ok && validOwner(owner) {
+		return owner
+	}
+	if repo.Owner != nil {
+		for _, name := range []string{repo.Owner.Username, repo.Owner.Nickname} {
+			if validOwner(name) {
+				return name
+			}
+		}
+	}
+	return workspace
+}
+
+// validOwner reports whether name can be used as a directory name.
+func validOwner(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// personalRepoRel returns a personal repository's directory relative to a
+// run or latest/ directory.
+func personalRepoRel(owner, slug string) string {
+	return filepath.Join(personalDirName, owner, "repositories", slug)
+}
+
+// repoOwner returns the owner of a repository in this backup's workspace.
+func (b *Backup) repoOwner(repo *api.Repository) string {
+	return RepoOwner(repo, b.cfg.Workspace)
+}
+
+// repoStateKey returns a repository's key in the state file: its slug, or
+// owner/slug for a personal repository owned by an account other than the
+// workspace, so incremental timestamps and history don't mix between
+// repositories of different users with the same slug.
+func (b *Backup) repoStateKey(repo *api.Repository) string {
+	if repoProjectKey(repo) != "" {
+		return repo.Slug
+	}
+	if owner := b.repoOwner(repo); owner != b.cfg.Workspace {
+		return owner + "/" + repo.Slug
+	}
+	return repo.Slug
+}
+
+// PersonalRepo is a personal repository's directory in a run or latest/.
+type PersonalRepo struct {
+	Owner string // Empty for the layout of earlier versions
+	Slug  string
+	Rel   string // Relative to the run or latest/ directory
+}
+
+// ListPersonalRepos lists the personal repository directories under root,
+// a run or latest/ directory, in both the per-owner and the earlier layout.
+func ListPersonalRepos(root string) ([]PersonalRepo, error) {
+	var repos []PersonalRepo
+	collect := func(owner, rel string) error {
+		entries, err := os.ReadDir(filepath.Join(root, rel))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("reading %s: %w", filepath.Join(root, rel), err)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				repos = append(repos, PersonalRepo{Owner: owner, Slug: e.Name(), Rel: filepath.Join(rel, e.Name())})
+			}
+		}
+		return nil
+	}
+
+	if err := collect("", filepath.Join(personalDirName, "repositories")); err != nil {
+		return nil, err
+	}
+	owners, err := os.ReadDir(filepath.Join(root, personalDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading personal repositories in %s: %w", root, err)
+	}
+	for _, o := range owners {
+		if !o.IsDir() || o.Name() == "repositories" {
+			continue
+		}
+		if err := collect(o.Name(), filepath.Join(personalDirName, o.Name(), "repositories")); err != nil {
+			return nil, err
+		}
+	}
+	return repos, nil
+}
+
+// adoptLegacyPersonal moves a personal repository's latest/ copy from the
+// layout of earlier versions to its owner's directory, so its mirror is
+// fetched rather than cloned again, and moves its state entry to the
+// owner-qualified key. A copy recorded in the state for a different
+// repository (another owner's, by UUID) is left for that repository.
+func (b *Backup) adoptLegacyPersonal(ctx context.Context, repo *api.Repository) {
+	if repoProjectKey(repo) != "" || b.opts.DryRun || b.storage == nil {
+		return
+	}
+	prefix := api.LogPrefix(ctx)
+	legacyDir := filepath.Join(b.storage.BasePath(), b.latestRoot(), personalDirName, "repositories", repo.Slug)
+	newDir := b.latestRepoPath("", repo)
+	if _, err := os.Stat(legacyDir); err != nil {
+		return
+	}
+	if _, err := os.Stat(newDir); err == nil {
+		return
+	}
+	prev, known := b.state.GetRepoState(repo.Slug)
+	if known && prev.UUID != "" && repo.UUID != "" && prev.UUID != repo.UUID {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		b.log.Error("%sFailed to move %s to %s: %v", prefix, repo.Slug, newDir, err)
+		return
+	}
+	if err := os.Rename(legacyDir, newDir); err != nil {
+		b.log.Error("%sFailed to move %s to %s: %v", prefix, repo.Slug, newDir, err)
+		return
+	}
+	if key := b.repoStateKey(repo); known && key != repo.Slug {
+		b.state.RenameRepository(repo.Slug, key)
+	}
+	b.log.Info("%sMoved personal repository %s in latest/ under its owner %s", prefix, repo.Slug, b.repoOwner(repo))
+	if err := b.replicateMove(legacyDir, newDir); err != nil {
+		b.log.Error("%sFailed to move %s in the bucket: %v", prefix, repo.Slug, err)
+	}
+}
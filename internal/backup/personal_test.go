@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func TestRepoOwner(t *testing.T) {
+	tests := []struct {
+		name string
+		repo api.Repository
+		want string
+	}{
+		{"full name", api.Repository{FullName: "alice/tools", Owner: &api.User{Username: "bob"}}, "alice"},
+		{"owner username", api.Repository{Owner: &api.User{Username: "bob"}}, "bob"},
+		{"owner nickname", api.Repository{Owner: &api.User{Nickname: "carol"}}, "carol"},
+		{"unsafe owner", api.Repository{FullName: "../tools"}, "ws"},
+		{"no owner", api.Repository{Slug: "tools"}, "ws"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RepoOwner(&tt.repo, "ws"); got != tt.want {
+				t.Errorf("RepoOwner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepoStateKey(t *testing.T) {
+	b := newRunTestBackup(t, "")
+
+	tests := []struct {
+		repo api.Repository
+		want string
+	}{
+		{api.Repository{Slug: "api", FullName: "ws/api", Project: &api.Project{Key: "CORE"}}, "api"},
+		{api.Repository{Slug: "tools", FullName: "ws/tools"}, "tools"},
+		{api.Repository{Slug: "tools", FullName: "alice/tools"}, "alice/tools"},
+	}
+	for _, tt := range tests {
+		if got := b.repoStateKey(&tt.repo); got != tt.want {
+			t.Errorf("repoStateKey(%s) = %q, want %q", tt.repo.FullName, got, tt.want)
+		}
+	}
+}
+
+func TestListPersonalRepos(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{
+		"personal/repositories/legacy",
+		"personal/alice/repositories/tools",
+		"personal/bob/repositories/tools",
+		"projects/CORE/repositories/api",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repos, err := ListPersonalRepos(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PersonalRepo{
+		{Slug: "legacy", Rel: filepath.Join("personal", "repositories", "legacy")},
+		{Owner: "alice", Slug: "tools", Rel: filepath.Join("personal", "alice", "repositories", "tools")},
+		{Owner: "bob", Slug: "tools", Rel: filepath.Join("personal", "bob", "repositories", "tools")},
+	}
+	if len(repos) != len(want) {
+		t.Fatalf("got %+v, want %+v", repos, want)
+	}
+	for i := range want {
+		if repos[i] != want[i] {
+			t.Errorf("repos[%d] = %+v, want %+v", i, repos[i], want[i])
+		}
+	}
+
+	if repos, err := ListPersonalRepos(t.TempDir()); err != nil || len(repos) != 0 {
+		t.Errorf("empty root: got %+v, %v", repos, err)
+	}
+}
+
+func TestAdoptLegacyPersonal(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	b.state.UpdateRepository("tools", "{uuid}", "", "")
+	b.state.SetRepoLastPRUpdated("tools", "2024-01-01T00:00:00Z")
+
+	legacy := filepath.Join(b.storage.BasePath(), "ws/latest/personal/repositories/tools/repo.git")
+	if err := os.MkdirAll(legacy, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another user's repository with the same slug leaves the copy alone
+	other := &api.Repository{Slug: "tools", UUID: "{other}", FullName: "bob/tools"}
+	b.adoptLegacyPersonal(context.Background(), other)
+	if _, err := os.Stat(legacy); err != nil {
+		t.Fatalf("copy moved for a different repository: %v", err)
+	}
+
+	repo := &api.Repository{Slug: "tools", UUID: "{uuid}", FullName: "alice/tools"}
+	b.adoptLegacyPersonal(context.Background(), repo)
+	if _, err := os.Stat(filepath.Join(b.storage.BasePath(), "ws/latest/personal/alice/repositories/tools/repo.git")); err != nil {
+		t.Errorf("mirror not moved under its owner: %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy copy still present: %v", err)
+	}
+	if _, ok := b.state.GetRepoState("tools"); ok {
+		t.Error("state still keyed by slug")
+	}
+	if got := b.state.GetLastPRUpdated("alice/tools"); got != "2024-01-01T00:00:00Z" {
+		t.Errorf("last PR update = %q, want it carried to alice/tools", got)
+	}
+}
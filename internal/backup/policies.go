@@ -0,0 +1,228 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// PoliciesFileName is the per-repository summary of branch permissions,
+// merge checks, and default reviewers, written for auditors who want
+// evidence they can read rather than API payloads.
+const PoliciesFileName = "policies.md"
+
+// branchPermissionKinds are the restriction kinds that limit who may change
+// a branch; every other kind is a merge check.
+var branchPermissionKinds = map[string]string{
+	"push":            "Only the users and groups listed may push",
+	"force":           "Force pushes (history rewrites) are blocked",
+	"delete":          "The branch cannot be deleted",
+	"restrict_merges": "Only the users and groups listed may merge pull requests",
+}
+
+// mergeCheckDescriptions describe merge check kinds; %d is the value.
+var mergeCheckDescriptions = map[string]string{
+	"require_approvals_to_merge":                    "At least %d approvals",
+	"require_default_reviewer_approvals_to_merge":   "At least %d approvals from default reviewers",
+	"require_passing_builds_to_merge":               "At least %d successful builds and no failed builds",
+	"require_commits_behind":                        "Source branch no more than %d commits behind the destination",
+	"require_tasks_to_be_completed":                 "All pull request tasks resolved",
+	"require_no_changes_requested":                  "No reviewer has requested changes",
+	"require_all_dependencies_merged":               "All dependency pull requests merged",
+	"reset_pullrequest_approvals_on_change":         "Approvals are reset when the source branch changes",
+	"reset_pullrequest_changes_requested_on_change": "Requested changes are reset when the source branch changes",
+	"enforce_merge_checks":                          "Merging is blocked until the checks pass",
+	"allow_auto_merge_when_builds_pass":             "Pull requests may auto-merge once builds pass",
+}
+
+// savePolicies fetches a repository's branch restrictions and default
+// reviewers and writes policies.md to the run's and latest/ directories.
+// The privacy policy applies to the names in it as for other entities.
+// A repository whose settings the credentials may not read (403, as
+// reading branch restrictions needs repository admin) is skipped with a
+// note; other failures are returned.
+func (b *Backup) savePolicies(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) error {
+	prefix := api.LogPrefix(ctx)
+
+	restrictions, err := b.client.GetBranchRestrictions(ctx, b.cfg.Workspace, repo.Slug)
+	if err == nil {
+		err = b.minimizeInto(&restrictions)
+	}
+	var reviewers []api.DefaultReviewer
+	if err == nil {
+		reviewers, err = b.client.GetEffectiveDefaultReviewers(ctx, b.cfg.Workspace, repo.Slug)
+	}
+	if err == nil {
+		err = b.minimizeInto(&reviewers)
+	}
+	if errors.Is(err, api.ErrPoliciesRestricted) {
+		b.log.Info("%sSkipping policies for %s: reading branch restrictions needs repository admin (403)", prefix, repo.Slug)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	doc := renderPolicies(b.cfg.Workspace, repo, restrictions, reviewers, time.Now().UTC())
+	for _, dir := range []string{repoDir, latestRepoDir} {
+		if err := b.storage.Write(filepath.Join(dir, PoliciesFileName), doc); err != nil {
+			return fmt.Errorf("saving %s: %w", PoliciesFileName, err)
+		}
+	}
+	return nil
+}
+
+// minimizeInto applies the privacy policy to v in place.
+func (b *Backup) minimizeInto(v interface{}) error {
+	if b.privacy == nil {
+		return nil
+	}
+	minimized, err := b.minimize(v)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(minimized)
+	if err != nil {
+		return fmt.Errorf("marshaling JSON: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// renderPolicies returns policies.md for a repository.
+func renderPolicies(workspace string, repo *api.Repository, restrictions []api.BranchRestriction, reviewers []api.DefaultReviewer, now time.Time) []byte {
+	var permissions, checks []api.BranchRestriction
+	for _, r := range restrictions {
+		if _, ok := branchPermissionKinds[r.Kind]; ok {
+			permissions = append(permissions, r)
+		} else {
+			checks = append(checks, r)
+		}
+	}
+	for _, list := range [][]api.BranchRestriction{permissions, checks} {
+		sort.SliceStable(list, func(i, j int) bool {
+			if bi, bj := branchesLabel(list[i]), branchesLabel(list[j]); bi != bj {
+				return bi < bj
+			}
+			return list[i].Kind < list[j].Kind
+		})
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Repository policies: %s/%s\n\n", workspace, repo.Slug)
+	fmt.Fprintf(&sb, "Captured from the Bitbucket API by bb-backup at %s.\n", now.Format(time.RFC3339))
+	if repo.MainBranch != nil && repo.MainBranch.Name != "" {
+		fmt.Fprintf(&sb, "Main branch: `%s`.\n", repo.MainBranch.Name)
+	}
+
+	sb.WriteString("\n## Branch permissions\n\n")
+	if len(permissions) == 0 {
+		sb.WriteString("None. Anyone with write access may push to, rewrite, or delete any branch.\n")
+	} else {
+		sb.WriteString("| Branches | Restriction | Users | Groups |\n|---|---|---|---|\n")
+		for _, r := range permissions {
+			fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n",
+				branchesLabel(r), branchPermissionKinds[r.Kind], userNames(r.Users), groupNames(r.Groups))
+		}
+	}
+
+	sb.WriteString("\n## Merge checks\n\n")
+	if len(checks) == 0 {
+		sb.WriteString("None. Pull requests may be merged without approvals or passing builds.\n")
+	} else {
+		sb.WriteString("| Branches | Check |\n|---|---|\n")
+		for _, r := range checks {
+			fmt.Fprintf(&sb, "| %s | %s |\n", branchesLabel(r), mergeCheckLabel(r))
+		}
+	}
+
+	sb.WriteString("\n## Default reviewers\n\n")
+	if len(reviewers) == 0 {
+		sb.WriteString("None.\n")
+	} else {
+		for _, r := range reviewers {
+			name := "(unknown user)"
+			if r.User != nil {
+				name = userName(*r.User)
+			}
+			source := "repository setting"
+			if r.ReviewerType == "project" {
+				source = "inherited from the project"
+			}
+			fmt.Fprintf(&sb, "- %s (%s)\n", name, source)
+		}
+	}
+	return []byte(sb.String())
+}
+
+// branchesLabel describes the branches a restriction applies to.
+func branchesLabel(r api.BranchRestriction) string {
+	if r.BranchMatchKind == "branching_model" {
+		return fmt.Sprintf("All %s branches", markdownCell(r.BranchType))
+	}
+	return "`" + strings.ReplaceAll(r.Pattern, "|", `\|`) + "`"
+}
+
+func mergeCheckLabel(r api.BranchRestriction) string {
+	desc, ok := mergeCheckDescriptions[r.Kind]
+	if !ok {
+		// A kind newer than this list; show it as the API names it
+		desc = "`" + r.Kind + "`"
+		if r.Value != nil {
+			desc += fmt.Sprintf(" = %d", *r.Value)
+		}
+		return desc
+	}
+	if strings.Contains(desc, "%d") {
+		value := 1
+		if r.Value != nil {
+			value = *r.Value
+		}
+		return fmt.Sprintf(desc, value)
+	}
+	return desc
+}
+
+func userNames(users []api.User) string {
+	if len(users) == 0 {
+		return "-"
+	}
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = userName(u)
+	}
+	return strings.Join(names, ", ")
+}
+
+func userName(u api.User) string {
+	switch {
+	case u.DisplayName != "":
+		return markdownCell(u.DisplayName)
+	case u.Nickname != "":
+		return markdownCell(u.Nickname)
+	default:
+		return markdownCell(u.UUID)
+	}
+}
+
+func groupNames(groups []api.Group) string {
+	if len(groups) == 0 {
+		return "-"
+	}
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = markdownCell(g.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// markdownCell escapes text for a Markdown table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
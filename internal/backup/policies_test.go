@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestRenderPolicies(t *testing.T) {
+	two := 2
+	repo := &api.Repository{Slug: "core-api", MainBranch: &api.Branch{Name: "main"}}
+	restrictions := []api.BranchRestriction{
+		{Kind: "require_approvals_to_merge", BranchMatchKind: "glob", Pattern: "main", Value: &two},
+		{Kind: "push", BranchMatchKind: "glob", Pattern: "main",
+			Users: []api.User{{DisplayName: "Ada Lovelace"}}, Groups: []api.Group{{Name: "Release | Managers"}}},
+		{Kind: "force", BranchMatchKind: "branching_model", BranchType: "production"},
+		{Kind: "require_shiny_new_check", BranchMatchKind: "glob", Pattern: "release/*"},
+	}
+	reviewers := []api.DefaultReviewer{
+		{ReviewerType: "repository", User: &api.User{DisplayName: "Grace Hopper"}},
+		{ReviewerType: "project", User: &api.User{Nickname: "linus"}},
+	}
+
+	doc := string(renderPolicies("acme", repo, restrictions, reviewers, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	for _, want := range []string{
+		"# Repository policies: acme/core-api\n",
+		"at 2024-01-02T03:04:05Z",
+		"Main branch: `main`.",
+		"| All production branches | Force pushes (history rewrites) are blocked | - | - |",
+		"| `main` | Only the users and groups listed may push | Ada Lovelace | Release \\| Managers |",
+		"| `main` | At least 2 approvals |",
+		"| `release/*` | `require_shiny_new_check` |",
+		"- Grace Hopper (repository setting)",
+		"- linus (inherited from the project)",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("policies.md missing %q:\n%s", want, doc)
+		}
+	}
+	if strings.Index(doc, "## Branch permissions") > strings.Index(doc, "## Merge checks") {
+		t.Errorf("branch permissions should come before merge checks:\n%s", doc)
+	}
+
+	empty := string(renderPolicies("acme", repo, nil, nil, time.Now()))
+	if !strings.Contains(empty, "None. Anyone with write access") || !strings.Contains(empty, "None. Pull requests may be merged") {
+		t.Errorf("a repository without policies should say so:\n%s", empty)
+	}
+}
+
+func TestSavePolicies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repositories/ws/core-api/branch-restrictions":
+			_, _ = w.Write([]byte(`{"values":[{"kind":"delete","branch_match_kind":"glob","pattern":"main"}]}`))
+		case "/repositories/ws/core-api/effective-default-reviewers":
+			_, _ = w.Write([]byte(`{"values":[{"reviewer_type":"repository","user":{"display_name":"Grace Hopper"}}]}`))
+		case "/repositories/ws/locked/branch-restrictions":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"message":"Access denied"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	b := newRunTestBackup(t, "")
+	b.cfg = config.Default()
+	b.cfg.Workspace = "ws"
+	b.cfg.RateLimit.RequestsPerHour = 36000
+	b.cfg.Privacy = config.PrivacyConfig{HashFields: []string{"display_name"}, HashSalt: "salt"}
+	b.privacy = newPrivacyFilter(b.cfg.Privacy)
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+
+	runDir, latestDir := "run/core-api", "latest/core-api"
+	if err := b.savePolicies(context.Background(), runDir, latestDir, &api.Repository{Slug: "core-api"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{runDir, latestDir} {
+		data, err := os.ReadFile(filepath.Join(b.storage.BasePath(), dir, PoliciesFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := string(data)
+		if !strings.Contains(doc, "The branch cannot be deleted") {
+			t.Errorf("%s: missing the delete restriction:\n%s", dir, doc)
+		}
+		if strings.Contains(doc, "Grace Hopper") || !strings.Contains(doc, hashedValuePrefix) {
+			t.Errorf("%s: privacy.hash_fields not applied to reviewer names:\n%s", dir, doc)
+		}
+	}
+
+	// Without repository admin the repository is skipped, not failed
+	if err := b.savePolicies(context.Background(), "run/locked", "latest/locked", &api.Repository{Slug: "locked"}); err != nil {
+		t.Errorf("restricted settings should be skipped, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(b.storage.BasePath(), "run/locked", PoliciesFileName)); !os.IsNotExist(err) {
+		t.Errorf("policies.md written for a restricted repository: %v", err)
+	}
+}
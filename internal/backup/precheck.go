@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"context"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// refsUnchanged reports whether the refs Bitbucket advertises for a
+// repository match its mirror exactly, so the fetch can be skipped. Only
+// an existing mirror with git.precheck_refs set is checked; any error
+// reads as changed and the fetch runs as usual.
+func (b *Backup) refsUnchanged(ctx context.Context, gitPath string, repo *api.Repository) bool {
+	cloneURL := b.cfg.Git.CloneURL(repo.CloneURL())
+	if !b.cfg.Git.PrecheckRefs || b.opts.DryRun || b.gitClient == nil || cloneURL == "" || !isValidGitRepo(gitPath) {
+		return false
+	}
+	prefix := api.LogPrefix(ctx)
+
+	local, err := git.ReadRefs(gitPath)
+	if err != nil {
+		b.log.Debug("%sRef precheck for %s: reading mirror refs: %v", prefix, repo.Slug, err)
+		return false
+	}
+	remote, err := b.gitClient.ListRemoteRefs(ctx, cloneURL)
+	if err != nil {
+		b.log.Debug("%sRef precheck for %s: %v", prefix, repo.Slug, err)
+		return false
+	}
+	if changed := git.DiffRefs(local, remote); len(changed) > 0 {
+		b.log.Debug("%sRef precheck for %s: %d refs changed, fetching", prefix, repo.Slug, len(changed))
+		return false
+	}
+	b.log.Debug("%sRefs for %s match the mirror (%d refs), skipping fetch", prefix, repo.Slug, len(remote))
+	return true
+}
@@ -0,0 +1,61 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+func TestRefsUnchanged(t *testing.T) {
+	if !git.IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	mirror := filepath.Join(dir, "repo.git")
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q", "-b", "main", src)
+	run("-C", src, "commit", "-q", "--allow-empty", "-m", "one")
+	run("clone", "-q", "--mirror", src, mirror)
+
+	repo := &api.Repository{Slug: "core-api"}
+	repo.Links.Clone = []api.Link{{Name: "https", Href: src}}
+	b := &Backup{
+		cfg:       &config.Config{Git: config.GitConfig{PrecheckRefs: true}},
+		log:       &defaultLogger{quiet: true},
+		gitClient: git.NewGoGitClient(),
+	}
+	ctx := context.Background()
+
+	if !b.refsUnchanged(ctx, mirror, repo) {
+		t.Error("refsUnchanged() = false for an up-to-date mirror")
+	}
+	if b.refsUnchanged(ctx, filepath.Join(dir, "missing.git"), repo) {
+		t.Error("refsUnchanged() = true without a mirror")
+	}
+
+	run("-C", src, "branch", "feature")
+	if b.refsUnchanged(ctx, mirror, repo) {
+		t.Error("refsUnchanged() = true after a new branch")
+	}
+
+	b.cfg.Git.PrecheckRefs = false
+	run("-C", mirror, "fetch", "-q")
+	if b.refsUnchanged(ctx, mirror, repo) {
+		t.Error("refsUnchanged() = true with git.precheck_refs off")
+	}
+}
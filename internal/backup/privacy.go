@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// hashedValuePrefix marks a value replaced by privacy.hash_fields.
+const hashedValuePrefix = "hmac-sha256:"
+
+// PrivacyPolicy is the data minimization applied to saved metadata, as
+// recorded in the manifest. The salt itself is never recorded.
+type PrivacyPolicy struct {
+	DropFields []string `json:"drop_fields,omitempty"`
+	HashFields []string `json:"hash_fields,omitempty"`
+	StripHTML  bool     `json:"strip_html,omitempty"`
+}
+
+// privacyFilter rewrites entities before they are saved according to the
+// privacy config.
+type privacyFilter struct {
+	policy PrivacyPolicy
+	drop   map[string]bool
+	hash   map[string]bool
+	salt   []byte
+}
+
+// newPrivacyFilter returns a filter for the config, or nil if nothing is
+// to be minimized.
+func newPrivacyFilter(cfg config.PrivacyConfig) *privacyFilter {
+	if !cfg.Enabled() {
+		return nil
+	}
+	f := &privacyFilter{
+		policy: PrivacyPolicy{
+			DropFields: sortedCopy(cfg.DropFields),
+			HashFields: sortedCopy(cfg.HashFields),
+			StripHTML:  cfg.StripHTML,
+		},
+		drop: make(map[string]bool),
+		hash: make(map[string]bool),
+		salt: []byte(cfg.HashSalt),
+	}
+	for _, field := range cfg.DropFields {
+		f.drop[field] = true
+	}
+	for _, field := range cfg.HashFields {
+		f.hash[field] = true
+	}
+	return f
+}
+
+func sortedCopy(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	out := append([]string(nil), s...)
+	sort.Strings(out)
+	return out
+}
+
+// apply returns data as generic JSON with the policy applied. Numbers are
+// kept as written so IDs and timestamps survive the round trip unchanged.
+func (f *privacyFilter) apply(data interface{}) (interface{}, error) {
+	v, err := genericJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return f.walk("", v), nil
+}
+
+// genericJSON returns data as generic JSON, keeping numbers as written.
+func genericJSON(data interface{}) (interface{}, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling JSON: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decoding JSON: %w", err)
+	}
+	return v, nil
+}
+
+// walk applies the policy to v, whose key in its parent object is parent.
+func (f *privacyFilter) walk(parent string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// Rendered content is {"raw", "markup", "html"}; links also have
+		// an "html" key, so only drop it beside markup
+		if _, rendered := v["markup"]; f.policy.StripHTML && rendered {
+			delete(v, "html")
+		}
+		for key, value := range v {
+			switch {
+			case f.matches(f.drop, parent, key):
+				delete(v, key)
+			case f.matches(f.hash, parent, key):
+				if s, ok := value.(string); ok && s != "" {
+					v[key] = f.hashValue(s)
+				}
+			default:
+				v[key] = f.walk(key, value)
+			}
+		}
+	case []interface{}:
+		// Array elements are addressed by the array's key
+		for i := range v {
+			v[i] = f.walk(parent, v[i])
+		}
+	}
+	return v
+}
+
+// matches reports whether key, under parent, is one of fields.
+func (f *privacyFilter) matches(fields map[string]bool, parent, key string) bool {
+	return fields[key] || (parent != "" && fields[parent+"."+key])
+}
+
+// hashValue replaces s with a keyed hash. The same input always maps to
+// the same output, so values can still be correlated across files.
+func (f *privacyFilter) hashValue(s string) string {
+	mac := hmac.New(sha256.New, f.salt)
+	mac.Write([]byte(s))
+	return hashedValuePrefix + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// saveEntity saves Bitbucket data (as opposed to bb-backup's own files)
+// with field masks and the privacy policy applied.
+func (b *Backup) saveEntity(dir, filename string, data interface{}) error {
+	data, err := b.renderEntity(dir, filename, data)
+	if err != nil {
+		return err
+	}
+	return b.saveJSON(dir, filename, data)
+}
+
+// renderEntity returns data as saveEntity saves it to filename in dir:
+// with the field masks of its kind and then the privacy policy applied.
+func (b *Backup) renderEntity(dir, filename string, data interface{}) (interface{}, error) {
+	data, err := b.applyFieldMasks(entityKind(dir, filename), data)
+	if err != nil {
+		return nil, err
+	}
+	return b.minimize(data)
+}
+
+// minimize applies the privacy policy to an entity, if one is configured.
+func (b *Backup) minimize(data interface{}) (interface{}, error) {
+	if b.privacy == nil {
+		return data, nil
+	}
+	return b.privacy.apply(data)
+}
+
+// privacyPolicy returns the policy for the manifest, or nil.
+func (b *Backup) privacyPolicy() *PrivacyPolicy {
+	if b.privacy == nil {
+		return nil
+	}
+	policy := b.privacy.policy
+	return &policy
+}
@@ -0,0 +1,114 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+const privacyTestPR = `{
+  "id": 9007199254740993,
+  "title": "Fix login",
+  "author": {"display_name": "Ada Lovelace", "uuid": "{1}", "links": {"html": {"href": "https://bitbucket.org/ada"}}},
+  "participants": [{"user": {"display_name": "Ada Lovelace", "email": "ada@example.com"}}],
+  "summary": {"raw": "See #1", "markup": "markdown", "html": "<p>See #1</p>"},
+  "commit": {"author": {"raw": "Ada <ada@example.com>"}}
+}`
+
+func applyPrivacy(t *testing.T, cfg config.PrivacyConfig, input string) map[string]interface{} {
+	t.Helper()
+	out, err := newPrivacyFilter(cfg).apply(json.RawMessage(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(out)
+	var m map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestPrivacyFilter(t *testing.T) {
+	if newPrivacyFilter(config.PrivacyConfig{}) != nil {
+		t.Error("empty config should not build a filter")
+	}
+
+	m := applyPrivacy(t, config.PrivacyConfig{
+		DropFields: []string{"email"},
+		HashFields: []string{"display_name", "author.raw"},
+		StripHTML:  true,
+		HashSalt:   "s3cret",
+	}, privacyTestPR)
+
+	if id := m["id"].(json.Number).String(); id != "9007199254740993" {
+		t.Errorf("id = %s; large numbers must survive unchanged", id)
+	}
+	author := m["author"].(map[string]interface{})
+	name := author["display_name"].(string)
+	if !strings.HasPrefix(name, hashedValuePrefix) {
+		t.Errorf("display_name = %q, want hashed", name)
+	}
+	if author["uuid"] != "{1}" {
+		t.Error("unlisted fields must be kept")
+	}
+	if _, ok := author["links"].(map[string]interface{})["html"]; !ok {
+		t.Error("links.html is a link, not a rendering, and must be kept")
+	}
+
+	user := m["participants"].([]interface{})[0].(map[string]interface{})["user"].(map[string]interface{})
+	if _, ok := user["email"]; ok {
+		t.Error("email should be dropped inside arrays too")
+	}
+	if user["display_name"] != name {
+		t.Error("the same value must hash the same everywhere")
+	}
+
+	summary := m["summary"].(map[string]interface{})
+	if _, ok := summary["html"]; ok || summary["raw"] != "See #1" {
+		t.Errorf("summary = %v, want html stripped and raw kept", summary)
+	}
+	commitAuthor := m["commit"].(map[string]interface{})["author"].(map[string]interface{})
+	if raw := commitAuthor["raw"].(string); !strings.HasPrefix(raw, hashedValuePrefix) {
+		t.Errorf("author.raw = %q, want hashed", raw)
+	}
+
+	// A different salt gives unrelated hashes
+	other := applyPrivacy(t, config.PrivacyConfig{HashFields: []string{"display_name"}, HashSalt: "other"}, privacyTestPR)
+	if other["author"].(map[string]interface{})["display_name"] == name {
+		t.Error("hashes should depend on the salt")
+	}
+}
+
+func TestSaveEntity_Privacy(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.cfg.Privacy = config.PrivacyConfig{DropFields: []string{"email"}, StripHTML: true}
+	b.privacy = newPrivacyFilter(b.cfg.Privacy)
+
+	pr := json.RawMessage(privacyTestPR)
+	if err := b.saveEntity("ws/latest", "1.json", pr); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(b.storage.BasePath(), "ws/latest/1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "ada@example.com\"") || strings.Contains(string(data), "<p>") {
+		t.Errorf("saved entity not minimized:\n%s", data)
+	}
+	// Incremental runs compare against the minimized copy
+	if !b.unchangedInLatest("ws/latest", "1.json", pr) {
+		t.Error("an unchanged entity should match its minimized copy")
+	}
+
+	policy := b.privacyPolicy()
+	if policy == nil || !policy.StripHTML || len(policy.DropFields) != 1 {
+		t.Errorf("manifest policy = %+v", policy)
+	}
+}
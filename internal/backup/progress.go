@@ -1,19 +1,26 @@
 package backup
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/andy-wilson/bb-backup/internal/git"
 	"github.com/andy-wilson/bb-backup/internal/ui"
 )
 
-// Progress tracks and reports backup progress.
+// Progress tracks backup progress and fans events out to one or more sinks,
+// so a JSON stream for automation and the interactive bar for a human can
+// run side by side.
 type Progress struct {
-	mu           sync.Mutex   // Only for current string and non-atomic operations
+	mu           sync.Mutex // Serializes events so sinks see them in order
 	startTime    time.Time
 	total        int64
 	completed    atomic.Int64 // Lock-free counter
@@ -26,24 +33,119 @@ type Progress struct {
 	interactive  bool
 	lastUpdate   time.Time
 	updatePeriod time.Duration
-	progressBar  *ui.ProgressBar
+	plain        bool          // Use heartbeat lines instead of the redrawing bar
+	plainPeriod  time.Duration // Interval between heartbeat lines (0 = default)
+	sinks        []ProgressSink
+	closed       bool
+
+	// History-based ETA; nil pending disables it
+	pending    map[string]time.Duration // Expected duration of unfinished repos
+	etaWorkers int
+
+	// Git transfers in flight, by repository, for live displays
+	transfers    map[string]*transfer
+	lastTransfer time.Time
 }
 
+// transfer is the latest progress of a repository's clone or fetch.
+type transfer struct {
+	stage          string // A git.Stage* object stage, or "" before the first
+	current, total int64  // Objects in stage
+	bytes          int64  // Received so far
+	started        time.Time
+}
+
+// transferPeriod is the least time between status events from transfers.
+const transferPeriod = 250 * time.Millisecond
+
+// maxTransfersShown caps the transfers in the status line so it fits on
+// one terminal line.
+const maxTransfersShown = 3
+
+// ProgressOption configures a Progress.
+type ProgressOption func(*Progress)
+
+// WithPlainProgress renders interactive progress as a plain heartbeat line
+// every interval (0 uses ui.DefaultHeartbeatInterval) instead of a bar.
+func WithPlainProgress(interval time.Duration) ProgressOption {
+	return func(p *Progress) {
+		p.plain = true
+		p.plainPeriod = interval
+	}
+}
+
+// WithProgressSink attaches an additional sink, such as a file or HTTP
+// push sink, alongside the ones selected by the output flags.
+func WithProgressSink(sink ProgressSink) ProgressOption {
+	return func(p *Progress) {
+		p.sinks = append(p.sinks, sink)
+	}
+}
+
+// WithExpectedDurations estimates the ETA from the usual duration of each
+// repository still to run, spread over workers, instead of the average so
+// far. Repositories in slugs without an expected duration count as the
+// mean of those with one.
+func WithExpectedDurations(slugs []string, expected map[string]time.Duration, workers int) ProgressOption {
+	return func(p *Progress) {
+		if len(expected) == 0 {
+			return
+		}
+		var sum time.Duration
+		for _, d := range expected {
+			sum += d
+		}
+		mean := sum / time.Duration(len(expected))
+		p.pending = make(map[string]time.Duration, len(slugs))
+		for _, slug := range slugs {
+			d, ok := expected[slug]
+			if !ok {
+				d = mean
+			}
+			p.pending[slug] = d
+		}
+		p.etaWorkers = max(workers, 1)
+	}
+}
+
+// Progress event types. Status events carry the current activity for live
+// displays and are not written to event streams.
+const (
+	ProgressEventStart    = "start"
+	ProgressEventComplete = "complete"
+	ProgressEventFail     = "fail"
+	ProgressEventDefer    = "defer"
+	ProgressEventProgress = "progress"
+	ProgressEventStatus   = "status"
+	ProgressEventShutdown = "shutdown"
+	ProgressEventSummary  = "summary"
+)
+
 // ProgressEvent represents a progress update in JSON format.
 type ProgressEvent struct {
-	Type       string  `json:"type"`
-	Timestamp  string  `json:"timestamp"`
-	Total      int     `json:"total"`
-	Completed  int     `json:"completed"`
-	Failed     int     `json:"failed"`
-	Percent    float64 `json:"percent"`
-	Current    string  `json:"current,omitempty"`
-	Message    string  `json:"message,omitempty"`
-	ElapsedSec float64 `json:"elapsed_seconds"`
+	Type        string  `json:"type"`
+	Timestamp   string  `json:"timestamp"`
+	Total       int     `json:"total"`
+	Completed   int     `json:"completed"`
+	Failed      int     `json:"failed"`
+	Interrupted int     `json:"interrupted,omitempty"`
+	Active      int     `json:"active,omitempty"`
+	Percent     float64 `json:"percent"`
+	Repo        string  `json:"repo,omitempty"`
+	Current     string  `json:"current,omitempty"`
+	Message     string  `json:"message,omitempty"`
+	Code        string  `json:"code,omitempty"` // Failure code of a fail event
+	ElapsedSec  float64 `json:"elapsed_seconds"`
+	ETASec      float64 `json:"eta_seconds,omitempty"` // From repository history, when known
+
+	// Summary is set on the summary event at the end of a run
+	Summary *RunSummary `json:"summary,omitempty"`
 }
 
-// NewProgress creates a new progress tracker.
-func NewProgress(total int, jsonOutput, quiet, interactive bool) *Progress {
+// NewProgress creates a new progress tracker. jsonOutput streams events to
+// stdout, interactive adds the progress bar on stderr, and otherwise text
+// lines go to stdout unless quiet. Extra sinks come from WithProgressSink.
+func NewProgress(total int, jsonOutput, quiet, interactive bool, opts ...ProgressOption) *Progress {
 	p := &Progress{
 		startTime:    time.Now(),
 		total:        int64(total),
@@ -52,12 +154,30 @@ func NewProgress(total int, jsonOutput, quiet, interactive bool) *Progress {
 		interactive:  interactive,
 		updatePeriod: 500 * time.Millisecond,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
 
-	// Create progress bar for interactive mode
-	if interactive && !jsonOutput && !quiet {
-		p.progressBar = ui.NewProgressBar(total, ui.WithTwoLineMode())
-		p.progressBar.Start()
+	var defaults []ProgressSink
+	if jsonOutput {
+		defaults = append(defaults, NewJSONProgressSink(os.Stdout))
 	}
+	switch {
+	case interactive && !quiet:
+		barOpts := []ui.ProgressBarOption{ui.WithTwoLineMode()}
+		if p.plain {
+			barOpts = append(barOpts, ui.WithPlainMode(), ui.WithUpdateInterval(p.plainPeriod))
+		}
+		// Keep stdout clean for the JSON stream when both are enabled
+		summaryOut := io.Writer(os.Stdout)
+		if jsonOutput {
+			summaryOut = os.Stderr
+		}
+		defaults = append(defaults, newBarProgressSink(ui.NewProgressBar(total, barOpts...), summaryOut))
+	case !jsonOutput && !quiet:
+		defaults = append(defaults, NewTextProgressSink(os.Stdout))
+	}
+	p.sinks = append(defaults, p.sinks...)
 
 	return p
 }
@@ -69,85 +189,56 @@ func (p *Progress) Start(name string) {
 
 // StartWithType marks the start of a new item with a type indicator (e.g., "updating", "cloning").
 func (p *Progress) StartWithType(name, itemType string) {
-	activeCount := p.active.Add(1) // Increment active counter
+	p.active.Add(1) // Increment active counter
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	message := "Starting: " + name
 	if itemType != "" {
 		p.current = itemType + ": " + name
+		message = p.current
 	} else {
 		p.current = name
 	}
-
-	if p.progressBar != nil {
-		// Show active count when multiple workers are running
-		if activeCount > 1 {
-			p.progressBar.SetCurrent(fmt.Sprintf("%d repos in progress", activeCount))
-		} else {
-			p.progressBar.SetCurrent(p.current)
-		}
-	} else {
-		if itemType != "" {
-			p.emit("start", fmt.Sprintf("%s: %s", itemType, name))
-		} else {
-			p.emit("start", fmt.Sprintf("Starting: %s", name))
-		}
-	}
+	p.emitLocked(ProgressEventStart, name, message)
 }
 
 // Complete marks an item as completed.
 func (p *Progress) Complete(name string) {
-	p.completed.Add(1)       // Atomic increment
-	activeCount := p.active.Add(-1) // Decrement active counter
+	p.completed.Add(1) // Atomic increment
+	p.active.Add(-1)   // Decrement active counter
 
 	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.current = ""
-	p.mu.Unlock()
-
-	if p.progressBar != nil {
-		p.progressBar.Complete(name)
-		// Update status to reflect remaining active count
-		if activeCount > 1 {
-			p.progressBar.SetCurrent(fmt.Sprintf("%d repos in progress", activeCount))
-		} else if activeCount == 1 {
-			p.progressBar.SetCurrent("1 repo in progress")
-		} else {
-			// activeCount == 0: nothing in progress
-			p.progressBar.SetCurrent("")
-		}
-	} else {
-		p.mu.Lock()
-		p.emitProgress("complete", fmt.Sprintf("Completed: %s", name))
-		p.mu.Unlock()
-	}
+	delete(p.pending, name)
+	p.emitLocked(ProgressEventComplete, name, "Completed: "+name)
 }
 
 // Fail marks an item as failed.
 func (p *Progress) Fail(name string, err error) {
-	p.failed.Add(1)          // Atomic increment
-	activeCount := p.active.Add(-1) // Decrement active counter
+	p.failed.Add(1)  // Atomic increment
+	p.active.Add(-1) // Decrement active counter
 
 	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.current = ""
-	p.mu.Unlock()
+	delete(p.pending, name)
+	event := p.eventLocked(ProgressEventFail, name, fmt.Sprintf("Failed: %s - %v", name, err))
+	event.Code = string(errcode.Of(err))
+	p.sendLocked(event)
+}
 
-	if p.progressBar != nil {
-		p.progressBar.Fail(name)
-		// Update status to reflect remaining active count
-		if activeCount > 1 {
-			p.progressBar.SetCurrent(fmt.Sprintf("%d repos in progress", activeCount))
-		} else if activeCount == 1 {
-			p.progressBar.SetCurrent("1 repo in progress")
-		} else {
-			// activeCount == 0: nothing in progress
-			p.progressBar.SetCurrent("")
-		}
-	} else {
-		p.mu.Lock()
-		p.emitProgress("fail", fmt.Sprintf("Failed: %s - %v", name, err))
-		p.mu.Unlock()
-	}
+// Defer marks an item as left for a later run. It counts as completed, as
+// it needs nothing more from this run.
+func (p *Progress) Defer(name string, reason error) {
+	p.completed.Add(1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pending, name)
+	p.emitLocked(ProgressEventDefer, name, fmt.Sprintf("Deferred: %s - %v", name, reason))
 }
 
 // Update emits a progress update if enough time has passed.
@@ -158,8 +249,95 @@ func (p *Progress) Update() {
 	if time.Since(p.lastUpdate) < p.updatePeriod {
 		return
 	}
+	p.lastUpdate = time.Now()
+	p.emitLocked(ProgressEventProgress, "", "")
+}
+
+// Transfer records the progress of a repository's clone or fetch, as
+// reported by git (see git.WithProgressFunc), and shows the transfers in
+// flight as the current status. Updates are frequent, so status events
+// are sent at most every transferPeriod.
+func (p *Progress) Transfer(name, stage string, current, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	p.emitProgress("progress", "")
+	if p.transfers == nil {
+		p.transfers = make(map[string]*transfer)
+	}
+	t := p.transfers[name]
+	if t == nil {
+		t = &transfer{started: time.Now()}
+		p.transfers[name] = t
+	}
+	if stage == git.StageReceived {
+		t.bytes = current
+	} else {
+		t.stage, t.current, t.total = stage, current, total
+	}
+
+	if time.Since(p.lastTransfer) < transferPeriod {
+		return
+	}
+	p.lastTransfer = time.Now()
+	p.current = p.transferStatusLocked()
+	p.emitLocked(ProgressEventStatus, "", "")
+}
+
+// EndTransfer forgets a repository's transfer once its git operations are
+// done.
+func (p *Progress) EndTransfer(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.transfers, name)
+}
+
+// transferStatusLocked describes the transfers in flight, e.g.
+// "core-api: receiving objects 45% (450/1000), 12.0 MB at 3.1 MB/s", or
+// for several "3 transfers: core-api 45% 12.0 MB, web 10%, docs 2.0 KB"
+// (caller must hold p.mu).
+func (p *Progress) transferStatusLocked() string {
+	names := make([]string, 0, len(p.transfers))
+	for name := range p.transfers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) == 1 {
+		return names[0] + ": " + p.transfers[names[0]].describe(true)
+	}
+	parts := make([]string, 0, maxTransfersShown+1)
+	for i, name := range names {
+		if i == maxTransfersShown {
+			parts = append(parts, fmt.Sprintf("+%d more", len(names)-i))
+			break
+		}
+		parts = append(parts, name+" "+p.transfers[name].describe(false))
+	}
+	return fmt.Sprintf("%d transfers: %s", len(names), strings.Join(parts, ", "))
+}
+
+// describe formats a transfer; detailed adds the stage, object counts, and
+// rate.
+func (t *transfer) describe(detailed bool) string {
+	var parts []string
+	if t.total > 0 {
+		pct := format.Percent(float64(t.current) / float64(t.total) * 100)
+		if detailed {
+			pct = fmt.Sprintf("%s %s (%d/%d)", t.stage, pct, t.current, t.total)
+		}
+		parts = append(parts, pct)
+	}
+	if t.bytes > 0 {
+		size := format.Bytes(t.bytes)
+		if detailed {
+			size += " at " + format.Rate(t.bytes, time.Since(t.started))
+		}
+		parts = append(parts, size)
+	}
+	if len(parts) == 0 {
+		return "connecting"
+	}
+	return strings.Join(parts, ", ")
 }
 
 // Interrupt marks an item as interrupted (e.g., by CTRL-C).
@@ -170,16 +348,19 @@ func (p *Progress) Interrupt(name string) {
 	p.mu.Lock()
 	p.current = ""
 	p.mu.Unlock()
-	// Don't update progress bar - just track the count
+	// Don't notify sinks - just track the count
 }
 
-// Summary prints the final summary.
-func (p *Progress) Summary() {
-	// Stop progress bar if running
-	if p.progressBar != nil {
-		p.progressBar.Stop()
-	}
+// Shutdown tells sinks that the run is being interrupted so live displays
+// can stop redrawing before shutdown messages are printed.
+func (p *Progress) Shutdown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emitLocked(ProgressEventShutdown, "", "")
+}
 
+// Summary emits the final summary, with the run's overview when given.
+func (p *Progress) Summary(summary *RunSummary) {
 	completed := p.completed.Load()
 	failed := p.failed.Load()
 	interrupted := p.interrupted.Load()
@@ -188,68 +369,88 @@ func (p *Progress) Summary() {
 	var msg string
 	if interrupted > 0 {
 		msg = fmt.Sprintf("Backup complete: %d/%d succeeded, %d failed, %d interrupted in %s",
-			completed, p.total, failed, interrupted, elapsed.Round(time.Second))
+			completed, p.total, failed, interrupted, format.Duration(elapsed))
 	} else {
 		msg = fmt.Sprintf("Backup complete: %d/%d succeeded, %d failed in %s",
-			completed, p.total, failed, elapsed.Round(time.Second))
-	}
-
-	// For interactive mode, print the summary after progress bar stops
-	if p.interactive && !p.jsonOutput && !p.quiet {
-		fmt.Printf("\n%s\n", msg)
-		return
+			completed, p.total, failed, format.Duration(elapsed))
 	}
 
 	p.mu.Lock()
-	p.emit("summary", msg)
-	p.mu.Unlock()
+	defer p.mu.Unlock()
+	event := p.eventLocked(ProgressEventSummary, "", msg)
+	event.Summary = summary
+	p.sendLocked(event)
 }
 
-// emitProgress emits a progress event with rate limiting for text output.
-func (p *Progress) emitProgress(eventType, message string) {
-	if p.quiet && !p.jsonOutput {
-		return
+// Close closes every sink, flushing file and HTTP sinks. It is safe to
+// call more than once.
+func (p *Progress) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
 	}
+	p.closed = true
 
-	now := time.Now()
-	if !p.jsonOutput && time.Since(p.lastUpdate) < p.updatePeriod && eventType == "progress" {
-		return
+	var firstErr error
+	for _, sink := range p.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	p.lastUpdate = now
+	return firstErr
+}
 
-	p.emitLocked(eventType, message)
+// emitLocked builds an event from the current counters and hands it to
+// every sink (caller must hold p.mu).
+func (p *Progress) emitLocked(eventType, repo, message string) {
+	p.sendLocked(p.eventLocked(eventType, repo, message))
 }
 
-// emit emits a progress event unconditionally.
-func (p *Progress) emit(eventType, message string) {
-	if p.quiet && !p.jsonOutput {
-		return
+// eventLocked builds an event from the current counters (caller must hold
+// p.mu).
+func (p *Progress) eventLocked(eventType, repo, message string) ProgressEvent {
+	return ProgressEvent{
+		Type:        eventType,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Total:       int(p.total),
+		Completed:   int(p.completed.Load()),
+		Failed:      int(p.failed.Load()),
+		Interrupted: int(p.interrupted.Load()),
+		Active:      int(p.active.Load()),
+		Percent:     p.percent(),
+		Repo:        repo,
+		Current:     p.current,
+		Message:     message,
+		ElapsedSec:  time.Since(p.startTime).Seconds(),
+		ETASec:      p.etaLocked().Seconds(),
 	}
-	p.emitLocked(eventType, message)
 }
 
-// emitLocked emits the event (caller must hold lock for current string).
-func (p *Progress) emitLocked(eventType, message string) {
-	completed := p.completed.Load()
-	failed := p.failed.Load()
+// sendLocked hands an event to every sink unless the sinks are closed
+// (caller must hold p.mu).
+func (p *Progress) sendLocked(event ProgressEvent) {
+	if p.closed {
+		return
+	}
+	for _, sink := range p.sinks {
+		sink.Handle(event)
+	}
+}
 
-	if p.jsonOutput {
-		event := ProgressEvent{
-			Type:       eventType,
-			Timestamp:  time.Now().UTC().Format(time.RFC3339),
-			Total:      int(p.total),
-			Completed:  int(completed),
-			Failed:     int(failed),
-			Percent:    p.percent(),
-			Current:    p.current,
-			Message:    message,
-			ElapsedSec: time.Since(p.startTime).Seconds(),
-		}
-		data, _ := json.Marshal(event)
-		_, _ = fmt.Fprintln(os.Stdout, string(data))
-	} else if message != "" {
-		fmt.Printf("[%d/%d] %s\n", completed+failed, p.total, message)
+// etaLocked estimates the time left from the expected durations of the
+// unfinished repositories: their total spread over the workers, but never
+// less than the longest of them. It returns 0 without history.
+func (p *Progress) etaLocked() time.Duration {
+	if p.pending == nil {
+		return 0
+	}
+	var sum, longest time.Duration
+	for _, d := range p.pending {
+		sum += d
+		longest = max(longest, d)
 	}
+	return max(sum/time.Duration(p.etaWorkers), longest)
 }
 
 // percent calculates completion percentage.
@@ -269,10 +470,7 @@ func (p *Progress) GetStats() (completed, failed int) {
 // Used to show metadata fetch progress (e.g., "fetching PRs: repo-name (5/10)").
 func (p *Progress) UpdateStatus(status string) {
 	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.current = status
-	p.mu.Unlock()
-
-	if p.progressBar != nil {
-		p.progressBar.SetCurrent(status)
-	}
+	p.emitLocked(ProgressEventStatus, "", "")
 }
@@ -1,7 +1,13 @@
 package backup
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/git"
 )
 
 func TestNewProgress(t *testing.T) {
@@ -78,6 +84,18 @@ func TestProgress_Fail(t *testing.T) {
 	}
 }
 
+func TestProgress_FailCode(t *testing.T) {
+	var events recordingSink
+	p := NewProgress(1, false, true, false, WithProgressSink(&events))
+	p.Start("repo")
+	p.Fail("repo", fmt.Errorf("backing up: %w", errcode.New(errcode.GitTimeout, "git fetch timed out after 30 minutes")))
+
+	last := events.events[len(events.events)-1]
+	if last.Type != ProgressEventFail || last.Code != "GIT_TIMEOUT" {
+		t.Errorf("fail event = %+v, want code GIT_TIMEOUT", last)
+	}
+}
+
 func TestProgress_Interrupt(t *testing.T) {
 	p := NewProgress(2, false, true, false) // quiet mode
 
@@ -160,6 +178,47 @@ func TestProgress_UpdateStatus(t *testing.T) {
 	}
 }
 
+func TestProgress_Transfer(t *testing.T) {
+	var events recordingSink
+	p := NewProgress(3, false, true, false, WithProgressSink(&events))
+
+	p.Transfer("core-api", git.StageReceiving, 450, 1000)
+	p.Transfer("core-api", git.StageReceived, 12<<20, 0)
+	p.lastTransfer = time.Time{}
+	p.Transfer("core-api", git.StageReceiving, 500, 1000)
+	if !strings.HasPrefix(p.current, "core-api: receiving objects 50% (500/1000), 12.0 MB at ") {
+		t.Errorf("current = %q", p.current)
+	}
+	if last := events.events[len(events.events)-1]; last.Type != ProgressEventStatus || last.Current != p.current {
+		t.Errorf("last event = %+v, want the transfer status", last)
+	}
+
+	// Updates within transferPeriod are recorded without an event
+	sent := len(events.events)
+	p.Transfer("web", git.StageCounting, 1, 10)
+	if len(events.events) != sent {
+		t.Errorf("sent %d events within transferPeriod", len(events.events)-sent)
+	}
+
+	for _, name := range []string{"docs", "infra"} {
+		p.Transfer(name, git.StageReceived, 2048, 0)
+	}
+	p.lastTransfer = time.Time{}
+	p.Transfer("zeta", "", 0, 0)
+	if want := "5 transfers: core-api 50%, 12.0 MB, docs 2.0 KB, infra 2.0 KB, +2 more"; p.current != want {
+		t.Errorf("current = %q, want %q", p.current, want)
+	}
+
+	for _, name := range []string{"core-api", "web", "docs", "infra"} {
+		p.EndTransfer(name)
+	}
+	p.lastTransfer = time.Time{}
+	p.Transfer("zeta", git.StageReceived, 0, 0)
+	if p.current != "zeta: connecting" {
+		t.Errorf("current = %q after the other transfers ended", p.current)
+	}
+}
+
 func TestProgress_ConcurrentStartComplete(t *testing.T) {
 	p := NewProgress(100, false, true, false) // quiet mode
 
@@ -203,5 +262,24 @@ func TestProgress_Summary(t *testing.T) {
 	p.Fail("repo2", nil)
 
 	// Summary should not panic
-	p.Summary()
+	p.Summary(nil)
+}
+
+func TestProgress_ExpectedDurationsETA(t *testing.T) {
+	expected := map[string]time.Duration{"a": 10 * time.Minute, "b": 2 * time.Minute}
+	p := NewProgress(3, false, true, false, WithExpectedDurations([]string{"a", "b", "c"}, expected, 2))
+
+	// c has no history and counts as the mean (6m): (10+2+6)/2 = 9m, but
+	// never less than the longest remaining repo (10m)
+	if eta := p.etaLocked(); eta != 10*time.Minute {
+		t.Errorf("initial ETA = %s, want 10m", eta)
+	}
+	p.Complete("a")
+	if eta := p.etaLocked(); eta != 6*time.Minute {
+		t.Errorf("ETA after a = %s, want 6m", eta)
+	}
+
+	if eta := NewProgress(1, false, true, false).etaLocked(); eta != 0 {
+		t.Errorf("ETA without history = %s, want 0", eta)
+	}
 }
@@ -0,0 +1,227 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/ui"
+)
+
+// ProgressSink receives progress events. Progress serializes calls, so
+// sinks see events in order and need no locking of their own; Handle must
+// not block for long because workers wait on it.
+type ProgressSink interface {
+	Handle(event ProgressEvent)
+	Close() error
+}
+
+// textProgressSink prints human-readable "[n/total] message" lines.
+type textProgressSink struct {
+	w io.Writer
+}
+
+// NewTextProgressSink returns a sink that writes one line per start,
+// completion, failure, and summary.
+func NewTextProgressSink(w io.Writer) ProgressSink {
+	return &textProgressSink{w: w}
+}
+
+func (s *textProgressSink) Handle(event ProgressEvent) {
+	if event.Message == "" || event.Type == ProgressEventStatus {
+		return
+	}
+	_, _ = fmt.Fprintf(s.w, "[%d/%d] %s\n", event.Completed+event.Failed, event.Total, event.Message)
+	if event.Summary != nil {
+		_, _ = fmt.Fprintln(s.w)
+		event.Summary.WriteTable(s.w)
+	}
+}
+
+func (s *textProgressSink) Close() error { return nil }
+
+// jsonProgressSink writes one JSON object per event.
+type jsonProgressSink struct {
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewJSONProgressSink returns a sink that writes events as JSON lines to w.
+// Status events are omitted to keep the stream compact.
+func NewJSONProgressSink(w io.Writer) ProgressSink {
+	return &jsonProgressSink{w: w}
+}
+
+// OpenProgressFile returns a JSON lines sink appending to path, so a
+// long-running job can be followed with tail -f.
+func OpenProgressFile(path string) (ProgressSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening progress file: %w", err)
+	}
+	return &jsonProgressSink{w: f, closer: f}, nil
+}
+
+func (s *jsonProgressSink) Handle(event ProgressEvent) {
+	if event.Type == ProgressEventStatus {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, _ = s.w.Write(append(data, '\n'))
+}
+
+func (s *jsonProgressSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// barProgressSink drives the interactive progress bar.
+type barProgressSink struct {
+	bar        *ui.ProgressBar
+	summaryOut io.Writer
+}
+
+func newBarProgressSink(bar *ui.ProgressBar, summaryOut io.Writer) *barProgressSink {
+	bar.Start()
+	return &barProgressSink{bar: bar, summaryOut: summaryOut}
+}
+
+func (s *barProgressSink) Handle(event ProgressEvent) {
+	switch event.Type {
+	case ProgressEventStart:
+		// Show active count when multiple workers are running
+		if event.Active > 1 {
+			s.bar.SetCurrent(fmt.Sprintf("%d repos in progress", event.Active))
+		} else {
+			s.bar.SetCurrent(event.Current)
+		}
+	case ProgressEventComplete, ProgressEventFail, ProgressEventDefer:
+		if event.ETASec > 0 {
+			s.bar.SetETA(time.Duration(event.ETASec * float64(time.Second)))
+		}
+		if event.Type == ProgressEventFail {
+			s.bar.Fail(event.Repo)
+		} else {
+			s.bar.Complete(event.Repo)
+		}
+		// Update status to reflect remaining active count
+		switch {
+		case event.Active > 1:
+			s.bar.SetCurrent(fmt.Sprintf("%d repos in progress", event.Active))
+		case event.Active == 1:
+			s.bar.SetCurrent("1 repo in progress")
+		default:
+			s.bar.SetCurrent("")
+		}
+	case ProgressEventStatus:
+		s.bar.SetCurrent(event.Current)
+	case ProgressEventShutdown:
+		s.bar.Stop()
+	case ProgressEventSummary:
+		// Print the summary after the bar stops
+		s.bar.Stop()
+		_, _ = fmt.Fprintf(s.summaryOut, "\n%s\n", event.Message)
+		if event.Summary != nil {
+			_, _ = fmt.Fprintln(s.summaryOut)
+			event.Summary.WriteTable(s.summaryOut)
+		}
+	}
+}
+
+func (s *barProgressSink) Close() error {
+	s.bar.Stop()
+	return nil
+}
+
+// httpSinkBuffer is the number of events an HTTP sink queues before
+// dropping new ones.
+const httpSinkBuffer = 256
+
+// httpSinkDrainTimeout bounds how long Close waits for queued events.
+const httpSinkDrainTimeout = 5 * time.Second
+
+// httpProgressSink POSTs each event as JSON to a URL from a background
+// goroutine. A slow or unreachable endpoint never stalls the backup:
+// events are dropped once the queue is full.
+type httpProgressSink struct {
+	url     string
+	client  *http.Client
+	events  chan ProgressEvent
+	done    chan struct{}
+	dropped atomic.Int64
+	failed  atomic.Int64
+	once    sync.Once
+}
+
+// NewHTTPProgressSink returns a sink that pushes events to url. A nil
+// client uses one with a 10s timeout.
+func NewHTTPProgressSink(url string, client *http.Client) ProgressSink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	s := &httpProgressSink{
+		url:    url,
+		client: client,
+		events: make(chan ProgressEvent, httpSinkBuffer),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *httpProgressSink) Handle(event ProgressEvent) {
+	if event.Type == ProgressEventStatus {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *httpProgressSink) run() {
+	defer close(s.done)
+	for event := range s.events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			s.failed.Add(1)
+			continue
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			s.failed.Add(1)
+		}
+	}
+}
+
+// Close waits briefly for queued events to be delivered and reports any
+// that were dropped or rejected.
+func (s *httpProgressSink) Close() error {
+	s.once.Do(func() { close(s.events) })
+	select {
+	case <-s.done:
+	case <-time.After(httpSinkDrainTimeout):
+		return fmt.Errorf("progress push to %s: timed out delivering queued events", s.url)
+	}
+	if dropped, failed := s.dropped.Load(), s.failed.Load(); dropped > 0 || failed > 0 {
+		return fmt.Errorf("progress push to %s: %d events dropped, %d failed", s.url, dropped, failed)
+	}
+	return nil
+}
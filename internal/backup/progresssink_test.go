@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingSink captures events for assertions.
+type recordingSink struct {
+	events []ProgressEvent
+	closed int
+}
+
+func (s *recordingSink) Handle(event ProgressEvent) { s.events = append(s.events, event) }
+func (s *recordingSink) Close() error               { s.closed++; return nil }
+
+func TestProgress_MultipleSinks(t *testing.T) {
+	a, b := &recordingSink{}, &recordingSink{}
+	p := NewProgress(2, false, true, false, WithProgressSink(a), WithProgressSink(b))
+
+	p.StartWithType("repo1", "cloning")
+	p.UpdateStatus("fetching PRs: repo1")
+	p.Complete("repo1")
+	p.Start("repo2")
+	p.Fail("repo2", errors.New("boom"))
+	p.Summary(nil)
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	_ = p.Close()
+
+	want := []string{"start", "status", "complete", "start", "fail", "summary"}
+	for _, sink := range []*recordingSink{a, b} {
+		var got []string
+		for _, e := range sink.events {
+			got = append(got, e.Type)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("event types = %v, want %v", got, want)
+		}
+		if sink.closed != 1 {
+			t.Errorf("sink closed %d times, want 1", sink.closed)
+		}
+	}
+
+	complete := a.events[2]
+	if complete.Repo != "repo1" || complete.Completed != 1 || complete.Percent != 50 {
+		t.Errorf("unexpected complete event: %+v", complete)
+	}
+	if a.events[4].Message != "Failed: repo2 - boom" {
+		t.Errorf("fail message = %q", a.events[4].Message)
+	}
+
+	// Events after Close are dropped
+	p.Start("repo3")
+	if len(a.events) != len(want) {
+		t.Error("sink received an event after Close")
+	}
+}
+
+func TestTextProgressSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewTextProgressSink(&buf)
+	sink.Handle(ProgressEvent{Type: ProgressEventStatus, Message: "ignored"})
+	sink.Handle(ProgressEvent{Type: ProgressEventComplete, Total: 4, Completed: 2, Failed: 1, Message: "Completed: repo"})
+
+	if got := buf.String(); got != "[3/4] Completed: repo\n" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestOpenProgressFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.ndjson")
+	sink, err := OpenProgressFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Handle(ProgressEvent{Type: ProgressEventStart, Repo: "repo"})
+	sink.Handle(ProgressEvent{Type: ProgressEventStatus, Current: "fetching"})
+	sink.Handle(ProgressEvent{Type: ProgressEventSummary, Message: "done"})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines (status omitted), got %q", data)
+	}
+	var event ProgressEvent
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil || event.Repo != "repo" {
+		t.Errorf("first line = %q (%v)", lines[0], err)
+	}
+}
+
+func TestHTTPProgressSink(t *testing.T) {
+	var mu sync.Mutex
+	var received []ProgressEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var event ProgressEvent
+		if err := json.Unmarshal(body, &event); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received = append(received, event)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink := NewHTTPProgressSink(server.URL, nil)
+	sink.Handle(ProgressEvent{Type: ProgressEventStart, Repo: "repo"})
+	sink.Handle(ProgressEvent{Type: ProgressEventStatus})
+	sink.Handle(ProgressEvent{Type: ProgressEventComplete, Repo: "repo"})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[1].Type != ProgressEventComplete {
+		t.Errorf("received = %+v", received)
+	}
+}
+
+func TestHTTPProgressSink_ReportsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink := NewHTTPProgressSink(server.URL, nil)
+	sink.Handle(ProgressEvent{Type: ProgressEventStart})
+	if err := sink.Close(); err == nil || !strings.Contains(err.Error(), "1 failed") {
+		t.Errorf("Close() error = %v, want failure count", err)
+	}
+}
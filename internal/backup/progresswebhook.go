@@ -0,0 +1,220 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// Webhook sink timing. Retries back off from webhookRetryBase, doubling,
+// and never wait longer than webhookMaxRetryWait even if Retry-After asks
+// for more. Close waits up to webhookDrainTimeout for queued events.
+const (
+	webhookRetryBase    = time.Second
+	webhookMaxRetryWait = time.Minute
+	webhookDrainTimeout = 30 * time.Second
+)
+
+// ProgressBatch is the body of each progress webhook POST.
+type ProgressBatch struct {
+	Workspace string          `json:"workspace"`
+	RunID     string          `json:"run_id"`
+	Events    []ProgressEvent `json:"events"`
+}
+
+// webhookProgressSink POSTs progress events in batches from a background
+// goroutine. A batch goes out when it is full or the flush interval has
+// passed. Failed batches are retried with backoff, honoring Retry-After;
+// meanwhile new events queue up to a limit, beyond which the oldest are
+// dropped so the backup never waits on the endpoint.
+type webhookProgressSink struct {
+	url        string
+	client     *http.Client
+	workspace  string
+	runID      string
+	batchSize  int
+	interval   time.Duration
+	maxRetries int
+	maxQueued  int
+	retryBase  time.Duration
+
+	mu    sync.Mutex
+	queue []ProgressEvent
+
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	delivered atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+}
+
+// NewWebhookProgressSink returns a sink that pushes batches of events to
+// cfg.WebhookURL, tagged with the workspace and run ID. A nil client uses
+// one with a 10s timeout.
+func NewWebhookProgressSink(cfg config.ProgressConfig, workspace, runID string, client *http.Client) ProgressSink {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	s := &webhookProgressSink{
+		url:        cfg.WebhookURL,
+		client:     client,
+		workspace:  workspace,
+		runID:      runID,
+		batchSize:  max(cfg.BatchSize, 1),
+		interval:   time.Duration(max(cfg.FlushIntervalSeconds, 1)) * time.Second,
+		maxRetries: cfg.MaxRetries,
+		maxQueued:  max(cfg.MaxQueued, cfg.BatchSize, 1),
+		retryBase:  webhookRetryBase,
+		wake:       make(chan struct{}, 1),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *webhookProgressSink) Handle(event ProgressEvent) {
+	if event.Type == ProgressEventStatus {
+		return
+	}
+	s.mu.Lock()
+	if len(s.queue) >= s.maxQueued {
+		s.queue = s.queue[1:]
+		s.dropped.Add(1)
+	}
+	s.queue = append(s.queue, event)
+	full := len(s.queue) >= s.batchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *webhookProgressSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			for s.sendBatch() {
+			}
+			return
+		case <-ticker.C:
+			for s.sendBatch() {
+			}
+		case <-s.wake:
+			// Only full batches; a partial one waits for the tick
+			for s.queued() >= s.batchSize && s.sendBatch() {
+			}
+		}
+	}
+}
+
+// queued returns the number of events waiting.
+func (s *webhookProgressSink) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// sendBatch takes up to batchSize events off the queue and delivers them.
+// It returns false when the queue was empty.
+func (s *webhookProgressSink) sendBatch() bool {
+	s.mu.Lock()
+	n := min(len(s.queue), s.batchSize)
+	batch := append([]ProgressEvent(nil), s.queue[:n]...)
+	s.queue = s.queue[n:]
+	s.mu.Unlock()
+	if n == 0 {
+		return false
+	}
+
+	if s.deliver(batch) {
+		s.delivered.Add(int64(n))
+	} else {
+		s.failed.Add(int64(n))
+	}
+	return true
+}
+
+// deliver POSTs a batch, retrying on network errors, 408, 429, and 5xx.
+func (s *webhookProgressSink) deliver(events []ProgressEvent) bool {
+	data, err := json.Marshal(ProgressBatch{Workspace: s.workspace, RunID: s.runID, Events: events})
+	if err != nil {
+		return false
+	}
+	wait := s.retryBase
+	for attempt := 0; ; attempt++ {
+		retry, ok := s.post(data)
+		if ok {
+			return true
+		}
+		if retry.never || attempt >= s.maxRetries {
+			return false
+		}
+		if retry.after > 0 {
+			wait = retry.after
+		}
+		time.Sleep(min(wait, webhookMaxRetryWait))
+		wait *= 2
+	}
+}
+
+// webhookRetry says whether and when a failed POST may be retried.
+type webhookRetry struct {
+	never bool
+	after time.Duration // From Retry-After, if the endpoint sent one
+}
+
+func (s *webhookProgressSink) post(data []byte) (webhookRetry, bool) {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return webhookRetry{}, false
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch code := resp.StatusCode; {
+	case code < 300:
+		return webhookRetry{}, true
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+		var retry webhookRetry
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retry.after = time.Duration(secs) * time.Second
+		}
+		return retry, false
+	default:
+		return webhookRetry{never: true}, false
+	}
+}
+
+// Close sends what is still queued and reports events that were dropped
+// or could not be delivered.
+func (s *webhookProgressSink) Close() error {
+	s.once.Do(func() { close(s.closing) })
+	select {
+	case <-s.done:
+	case <-time.After(webhookDrainTimeout):
+		return fmt.Errorf("progress webhook %s: timed out delivering %d queued events", s.url, s.queued())
+	}
+	if dropped, failed := s.dropped.Load(), s.failed.Load(); dropped > 0 || failed > 0 {
+		return fmt.Errorf("progress webhook %s: %d events delivered, %d dropped, %d failed", s.url, s.delivered.Load(), dropped, failed)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package backup
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func newTestWebhookSink(url string, batchSize, maxRetries, maxQueued int) *webhookProgressSink {
+	s := NewWebhookProgressSink(config.ProgressConfig{
+		WebhookURL:           url,
+		BatchSize:            batchSize,
+		FlushIntervalSeconds: 3600,
+		MaxRetries:           maxRetries,
+		MaxQueued:            maxQueued,
+	}, "ws", "run-1", nil).(*webhookProgressSink)
+	s.retryBase = time.Millisecond
+	return s
+}
+
+func TestWebhookProgressSink_Batches(t *testing.T) {
+	var mu sync.Mutex
+	var batches []ProgressBatch
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch ProgressBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink := newTestWebhookSink(server.URL, 2, 0, 10)
+	for _, repo := range []string{"a", "b", "c"} {
+		sink.Handle(ProgressEvent{Type: ProgressEventComplete, Repo: repo})
+		sink.Handle(ProgressEvent{Type: ProgressEventStatus})
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var repos []string
+	for _, b := range batches {
+		if b.Workspace != "ws" || b.RunID != "run-1" || len(b.Events) > 2 {
+			t.Errorf("batch = %+v", b)
+		}
+		for _, e := range b.Events {
+			repos = append(repos, e.Repo)
+		}
+	}
+	if strings.Join(repos, ",") != "a,b,c" {
+		t.Errorf("delivered %v, want a,b,c in order without status events", repos)
+	}
+}
+
+func TestWebhookProgressSink_Retries(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	sink := newTestWebhookSink(server.URL, 10, 2, 10)
+	sink.Handle(ProgressEvent{Type: ProgressEventStart, Repo: "a"})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2 (one retry)", calls)
+	}
+}
+
+func TestWebhookProgressSink_NoRetryOnClientError(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink := newTestWebhookSink(server.URL, 10, 3, 10)
+	sink.Handle(ProgressEvent{Type: ProgressEventStart, Repo: "a"})
+	err := sink.Close()
+	if err == nil || !strings.Contains(err.Error(), "1 failed") {
+		t.Errorf("Close() error = %v, want failure count", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestWebhookProgressSink_DropsOldestWhenFull(t *testing.T) {
+	var mu sync.Mutex
+	var repos []string
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var batch ProgressBatch
+		_ = json.NewDecoder(r.Body).Decode(&batch)
+		mu.Lock()
+		for _, e := range batch.Events {
+			repos = append(repos, e.Repo)
+		}
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink := newTestWebhookSink(server.URL, 1, 0, 2)
+	sink.Handle(ProgressEvent{Type: ProgressEventStart, Repo: "first"})
+	// Wait for the first batch to be in flight so the rest queue behind it
+	for sink.queued() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, repo := range []string{"a", "b", "c", "d"} {
+		sink.Handle(ProgressEvent{Type: ProgressEventStart, Repo: repo})
+	}
+	close(release)
+
+	err := sink.Close()
+	if err == nil || !strings.Contains(err.Error(), "2 dropped") {
+		t.Errorf("Close() error = %v, want 2 dropped", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(repos, ",") != "first,c,d" {
+		t.Errorf("delivered %v, want first,c,d", repos)
+	}
+}
@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+	"sync"
+)
+
+// projectQueue hands out jobs so that no project has more than limit
+// repositories in flight (parallelism.max_per_project). Workers take the
+// first queued job, in planned order, whose project has a free slot, so a
+// project with hundreds of repositories cannot hold every worker while
+// other projects wait.
+type projectQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []repoJob
+	running map[string]int
+	active  int // Jobs handed out and not yet done
+	limit   int
+	closed  bool
+}
+
+// newProjectQueue creates a queue allowing limit jobs per project.
+func newProjectQueue(limit int) *projectQueue {
+	q := &projectQueue{running: make(map[string]int), limit: limit}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a job to the back of the queue.
+func (q *projectQueue) push(job repoJob) {
+	q.mu.Lock()
+	q.pending = append(q.pending, job)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// close marks that no more jobs will be submitted. Retries of running jobs
+// may still be pushed.
+func (q *projectQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pop waits for a job whose project has a free slot and takes the slot.
+// It returns false once the queue is closed and drained with nothing
+// running that could be retried, or when ctx is done.
+func (q *projectQueue) pop(ctx context.Context) (repoJob, bool) {
+	stop := context.AfterFunc(ctx, q.cond.Broadcast)
+	defer stop()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if ctx.Err() != nil {
+			return repoJob{}, false
+		}
+		for i, job := range q.pending {
+			project := repoProjectKey(job.repo)
+			if q.running[project] >= q.limit {
+				continue
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.running[project]++
+			q.active++
+			return job, true
+		}
+		if q.closed && len(q.pending) == 0 && q.active == 0 {
+			return repoJob{}, false
+		}
+		q.cond.Wait()
+	}
+}
+
+// done releases the slot taken by pop for job.
+func (q *projectQueue) done(job repoJob) {
+	q.mu.Lock()
+	q.running[repoProjectKey(job.repo)]--
+	q.active--
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// forward moves jobs submitted to the pool's channel into the queue and
+// closes it when the channel is closed.
+func (q *projectQueue) forward(jobs <-chan repoJob) {
+	for job := range jobs {
+		q.push(job)
+	}
+	q.close()
+}
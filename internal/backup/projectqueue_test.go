@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func projectJob(project, slug string) repoJob {
+	return repoJob{repo: &api.Repository{Slug: slug, Project: &api.Project{Key: project}}}
+}
+
+func TestProjectQueue(t *testing.T) {
+	q := newProjectQueue(1)
+	for _, job := range []repoJob{projectJob("BIG", "a1"), projectJob("BIG", "a2"), projectJob("BIG", "a3"), projectJob("SMALL", "b1")} {
+		q.push(job)
+	}
+	q.close()
+	ctx := context.Background()
+
+	first, _ := q.pop(ctx)
+	second, _ := q.pop(ctx)
+	if first.repo.Slug != "a1" || second.repo.Slug != "b1" {
+		t.Fatalf("popped %s, %s; want a1, then b1 ahead of BIG's queued repos", first.repo.Slug, second.repo.Slug)
+	}
+
+	got := make(chan string)
+	go func() {
+		job, _ := q.pop(ctx)
+		got <- job.repo.Slug
+	}()
+	select {
+	case slug := <-got:
+		t.Fatalf("popped %s while BIG was at its limit", slug)
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.done(first)
+	if slug := <-got; slug != "a2" {
+		t.Errorf("popped %s after a1 finished, want a2", slug)
+	}
+}
+
+func TestProjectQueue_RetryAfterClose(t *testing.T) {
+	q := newProjectQueue(2)
+	q.push(projectJob("P", "a"))
+	q.close()
+	ctx := context.Background()
+
+	job, ok := q.pop(ctx)
+	if !ok {
+		t.Fatal("pop() = false with a queued job")
+	}
+	// A failing job is requeued before its slot is released
+	q.push(job)
+	q.done(job)
+	if retry, ok := q.pop(ctx); !ok || retry.repo.Slug != "a" {
+		t.Fatalf("pop() = %v, %v; want the retry", retry.repo, ok)
+	}
+	q.done(job)
+	if _, ok := q.pop(ctx); ok {
+		t.Error("pop() = true on a closed, drained queue")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	open := newProjectQueue(1)
+	go cancel()
+	if _, ok := open.pop(cancelled); ok {
+		t.Error("pop() = true after cancellation")
+	}
+}
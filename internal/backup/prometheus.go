@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+// PrometheusContentType is the content type of the Prometheus text format.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// pushTimeout bounds the push of a run's metrics to the Pushgateway.
+const pushTimeout = 30 * time.Second
+
+// runCounters are the run's totals, updated as repositories finish.
+type runCounters struct {
+	started      time.Time
+	backedUp     atomic.Int64
+	failed       atomic.Int64
+	pullRequests atomic.Int64
+	issues       atomic.Int64
+	bytes        atomic.Int64
+}
+
+// add counts a repository backed up successfully.
+func (c *runCounters) add(stats repoStats) {
+	c.backedUp.Add(1)
+	c.pullRequests.Add(int64(stats.PullRequests))
+	c.issues.Add(int64(stats.Issues))
+	c.bytes.Add(stats.Bytes)
+}
+
+// promMetric is one gauge sample in the Prometheus text format.
+type promMetric struct {
+	name   string
+	help   string
+	labels map[string]string
+	value  float64
+}
+
+// promMetrics returns the run's metrics as of now. Every value is a gauge
+// for this run alone: a Pushgateway keeps only the last push, so totals
+// across runs belong to Prometheus.
+func (b *Backup) promMetrics(now time.Time) []promMetric {
+	c := &b.counters
+	m := []promMetric{
+		{name: "bb_backup_start_time_seconds", help: "When the run started, as a Unix time.", value: unixSeconds(c.started)},
+		{name: "bb_backup_duration_seconds", help: "How long the run has been running.", value: now.Sub(c.started).Seconds()},
+		{name: "bb_backup_repositories_backed_up", help: "Repositories backed up by the run.", value: float64(c.backedUp.Load())},
+		{name: "bb_backup_repositories_failed", help: "Repositories that failed in the run.", value: float64(c.failed.Load())},
+		{name: "bb_backup_pull_requests_backed_up", help: "Pull requests saved by the run.", value: float64(c.pullRequests.Load())},
+		{name: "bb_backup_issues_backed_up", help: "Issues saved by the run.", value: float64(c.issues.Load())},
+		{name: "bb_backup_mirror_bytes", help: "Size of the git mirrors the run backed up.", value: float64(c.bytes.Load())},
+		{name: "bb_backup_panics", help: "Panics recovered during the run.", value: float64(b.panics.Load())},
+	}
+	if b.client != nil {
+		usage := b.client.Usage()
+		limiter := b.client.RateLimiter().Stats()
+		m = append(m,
+			promMetric{name: "bb_backup_api_requests", help: "Bitbucket API requests made by the run.", value: float64(usage.Requests)},
+			promMetric{name: "bb_backup_api_rate_limited", help: "API responses with status 429 during the run.", value: float64(usage.RateLimited)},
+			promMetric{name: "bb_backup_rate_limit_waits", help: "API requests that queued for the rate limiter.", value: float64(limiter.Waits)},
+			promMetric{name: "bb_backup_rate_limit_wait_seconds", help: "Time API requests spent queued for the rate limiter.", value: limiter.WaitedSeconds},
+		)
+	}
+
+	codes := b.report.FailureCodes()
+	names := make([]string, 0, len(codes))
+	for code := range codes {
+		names = append(names, string(code))
+	}
+	sort.Strings(names)
+	for _, code := range names {
+		m = append(m, promMetric{
+			name:   "bb_backup_repository_failures",
+			help:   "Repositories that failed in the run, by error code.",
+			labels: map[string]string{"code": code},
+			value:  float64(codes[errcode.Code(code)]),
+		})
+	}
+	return m
+}
+
+// writePrometheus writes metrics in the Prometheus text format, adding
+// labels to every sample.
+func writePrometheus(w io.Writer, metrics []promMetric, labels map[string]string) error {
+	var buf bytes.Buffer
+	described := make(map[string]bool)
+	for _, m := range metrics {
+		if !described[m.name] {
+			described[m.name] = true
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		}
+		all := make(map[string]string, len(labels)+len(m.labels))
+		for k, v := range labels {
+			all[k] = v
+		}
+		for k, v := range m.labels {
+			all[k] = v
+		}
+		fmt.Fprintf(&buf, "%s%s %g\n", m.name, formatLabels(all), m.value)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// formatLabels renders labels as {name="value",...}, sorted by name, or
+// "" if there are none.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, escape.Replace(labels[name]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// servePrometheus serves the run's metrics, labelled with the workspace.
+func (b *Backup) servePrometheus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", PrometheusContentType)
+	_ = writePrometheus(w, b.promMetrics(time.Now()), map[string]string{"workspace": b.cfg.Workspace})
+}
+
+// pushMetrics pushes the finished run's metrics to metrics.pushgateway_url,
+// replacing the last run's under the job and workspace, with the time it
+// finished and whether it succeeded: without error and without failed
+// repositories. Dry runs push nothing. Failures are logged and never fail
+// the backup.
+func (b *Backup) pushMetrics(runErr error) {
+	gateway := b.cfg.Metrics.PushgatewayURL
+	if gateway == "" || b.opts.DryRun {
+		return
+	}
+	now := time.Now()
+	success := 0.0
+	if runErr == nil && b.counters.failed.Load() == 0 {
+		success = 1
+	}
+	metrics := append(b.promMetrics(now),
+		promMetric{name: "bb_backup_completion_time_seconds", help: "When the run finished, as a Unix time.", value: unixSeconds(now)},
+		promMetric{name: "bb_backup_success", help: "1 if the run finished without errors or failed repositories, else 0.", value: success},
+	)
+
+	// The grouping key supplies the workspace label
+	var body bytes.Buffer
+	_ = writePrometheus(&body, metrics, nil)
+	target := fmt.Sprintf("%s/metrics/job/%s/workspace/%s", strings.TrimRight(gateway, "/"),
+		url.PathEscape(b.cfg.Metrics.PushgatewayJob), url.PathEscape(b.cfg.Workspace))
+
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, &body)
+	if err != nil {
+		b.log.Error("Failed to push metrics: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", PrometheusContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		b.log.Error("Failed to push metrics to %s: %v", gateway, err)
+		return
+	}
+	defer resp.Body.Close() //nolint:errcheck // read-only
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		b.log.Error("Failed to push metrics to %s: status %d: %s", gateway, resp.StatusCode, strings.TrimSpace(string(msg)))
+		return
+	}
+	b.log.Debug("Pushed run metrics to %s", gateway)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
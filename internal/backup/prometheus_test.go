@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+func TestServePrometheus(t *testing.T) {
+	cfg := &config.Config{Workspace: `w"s`, RateLimit: config.RateLimitConfig{RequestsPerHour: 1000, BurstSize: 10}}
+	b := &Backup{
+		cfg:    cfg,
+		client: api.NewClient(cfg),
+		log:    &defaultLogger{quiet: true},
+		report: NewReport(cfg.Workspace),
+	}
+	b.counters.started = time.Now().Add(-time.Minute)
+	b.counters.add(repoStats{PullRequests: 3, Issues: 2, Bytes: 2048})
+	b.counters.failed.Add(2)
+	b.report.Add(RepoReport{Slug: "a", Status: RepoStatusFailed, Code: errcode.GitTimeout})
+	b.report.Add(RepoReport{Slug: "b", Status: RepoStatusFailed, Code: errcode.AuthFailed})
+
+	rec := httptest.NewRecorder()
+	b.servePrometheus(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != PrometheusContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE bb_backup_repositories_backed_up gauge\nbb_backup_repositories_backed_up{workspace=\"w\\\"s\"} 1\n",
+		`bb_backup_repositories_failed{workspace="w\"s"} 2`,
+		`bb_backup_pull_requests_backed_up{workspace="w\"s"} 3`,
+		`bb_backup_mirror_bytes{workspace="w\"s"} 2048`,
+		`bb_backup_api_requests{workspace="w\"s"} 0`,
+		`bb_backup_rate_limit_waits{workspace="w\"s"} 0`,
+		`bb_backup_repository_failures{code="AUTH_FAILED",workspace="w\"s"} 1`,
+		`bb_backup_repository_failures{code="GIT_TIMEOUT",workspace="w\"s"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+	if n := strings.Count(body, "# HELP bb_backup_repository_failures "); n != 1 {
+		t.Errorf("HELP for bb_backup_repository_failures written %d times, want 1", n)
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var method, path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.EscapedPath(), string(data)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		Workspace: "my ws",
+		Metrics:   config.MetricsConfig{PushgatewayURL: srv.URL + "/", PushgatewayJob: "bb_backup"},
+	}
+	b := &Backup{cfg: cfg, log: &defaultLogger{quiet: true}, report: NewReport(cfg.Workspace)}
+	b.counters.started = time.Now()
+	b.counters.add(repoStats{})
+
+	b.pushMetrics(nil)
+	if method != http.MethodPut || path != "/metrics/job/bb_backup/workspace/my%20ws" {
+		t.Errorf("pushed %s %s, want PUT /metrics/job/bb_backup/workspace/my%%20ws", method, path)
+	}
+	for _, want := range []string{"bb_backup_success 1\n", "bb_backup_repositories_backed_up 1\n", "bb_backup_completion_time_seconds "} {
+		if !strings.Contains(body, want) {
+			t.Errorf("pushed metrics missing %q:\n%s", want, body)
+		}
+	}
+
+	// A failed repository marks the run unsuccessful
+	b.counters.failed.Add(1)
+	b.pushMetrics(nil)
+	if !strings.Contains(body, "bb_backup_success 0\n") {
+		t.Errorf("pushed metrics after a failure:\n%s", body)
+	}
+
+	// Dry runs push nothing
+	method = ""
+	b.opts.DryRun = true
+	b.pushMetrics(nil)
+	if method != "" {
+		t.Errorf("dry run pushed metrics")
+	}
+}
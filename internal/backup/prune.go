@@ -0,0 +1,510 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// PruneAuditFileName is the log, in the workspace directory, of everything
+// prune has deleted. Each prune appends one JSON line per deletion.
+const PruneAuditFileName = "prune-audit.ndjson"
+
+// PruneAction is one deletion by prune, as recorded in the audit log.
+type PruneAction struct {
+	Time           string `json:"time"`
+	RunID          string `json:"run_id"`
+	Path           string `json:"path"`                 // Relative to the workspace directory
+	Repository     string `json:"repository,omitempty"` // Empty when the whole run was removed
+	RetentionClass string `json:"retention_class,omitempty"`
+	KeepDays       int    `json:"keep_days"`
+	KeepRuns       int    `json:"keep_runs,omitempty"` // Set when retention.keep_runs expired the data
+	AgeDays        int    `json:"age_days"`
+	Bytes          int64  `json:"bytes"`
+	// Rule is the setting that expired the data, e.g.
+	// retention.classes.critical.keep_days
+	Rule string `json:"rule"`
+}
+
+// Reason explains which rule expired the data, e.g. "45 days old, past
+// retention.keep_days of 30".
+func (a PruneAction) Reason() string {
+	if a.KeepRuns > 0 {
+		return fmt.Sprintf("older than the newest %d runs, past %s", a.KeepRuns, a.Rule)
+	}
+	return fmt.Sprintf("%d days old, past %s of %d", a.AgeDays, a.Rule, a.KeepDays)
+}
+
+// PruneResult summarizes a prune.
+type PruneResult struct {
+	Workspace    string        `json:"workspace"`
+	DryRun       bool          `json:"dry_run,omitempty"` // Nothing was deleted; Actions are what would be
+	Actions      []PruneAction `json:"actions"`
+	Runs         int           `json:"runs_removed"`         // Run directories removed entirely
+	Repositories int           `json:"repositories_removed"` // Repository directories removed from runs
+	Bytes        int64         `json:"bytes_removed"`
+}
+
+// PruneOptions controls a prune.
+type PruneOptions struct {
+	Now    time.Time // Reference time for ages; zero means now
+	Log    Logger    // nil logs nothing
+	DryRun bool      // Work out what would be deleted without deleting or auditing it
+
+	// Storage is the storage for cfg.Storage; nil opens it
+	Storage storage.Storage
+}
+
+// pruneRun is a run directory, or the archive of one, considered by prune.
+type pruneRun struct {
+	id          string
+	started     time.Time
+	archiveOnly bool // Only the run's archive is left (see storage.archive)
+}
+
+// Prune deletes expired backup data from the workspace's run directories
+// according to cfg.Retention. Each repository's directory in a run is kept
+// for the days of its retention class, from custom metadata, or for
+// retention.keep_days without one. A run whose repositories have all
+// expired is removed entirely once it is older than retention.keep_days.
+// With retention.keep_runs, runs older than the newest keep_runs also lose
+// every repository without a configured class, and are removed once
+// nothing is left in them, whatever their age. A run's archive is removed
+// with its directory; a run left only as an archive holds every
+// repository, so it is kept for the longest retention configured.
+// latest/, the newest run, and the run current points at are never
+// touched. Every deletion is
+// appended to prune-audit.ndjson before the next one starts; a dry run
+// returns the same actions without deleting or recording anything. With
+// policy.repository set, the retention in the copy of the policy file kept
+// by the last backup applies over cfg's.
+func Prune(cfg *config.Config, opts PruneOptions) (*PruneResult, error) {
+	log := opts.Log
+	if log == nil {
+		log = &defaultLogger{quiet: true}
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var custom *CustomMetadata
+	if cfg.Backup.CustomMetadataFile != "" {
+		var err error
+		if custom, err = LoadCustomMetadata(cfg.Backup.CustomMetadataFile); err != nil {
+			return nil, err
+		}
+	}
+
+	workspaceDir := filepath.Join(cfg.Storage.Path, cfg.Workspace)
+	if cfg.Policy.Repository != "" {
+		// The policy the last backup read overrides the config's retention
+		policy, err := LoadCachedPolicy(workspaceDir)
+		if err != nil {
+			return nil, err
+		}
+		if policy != nil {
+			merged := *cfg
+			policy.Apply(&merged)
+			cfg = &merged
+			custom = policy.MergeCustom(custom)
+		} else {
+			log.Info("No %s in %s yet; using the config's retention", PolicyFileName, workspaceDir)
+		}
+	}
+	runs, err := listPruneRuns(workspaceDir, cfg.Backup.RunTimestampFormat, log)
+	if err != nil {
+		return nil, err
+	}
+	result := &PruneResult{Workspace: cfg.Workspace, DryRun: opts.DryRun, Actions: []PruneAction{}}
+	if len(runs) == 0 {
+		return result, nil
+	}
+
+	protected := map[string]bool{runs[len(runs)-1].id: true}
+	if current := currentRunID(workspaceDir); current != "" {
+		protected[current] = true
+	}
+
+	// Deletions and the audit log go through storage so that with s3
+	// storage the bucket's copy is pruned and audited too
+	store := opts.Storage
+	if store == nil {
+		if store, err = OpenStorage(cfg); err != nil {
+			return nil, err
+		}
+	}
+	auditPath := filepath.Join(cfg.Workspace, PruneAuditFileName)
+	var audit []byte
+	if !opts.DryRun {
+		audit, err = store.Read(auditPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("reading prune audit log: %w", err)
+		}
+	}
+
+	// In a dry run the repository directories a removed run has already
+	// given up are still on disk; runCounted keeps them from being counted
+	// again with the run
+	runCounted := make(map[string]int64)
+	remove := func(action PruneAction) error {
+		dir := filepath.Join(workspaceDir, action.Path)
+		if usage, err := MeasureWorkspace(dir); err == nil {
+			action.Bytes = usage.Bytes
+		}
+		var archives []string
+		if action.Repository == "" {
+			for _, path := range runArchives(workspaceDir, action.RunID) {
+				archives = append(archives, path, path+ArchiveChecksumSuffix)
+			}
+		}
+		for _, path := range archives {
+			if info, err := os.Stat(path); err == nil {
+				action.Bytes += info.Size()
+			}
+		}
+		action.Time = time.Now().UTC().Format(time.RFC3339)
+		if opts.DryRun {
+			if action.Repository == "" {
+				action.Bytes -= runCounted[action.RunID]
+			} else {
+				runCounted[action.RunID] += action.Bytes
+			}
+			result.Actions = append(result.Actions, action)
+			result.Bytes += action.Bytes
+			return nil
+		}
+		if err := store.Delete(filepath.Join(cfg.Workspace, action.Path)); err != nil {
+			return fmt.Errorf("removing %s: %w", action.Path, err)
+		}
+		for _, path := range archives {
+			if err := store.Delete(filepath.Join(cfg.Workspace, filepath.Base(path))); err != nil {
+				return fmt.Errorf("removing %s: %w", filepath.Base(path), err)
+			}
+		}
+		line, err := json.Marshal(action)
+		if err != nil {
+			return fmt.Errorf("encoding prune audit entry: %w", err)
+		}
+		audit = append(append(audit, line...), '\n')
+		if err := store.Write(auditPath, audit); err != nil {
+			return fmt.Errorf("writing prune audit log: %w", err)
+		}
+		result.Actions = append(result.Actions, action)
+		result.Bytes += action.Bytes
+		return nil
+	}
+
+	// Runs before rotateBefore are past retention.keep_runs
+	rotateBefore := 0
+	if keep := cfg.Retention.KeepRuns; keep > 0 && len(runs) > keep {
+		rotateBefore = len(runs) - keep
+	}
+
+	verb := "Pruned"
+	if opts.DryRun {
+		verb = "Would prune"
+	}
+	unknown := make(map[string]bool)
+	for i, run := range runs {
+		if protected[run.id] {
+			log.Debug("Keeping run %s: newest or current run", run.id)
+			continue
+		}
+		rotated := i < rotateBefore
+		age := now.Sub(run.started)
+		ageDays := int(age.Hours() / 24)
+		expired := func(keepDays int) bool {
+			return keepDays > 0 && age > time.Duration(keepDays)*24*time.Hour
+		}
+
+		if run.archiveOnly {
+			keepDays, rule := archiveRetention(cfg.Retention)
+			action := PruneAction{RunID: run.id, Path: run.id, KeepDays: keepDays, AgeDays: ageDays, Rule: rule}
+			switch {
+			case expired(keepDays):
+			case rotated && len(cfg.Retention.Classes) == 0:
+				action.KeepRuns = cfg.Retention.KeepRuns
+				action.Rule = "retention.keep_runs"
+			default:
+				continue
+			}
+			if err := remove(action); err != nil {
+				return result, err
+			}
+			result.Runs++
+			log.Info("%s archived run %s (%s)", verb, run.id, action.Reason())
+			continue
+		}
+
+		repos, err := runRepoDirs(filepath.Join(workspaceDir, run.id))
+		if err != nil {
+			return result, err
+		}
+		remaining := 0
+		for _, repo := range repos {
+			class := custom.For(repo.slug).RetentionClass()
+			keepDays, known := cfg.Retention.KeepDaysFor(class)
+			rule := "retention.keep_days"
+			if known {
+				rule = "retention.classes." + class + ".keep_days"
+			} else if class != "" && !unknown[class] {
+				unknown[class] = true
+				log.Error("Retention class %q is not in retention.classes; using retention.keep_days", class)
+			}
+			action := PruneAction{
+				RunID:          run.id,
+				Path:           filepath.Join(run.id, repo.rel),
+				Repository:     repo.slug,
+				RetentionClass: class,
+				KeepDays:       keepDays,
+				AgeDays:        ageDays,
+				Rule:           rule,
+			}
+			switch {
+			case expired(keepDays):
+			case rotated && !known:
+				// A class is an explicit promise to keep the data for its
+				// days; only the default retention gives way to keep_runs
+				action.KeepRuns = cfg.Retention.KeepRuns
+				action.Rule = "retention.keep_runs"
+			default:
+				remaining++
+				continue
+			}
+			if err := remove(action); err != nil {
+				return result, err
+			}
+			result.Repositories++
+			log.Info("%s %s from run %s (%s)", verb, repo.slug, run.id, action.Reason())
+		}
+
+		if remaining > 0 || !(rotated || expired(cfg.Retention.KeepDays)) {
+			continue
+		}
+		action := PruneAction{
+			RunID:    run.id,
+			Path:     run.id,
+			KeepDays: cfg.Retention.KeepDays,
+			AgeDays:  ageDays,
+			Rule:     "retention.keep_days",
+		}
+		if !expired(cfg.Retention.KeepDays) {
+			action.KeepRuns = cfg.Retention.KeepRuns
+			action.Rule = "retention.keep_runs"
+		}
+		if err := remove(action); err != nil {
+			return result, err
+		}
+		result.Runs++
+		log.Info("%s run %s (%s)", verb, run.id, action.Reason())
+	}
+
+	if len(result.Actions) > 0 && !opts.DryRun {
+		refreshUsage(store, cfg.Workspace, log)
+	}
+	return result, nil
+}
+
+// listPruneRuns returns the run directories in a workspace, and the runs
+// left only as archives, oldest first. Runs are dated by their recorded
+// start time or their run ID (see runStartTime); runs with neither are
+// skipped rather than guessed at.
+func listPruneRuns(workspaceDir, layout string, log Logger) ([]pruneRun, error) {
+	entries, err := os.ReadDir(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+	var runs []pruneRun
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || ValidateRunID(name) != nil || strings.HasPrefix(name, LatestDirName) {
+			continue
+		}
+		seen[name] = true
+		started, ok := runStartTime(filepath.Join(workspaceDir, name), layout)
+		if !ok {
+			log.Debug("Skipping %s: cannot tell when the run started", name)
+			continue
+		}
+		runs = append(runs, pruneRun{id: name, started: started})
+	}
+
+	archives, err := ListArchives(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range archives {
+		id := strings.TrimSuffix(name, "."+ArchiveFormat(name))
+		if seen[id] || strings.HasPrefix(id, LatestDirName) {
+			continue
+		}
+		seen[id] = true
+		started, ok := archiveStartTime(filepath.Join(workspaceDir, name), id, layout)
+		if !ok {
+			log.Debug("Skipping %s: cannot tell when the run started", name)
+			continue
+		}
+		runs = append(runs, pruneRun{id: id, started: started, archiveOnly: true})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].started.Before(runs[j].started) })
+	return runs, nil
+}
+
+// runStartTime returns when the run in runDir started. The start time
+// recorded in manifest.json or report.json wins over the directory name:
+// runs named by older versions carry local time with a "Z" suffix, so
+// their names can be off by the UTC offset. Runs that left neither file
+// are dated by their name in layout, then in the default layout.
+func runStartTime(runDir, layout string) (time.Time, bool) {
+	for _, name := range []string{"manifest.json", ReportFileName} {
+		data, err := os.ReadFile(filepath.Join(runDir, name))
+		if err != nil {
+			continue
+		}
+		if t, ok := recordedStartTime(data); ok {
+			return t, true
+		}
+	}
+	return runIDTime(filepath.Base(runDir), layout)
+}
+
+// recordedStartTime reads the started_at of a manifest or report.
+func recordedStartTime(data []byte) (time.Time, bool) {
+	var recorded struct {
+		StartedAt string `json:"started_at"`
+	}
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, recorded.StartedAt)
+	return t, err == nil
+}
+
+// runIDTime dates a run by its ID in layout, then in the default layout.
+func runIDTime(id, layout string) (time.Time, bool) {
+	for _, l := range []string{layout, runTimeFormat} {
+		if l == "" {
+			continue
+		}
+		if t, ok := parseRunTime(id, l); ok {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// archiveRetention returns the days a run left only as an archive is kept:
+// the longest of retention.keep_days and the classes' keep_days, or 0
+// (forever) if any of them keeps data forever. It also returns the setting
+// that decided it.
+func archiveRetention(r config.RetentionConfig) (int, string) {
+	keepDays, rule := r.KeepDays, "retention.keep_days"
+	classes := make([]string, 0, len(r.Classes))
+	for name := range r.Classes {
+		classes = append(classes, name)
+	}
+	sort.Strings(classes)
+	for _, name := range classes {
+		days := r.Classes[name].KeepDays
+		if keepDays == 0 {
+			break
+		}
+		if days == 0 || days > keepDays {
+			keepDays, rule = days, "retention.classes."+name+".keep_days"
+		}
+	}
+	return keepDays, rule
+}
+
+// parseRunTime reads the timestamp at the start of a run ID in layout.
+func parseRunTime(id, layout string) (time.Time, bool) {
+	n := len(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Format(layout))
+	if len(id) < n {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(layout, id[:n])
+	return t, err == nil
+}
+
+// runRepo is a repository's directory in a run.
+type runRepo struct {
+	slug string
+	rel  string // Relative to the run directory
+}
+
+// runRepoDirs lists the repository directories in a run.
+func runRepoDirs(runDir string) ([]runRepo, error) {
+	var repos []runRepo
+	collect := func(rel string) error {
+		entries, err := os.ReadDir(filepath.Join(runDir, rel))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("reading %s: %w", filepath.Join(runDir, rel), err)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				repos = append(repos, runRepo{slug: e.Name(), rel: filepath.Join(rel, e.Name())})
+			}
+		}
+		return nil
+	}
+
+	projects, err := os.ReadDir(filepath.Join(runDir, "projects"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading projects in %s: %w", runDir, err)
+	}
+	for _, p := range projects {
+		if p.IsDir() {
+			if err := collect(filepath.Join("projects", p.Name(), "repositories")); err != nil {
+				return nil, err
+			}
+		}
+	}
+	personal, err := ListPersonalRepos(runDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range personal {
+		repos = append(repos, runRepo{slug: r.Slug, rel: r.Rel})
+	}
+	return repos, nil
+}
+
+// refreshUsage re-measures the workspace after a prune so the usage cached
+// in the state file, which the storage quota check trusts, is not stale.
+func refreshUsage(store storage.Storage, workspace string, log Logger) {
+	workspaceDir := filepath.Join(store.BasePath(), workspace)
+	state, err := LoadState(filepath.Join(workspaceDir, StateFileName))
+	if err != nil || state == nil {
+		return
+	}
+	if _, ok := state.GetUsage(); !ok {
+		return
+	}
+	usage, err := MeasureWorkspace(workspaceDir)
+	if err != nil {
+		log.Error("Failed to measure workspace usage: %v", err)
+		return
+	}
+	state.SetUsage(usage)
+	data, err := state.Encode()
+	if err == nil {
+		err = store.Write(filepath.Join(workspace, StateFileName), data)
+	}
+	if err != nil {
+		log.Error("Failed to save workspace usage: %v", err)
+	}
+}
@@ -0,0 +1,425 @@
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// writeRun creates a run directory started daysAgo before now, holding the
+// given repositories under project CORE.
+func writeRun(t *testing.T, wsDir string, now time.Time, daysAgo int, repos ...string) string {
+	t.Helper()
+	id := NewRunID(now.Add(-time.Duration(daysAgo) * 24 * time.Hour))
+	for _, slug := range repos {
+		dir := filepath.Join(wsDir, id, "projects", "CORE", "repositories", slug)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "repository.json"), []byte(`{"slug":"`+slug+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(wsDir, id, "manifest.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func newPruneTestConfig(t *testing.T) (*config.Config, string) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.Storage.Path = t.TempDir()
+	cfg.Retention.KeepDays = 30
+	cfg.Retention.Classes = map[string]config.RetentionClassConfig{"critical": {KeepDays: 365}}
+	cfg.Backup.CustomMetadataFile = writeCustomMetadata(t, "repositories:\n  \"core-*\":\n    retention_class: critical\n")
+	return cfg, filepath.Join(cfg.Storage.Path, cfg.Workspace)
+}
+
+func TestPrune_RetentionClasses(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	now := time.Now()
+	mixed := writeRun(t, wsDir, now, 100, "core-api", "web")
+	webOnly := writeRun(t, wsDir, now, 40, "web")
+	recent := writeRun(t, wsDir, now, 5, "web")
+	ancient := writeRun(t, wsDir, now, 400, "core-api")
+	linked := writeRun(t, wsDir, now, 500, "web") // Oldest, but current points at it
+	if err := os.Symlink(linked, filepath.Join(wsDir, CurrentLinkName)); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(wsDir, rel))
+		return err == nil
+	}
+	repo := func(run, slug string) string {
+		return filepath.Join(run, "projects", "CORE", "repositories", slug)
+	}
+	switch {
+	case exists(repo(mixed, "web")):
+		t.Error("web in the 100-day run should be pruned (keep_days 30)")
+	case !exists(repo(mixed, "core-api")):
+		t.Error("core-api in the 100-day run should be kept (critical, 365 days)")
+	case !exists(filepath.Join(mixed, "manifest.json")):
+		t.Error("a run with repositories left should keep its manifest")
+	case exists(webOnly):
+		t.Error("the 40-day run with only expired repositories should be removed")
+	case !exists(repo(recent, "web")):
+		t.Error("the 5-day run should be kept")
+	case exists(ancient):
+		t.Error("the 400-day run should be removed")
+	case !exists(linked):
+		t.Error("the run current points at should never be pruned")
+	}
+	if result.Runs != 2 || result.Repositories != 3 || result.Bytes == 0 {
+		t.Errorf("result = %d runs, %d repositories, %d bytes", result.Runs, result.Repositories, result.Bytes)
+	}
+
+	f, err := os.Open(filepath.Join(wsDir, PruneAuditFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var actions []PruneAction
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var a PruneAction
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, a)
+	}
+	if len(actions) != len(result.Actions) {
+		t.Fatalf("audit log has %d entries, result %d", len(actions), len(result.Actions))
+	}
+	var critical bool
+	for _, a := range actions {
+		if a.Repository == "core-api" {
+			critical = a.RetentionClass == "critical" && a.Rule == "retention.classes.critical.keep_days" && a.KeepDays == 365
+		}
+	}
+	if !critical {
+		t.Errorf("audit log missing the critical class rule: %+v", actions)
+	}
+}
+
+func TestPrune_DryRun(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	now := time.Now()
+	mixed := writeRun(t, wsDir, now, 100, "core-api", "web")
+	old := writeRun(t, wsDir, now, 40, "web", "docs")
+	writeRun(t, wsDir, now, 5, "web")
+
+	planned, err := Prune(cfg, PruneOptions{Now: now, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !planned.DryRun || planned.Runs != 1 || planned.Repositories != 3 {
+		t.Errorf("dry run = %+v", planned)
+	}
+	for _, rel := range []string{filepath.Join(mixed, "projects", "CORE", "repositories", "web"), old} {
+		if _, err := os.Stat(filepath.Join(wsDir, rel)); err != nil {
+			t.Errorf("dry run deleted %s", rel)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, PruneAuditFileName)); !os.IsNotExist(err) {
+		t.Error("dry run should not write the audit log")
+	}
+	if reason := planned.Actions[0].Reason(); reason != "100 days old, past retention.keep_days of 30" {
+		t.Errorf("Reason() = %q", reason)
+	}
+
+	// The plan matches what a real prune then does, bytes included
+	pruned, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned.Actions) != len(planned.Actions) || pruned.Bytes != planned.Bytes {
+		t.Fatalf("prune = %d actions, %d bytes; dry run = %d actions, %d bytes",
+			len(pruned.Actions), pruned.Bytes, len(planned.Actions), planned.Bytes)
+	}
+	for i, a := range pruned.Actions {
+		if p := planned.Actions[i]; p.Path != a.Path || p.Rule != a.Rule {
+			t.Errorf("action %d: dry run %s (%s), prune %s (%s)", i, p.Path, p.Rule, a.Path, a.Rule)
+		}
+	}
+}
+
+func TestPrune_UnknownClassUsesDefault(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	cfg.Backup.CustomMetadataFile = writeCustomMetadata(t, "repositories:\n  web:\n    retention_class: gold\n")
+	now := time.Now()
+	old := writeRun(t, wsDir, now, 60, "web")
+	writeRun(t, wsDir, now, 1, "web")
+
+	result, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, old)); !os.IsNotExist(err) {
+		t.Errorf("run with an unknown class past keep_days should be removed: %v", err)
+	}
+	if len(result.Actions) == 0 || result.Actions[0].Rule != "retention.keep_days" {
+		t.Errorf("actions = %+v", result.Actions)
+	}
+}
+
+func TestPrune_KeepForever(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	cfg.Retention.KeepDays = 0
+	now := time.Now()
+	old := writeRun(t, wsDir, now, 1000, "web", "core-api")
+	writeRun(t, wsDir, now, 1, "web")
+
+	result, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Repositories != 1 || result.Runs != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, old, "projects", "CORE", "repositories", "web")); err != nil {
+		t.Errorf("web should be kept forever with keep_days 0: %v", err)
+	}
+}
+
+func TestPrune_KeepRuns(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	cfg.Retention.KeepRuns = 2
+	now := time.Now()
+	mixed := writeRun(t, wsDir, now, 5, "core-api", "web")
+	webOnly := writeRun(t, wsDir, now, 4, "web")
+	kept := writeRun(t, wsDir, now, 3, "web")
+	writeRun(t, wsDir, now, 2, "web")
+
+	result, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(wsDir, rel))
+		return err == nil
+	}
+	repo := func(run, slug string) string {
+		return filepath.Join(run, "projects", "CORE", "repositories", slug)
+	}
+	switch {
+	case exists(repo(mixed, "web")):
+		t.Error("web in a run past keep_runs should be pruned, though within keep_days")
+	case !exists(repo(mixed, "core-api")):
+		t.Error("core-api has a retention class and should outlive keep_runs")
+	case exists(webOnly):
+		t.Error("a run past keep_runs with nothing left should be removed")
+	case !exists(repo(kept, "web")):
+		t.Error("the newest two runs should be kept")
+	}
+	if result.Runs != 1 || result.Repositories != 2 {
+		t.Errorf("result = %d runs, %d repositories", result.Runs, result.Repositories)
+	}
+	for _, a := range result.Actions {
+		if a.Rule != "retention.keep_runs" || a.KeepRuns != 2 {
+			t.Errorf("action %s: rule %q, keep_runs %d", a.Path, a.Rule, a.KeepRuns)
+		}
+	}
+}
+
+func TestPrune_RefreshesUsage(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	now := time.Now()
+	writeRun(t, wsDir, now, 60, "web")
+	writeRun(t, wsDir, now, 1, "web")
+
+	state := NewState("ws")
+	state.SetUsage(WorkspaceUsage{Bytes: 1 << 40})
+	statePath := filepath.Join(wsDir, StateFileName)
+	if err := state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Prune(cfg, PruneOptions{Now: now}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := state.GetUsage(); usage.Bytes >= 1<<40 {
+		t.Errorf("cached usage not refreshed after prune: %d bytes", usage.Bytes)
+	}
+}
+
+func TestPrune_RecordedStartTime(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	now := time.Now()
+	record := func(run, file string, daysAgo int) {
+		t.Helper()
+		started := now.AddDate(0, 0, -daysAgo).UTC().Format(time.RFC3339)
+		if err := os.WriteFile(filepath.Join(wsDir, run, file), []byte(`{"started_at":"`+started+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Named as recent but recorded as old, and the other way round
+	renamedRecent := writeRun(t, wsDir, now, 5, "web")
+	record(renamedRecent, "manifest.json", 60)
+	renamedOld := writeRun(t, wsDir, now, 60, "web")
+	record(renamedOld, ReportFileName, 5)
+
+	result, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, renamedRecent)); !os.IsNotExist(err) {
+		t.Error("a run recorded as started 60 days ago should be pruned whatever its name")
+	}
+	if _, err := os.Stat(filepath.Join(wsDir, renamedOld)); err != nil {
+		t.Errorf("a run recorded as started 5 days ago should be kept whatever its name: %v", err)
+	}
+	if result.Runs != 1 {
+		t.Errorf("result = %d runs removed, want 1", result.Runs)
+	}
+}
+
+func TestRunStartTime(t *testing.T) {
+	wsDir := t.TempDir()
+	mkRun := func(id, manifest string) string {
+		t.Helper()
+		dir := filepath.Join(wsDir, id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if manifest != "" {
+			if err := os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+
+	// Named in local time by an older version; the manifest is right
+	legacy := mkRun("2024-01-15T10-30-00Z-abcd1234", `{"started_at":"2024-01-15T15:30:00.25Z"}`)
+	custom := mkRun("20240115-103000-abcd1234", "")
+	crashed := mkRun("2024-01-15T10-30-00Z-ef567890", "")
+	unknown := mkRun("nightly", "")
+
+	for _, tt := range []struct {
+		dir  string
+		want time.Time
+		ok   bool
+	}{
+		{legacy, time.Date(2024, 1, 15, 15, 30, 0, 250e6, time.UTC), true},
+		{custom, time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC), true},
+		{crashed, time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC), true},
+		{unknown, time.Time{}, false},
+	} {
+		got, ok := runStartTime(tt.dir, "20060102-150405")
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("runStartTime(%s) = %v, %v, want %v, %v", filepath.Base(tt.dir), got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPrune_Archives(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	now := time.Now()
+	archived := writeRun(t, wsDir, now, 40, "web")      // Directory and archive expire together
+	kept := writeRun(t, wsDir, now, 100, "core-api")    // Left only as an archive, within critical's 365 days
+	expired := writeRun(t, wsDir, now, 400, "core-api") // Left only as an archive, past every retention
+	writeRun(t, wsDir, now, 1, "web")
+	for _, id := range []string{archived, kept, expired} {
+		if _, err := ArchiveRun(newLocalStore(t, wsDir), ".", id, config.ArchiveTarGz); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{kept, expired} {
+		if err := os.RemoveAll(filepath.Join(wsDir, id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := Prune(cfg, PruneOptions{Now: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(wsDir, name))
+		return err == nil
+	}
+	for _, id := range []string{archived, expired} {
+		if exists(id) || exists(id+".tar.gz") || exists(id+".tar.gz"+ArchiveChecksumSuffix) {
+			t.Errorf("run %s and its archive should be removed", id)
+		}
+	}
+	if !exists(kept + ".tar.gz") {
+		t.Error("the 100-day archive should be kept for the critical class")
+	}
+	if result.Runs != 2 {
+		t.Errorf("removed %d runs, want 2", result.Runs)
+	}
+	for _, a := range result.Actions {
+		if a.RunID == expired && (a.Rule != "retention.classes.critical.keep_days" || a.Bytes == 0) {
+			t.Errorf("archive action = %+v, want the critical class's rule and the archive's size", a)
+		}
+	}
+}
+
+func TestPrune_DeletesFromReplica(t *testing.T) {
+	cfg, wsDir := newPruneTestConfig(t)
+	cfg.Retention.KeepRuns = 1
+	now := time.Now()
+	old := writeRun(t, wsDir, now, 3, "web")
+	archived := writeRun(t, wsDir, now, 2, "web")
+	writeRun(t, wsDir, now, 1, "web")
+
+	// The replica holds what a backup with s3 storage uploaded
+	local, err := storage.NewLocal(cfg.Storage.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica := newLocalStore(t, t.TempDir())
+	store := storage.NewReplicated(local, replica)
+	if _, err := ArchiveRun(store, "ws", archived, config.ArchiveTarGz); err != nil {
+		t.Fatal(err)
+	}
+	files, err := local.List("ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		data, err := local.Read(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := replica.Write(f, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Prune(cfg, PruneOptions{Now: now, Storage: store}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{old, archived, archived + ".tar.gz", archived + ".tar.gz" + ArchiveChecksumSuffix} {
+		if ok, _ := replica.Exists(filepath.Join("ws", name)); ok {
+			t.Errorf("%s is still in the replica", name)
+		}
+	}
+	audit, err := replica.Read(filepath.Join("ws", PruneAuditFileName))
+	if err != nil {
+		t.Fatalf("prune audit not in the replica: %v", err)
+	}
+	if lines := strings.Count(string(audit), "\n"); lines != 4 {
+		t.Errorf("replica audit has %d lines, want 4:\n%s", lines, audit)
+	}
+}
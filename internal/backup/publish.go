@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the latest directory and its staging copy. With
+// backup.atomic_latest, latest is a symlink to a generation directory
+// (latest.<run-id>) and each run works in latest.tmp until it is published.
+const (
+	LatestDirName   = "latest"
+	latestStaging   = LatestDirName + ".tmp"
+	latestGenPrefix = LatestDirName + "."
+)
+
+// latestRoot returns the storage-relative directory that this run writes
+// latest data into: latest itself, or its staging copy when publishing
+// atomically.
+func (b *Backup) latestRoot() string {
+	if b.stagingLatest {
+		return b.cfg.Workspace + "/" + latestStaging
+	}
+	return b.cfg.Workspace + "/" + LatestDirName
+}
+
+// stageLatest prepares latest.tmp as a copy of the current latest tree so
+// the run can update it without readers of latest seeing partial results.
+// Immutable git objects and metadata files (which storage replaces by
+// rename) are hard-linked; other git files such as refs are copied since
+// git may rewrite them in place.
+func (b *Backup) stageLatest() error {
+	workspaceDir := filepath.Join(b.storage.BasePath(), b.cfg.Workspace)
+	staging := filepath.Join(workspaceDir, latestStaging)
+
+	// A leftover staging tree belongs to an interrupted run and is discarded
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("removing stale %s: %w", latestStaging, err)
+	}
+
+	src, err := filepath.EvalSymlinks(filepath.Join(workspaceDir, LatestDirName))
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return fmt.Errorf("creating %s: %w", latestStaging, err)
+		}
+		b.stagingLatest = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", LatestDirName, err)
+	}
+
+	b.log.Info("Staging %s into %s", LatestDirName, latestStaging)
+	if err := cloneTree(src, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return fmt.Errorf("staging %s: %w", LatestDirName, err)
+	}
+	b.stagingLatest = true
+	return nil
+}
+
+// publishLatest atomically replaces latest with the staged tree. The
+// staging directory becomes generation latest.<run-id> and the latest
+// symlink is flipped to it with a rename, so readers see either the old or
+// the new tree in full. A pre-existing plain latest directory is moved aside
+// once, on the first atomic publish.
+func (b *Backup) publishLatest() error {
+	workspaceDir := filepath.Join(b.storage.BasePath(), b.cfg.Workspace)
+	latest := filepath.Join(workspaceDir, LatestDirName)
+	generation := latestGenPrefix + b.runID
+	if err := os.Rename(filepath.Join(workspaceDir, latestStaging), filepath.Join(workspaceDir, generation)); err != nil {
+		return fmt.Errorf("renaming %s: %w", latestStaging, err)
+	}
+
+	var previous string
+	info, err := os.Lstat(latest)
+	switch {
+	case err == nil && info.Mode()&os.ModeSymlink != 0:
+		if target, err := os.Readlink(latest); err == nil {
+			previous = target
+		}
+	case err == nil && info.IsDir():
+		previous = latestGenPrefix + "legacy-" + b.runID
+		if err := os.Rename(latest, filepath.Join(workspaceDir, previous)); err != nil {
+			return fmt.Errorf("moving aside %s: %w", LatestDirName, err)
+		}
+	case err != nil && !os.IsNotExist(err):
+		return fmt.Errorf("inspecting %s: %w", LatestDirName, err)
+	}
+
+	tmpLink := latest + ".link"
+	_ = os.Remove(tmpLink)
+	if err := os.Symlink(generation, tmpLink); err != nil {
+		return fmt.Errorf("creating %s link: %w", LatestDirName, err)
+	}
+	if err := os.Rename(tmpLink, latest); err != nil {
+		_ = os.Remove(tmpLink)
+		return fmt.Errorf("publishing %s: %w", LatestDirName, err)
+	}
+	b.stagingLatest = false
+	b.log.Info("Published %s -> %s", LatestDirName, generation)
+
+	if previous != "" && previous != generation && strings.HasPrefix(previous, latestGenPrefix) {
+		if err := os.RemoveAll(filepath.Join(workspaceDir, previous)); err != nil {
+			b.log.Error("Failed to remove previous %s generation %s: %v", LatestDirName, previous, err)
+		}
+	}
+	return nil
+}
+
+// cloneTree recreates src at dst, hard-linking files that are never
+// modified in place and copying the rest.
+func cloneTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case linkable(rel):
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+			// Cross-device or unsupported; fall back to a copy
+		}
+		return copyFile(path, target)
+	})
+}
+
+// linkable reports whether a file under latest is safe to hard-link into
+// the staging tree: git object files are immutable, and files outside git
+// mirrors are only ever replaced via rename by storage.Write.
+func linkable(rel string) bool {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts {
+		if strings.HasSuffix(part, ".git") && i < len(parts)-1 {
+			rest := parts[i+1:]
+			if rest[0] == ".git" && len(rest) > 1 {
+				// go-git nested layout: repo.git/.git/objects
+				rest = rest[1:]
+			}
+			return rest[0] == "objects"
+		}
+	}
+	return true
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck // read-only file
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
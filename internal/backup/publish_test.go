@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestLinkable(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"projects/P/repositories/r/repository.json", true},
+		{"projects/P/repositories/r/repo.git/objects/pack/p.pack", true},
+		{"projects/P/repositories/r/repo.git/.git/objects/ab/cdef", true},
+		{"projects/P/repositories/r/repo.git/refs/heads/main", false},
+		{"projects/P/repositories/r/repo.git/packed-refs", false},
+		{"projects/P/repositories/r/repo.git/.git/HEAD", false},
+	}
+	for _, tt := range tests {
+		if got := linkable(tt.rel); got != tt.want {
+			t.Errorf("linkable(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestStageAndPublishLatest(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.cfg = &config.Config{Workspace: "ws", Backup: config.BackupConfig{AtomicLatest: true}}
+	ws := filepath.Join(b.storage.BasePath(), "ws")
+	latest := filepath.Join(ws, LatestDirName)
+
+	// Pre-existing plain latest directory from a non-atomic run
+	writeTestFile(t, filepath.Join(latest, "personal/repositories/r/repository.json"), "old")
+	writeTestFile(t, filepath.Join(latest, "personal/repositories/r/repo.git/refs/heads/main"), "old-ref")
+
+	b.runID = "run-1"
+	if err := b.stageLatest(); err != nil {
+		t.Fatalf("stageLatest() error = %v", err)
+	}
+	if got := b.latestRoot(); got != "ws/"+latestStaging {
+		t.Errorf("latestRoot() = %q while staging", got)
+	}
+
+	// Updates land in staging and are invisible through latest
+	if err := b.storage.Write(b.latestRoot()+"/personal/repositories/r/repository.json", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(ws, latestStaging, "personal/repositories/r/repo.git/refs/heads/main"), "new-ref")
+	if got := readTestFile(t, filepath.Join(latest, "personal/repositories/r/repository.json")); got != "old" {
+		t.Errorf("latest metadata changed before publish: %q", got)
+	}
+	if got := readTestFile(t, filepath.Join(latest, "personal/repositories/r/repo.git/refs/heads/main")); got != "old-ref" {
+		t.Errorf("latest ref changed before publish: %q", got)
+	}
+
+	if err := b.publishLatest(); err != nil {
+		t.Fatalf("publishLatest() error = %v", err)
+	}
+	target, err := os.Readlink(latest)
+	if err != nil {
+		t.Fatalf("latest is not a symlink: %v", err)
+	}
+	if target != "latest.run-1" {
+		t.Errorf("latest -> %q, want latest.run-1", target)
+	}
+	if got := readTestFile(t, filepath.Join(latest, "personal/repositories/r/repository.json")); got != "new" {
+		t.Errorf("published metadata = %q, want new", got)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "latest.legacy-run-1")); !os.IsNotExist(err) {
+		t.Error("expected legacy latest directory to be removed")
+	}
+
+	// A second cycle flips the link and drops the previous generation
+	b.runID = "run-2"
+	if err := b.stageLatest(); err != nil {
+		t.Fatalf("stageLatest() error = %v", err)
+	}
+	if err := b.publishLatest(); err != nil {
+		t.Fatalf("publishLatest() error = %v", err)
+	}
+	if target, _ := os.Readlink(latest); target != "latest.run-2" {
+		t.Errorf("latest -> %q, want latest.run-2", target)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "latest.run-1")); !os.IsNotExist(err) {
+		t.Error("expected previous generation to be removed")
+	}
+	if got := readTestFile(t, filepath.Join(latest, "personal/repositories/r/repo.git/refs/heads/main")); got != "new-ref" {
+		t.Errorf("ref after second publish = %q, want new-ref", got)
+	}
+}
+
+func TestStageLatest_DiscardsStaleStaging(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	ws := filepath.Join(b.storage.BasePath(), "ws")
+	writeTestFile(t, filepath.Join(ws, latestStaging, "stale.json"), "x")
+
+	if err := b.stageLatest(); err != nil {
+		t.Fatalf("stageLatest() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(ws, latestStaging, "stale.json")); !os.IsNotExist(err) {
+		t.Error("expected stale staging contents to be discarded")
+	}
+}
+
+func TestStagedRun_InterruptedKeepsState(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var values []json.RawMessage
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/pullrequests"):
+			queries = append(queries, "pullrequests "+r.URL.Query().Get("q"))
+			values = []json.RawMessage{json.RawMessage(`{"id":7,"title":"PR","updated_on":"2025-01-05T00:00:00Z"}`)}
+		case strings.HasSuffix(r.URL.Path, "/issues"):
+			queries = append(queries, "issues "+r.URL.Query().Get("q"))
+			values = []json.RawMessage{json.RawMessage(`{"id":3,"title":"Issue","updated_on":"2025-01-05T00:00:00Z"}`)}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	}))
+	defer server.Close()
+
+	b := newRunTestBackup(t, "")
+	b.cfg = config.Default()
+	b.cfg.Workspace = "ws"
+	b.cfg.Storage.Path = b.storage.BasePath()
+	b.cfg.RateLimit.RequestsPerHour = 36000
+	b.cfg.Backup.AtomicLatest = true
+	b.cfg.Backup.IncludePRComments = false
+	b.cfg.Backup.IncludePRActivity = false
+	b.cfg.Backup.IncludeIssueComments = false
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+	statePath := GetStatePath(b.cfg.Storage.Path, "ws")
+
+	// The last published run saw everything up to January 1st
+	b.state = NewState("ws")
+	b.state.UpdateRepository("repo", "{uuid}", "", "")
+	b.state.SetRepoLastPRUpdated("repo", "2025-01-01T00:00:00Z")
+	b.state.SetRepoLastIssueUpdated("repo", "2025-01-01T00:00:00Z")
+	if err := b.state.Save(statePath); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &api.Repository{Slug: "repo", FullName: "ws/repo", HasIssues: true}
+	stagedRun := func(runID string) {
+		t.Helper()
+		b.runID = runID
+		if err := b.stageLatest(); err != nil {
+			t.Fatalf("stageLatest() error = %v", err)
+		}
+		latestDir := b.latestRoot() + "/repositories/repo"
+		if n, _, err := b.backupPullRequestsWorker(context.Background(), runID+"/repositories/repo", latestDir, repo); err != nil || n != 1 {
+			t.Fatalf("%s: backed up %d pull requests (%v), want 1", runID, n, err)
+		}
+		if n, _, err := b.backupIssuesWorker(context.Background(), runID+"/repositories/repo", latestDir, repo); err != nil || n != 1 {
+			t.Fatalf("%s: backed up %d issues (%v), want 1", runID, n, err)
+		}
+	}
+
+	// The first run is interrupted before publishing; its checkpoints and
+	// the save on abort must not record what only latest.tmp holds
+	stagedRun("run-1")
+	b.checkpointState(statePath, "test")
+	b.Abort()
+
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.GetLastPRUpdated("repo"); got != "2025-01-01T00:00:00Z" {
+		t.Errorf("saved last_pr_updated = %q, want the published run's", got)
+	}
+	if got := state.GetLastIssueUpdated("repo"); got != "2025-01-01T00:00:00Z" {
+		t.Errorf("saved last_issue_updated = %q, want the published run's", got)
+	}
+
+	// The next run starts from the saved state, discards latest.tmp, and
+	// fetches the same pull request and issue again
+	b.state = state
+	b.stagingLatest = false
+	b.shuttingDown.Store(false)
+	queries = nil
+	stagedRun("run-2")
+	for _, q := range queries {
+		if !strings.Contains(q, "2025-01-01") {
+			t.Errorf("request %q does not query since the published run", q)
+		}
+	}
+	ws := filepath.Join(b.storage.BasePath(), "ws", latestStaging, "repositories/repo")
+	for _, f := range []string{"pull-requests/7.json", "issues/3.json"} {
+		if _, err := os.Stat(filepath.Join(ws, f)); err != nil {
+			t.Errorf("%s not refetched into %s: %v", f, latestStaging, err)
+		}
+	}
+}
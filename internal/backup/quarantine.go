@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"sort"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// RepoStatusQuarantined marks a repository skipped because it is in
+// quarantine after failing several runs in a row.
+const RepoStatusQuarantined = "quarantined"
+
+// manualQuarantineReason is recorded for repositories put in quarantine
+// with --requarantine.
+const manualQuarantineReason = "quarantined manually"
+
+// QuarantinedRepo is a repository that kept failing and is skipped for a
+// number of runs so it does not cost time on every run. When the cool-down
+// is over it is tried again; a success releases it, another failure puts
+// it back for twice as long.
+type QuarantinedRepo struct {
+	Slug          string `json:"slug"`
+	Error         string `json:"error"`
+	QuarantinedAt string `json:"quarantined_at"`
+	// Level counts the quarantines in a row; the cool-down doubles with each
+	Level int `json:"level"`
+	// SkipRuns is the number of runs left to skip before the next attempt
+	SkipRuns int `json:"skip_runs"`
+}
+
+// Quarantined returns the quarantine entry for a repository.
+func (s *State) Quarantined(slug string) (QuarantinedRepo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q, ok := s.Quarantine[slug]
+	return q, ok
+}
+
+// QuarantineRepo puts a repository in quarantine, or back in it one level
+// higher, and returns the entry. runs maps the new level to the number of
+// runs to skip.
+func (s *State) QuarantineRepo(slug, errMsg string, runs func(level int) int) QuarantinedRepo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Quarantine == nil {
+		s.Quarantine = make(map[string]QuarantinedRepo)
+	}
+	level := s.Quarantine[slug].Level + 1
+	q := QuarantinedRepo{
+		Slug:          slug,
+		Error:         errMsg,
+		QuarantinedAt: time.Now().UTC().Format(time.RFC3339),
+		Level:         level,
+		SkipRuns:      runs(level),
+	}
+	s.Quarantine[slug] = q
+	return q
+}
+
+// skipQuarantined uses up one skipped run of a quarantined repository. It
+// returns the entry and true if the repository is to be skipped this run.
+func (s *State) skipQuarantined(slug string) (QuarantinedRepo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.Quarantine[slug]
+	if !ok || q.SkipRuns <= 0 {
+		return q, false
+	}
+	q.SkipRuns--
+	s.Quarantine[slug] = q
+	return q, true
+}
+
+// ReleaseQuarantine takes a repository out of quarantine and resets its
+// consecutive failure count. It reports whether it was quarantined.
+func (s *State) ReleaseQuarantine(slug string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.FailedRepos[slug]; ok {
+		f.Consecutive = 0
+		s.FailedRepos[slug] = f
+	}
+	if _, ok := s.Quarantine[slug]; !ok {
+		return false
+	}
+	delete(s.Quarantine, slug)
+	return true
+}
+
+// GetQuarantined returns the quarantined repositories sorted by slug.
+func (s *State) GetQuarantined() []QuarantinedRepo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	repos := make([]QuarantinedRepo, 0, len(s.Quarantine))
+	for _, q := range s.Quarantine {
+		repos = append(repos, q)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Slug < repos[j].Slug })
+	return repos
+}
+
+// quarantineRuns returns the number of runs to skip at a quarantine level:
+// backup.quarantine_runs, doubled for each level above the first and
+// capped at backup.quarantine_max_runs.
+func (b *Backup) quarantineRuns(level int) int {
+	runs, limit := b.cfg.Backup.QuarantineRuns, b.cfg.Backup.QuarantineMaxRuns
+	if runs < 1 {
+		runs = 1
+	}
+	for i := 1; i < level; i++ {
+		runs *= 2
+		if limit > 0 && runs >= limit {
+			return limit
+		}
+	}
+	return runs
+}
+
+// applyQuarantineControls applies --unquarantine and --requarantine before
+// the run plans its repositories.
+func (b *Backup) applyQuarantineControls() {
+	for _, slug := range b.opts.Unquarantine {
+		if b.state.ReleaseQuarantine(slug) {
+			b.log.Info("Released %s from quarantine", slug)
+		} else {
+			b.log.Info("Repository %s is not quarantined", slug)
+		}
+	}
+	for _, slug := range b.opts.Requarantine {
+		q := b.state.QuarantineRepo(slug, manualQuarantineReason, b.quarantineRuns)
+		b.log.Info("Quarantined %s for %d runs", slug, q.SkipRuns)
+	}
+}
+
+// planQuarantine drops quarantined repositories whose cool-down is not
+// over, recording them in the report. Each skip counts down the
+// cool-down, except in dry runs. It returns the repositories to process
+// and the number skipped.
+func (b *Backup) planQuarantine(repos []api.Repository) (planned []api.Repository, skipped int) {
+	planned = repos[:0:0]
+	for _, repo := range repos {
+		var q QuarantinedRepo
+		var skip bool
+		if b.opts.DryRun {
+			q, skip = b.state.Quarantined(repo.Slug)
+			skip = skip && q.SkipRuns > 0
+		} else {
+			q, skip = b.state.skipQuarantined(repo.Slug)
+		}
+		if !skip {
+			planned = append(planned, repo)
+			continue
+		}
+		skipped++
+		b.log.Debug("Skipping quarantined repository %s (%d more runs after this one): %s", repo.Slug, q.SkipRuns, q.Error)
+		entry := RepoReport{Slug: repo.Slug, Status: RepoStatusQuarantined, Archived: repo.IsArchived, Error: q.Error}
+		if repo.Project != nil {
+			entry.Project = repo.Project.Key
+		}
+		b.report.Add(entry)
+	}
+	return planned, skipped
+}
+
+// quarantineFailed quarantines a repository that just failed if it has now
+// failed backup.quarantine_after runs in a row, or escalates its
+// quarantine if this run was its attempt after a cool-down.
+func (b *Backup) quarantineFailed(slug, errMsg string) {
+	_, was := b.state.Quarantined(slug)
+	if !was {
+		after := b.cfg.Backup.QuarantineAfter
+		if after <= 0 || b.state.consecutiveFailures(slug) < after {
+			return
+		}
+	}
+	q := b.state.QuarantineRepo(slug, errMsg, b.quarantineRuns)
+	if was {
+		b.log.Info("Repository %s failed again after quarantine; skipping it for the next %d runs", slug, q.SkipRuns)
+	} else {
+		b.log.Info("Repository %s failed %d runs in a row; skipping it for the next %d runs", slug, b.cfg.Backup.QuarantineAfter, q.SkipRuns)
+	}
+}
+
+// consecutiveFailures returns the number of runs in a row a repository has
+// failed.
+func (s *State) consecutiveFailures(slug string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.FailedRepos[slug].Consecutive
+}
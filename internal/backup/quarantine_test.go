@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func newQuarantineTestBackup(t *testing.T, after int) *Backup {
+	t.Helper()
+	b := newRunTestBackup(t, "")
+	b.cfg.Backup.QuarantineAfter = after
+	b.cfg.Backup.QuarantineRuns = 2
+	b.cfg.Backup.QuarantineMaxRuns = 6
+	b.state = NewState("ws")
+	return b
+}
+
+// failRun records a failed run for slug the way the result collector does.
+func failRun(b *Backup, slug string) {
+	b.state.AddFailedRepo(slug, "", errors.New("object not found"), 1)
+	b.quarantineFailed(slug, "object not found")
+}
+
+func TestQuarantineRuns(t *testing.T) {
+	b := newQuarantineTestBackup(t, 3)
+	for level, want := range map[int]int{1: 2, 2: 4, 3: 6, 10: 6} {
+		if got := b.quarantineRuns(level); got != want {
+			t.Errorf("quarantineRuns(%d) = %d, want %d", level, got, want)
+		}
+	}
+}
+
+func TestQuarantine_CoolDown(t *testing.T) {
+	b := newQuarantineTestBackup(t, 3)
+	repos := []api.Repository{{Slug: "corrupt"}, {Slug: "fine"}}
+
+	failRun(b, "corrupt")
+	failRun(b, "corrupt")
+	if _, ok := b.state.Quarantined("corrupt"); ok {
+		t.Fatal("quarantined before quarantine_after failures")
+	}
+	failRun(b, "corrupt")
+	q, ok := b.state.Quarantined("corrupt")
+	if !ok || q.Level != 1 || q.SkipRuns != 2 {
+		t.Fatalf("after 3 failures: %+v, %v", q, ok)
+	}
+
+	// Skipped for two runs, then tried again
+	for run := 1; run <= 2; run++ {
+		planned, skipped := b.planQuarantine(repos)
+		if skipped != 1 || slugs(planned) != "fine" {
+			t.Fatalf("run %d: planned %s, skipped %d", run, slugs(planned), skipped)
+		}
+	}
+	if planned, _ := b.planQuarantine(repos); slugs(planned) != "corrupt,fine" {
+		t.Fatalf("after cool-down: planned %s", slugs(planned))
+	}
+
+	// Failing again doubles the cool-down at once
+	failRun(b, "corrupt")
+	if q, _ := b.state.Quarantined("corrupt"); q.Level != 2 || q.SkipRuns != 4 {
+		t.Fatalf("after failing again: %+v", q)
+	}
+
+	if got := b.report.Repositories[0]; got.Status != RepoStatusQuarantined || got.Error != "object not found" {
+		t.Errorf("report entry = %+v", got)
+	}
+}
+
+func TestQuarantine_DryRunKeepsCoolDown(t *testing.T) {
+	b := newQuarantineTestBackup(t, 1)
+	b.opts.DryRun = true
+	failRun(b, "corrupt")
+
+	for i := 0; i < 3; i++ {
+		if _, skipped := b.planQuarantine([]api.Repository{{Slug: "corrupt"}}); skipped != 1 {
+			t.Fatalf("dry run %d: skipped %d", i, skipped)
+		}
+	}
+	if q, _ := b.state.Quarantined("corrupt"); q.SkipRuns != 2 {
+		t.Errorf("SkipRuns = %d after dry runs, want 2", q.SkipRuns)
+	}
+}
+
+func TestQuarantine_Disabled(t *testing.T) {
+	b := newQuarantineTestBackup(t, 0)
+	for i := 0; i < 5; i++ {
+		failRun(b, "corrupt")
+	}
+	if _, ok := b.state.Quarantined("corrupt"); ok {
+		t.Error("quarantined with quarantine_after 0")
+	}
+}
+
+func TestQuarantine_Controls(t *testing.T) {
+	b := newQuarantineTestBackup(t, 0)
+	b.opts.Requarantine = []string{"corrupt"}
+	b.applyQuarantineControls()
+	if q, ok := b.state.Quarantined("corrupt"); !ok || q.Error != manualQuarantineReason || q.SkipRuns != 2 {
+		t.Fatalf("after --requarantine: %+v, %v", q, ok)
+	}
+
+	// A repository put in quarantine by hand escalates like any other
+	b.applyQuarantineControls()
+	if q, _ := b.state.Quarantined("corrupt"); q.Level != 2 || q.SkipRuns != 4 {
+		t.Fatalf("after second --requarantine: %+v", q)
+	}
+
+	failRun(b, "corrupt")
+	b.opts.Requarantine = nil
+	b.opts.Unquarantine = []string{"corrupt"}
+	b.applyQuarantineControls()
+	if _, ok := b.state.Quarantined("corrupt"); ok {
+		t.Fatal("still quarantined after --unquarantine")
+	}
+	if n := b.state.consecutiveFailures("corrupt"); n != 0 {
+		t.Errorf("consecutive failures = %d after --unquarantine, want 0", n)
+	}
+}
+
+func TestAddFailedRepo_Consecutive(t *testing.T) {
+	s := NewState("ws")
+	s.AddFailedRepo("repo", "", errors.New("boom"), 1)
+	s.AddFailedRepo("repo", "", errors.New("boom"), 1)
+	if n := s.consecutiveFailures("repo"); n != 2 {
+		t.Errorf("consecutive = %d, want 2", n)
+	}
+	s.RemoveFailedRepo("repo")
+	s.AddFailedRepo("repo", "", errors.New("boom"), 1)
+	if n := s.consecutiveFailures("repo"); n != 1 {
+		t.Errorf("consecutive after a success = %d, want 1", n)
+	}
+}
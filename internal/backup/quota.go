@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/format"
+)
+
+// WorkspaceUsage is the disk space used by a workspace's backups: every
+// run directory, latest/, and the state file. Sizes are apparent file
+// sizes; files hard-linked between trees are counted once per path.
+type WorkspaceUsage struct {
+	Bytes      int64  `json:"bytes"`
+	Files      int64  `json:"files"`
+	MeasuredAt string `json:"measured_at"`
+	RunID      string `json:"run_id,omitempty"` // Run that measured it
+}
+
+// SetUsage records the workspace's measured disk usage.
+func (s *State) SetUsage(u WorkspaceUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Usage = &u
+}
+
+// GetUsage returns the last measured disk usage, if any.
+func (s *State) GetUsage() (WorkspaceUsage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.Usage == nil {
+		return WorkspaceUsage{}, false
+	}
+	return *s.Usage, true
+}
+
+// MeasureWorkspace sums the sizes of the regular files under a workspace
+// backup directory. Symlinks such as current are not followed.
+func MeasureWorkspace(dir string) (WorkspaceUsage, error) {
+	usage := WorkspaceUsage{MeasuredAt: time.Now().UTC().Format(time.RFC3339)}
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files removed mid-walk, e.g. by a concurrent prune
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil //nolint:nilerr // removed since listed
+		}
+		usage.Bytes += info.Size()
+		usage.Files++
+		return nil
+	})
+	if err != nil {
+		return usage, fmt.Errorf("measuring %s: %w", dir, err)
+	}
+	return usage, nil
+}
+
+// workspaceDir returns the absolute directory holding the workspace's runs.
+func (b *Backup) workspaceDir() string {
+	return filepath.Join(b.storage.BasePath(), b.cfg.Workspace)
+}
+
+// checkQuota compares the workspace's usage with storage.max_workspace_size
+// before a run. Usage cached in the state file is used when there is one;
+// otherwise the workspace is measured now. Over the cap, quota_action
+// "block" stops the run and "warn" logs and carries on.
+func (b *Backup) checkQuota() error {
+	limit := b.cfg.Storage.MaxWorkspaceBytes()
+	if limit <= 0 {
+		return nil
+	}
+	usage, ok := b.state.GetUsage()
+	if !ok {
+		if _, err := os.Stat(b.workspaceDir()); err != nil {
+			return nil
+		}
+		measured, err := MeasureWorkspace(b.workspaceDir())
+		if err != nil {
+			b.log.Error("Failed to measure workspace usage: %v", err)
+			return nil
+		}
+		usage = measured
+		b.state.SetUsage(usage)
+	}
+	if usage.Bytes <= limit {
+		b.log.Debug("Workspace usage %s of %s (storage.max_workspace_size)", format.Bytes(usage.Bytes), format.Bytes(limit))
+		return nil
+	}
+
+	msg := fmt.Sprintf("workspace backups use %s, over storage.max_workspace_size %s (measured %s); prune old runs or raise the limit",
+		format.Bytes(usage.Bytes), b.cfg.Storage.MaxWorkspaceSize, usage.MeasuredAt)
+	if b.cfg.Storage.QuotaAction == "block" {
+		return fmt.Errorf("%s", msg)
+	}
+	b.log.Error("Storage quota exceeded: %s", msg)
+	return nil
+}
+
+// measureUsage measures the workspace after a run, caches the result in
+// the state file, and warns if it is over storage.max_workspace_size so
+// the next run's check is not the first anyone hears of it.
+func (b *Backup) measureUsage() *WorkspaceUsage {
+	usage, err := MeasureWorkspace(b.workspaceDir())
+	if err != nil {
+		b.log.Error("Failed to measure workspace usage: %v", err)
+		return nil
+	}
+	usage.RunID = b.runID
+	b.state.SetUsage(usage)
+	b.log.Info("Workspace usage: %s in %s files", format.Bytes(usage.Bytes), format.Count(usage.Files))
+
+	if limit := b.cfg.Storage.MaxWorkspaceBytes(); limit > 0 && usage.Bytes > limit {
+		next := "will warn"
+		if b.cfg.Storage.QuotaAction == "block" {
+			next = "will not start"
+		}
+		b.log.Error("Workspace usage %s is over storage.max_workspace_size %s; the next run %s", format.Bytes(usage.Bytes), b.cfg.Storage.MaxWorkspaceSize, next)
+	}
+	return &usage
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSizedFile(t *testing.T, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMeasureWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	writeSizedFile(t, filepath.Join(dir, "run-1", "manifest.json"), 100)
+	writeSizedFile(t, filepath.Join(dir, "latest", "repo.git", "objects", "pack"), 1000)
+	if err := os.Symlink("run-1", filepath.Join(dir, "current")); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := MeasureWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Bytes != 1100 || usage.Files != 2 || usage.MeasuredAt == "" {
+		t.Errorf("MeasureWorkspace() = %+v, want 1100 bytes in 2 files (symlink not followed)", usage)
+	}
+}
+
+func newQuotaTestBackup(t *testing.T, limit, action string) *Backup {
+	t.Helper()
+	b := newRunTestBackup(t, "")
+	b.cfg.Storage.MaxWorkspaceSize = limit
+	b.cfg.Storage.QuotaAction = action
+	b.state = NewState("ws")
+	writeSizedFile(t, filepath.Join(b.workspaceDir(), "run-1", "data"), 2048)
+	return b
+}
+
+func TestCheckQuota(t *testing.T) {
+	// Under the cap; measured now because the state has no usage yet
+	b := newQuotaTestBackup(t, "4KB", "block")
+	if err := b.checkQuota(); err != nil {
+		t.Fatalf("checkQuota() under the cap = %v", err)
+	}
+	if u, ok := b.state.GetUsage(); !ok || u.Bytes != 2048 {
+		t.Errorf("usage not cached: %+v, %v", u, ok)
+	}
+
+	b = newQuotaTestBackup(t, "1KB", "block")
+	err := b.checkQuota()
+	if err == nil || !strings.Contains(err.Error(), "storage.max_workspace_size 1KB") {
+		t.Errorf("checkQuota() over the cap with block = %v", err)
+	}
+
+	b = newQuotaTestBackup(t, "1KB", "warn")
+	if err := b.checkQuota(); err != nil {
+		t.Errorf("checkQuota() over the cap with warn = %v", err)
+	}
+}
+
+func TestCheckQuota_UsesCachedUsage(t *testing.T) {
+	b := newQuotaTestBackup(t, "1KB", "block")
+	b.state.SetUsage(WorkspaceUsage{Bytes: 512})
+	if err := b.checkQuota(); err != nil {
+		t.Errorf("checkQuota() = %v, want the cached usage to be trusted", err)
+	}
+
+	b.runID = "run-2"
+	usage := b.measureUsage()
+	if usage == nil || usage.Bytes != 2048 || usage.RunID != "run-2" {
+		t.Fatalf("measureUsage() = %+v", usage)
+	}
+	if err := b.checkQuota(); err == nil {
+		t.Error("checkQuota() passed after the usage was remeasured over the cap")
+	}
+}
@@ -0,0 +1,362 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// RawIndexFileName is the per-repository index written in raw mode.
+const RawIndexFileName = "raw-index.json"
+
+// RawIndex lists the API endpoints fetched for a repository in raw mode and
+// the files their values were written to.
+type RawIndex struct {
+	Repository  string          `json:"repository"`
+	GeneratedAt string          `json:"generated_at"`
+	Entries     []RawIndexEntry `json:"entries"`
+}
+
+// RawIndexEntry records one endpoint fetch. File is relative to the
+// repository directory.
+type RawIndexEntry struct {
+	Endpoint string `json:"endpoint"`
+	File     string `json:"file"`
+	Count    int    `json:"count"`
+}
+
+// rawRecord holds the only fields raw mode parses from a value: enough to
+// name files, fetch sub-resources, and track incremental timestamps.
+type rawRecord struct {
+	ID          int          `json:"id"`
+	UpdatedOn   string       `json:"updated_on"`
+	Description string       `json:"description"` // Pull requests
+	Content     *api.Content `json:"content"`     // Issues
+}
+
+// add records an endpoint fetch in the index.
+func (idx *RawIndex) add(endpoint, file string, count int) {
+	idx.Entries = append(idx.Entries, RawIndexEntry{Endpoint: endpoint, File: file, Count: count})
+}
+
+// rawOrTyped returns the raw payload in raw mode, falling back to v when no
+// payload was captured.
+func (b *Backup) rawOrTyped(v interface{}, raw json.RawMessage) interface{} {
+	if b.cfg.Backup.RawMode && len(raw) > 0 {
+		return raw
+	}
+	return v
+}
+
+// backupRawMetadata fetches PRs and issues as raw API values and writes them
+// verbatim to both the timestamped and latest repository directories, along
+// with an index of the endpoints fetched. It returns the PR and issue counts;
+// the error is set only when a write failed verification.
+func (b *Backup) backupRawMetadata(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) (int, int, error) {
+	prefix := api.LogPrefix(ctx)
+	index := &RawIndex{
+		Repository:  repo.FullName,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Entries:     make([]RawIndexEntry, 0),
+	}
+	if repo.Links.Self.Href != "" {
+		index.add(repo.Links.Self.Href, "repository.json", 1)
+	}
+
+	var prCount, issueCount int
+	if b.cfg.Backup.IncludePRs {
+		count, err := b.backupPullRequestsRaw(ctx, repoDir, latestRepoDir, repo, index)
+		if isStorageFailure(err) {
+			return count, 0, fmt.Errorf("saving pull requests: %w", err)
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to backup PRs for %s: %v", prefix, repo.Slug, err)
+		}
+		prCount = count
+	}
+
+	if b.cfg.Backup.IncludeIssues && repo.HasIssues {
+		count, err := b.backupIssuesRaw(ctx, repoDir, latestRepoDir, repo, index)
+		if isStorageFailure(err) {
+			return prCount, count, fmt.Errorf("saving issues: %w", err)
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to backup issues for %s: %v", prefix, repo.Slug, err)
+		}
+		issueCount = count
+	}
+
+	if !b.opts.DryRun {
+		for _, dir := range []string{repoDir, latestRepoDir} {
+			if err := b.saveJSON(dir, RawIndexFileName, index); err != nil {
+				if isStorageFailure(err) {
+					return prCount, issueCount, err
+				}
+				b.log.Error("%sFailed to save raw index for %s: %v", prefix, repo.Slug, err)
+			}
+		}
+	}
+
+	return prCount, issueCount, nil
+}
+
+// backupPullRequestsRaw fetches PRs and their sub-resources as raw values.
+func (b *Backup) backupPullRequestsRaw(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository, index *RawIndex) (int, error) {
+	prefix := api.LogPrefix(ctx)
+
+	if b.progress != nil && !b.shuttingDown.Load() {
+		b.progress.UpdateStatus(fmt.Sprintf("fetching PRs: %s", repo.Slug))
+	}
+
+	var values []json.RawMessage
+	lastPRUpdated := b.state.GetLastPRUpdated(b.repoStateKey(repo))
+	isIncremental := !b.opts.Full && lastPRUpdated != ""
+	if isIncremental {
+		path := api.PullRequestsUpdatedSincePath(b.cfg.Workspace, repo.Slug, lastPRUpdated)
+		page, err := b.client.GetPaginated(ctx, path)
+		if err != nil {
+			return 0, fmt.Errorf("fetching updated pull requests: %w", err)
+		}
+		index.add(path, "pull-requests/", len(page))
+		values = page
+	} else {
+		for _, state := range api.PullRequestStates {
+			path := api.PullRequestsPath(b.cfg.Workspace, repo.Slug, state)
+			page, err := b.client.GetPaginated(ctx, path)
+			if err != nil {
+				return 0, fmt.Errorf("fetching pull requests for %s/%s: %w", b.cfg.Workspace, repo.Slug, err)
+			}
+			index.add(path, "pull-requests/", len(page))
+			values = append(values, page...)
+		}
+	}
+
+	if len(values) == 0 {
+		if !isIncremental && !b.opts.DryRun {
+			b.state.SetRepoLastPRUpdated(b.repoStateKey(repo), time.Now().UTC().Format(time.RFC3339))
+		}
+		return 0, nil
+	}
+	b.log.Debug("%sFound %d raw pull requests for %s", prefix, len(values), repo.Slug)
+
+	count := 0
+	var latestUpdated string
+	for i, value := range values {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		if b.progress != nil && !b.shuttingDown.Load() {
+			b.progress.UpdateStatus(fmt.Sprintf("saving PRs: %s (%d/%d)", repo.Slug, i+1, len(values)))
+		}
+
+		var rec rawRecord
+		if err := json.Unmarshal(value, &rec); err != nil || rec.ID == 0 {
+			b.log.Error("%sSkipping pull request without an id in %s: %v", prefix, repo.Slug, err)
+			continue
+		}
+		if rec.UpdatedOn > latestUpdated {
+			latestUpdated = rec.UpdatedOn
+		}
+		b.validateRaw(ctx, fmt.Sprintf("PR #%d", rec.ID), value, &api.PullRequest{})
+
+		if b.opts.DryRun {
+			count++
+			continue
+		}
+
+		prFile := fmt.Sprintf("pull-requests/%d.json", rec.ID)
+		if err := b.saveRaw(repoDir, latestRepoDir, prFile, value); err != nil {
+			if isStorageFailure(err) {
+				return count, err
+			}
+			b.log.Error("%sFailed to save PR #%d: %v", prefix, rec.ID, err)
+			continue
+		}
+
+		subDir := fmt.Sprintf("pull-requests/%d", rec.ID)
+		if err := b.saveAttachments(ctx, fmt.Sprintf("PR #%d", rec.ID), b.visibleText(rec.Description, "description"),
+			filepath.Join(repoDir, subDir), filepath.Join(latestRepoDir, subDir)); err != nil {
+			if isStorageFailure(err) {
+				return count, err
+			}
+			b.log.Error("%s%v", prefix, err)
+		}
+		if b.cfg.Backup.IncludePRComments {
+			path := api.PullRequestCommentsPath(b.cfg.Workspace, repo.Slug, rec.ID)
+			if err := b.fetchRawList(ctx, repoDir, latestRepoDir, path, subDir+"/comments.json", index, func() interface{} { return &api.PRComment{} }); err != nil {
+				return count, err
+			}
+		}
+		if b.cfg.Backup.IncludePRActivity {
+			path := api.PullRequestActivityPath(b.cfg.Workspace, repo.Slug, rec.ID)
+			if err := b.fetchRawList(ctx, repoDir, latestRepoDir, path, subDir+"/activity.json", index, func() interface{} { return &api.PRActivity{} }); err != nil {
+				return count, err
+			}
+			path = api.PullRequestTasksPath(b.cfg.Workspace, repo.Slug, rec.ID)
+			if err := b.fetchRawList(ctx, repoDir, latestRepoDir, path, subDir+"/tasks.json", index, func() interface{} { return &api.PRTask{} }); err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+
+	if latestUpdated != "" && !b.opts.DryRun {
+		b.state.SetRepoLastPRUpdated(b.repoStateKey(repo), latestUpdated)
+	}
+
+	return count, nil
+}
+
+// backupIssuesRaw fetches issues and their comments as raw values.
+func (b *Backup) backupIssuesRaw(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository, index *RawIndex) (int, error) {
+	prefix := api.LogPrefix(ctx)
+
+	if b.progress != nil && !b.shuttingDown.Load() {
+		b.progress.UpdateStatus(fmt.Sprintf("fetching issues: %s", repo.Slug))
+	}
+
+	lastIssueUpdated := b.state.GetLastIssueUpdated(b.repoStateKey(repo))
+	isIncremental := !b.opts.Full && lastIssueUpdated != ""
+	path := api.IssuesPath(b.cfg.Workspace, repo.Slug)
+	if isIncremental {
+		path = api.IssuesUpdatedSincePath(b.cfg.Workspace, repo.Slug, lastIssueUpdated)
+	}
+
+	values, err := b.client.GetPaginated(ctx, path)
+	if err != nil {
+		// A 404 means the issue tracker is disabled
+		var apiErr *api.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 {
+			return 0, fmt.Errorf("fetching issues for %s/%s: %w", b.cfg.Workspace, repo.Slug, err)
+		}
+		values = nil
+	}
+	index.add(path, "issues/", len(values))
+
+	if len(values) == 0 {
+		if !isIncremental && !b.opts.DryRun {
+			b.state.SetRepoLastIssueUpdated(b.repoStateKey(repo), time.Now().UTC().Format(time.RFC3339))
+		}
+		return 0, nil
+	}
+	b.log.Debug("%sFound %d raw issues for %s", prefix, len(values), repo.Slug)
+
+	count := 0
+	var latestUpdated string
+	for i, value := range values {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		if b.progress != nil && !b.shuttingDown.Load() {
+			b.progress.UpdateStatus(fmt.Sprintf("saving issues: %s (%d/%d)", repo.Slug, i+1, len(values)))
+		}
+
+		var rec rawRecord
+		if err := json.Unmarshal(value, &rec); err != nil || rec.ID == 0 {
+			b.log.Error("%sSkipping issue without an id in %s: %v", prefix, repo.Slug, err)
+			continue
+		}
+		if rec.UpdatedOn > latestUpdated {
+			latestUpdated = rec.UpdatedOn
+		}
+		b.validateRaw(ctx, fmt.Sprintf("issue #%d", rec.ID), value, &api.Issue{})
+
+		if b.opts.DryRun {
+			count++
+			continue
+		}
+
+		issueFile := fmt.Sprintf("issues/%d.json", rec.ID)
+		if err := b.saveRaw(repoDir, latestRepoDir, issueFile, value); err != nil {
+			if isStorageFailure(err) {
+				return count, err
+			}
+			b.log.Error("%sFailed to save issue #%d: %v", prefix, rec.ID, err)
+			continue
+		}
+
+		if rec.Content != nil {
+			subDir := fmt.Sprintf("issues/%d", rec.ID)
+			if err := b.saveAttachments(ctx, fmt.Sprintf("issue #%d", rec.ID), b.visibleText(rec.Content.Raw, "content", "raw"),
+				filepath.Join(repoDir, subDir), filepath.Join(latestRepoDir, subDir)); err != nil {
+				if isStorageFailure(err) {
+					return count, err
+				}
+				b.log.Error("%s%v", prefix, err)
+			}
+		}
+
+		if b.cfg.Backup.IncludeIssueComments {
+			path := api.IssueCommentsPath(b.cfg.Workspace, repo.Slug, rec.ID)
+			file := fmt.Sprintf("issues/%d/comments.json", rec.ID)
+			if err := b.fetchRawList(ctx, repoDir, latestRepoDir, path, file, index, func() interface{} { return &api.IssueComment{} }); err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+
+	if latestUpdated != "" && !b.opts.DryRun {
+		b.state.SetRepoLastIssueUpdated(b.repoStateKey(repo), latestUpdated)
+	}
+
+	return count, nil
+}
+
+// fetchRawList fetches a paginated sub-resource and saves its values as a
+// JSON array. Failures are logged; sub-resources never fail the parent
+// unless a write failed verification, which is returned.
+func (b *Backup) fetchRawList(ctx context.Context, repoDir, latestRepoDir, path, file string, index *RawIndex, newTyped func() interface{}) error {
+	prefix := api.LogPrefix(ctx)
+
+	values, err := b.client.GetPaginated(api.WithPriority(ctx, api.PriorityLow), path)
+	if err != nil {
+		if !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to fetch %s: %v", prefix, path, err)
+		}
+		return nil
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	for _, value := range values {
+		b.validateRaw(ctx, file, value, newTyped())
+	}
+	index.add(path, file, len(values))
+
+	if err := b.saveRaw(repoDir, latestRepoDir, file, values); err != nil {
+		if isStorageFailure(err) {
+			return err
+		}
+		b.log.Error("%sFailed to save %s: %v", prefix, file, err)
+	}
+	return nil
+}
+
+// saveRaw writes data under both the timestamped and latest repository
+// directories.
+func (b *Backup) saveRaw(repoDir, latestRepoDir, file string, data interface{}) error {
+	for _, base := range []string{repoDir, latestRepoDir} {
+		if err := b.saveEntity(filepath.Join(base, filepath.Dir(file)), filepath.Base(file), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRaw decodes a raw value into its typed struct when raw
+// validation is enabled, logging if the payload no longer fits.
+func (b *Backup) validateRaw(ctx context.Context, what string, value json.RawMessage, typed interface{}) {
+	if !b.cfg.Backup.RawValidate {
+		return
+	}
+	if err := json.Unmarshal(value, typed); err != nil {
+		b.log.Info("%sRaw %s does not match the typed schema: %v", api.LogPrefix(ctx), what, err)
+	}
+}
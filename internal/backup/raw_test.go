@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+func TestBackupRawMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var values []json.RawMessage
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/pullrequests") && r.URL.Query().Get("state") == "OPEN":
+			values = []json.RawMessage{json.RawMessage(`{"id":1,"updated_on":"2025-01-02T00:00:00Z","future_field":{"a":1}}`)}
+		case strings.HasSuffix(r.URL.Path, "/pullrequests/1/comments"):
+			values = []json.RawMessage{json.RawMessage(`{"id":5,"content":{"raw":"hi"},"reactions":[]}`)}
+		case strings.HasSuffix(r.URL.Path, "/issues"):
+			values = []json.RawMessage{json.RawMessage(`{"id":9,"updated_on":"2025-01-03T00:00:00Z","title":"Bug"}`)}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+	cfg.Backup.RawMode = true
+	cfg.Backup.RawValidate = true
+
+	tmpDir := t.TempDir()
+	store, err := storage.NewLocal(tmpDir)
+	if err != nil {
+		t.Fatalf("creating storage: %v", err)
+	}
+
+	b := &Backup{
+		cfg:     cfg,
+		storage: store,
+		log:     &defaultLogger{quiet: true},
+		state:   NewState("ws"),
+	}
+	setClient(b, api.NewClient(cfg, api.WithBaseURL(server.URL)))
+
+	b.state.UpdateRepository("repo", "{uuid}", "", "")
+
+	repo := &api.Repository{Slug: "repo", FullName: "ws/repo", HasIssues: true}
+	prs, issues, err := b.backupRawMetadata(context.Background(), "run/repositories/repo", "ws/latest/personal/repositories/repo", repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prs != 1 || issues != 1 {
+		t.Fatalf("expected 1 PR and 1 issue, got %d and %d", prs, issues)
+	}
+
+	for _, dir := range []string{"run/repositories/repo", "ws/latest/personal/repositories/repo"} {
+		data, err := os.ReadFile(filepath.Join(tmpDir, dir, "pull-requests", "1.json"))
+		if err != nil {
+			t.Fatalf("reading PR: %v", err)
+		}
+		if !strings.Contains(string(data), "future_field") {
+			t.Errorf("expected unknown field preserved in %s, got %s", dir, data)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, dir, "pull-requests", "1", "comments.json")); err != nil {
+			t.Errorf("expected comments.json in %s: %v", dir, err)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, dir, "issues", "9.json")); err != nil {
+			t.Errorf("expected issue file in %s: %v", dir, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "run/repositories/repo", RawIndexFileName))
+	if err != nil {
+		t.Fatalf("reading index: %v", err)
+	}
+	var index RawIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("parsing index: %v", err)
+	}
+	found := false
+	for _, e := range index.Entries {
+		if e.File == "pull-requests/1/comments.json" && e.Count == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected comments entry in index, got %+v", index.Entries)
+	}
+
+	if got := b.state.GetLastPRUpdated("repo"); got != "2025-01-02T00:00:00Z" {
+		t.Errorf("expected PR timestamp from raw value, got %q", got)
+	}
+}
@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// ReadmeFileName is the per-repository README and description snapshot
+// written next to repository.json in the run directory and latest/, so
+// browse and other consumers can show what a repository is without
+// opening its mirror.
+const ReadmeFileName = "readme.json"
+
+// readmeMaxSize caps the README content kept in the snapshot.
+const readmeMaxSize = 64 << 10
+
+// ReadmeSnapshot is the content of readme.json. Readme is nil when the
+// default branch has no README.
+type ReadmeSnapshot struct {
+	Slug        string      `json:"slug"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	GeneratedAt string      `json:"generated_at"`
+	Readme      *git.Readme `json:"readme,omitempty"`
+}
+
+// saveReadme records the repository's description and the README from its
+// mirror's default branch. The privacy policy applies as for other
+// entities. Failures are logged and never fail the backup.
+func (b *Backup) saveReadme(ctx context.Context, repoDir, latestRepoDir, gitPath string, repo *api.Repository) {
+	prefix := api.LogPrefix(ctx)
+
+	readme, err := git.ReadReadme(gitPath, readmeMaxSize)
+	if err != nil {
+		b.log.Error("%sFailed to read README of %s: %v", prefix, repo.Slug, err)
+		return
+	}
+	snapshot := ReadmeSnapshot{
+		Slug:        repo.Slug,
+		Name:        repo.Name,
+		Description: repo.Description,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Readme:      readme,
+	}
+
+	for _, dir := range []string{repoDir, latestRepoDir} {
+		if err := b.saveEntity(dir, ReadmeFileName, snapshot); err != nil {
+			b.log.Error("%sFailed to save README snapshot: %v", prefix, err)
+		}
+	}
+}
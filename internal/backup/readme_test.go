@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func TestSaveReadme(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	work := t.TempDir()
+	if err := os.WriteFile(filepath.Join(work, "README.md"), []byte("# API\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mirror := filepath.Join(t.TempDir(), "repo.git")
+	for _, args := range [][]string{
+		{"-C", work, "init", "-q", "-b", "main"},
+		{"-C", work, "add", "."},
+		{"-C", work, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "-m", "init"},
+		{"clone", "-q", "--mirror", work, mirror},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	b := newRunTestBackup(t, "")
+	repo := &api.Repository{Slug: "api", Name: "API", Description: "Public API"}
+	b.saveReadme(context.Background(), "ws/run/repositories/api", "ws/latest/repositories/api", mirror, repo)
+
+	for _, dir := range []string{"ws/run/repositories/api", "ws/latest/repositories/api"} {
+		data, err := b.storage.Read(dir + "/" + ReadmeFileName)
+		if err != nil {
+			t.Fatalf("reading %s: %v", ReadmeFileName, err)
+		}
+		var got ReadmeSnapshot
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Description != "Public API" || got.Readme == nil || got.Readme.Content != "# API\n" || got.Readme.Branch != "main" {
+			t.Errorf("%s snapshot = %+v", dir, got)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// OpenStorage returns the storage for cfg.Storage. For type "s3" that is
+// the local working copy at storage.path replicated to the bucket. Git
+// mirrors stay in the working copy because git needs a filesystem; they
+// reach the bucket as bundles (see uploadMirror).
+func OpenStorage(cfg *config.Config, opts ...storage.LocalOption) (storage.Storage, error) {
+	local, err := storage.NewLocal(cfg.Storage.Path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Storage.Type != "s3" {
+		return local, nil
+	}
+	s3cfg := cfg.Storage.S3
+	remote, err := storage.NewS3(storage.S3Options{
+		Bucket:    s3cfg.Bucket,
+		Region:    s3cfg.Region,
+		Endpoint:  s3cfg.Endpoint,
+		Prefix:    s3cfg.Prefix,
+		PathStyle: s3cfg.PathStyle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewReplicated(local, remote), nil
+}
+
+// Files a repository's git mirror is uploaded as with s3 storage, in its
+// latest/ directory.
+const (
+	MirrorBundleFileName     = "repo.bundle"
+	mirrorBundleRefsFileName = "repo.bundle.json"
+)
+
+// mirrorBundle records the refs in an uploaded bundle.
+type mirrorBundle struct {
+	CreatedAt string            `json:"created_at"`
+	Refs      map[string]string `json:"refs"`
+}
+
+// uploadMirror copies a repository's git mirror to the bucket with s3
+// storage, as a bundle of all its refs: a bucket cannot hold a repository
+// git can use, but "git clone --mirror repo.bundle" restores one. The
+// bundle is only replaced when the refs differ from those recorded in
+// repo.bundle.json at the last upload. It is built in the working copy and
+// removed from it once uploaded. Empty repositories have nothing to bundle.
+func (b *Backup) uploadMirror(ctx context.Context, latestRepoDir, gitPath string) error {
+	if b.cfg.Storage.Type != "s3" {
+		return nil
+	}
+	refs, err := git.ReadRefs(gitPath)
+	if err != nil {
+		return fmt.Errorf("reading refs: %w", err)
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	var last mirrorBundle
+	if data, err := b.storage.Read(filepath.Join(latestRepoDir, mirrorBundleRefsFileName)); err == nil &&
+		json.Unmarshal(data, &last) == nil && maps.Equal(last.Refs, refs) {
+		return nil
+	}
+
+	bundlePath := filepath.Join(latestRepoDir, MirrorBundleFileName)
+	local := filepath.Join(b.storage.BasePath(), bundlePath)
+	if err := git.CreateBundle(ctx, gitPath, refs, nil, local); err != nil {
+		return fmt.Errorf("bundling mirror: %w", err)
+	}
+	defer os.Remove(local) //nolint:errcheck // uploaded copy is what counts
+	if err := storage.WriteFile(b.storage, bundlePath, local); err != nil {
+		return err
+	}
+	return b.saveJSON(latestRepoDir, mirrorBundleRefsFileName, mirrorBundle{
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Refs:      refs,
+	})
+}
+
+// replicateMove repeats in the bucket, with s3 storage, the rename of a
+// repository's latest/ directory in the working copy: its files are
+// uploaded under the new path and the old path is deleted. Git mirrors are
+// skipped; the record of the last bundle is dropped so the next upload
+// bundles the mirror under the new path.
+func (b *Backup) replicateMove(oldDir, newDir string) error {
+	if b.cfg.Storage.Type != "s3" {
+		return nil
+	}
+	oldRel, oldOK := storageRel(b.storage, oldDir)
+	newRel, newOK := storageRel(b.storage, newDir)
+	if !oldOK || !newOK {
+		return fmt.Errorf("moving %s to %s: outside storage %s", oldDir, newDir, b.storage.BasePath())
+	}
+	if err := os.Remove(filepath.Join(newDir, mirrorBundleRefsFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err := filepath.WalkDir(newDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasSuffix(d.Name(), ".git") {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(newDir, path)
+		if err != nil {
+			return err
+		}
+		return storage.WriteFile(b.storage, filepath.Join(newRel, rel), path)
+	})
+	if err != nil {
+		return err
+	}
+	return b.storage.Delete(oldRel)
+}
+
+// fetchRemoteState copies the state file from the bucket when the working
+// copy has none, so an incremental run on a new machine or an emptied
+// storage.path picks up where the last one stopped.
+func fetchRemoteState(store storage.Storage, statePath string) error {
+	replicated, ok := store.(*storage.Replicated)
+	if !ok {
+		return nil
+	}
+	if _, err := os.Stat(statePath); err == nil || !os.IsNotExist(err) {
+		return nil
+	}
+	rel, ok := storageRel(store, statePath)
+	if !ok {
+		return nil
+	}
+	data, err := replicated.Replica().Read(rel)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("fetching state from %s: %w", replicated.Replica().BasePath(), err)
+	}
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return fmt.Errorf("creating state directory: %w", err)
+	}
+	return os.WriteFile(statePath, data, 0644)
+}
@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+func TestUploadMirror(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	b := newRunTestBackup(t, "")
+	b.cfg.Storage.Type = "s3"
+	replica := newLocalStore(t, t.TempDir())
+	b.storage = storage.NewReplicated(b.storage, replica)
+
+	runGit := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	commit := func(src, msg string) {
+		t.Helper()
+		writeTestFile(t, filepath.Join(src, msg+".txt"), msg)
+		runGit("-C", src, "add", ".")
+		runGit("-C", src, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "-m", msg)
+	}
+	src := filepath.Join(t.TempDir(), "src")
+	runGit("init", "-q", "-b", "main", src)
+	commit(src, "one")
+
+	latestRepoDir := "ws/latest/projects/CORE/repositories/api"
+	gitPath := filepath.Join(b.storage.BasePath(), latestRepoDir, "repo.git")
+	runGit("clone", "-q", "--mirror", src, gitPath)
+	ctx := context.Background()
+	if err := b.uploadMirror(ctx, latestRepoDir, gitPath); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := filepath.Join(latestRepoDir, MirrorBundleFileName)
+	if ok, _ := replica.Exists(bundle); !ok {
+		t.Fatal("bundle was not uploaded")
+	}
+	if _, err := os.Stat(filepath.Join(b.storage.BasePath(), bundle)); !os.IsNotExist(err) {
+		t.Error("the bundle should not be kept in the working copy")
+	}
+	restored := filepath.Join(t.TempDir(), "restored.git")
+	runGit("clone", "-q", "--mirror", filepath.Join(replica.BasePath(), bundle), restored)
+	runGit("--git-dir", restored, "rev-parse", "--verify", "refs/heads/main")
+
+	// Unchanged refs are not uploaded again
+	if err := replica.Delete(bundle); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.uploadMirror(ctx, latestRepoDir, gitPath); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := replica.Exists(bundle); ok {
+		t.Error("bundle was uploaded again without a change")
+	}
+
+	// New commits are
+	commit(src, "two")
+	runGit("--git-dir", gitPath, "fetch", "-q", "--prune", "origin")
+	if err := b.uploadMirror(ctx, latestRepoDir, gitPath); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := replica.Exists(bundle); !ok {
+		t.Error("bundle was not uploaded after the refs changed")
+	}
+}
+
+func TestReplicateMove(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.cfg.Storage.Type = "s3"
+	replica := newLocalStore(t, t.TempDir())
+	b.storage = storage.NewReplicated(b.storage, replica)
+
+	oldRel := "ws/latest/projects/OLD/repositories/api"
+	newRel := "ws/latest/projects/NEW/repositories/api"
+	for _, name := range []string{"repository.json", mirrorBundleRefsFileName, MirrorBundleFileName} {
+		if err := b.storage.Write(filepath.Join(oldRel, name), []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(b.storage.BasePath(), oldRel, "repo.git", "HEAD"), "ref: refs/heads/main\n")
+	oldDir, newDir := filepath.Join(b.storage.BasePath(), oldRel), filepath.Join(b.storage.BasePath(), newRel)
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.replicateMove(oldDir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := replica.Exists(oldRel); ok {
+		t.Error("the old path is still in the replica")
+	}
+	for name, want := range map[string]bool{
+		"repository.json":        true,
+		"repo.bundle":            true,
+		mirrorBundleRefsFileName: false, // So the mirror is bundled again
+		"repo.git/HEAD":          false,
+	} {
+		if ok, _ := replica.Exists(filepath.Join(newRel, name)); ok != want {
+			t.Errorf("replica has %s = %v, want %v", name, ok, want)
+		}
+	}
+}
+
+// newLocalStore returns local storage rooted at dir.
+func newLocalStore(t *testing.T, dir string) storage.Storage {
+	t.Helper()
+	store, err := storage.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/git"
+	"github.com/andy-wilson/bb-backup/internal/scan"
+)
+
+// ReportFileName is the name of the per-run report written next to the manifest.
+const ReportFileName = "report.json"
+
+// Repository outcomes recorded in the run report.
+const (
+	RepoStatusOK          = "ok"
+	RepoStatusFailed      = "failed"
+	RepoStatusInterrupted = "interrupted"
+)
+
+// IssuesSkippedRestricted marks a repository whose issue tracker returned
+// 403 and was skipped.
+const IssuesSkippedRestricted = "restricted"
+
+// Report records per-repository outcomes for a single backup run.
+type Report struct {
+	mu           sync.Mutex   `json:"-"`
+	RunID        string       `json:"run_id,omitempty"`
+	Workspace    string       `json:"workspace"`
+	StartedAt    string       `json:"started_at"`
+	CompletedAt  string       `json:"completed_at"`
+	APIUsage     *APIUsage    `json:"api_usage,omitempty"`
+	Repositories []RepoReport `json:"repositories"`
+}
+
+// RepoReport records the outcome of backing up a single repository.
+type RepoReport struct {
+	Slug        string         `json:"slug"`
+	Project     string         `json:"project,omitempty"`
+	Status      string         `json:"status"`
+	Archived    bool           `json:"archived,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Code        errcode.Code   `json:"code,omitempty"`
+	GitEngine   string         `json:"git_engine,omitempty"`
+	GitProtocol string         `json:"git_protocol,omitempty"`
+	Findings    []scan.Finding `json:"findings,omitempty"`
+	ScanError   string         `json:"scan_error,omitempty"`
+
+	// PackScan is set when scan.pack_command scanned the objects the fetch
+	// added; a flagged update is quarantined
+	PackScan *PackScan `json:"pack_scan,omitempty"`
+
+	// Owner is the account a repository outside any project belongs to
+	Owner string `json:"owner,omitempty"`
+
+	// FetchSkipped is set when git.precheck_refs found the mirror already
+	// up to date
+	FetchSkipped bool `json:"fetch_skipped,omitempty"`
+
+	// RefRewrites lists force-pushes, moved tags, and deleted branches seen
+	// by this run's fetch
+	RefRewrites []git.RefRewrite `json:"ref_rewrites,omitempty"`
+
+	// Entities an incremental run fetched but did not rewrite because they
+	// matched latest/
+	PullRequestsUnchanged int `json:"pull_requests_unchanged,omitempty"`
+	IssuesUnchanged       int `json:"issues_unchanged,omitempty"`
+
+	// IssuesSkipped says why issues were not backed up although the
+	// repository has a tracker, e.g. "restricted"
+	IssuesSkipped string `json:"issues_skipped,omitempty"`
+
+	// Move is set when the repository was found in a different project
+	Move *RepoMove `json:"move,omitempty"`
+
+	// Time taken and mirror size for a successful backup. Ballooned is set
+	// when it took far longer than the repository's recent history
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Bytes           int64   `json:"bytes,omitempty"`
+	Ballooned       bool    `json:"ballooned,omitempty"`
+
+	// Custom is the operator-provided metadata from custom_metadata_file
+	Custom CustomFields `json:"custom,omitempty"`
+}
+
+// NewReport creates an empty run report.
+func NewReport(workspace string) *Report {
+	return &Report{
+		Workspace:    workspace,
+		Repositories: make([]RepoReport, 0),
+	}
+}
+
+// Add records the outcome for a repository.
+func (r *Report) Add(entry RepoReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Repositories = append(r.Repositories, entry)
+}
+
+// Sort orders repositories by project then slug for stable output.
+func (r *Report) Sort() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sort.Slice(r.Repositories, func(i, j int) bool {
+		a, b := r.Repositories[i], r.Repositories[j]
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		return a.Slug < b.Slug
+	})
+}
+
+// RewriteCount returns the total number of history rewrites across repositories.
+func (r *Report) RewriteCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, repo := range r.Repositories {
+		count += len(repo.RefRewrites)
+	}
+	return count
+}
+
+// FailureCodes counts the failed repositories by failure code.
+func (r *Report) FailureCodes() map[errcode.Code]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var counts map[errcode.Code]int
+	for _, repo := range r.Repositories {
+		if repo.Status != RepoStatusFailed {
+			continue
+		}
+		if counts == nil {
+			counts = make(map[errcode.Code]int)
+		}
+		counts[repo.Code]++
+	}
+	return counts
+}
+
+// FindingCount returns the total number of scan findings across repositories.
+func (r *Report) FindingCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, repo := range r.Repositories {
+		count += len(repo.Findings)
+	}
+	return count
+}
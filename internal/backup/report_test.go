@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/scan"
+)
+
+func TestReport_AddAndSort(t *testing.T) {
+	r := NewReport("ws")
+	r.Add(RepoReport{Slug: "zeta", Project: "B", Status: RepoStatusOK})
+	r.Add(RepoReport{Slug: "alpha", Project: "B", Status: RepoStatusOK})
+	r.Add(RepoReport{Slug: "omega", Project: "A", Status: RepoStatusFailed,
+		Findings: []scan.Finding{{Rule: "aws-access-key"}, {Rule: "private-key"}}})
+	r.Sort()
+
+	want := []string{"omega", "alpha", "zeta"}
+	for i, slug := range want {
+		if r.Repositories[i].Slug != slug {
+			t.Errorf("Repositories[%d] = %q, want %q", i, r.Repositories[i].Slug, slug)
+		}
+	}
+	if got := r.FindingCount(); got != 2 {
+		t.Errorf("FindingCount() = %d, want 2", got)
+	}
+}
+
+func TestRepoResult_RepoReport(t *testing.T) {
+	result := repoResult{
+		repo: &api.Repository{Slug: "repo", Project: &api.Project{Key: "PROJ"}},
+		stats: repoStats{
+			Findings:    []scan.Finding{{Rule: "private-key"}},
+			ScanError:   "scan failed",
+			GitEngine:   gitEngineCLI,
+			GitProtocol: gitProtocolSSH,
+		},
+		err: errors.New("clone failed"),
+	}
+
+	entry := result.repoReport(RepoStatusFailed)
+	if entry.Slug != "repo" || entry.Project != "PROJ" {
+		t.Errorf("unexpected identity: %+v", entry)
+	}
+	if entry.Status != RepoStatusFailed || entry.Error != "clone failed" {
+		t.Errorf("unexpected status: %+v", entry)
+	}
+	if len(entry.Findings) != 1 || entry.ScanError != "scan failed" {
+		t.Errorf("unexpected scan results: %+v", entry)
+	}
+	if entry.GitEngine != gitEngineCLI || entry.GitProtocol != gitProtocolSSH {
+		t.Errorf("unexpected git engine/protocol: %+v", entry)
+	}
+	if entry.Code != errcode.Unknown {
+		t.Errorf("Code = %q, want %q", entry.Code, errcode.Unknown)
+	}
+}
+
+func TestReport_FailureCodes(t *testing.T) {
+	r := NewReport("ws")
+	r.Add(RepoReport{Slug: "a", Status: RepoStatusFailed, Code: errcode.GitTimeout})
+	r.Add(RepoReport{Slug: "b", Status: RepoStatusFailed, Code: errcode.GitTimeout})
+	r.Add(RepoReport{Slug: "c", Status: RepoStatusFailed, Code: errcode.AuthFailed})
+	r.Add(RepoReport{Slug: "d", Status: RepoStatusOK})
+
+	codes := r.FailureCodes()
+	if len(codes) != 2 || codes[errcode.GitTimeout] != 2 || codes[errcode.AuthFailed] != 1 {
+		t.Errorf("FailureCodes() = %v", codes)
+	}
+	if NewReport("ws").FailureCodes() != nil {
+		t.Error("FailureCodes() of a clean run is not nil")
+	}
+
+	err := reposFailedError(3, codes)
+	if errcode.Of(err) != errcode.ReposFailed || err.Error() != "3 repositories failed (AUTH_FAILED: 1, GIT_TIMEOUT: 2)" {
+		t.Errorf("reposFailedError() = %q (%s)", err, errcode.Of(err))
+	}
+}
@@ -0,0 +1,368 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// Policies for a backed-up repository whose slug already exists in the
+// workspace being restored into.
+const (
+	RestoreSkip          = "skip"           // Leave the live repository alone
+	RestoreOverwriteRefs = "overwrite-refs" // Make its branches and tags match the backup
+	RestoreNewSlug       = "new-slug"       // Create a repository at a slug given for it
+	RestorePrefix        = "prefix"         // Create a repository at the slug with a prefix
+	RestoreAsk           = "ask"            // Decided interactively before the restore
+)
+
+// RestoreCreate is the action for a repository missing from the workspace:
+// it is created at its own slug.
+const RestoreCreate = "create"
+
+// DefaultRestorePrefix is put before the slugs of repositories restored
+// with RestorePrefix when no prefix is given.
+const DefaultRestorePrefix = "restored-"
+
+// RestorePolicies lists the policies accepted for existing repositories.
+var RestorePolicies = []string{RestoreSkip, RestoreOverwriteRefs, RestoreNewSlug, RestorePrefix, RestoreAsk}
+
+// RestoreOptions selects what a restore pushes and how it treats
+// repositories that already exist.
+type RestoreOptions struct {
+	Repos      []string          // Slug glob patterns; empty restores every repository
+	OnConflict string            // Policy for existing repositories; empty is RestoreSkip
+	Policies   map[string]string // Policy by slug, overriding OnConflict
+	NewSlugs   map[string]string // Slug to restore to, by slug, for RestoreNewSlug
+	Prefix     string            // For RestorePrefix; empty is DefaultRestorePrefix
+}
+
+// RestoreAction is what a restore does with one backed-up repository.
+type RestoreAction struct {
+	Project string `json:"project,omitempty"`
+	Owner   string `json:"owner,omitempty"`
+	Slug    string `json:"slug"`
+	Exists  bool   `json:"exists"`           // The slug already exists in the workspace
+	Action  string `json:"action"`           // RestoreCreate or one of RestorePolicies
+	Target  string `json:"target,omitempty"` // Slug pushed to; empty when skipped or undecided
+	Create  bool   `json:"create,omitempty"` // Target is created before the push
+
+	// Branch and tag changes an overwrite makes to the live repository
+	RefsCreated int `json:"refs_created,omitempty"`
+	RefsUpdated int `json:"refs_updated,omitempty"`
+	RefsDeleted int `json:"refs_deleted,omitempty"`
+
+	Restored bool   `json:"restored,omitempty"`
+	Error    string `json:"error,omitempty"` // Why the action cannot be or was not carried out
+
+	mirror   string          // Path of the backed-up mirror
+	repo     *api.Repository // The backed-up repository.json
+	cloneURL string          // The target's clone URL, when it exists
+}
+
+// Name returns the repository's place in the backup, e.g. PROJ/slug.
+func (a *RestoreAction) Name() string {
+	switch {
+	case a.Project != "":
+		return a.Project + "/" + a.Slug
+	case a.Owner != "":
+		return a.Owner + "/" + a.Slug
+	}
+	return a.Slug
+}
+
+// RestorePlan lists what a restore does, one action per repository.
+type RestorePlan struct {
+	Workspace    string           `json:"workspace"`
+	Repositories []*RestoreAction `json:"repositories"`
+}
+
+// Collisions returns the number of repositories that already exist.
+func (p *RestorePlan) Collisions() int {
+	n := 0
+	for _, a := range p.Repositories {
+		if a.Exists {
+			n++
+		}
+	}
+	return n
+}
+
+// Undecided returns the actions still to be decided with Restorer.Decide.
+func (p *RestorePlan) Undecided() []*RestoreAction {
+	var undecided []*RestoreAction
+	for _, a := range p.Repositories {
+		if a.Action == RestoreAsk {
+			undecided = append(undecided, a)
+		}
+	}
+	return undecided
+}
+
+// Failed returns the number of actions with an error.
+func (p *RestorePlan) Failed() int {
+	n := 0
+	for _, a := range p.Repositories {
+		if a.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Restorer pushes the git mirrors of a workspace backup to the configured
+// workspace. Only branches and tags are restored; pull requests, issues,
+// and wikis are not.
+type Restorer struct {
+	cfg      *config.Config
+	provider api.Provider
+	git      *git.ShellGitClient
+}
+
+// NewRestorer creates a Restorer with the configured credentials. Pushing
+// needs the git CLI.
+func NewRestorer(cfg *config.Config) (*Restorer, error) {
+	authProvider := auth.FromConfig(cfg)
+	gitClient := git.NewShellGitClient(
+		git.WithShellCredentialFunc(auth.GitCredentialFunc(authProvider)),
+		git.WithShellSSHKey(cfg.Git.SSHKeyPath),
+	)
+	if gitClient == nil {
+		return nil, fmt.Errorf("restoring requires the git CLI")
+	}
+	client := api.NewClient(cfg, api.WithAuthProvider(authProvider))
+	return &Restorer{cfg: cfg, provider: client.Provider, git: gitClient}, nil
+}
+
+// Plan works out what restoring the latest mirrors under workspaceDir
+// would do, without changing anything. Repositories missing from the
+// workspace are created at their slug; those that exist get the policy
+// for them in opts.
+func (r *Restorer) Plan(ctx context.Context, workspaceDir string, opts RestoreOptions) (*RestorePlan, error) {
+	if opts.OnConflict == "" {
+		opts.OnConflict = RestoreSkip
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultRestorePrefix
+	}
+	if !validRestorePolicy(opts.OnConflict) {
+		return nil, fmt.Errorf("unknown conflict policy %q (want one of %s)", opts.OnConflict, strings.Join(RestorePolicies, ", "))
+	}
+	for slug, policy := range opts.Policies {
+		if !validRestorePolicy(policy) {
+			return nil, fmt.Errorf("unknown policy %q for %s (want one of %s)", policy, slug, strings.Join(RestorePolicies, ", "))
+		}
+	}
+
+	mirrors, err := FindMirrors(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	filter := NewRepoFilter(opts.Repos, nil)
+	plan := &RestorePlan{Workspace: r.cfg.Workspace}
+	for _, m := range mirrors {
+		a, err := newRestoreAction(m)
+		if err != nil {
+			return nil, err
+		}
+		if !filter.ShouldInclude(a.Slug) {
+			continue
+		}
+		plan.Repositories = append(plan.Repositories, a)
+
+		live, err := r.lookup(ctx, a.Slug)
+		if err != nil {
+			a.Error = err.Error()
+			continue
+		}
+		if live == nil {
+			a.Action, a.Target, a.Create = RestoreCreate, a.Slug, true
+			continue
+		}
+		a.Exists = true
+		a.cloneURL = live.CloneURL()
+
+		policy, target := opts.OnConflict, ""
+		if s, ok := opts.NewSlugs[a.Slug]; ok {
+			policy, target = RestoreNewSlug, s
+		}
+		if p, ok := opts.Policies[a.Slug]; ok {
+			policy = p
+		}
+		if policy == RestorePrefix {
+			target = opts.Prefix + a.Slug
+		}
+		// A failed decision is recorded on the action
+		_ = r.Decide(ctx, a, policy, target)
+	}
+	return plan, nil
+}
+
+// Decide sets the policy for a repository that already exists. target is
+// the slug to create for RestoreNewSlug and RestorePrefix, and must not
+// exist yet.
+func (r *Restorer) Decide(ctx context.Context, a *RestoreAction, policy, target string) error {
+	a.Action, a.Target, a.Create, a.Error = policy, "", false, ""
+	a.RefsCreated, a.RefsUpdated, a.RefsDeleted = 0, 0, 0
+
+	var err error
+	switch policy {
+	case RestoreSkip, RestoreAsk:
+	case RestoreOverwriteRefs:
+		a.Target = a.Slug
+		err = r.countRefChanges(ctx, a)
+	case RestoreNewSlug, RestorePrefix:
+		err = r.setNewTarget(ctx, a, target)
+	default:
+		err = fmt.Errorf("unknown policy %q", policy)
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return err
+}
+
+// setNewTarget points an action at a slug to create.
+func (r *Restorer) setNewTarget(ctx context.Context, a *RestoreAction, target string) error {
+	switch target {
+	case "":
+		return fmt.Errorf("no new slug given for %s", a.Slug)
+	case a.Slug:
+		return fmt.Errorf("new slug for %s is the same slug", a.Slug)
+	}
+	a.Target = target
+	live, err := r.lookup(ctx, target)
+	if err != nil {
+		return err
+	}
+	if live != nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+	a.Create = true
+	return nil
+}
+
+// countRefChanges records how overwriting the live repository would
+// change its branches and tags.
+func (r *Restorer) countRefChanges(ctx context.Context, a *RestoreAction) error {
+	remote, err := r.git.RemoteRefs(ctx, r.cfg.Git.CloneURL(a.cloneURL))
+	if err != nil {
+		return fmt.Errorf("listing refs of %s: %w", a.Slug, err)
+	}
+	local, err := git.ReadRefs(a.mirror)
+	if err != nil {
+		return fmt.Errorf("reading refs of the backup of %s: %w", a.Slug, err)
+	}
+	for name := range local {
+		if !strings.HasPrefix(name, "refs/heads/") && !strings.HasPrefix(name, "refs/tags/") {
+			delete(local, name)
+		}
+	}
+	for _, u := range git.DiffRefs(remote, local) {
+		switch {
+		case u.Old == "":
+			a.RefsCreated++
+		case u.New == "":
+			a.RefsDeleted++
+		default:
+			a.RefsUpdated++
+		}
+	}
+	return nil
+}
+
+// Restore carries out a plan: it creates the repositories planned for
+// creation and pushes each mirror's branches and tags to its target.
+// Each action's outcome is recorded on it; the error is for a plan that
+// still has undecided actions.
+func (r *Restorer) Restore(ctx context.Context, plan *RestorePlan) error {
+	if n := len(plan.Undecided()); n > 0 {
+		return fmt.Errorf("%d repositories have no policy decided", n)
+	}
+	for _, a := range plan.Repositories {
+		if a.Target == "" || a.Error != "" {
+			continue
+		}
+		if err := r.restore(ctx, a); err != nil {
+			a.Error = err.Error()
+			continue
+		}
+		a.Restored = true
+	}
+	return nil
+}
+
+func (r *Restorer) restore(ctx context.Context, a *RestoreAction) error {
+	cloneURL := a.cloneURL
+	if a.Create {
+		repo := *a.repo
+		repo.Slug = a.Target
+		if a.Target != a.Slug {
+			repo.Name = a.Target
+		}
+		created, err := r.provider.CreateRepository(ctx, r.cfg.Workspace, &repo)
+		if err != nil {
+			return err
+		}
+		cloneURL = created.CloneURL()
+	}
+	if cloneURL == "" {
+		return fmt.Errorf("%s has no HTTPS clone link", a.Target)
+	}
+	return r.git.PushMirror(ctx, a.mirror, r.cfg.Git.CloneURL(cloneURL))
+}
+
+// lookup fetches a repository of the workspace, or nil if there is none.
+func (r *Restorer) lookup(ctx context.Context, slug string) (*api.Repository, error) {
+	repo, err := r.provider.GetRepository(ctx, r.cfg.Workspace, slug)
+	var apiErr *api.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	return repo, err
+}
+
+// newRestoreAction reads a mirror's repository.json, which has its slug
+// where the directory name was disambiguated, and the project and
+// settings to create it with.
+func newRestoreAction(m Mirror) (*RestoreAction, error) {
+	repo := &api.Repository{Slug: m.Slug, IsPrivate: true}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(m.Path), "repository.json"))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, repo); err != nil {
+			return nil, fmt.Errorf("parsing repository.json of %s: %w", m.Slug, err)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	if repo.Slug == "" {
+		repo.Slug = m.Slug
+	}
+	if repo.Project == nil && m.Project != "" {
+		repo.Project = &api.Project{Key: m.Project}
+	}
+	if repo.Owner == nil && m.Owner != "" {
+		repo.Owner = &api.User{Username: m.Owner}
+	}
+	return &RestoreAction{Project: m.Project, Owner: m.Owner, Slug: repo.Slug, mirror: m.Path, repo: repo}, nil
+}
+
+func validRestorePolicy(policy string) bool {
+	for _, p := range RestorePolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
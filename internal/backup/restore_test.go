@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// restoreProvider serves the repositories of a workspace as bare
+// repositories under dir; the other endpoints are left unimplemented.
+type restoreProvider struct {
+	api.Provider
+	dir     string
+	created []string
+}
+
+func (p *restoreProvider) GetRepository(_ context.Context, _, slug string) (*api.Repository, error) {
+	path := filepath.Join(p.dir, slug+".git")
+	if _, err := os.Stat(path); err != nil {
+		return nil, &api.APIError{StatusCode: http.StatusNotFound, Message: "not found"}
+	}
+	return &api.Repository{Slug: slug, Links: api.Links{Clone: []api.Link{{Name: "https", Href: path}}}}, nil
+}
+
+func (p *restoreProvider) CreateRepository(ctx context.Context, workspace string, repo *api.Repository) (*api.Repository, error) {
+	if out, err := exec.Command("git", "init", "-q", "--bare", filepath.Join(p.dir, repo.Slug+".git")).CombinedOutput(); err != nil {
+		return nil, &api.APIError{StatusCode: http.StatusBadRequest, Message: string(out)}
+	}
+	p.created = append(p.created, repo.Slug)
+	return p.GetRepository(ctx, workspace, repo.Slug)
+}
+
+// newRestoreTest backs up two repositories of project CORE, api and site,
+// and returns a Restorer for a workspace where only api still exists, with
+// a branch added since the backup.
+func newRestoreTest(t *testing.T) (*Restorer, *restoreProvider, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	runGit := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	src := filepath.Join(t.TempDir(), "src")
+	runGit("init", "-q", "-b", "main", src)
+	writeTestFile(t, filepath.Join(src, "README.md"), "restore me")
+	runGit("-C", src, "add", ".")
+	runGit("-C", src, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "-m", "initial")
+	runGit("-C", src, "tag", "v1")
+
+	workspaceDir := t.TempDir()
+	for _, slug := range []string{"api", "site"} {
+		dir := filepath.Join(workspaceDir, LatestDirName, "projects", "CORE", "repositories", slug)
+		runGit("clone", "-q", "--mirror", src, filepath.Join(dir, "repo.git"))
+		writeTestFile(t, filepath.Join(dir, "repository.json"), `{"slug":"`+slug+`","name":"`+slug+`","project":{"key":"CORE"}}`)
+	}
+
+	provider := &restoreProvider{dir: t.TempDir()}
+	live := filepath.Join(provider.dir, "api.git")
+	runGit("clone", "-q", "--bare", src, live)
+	runGit("-C", live, "branch", "feature", "main")
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	return &Restorer{cfg: cfg, provider: provider, git: git.NewShellGitClient()}, provider, workspaceDir
+}
+
+func actionFor(t *testing.T, plan *RestorePlan, slug string) *RestoreAction {
+	t.Helper()
+	for _, a := range plan.Repositories {
+		if a.Slug == slug {
+			return a
+		}
+	}
+	t.Fatalf("no action for %s", slug)
+	return nil
+}
+
+func TestRestorerPlan_Policies(t *testing.T) {
+	r, _, workspaceDir := newRestoreTest(t)
+	ctx := context.Background()
+
+	plan, err := r.Plan(ctx, workspaceDir, RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Collisions() != 1 {
+		t.Errorf("Collisions() = %d, want 1", plan.Collisions())
+	}
+	if a := actionFor(t, plan, "site"); a.Action != RestoreCreate || a.Target != "site" || !a.Create || a.Project != "CORE" {
+		t.Errorf("site = %+v, want it created at its slug", a)
+	}
+	if a := actionFor(t, plan, "api"); a.Action != RestoreSkip || a.Target != "" || !a.Exists {
+		t.Errorf("api = %+v, want it skipped", a)
+	}
+
+	// An overwrite counts the branch it would delete
+	plan, err = r.Plan(ctx, workspaceDir, RestoreOptions{Policies: map[string]string{"api": RestoreOverwriteRefs}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := actionFor(t, plan, "api"); a.Target != "api" || a.RefsCreated != 0 || a.RefsUpdated != 0 || a.RefsDeleted != 1 {
+		t.Errorf("api = %+v, want one ref deleted", a)
+	}
+
+	plan, err = r.Plan(ctx, workspaceDir, RestoreOptions{OnConflict: RestorePrefix, Prefix: "old-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := actionFor(t, plan, "api"); a.Target != "old-api" || !a.Create {
+		t.Errorf("api = %+v, want it created at old-api", a)
+	}
+
+	// A new slug that already exists cannot be restored to
+	plan, err = r.Plan(ctx, workspaceDir, RestoreOptions{NewSlugs: map[string]string{"api": "api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := actionFor(t, plan, "api"); a.Action != RestoreNewSlug || a.Error == "" || plan.Failed() != 1 {
+		t.Errorf("api = %+v, want an error", a)
+	}
+
+	if _, err := r.Plan(ctx, workspaceDir, RestoreOptions{OnConflict: "merge"}); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestRestorerRestore(t *testing.T) {
+	r, provider, workspaceDir := newRestoreTest(t)
+	ctx := context.Background()
+
+	plan, err := r.Plan(ctx, workspaceDir, RestoreOptions{OnConflict: RestoreAsk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Restore(ctx, plan); err == nil {
+		t.Fatal("expected an error for an undecided plan")
+	}
+	if err := r.Decide(ctx, actionFor(t, plan, "api"), RestoreNewSlug, "api-2024"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Restore(ctx, plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.Failed() != 0 {
+		t.Fatalf("plan failed: %+v", plan.Repositories)
+	}
+
+	if got, want := provider.created, []string{"api-2024", "site"}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("created %v, want %v", got, want)
+	}
+	for _, a := range plan.Repositories {
+		want, err := git.ReadRefs(a.mirror)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := git.ReadRefs(filepath.Join(provider.dir, a.Target+".git"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(git.DiffRefs(got, want)) != 0 {
+			t.Errorf("%s refs = %v, want %v", a.Target, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+// CurrentLinkName is the symlink in each workspace directory that points at
+// the most recently started run directory.
+const CurrentLinkName = "current"
+
+// runTimeFormat is the default timestamp prefix of a run ID.
+const runTimeFormat = config.DefaultRunTimestampFormat
+
+// NewRunID returns a unique, sortable run identifier: the UTC start
+// timestamp followed by a short random suffix, so runs started within the
+// same second get distinct directories.
+func NewRunID(start time.Time) string {
+	return newRunID(start, runTimeFormat)
+}
+
+// newRunID is NewRunID with the timestamp in the given layout.
+func newRunID(start time.Time, layout string) string {
+	if layout == "" {
+		layout = runTimeFormat
+	}
+	return start.UTC().Format(layout) + "-" + generateJobID()
+}
+
+// ValidateRunID checks that id can safely name a run directory.
+func ValidateRunID(id string) error {
+	switch {
+	case id == "":
+		return fmt.Errorf("run ID is empty")
+	case id == "latest" || id == CurrentLinkName:
+		return fmt.Errorf("run ID %q is reserved", id)
+	case strings.HasPrefix(id, "."):
+		return fmt.Errorf("run ID %q must not start with a dot", id)
+	case strings.ContainsAny(id, `/\`):
+		return fmt.Errorf("run ID %q must not contain path separators", id)
+	}
+	return nil
+}
+
+// resolveRunID picks the run ID for this invocation. A rerun must name an
+// existing run directory; otherwise a fresh ID is generated.
+func (b *Backup) resolveRunID(start time.Time) (string, error) {
+	if b.opts.RerunID == "" {
+		return newRunID(start, b.cfg.Backup.RunTimestampFormat), nil
+	}
+
+	if err := ValidateRunID(b.opts.RerunID); err != nil {
+		return "", err
+	}
+	runPath := filepath.Join(b.storage.BasePath(), b.cfg.Workspace, b.opts.RerunID)
+	info, err := os.Stat(runPath)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("run %s not found in %s", b.opts.RerunID, filepath.Dir(runPath))
+	}
+	return b.opts.RerunID, nil
+}
+
+// updateCurrentLink points the workspace's current symlink at runID. The
+// link is replaced atomically via a temporary link and rename. Platforms
+// without symlink support simply have no current link.
+func (b *Backup) updateCurrentLink(runID string) {
+	workspaceDir := filepath.Join(b.storage.BasePath(), b.cfg.Workspace)
+	link := filepath.Join(workspaceDir, CurrentLinkName)
+	tmp := link + ".tmp"
+
+	_ = os.Remove(tmp)
+	if err := os.Symlink(runID, tmp); err != nil {
+		b.log.Debug("Could not create %s link: %v", CurrentLinkName, err)
+		return
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		_ = os.Remove(tmp)
+		b.log.Debug("Could not update %s link: %v", CurrentLinkName, err)
+	}
+}
+
+// skipCompletedInRun drops repositories that already succeeded in the run
+// being resumed and carries their report entries forward, so a rerun only
+// redoes what failed or never ran. It returns the remaining repositories and
+// how many were skipped.
+func (b *Backup) skipCompletedInRun(runDir string, repos []api.Repository) ([]api.Repository, int) {
+	data, err := b.storage.Read(filepath.Join(runDir, ReportFileName))
+	if err != nil {
+		// No report means the earlier attempt died before writing one;
+		// everything is redone.
+		return repos, 0
+	}
+
+	var previous Report
+	if err := json.Unmarshal(data, &previous); err != nil {
+		b.log.Debug("Ignoring unreadable report in %s: %v", runDir, err)
+		return repos, 0
+	}
+
+	done := make(map[string]RepoReport)
+	for _, entry := range previous.Repositories {
+		if entry.Status == RepoStatusOK {
+			done[entry.Slug] = entry
+		}
+	}
+
+	remaining := repos[:0:0]
+	for _, repo := range repos {
+		if entry, ok := done[repo.Slug]; ok {
+			b.report.Add(entry)
+			continue
+		}
+		remaining = append(remaining, repo)
+	}
+	return remaining, len(repos) - len(remaining)
+}
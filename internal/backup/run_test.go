@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+func TestNewRunID(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	a, b := NewRunID(start), NewRunID(start)
+
+	if !strings.HasPrefix(a, "2024-01-15T10-30-00Z-") {
+		t.Errorf("NewRunID() = %q, want timestamp prefix", a)
+	}
+	if a == b {
+		t.Errorf("runs in the same second got the same ID %q", a)
+	}
+	if err := ValidateRunID(a); err != nil {
+		t.Errorf("generated ID failed validation: %v", err)
+	}
+}
+
+func TestNewRunID_UTC(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	if id := NewRunID(start); !strings.HasPrefix(id, "2024-01-15T15-30-00Z-") {
+		t.Errorf("NewRunID() = %q, want the UTC start time", id)
+	}
+	if id := newRunID(start, "20060102-150405Z"); !strings.HasPrefix(id, "20240115-153000Z-") {
+		t.Errorf("newRunID() = %q, want the custom layout", id)
+	}
+}
+
+func TestValidateRunID(t *testing.T) {
+	for _, id := range []string{"", "latest", "current", ".hidden", "../escape", `a\b`} {
+		if err := ValidateRunID(id); err == nil {
+			t.Errorf("ValidateRunID(%q) expected error", id)
+		}
+	}
+}
+
+func newRunTestBackup(t *testing.T, rerunID string) *Backup {
+	t.Helper()
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Backup{
+		cfg:     &config.Config{Workspace: "ws"},
+		opts:    Options{RerunID: rerunID},
+		storage: store,
+		log:     &defaultLogger{quiet: true},
+		report:  NewReport("ws"),
+	}
+}
+
+// setClient points a test backup's API requests at client.
+func setClient(b *Backup, client *api.Client) {
+	b.client = client
+	b.provider = client.Provider
+}
+
+func TestResolveRunID_Rerun(t *testing.T) {
+	b := newRunTestBackup(t, "2024-01-15T10-30-00Z-abcd1234")
+
+	if _, err := b.resolveRunID(time.Now()); err == nil {
+		t.Fatal("expected error for missing run directory")
+	}
+
+	runDir := filepath.Join(b.storage.BasePath(), "ws", "2024-01-15T10-30-00Z-abcd1234")
+	if err := os.MkdirAll(runDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	id, err := b.resolveRunID(time.Now())
+	if err != nil {
+		t.Fatalf("resolveRunID() error = %v", err)
+	}
+	if id != "2024-01-15T10-30-00Z-abcd1234" {
+		t.Errorf("resolveRunID() = %q, want rerun ID", id)
+	}
+}
+
+func TestUpdateCurrentLink(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	if err := os.MkdirAll(filepath.Join(b.storage.BasePath(), "ws", "run-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b.updateCurrentLink("run-1")
+	b.updateCurrentLink("run-2")
+
+	target, err := os.Readlink(filepath.Join(b.storage.BasePath(), "ws", CurrentLinkName))
+	if err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	if target != "run-2" {
+		t.Errorf("current -> %q, want run-2", target)
+	}
+}
+
+func TestSkipCompletedInRun(t *testing.T) {
+	b := newRunTestBackup(t, "run-1")
+	previous := NewReport("ws")
+	previous.Add(RepoReport{Slug: "done", Status: RepoStatusOK})
+	previous.Add(RepoReport{Slug: "broken", Status: RepoStatusFailed})
+	if err := b.saveJSON(filepath.Join("ws", "run-1"), ReportFileName, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	repos := []api.Repository{{Slug: "done"}, {Slug: "broken"}, {Slug: "new"}}
+	remaining, skipped := b.skipCompletedInRun(filepath.Join("ws", "run-1"), repos)
+
+	if skipped != 1 {
+		t.Errorf("skipped = %d, want 1", skipped)
+	}
+	if len(remaining) != 2 || remaining[0].Slug != "broken" || remaining[1].Slug != "new" {
+		t.Errorf("remaining = %v, want broken and new", remaining)
+	}
+	if len(b.report.Repositories) != 1 || b.report.Repositories[0].Slug != "done" {
+		t.Errorf("expected completed repo carried into report, got %v", b.report.Repositories)
+	}
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// Files written to the run directory with logging.bundle.
+const (
+	RunLogFileName = "bb-backup.log"
+	ErrorsFileName = "errors.json"
+)
+
+// LoggedError is an error logged during a run, as saved in errors.json.
+type LoggedError struct {
+	Time    string `json:"time"`
+	Message string `json:"message"`
+}
+
+// runLog passes log lines through to another Logger and keeps a copy for
+// the run directory. Lines are buffered until the directory exists, then
+// appended to its log file in the working copy as they are logged, so a
+// crashed run still has its log. Errors are also collected for errors.json.
+// Both files are written to storage when the log is closed.
+type runLog struct {
+	next  Logger
+	debug bool
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	file   *os.File
+	store  storage.Storage
+	dir    string // Relative to store
+	closed bool
+	errors []LoggedError
+}
+
+// newRunLog wraps next. Debug lines are kept only if debug is set.
+func newRunLog(next Logger, debug bool) *runLog {
+	return &runLog{next: next, debug: debug, errors: []LoggedError{}}
+}
+
+func (l *runLog) Info(msg string, args ...interface{}) {
+	l.next.Info(msg, args...)
+	l.write("INFO", msg, args)
+}
+
+func (l *runLog) Debug(msg string, args ...interface{}) {
+	l.next.Debug(msg, args...)
+	if l.debug {
+		l.write("DEBUG", msg, args)
+	}
+}
+
+func (l *runLog) Error(msg string, args ...interface{}) {
+	l.next.Error(msg, args...)
+	l.write("ERROR", msg, args)
+}
+
+func (l *runLog) write(level, msg string, args []interface{}) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	formatted := fmt.Sprintf(msg, args...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if level == "ERROR" {
+		l.errors = append(l.errors, LoggedError{Time: now, Message: formatted})
+	}
+	line := fmt.Sprintf("%s [%s] %s\n", now, level, formatted)
+	if l.file != nil {
+		_, _ = l.file.WriteString(line)
+		return
+	}
+	l.buf.WriteString(line)
+}
+
+// open starts writing to the log file in dir, relative to store, flushing
+// what was logged so far. A continued run appends to the existing file.
+func (l *runLog) open(store storage.Storage, dir string) error {
+	local := filepath.Join(store.BasePath(), dir)
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", RunLogFileName, err)
+	}
+	f, err := os.OpenFile(filepath.Join(local, RunLogFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", RunLogFileName, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := f.Write(l.buf.Bytes()); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("writing %s: %w", RunLogFileName, err)
+	}
+	l.buf.Reset()
+	l.file, l.store, l.dir = f, store, dir
+	return nil
+}
+
+// close writes errors.json next to the log, closes the log file, and
+// writes it to storage. Later lines still reach the wrapped logger.
+func (l *runLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil || l.closed {
+		return nil
+	}
+	l.closed = true
+
+	data, err := json.MarshalIndent(l.errors, "", "  ")
+	if err == nil {
+		err = l.store.Write(filepath.Join(l.dir, ErrorsFileName), data)
+	}
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = storage.WriteFile(l.store, filepath.Join(l.dir, RunLogFileName), l.file.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("bundling run log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+func TestRunLog(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(store.BasePath(), "ws", "run-1")
+	l := newRunLog(&defaultLogger{quiet: true}, false)
+
+	l.Info("Starting backup for workspace: %s", "ws")
+	l.Debug("not kept without debug")
+	if err := l.open(store, filepath.Join("ws", "run-1")); err != nil {
+		t.Fatal(err)
+	}
+	l.Error("Failed to backup repo %s: %v", "api", "boom")
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("after close")
+
+	data, err := os.ReadFile(filepath.Join(dir, RunLogFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 ||
+		!strings.HasSuffix(lines[0], "[INFO] Starting backup for workspace: ws") ||
+		!strings.HasSuffix(lines[1], "[ERROR] Failed to backup repo api: boom") {
+		t.Errorf("log = %q", data)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, ErrorsFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []LoggedError
+	if err := json.Unmarshal(data, &errs); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || errs[0].Message != "Failed to backup repo api: boom" || errs[0].Time == "" {
+		t.Errorf("errors.json = %s", data)
+	}
+}
+
+func TestRunLog_AppendsOnRerun(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := store.BasePath()
+	for _, msg := range []string{"first run", "rerun"} {
+		l := newRunLog(&defaultLogger{quiet: true}, true)
+		if err := l.open(store, "."); err != nil {
+			t.Fatal(err)
+		}
+		l.Debug(msg)
+		if err := l.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, RunLogFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "[DEBUG] first run") || !strings.Contains(string(data), "[DEBUG] rerun") {
+		t.Errorf("log = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, ErrorsFileName)); strings.TrimSpace(string(data)) != "[]" {
+		t.Errorf("errors.json = %q, want []", data)
+	}
+}
+
+func TestRunLog_WrittenToReplica(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newRunLog(&defaultLogger{quiet: true}, false)
+	if err := l.open(storage.NewReplicated(local, replica), "ws/run-1"); err != nil {
+		t.Fatal(err)
+	}
+	l.Error("Failed to backup repo %s", "api")
+	if err := l.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{RunLogFileName, ErrorsFileName} {
+		data, err := replica.Read(filepath.Join("ws", "run-1", name))
+		if err != nil || !strings.Contains(string(data), "Failed to backup repo api") {
+			t.Errorf("replica %s = %q, %v", name, data, err)
+		}
+	}
+}
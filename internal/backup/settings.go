@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// SettingsDirName is the per-repository directory of server-side settings
+// the git mirror does not carry, saved as the API returns them.
+const SettingsDirName = "settings"
+
+// Files in SettingsDirName.
+const (
+	BranchRestrictionsFileName = "branch-restrictions.json"
+	BranchingModelFileName     = "branching-model.json"
+	DefaultReviewersFileName   = "default-reviewers.json"
+)
+
+// saveSettings fetches a repository's branch restrictions, branching model,
+// and default reviewers and writes them under settings/ in the run's and
+// latest/ directories. A setting the credentials may not read (403; branch
+// restrictions need repository admin) is skipped with a note and the others
+// are still saved; other failures are returned.
+func (b *Backup) saveSettings(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) error {
+	prefix := api.LogPrefix(ctx)
+
+	settings := []struct {
+		file  string
+		what  string
+		fetch func() (interface{}, error)
+	}{
+		{BranchRestrictionsFileName, "branch restrictions", func() (interface{}, error) {
+			return b.client.GetBranchRestrictions(ctx, b.cfg.Workspace, repo.Slug)
+		}},
+		{BranchingModelFileName, "branching model", func() (interface{}, error) {
+			return b.client.GetBranchingModel(ctx, b.cfg.Workspace, repo.Slug)
+		}},
+		{DefaultReviewersFileName, "default reviewers", func() (interface{}, error) {
+			return b.client.GetEffectiveDefaultReviewers(ctx, b.cfg.Workspace, repo.Slug)
+		}},
+	}
+
+	for _, s := range settings {
+		data, err := s.fetch()
+		if errors.Is(err, api.ErrPoliciesRestricted) {
+			b.log.Info("%sSkipping %s for %s: the credentials may not read them (403)", prefix, s.what, repo.Slug)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, dir := range []string{repoDir, latestRepoDir} {
+			if err := b.saveEntity(filepath.Join(dir, SettingsDirName), s.file, data); err != nil {
+				return fmt.Errorf("saving %s: %w", s.file, err)
+			}
+		}
+	}
+	return nil
+}
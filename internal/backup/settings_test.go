@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestSaveSettings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repositories/ws/core-api/branch-restrictions":
+			_, _ = w.Write([]byte(`{"values":[{"kind":"push","branch_match_kind":"glob","pattern":"main","users":[{"display_name":"Ada Lovelace"}]}]}`))
+		case "/repositories/ws/core-api/branching-model", "/repositories/ws/locked/branching-model":
+			_, _ = w.Write([]byte(`{"type":"branching_model","production":{"name":"","use_mainbranch":true},"branch_types":[{"kind":"feature","prefix":"feature/"}]}`))
+		case "/repositories/ws/core-api/effective-default-reviewers", "/repositories/ws/locked/effective-default-reviewers":
+			_, _ = w.Write([]byte(`{"values":[{"reviewer_type":"project","user":{"display_name":"Grace Hopper"}}]}`))
+		case "/repositories/ws/locked/branch-restrictions":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error":{"message":"Access denied"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	b := newRunTestBackup(t, "")
+	b.cfg = config.Default()
+	b.cfg.Workspace = "ws"
+	b.cfg.RateLimit.RequestsPerHour = 36000
+	b.cfg.Privacy = config.PrivacyConfig{HashFields: []string{"display_name"}, HashSalt: "salt"}
+	b.privacy = newPrivacyFilter(b.cfg.Privacy)
+	setClient(b, api.NewClient(b.cfg, api.WithBaseURL(server.URL)))
+
+	runDir, latestDir := "run/core-api", "latest/core-api"
+	if err := b.saveSettings(context.Background(), runDir, latestDir, &api.Repository{Slug: "core-api"}); err != nil {
+		t.Fatal(err)
+	}
+	read := func(dir, file string) []byte {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(b.storage.BasePath(), dir, SettingsDirName, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	for _, dir := range []string{runDir, latestDir} {
+		var restrictions []api.BranchRestriction
+		if err := json.Unmarshal(read(dir, BranchRestrictionsFileName), &restrictions); err != nil || len(restrictions) != 1 || restrictions[0].Kind != "push" {
+			t.Errorf("%s: branch restrictions = %+v (%v)", dir, restrictions, err)
+		}
+		var model api.BranchingModel
+		if err := json.Unmarshal(read(dir, BranchingModelFileName), &model); err != nil || len(model.BranchTypes) != 1 || model.Production == nil || !model.Production.UseMainbranch {
+			t.Errorf("%s: branching model = %+v (%v)", dir, model, err)
+		}
+		reviewers := string(read(dir, DefaultReviewersFileName))
+		if strings.Contains(reviewers, "Grace Hopper") || !strings.Contains(reviewers, hashedValuePrefix) {
+			t.Errorf("%s: privacy.hash_fields not applied to default reviewers:\n%s", dir, reviewers)
+		}
+	}
+
+	// Without repository admin, branch restrictions are skipped and the
+	// rest is still saved
+	if err := b.saveSettings(context.Background(), "run/locked", "latest/locked", &api.Repository{Slug: "locked"}); err != nil {
+		t.Fatalf("restricted settings should be skipped, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(b.storage.BasePath(), "run/locked", SettingsDirName, BranchRestrictionsFileName)); !os.IsNotExist(err) {
+		t.Errorf("branch restrictions written for a restricted repository: %v", err)
+	}
+	read("run/locked", BranchingModelFileName)
+	read("run/locked", DefaultReviewersFileName)
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/format"
+)
+
+// SLOFileName is the SLO evaluation written to the run directory.
+const SLOFileName = "slo.json"
+
+// SLO check names, matching their config keys.
+const (
+	SLOMaxDuration    = "max_duration"
+	SLOMaxFailedRepos = "max_failed_repos"
+	SLOMaxStaleness   = "max_staleness"
+)
+
+// SLOResult is the outcome of evaluating a run against the configured
+// service level objectives.
+type SLOResult struct {
+	Workspace   string     `json:"workspace"`
+	RunID       string     `json:"run_id"`
+	EvaluatedAt string     `json:"evaluated_at"`
+	Pass        bool       `json:"pass"`
+	Checks      []SLOCheck `json:"checks"`
+}
+
+// SLOCheck is the outcome of a single objective. Target and Actual are in
+// Unit ("seconds" or "repositories") so dashboards can plot them; Detail
+// is the human-readable form.
+type SLOCheck struct {
+	Name   string  `json:"name"`
+	Unit   string  `json:"unit"`
+	Target float64 `json:"target"`
+	Actual float64 `json:"actual"`
+	Pass   bool    `json:"pass"`
+	Detail string  `json:"detail"`
+}
+
+// Failed returns the names of the checks that did not pass.
+func (r *SLOResult) Failed() []string {
+	var names []string
+	for _, c := range r.Checks {
+		if !c.Pass {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// EvaluateSLO checks a completed run's manifest against the configured
+// objectives. Staleness is measured from the run's completion to now.
+func EvaluateSLO(slo config.SLOConfig, m *Manifest, now time.Time) (*SLOResult, error) {
+	started, err := time.Parse(time.RFC3339, m.StartedAt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing started_at: %w", err)
+	}
+	completed, err := time.Parse(time.RFC3339, m.CompletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing completed_at: %w", err)
+	}
+
+	result := &SLOResult{
+		Workspace:   m.Workspace,
+		RunID:       m.RunID,
+		EvaluatedAt: now.UTC().Format(time.RFC3339),
+		Pass:        true,
+	}
+	add := func(c SLOCheck) {
+		result.Checks = append(result.Checks, c)
+		result.Pass = result.Pass && c.Pass
+	}
+
+	if slo.MaxDuration != "" {
+		target, err := time.ParseDuration(slo.MaxDuration)
+		if err != nil {
+			return nil, fmt.Errorf("parsing slo.max_duration: %w", err)
+		}
+		took := completed.Sub(started)
+		add(SLOCheck{
+			Name:   SLOMaxDuration,
+			Unit:   "seconds",
+			Target: target.Seconds(),
+			Actual: took.Seconds(),
+			Pass:   took <= target,
+			Detail: fmt.Sprintf("run took %s (target %s)", format.Duration(took), format.Duration(target)),
+		})
+	}
+
+	if slo.MaxFailedRepos >= 0 {
+		add(SLOCheck{
+			Name:   SLOMaxFailedRepos,
+			Unit:   "repositories",
+			Target: float64(slo.MaxFailedRepos),
+			Actual: float64(m.Stats.Failed),
+			Pass:   m.Stats.Failed <= slo.MaxFailedRepos,
+			Detail: fmt.Sprintf("%d of %d repositories failed (target at most %d)", m.Stats.Failed, m.Stats.Repositories, slo.MaxFailedRepos),
+		})
+	}
+
+	if slo.MaxStaleness != "" {
+		target, err := time.ParseDuration(slo.MaxStaleness)
+		if err != nil {
+			return nil, fmt.Errorf("parsing slo.max_staleness: %w", err)
+		}
+		age := now.Sub(completed)
+		if age < 0 {
+			age = 0
+		}
+		add(SLOCheck{
+			Name:   SLOMaxStaleness,
+			Unit:   "seconds",
+			Target: target.Seconds(),
+			Actual: age.Seconds(),
+			Pass:   age <= target,
+			Detail: fmt.Sprintf("last run completed %s ago at %s (target %s)", format.Duration(age), m.CompletedAt, format.Duration(target)),
+		})
+	}
+
+	return result, nil
+}
+
+// FindLatestRun returns the manifest of the most recent completed run in a
+// workspace backup directory, along with its run directory. Runs without a
+// manifest (in progress, interrupted, or dry runs) are skipped.
+func FindLatestRun(workspaceDir string) (*Manifest, string, error) {
+	entries, err := os.ReadDir(workspaceDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading backup directory: %w", err)
+	}
+
+	// Run IDs start with a sortable timestamp
+	var runs []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || ValidateRunID(name) != nil || strings.HasPrefix(name, LatestDirName) {
+			continue
+		}
+		runs = append(runs, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(runs)))
+
+	for _, run := range runs {
+		runDir := filepath.Join(workspaceDir, run)
+		data, err := os.ReadFile(filepath.Join(runDir, "manifest.json"))
+		if err != nil {
+			continue
+		}
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			continue
+		}
+		return &m, runDir, nil
+	}
+	return nil, "", fmt.Errorf("no completed runs found in %s", workspaceDir)
+}
+
+// evaluateSLO evaluates this run against the configured objectives, logs
+// the outcome, and saves it to the run directory. A missed objective does
+// not fail the backup; `bb-backup slo` is the gate for that.
+func (b *Backup) evaluateSLO(backupDir string, manifest *Manifest) {
+	result, err := EvaluateSLO(b.cfg.SLO, manifest, time.Now())
+	if err != nil {
+		b.log.Error("Failed to evaluate SLOs: %v", err)
+		return
+	}
+	if err := b.saveJSON(backupDir, SLOFileName, result); err != nil {
+		b.log.Error("Failed to save %s: %v", SLOFileName, err)
+	}
+
+	if result.Pass {
+		b.log.Info("SLO: all %d objectives met", len(result.Checks))
+		return
+	}
+	for _, c := range result.Checks {
+		if !c.Pass {
+			b.log.Error("SLO missed: %s: %s", c.Name, c.Detail)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestEvaluateSLO(t *testing.T) {
+	m := &Manifest{
+		RunID:       "2024-01-01T00-00-00Z-abc",
+		Workspace:   "ws",
+		StartedAt:   "2024-01-01T00:00:00Z",
+		CompletedAt: "2024-01-01T03:00:00Z",
+		Stats:       ManifestStats{Repositories: 10, Failed: 2},
+	}
+	now := time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		slo    config.SLOConfig
+		pass   bool
+		failed []string
+	}{
+		{"all met", config.SLOConfig{MaxDuration: "4h", MaxFailedRepos: 2, MaxStaleness: "26h"}, true, nil},
+		{"too slow", config.SLOConfig{MaxDuration: "2h", MaxFailedRepos: -1}, false, []string{SLOMaxDuration}},
+		{"too many failures", config.SLOConfig{MaxFailedRepos: 0}, false, []string{SLOMaxFailedRepos}},
+		{"stale", config.SLOConfig{MaxFailedRepos: -1, MaxStaleness: "12h"}, false, []string{SLOMaxStaleness}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := EvaluateSLO(tt.slo, m, now)
+			if err != nil {
+				t.Fatalf("EvaluateSLO() error = %v", err)
+			}
+			if result.Pass != tt.pass {
+				t.Errorf("Pass = %v, want %v (%+v)", result.Pass, tt.pass, result.Checks)
+			}
+			if got := result.Failed(); !reflect.DeepEqual(got, tt.failed) {
+				t.Errorf("Failed() = %v, want %v", got, tt.failed)
+			}
+		})
+	}
+
+	result, err := EvaluateSLO(config.SLOConfig{MaxDuration: "4h", MaxFailedRepos: -1}, m, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := result.Checks[0]; c.Unit != "seconds" || c.Target != 14400 || c.Actual != 10800 {
+		t.Errorf("unexpected duration check %+v", c)
+	}
+}
+
+func TestFindLatestRun(t *testing.T) {
+	ws := t.TempDir()
+	write := func(run string, m *Manifest) {
+		dir := filepath.Join(ws, run)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if m == nil {
+			return
+		}
+		data, _ := json.Marshal(m)
+		if err := os.WriteFile(filepath.Join(dir, "manifest.json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("2024-01-01T00-00-00Z-aaa", &Manifest{RunID: "2024-01-01T00-00-00Z-aaa"})
+	write("2024-01-02T00-00-00Z-bbb", &Manifest{RunID: "2024-01-02T00-00-00Z-bbb"})
+	write("2024-01-03T00-00-00Z-ccc", nil) // interrupted, no manifest
+	write(LatestDirName, &Manifest{RunID: "latest"})
+
+	m, dir, err := FindLatestRun(ws)
+	if err != nil {
+		t.Fatalf("FindLatestRun() error = %v", err)
+	}
+	if m.RunID != "2024-01-02T00-00-00Z-bbb" || filepath.Base(dir) != m.RunID {
+		t.Errorf("got run %s in %s, want 2024-01-02T00-00-00Z-bbb", m.RunID, dir)
+	}
+
+	if _, _, err := FindLatestRun(t.TempDir()); err == nil {
+		t.Error("expected error when there are no runs")
+	}
+}
@@ -7,24 +7,31 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
 )
 
 // StateFileName is the default state file name.
 const StateFileName = ".bb-backup-state.json"
 
-// CheckpointInterval is the number of repos between state checkpoints.
-const CheckpointInterval = 50
-
 // State tracks the state of previous backups for incremental support.
 type State struct {
-	mu              sync.RWMutex            `json:"-"` // Protects concurrent access
-	Version         string                  `json:"version"`
-	Workspace       string                  `json:"workspace"`
-	LastFullBackup  string                  `json:"last_full_backup,omitempty"`
-	LastIncremental string                  `json:"last_incremental,omitempty"`
-	Projects        map[string]ProjectState `json:"projects"`
-	Repositories    map[string]RepoState    `json:"repositories"`
-	FailedRepos     map[string]FailedRepo   `json:"failed_repos,omitempty"`
+	mu              sync.RWMutex               `json:"-"` // Protects concurrent access
+	Version         string                     `json:"version"`
+	Workspace       string                     `json:"workspace"`
+	LastFullBackup  string                     `json:"last_full_backup,omitempty"`
+	LastIncremental string                     `json:"last_incremental,omitempty"`
+	Projects        map[string]ProjectState    `json:"projects"`
+	Repositories    map[string]RepoState       `json:"repositories"`
+	FailedRepos     map[string]FailedRepo      `json:"failed_repos,omitempty"`
+	Quarantine      map[string]QuarantinedRepo `json:"quarantine,omitempty"`
+	Deferred        map[string]string          `json:"deferred,omitempty"` // Budget that deferred each repository, by slug
+	Usage           *WorkspaceUsage            `json:"usage,omitempty"`
+	Runs            []RunStats                 `json:"runs,omitempty"` // Last RunHistoryLength runs, oldest first
+
+	// pending holds PR and issue timestamps for repositories backed up for
+	// the first time, until UpdateRepository records the repository.
+	pending map[string]RepoState
 }
 
 // FailedRepo tracks a repository that failed to backup.
@@ -34,6 +41,10 @@ type FailedRepo struct {
 	Error      string `json:"error"`
 	FailedAt   string `json:"failed_at"`
 	Attempts   int    `json:"attempts"`
+	// Code classifies the error
+	Code errcode.Code `json:"code,omitempty"`
+	// Consecutive counts the runs in a row the repository has failed
+	Consecutive int `json:"consecutive,omitempty"`
 }
 
 // ProjectState tracks the state of a project.
@@ -46,10 +57,17 @@ type ProjectState struct {
 type RepoState struct {
 	UUID             string `json:"uuid"`
 	ProjectKey       string `json:"project_key,omitempty"`
+	Owner            string `json:"owner,omitempty"` // Owning account of a personal repository
 	LastCommit       string `json:"last_commit,omitempty"`
 	LastPRUpdated    string `json:"last_pr_updated,omitempty"`
 	LastIssueUpdated string `json:"last_issue_updated,omitempty"`
 	LastBackedUp     string `json:"last_backed_up"`
+	Archived         bool   `json:"archived,omitempty"`
+	Dir              string `json:"dir,omitempty"` // Directory name when not the slug, after a case collision
+
+	// History holds the most recent successful runs, oldest first, for
+	// scheduling, ETAs, and spotting repositories that suddenly slow down
+	History []RepoRun `json:"history,omitempty"`
 }
 
 // NewState creates a new empty state.
@@ -83,18 +101,15 @@ func LoadState(path string) (*State, error) {
 
 // Save writes the state to the given path.
 func (s *State) Save(path string) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	// Ensure directory exists
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("creating state directory: %w", err)
 	}
 
-	data, err := json.MarshalIndent(s, "", "  ")
+	data, err := s.Encode()
 	if err != nil {
-		return fmt.Errorf("marshaling state: %w", err)
+		return err
 	}
 
 	if err := os.WriteFile(path, data, 0644); err != nil {
@@ -104,6 +119,18 @@ func (s *State) Save(path string) error {
 	return nil
 }
 
+// Encode returns the state as it is written to the state file.
+func (s *State) Encode() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling state: %w", err)
+	}
+	return data, nil
+}
+
 // MarkFullBackup marks a full backup as completed.
 func (s *State) MarkFullBackup() {
 	s.mu.Lock()
@@ -130,18 +157,60 @@ func (s *State) UpdateProject(key, uuid string) {
 	}
 }
 
-// UpdateRepository updates the state for a repository.
-func (s *State) UpdateRepository(slug, uuid, projectKey string) {
+// UpdateRepository updates the state for a repository. Personal
+// repositories also record their owner.
+func (s *State) UpdateRepository(slug, uuid, projectKey, owner string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	existing := s.Repositories[slug]
+	existing, ok := s.Repositories[slug]
+	if !ok {
+		existing = s.pending[slug]
+		delete(s.pending, slug)
+	}
 	s.Repositories[slug] = RepoState{
 		UUID:             uuid,
 		ProjectKey:       projectKey,
+		Owner:            owner,
 		LastCommit:       existing.LastCommit,
 		LastPRUpdated:    existing.LastPRUpdated,
 		LastIssueUpdated: existing.LastIssueUpdated,
 		LastBackedUp:     time.Now().UTC().Format(time.RFC3339),
+		Archived:         existing.Archived,
+		Dir:              existing.Dir,
+		History:          existing.History,
+	}
+}
+
+// RenameRepository moves a repository's state to a new key, such as when a
+// personal repository's key gains its owner.
+func (s *State) RenameRepository(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.Repositories[from]; ok {
+		s.Repositories[to] = existing
+		delete(s.Repositories, from)
+	}
+}
+
+// SetRepoArchived records whether a repository was archived when last
+// backed up.
+func (s *State) SetRepoArchived(slug string, archived bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if repo, ok := s.Repositories[slug]; ok {
+		repo.Archived = archived
+		s.Repositories[slug] = repo
+	}
+}
+
+// SetRepoDir records the directory name a repository is stored under,
+// empty for its slug.
+func (s *State) SetRepoDir(slug, dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if repo, ok := s.Repositories[slug]; ok {
+		repo.Dir = dir
+		s.Repositories[slug] = repo
 	}
 }
 
@@ -152,7 +221,20 @@ func (s *State) SetRepoLastPRUpdated(slug, timestamp string) {
 	if repo, ok := s.Repositories[slug]; ok {
 		repo.LastPRUpdated = timestamp
 		s.Repositories[slug] = repo
+		return
+	}
+	s.setPending(slug, func(repo *RepoState) { repo.LastPRUpdated = timestamp })
+}
+
+// setPending updates the timestamps held for a repository that is not in
+// the state yet. The caller holds s.mu.
+func (s *State) setPending(slug string, set func(*RepoState)) {
+	if s.pending == nil {
+		s.pending = make(map[string]RepoState)
 	}
+	repo := s.pending[slug]
+	set(&repo)
+	s.pending[slug] = repo
 }
 
 // SetRepoLastIssueUpdated sets the last issue updated timestamp for a repo.
@@ -162,7 +244,9 @@ func (s *State) SetRepoLastIssueUpdated(slug, timestamp string) {
 	if repo, ok := s.Repositories[slug]; ok {
 		repo.LastIssueUpdated = timestamp
 		s.Repositories[slug] = repo
+		return
 	}
+	s.setPending(slug, func(repo *RepoState) { repo.LastIssueUpdated = timestamp })
 }
 
 // GetRepoState returns the state for a repository.
@@ -213,19 +297,21 @@ func GetStatePath(storagePath, workspace string) string {
 	return filepath.Join(storagePath, workspace, StateFileName)
 }
 
-// AddFailedRepo records a repository that failed to backup.
-func (s *State) AddFailedRepo(slug, projectKey, errMsg string, attempts int) {
+// AddFailedRepo records a repository that failed to backup with err.
+func (s *State) AddFailedRepo(slug, projectKey string, err error, attempts int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.FailedRepos == nil {
 		s.FailedRepos = make(map[string]FailedRepo)
 	}
 	s.FailedRepos[slug] = FailedRepo{
-		Slug:       slug,
-		ProjectKey: projectKey,
-		Error:      errMsg,
-		FailedAt:   time.Now().UTC().Format(time.RFC3339),
-		Attempts:   attempts,
+		Slug:        slug,
+		ProjectKey:  projectKey,
+		Error:       err.Error(),
+		Code:        errcode.Of(err),
+		FailedAt:    time.Now().UTC().Format(time.RFC3339),
+		Attempts:    attempts,
+		Consecutive: s.FailedRepos[slug].Consecutive + 1,
 	}
 }
 
@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/storage"
 )
 
 func TestNewState(t *testing.T) {
@@ -30,7 +32,7 @@ func TestState_SaveAndLoad(t *testing.T) {
 	// Create and save state
 	state := NewState("my-workspace")
 	state.UpdateProject("PROJ1", "uuid-1")
-	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1")
+	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1", "")
 	state.MarkFullBackup()
 
 	if err := state.Save(statePath); err != nil {
@@ -128,7 +130,7 @@ func TestState_UpdateProject(t *testing.T) {
 func TestState_UpdateRepository(t *testing.T) {
 	state := NewState("workspace")
 
-	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1")
+	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1", "")
 
 	repo, ok := state.Repositories["repo-1"]
 	if !ok {
@@ -154,7 +156,7 @@ func TestState_PRTimestamps(t *testing.T) {
 	}
 
 	// Add repo
-	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1")
+	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1", "")
 
 	// Set PR timestamp
 	state.SetRepoLastPRUpdated("repo-1", "2025-01-15T10:00:00Z")
@@ -168,7 +170,7 @@ func TestState_IssueTimestamps(t *testing.T) {
 	state := NewState("workspace")
 
 	// Add repo
-	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1")
+	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1", "")
 
 	// Set issue timestamp
 	state.SetRepoLastIssueUpdated("repo-1", "2025-01-15T11:00:00Z")
@@ -178,6 +180,25 @@ func TestState_IssueTimestamps(t *testing.T) {
 	}
 }
 
+func TestState_TimestampsForNewRepo(t *testing.T) {
+	state := NewState("workspace")
+
+	// A first backup saves PRs and issues before the repository succeeds
+	state.SetRepoLastPRUpdated("repo-1", "2025-01-15T10:00:00Z")
+	state.SetRepoLastIssueUpdated("repo-1", "2025-01-15T11:00:00Z")
+	if _, ok := state.Repositories["repo-1"]; ok {
+		t.Fatal("repository tracked before it succeeded")
+	}
+
+	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1", "")
+	if ts := state.GetLastPRUpdated("repo-1"); ts != "2025-01-15T10:00:00Z" {
+		t.Errorf("expected PR timestamp '2025-01-15T10:00:00Z', got '%s'", ts)
+	}
+	if ts := state.GetLastIssueUpdated("repo-1"); ts != "2025-01-15T11:00:00Z" {
+		t.Errorf("expected issue timestamp '2025-01-15T11:00:00Z', got '%s'", ts)
+	}
+}
+
 func TestState_IsNewRepo(t *testing.T) {
 	state := NewState("workspace")
 
@@ -185,7 +206,7 @@ func TestState_IsNewRepo(t *testing.T) {
 		t.Error("repo-1 should be new")
 	}
 
-	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1")
+	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1", "")
 
 	if state.IsNewRepo("repo-1") {
 		t.Error("repo-1 should not be new after update")
@@ -200,7 +221,7 @@ func TestState_GetRepoState(t *testing.T) {
 		t.Error("expected false for nonexistent repo")
 	}
 
-	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1")
+	state.UpdateRepository("repo-1", "uuid-r1", "PROJ1", "")
 
 	repoState, ok := state.GetRepoState("repo-1")
 	if !ok {
@@ -232,3 +253,84 @@ func TestState_SaveCreatesDir(t *testing.T) {
 		t.Error("state file should have been created")
 	}
 }
+
+func TestCheckpointState(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.state = NewState("ws")
+	b.state.UpdateRepository("api", "{uuid}", "CORE", "")
+
+	path := filepath.Join(t.TempDir(), StateFileName)
+	b.checkpointState(path, "test")
+
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if _, ok := loaded.Repositories["api"]; !ok {
+		t.Error("checkpoint did not include repository state")
+	}
+}
+
+func TestCheckpointState_Buffered(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.cfg.Storage.Path = b.storage.BasePath()
+	b.state = NewState("ws")
+	b.bufferWrites()
+	defer b.closeWrites()
+
+	path := GetStatePath(b.cfg.Storage.Path, "ws")
+	for _, slug := range []string{"api", "web"} {
+		b.state.UpdateRepository(slug, "{uuid}", "CORE", "")
+		b.checkpointState(path, "test")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("checkpoint should wait for the next flush")
+	}
+
+	b.flushWrites()
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if len(loaded.Repositories) != 2 {
+		t.Errorf("expected the last checkpoint on disk, got %d repositories", len(loaded.Repositories))
+	}
+}
+
+func TestFetchRemoteState(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write("ws/"+StateFileName, []byte(`{"workspace": "ws", "last_full_backup": "2026-01-02T03:04:05Z"}`)); err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewReplicated(local, remote)
+
+	statePath := GetStatePath(local.BasePath(), "ws")
+	if err := fetchRemoteState(store, statePath); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.HasPreviousBackup() {
+		t.Error("state fetched from the replica has no previous backup")
+	}
+
+	// A local state file wins
+	if err := os.WriteFile(statePath, []byte(`{"workspace": "ws"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fetchRemoteState(store, statePath); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(statePath); string(data) != `{"workspace": "ws"}` {
+		t.Errorf("local state was overwritten: %s", data)
+	}
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stateClockSkew is how far past now a recorded timestamp may be before
+// it is reported, allowing for clock differences with Bitbucket.
+const stateClockSkew = 5 * time.Minute
+
+// StateCheck is the outcome of cross-checking a workspace's state file
+// against its latest/ tree. Problems are corruption that breaks
+// incremental backups; warnings are explainable differences, such as a
+// repository whose first backup failed after writing its metadata.
+type StateCheck struct {
+	StateFile string   `json:"state_file"`
+	Tracked   int      `json:"tracked"` // Repositories in the state file
+	OnDisk    int      `json:"on_disk"` // Repository directories in latest/
+	Problems  []string `json:"problems,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// Valid reports whether the check found no problems.
+func (c *StateCheck) Valid() bool {
+	return len(c.Problems) == 0
+}
+
+// CheckState cross-checks the state file in workspaceDir against latest/:
+// every tracked repository must have a directory in latest/, every
+// directory must be tracked, and no PR or issue timestamp may lie in the
+// future, since incremental runs only fetch what changed after it. It
+// returns nil if the workspace has no state file.
+func CheckState(workspaceDir string, now time.Time) (*StateCheck, error) {
+	statePath := filepath.Join(workspaceDir, StateFileName)
+	state, err := LoadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, nil
+	}
+	check := &StateCheck{StateFile: statePath, Tracked: len(state.Repositories)}
+
+	onDisk := make(map[string]string) // slug -> project key ("" for personal)
+	latest, err := filepath.EvalSymlinks(filepath.Join(workspaceDir, LatestDirName))
+	hasLatest := err == nil
+	if hasLatest {
+		repos, err := runRepoDirs(latest)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			onDisk[repo.slug] = repoDirProject(repo.rel)
+		}
+	} else if len(state.Repositories) > 0 {
+		check.Problems = append(check.Problems, fmt.Sprintf("%s/ not found, but the state file tracks %d repositories", LatestDirName, len(state.Repositories)))
+	}
+	check.OnDisk = len(onDisk)
+
+	slugs := make([]string, 0, len(state.Repositories))
+	for slug := range state.Repositories {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	for _, slug := range slugs {
+		repo := state.Repositories[slug]
+		project, ok := onDisk[slug]
+		switch {
+		case !ok && hasLatest:
+			check.Problems = append(check.Problems, fmt.Sprintf("%s: tracked in the state file but missing from %s/", slug, LatestDirName))
+		case ok && project != repo.ProjectKey:
+			check.Warnings = append(check.Warnings, fmt.Sprintf("%s: state file records project %q but %s/ has it under %q", slug, repo.ProjectKey, LatestDirName, project))
+		}
+		for _, ts := range []struct{ name, value string }{
+			{"last_pr_updated", repo.LastPRUpdated},
+			{"last_issue_updated", repo.LastIssueUpdated},
+		} {
+			if ts.value == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, ts.value)
+			switch {
+			case err != nil:
+				check.Problems = append(check.Problems, fmt.Sprintf("%s: %s %q is not a timestamp", slug, ts.name, ts.value))
+			case t.After(now.Add(stateClockSkew)):
+				check.Problems = append(check.Problems, fmt.Sprintf("%s: %s %s is in the future; incremental runs will miss changes until then", slug, ts.name, ts.value))
+			}
+		}
+	}
+
+	untracked := make([]string, 0)
+	for slug := range onDisk {
+		if _, ok := state.Repositories[slug]; !ok {
+			untracked = append(untracked, slug)
+		}
+	}
+	sort.Strings(untracked)
+	for _, slug := range untracked {
+		_, failed := state.FailedRepos[slug]
+		_, quarantined := state.Quarantine[slug]
+		if failed || quarantined {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("%s: in %s/ but has not been backed up successfully yet", slug, LatestDirName))
+			continue
+		}
+		check.Problems = append(check.Problems, fmt.Sprintf("%s: in %s/ but not tracked in the state file", slug, LatestDirName))
+	}
+	return check, nil
+}
+
+// repoDirProject returns the project key of a repository directory path
+// as listed by runRepoDirs, or "" for a personal repository.
+func repoDirProject(rel string) string {
+	dir := filepath.Dir(filepath.Dir(rel)) // projects/<key> or personal
+	if filepath.Dir(dir) == "projects" {
+		return filepath.Base(dir)
+	}
+	return ""
+}
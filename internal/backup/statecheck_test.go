@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckState(t *testing.T) {
+	wsDir := t.TempDir()
+	for _, rel := range []string{
+		"latest/projects/CORE/repositories/api",
+		"latest/projects/WEB/repositories/site",
+		"latest/personal/repositories/stray",
+		"latest/personal/repositories/first-try",
+	} {
+		if err := os.MkdirAll(filepath.Join(wsDir, rel), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	state := NewState("ws")
+	state.UpdateRepository("api", "{1}", "CORE", "")
+	state.SetRepoLastPRUpdated("api", now.Add(-time.Hour).UTC().Format(time.RFC3339))
+	state.UpdateRepository("site", "{2}", "CORE", "") // Moved to WEB on disk
+	state.SetRepoLastIssueUpdated("site", now.Add(48*time.Hour).UTC().Format(time.RFC3339))
+	state.UpdateRepository("gone", "{3}", "CORE", "")
+	state.AddFailedRepo("first-try", "", errors.New("clone failed"), 1)
+	if err := state.Save(filepath.Join(wsDir, StateFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	check, err := CheckState(wsDir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Valid() || check.Tracked != 3 || check.OnDisk != 4 {
+		t.Fatalf("check = %+v", check)
+	}
+	problems := strings.Join(check.Problems, "\n")
+	for _, want := range []string{
+		"gone: tracked in the state file but missing from latest/",
+		"site: last_issue_updated",
+		"stray: in latest/ but not tracked",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("problems missing %q:\n%s", want, problems)
+		}
+	}
+	if strings.Contains(problems, "api") || strings.Contains(problems, "first-try") {
+		t.Errorf("unexpected problems:\n%s", problems)
+	}
+	warnings := strings.Join(check.Warnings, "\n")
+	for _, want := range []string{`site: state file records project "CORE"`, "first-try: in latest/ but has not been backed up successfully"} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("warnings missing %q:\n%s", want, warnings)
+		}
+	}
+}
+
+func TestCheckState_NoStateFile(t *testing.T) {
+	check, err := CheckState(t.TempDir(), time.Now())
+	if err != nil || check != nil {
+		t.Errorf("CheckState() = %+v, %v; want nil, nil", check, err)
+	}
+}
+
+func TestCheckState_NoLatest(t *testing.T) {
+	wsDir := t.TempDir()
+	state := NewState("ws")
+	state.UpdateRepository("api", "{1}", "CORE", "")
+	if err := state.Save(filepath.Join(wsDir, StateFileName)); err != nil {
+		t.Fatal(err)
+	}
+	check, err := CheckState(wsDir, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(check.Problems) != 1 || !strings.Contains(check.Problems[0], "latest/ not found") {
+		t.Errorf("problems = %v", check.Problems)
+	}
+}
@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/format"
+)
+
+// RunSummary is the overview of a finished run. Text and interactive
+// output print it as a table; JSON progress output carries it in the
+// summary event.
+type RunSummary struct {
+	Succeeded       int     `json:"succeeded"`
+	Failed          int     `json:"failed"`
+	Skipped         int     `json:"skipped"` // Resumed, archived, or quarantined repositories not run
+	Interrupted     int     `json:"interrupted,omitempty"`
+	Deferred        int     `json:"deferred,omitempty"` // Left for the next run by runtime budgets
+	PullRequests    int     `json:"pull_requests"`
+	Issues          int     `json:"issues"`
+	Bytes           int64   `json:"bytes"` // Size of the mirrors this run fetched
+	APIRequests     int     `json:"api_requests"`
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	// Phases are the run's stages in wall time. RepoPhases split the
+	// repository stage by what the workers spent it on, summed over
+	// workers, so they can add up to more than the stage itself.
+	Phases     []PhaseTime `json:"phases"`
+	RepoPhases []PhaseTime `json:"repository_phases,omitempty"`
+
+	// FailureCodes counts the failed repositories by failure code
+	FailureCodes map[errcode.Code]int `json:"failure_codes,omitempty"`
+}
+
+// PhaseTime is the time spent in one phase of a run.
+type PhaseTime struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// phaseTimes is the time a repository's backup spent in each phase.
+type phaseTimes struct {
+	Metadata     time.Duration // repository.json, custom metadata, policies, settings, integrations, raw mode
+	PullRequests time.Duration
+	Issues       time.Duration
+	Git          time.Duration // Clone or fetch, then scans, integrity, and README
+}
+
+func (p *phaseTimes) add(o phaseTimes) {
+	p.Metadata += o.Metadata
+	p.PullRequests += o.PullRequests
+	p.Issues += o.Issues
+	p.Git += o.Git
+}
+
+// runSummary builds the summary of a run from its stats. listing,
+// processing, and elapsed are wall times measured from the run's start.
+func (b *Backup) runSummary(stats *backupStats, listing, processing, elapsed time.Duration) *RunSummary {
+	summary := &RunSummary{
+		Succeeded:       stats.BackedUp,
+		Failed:          stats.Failed,
+		Skipped:         stats.Skipped,
+		Interrupted:     stats.Interrupted,
+		Deferred:        stats.Deferred,
+		PullRequests:    stats.PullRequests,
+		Issues:          stats.Issues,
+		Bytes:           stats.Bytes,
+		APIRequests:     b.client.Usage().Requests,
+		DurationSeconds: elapsed.Seconds(),
+		FailureCodes:    b.report.FailureCodes(),
+		Phases: []PhaseTime{
+			{"listing", listing.Seconds()},
+			{"repositories", processing.Seconds()},
+			{"finishing", (elapsed - listing - processing).Seconds()},
+		},
+	}
+	for _, phase := range []PhaseTime{
+		{"metadata", stats.Phases.Metadata.Seconds()},
+		{"pull_requests", stats.Phases.PullRequests.Seconds()},
+		{"issues", stats.Phases.Issues.Seconds()},
+		{"git", stats.Phases.Git.Seconds()},
+	} {
+		if phase.Seconds > 0 {
+			summary.RepoPhases = append(summary.RepoPhases, phase)
+		}
+	}
+	return summary
+}
+
+// WriteTable prints the summary as an aligned two-column table.
+func (s *RunSummary) WriteTable(w io.Writer) {
+	type row struct{ label, value string }
+	count := func(n int) string { return format.Count(int64(n)) }
+	seconds := func(sec float64) string { return format.Duration(time.Duration(sec * float64(time.Second))) }
+
+	rows := []row{
+		{"Repositories", ""},
+		{"  backed up", count(s.Succeeded)},
+		{"  failed", count(s.Failed)},
+	}
+	codes := make([]string, 0, len(s.FailureCodes))
+	for code := range s.FailureCodes {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		rows = append(rows, row{"    " + code, count(s.FailureCodes[errcode.Code(code)])})
+	}
+	rows = append(rows, row{"  skipped", count(s.Skipped)})
+	if s.Interrupted > 0 {
+		rows = append(rows, row{"  interrupted", count(s.Interrupted)})
+	}
+	if s.Deferred > 0 {
+		rows = append(rows, row{"  deferred", count(s.Deferred)})
+	}
+	rows = append(rows,
+		row{"Pull requests", count(s.PullRequests)},
+		row{"Issues", count(s.Issues)},
+		row{"Git data", format.Bytes(s.Bytes)},
+		row{"API requests", count(s.APIRequests)},
+		row{"Time", seconds(s.DurationSeconds)},
+	)
+	for _, phase := range s.Phases {
+		rows = append(rows, row{"  " + phase.Name, seconds(phase.Seconds)})
+		if phase.Name != "repositories" {
+			continue
+		}
+		for _, rp := range s.RepoPhases {
+			rows = append(rows, row{"    " + strings.ReplaceAll(rp.Name, "_", " "), seconds(rp.Seconds)})
+		}
+	}
+
+	labelWidth, valueWidth := 0, 0
+	for _, r := range rows {
+		labelWidth = max(labelWidth, len(r.label))
+		valueWidth = max(valueWidth, len(r.value))
+	}
+	for _, r := range rows {
+		line := fmt.Sprintf("  %-*s  %*s", labelWidth, r.label, valueWidth, r.value)
+		_, _ = fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	if len(s.RepoPhases) > 0 {
+		_, _ = fmt.Fprintln(w, "  (repository phases are summed over workers)")
+	}
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+func testRunSummary() *RunSummary {
+	return &RunSummary{
+		Succeeded:       1234,
+		Failed:          2,
+		Skipped:         3,
+		PullRequests:    56,
+		Issues:          7,
+		Bytes:           3 << 30,
+		APIRequests:     890,
+		DurationSeconds: 135,
+		Phases:          []PhaseTime{{"listing", 4}, {"repositories", 130}, {"finishing", 1}},
+		RepoPhases:      []PhaseTime{{"pull_requests", 30}, {"git", 110}},
+		FailureCodes:    map[errcode.Code]int{errcode.GitTimeout: 1, errcode.AuthFailed: 1},
+	}
+}
+
+func TestRunSummary_WriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	testRunSummary().WriteTable(&buf)
+	out := buf.String()
+
+	for _, want := range []string{"  backed up", "1,234", "3.0 GB", "2m15s", "    pull requests", "    git", "    AUTH_FAILED"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "interrupted") {
+		t.Errorf("table shows interrupted with none:\n%s", out)
+	}
+
+	// Values are right-aligned in one column
+	width := -1
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		if line == "  Repositories" || strings.HasPrefix(line, "  (") {
+			continue
+		}
+		if width >= 0 && len(line) != width {
+			t.Errorf("line %q is %d wide, want %d", line, len(line), width)
+		}
+		width = len(line)
+	}
+}
+
+func TestProgressSummaryEvent(t *testing.T) {
+	var text bytes.Buffer
+	var events recordingSink
+	p := NewProgress(1, false, true, false, WithProgressSink(NewTextProgressSink(&text)), WithProgressSink(&events))
+	p.Start("repo")
+	p.Complete("repo")
+	p.Summary(testRunSummary())
+
+	if !strings.Contains(text.String(), "Backup complete") || !strings.Contains(text.String(), "API requests") {
+		t.Errorf("text output missing summary table:\n%s", text.String())
+	}
+
+	last := events.events[len(events.events)-1]
+	data, err := json.Marshal(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Summary *RunSummary `json:"summary"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.Summary == nil {
+		t.Fatalf("summary event %s has no summary object (%v)", data, err)
+	}
+	if decoded.Summary.Succeeded != 1234 || len(decoded.Summary.RepoPhases) != 2 {
+		t.Errorf("summary = %+v", decoded.Summary)
+	}
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/throttle"
+)
+
+// throttleInterval is how often the worker limit is reconsidered. The
+// 1-minute load average moves slowly, so sampling faster only adds noise.
+const throttleInterval = 15 * time.Second
+
+// lowerPriority applies throttle.io_class and throttle.cpu_nice to the
+// process. A setting the system does not support is logged, not fatal.
+func (b *Backup) lowerPriority() {
+	t := b.cfg.Throttle
+	if t.IOClass == "" && t.CPUNice == 0 {
+		return
+	}
+	if err := throttle.Lower(t.IOClass, t.CPUNice); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			b.log.Info("Throttling partly unsupported on this system: %v", err)
+		} else {
+			b.log.Error("Failed to lower process priority: %v", err)
+		}
+		return
+	}
+	b.log.Debug("Lowered process priority (io_class %q, cpu_nice %d)", t.IOClass, t.CPUNice)
+}
+
+// workerGate lets at most limit workers process jobs at once. The limit
+// can change while the run goes on; workers past a lowered limit finish
+// their job before waiting.
+type workerGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+// newWorkerGate creates a gate admitting limit workers.
+func newWorkerGate(limit int) *workerGate {
+	g := &workerGate{limit: limit}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// enter waits for a free slot and takes it. It returns false when ctx is
+// done first.
+func (g *workerGate) enter(ctx context.Context) bool {
+	stop := context.AfterFunc(ctx, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.cond.Broadcast()
+	})
+	defer stop()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.active >= g.limit {
+		if ctx.Err() != nil {
+			return false
+		}
+		g.cond.Wait()
+	}
+	g.active++
+	return true
+}
+
+// leave releases the slot taken by enter.
+func (g *workerGate) leave() {
+	g.mu.Lock()
+	g.active--
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// setLimit changes the number of workers admitted at once.
+func (g *workerGate) setLimit(limit int) {
+	g.mu.Lock()
+	g.limit = limit
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// governWorkers adjusts gate's limit every throttleInterval from the system
+// load (throttle.nice) and the run's CPU use (throttle.cpu_percent) until
+// ctx is done.
+func (b *Backup) governWorkers(ctx context.Context, gate *workerGate, workers int) {
+	t := b.cfg.Throttle
+	gov := &throttle.Governor{Max: workers, Min: t.MinWorkers, CPUPercent: float64(t.CPUPercent)}
+	if t.Nice {
+		gov.LoadThreshold = t.LoadThreshold
+	}
+	var probe throttle.Probe
+	if s := probe.Sample(time.Now()); t.Nice && s.Load < 0 {
+		b.log.Info("Throttling by load is not supported on this system; only cpu_percent applies")
+	}
+
+	ticker := time.NewTicker(throttleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s := probe.Sample(now)
+			before := gov.Limit()
+			if limit := gov.Update(s); limit != before {
+				gate.setLimit(limit)
+				b.log.Info("Throttle: load %.2f per core, CPU %.0f%%; running %d of %d workers", s.Load, s.CPUPercent, limit, workers)
+			}
+		}
+	}
+}
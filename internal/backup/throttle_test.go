@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerGate(t *testing.T) {
+	gate := newWorkerGate(2)
+	ctx := context.Background()
+
+	var running, peak atomic.Int64
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !gate.enter(ctx) {
+				t.Error("enter() = false with a live context")
+				return
+			}
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			<-release
+			running.Add(-1)
+			gate.leave()
+		}()
+	}
+
+	waitFor(t, func() bool { return running.Load() == 2 })
+	time.Sleep(10 * time.Millisecond)
+	if got := running.Load(); got != 2 {
+		t.Fatalf("%d workers admitted, want 2", got)
+	}
+
+	// Raising the limit admits the waiting workers at once
+	gate.setLimit(4)
+	waitFor(t, func() bool { return running.Load() == 4 })
+	close(release)
+	wg.Wait()
+	if peak.Load() != 4 {
+		t.Errorf("peak = %d, want 4", peak.Load())
+	}
+}
+
+func TestWorkerGate_Canceled(t *testing.T) {
+	gate := newWorkerGate(1)
+	if !gate.enter(context.Background()) {
+		t.Fatal("enter() = false")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- gate.enter(ctx) }()
+	cancel()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("enter() = true after cancellation with no free slot")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("enter() did not return after cancellation")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
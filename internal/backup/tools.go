@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// goGitModule is the go-git module path; a replace directive may point it
+// at a fork, whose version is reported instead.
+const goGitModule = "github.com/go-git/go-git/v5"
+
+// ManifestTools records the versions that produced a run, so anomalies can
+// be matched to upgrades.
+type ManifestTools struct {
+	BBBackup string `json:"bb_backup"`
+	Commit   string `json:"commit,omitempty"`
+	Go       string `json:"go"`
+	GoGit    string `json:"go_git,omitempty"`
+	GitCLI   string `json:"git_cli,omitempty"` // Empty when git is not installed
+}
+
+// toolVersions collects the versions of bb-backup, the Go runtime, go-git,
+// and the git CLI.
+func (b *Backup) toolVersions() ManifestTools {
+	tools := ManifestTools{
+		BBBackup: b.opts.Version,
+		Commit:   b.opts.Commit,
+		Go:       runtime.Version(),
+		GoGit:    goGitVersion(),
+		GitCLI:   b.gitCLIVersion,
+	}
+	if tools.BBBackup == "" {
+		tools.BBBackup = "dev"
+	}
+	if tools.Commit == "unknown" {
+		tools.Commit = ""
+	}
+	return tools
+}
+
+// goGitVersion returns the go-git version compiled in, naming the fork if
+// the module is replaced.
+func goGitVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != goGitModule {
+			continue
+		}
+		if r := dep.Replace; r != nil {
+			return r.Path + " " + r.Version
+		}
+		return dep.Version
+	}
+	return ""
+}
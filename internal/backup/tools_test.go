@@ -0,0 +1,22 @@
+package backup
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestToolVersions(t *testing.T) {
+	b := newRunTestBackup(t, "")
+	b.opts.Commit = "unknown"
+	b.gitCLIVersion = "git version 2.43.0"
+
+	tools := b.toolVersions()
+	if tools.BBBackup != "dev" || tools.Commit != "" || tools.Go != runtime.Version() || tools.GitCLI != "git version 2.43.0" {
+		t.Errorf("toolVersions() = %+v", tools)
+	}
+	// go.mod replaces go-git with a fork; the manifest should say so
+	if !strings.Contains(tools.GoGit, "go-git") {
+		t.Errorf("GoGit = %q, want the replacement module and version", tools.GoGit)
+	}
+}
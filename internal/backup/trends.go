@@ -0,0 +1,145 @@
+package backup
+
+import "time"
+
+// RunHistoryLength is the number of runs whose stats are kept in the state
+// file for `bb-backup trends`.
+const RunHistoryLength = 100
+
+// Thresholds for flagging a run that took much longer, or failed much
+// more, than the runs before it.
+const (
+	trendMinRuns      = 3
+	trendSlowFactor   = 2.0
+	trendFailureDelta = 0.05
+)
+
+// RunStats is the key numbers of one run, kept in the state file to show
+// how backups change over time.
+type RunStats struct {
+	RunID           string  `json:"run_id"`
+	StartedAt       string  `json:"started_at"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Full            bool    `json:"full,omitempty"`
+	Repositories    int     `json:"repositories"` // In the backup after the run, including ones carried over
+	BackedUp        int     `json:"backed_up"`    // Backed up by this run
+	Failed          int     `json:"failed"`
+	PullRequests    int     `json:"pull_requests"`
+	Issues          int     `json:"issues"`
+	UsageBytes      int64   `json:"usage_bytes,omitempty"` // Workspace disk usage after the run
+	APIRequests     int     `json:"api_requests,omitempty"`
+}
+
+// FailureRate returns the share of the repositories the run tried that
+// failed.
+func (r RunStats) FailureRate() float64 {
+	if tried := r.BackedUp + r.Failed; tried > 0 {
+		return float64(r.Failed) / float64(tried)
+	}
+	return 0
+}
+
+// RecordRun appends a run's stats, keeping the last RunHistoryLength.
+func (s *State) RecordRun(run RunStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Runs = append(s.Runs, run)
+	if n := len(s.Runs); n > RunHistoryLength {
+		s.Runs = append([]RunStats(nil), s.Runs[n-RunHistoryLength:]...)
+	}
+}
+
+// RecentRuns returns the stats of the last n recorded runs (all of them
+// when n is not positive), oldest first.
+func (s *State) RecentRuns(n int) []RunStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	runs := s.Runs
+	if n > 0 && len(runs) > n {
+		runs = runs[len(runs)-n:]
+	}
+	return append([]RunStats(nil), runs...)
+}
+
+// recordRunStats adds this run to the state's run history.
+func (b *Backup) recordRunStats(startTime time.Time, full bool, stats *backupStats, usage *WorkspaceUsage) {
+	run := RunStats{
+		RunID:           b.runID,
+		StartedAt:       startTime.UTC().Format(time.RFC3339),
+		DurationSeconds: time.Since(startTime).Seconds(),
+		Full:            full,
+		Repositories:    stats.Repos,
+		BackedUp:        stats.BackedUp,
+		Failed:          stats.Failed,
+		PullRequests:    stats.PullRequests,
+		Issues:          stats.Issues,
+		APIRequests:     b.client.Usage().Requests,
+	}
+	if usage != nil {
+		run.UsageBytes = usage.Bytes
+	}
+	b.state.RecordRun(run)
+}
+
+// Trends compares the last of a series of runs with the ones before it,
+// for `bb-backup trends`.
+type Trends struct {
+	Runs int `json:"runs"`
+
+	// Change from the first run to the last
+	RepositoriesFirst int   `json:"repositories_first"`
+	RepositoriesLast  int   `json:"repositories_last"`
+	UsageBytesFirst   int64 `json:"usage_bytes_first,omitempty"`
+	UsageBytesLast    int64 `json:"usage_bytes_last,omitempty"`
+
+	// The last run against the median of the runs before it
+	MedianSeconds     float64 `json:"median_seconds"`
+	LastSeconds       float64 `json:"last_seconds"`
+	MedianFailureRate float64 `json:"median_failure_rate"`
+	LastFailureRate   float64 `json:"last_failure_rate"`
+
+	// Set when the last run was much slower, or failed much more, than
+	// usual; both need at least three earlier runs
+	Slower       bool `json:"slower,omitempty"`
+	MoreFailures bool `json:"more_failures,omitempty"`
+}
+
+// RunTrends summarizes runs, oldest first. It returns nil for no runs.
+func RunTrends(runs []RunStats) *Trends {
+	if len(runs) == 0 {
+		return nil
+	}
+	first, last := runs[0], runs[len(runs)-1]
+	t := &Trends{
+		Runs:              len(runs),
+		RepositoriesFirst: first.Repositories,
+		RepositoriesLast:  last.Repositories,
+		LastSeconds:       last.DurationSeconds,
+		LastFailureRate:   last.FailureRate(),
+	}
+	for _, r := range runs {
+		if r.UsageBytes > 0 {
+			if t.UsageBytesFirst == 0 {
+				t.UsageBytesFirst = r.UsageBytes
+			}
+			t.UsageBytesLast = r.UsageBytes
+		}
+	}
+
+	earlier := runs[:len(runs)-1]
+	if len(earlier) == 0 {
+		t.MedianSeconds, t.MedianFailureRate = t.LastSeconds, t.LastFailureRate
+		return t
+	}
+	seconds := make([]float64, len(earlier))
+	rates := make([]float64, len(earlier))
+	for i, r := range earlier {
+		seconds[i], rates[i] = r.DurationSeconds, r.FailureRate()
+	}
+	t.MedianSeconds, t.MedianFailureRate = median(seconds), median(rates)
+	if len(earlier) >= trendMinRuns {
+		t.Slower = t.LastSeconds > trendSlowFactor*t.MedianSeconds && t.LastSeconds-t.MedianSeconds >= balloonMinDelta.Seconds()
+		t.MoreFailures = t.LastFailureRate > t.MedianFailureRate+trendFailureDelta
+	}
+	return t
+}
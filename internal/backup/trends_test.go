@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRecordRun_KeepsLast(t *testing.T) {
+	s := NewState("ws")
+	for i := 0; i < RunHistoryLength+5; i++ {
+		s.RecordRun(RunStats{RunID: fmt.Sprint(i)})
+	}
+	runs := s.RecentRuns(0)
+	if len(runs) != RunHistoryLength || runs[0].RunID != "5" {
+		t.Fatalf("kept %d runs starting at %s, want %d starting at 5", len(runs), runs[0].RunID, RunHistoryLength)
+	}
+	if recent := s.RecentRuns(3); len(recent) != 3 || recent[2].RunID != fmt.Sprint(RunHistoryLength+4) {
+		t.Errorf("RecentRuns(3) = %+v", recent)
+	}
+}
+
+func TestRunTrends(t *testing.T) {
+	if RunTrends(nil) != nil {
+		t.Error("RunTrends(nil) should be nil")
+	}
+
+	usual := RunStats{Repositories: 100, BackedUp: 100, DurationSeconds: 600, UsageBytes: 1 << 30}
+	runs := []RunStats{usual, usual, usual}
+	runs[0].Repositories = 90
+	runs[0].UsageBytes = 0 // measured from the second run on
+
+	steady := RunTrends(append(runs, usual))
+	if steady.Slower || steady.MoreFailures {
+		t.Errorf("steady runs flagged: %+v", steady)
+	}
+	if steady.RepositoriesFirst != 90 || steady.RepositoriesLast != 100 || steady.UsageBytesFirst != 1<<30 {
+		t.Errorf("steady trends = %+v", steady)
+	}
+
+	bad := RunStats{Repositories: 100, BackedUp: 80, Failed: 20, DurationSeconds: 1800}
+	got := RunTrends(append(runs, bad))
+	if !got.Slower || !got.MoreFailures || got.MedianSeconds != 600 || got.LastFailureRate != 0.2 {
+		t.Errorf("regression trends = %+v", got)
+	}
+
+	// Too few earlier runs to judge
+	if few := RunTrends([]RunStats{usual, bad}); few.Slower || few.MoreFailures {
+		t.Errorf("flagged with one earlier run: %+v", few)
+	}
+}
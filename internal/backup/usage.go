@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+// APIUsage is a run's API consumption, recorded in manifest.json and
+// report.json for capacity planning.
+type APIUsage struct {
+	api.Usage
+
+	// RequestsPerHour is the run's average request rate
+	RequestsPerHour float64 `json:"requests_per_hour"`
+	// QuotaFraction is requests divided by the hourly limit Bitbucket
+	// reported: the share of one hour's quota the run used
+	QuotaFraction float64 `json:"quota_fraction,omitempty"`
+}
+
+// apiUsage returns the API requests sent so far by this run.
+func (b *Backup) apiUsage(elapsed time.Duration) *APIUsage {
+	usage := &APIUsage{Usage: b.client.Usage()}
+	if hours := elapsed.Hours(); hours > 0 {
+		usage.RequestsPerHour = float64(usage.Requests) / hours
+	}
+	if usage.Limit > 0 {
+		usage.QuotaFraction = float64(usage.Requests) / float64(usage.Limit)
+	}
+	return usage
+}
+
+// logAPIUsage logs the run's request count, quota share, and breakdown.
+func (b *Backup) logAPIUsage(usage *APIUsage) {
+	if usage.Requests == 0 {
+		return
+	}
+	msg := fmt.Sprintf("API usage: %d requests (%.0f/hour)", usage.Requests, usage.RequestsPerHour)
+	if usage.Limit > 0 {
+		msg += fmt.Sprintf(", %.0f%% of the hourly limit of %d", usage.QuotaFraction*100, usage.Limit)
+	}
+	if usage.Remaining != nil {
+		msg += fmt.Sprintf(", %d remaining", *usage.Remaining)
+	}
+	if usage.RateLimited > 0 {
+		msg += fmt.Sprintf(", %d rate limited", usage.RateLimited)
+	}
+	b.log.Info("%s", msg)
+
+	categories := make([]string, 0, len(usage.ByEndpoint))
+	for c := range usage.ByEndpoint {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+	parts := make([]string, len(categories))
+	for i, c := range categories {
+		parts[i] = fmt.Sprintf("%s %d", c, usage.ByEndpoint[c])
+	}
+	b.log.Debug("API usage by endpoint: %s", strings.Join(parts, ", "))
+}
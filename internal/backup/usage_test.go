@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+)
+
+func TestAPIUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Remaining", "950")
+		w.Write([]byte(`{"values": []}`))
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	cfg.RateLimit.RequestsPerHour = 36000
+	cfg.RateLimit.BurstSize = 100
+	b := newRunTestBackup(t, "")
+	setClient(b, api.NewClient(cfg, api.WithBaseURL(server.URL)))
+
+	for i := 0; i < 50; i++ {
+		if _, err := b.client.GetPaginated(context.Background(), "/repositories/ws/repo/pullrequests"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage := b.apiUsage(30 * time.Minute)
+	if usage.Requests != 50 || usage.RequestsPerHour != 100 {
+		t.Errorf("expected 50 requests at 100/hour, got %d at %.1f", usage.Requests, usage.RequestsPerHour)
+	}
+	if usage.QuotaFraction != 0.05 {
+		t.Errorf("expected 5%% of the hourly quota, got %v", usage.QuotaFraction)
+	}
+
+	// Usage fields are flattened into the manifest's api_usage object
+	data, err := json.Marshal(&Manifest{APIUsage: usage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"requests":50`, `"pull_requests":50`, `"remaining":950`, `"quota_fraction":0.05`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("manifest JSON missing %s: %s", want, data)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// wikiDirName is the wiki's mirror next to repo.git in latest/.
+const wikiDirName = "wiki.git"
+
+// getLatestWikiPath returns the storage-relative path of a repository's
+// wiki mirror.
+func (b *Backup) getLatestWikiPath(repo *api.Repository) string {
+	return b.getLatestRepoDir(repo) + "/" + wikiDirName
+}
+
+// backupWiki clones or fetches the mirror of a repository's wiki, a git
+// repository of its own at <clone URL>/wiki, with backup.include_wikis.
+// It returns the mirror's size, or 0 when there is no wiki to back up.
+func (b *Backup) backupWiki(ctx context.Context, repo *api.Repository) (int64, error) {
+	if !b.cfg.Backup.IncludeWikis || !repo.HasWiki || b.opts.DryRun {
+		return 0, nil
+	}
+	cloneURL := b.cfg.Git.CloneURL(repo.CloneURL())
+	if cloneURL == "" {
+		return 0, nil
+	}
+	cloneURL += "/wiki"
+
+	prefix := api.LogPrefix(ctx)
+	fullWikiPath := b.storage.BasePath() + "/" + b.getLatestWikiPath(repo)
+	isClone := !isValidGitRepo(fullWikiPath)
+	timeout := time.Duration(b.cfg.Backup.GitTimeoutMinutes) * time.Minute
+	if timeout <= 0 {
+		timeout = 30 * time.Minute
+	}
+
+	if b.progress != nil && !b.shuttingDown.Load() {
+		b.progress.UpdateStatus(fmt.Sprintf("wiki: %s", repo.Slug))
+	}
+
+	engine := b.cfg.Git.EngineFor(repo.Slug)
+	if engine == gitEngineCLI && b.shellGitClient != nil {
+		if err := b.runShellGit(ctx, timeout, cloneURL, fullWikiPath, repo, isClone, nil); err != nil {
+			return 0, err
+		}
+		return git.DirSize(fullWikiPath), nil
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var err error
+	if isClone {
+		b.log.Debug("%sCloning wiki of %s (mirror, go-git)", prefix, repo.Slug)
+		err = b.gitClient.CloneMirror(gitCtx, cloneURL, fullWikiPath)
+	} else {
+		b.log.Debug("%sFetching wiki updates for %s (go-git)", prefix, repo.Slug)
+		err = b.gitClient.Fetch(gitCtx, fullWikiPath)
+	}
+	if err != nil && engine != gitEngineGoGit && b.shellGitClient != nil && isGoGitRetryableError(err) {
+		if isClone {
+			_ = os.RemoveAll(fullWikiPath)
+		}
+		err = b.runShellGit(ctx, timeout, cloneURL, fullWikiPath, repo, isClone, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return git.DirSize(fullWikiPath), nil
+}
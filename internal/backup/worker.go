@@ -2,15 +2,23 @@ package backup
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/auth"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/format"
+	"github.com/andy-wilson/bb-backup/internal/git"
+	"github.com/andy-wilson/bb-backup/internal/scan"
+	"github.com/andy-wilson/bb-backup/internal/storage"
 	"github.com/google/uuid"
 )
 
@@ -34,6 +42,58 @@ type repoResult struct {
 type repoStats struct {
 	PullRequests int
 	Issues       int
+	// Entities re-fetched by an incremental run but identical to latest/,
+	// so neither copy was rewritten
+	PullRequestsUnchanged int
+	IssuesUnchanged       int
+	IssuesSkipped         string // Why issues were not backed up, e.g. "restricted"
+	Move                  *RepoMove
+	Duration              time.Duration // Wall time of a successful backup
+	Bytes                 int64         // Size of the git mirror after fetching
+	Ballooned             bool          // Duration far above the repository's history
+	Findings              []scan.Finding
+	RefRewrites           []git.RefRewrite
+	ScanError             string
+	GitEngine             string // Engine that cloned/fetched: "gogit" or "cli"
+	GitProtocol           string // Protocol that cloned/fetched: "https" or "ssh"
+	FetchSkipped          bool   // Remote refs matched the mirror, so no fetch ran
+	Phases                phaseTimes
+	Wiki                  bool      // The repository's wiki was mirrored
+	Downloads             int       // Files saved from the Downloads section
+	PackScan              *PackScan // Malware scan of the objects the fetch added
+}
+
+// repoReport converts a result into a run report entry with the given status.
+func (r repoResult) repoReport(status string) RepoReport {
+	entry := RepoReport{
+		Slug:                  r.repo.Slug,
+		Status:                status,
+		Archived:              r.repo.IsArchived,
+		PullRequestsUnchanged: r.stats.PullRequestsUnchanged,
+		IssuesUnchanged:       r.stats.IssuesUnchanged,
+		IssuesSkipped:         r.stats.IssuesSkipped,
+		Move:                  r.stats.Move,
+		DurationSeconds:       r.stats.Duration.Seconds(),
+		Bytes:                 r.stats.Bytes,
+		Ballooned:             r.stats.Ballooned,
+		Findings:              r.stats.Findings,
+		RefRewrites:           r.stats.RefRewrites,
+		ScanError:             r.stats.ScanError,
+		PackScan:              r.stats.PackScan,
+		GitEngine:             r.stats.GitEngine,
+		GitProtocol:           r.stats.GitProtocol,
+		FetchSkipped:          r.stats.FetchSkipped,
+	}
+	if r.repo.Project != nil {
+		entry.Project = r.repo.Project.Key
+	} else {
+		entry.Owner = RepoOwner(r.repo, "")
+	}
+	if r.err != nil {
+		entry.Error = r.err.Error()
+		entry.Code = errcode.Of(r.err)
+	}
+	return entry
 }
 
 // generateJobID creates a short unique job ID using UUIDv7.
@@ -61,6 +121,11 @@ type workerPool struct {
 	jobBuffer int
 	resBuffer int
 	maxRetry  int
+	// queue limits jobs per project (nil without parallelism.max_per_project)
+	queue *projectQueue
+	// gate limits the workers processing jobs at once (nil unless the
+	// throttle adapts the worker count)
+	gate *workerGate
 	// Instrumentation
 	jobsSubmitted atomic.Int64
 	jobsProcessed atomic.Int64
@@ -99,8 +164,17 @@ func newWorkerPool(workers, totalJobs, maxRetry int, logFunc func(string, ...int
 	return p
 }
 
+// limitPerProject makes workers take jobs through a queue that keeps at
+// most limit jobs of one project in flight. Call it before start.
+func (p *workerPool) limitPerProject(limit int) {
+	p.queue = newProjectQueue(limit)
+}
+
 // start launches the worker goroutines.
 func (p *workerPool) start(ctx context.Context, b *Backup) {
+	if p.queue != nil {
+		go p.queue.forward(p.jobs)
+	}
 	for i := 0; i < p.workers; i++ {
 		p.wg.Add(1)
 		workerID := i + 1
@@ -119,22 +193,58 @@ func (p *workerPool) worker(ctx context.Context, b *Backup, workerID int) {
 	p.activeWorkers.Add(1)
 	b.log.Debug("[worker-%d] Started (active workers: %d)", workerID, p.activeWorkers.Load())
 
+	if p.queue != nil {
+		for {
+			if !p.enterGate(ctx) {
+				return
+			}
+			job, ok := p.queue.pop(ctx)
+			if !ok {
+				p.leaveGate()
+				return
+			}
+			p.processJob(ctx, b, workerID, job)
+			p.queue.done(job)
+			p.leaveGate()
+		}
+	}
+
 	for {
+		if !p.enterGate(ctx) {
+			b.log.Debug("[worker-%d] Context cancelled, exiting", workerID)
+			return
+		}
 		select {
 		case <-ctx.Done():
 			// Context cancelled - exit immediately without draining queue
+			p.leaveGate()
 			b.log.Debug("[worker-%d] Context cancelled, exiting", workerID)
 			return
 		case job, ok := <-p.jobs:
 			if !ok {
 				// Channel closed, no more jobs
+				p.leaveGate()
 				return
 			}
 			p.processJob(ctx, b, workerID, job)
+			p.leaveGate()
 		}
 	}
 }
 
+// enterGate waits for the throttle to admit the worker. It returns false
+// when ctx is done first.
+func (p *workerPool) enterGate(ctx context.Context) bool {
+	return p.gate == nil || p.gate.enter(ctx)
+}
+
+// leaveGate lets the throttle admit another worker.
+func (p *workerPool) leaveGate() {
+	if p.gate != nil {
+		p.gate.leave()
+	}
+}
+
 // processJob handles a single backup job with panic recovery and retry support.
 func (p *workerPool) processJob(ctx context.Context, b *Backup, workerID int, job repoJob) {
 	p.jobsProcessed.Add(1)
@@ -143,9 +253,13 @@ func (p *workerPool) processJob(ctx context.Context, b *Backup, workerID int, jo
 	// Add worker ID and job ID to context for logging
 	ctx = api.WithWorkerID(ctx, workerID)
 	ctx = api.WithJobID(ctx, job.jobID)
+	ctx = withAttempt(ctx, job.attempt+1)
+	ctx = auth.WithSet(ctx, b.cfg.CredentialSetFor(repoProjectKey(job.repo), job.repo.Slug))
 
 	// Log prefix for this job
 	prefix := fmt.Sprintf("[%s]", job.jobID)
+	b.jobLog.start(job.jobID)
+	defer b.jobLog.finish(job.jobID)
 
 	var jobErr error
 	var stats repoStats
@@ -153,12 +267,16 @@ func (p *workerPool) processJob(ctx context.Context, b *Backup, workerID int, jo
 	// Recover from panics (e.g., go-git bugs) to prevent crashing the entire backup
 	defer func() {
 		if r := recover(); r != nil {
-			stack := string(debug.Stack())
-			jobErr = fmt.Errorf("panic recovered in worker: %v", r)
+			stack := debug.Stack()
+			jobErr = errcode.Errorf(errcode.PanicRecovered, "panic recovered in worker: %v", r)
+			crashFile := b.recordPanic(ctx, "worker", job.repo, r, stack)
 			// Only log panics if not shutting down
 			if !b.shuttingDown.Load() {
 				b.log.Error("%s PANIC while processing %s (attempt %d): %v", prefix, job.repo.Slug, job.attempt+1, r)
 				b.log.Error("%s Stack trace:\n%s", prefix, stack)
+				if crashFile != "" {
+					b.log.Error("%s Crash report: %s", prefix, crashFile)
+				}
 			}
 		}
 
@@ -167,7 +285,8 @@ func (p *workerPool) processJob(ctx context.Context, b *Backup, workerID int, jo
 			if p.shouldRetry(job, jobErr) {
 				p.requeueJob(b, workerID, job, jobErr)
 			} else {
-				p.sendResult(workerID, repoResult{repo: job.repo, err: jobErr})
+				// Keep partial stats so the report shows e.g. which engine failed
+				p.sendResult(workerID, repoResult{repo: job.repo, stats: stats, err: jobErr})
 			}
 		}
 	}()
@@ -183,6 +302,20 @@ func (p *workerPool) processJob(ctx context.Context, b *Backup, workerID int, jo
 	default:
 	}
 
+	// A retry finishes what the run started; only new repositories wait
+	// for the next run once their budget is used up
+	if job.attempt == 0 {
+		if budget := b.budgets.exhausted(job.repo); budget != "" {
+			b.log.Debug("%s Deferring %s: runtime budget of %s used up", prefix, job.repo.Slug, budget)
+			p.sendResult(workerID, repoResult{repo: job.repo, err: &budgetError{budget: budget}})
+			return
+		}
+	}
+	b.budgets.start(job.jobID, job.repo)
+	defer b.budgets.stop(job.jobID)
+
+	b.opts.Faults.MaybePanic("worker processing " + job.repo.Slug)
+
 	attemptStr := ""
 	if job.attempt > 0 {
 		attemptStr = fmt.Sprintf(" (retry %d/%d)", job.attempt, job.maxRetry)
@@ -250,6 +383,12 @@ func (p *workerPool) requeueJob(b *Backup, workerID int, job repoJob, err error)
 	// Brief delay before retry to avoid hammering on transient errors
 	time.Sleep(time.Duration(job.attempt) * 2 * time.Second)
 
+	if p.queue != nil {
+		p.queue.push(job)
+		p.lastActivity.Store(time.Now().Unix())
+		return
+	}
+
 	// Requeue the job (non-blocking since buffer should have space)
 	select {
 	case p.jobs <- job:
@@ -291,14 +430,14 @@ func (p *workerPool) sendResult(workerID int, result repoResult) {
 			waited := time.Since(startWait)
 			if waited > time.Second {
 				if p.logFunc != nil {
-					p.logFunc("[worker-%d] Results channel unblocked after %s", workerID, waited.Round(time.Millisecond))
+					p.logFunc("[worker-%d] Results channel unblocked after %s", workerID, format.Duration(waited))
 				}
 			}
 			return
 		case <-ticker.C:
 			if p.logFunc != nil {
 				p.logFunc("[worker-%d] STALL: Waiting to send result for %s (results: %d/%d, read: %d)",
-					workerID, time.Since(startWait).Round(time.Second), len(p.results), p.resBuffer, p.resultsRead.Load())
+					workerID, format.Duration(time.Since(startWait)), len(p.results), p.resBuffer, p.resultsRead.Load())
 			}
 		}
 	}
@@ -350,56 +489,288 @@ func (p *workerPool) closeResults() {
 func (b *Backup) backupRepositoryWorker(ctx context.Context, baseDir string, repo *api.Repository) (repoStats, error) {
 	var stats repoStats
 	prefix := api.LogPrefix(ctx)
+	started := time.Now()
+
+	// Follow repositories that moved between projects since the last run
+	// or since the listing, so paths and state stay consistent
+	baseDir, stats.Move = b.reconcileProject(ctx, baseDir, repo)
+	b.adoptLegacyPersonal(ctx, repo)
 
 	// Timestamped directory for this run's data
-	repoDir := baseDir + "/repositories/" + repo.Slug
+	repoDir := baseDir + "/repositories/" + b.repoDirName(repo)
 	// Latest directory for aggregated data
 	latestRepoDir := b.getLatestRepoDir(repo)
 
 	// Save repository metadata to both latest and timestamped directories
 	// Skip if git-only mode (metadata-only and normal mode both save metadata)
+	phaseStart := time.Now()
 	if !b.opts.DryRun && !b.opts.GitOnly {
 		// Save to latest (aggregated)
-		if err := b.saveJSON(latestRepoDir, "repository.json", repo); err != nil {
+		latestRepoFile := latestRepoDir + "/repository.json"
+		existed, before := b.readUpdatedOn(latestRepoFile)
+		if err := b.saveEntity(latestRepoDir, "repository.json", b.rawOrTyped(repo, repo.Raw)); err != nil {
 			return stats, err
 		}
+		b.recordEntity(ChangeKindRepository, repo, repo.Slug, latestRepoFile, existed, before, repo.UpdatedOn)
 		// Save to timestamped directory (this run)
-		if err := b.saveJSON(repoDir, "repository.json", repo); err != nil {
+		if err := b.saveEntity(repoDir, "repository.json", b.rawOrTyped(repo, repo.Raw)); err != nil {
+			return stats, err
+		}
+		if err := b.saveCustom(repo.Slug, latestRepoDir, repoDir); err != nil {
 			return stats, err
 		}
 	}
 
+	if b.cfg.Backup.RawMode && !b.opts.GitOnly {
+		// Raw mode writes API values verbatim and parses only ids/timestamps
+		var err error
+		stats.PullRequests, stats.Issues, err = b.backupRawMetadata(ctx, repoDir, latestRepoDir, repo)
+		if err != nil {
+			return stats, err
+		}
+	}
+	stats.Phases.Metadata = time.Since(phaseStart)
+
 	// Backup pull requests if enabled (skip in git-only mode)
-	if b.cfg.Backup.IncludePRs && !b.opts.GitOnly {
-		prCount, err := b.backupPullRequestsWorker(ctx, repoDir, latestRepoDir, repo)
-		if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+	if b.cfg.Backup.IncludePRs && !b.cfg.Backup.RawMode && !b.opts.GitOnly {
+		phaseStart = time.Now()
+		prCount, prUnchanged, err := b.backupPullRequestsWorker(ctx, repoDir, latestRepoDir, repo)
+		stats.Phases.PullRequests = time.Since(phaseStart)
+		if isStorageFailure(err) {
+			return stats, fmt.Errorf("saving pull requests: %w", err)
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
 			b.log.Error("%sFailed to backup PRs for %s: %v", prefix, repo.Slug, err)
 		}
 		stats.PullRequests = prCount
+		stats.PullRequestsUnchanged = prUnchanged
 	}
 
 	// Backup issues if enabled (skip in git-only mode)
-	if b.cfg.Backup.IncludeIssues && repo.HasIssues && !b.opts.GitOnly {
-		issueCount, err := b.backupIssuesWorker(ctx, repoDir, latestRepoDir, repo)
-		if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+	if b.cfg.Backup.IncludeIssues && repo.HasIssues && !b.cfg.Backup.RawMode && !b.opts.GitOnly {
+		phaseStart = time.Now()
+		issueCount, issueUnchanged, err := b.backupIssuesWorker(ctx, repoDir, latestRepoDir, repo)
+		stats.Phases.Issues = time.Since(phaseStart)
+		if isStorageFailure(err) {
+			return stats, fmt.Errorf("saving issues: %w", err)
+		} else if errors.Is(err, api.ErrIssueTrackerRestricted) && !b.cfg.Backup.StrictIssuePermissions {
+			b.log.Info("%sSkipping issues for %s: issue tracker is restricted (403)", prefix, repo.Slug)
+			stats.IssuesSkipped = IssuesSkippedRestricted
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
 			b.log.Error("%sFailed to backup issues for %s: %v", prefix, repo.Slug, err)
 		}
 		stats.Issues = issueCount
+		stats.IssuesUnchanged = issueUnchanged
+	}
+
+	// Summarize branch permissions and merge checks for auditors
+	if b.cfg.Backup.IncludePolicies && !b.opts.GitOnly && !b.opts.DryRun {
+		phaseStart = time.Now()
+		err := b.savePolicies(ctx, repoDir, latestRepoDir, repo)
+		stats.Phases.Metadata += time.Since(phaseStart)
+		if isStorageFailure(err) {
+			return stats, fmt.Errorf("saving policies: %w", err)
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to backup policies for %s: %v", prefix, repo.Slug, err)
+		}
+	}
+
+	// Save the server-side settings the git mirror does not carry
+	if b.cfg.Backup.IncludeSettings && !b.opts.GitOnly && !b.opts.DryRun {
+		phaseStart = time.Now()
+		err := b.saveSettings(ctx, repoDir, latestRepoDir, repo)
+		stats.Phases.Metadata += time.Since(phaseStart)
+		if isStorageFailure(err) {
+			return stats, fmt.Errorf("saving settings: %w", err)
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to backup settings for %s: %v", prefix, repo.Slug, err)
+		}
+	}
+
+	// Save deploy keys and webhooks so a restore can recreate integrations
+	if b.cfg.Backup.IncludeIntegrations && !b.opts.GitOnly && !b.opts.DryRun {
+		phaseStart = time.Now()
+		err := b.saveIntegrations(ctx, repoDir, latestRepoDir, repo)
+		stats.Phases.Metadata += time.Since(phaseStart)
+		if isStorageFailure(err) {
+			return stats, fmt.Errorf("saving integrations: %w", err)
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to backup integrations for %s: %v", prefix, repo.Slug, err)
+		}
+	}
+
+	// Save release artifacts and other files from the Downloads section
+	if b.cfg.Backup.IncludeDownloads && !b.opts.GitOnly && !b.opts.DryRun {
+		phaseStart = time.Now()
+		count, err := b.backupDownloads(ctx, repoDir, latestRepoDir, repo)
+		stats.Phases.Metadata += time.Since(phaseStart)
+		stats.Downloads = count
+		if isStorageFailure(err) {
+			return stats, fmt.Errorf("saving downloads: %w", err)
+		} else if err != nil && !b.shuttingDown.Load() && !isContextCanceled(err) {
+			b.log.Error("%sFailed to backup downloads for %s: %v", prefix, repo.Slug, err)
+		}
 	}
 
 	// Clone/fetch the git repository (skip in metadata-only mode)
 	if !b.opts.MetadataOnly {
-		if err := b.backupGitRepo(ctx, repoDir, repo); err != nil {
+		phaseStart = time.Now()
+		// Snapshot refs before fetching so the scanner and change feed
+		// only see what this fetch changed
+		var refsBefore map[string]string
+		fullGitPath := b.storage.BasePath() + "/" + b.getLatestGitPath(repo)
+		trackRefs := (b.scanner != nil || b.changes != nil || b.cfg.Git.DetectRewrites) && !b.opts.DryRun
+		if trackRefs {
+			var err error
+			if refsBefore, err = git.ReadRefs(fullGitPath); err != nil {
+				b.log.Debug("%sCould not read refs before fetch for %s: %v", prefix, repo.Slug, err)
+			}
+		}
+
+		// Git goes to the same hosts, so don't clone into a maintenance window
+		if err := b.client.WaitForMaintenance(ctx); err != nil {
 			return stats, err
 		}
+		if b.refsUnchanged(ctx, fullGitPath, repo) {
+			stats.FetchSkipped = true
+		} else {
+			var snapshot string
+			scanPacks := b.packScanner != nil && !b.opts.DryRun
+			if scanPacks {
+				var err error
+				if snapshot, err = snapshotMirror(fullGitPath); err != nil {
+					return stats, err
+				}
+			}
+			b.repointAlternates(ctx, fullGitPath, repo)
+			engine, protocol, err := b.backupGitRepo(ctx, repoDir, repo)
+			stats.GitEngine = engine
+			stats.GitProtocol = protocol
+			if err != nil {
+				discardSnapshot(snapshot)
+				return stats, err
+			}
+			if scanPacks {
+				if stats.PackScan, err = b.scanPacks(ctx, repoDir, fullGitPath, snapshot, repo); err != nil {
+					return stats, err
+				}
+			}
+			b.shareForkObjects(ctx, fullGitPath, repo)
+		}
+
+		if trackRefs {
+			updates, err := b.refUpdates(fullGitPath, refsBefore)
+			if err != nil {
+				b.log.Error("%sFailed to read refs after fetch of %s: %v", prefix, repo.Slug, err)
+				if b.scanner != nil {
+					stats.ScanError = err.Error()
+				}
+			} else {
+				b.recordRefs(repo, updates)
+				if b.cfg.Git.DetectRewrites {
+					stats.RefRewrites = b.detectRewrites(ctx, fullGitPath, repo, updates)
+				}
+				if b.scanner != nil {
+					stats.Findings, stats.ScanError = b.scanRepo(ctx, fullGitPath, repo, updates)
+				}
+			}
+		}
+
+		if !b.opts.DryRun {
+			b.saveIntegrity(ctx, repoDir, latestRepoDir, fullGitPath)
+			b.saveRefs(ctx, repoDir, fullGitPath)
+			b.saveReadme(ctx, repoDir, latestRepoDir, fullGitPath, repo)
+			stats.Bytes = git.DirSize(fullGitPath)
+			if err := b.uploadMirror(ctx, latestRepoDir, fullGitPath); err != nil {
+				return stats, fmt.Errorf("uploading git mirror: %w", err)
+			}
+		}
+
+		// A wiki that fails is logged; the repository itself is backed up
+		if wikiBytes, err := b.backupWiki(ctx, repo); err != nil {
+			if !b.shuttingDown.Load() && !isContextCanceled(err) {
+				b.log.Error("%sFailed to backup wiki for %s: %v", prefix, repo.Slug, err)
+			}
+		} else if wikiBytes > 0 {
+			stats.Wiki = true
+			stats.Bytes += wikiBytes
+		}
+		stats.Phases.Git = time.Since(phaseStart)
+	}
+
+	if err := b.flushRepoWrites(repoDir, latestRepoDir); err != nil {
+		return stats, fmt.Errorf("writing metadata: %w", err)
 	}
 
+	stats.Duration = time.Since(started)
 	return stats, nil
 }
 
-// backupPullRequestsWorker is a worker-friendly version that returns count.
+// refUpdates returns the refs changed since the refsBefore snapshot.
+func (b *Backup) refUpdates(gitPath string, refsBefore map[string]string) ([]git.RefUpdate, error) {
+	refsAfter, err := git.ReadRefs(gitPath)
+	if err != nil {
+		return nil, err
+	}
+	return git.DiffRefs(refsBefore, refsAfter), nil
+}
+
+// detectRewrites reports force-pushes, moved tags, and deleted branches
+// among the refs changed by the last fetch. Errors are logged only.
+func (b *Backup) detectRewrites(ctx context.Context, gitPath string, repo *api.Repository, updates []git.RefUpdate) []git.RefRewrite {
+	prefix := api.LogPrefix(ctx)
+	rewrites, err := git.DetectRewrites(gitPath, updates)
+	if err != nil {
+		b.log.Error("%sFailed to check %s for history rewrites: %v", prefix, repo.Slug, err)
+	}
+	for _, r := range rewrites {
+		b.log.Info("%sHistory rewrite in %s: %s %s (%s -> %s)", prefix, repo.Slug, r.Kind, r.Name, shortHash(r.Old), shortHash(r.New))
+	}
+	return rewrites
+}
+
+// shortHash abbreviates a commit hash for log messages.
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	if hash == "" {
+		return "none"
+	}
+	return hash
+}
+
+// scanRepo runs the content scanner against refs changed by the last fetch.
+// Scan errors are logged and reported but never fail the backup.
+func (b *Backup) scanRepo(ctx context.Context, gitPath string, repo *api.Repository, updates []git.RefUpdate) ([]scan.Finding, string) {
+	prefix := api.LogPrefix(ctx)
+
+	if len(updates) == 0 {
+		return nil, ""
+	}
+
+	if b.progress != nil && !b.shuttingDown.Load() {
+		b.progress.UpdateStatus(fmt.Sprintf("scanning: %s (%d refs)", repo.Slug, len(updates)))
+	}
+	b.log.Debug("%sScanning %d updated refs for %s (%s)", prefix, len(updates), repo.Slug, b.scanner.Name())
+
+	findings, err := b.scanner.Scan(ctx, gitPath, updates)
+	if err != nil {
+		if !isContextCanceled(err) {
+			b.log.Error("%sContent scan failed for %s: %v", prefix, repo.Slug, err)
+		}
+		return findings, err.Error()
+	}
+	if len(findings) > 0 {
+		b.log.Info("%sContent scan: %d findings in %s", prefix, len(findings), repo.Slug)
+	}
+	return findings, ""
+}
+
+// backupPullRequestsWorker is a worker-friendly version that returns the
+// number of PRs saved and, for incremental runs, the number skipped because
+// they were identical to latest/.
 // Saves PRs to both timestamped (repoDir) and latest (latestRepoDir) directories.
-func (b *Backup) backupPullRequestsWorker(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) (int, error) {
+func (b *Backup) backupPullRequestsWorker(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) (int, int, error) {
 	prefix := api.LogPrefix(ctx)
 	var prs []api.PullRequest
 	var err error
@@ -411,22 +782,22 @@ func (b *Backup) backupPullRequestsWorker(ctx context.Context, repoDir, latestRe
 	}
 
 	// Check if we can do incremental backup
-	lastPRUpdated := b.state.GetLastPRUpdated(repo.Slug)
+	lastPRUpdated := b.state.GetLastPRUpdated(b.repoStateKey(repo))
 	if !b.opts.Full && lastPRUpdated != "" {
 		// Incremental: only fetch PRs updated since last backup
-		prs, err = b.client.GetPullRequestsUpdatedSince(ctx, b.cfg.Workspace, repo.Slug, lastPRUpdated)
+		prs, err = b.provider.GetPullRequestsUpdatedSince(ctx, b.cfg.Workspace, repo.Slug, lastPRUpdated)
 		isIncremental = true
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 		if len(prs) > 0 {
 			b.log.Debug("%sFound %d updated pull requests for %s (since %s)", prefix, len(prs), repo.Slug, lastPRUpdated)
 		}
 	} else {
 		// Full backup: fetch all PRs
-		prs, err = b.client.GetAllPullRequests(ctx, b.cfg.Workspace, repo.Slug)
+		prs, err = b.provider.GetAllPullRequests(ctx, b.cfg.Workspace, repo.Slug)
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 		if len(prs) > 0 {
 			b.log.Debug("%sFound %d pull requests for %s", prefix, len(prs), repo.Slug)
@@ -434,18 +805,18 @@ func (b *Backup) backupPullRequestsWorker(ctx context.Context, repoDir, latestRe
 	}
 
 	if len(prs) == 0 {
-		return 0, nil
+		return 0, 0, nil
 	}
 
 	prDir := repoDir + "/pull-requests"
 	latestPRDir := latestRepoDir + "/pull-requests"
-	count := 0
+	count, unchanged := 0, 0
 	var latestUpdated string
 
 	totalPRs := len(prs)
 	for i, pr := range prs {
 		if err := ctx.Err(); err != nil {
-			return count, err
+			return count, unchanged, err
 		}
 
 		// Update progress to show PR processing progress
@@ -463,34 +834,63 @@ func (b *Backup) backupPullRequestsWorker(ctx context.Context, repoDir, latestRe
 			continue
 		}
 
+		// Incremental fetches overlap the previous run at the since
+		// boundary; skip PRs that are identical to the latest copy
+		prFile := fmt.Sprintf("%d.json", pr.ID)
+		latestPRFile := latestPRDir + "/" + prFile
+		if isIncremental && b.unchangedInLatest(latestPRDir, prFile, &pr) {
+			unchanged++
+			continue
+		}
+
 		// Save to timestamped directory
 		if err := b.savePR(ctx, prDir, repo.Slug, &pr); err != nil {
+			if isStorageFailure(err) {
+				return count, unchanged, err
+			}
 			b.log.Error("%sFailed to save PR #%d: %v", prefix, pr.ID, err)
 			continue
 		}
 		// Save to latest directory (aggregated)
+		existed, before := b.readUpdatedOn(latestPRFile)
 		if err := b.savePR(ctx, latestPRDir, repo.Slug, &pr); err != nil {
+			if isStorageFailure(err) {
+				return count, unchanged, err
+			}
 			b.log.Error("%sFailed to save PR #%d to latest: %v", prefix, pr.ID, err)
+		} else {
+			b.recordEntity(ChangeKindPullRequest, repo, strconv.Itoa(pr.ID), latestPRFile, existed, before, pr.UpdatedOn)
+		}
+		if err := b.saveAttachments(ctx, fmt.Sprintf("PR #%d", pr.ID), b.visibleText(pr.Description, "description"),
+			fmt.Sprintf("%s/%d", prDir, pr.ID), fmt.Sprintf("%s/%d", latestPRDir, pr.ID)); err != nil {
+			if isStorageFailure(err) {
+				return count, unchanged, err
+			}
+			b.log.Error("%s%v", prefix, err)
 		}
 		count++
 	}
 
 	// Update state with latest timestamp for next incremental backup
 	if latestUpdated != "" && !b.opts.DryRun {
-		b.state.SetRepoLastPRUpdated(repo.Slug, latestUpdated)
+		b.state.SetRepoLastPRUpdated(b.repoStateKey(repo), latestUpdated)
 	} else if !isIncremental && !b.opts.DryRun && len(prs) == 0 {
 		// First backup with no PRs - set timestamp to now
-		b.state.SetRepoLastPRUpdated(repo.Slug, time.Now().UTC().Format(time.RFC3339))
+		b.state.SetRepoLastPRUpdated(b.repoStateKey(repo), time.Now().UTC().Format(time.RFC3339))
+	}
+
+	if unchanged > 0 {
+		b.log.Debug("%sSkipped %d unchanged pull requests for %s", prefix, unchanged, repo.Slug)
 	}
 
-	return count, nil
+	return count, unchanged, nil
 }
 
 // savePR saves a single PR and its related data.
 func (b *Backup) savePR(ctx context.Context, prDir, repoSlug string, pr *api.PullRequest) error {
 	prefix := api.LogPrefix(ctx)
 	prFile := fmt.Sprintf("%d.json", pr.ID)
-	if err := b.saveJSON(prDir, prFile, pr); err != nil {
+	if err := b.saveEntity(prDir, prFile, pr); err != nil {
 		return err
 	}
 
@@ -501,13 +901,16 @@ func (b *Backup) savePR(ctx context.Context, prDir, repoSlug string, pr *api.Pul
 		if b.progress != nil && !b.shuttingDown.Load() {
 			b.progress.UpdateStatus(fmt.Sprintf("PR #%d comments: %s", pr.ID, repoSlug))
 		}
-		comments, err := b.client.GetPullRequestComments(ctx, b.cfg.Workspace, repoSlug, pr.ID)
+		comments, err := b.provider.GetPullRequestComments(ctx, b.cfg.Workspace, repoSlug, pr.ID)
 		if err != nil {
 			if !b.shuttingDown.Load() && !isContextCanceled(err) {
 				b.log.Error("%sFailed to fetch comments for PR #%d: %v", prefix, pr.ID, err)
 			}
 		} else if len(comments) > 0 {
-			if err := b.saveJSON(prSubDir, "comments.json", comments); err != nil {
+			if err := b.saveEntity(prSubDir, "comments.json", comments); err != nil {
+				if isStorageFailure(err) {
+					return err
+				}
 				b.log.Error("%sFailed to save comments for PR #%d: %v", prefix, pr.ID, err)
 			}
 		}
@@ -518,24 +921,44 @@ func (b *Backup) savePR(ctx context.Context, prDir, repoSlug string, pr *api.Pul
 		if b.progress != nil && !b.shuttingDown.Load() {
 			b.progress.UpdateStatus(fmt.Sprintf("PR #%d activity: %s", pr.ID, repoSlug))
 		}
-		activity, err := b.client.GetPullRequestActivity(ctx, b.cfg.Workspace, repoSlug, pr.ID)
+		activity, err := b.provider.GetPullRequestActivity(ctx, b.cfg.Workspace, repoSlug, pr.ID)
 		if err != nil {
 			if !b.shuttingDown.Load() && !isContextCanceled(err) {
 				b.log.Error("%sFailed to fetch activity for PR #%d: %v", prefix, pr.ID, err)
 			}
 		} else if len(activity) > 0 {
-			if err := b.saveJSON(prSubDir, "activity.json", activity); err != nil {
+			if err := b.saveEntity(prSubDir, "activity.json", activity); err != nil {
+				if isStorageFailure(err) {
+					return err
+				}
 				b.log.Error("%sFailed to save activity for PR #%d: %v", prefix, pr.ID, err)
 			}
 		}
+
+		// Tasks are sign-off checklist items and belong with the review record
+		tasks, err := b.provider.GetPullRequestTasks(ctx, b.cfg.Workspace, repoSlug, pr.ID)
+		if err != nil {
+			if !b.shuttingDown.Load() && !isContextCanceled(err) {
+				b.log.Error("%sFailed to fetch tasks for PR #%d: %v", prefix, pr.ID, err)
+			}
+		} else if len(tasks) > 0 {
+			if err := b.saveEntity(prSubDir, "tasks.json", tasks); err != nil {
+				if isStorageFailure(err) {
+					return err
+				}
+				b.log.Error("%sFailed to save tasks for PR #%d: %v", prefix, pr.ID, err)
+			}
+		}
 	}
 
 	return nil
 }
 
-// backupIssuesWorker is a worker-friendly version that returns count.
+// backupIssuesWorker is a worker-friendly version that returns the number
+// of issues saved and, for incremental runs, the number skipped because they
+// were identical to latest/.
 // Saves issues to both timestamped (repoDir) and latest (latestRepoDir) directories.
-func (b *Backup) backupIssuesWorker(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) (int, error) {
+func (b *Backup) backupIssuesWorker(ctx context.Context, repoDir, latestRepoDir string, repo *api.Repository) (int, int, error) {
 	prefix := api.LogPrefix(ctx)
 	var issues []api.Issue
 	var err error
@@ -547,13 +970,13 @@ func (b *Backup) backupIssuesWorker(ctx context.Context, repoDir, latestRepoDir
 	}
 
 	// Check if we can do incremental backup
-	lastIssueUpdated := b.state.GetLastIssueUpdated(repo.Slug)
+	lastIssueUpdated := b.state.GetLastIssueUpdated(b.repoStateKey(repo))
 	if !b.opts.Full && lastIssueUpdated != "" {
 		// Incremental: only fetch issues updated since last backup
 		issues, err = b.client.GetIssuesUpdatedSince(ctx, b.cfg.Workspace, repo.Slug, lastIssueUpdated)
 		isIncremental = true
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 		if len(issues) > 0 {
 			b.log.Debug("%sFound %d updated issues for %s (since %s)", prefix, len(issues), repo.Slug, lastIssueUpdated)
@@ -562,7 +985,7 @@ func (b *Backup) backupIssuesWorker(ctx context.Context, repoDir, latestRepoDir
 		// Full backup: fetch all issues
 		issues, err = b.client.GetIssues(ctx, b.cfg.Workspace, repo.Slug)
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 		if len(issues) > 0 {
 			b.log.Debug("%sFound %d issues for %s", prefix, len(issues), repo.Slug)
@@ -572,20 +995,20 @@ func (b *Backup) backupIssuesWorker(ctx context.Context, repoDir, latestRepoDir
 	if len(issues) == 0 {
 		// If full backup with no issues, set timestamp to now for future incrementals
 		if !isIncremental && !b.opts.DryRun {
-			b.state.SetRepoLastIssueUpdated(repo.Slug, time.Now().UTC().Format(time.RFC3339))
+			b.state.SetRepoLastIssueUpdated(b.repoStateKey(repo), time.Now().UTC().Format(time.RFC3339))
 		}
-		return 0, nil
+		return 0, 0, nil
 	}
 
 	issueDir := repoDir + "/issues"
 	latestIssueDir := latestRepoDir + "/issues"
-	count := 0
+	count, unchanged := 0, 0
 	var latestUpdated string
 
 	totalIssues := len(issues)
 	for i, issue := range issues {
 		if err := ctx.Err(); err != nil {
-			return count, err
+			return count, unchanged, err
 		}
 
 		// Update progress to show issue processing progress
@@ -603,31 +1026,61 @@ func (b *Backup) backupIssuesWorker(ctx context.Context, repoDir, latestRepoDir
 			continue
 		}
 
+		// Skip issues identical to the latest copy (see backupPullRequestsWorker)
+		issueFile := fmt.Sprintf("%d.json", issue.ID)
+		latestIssueFile := latestIssueDir + "/" + issueFile
+		if isIncremental && b.unchangedInLatest(latestIssueDir, issueFile, &issue) {
+			unchanged++
+			continue
+		}
+
 		// Save to timestamped directory
 		if err := b.saveIssue(ctx, issueDir, repo.Slug, &issue); err != nil {
+			if isStorageFailure(err) {
+				return count, unchanged, err
+			}
 			b.log.Error("%sFailed to save issue #%d: %v", prefix, issue.ID, err)
 			continue
 		}
 		// Save to latest directory (aggregated)
+		existed, before := b.readUpdatedOn(latestIssueFile)
 		if err := b.saveIssue(ctx, latestIssueDir, repo.Slug, &issue); err != nil {
+			if isStorageFailure(err) {
+				return count, unchanged, err
+			}
 			b.log.Error("%sFailed to save issue #%d to latest: %v", prefix, issue.ID, err)
+		} else {
+			b.recordEntity(ChangeKindIssue, repo, strconv.Itoa(issue.ID), latestIssueFile, existed, before, issue.UpdatedOn)
+		}
+		if issue.Content != nil {
+			if err := b.saveAttachments(ctx, fmt.Sprintf("issue #%d", issue.ID), b.visibleText(issue.Content.Raw, "content", "raw"),
+				fmt.Sprintf("%s/%d", issueDir, issue.ID), fmt.Sprintf("%s/%d", latestIssueDir, issue.ID)); err != nil {
+				if isStorageFailure(err) {
+					return count, unchanged, err
+				}
+				b.log.Error("%s%v", prefix, err)
+			}
 		}
 		count++
 	}
 
 	// Update state with latest timestamp for next incremental backup
 	if latestUpdated != "" && !b.opts.DryRun {
-		b.state.SetRepoLastIssueUpdated(repo.Slug, latestUpdated)
+		b.state.SetRepoLastIssueUpdated(b.repoStateKey(repo), latestUpdated)
 	}
 
-	return count, nil
+	if unchanged > 0 {
+		b.log.Debug("%sSkipped %d unchanged issues for %s", prefix, unchanged, repo.Slug)
+	}
+
+	return count, unchanged, nil
 }
 
 // saveIssue saves a single issue and its related data.
 func (b *Backup) saveIssue(ctx context.Context, issueDir, repoSlug string, issue *api.Issue) error {
 	prefix := api.LogPrefix(ctx)
 	issueFile := fmt.Sprintf("%d.json", issue.ID)
-	if err := b.saveJSON(issueDir, issueFile, issue); err != nil {
+	if err := b.saveEntity(issueDir, issueFile, issue); err != nil {
 		return err
 	}
 
@@ -644,7 +1097,10 @@ func (b *Backup) saveIssue(ctx context.Context, issueDir, repoSlug string, issue
 				b.log.Error("%sFailed to fetch comments for issue #%d: %v", prefix, issue.ID, err)
 			}
 		} else if len(comments) > 0 {
-			if err := b.saveJSON(issueSubDir, "comments.json", comments); err != nil {
+			if err := b.saveEntity(issueSubDir, "comments.json", comments); err != nil {
+				if isStorageFailure(err) {
+					return err
+				}
 				b.log.Error("%sFailed to save comments for issue #%d: %v", prefix, issue.ID, err)
 			}
 		}
@@ -653,14 +1109,27 @@ func (b *Backup) saveIssue(ctx context.Context, issueDir, repoSlug string, issue
 	return nil
 }
 
+// isStorageFailure reports whether err is a write that did not survive
+// read-back verification. Other save errors are logged and the repository
+// carries on; these fail it, since the backup target itself is suspect.
+func isStorageFailure(err error) bool {
+	return errors.Is(err, storage.ErrWriteVerification)
+}
+
 // getLatestRepoDir returns the path to the latest copy of a repository.
 // The latest directory contains the aggregated/current state of all backups.
-// Structure: <workspace>/latest/projects/<project_key>/repositories/<repo_slug>/
+// Structure: <workspace>/latest/projects/<project_key>/repositories/<repo_slug>/,
+// or <workspace>/latest/personal/<owner>/repositories/<repo_slug>/ outside projects.
 func (b *Backup) getLatestRepoDir(repo *api.Repository) string {
+	return b.latestRoot() + "/" + b.latestRepoRel(repo)
+}
+
+// latestRepoRel returns a repository's directory relative to latest/.
+func (b *Backup) latestRepoRel(repo *api.Repository) string {
 	if repo.Project != nil && repo.Project.Key != "" {
-		return b.cfg.Workspace + "/latest/projects/" + repo.Project.Key + "/repositories/" + repo.Slug
+		return "projects/" + repo.Project.Key + "/repositories/" + b.repoDirName(repo)
 	}
-	return b.cfg.Workspace + "/latest/personal/repositories/" + repo.Slug
+	return "personal/" + b.repoOwner(repo) + "/repositories/" + b.repoDirName(repo)
 }
 
 // getLatestGitPath returns the shared git repo path in the latest directory.
@@ -668,13 +1137,116 @@ func (b *Backup) getLatestGitPath(repo *api.Repository) string {
 	return b.getLatestRepoDir(repo) + "/repo.git"
 }
 
-func (b *Backup) backupGitRepo(ctx context.Context, repoDir string, repo *api.Repository) error {
+// Git engines and protocols recorded in the run report.
+const (
+	gitEngineGoGit = "gogit"
+	gitEngineCLI   = "cli"
+
+	gitProtocolHTTPS = "https"
+	gitProtocolSSH   = "ssh"
+)
+
+// backupGitRepo clones or fetches a repository's mirror over HTTPS and, when
+// git.ssh_fallback is enabled, retries over SSH after auth or proxy failures.
+// Rejected credentials are refreshed and retried once over HTTPS first. It
+// returns the engine and protocol that last ran.
+func (b *Backup) backupGitRepo(ctx context.Context, repoDir string, repo *api.Repository) (string, string, error) {
 	prefix := api.LogPrefix(ctx)
-	cloneURL := repo.CloneURL()
+
+	fullGitPath := b.storage.BasePath() + "/" + b.getLatestGitPath(repo)
+	isClone := !isValidGitRepo(fullGitPath)
+	used, _ := b.gitCredentials(ctx)
+
+	// Show how much of the clone or fetch has arrived, so a no-op fetch
+	// and a large clone can be told apart
+	if b.progress != nil && b.progress.interactive {
+		ctx = git.WithProgressFunc(ctx, func(stage string, current, total int64) {
+			b.progress.Transfer(repo.Slug, stage, current, total)
+		})
+		defer b.progress.EndTransfer(repo.Slug)
+	}
+
+	engine, err := b.backupGitRepoHTTPS(ctx, repoDir, repo)
+	if engine == "" {
+		// Nothing was attempted (dry run or no clone URL)
+		return "", "", err
+	}
+
+	// A short-lived token may have expired mid-run; refresh it once and
+	// retry rather than failing this and every later repository
+	if err != nil && isCredentialError(err) && b.refreshGitCredentials(ctx, used) {
+		b.log.Info("%sGit credentials rejected for %s, retrying with refreshed credentials", prefix, repo.Slug)
+		if isClone {
+			_ = os.RemoveAll(fullGitPath)
+		}
+		engine, err = b.backupGitRepoHTTPS(ctx, repoDir, repo)
+	}
+	if err == nil {
+		return engine, gitProtocolHTTPS, nil
+	}
+
+	sshURL := repo.SSHCloneURL()
+	if !b.cfg.Git.SSHFallback || b.shellGitClient == nil || sshURL == "" ||
+		b.cfg.Git.EngineFor(repo.Slug) == gitEngineGoGit || !isAuthOrProxyError(err) {
+		return engine, gitProtocolHTTPS, err
+	}
+
+	b.log.Info("%sHTTPS failed for %s (%v), retrying over SSH", prefix, repo.Slug, err)
+	if sshErr := b.backupGitRepoSSH(ctx, sshURL, repo); sshErr != nil {
+		return gitEngineCLI, gitProtocolSSH, fmt.Errorf("%w (SSH retry also failed: %v)", err, sshErr)
+	}
+
+	b.log.Debug("%sSSH retry succeeded for %s", prefix, repo.Slug)
+	return gitEngineCLI, gitProtocolSSH, nil
+}
+
+// backupGitRepoSSH clones or fetches a mirror from its SSH URL with the git CLI.
+func (b *Backup) backupGitRepoSSH(ctx context.Context, sshURL string, repo *api.Repository) error {
+	fullGitPath := b.storage.BasePath() + "/" + b.getLatestGitPath(repo)
+
+	timeout := time.Duration(b.cfg.Backup.GitTimeoutMinutes) * time.Minute
+	if timeout <= 0 {
+		timeout = 30 * time.Minute
+	}
+	gitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if !isValidGitRepo(fullGitPath) {
+		// Clean up any partial HTTPS attempt before cloning
+		_ = os.RemoveAll(fullGitPath)
+		if err := b.shellGitClient.CloneMirror(gitCtx, sshURL, fullGitPath); err != nil {
+			return err
+		}
+		return b.pointOriginAtHTTPS(fullGitPath, repo)
+	}
+	return b.shellGitClient.FetchURL(gitCtx, fullGitPath, sshURL)
+}
+
+// pointOriginAtHTTPS sets a mirror's origin to the repository's HTTPS
+// clone URL. SSH only stands in for single clones and fetches: later runs
+// start over HTTPS and fall back to SSH by URL again if they need to.
+func (b *Backup) pointOriginAtHTTPS(gitPath string, repo *api.Repository) error {
+	cloneURL := b.cfg.Git.CloneURL(repo.CloneURL())
 	if cloneURL == "" {
-		b.log.Debug("%sNo HTTPS clone URL found for %s, skipping git clone", prefix, repo.Slug)
 		return nil
 	}
+	if err := git.SetOriginURL(gitPath, cloneURL); err != nil {
+		return fmt.Errorf("pointing origin at HTTPS: %w", err)
+	}
+	return nil
+}
+
+// backupGitRepoHTTPS clones or fetches a repository's mirror and returns the git
+// engine that did the work. The engine is chosen by git.engine and its
+// per-pattern overrides: "auto" tries go-git and falls back to the git CLI
+// on known go-git failures, "gogit" and "cli" use only that engine.
+func (b *Backup) backupGitRepoHTTPS(ctx context.Context, repoDir string, repo *api.Repository) (string, error) {
+	prefix := api.LogPrefix(ctx)
+	cloneURL := b.cfg.Git.CloneURL(repo.CloneURL())
+	if cloneURL == "" {
+		b.log.Debug("%sNo HTTPS clone URL found for %s, skipping git clone", prefix, repo.Slug)
+		return "", nil
+	}
 
 	// Use latest directory for git repos (shared across all backup runs)
 	// This allows repos to be updated incrementally instead of re-cloned
@@ -682,16 +1254,17 @@ func (b *Backup) backupGitRepo(ctx context.Context, repoDir string, repo *api.Re
 
 	if b.opts.DryRun {
 		b.log.Info("%s[DRY RUN] Would clone %s", prefix, repo.Slug)
-		return nil
+		return "", nil
 	}
 
 	// Log git credentials being used (mask password)
-	gitUser, gitPass := b.cfg.GetGitCredentials()
-	maskedPass := "***"
-	if len(gitPass) > 4 {
-		maskedPass = gitPass[:4] + "***"
+	if gitCreds, err := b.gitCredentials(ctx); err == nil {
+		maskedPass := "***"
+		if len(gitCreds.Password) > 4 {
+			maskedPass = gitCreds.Password[:4] + "***"
+		}
+		b.log.Debug("%sGit auth: user=%q, pass=%s, method=%s", prefix, gitCreds.Username, maskedPass, b.cfg.Auth.Method)
 	}
-	b.log.Debug("%sGit auth: user=%q, pass=%s, method=%s", prefix, gitUser, maskedPass, b.cfg.Auth.Method)
 
 	fullGitPath := b.storage.BasePath() + "/" + latestGitDir
 
@@ -700,20 +1273,41 @@ func (b *Backup) backupGitRepo(ctx context.Context, repoDir string, repo *api.Re
 	if timeout <= 0 {
 		timeout = 30 * time.Minute // Default to 30 minutes
 	}
-	gitCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
 
-	// Try go-git first, fall back to shell git if it fails
 	// Check for HEAD file to verify it's a valid git repo (not just an empty directory)
 	isClone := !isValidGitRepo(fullGitPath)
 
+	engine := b.cfg.Git.EngineFor(repo.Slug)
+	if err := b.opts.Faults.GitError(repo.Slug); err != nil {
+		return engine, err
+	}
+	if engine == gitEngineCLI {
+		if b.shellGitClient == nil {
+			return gitEngineCLI, fmt.Errorf("git engine \"cli\" selected for %s but git CLI is not available", repo.Slug)
+		}
+		if err := b.runShellGit(ctx, timeout, cloneURL, fullGitPath, repo, isClone, nil); err != nil {
+			return gitEngineCLI, err
+		}
+		return gitEngineCLI, nil
+	}
+
+	gitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	// Wrap go-git calls in panic recovery so we can fall back to shell git
 	var goGitErr error
 	func() {
 		defer func() {
 			if r := recover(); r != nil {
-				goGitErr = fmt.Errorf("go-git panic: %v", r)
+				goGitErr = errcode.Errorf(errcode.PanicRecovered, "go-git panic: %v", r)
+				where := "go-git fetch"
+				if isClone {
+					where = "go-git clone"
+				}
 				b.log.Debug("%sgo-git panicked: %v", prefix, r)
+				if crashFile := b.recordPanic(ctx, where, repo, r, debug.Stack()); crashFile != "" {
+					b.log.Info("%sgo-git panicked on %s; crash report: %s", prefix, repo.Slug, crashFile)
+				}
 			}
 		}()
 		if isClone {
@@ -727,58 +1321,124 @@ func (b *Backup) backupGitRepo(ctx context.Context, repoDir string, repo *api.Re
 
 	// If go-git succeeded, we're done
 	if goGitErr == nil {
-		return nil
+		return gitEngineGoGit, nil
 	}
 
 	// Check for timeout
 	if gitCtx.Err() == context.DeadlineExceeded {
 		if isClone {
-			return fmt.Errorf("git clone timed out after %d minutes", b.cfg.Backup.GitTimeoutMinutes)
+			return gitEngineGoGit, errcode.Errorf(errcode.GitTimeout, "git clone timed out after %d minutes", b.cfg.Backup.GitTimeoutMinutes)
 		}
-		return fmt.Errorf("git fetch timed out after %d minutes", b.cfg.Backup.GitTimeoutMinutes)
+		return gitEngineGoGit, errcode.Errorf(errcode.GitTimeout, "git fetch timed out after %d minutes", b.cfg.Backup.GitTimeoutMinutes)
+	}
+
+	// The gogit engine never falls back
+	if engine == gitEngineGoGit {
+		return gitEngineGoGit, goGitErr
 	}
 
 	// If shell git is not available, return the go-git error
 	if b.shellGitClient == nil {
-		return goGitErr
+		return gitEngineGoGit, goGitErr
 	}
 
 	// Check if this is a go-git specific error that shell git might handle better
 	if !isGoGitRetryableError(goGitErr) {
-		return goGitErr
+		return gitEngineGoGit, goGitErr
 	}
 
 	// Try shell git as fallback
 	b.log.Debug("%sgo-git failed (%v), retrying with git CLI", prefix, goGitErr)
 
-	// Reset context timeout for retry
-	gitCtx2, cancel2 := context.WithTimeout(ctx, timeout)
-	defer cancel2()
-
 	if isClone {
 		// Clean up failed go-git attempt
 		_ = os.RemoveAll(fullGitPath)
-		b.log.Debug("%sCloning %s (mirror, git CLI fallback)", prefix, repo.Slug)
-		if err := b.shellGitClient.CloneMirror(gitCtx2, cloneURL, fullGitPath); err != nil {
-			if gitCtx2.Err() == context.DeadlineExceeded {
-				return fmt.Errorf("git clone timed out after %d minutes (CLI fallback)", b.cfg.Backup.GitTimeoutMinutes)
-			}
-			return fmt.Errorf("git CLI fallback also failed: %w (original go-git error: %v)", err, goGitErr)
-		}
-	} else {
-		b.log.Debug("%sFetching updates for %s (git CLI fallback)", prefix, repo.Slug)
-		if err := b.shellGitClient.Fetch(gitCtx2, fullGitPath); err != nil {
-			if gitCtx2.Err() == context.DeadlineExceeded {
-				return fmt.Errorf("git fetch timed out after %d minutes (CLI fallback)", b.cfg.Backup.GitTimeoutMinutes)
+	}
+	if err := b.runShellGit(ctx, timeout, cloneURL, fullGitPath, repo, isClone, goGitErr); err != nil {
+		return gitEngineCLI, err
+	}
+
+	b.log.Debug("%sgit CLI fallback succeeded for %s", prefix, repo.Slug)
+	return gitEngineCLI, nil
+}
+
+// runShellGit clones or fetches with the git CLI under its own timeout.
+// goGitErr is the go-git failure being retried, or nil when the CLI is the
+// selected engine.
+func (b *Backup) runShellGit(ctx context.Context, timeout time.Duration, cloneURL, fullGitPath string, repo *api.Repository, isClone bool, goGitErr error) error {
+	prefix := api.LogPrefix(ctx)
+	gitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	suffix := ""
+	if goGitErr != nil {
+		suffix = " fallback"
+	}
+
+	if isClone {
+		b.log.Debug("%sCloning %s (mirror, git CLI%s)", prefix, repo.Slug, suffix)
+		if err := b.shellGitClient.CloneMirror(gitCtx, cloneURL, fullGitPath); err != nil {
+			if gitCtx.Err() == context.DeadlineExceeded {
+				return errcode.Errorf(errcode.GitTimeout, "git clone timed out after %d minutes (CLI%s)", b.cfg.Backup.GitTimeoutMinutes, suffix)
 			}
-			return fmt.Errorf("git CLI fallback also failed: %w (original go-git error: %v)", err, goGitErr)
+			return wrapFallbackErr(err, goGitErr)
 		}
+		return nil
 	}
 
-	b.log.Debug("%sgit CLI fallback succeeded for %s", prefix, repo.Slug)
+	b.log.Debug("%sFetching updates for %s (git CLI%s)", prefix, repo.Slug, suffix)
+	if err := b.shellGitClient.Fetch(gitCtx, fullGitPath); err != nil {
+		if gitCtx.Err() == context.DeadlineExceeded {
+			return errcode.Errorf(errcode.GitTimeout, "git fetch timed out after %d minutes (CLI%s)", b.cfg.Backup.GitTimeoutMinutes, suffix)
+		}
+		return wrapFallbackErr(err, goGitErr)
+	}
 	return nil
 }
 
+// wrapFallbackErr adds the original go-git error to a CLI fallback failure.
+func wrapFallbackErr(err, goGitErr error) error {
+	if goGitErr == nil {
+		return err
+	}
+	return fmt.Errorf("git CLI fallback also failed: %w (original go-git error: %v)", err, goGitErr)
+}
+
+// isAuthOrProxyError checks if a clone/fetch error looks like an HTTPS
+// authentication or proxy problem that another protocol might avoid. It
+// matches the messages go-git and the git CLI give for these failures
+// rather than bare status codes, which also turn up in unrelated errors.
+func isAuthOrProxyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := strings.ToLower(err.Error())
+	patterns := []string{
+		// go-git
+		"authentication required",
+		"authorization failed",
+		"unexpected client error: 403",
+		"unexpected client error: 407",
+		"proxyconnect",
+		// git CLI
+		"authentication failed for",
+		"could not read username",
+		"invalid credentials",
+		"the requested url returned error: 401",
+		"the requested url returned error: 403",
+		"the requested url returned error: 407",
+		"from proxy after connect",
+		"proxy connect aborted",
+		"could not resolve proxy",
+	}
+	for _, pattern := range patterns {
+		if strings.Contains(errStr, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
 // isGoGitRetryableError checks if an error from go-git is likely to be fixed by using shell git.
 func isGoGitRetryableError(err error) bool {
 	if err == nil {
@@ -801,4 +1461,3 @@ func isGoGitRetryableError(err error) bool {
 	}
 	return false
 }
-
@@ -3,7 +3,14 @@ package backup
 import (
 	"context"
 	"errors"
+	"os/exec"
+	"path/filepath"
 	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+	"github.com/andy-wilson/bb-backup/internal/storage"
 )
 
 func TestGenerateJobID(t *testing.T) {
@@ -128,6 +135,72 @@ func TestIsGoGitRetryableError(t *testing.T) {
 	}
 }
 
+func TestIsAuthOrProxyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil error", nil, false},
+		{"timeout", errors.New("git clone timed out after 30 minutes"), false},
+		{"go-git auth", errors.New("authentication required"), true},
+		{"cli auth", errors.New("git clone failed: exit status 128: fatal: Authentication failed for 'https://bitbucket.org/ws/repo.git/'"), true},
+		{"prompt disabled", errors.New("fatal: could not read Username for 'https://bitbucket.org': terminal prompts disabled"), true},
+		{"forbidden", errors.New("unexpected client error: 403 Forbidden"), true},
+		{"go-git forbidden", errors.New("authorization failed"), true},
+		{"cli forbidden", errors.New("fatal: unable to access 'https://bitbucket.org/ws/repo.git/': The requested URL returned error: 403"), true},
+		{"proxy", errors.New("Received HTTP code 407 from proxy after CONNECT"), true},
+		{"go-git proxy", errors.New(`Get "https://bitbucket.org/ws/repo.git/info/refs": proxyconnect tcp: dial tcp 10.0.0.1:3128: connection refused`), true},
+		{"packfile", errors.New("packfile is nil"), false},
+		{"status digits in a path", errors.New("git fetch failed: exit status 128: fatal: bad object refs/heads/release-4031"), false},
+		{"proxy in a repository name", errors.New("repository proxy-config not found"), false},
+		{"authorization in a message", errors.New("fetching metadata: pull request 401 has no authorization section"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAuthOrProxyError(tt.err); got != tt.want {
+				t.Errorf("isAuthOrProxyError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupGitRepoSSH_PointsOriginAtHTTPS(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	// A local repository stands in for the SSH remote
+	sshRemote := filepath.Join(t.TempDir(), "src")
+	for _, args := range [][]string{
+		{"init", "-q", sshRemote},
+		{"-C", sshRemote, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.Workspace = "ws"
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Backup{cfg: cfg, storage: store, log: &defaultLogger{quiet: true}, shellGitClient: git.NewShellGitClient()}
+	const httpsURL = "https://bitbucket.org/ws/repo.git"
+	repo := &api.Repository{Slug: "repo", Links: api.Links{Clone: []api.Link{{Name: "https", Href: httpsURL}}}}
+
+	if err := b.backupGitRepoSSH(context.Background(), sshRemote, repo); err != nil {
+		t.Fatalf("backupGitRepoSSH() error = %v", err)
+	}
+	// Later runs start over HTTPS with the configured credentials
+	mirror := filepath.Join(store.BasePath(), b.getLatestGitPath(repo))
+	if origin, err := git.OriginURL(mirror); err != nil || origin != httpsURL {
+		t.Errorf("origin = %q, %v; want %q", origin, err, httpsURL)
+	}
+}
+
 func TestWorkerPool_Stats(t *testing.T) {
 	pool := newWorkerPool(2, 5, 3, nil)
 
@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/storage"
+)
+
+// bufferWrites routes storage writes through a coalescing buffer for
+// high-latency storage (storage.high_latency). Writes are held and
+// written out together on the flush interval, at the end of each
+// repository, and at the end of the run.
+func (b *Backup) bufferWrites() {
+	interval := time.Duration(b.cfg.Storage.FlushIntervalSeconds) * time.Second
+	b.writes = storage.NewBuffered(b.storage, interval)
+	b.storage = b.writes
+	b.log.Debug("High-latency storage: coalescing writes, flushing every %s", interval)
+}
+
+// flushWrites writes out everything pending. Failures are logged; the
+// repositories that own the files have already finished.
+func (b *Backup) flushWrites() {
+	if b.writes == nil {
+		return
+	}
+	pending := b.writes.Pending()
+	if err := b.writes.Flush(); err != nil {
+		b.log.Error("Failed to write buffered files: %v", err)
+		return
+	}
+	if pending > 0 {
+		b.log.Debug("Flushed %d buffered writes", pending)
+	}
+}
+
+// flushRepoWrites writes out a repository's pending files so a write that
+// fails, now or in an earlier background flush, fails the repository.
+func (b *Backup) flushRepoWrites(repoDir, latestRepoDir string) error {
+	if b.writes == nil {
+		return nil
+	}
+	return b.writes.Flush(repoDir, latestRepoDir)
+}
+
+// closeWrites stops background flushing and writes out what remains.
+func (b *Backup) closeWrites() {
+	if b.writes == nil {
+		return
+	}
+	if err := b.writes.Close(); err != nil {
+		b.log.Error("Failed to write buffered files: %v", err)
+	}
+}
+
+// saveState writes the state file. With buffered writes it is queued like
+// any other file, so checkpoints between flushes collapse into one write,
+// and with s3 storage it is copied to the bucket like any other file.
+// While latest.tmp is unpublished nothing is written: the state would
+// advance pull request and issue markers past entities that only the
+// staging tree holds, and the next run discards that tree.
+func (b *Backup) saveState(statePath string) error {
+	if b.stagingLatest {
+		b.log.Debug("State: not saved while %s is unpublished", latestStaging)
+		return nil
+	}
+	if b.writes == nil && b.cfg.Storage.Type != "s3" {
+		return b.state.Save(statePath)
+	}
+	rel, ok := storageRel(b.storage, statePath)
+	if !ok {
+		// Outside the storage base; write it directly
+		return b.state.Save(statePath)
+	}
+	data, err := b.state.Encode()
+	if err != nil {
+		return err
+	}
+	return b.storage.Write(rel, data)
+}
+
+// storageRel returns path relative to the storage base, or false if it is
+// outside it.
+func storageRel(store storage.Storage, path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(store.BasePath(), abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
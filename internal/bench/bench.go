@@ -0,0 +1,292 @@
+// Package bench measures clone throughput and API latency for a workspace
+// and recommends parallelism and rate-limit settings. Results are saved in
+// the storage directory so backups with parallelism.auto_tune can use them.
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// FileName is the benchmark results file kept in the storage directory.
+const FileName = ".bb-backup-bench.json"
+
+// goodEnough is the fraction of the best throughput at which a smaller
+// worker count is preferred: extra workers that add under 10% are not worth
+// the extra load on Bitbucket and the local disk.
+const goodEnough = 0.9
+
+// Cloner mirrors repoURL into destPath using one git engine.
+type Cloner func(ctx context.Context, repoURL, destPath string) error
+
+// Options controls a benchmark run.
+type Options struct {
+	Sample  int               // Number of repositories to clone per trial
+	Workers []int             // Worker counts to try
+	Cloners map[string]Cloner // Git engines to try, by name
+	WorkDir string            // Scratch directory for clones (removed per trial)
+	Logf    func(msg string, args ...interface{})
+}
+
+// Result is the outcome of a benchmark run.
+type Result struct {
+	Workspace      string         `json:"workspace"`
+	RanAt          string         `json:"ran_at"`
+	Sample         []string       `json:"sample"`
+	API            APIStats       `json:"api"`
+	Trials         []Trial        `json:"trials"`
+	Recommendation Recommendation `json:"recommendation"`
+}
+
+// APIStats summarizes API request latency.
+type APIStats struct {
+	Requests         int     `json:"requests"`
+	AvgMillis        float64 `json:"avg_ms"`
+	P95Millis        float64 `json:"p95_ms"`
+	RateLimitCeiling int     `json:"rate_limit_ceiling,omitempty"` // From X-RateLimit-Limit
+}
+
+// Trial records cloning the sample with one engine and worker count.
+type Trial struct {
+	Engine      string  `json:"engine"`
+	Workers     int     `json:"workers"`
+	Seconds     float64 `json:"seconds"`
+	Bytes       int64   `json:"bytes"`
+	MBPerSec    float64 `json:"mb_per_sec"`
+	ReposPerMin float64 `json:"repos_per_min"`
+	Failures    int     `json:"failures"`
+}
+
+// Recommendation holds suggested configuration values.
+type Recommendation struct {
+	GitWorkers      int    `json:"git_workers"`
+	GitEngine       string `json:"git_engine"`
+	RequestsPerHour int    `json:"requests_per_hour"`
+	BurstSize       int    `json:"burst_size"`
+}
+
+// Run benchmarks the given repositories. The client is used to measure API
+// latency; each trial clones the whole sample into a fresh scratch directory.
+func Run(ctx context.Context, client *api.Client, workspace string, repos []api.Repository, opts Options) (*Result, error) {
+	sample := PickSample(repos, opts.Sample)
+	if len(sample) == 0 {
+		return nil, fmt.Errorf("no repositories with an HTTPS clone URL to benchmark")
+	}
+
+	result := &Result{
+		Workspace: workspace,
+		RanAt:     time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, r := range sample {
+		result.Sample = append(result.Sample, r.Slug)
+	}
+
+	logf := opts.Logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	logf("Measuring API latency (%d requests)...", len(sample))
+	apiStats, err := measureAPI(ctx, client, workspace, sample)
+	if err != nil {
+		return nil, err
+	}
+	result.API = apiStats
+
+	engines := make([]string, 0, len(opts.Cloners))
+	for name := range opts.Cloners {
+		engines = append(engines, name)
+	}
+	sort.Strings(engines)
+
+	for _, engine := range engines {
+		for _, workers := range opts.Workers {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			logf("Cloning %d repos with %s, %d workers...", len(sample), engine, workers)
+			dir := filepath.Join(opts.WorkDir, fmt.Sprintf("%s-%d", engine, workers))
+			trial := runTrial(ctx, opts.Cloners[engine], sample, workers, dir)
+			trial.Engine = engine
+			_ = os.RemoveAll(dir)
+			result.Trials = append(result.Trials, trial)
+		}
+	}
+
+	result.Recommendation = Recommend(result.Trials, result.API)
+	return result, nil
+}
+
+// PickSample returns up to n repositories spread evenly across the size
+// range, so the sample reflects both small and large repositories.
+func PickSample(repos []api.Repository, n int) []api.Repository {
+	var candidates []api.Repository
+	for _, r := range repos {
+		if r.CloneURL() != "" {
+			candidates = append(candidates, r)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Size != candidates[j].Size {
+			return candidates[i].Size < candidates[j].Size
+		}
+		return candidates[i].Slug < candidates[j].Slug
+	})
+	if n <= 0 || n >= len(candidates) {
+		return candidates
+	}
+	if n == 1 {
+		return candidates[len(candidates)/2 : len(candidates)/2+1]
+	}
+
+	sample := make([]api.Repository, 0, n)
+	step := float64(len(candidates)-1) / float64(n-1)
+	for i := 0; i < n; i++ {
+		sample = append(sample, candidates[int(math.Round(float64(i)*step))])
+	}
+	return sample
+}
+
+func measureAPI(ctx context.Context, client *api.Client, workspace string, sample []api.Repository) (APIStats, error) {
+	latencies := make([]float64, 0, len(sample))
+	for _, r := range sample {
+		start := time.Now()
+		if _, err := client.GetRepository(ctx, workspace, r.Slug); err != nil {
+			return APIStats{}, fmt.Errorf("measuring API latency: %w", err)
+		}
+		latencies = append(latencies, float64(time.Since(start).Microseconds())/1000)
+	}
+
+	stats := latencyStats(latencies)
+	stats.RateLimitCeiling = client.RateLimitCeiling()
+	return stats, nil
+}
+
+func latencyStats(latencies []float64) APIStats {
+	stats := APIStats{Requests: len(latencies)}
+	if len(latencies) == 0 {
+		return stats
+	}
+	sorted := append([]float64(nil), latencies...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, l := range sorted {
+		sum += l
+	}
+	stats.AvgMillis = sum / float64(len(sorted))
+	stats.P95Millis = sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	return stats
+}
+
+func runTrial(ctx context.Context, clone Cloner, sample []api.Repository, workers int, dir string) Trial {
+	if workers < 1 {
+		workers = 1
+	}
+	trial := Trial{Workers: workers}
+
+	jobs := make(chan api.Repository)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				dest := filepath.Join(dir, r.Slug+".git")
+				err := clone(ctx, r.CloneURL(), dest)
+				size := git.DirSize(dest)
+				mu.Lock()
+				trial.Bytes += size
+				if err != nil {
+					trial.Failures++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, r := range sample {
+		jobs <- r
+	}
+	close(jobs)
+	wg.Wait()
+
+	elapsed := time.Since(start).Seconds()
+	trial.Seconds = elapsed
+	if elapsed > 0 {
+		trial.MBPerSec = float64(trial.Bytes) / (1024 * 1024) / elapsed
+		trial.ReposPerMin = float64(len(sample)-trial.Failures) / elapsed * 60
+	}
+	return trial
+}
+
+// Recommend picks the engine with the best throughput and, for it, the
+// smallest worker count within 10% of its best. Rate limits target 90% of
+// the ceiling the API reported, or the default of 900/hour.
+func Recommend(trials []Trial, apiStats APIStats) Recommendation {
+	rec := Recommendation{RequestsPerHour: 900}
+	if apiStats.RateLimitCeiling > 0 {
+		rec.RequestsPerHour = apiStats.RateLimitCeiling * 9 / 10
+	}
+
+	var best Trial
+	for _, t := range trials {
+		if t.Failures == 0 && t.MBPerSec > best.MBPerSec {
+			best = t
+		}
+	}
+	if best.Engine == "" {
+		return rec
+	}
+	rec.GitEngine = best.Engine
+	rec.GitWorkers = best.Workers
+	for _, t := range trials {
+		if t.Engine == best.Engine && t.Failures == 0 &&
+			t.MBPerSec >= goodEnough*best.MBPerSec && t.Workers < rec.GitWorkers {
+			rec.GitWorkers = t.Workers
+		}
+	}
+
+	// Let every worker issue a request without queueing at startup
+	rec.BurstSize = rec.GitWorkers * 2
+	if rec.BurstSize < 10 {
+		rec.BurstSize = 10
+	}
+	return rec
+}
+
+// Save writes a result to path.
+func Save(path string, result *Result) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding benchmark results: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("writing benchmark results: %w", err)
+	}
+	return nil
+}
+
+// Load reads a result saved by Save.
+func Load(path string) (*Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading benchmark results: %w", err)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("parsing benchmark results: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+)
+
+func testRepo(slug string, size int64) api.Repository {
+	return api.Repository{
+		Slug: slug,
+		Size: size,
+		Links: api.Links{Clone: []api.Link{
+			{Name: "https", Href: "https://bitbucket.org/ws/" + slug + ".git"},
+		}},
+	}
+}
+
+func TestPickSample(t *testing.T) {
+	var repos []api.Repository
+	for i, slug := range []string{"e", "a", "c", "b", "d"} {
+		repos = append(repos, testRepo(slug, int64((i+1)*100)))
+	}
+	repos = append(repos, api.Repository{Slug: "no-url", Size: 1})
+
+	sample := PickSample(repos, 3)
+	if len(sample) != 3 {
+		t.Fatalf("PickSample() returned %d repos, want 3", len(sample))
+	}
+	// Sizes: e=100 a=200 c=300 b=400 d=500 -> smallest, median, largest
+	want := []string{"e", "c", "d"}
+	for i, r := range sample {
+		if r.Slug != want[i] {
+			t.Errorf("sample[%d] = %s, want %s", i, r.Slug, want[i])
+		}
+	}
+
+	if got := PickSample(repos, 0); len(got) != 5 {
+		t.Errorf("PickSample(0) = %d repos, want all 5 cloneable", len(got))
+	}
+	if got := PickSample(repos, 1); len(got) != 1 || got[0].Slug != "c" {
+		t.Errorf("PickSample(1) = %v, want median repo c", got)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	stats := latencyStats([]float64{10, 20, 30, 40, 100})
+	if stats.Requests != 5 || stats.AvgMillis != 40 || stats.P95Millis != 100 {
+		t.Errorf("latencyStats() = %+v", stats)
+	}
+	if empty := latencyStats(nil); empty.Requests != 0 || empty.AvgMillis != 0 {
+		t.Errorf("latencyStats(nil) = %+v", empty)
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	trials := []Trial{
+		{Engine: "cli", Workers: 2, MBPerSec: 10},
+		{Engine: "cli", Workers: 4, MBPerSec: 19},
+		{Engine: "cli", Workers: 8, MBPerSec: 20},
+		{Engine: "gogit", Workers: 8, MBPerSec: 30, Failures: 1},
+		{Engine: "gogit", Workers: 4, MBPerSec: 15},
+	}
+
+	rec := Recommend(trials, APIStats{RateLimitCeiling: 1000})
+	if rec.GitEngine != "cli" {
+		t.Errorf("GitEngine = %q, want cli (failed trials are ignored)", rec.GitEngine)
+	}
+	if rec.GitWorkers != 4 {
+		t.Errorf("GitWorkers = %d, want 4 (within 10%% of best)", rec.GitWorkers)
+	}
+	if rec.RequestsPerHour != 900 {
+		t.Errorf("RequestsPerHour = %d, want 900", rec.RequestsPerHour)
+	}
+	if rec.BurstSize != 10 {
+		t.Errorf("BurstSize = %d, want 10", rec.BurstSize)
+	}
+
+	if none := Recommend(nil, APIStats{}); none.GitWorkers != 0 || none.RequestsPerHour != 900 {
+		t.Errorf("Recommend(no trials) = %+v", none)
+	}
+}
+
+func TestRunTrial(t *testing.T) {
+	dir := t.TempDir()
+	clone := func(_ context.Context, _ string, dest string) error {
+		if filepath.Base(dest) == "bad.git" {
+			return errors.New("clone failed")
+		}
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dest, "HEAD"), []byte("0123456789"), 0644)
+	}
+
+	sample := []api.Repository{testRepo("one", 1), testRepo("two", 2), testRepo("bad", 3)}
+	trial := runTrial(context.Background(), clone, sample, 2, dir)
+
+	if trial.Workers != 2 || trial.Failures != 1 {
+		t.Errorf("trial = %+v, want 2 workers and 1 failure", trial)
+	}
+	if trial.Bytes != 20 {
+		t.Errorf("Bytes = %d, want 20", trial.Bytes)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	want := &Result{Workspace: "ws", Recommendation: Recommendation{GitWorkers: 6, GitEngine: "cli"}}
+
+	if err := Save(path, want); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.Workspace != "ws" || got.Recommendation != want.Recommendation {
+		t.Errorf("Load() = %+v, want %+v", got, want)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Load(missing) expected error")
+	}
+}
@@ -0,0 +1,151 @@
+package browse
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// itemDetail is the subset of pull request and issue fields shown in the
+// detail pane.
+type itemDetail struct {
+	Title       string   `json:"title"`
+	State       string   `json:"state"`
+	Kind        string   `json:"kind"`
+	Priority    string   `json:"priority"`
+	CreatedOn   string   `json:"created_on"`
+	UpdatedOn   string   `json:"updated_on"`
+	Description string   `json:"description"`
+	Author      *user    `json:"author"`
+	Reporter    *user    `json:"reporter"`
+	Assignee    *user    `json:"assignee"`
+	Content     *content `json:"content"`
+	Source      *branch  `json:"source"`
+	Destination *branch  `json:"destination"`
+}
+
+type content struct {
+	Raw string `json:"raw"`
+}
+
+type branch struct {
+	Branch *struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+}
+
+func (b *branch) name() string {
+	if b == nil || b.Branch == nil {
+		return "?"
+	}
+	return b.Branch.Name
+}
+
+type comment struct {
+	CreatedOn string   `json:"created_on"`
+	User      *user    `json:"user"`
+	Content   *content `json:"content"`
+	Deleted   bool     `json:"deleted"`
+}
+
+// ItemDetail renders a pull request or issue, with its comments, as lines
+// of text for the detail pane.
+func ItemDetail(item Item) ([]string, error) {
+	var d itemDetail
+	if err := readJSON(item.Path, &d); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", item.Label(), err)
+	}
+
+	lines := []string{fmt.Sprintf("%s: %s", item.Label(), d.Title), ""}
+	field := func(name, value string) {
+		if value != "" {
+			lines = append(lines, fmt.Sprintf("%-10s %s", name+":", value))
+		}
+	}
+	field("State", d.State)
+	if item.Kind == KindIssue {
+		field("Kind", d.Kind)
+		field("Priority", d.Priority)
+		field("Reporter", d.Reporter.name())
+		field("Assignee", d.Assignee.name())
+	} else {
+		field("Author", d.Author.name())
+		if d.Source != nil || d.Destination != nil {
+			field("Branch", d.Source.name()+" -> "+d.Destination.name())
+		}
+	}
+	field("Created", d.CreatedOn)
+	field("Updated", d.UpdatedOn)
+
+	body := d.Description
+	if item.Kind == KindIssue && d.Content != nil {
+		body = d.Content.Raw
+	}
+	if strings.TrimSpace(body) != "" {
+		lines = append(lines, "")
+		lines = append(lines, strings.Split(strings.TrimRight(body, "\n"), "\n")...)
+	}
+
+	// Comments live in <id>/comments.json next to <id>.json
+	var comments []comment
+	commentsPath := filepath.Join(strings.TrimSuffix(item.Path, ".json"), "comments.json")
+	if readJSON(commentsPath, &comments) == nil && len(comments) > 0 {
+		lines = append(lines, "", fmt.Sprintf("Comments (%d)", len(comments)))
+		for _, c := range comments {
+			if c.Deleted {
+				continue
+			}
+			lines = append(lines, "", fmt.Sprintf("-- %s, %s", c.User.name(), c.CreatedOn))
+			if c.Content != nil {
+				lines = append(lines, strings.Split(strings.TrimRight(c.Content.Raw, "\n"), "\n")...)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// RepoDetail renders a repository summary as lines of text.
+func RepoDetail(r Repo) []string {
+	lines := []string{r.Name, ""}
+	if r.Project != "" {
+		lines = append(lines, "Project:   "+r.Project)
+	}
+	lines = append(lines, "Slug:      "+r.Slug)
+	mirror := "no"
+	if r.HasMirror {
+		mirror = "yes"
+	}
+	lines = append(lines,
+		"Mirror:    "+mirror,
+		fmt.Sprintf("PRs:       %d", len(r.PullRequests)),
+		fmt.Sprintf("Issues:    %d", len(r.Issues)),
+	)
+	if len(r.Custom) > 0 {
+		keys := make([]string, 0, len(r.Custom))
+		for k := range r.Custom {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		lines = append(lines, "")
+		for _, k := range keys {
+			lines = append(lines, fmt.Sprintf("%-10s %s", k+":", r.Custom[k]))
+		}
+	}
+	if r.Description != "" {
+		lines = append(lines, "")
+		lines = append(lines, strings.Split(r.Description, "\n")...)
+	}
+	if r.Readme != nil && strings.TrimSpace(r.Readme.Content) != "" {
+		title := "-- " + r.Readme.Path
+		if r.Readme.Branch != "" {
+			title += " (" + r.Readme.Branch + ")"
+		}
+		lines = append(lines, "", title)
+		lines = append(lines, strings.Split(strings.TrimRight(r.Readme.Content, "\n"), "\n")...)
+		if r.Readme.Truncated {
+			lines = append(lines, "", "[README truncated]")
+		}
+	}
+	return lines
+}
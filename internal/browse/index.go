@@ -0,0 +1,279 @@
+// Package browse provides an interactive terminal browser over the JSON
+// metadata in a workspace backup.
+package browse
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// latestDirName mirrors backup.LatestDirName; importing the backup package
+// here would pull the API client into a read-only tool.
+const latestDirName = "latest"
+
+// Item kinds.
+const (
+	KindPullRequest = "pr"
+	KindIssue       = "issue"
+)
+
+// Index is the browsable contents of a workspace backup's latest/ tree.
+type Index struct {
+	Workspace string
+	Root      string
+	Projects  []Project
+}
+
+// Project groups repositories. Personal repositories are collected under a
+// project with an empty key.
+type Project struct {
+	Key   string
+	Name  string
+	Repos []Repo
+}
+
+// Repo is a backed-up repository and the PRs and issues saved with it.
+type Repo struct {
+	Project      string
+	Slug         string
+	Name         string
+	Description  string
+	Dir          string
+	HasMirror    bool
+	Readme       *Readme           // nil when the backup has no README snapshot
+	Custom       map[string]string // Operator-provided metadata, e.g. owner
+	PullRequests []Item
+	Issues       []Item
+}
+
+// Readme is a repository's README as snapshotted from its mirror's default
+// branch.
+type Readme struct {
+	Path      string `json:"path"`
+	Branch    string `json:"branch"`
+	Truncated bool   `json:"truncated"`
+	Content   string `json:"content"`
+}
+
+// Item is a pull request or issue summary. Path points at its JSON file so
+// the full detail can be read on demand.
+type Item struct {
+	Kind    string
+	ID      int
+	Title   string
+	State   string
+	Author  string
+	Updated string
+	Path    string
+}
+
+// Label returns the item's display reference, e.g. "PR #12".
+func (i Item) Label() string {
+	if i.Kind == KindIssue {
+		return fmt.Sprintf("Issue #%d", i.ID)
+	}
+	return fmt.Sprintf("PR #%d", i.ID)
+}
+
+// Items returns pull requests followed by issues.
+func (r Repo) Items() []Item {
+	items := make([]Item, 0, len(r.PullRequests)+len(r.Issues))
+	items = append(items, r.PullRequests...)
+	return append(items, r.Issues...)
+}
+
+// Load reads the project, repository, pull request, and issue JSON under a
+// workspace backup's latest/ directory. Files that fail to parse are
+// skipped so one damaged entity does not hide the rest of the backup.
+func Load(workspaceDir string) (*Index, error) {
+	root, err := filepath.EvalSymlinks(filepath.Join(workspaceDir, latestDirName))
+	if err != nil {
+		return nil, fmt.Errorf("locating latest backup: %w", err)
+	}
+
+	idx := &Index{
+		Workspace: filepath.Base(filepath.Clean(workspaceDir)),
+		Root:      root,
+	}
+
+	projectDirs, err := os.ReadDir(filepath.Join(root, "projects"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading projects: %w", err)
+	}
+	for _, d := range projectDirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, "projects", d.Name())
+		project := Project{Key: d.Name(), Name: d.Name()}
+		var meta struct {
+			Name string `json:"name"`
+		}
+		if readJSON(filepath.Join(dir, "project.json"), &meta) == nil && meta.Name != "" {
+			project.Name = meta.Name
+		}
+		if project.Repos, err = loadRepos(filepath.Join(dir, "repositories"), project.Key); err != nil {
+			return nil, err
+		}
+		idx.Projects = append(idx.Projects, project)
+	}
+
+	// Personal repositories are under personal/<owner>/repositories, or
+	// personal/repositories in backups from earlier versions
+	personal, err := loadRepos(filepath.Join(root, "personal", "repositories"), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(personal) > 0 {
+		idx.Projects = append(idx.Projects, Project{Name: "Personal repositories", Repos: personal})
+	}
+	ownerDirs, err := os.ReadDir(filepath.Join(root, "personal"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading personal repositories: %w", err)
+	}
+	for _, d := range ownerDirs {
+		if !d.IsDir() || d.Name() == "repositories" {
+			continue
+		}
+		repos, err := loadRepos(filepath.Join(root, "personal", d.Name(), "repositories"), "")
+		if err != nil {
+			return nil, err
+		}
+		if len(repos) > 0 {
+			idx.Projects = append(idx.Projects, Project{Name: "Personal repositories of " + d.Name(), Repos: repos})
+		}
+	}
+
+	if len(idx.Projects) == 0 {
+		return nil, fmt.Errorf("no repositories found in %s", root)
+	}
+	return idx, nil
+}
+
+// loadRepos reads every repository directory under dir.
+func loadRepos(dir, projectKey string) ([]Repo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading repositories: %w", err)
+	}
+
+	var repos []Repo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		repoDir := filepath.Join(dir, e.Name())
+		repo := Repo{Project: projectKey, Slug: e.Name(), Name: e.Name(), Dir: repoDir}
+		var meta struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		if readJSON(filepath.Join(repoDir, "repository.json"), &meta) == nil {
+			if meta.Name != "" {
+				repo.Name = meta.Name
+			}
+			repo.Description = meta.Description
+		}
+		// readme.json mirrors backup.ReadmeFileName; it also carries the
+		// description for git-only backups without repository.json
+		var snapshot struct {
+			Description string  `json:"description"`
+			Readme      *Readme `json:"readme"`
+		}
+		if readJSON(filepath.Join(repoDir, "readme.json"), &snapshot) == nil {
+			repo.Readme = snapshot.Readme
+			if repo.Description == "" {
+				repo.Description = snapshot.Description
+			}
+		}
+		// custom.json mirrors backup.CustomFileName
+		_ = readJSON(filepath.Join(repoDir, "custom.json"), &repo.Custom)
+		if _, err := os.Stat(filepath.Join(repoDir, "repo.git")); err == nil {
+			repo.HasMirror = true
+		}
+		repo.PullRequests = loadItems(filepath.Join(repoDir, "pull-requests"), KindPullRequest)
+		repo.Issues = loadItems(filepath.Join(repoDir, "issues"), KindIssue)
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// loadItems reads the <id>.json files in dir, newest ID first.
+func loadItems(dir, kind string) []Item {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var items []Item
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(name, ".json")); err != nil {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		var entity struct {
+			ID        int    `json:"id"`
+			Title     string `json:"title"`
+			State     string `json:"state"`
+			UpdatedOn string `json:"updated_on"`
+			Author    *user  `json:"author"`
+			Reporter  *user  `json:"reporter"`
+		}
+		if readJSON(path, &entity) != nil {
+			continue
+		}
+		author := entity.Author
+		if kind == KindIssue {
+			author = entity.Reporter
+		}
+		items = append(items, Item{
+			Kind:    kind,
+			ID:      entity.ID,
+			Title:   entity.Title,
+			State:   entity.State,
+			Author:  author.name(),
+			Updated: entity.UpdatedOn,
+			Path:    path,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID > items[j].ID })
+	return items
+}
+
+// user is the subset of a Bitbucket user object shown in the browser.
+type user struct {
+	DisplayName string `json:"display_name"`
+	Nickname    string `json:"nickname"`
+}
+
+func (u *user) name() string {
+	switch {
+	case u == nil:
+		return ""
+	case u.DisplayName != "":
+		return u.DisplayName
+	default:
+		return u.Nickname
+	}
+}
+
+// readJSON decodes the JSON file at path into v.
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
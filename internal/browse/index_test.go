@@ -0,0 +1,148 @@
+package browse
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBackup creates a small latest/ tree and returns the workspace dir.
+func writeBackup(t *testing.T) string {
+	t.Helper()
+	ws := filepath.Join(t.TempDir(), "acme")
+	latest := filepath.Join(ws, latestDirName)
+	files := map[string]string{
+		"projects/CORE/project.json":                                   `{"key":"CORE","name":"Core Services"}`,
+		"projects/CORE/repositories/api/repository.json":               `{"name":"API","description":"Public API"}`,
+		"projects/CORE/repositories/api/pull-requests/1.json":          `{"id":1,"title":"Add login","state":"MERGED","author":{"display_name":"Ann"},"source":{"branch":{"name":"login"}},"destination":{"branch":{"name":"main"}},"description":"Adds the login flow"}`,
+		"projects/CORE/repositories/api/pull-requests/2.json":          `{"id":2,"title":"Fix timeout","state":"OPEN","author":{"display_name":"Bob"}}`,
+		"projects/CORE/repositories/api/pull-requests/1/comments.json": `[{"user":{"display_name":"Bob"},"created_on":"2024-01-02","content":{"raw":"Looks good"}}]`,
+		"projects/CORE/repositories/api/pull-requests/broken.json":     `not json`,
+		"projects/CORE/repositories/api/issues/7.json":                 `{"id":7,"title":"Crash on start","state":"new","reporter":{"display_name":"Cat"},"content":{"raw":"Stack trace"}}`,
+		"projects/CORE/repositories/web/repository.json":               `{"name":"Web"}`,
+		"projects/CORE/repositories/web/custom.json":                   `{"owner":"storefront-team","retention_class":"critical"}`,
+		"projects/CORE/repositories/web/readme.json":                   `{"slug":"web","description":"Storefront","readme":{"path":"README.md","branch":"main","content":"# Web\n\nBuilt with htmx.\n"}}`,
+		"personal/repositories/dotfiles/repository.json":               `{"name":"dotfiles"}`,
+	}
+	for rel, content := range files {
+		path := filepath.Join(latest, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(latest, "projects/CORE/repositories/api/repo.git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestLoad(t *testing.T) {
+	idx, err := Load(writeBackup(t))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if idx.Workspace != "acme" {
+		t.Errorf("Workspace = %q, want acme", idx.Workspace)
+	}
+	if len(idx.Projects) != 2 {
+		t.Fatalf("got %d projects, want 2", len(idx.Projects))
+	}
+	core := idx.Projects[0]
+	if core.Key != "CORE" || core.Name != "Core Services" || len(core.Repos) != 2 {
+		t.Errorf("unexpected project %+v", core)
+	}
+	if personal := idx.Projects[1]; personal.Key != "" || len(personal.Repos) != 1 {
+		t.Errorf("unexpected personal project %+v", personal)
+	}
+
+	api := core.Repos[0]
+	if api.Slug != "api" || api.Name != "API" || api.Description != "Public API" || !api.HasMirror {
+		t.Errorf("unexpected repo %+v", api)
+	}
+	if len(api.PullRequests) != 2 || api.PullRequests[0].ID != 2 {
+		t.Errorf("expected PRs newest first, got %+v", api.PullRequests)
+	}
+	if api.PullRequests[1].Author != "Ann" {
+		t.Errorf("PR author = %q, want Ann", api.PullRequests[1].Author)
+	}
+	if len(api.Issues) != 1 || api.Issues[0].Author != "Cat" {
+		t.Errorf("unexpected issues %+v", api.Issues)
+	}
+}
+
+func TestLoad_Readme(t *testing.T) {
+	idx, err := Load(writeBackup(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := idx.Projects[0].Repos[1]
+	if web.Readme == nil || web.Readme.Path != "README.md" {
+		t.Fatalf("Readme = %+v, want README.md", web.Readme)
+	}
+	// The snapshot fills in a description missing from repository.json
+	if web.Description != "Storefront" {
+		t.Errorf("Description = %q, want Storefront", web.Description)
+	}
+
+	text := strings.Join(RepoDetail(web), "\n")
+	if !strings.Contains(text, "-- README.md (main)") || !strings.Contains(text, "Built with htmx.") {
+		t.Errorf("repo detail missing README:\n%s", text)
+	}
+}
+
+func TestLoad_Custom(t *testing.T) {
+	idx, err := Load(writeBackup(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api := idx.Projects[0].Repos[0]; api.Custom != nil {
+		t.Errorf("api Custom = %v, want none", api.Custom)
+	}
+	web := idx.Projects[0].Repos[1]
+	if web.Custom["owner"] != "storefront-team" {
+		t.Fatalf("web Custom = %v", web.Custom)
+	}
+	text := strings.Join(RepoDetail(web), "\n")
+	if !strings.Contains(text, "owner:     storefront-team") || !strings.Contains(text, "retention_class: critical") {
+		t.Errorf("repo detail missing custom metadata:\n%s", text)
+	}
+}
+
+func TestLoad_NoLatest(t *testing.T) {
+	if _, err := Load(t.TempDir()); err == nil {
+		t.Error("expected error for a directory without latest/")
+	}
+}
+
+func TestItemDetail(t *testing.T) {
+	idx, err := Load(writeBackup(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := idx.Projects[0].Repos[0]
+
+	lines, err := ItemDetail(api.PullRequests[1])
+	if err != nil {
+		t.Fatalf("ItemDetail() error = %v", err)
+	}
+	text := strings.Join(lines, "\n")
+	for _, want := range []string{"PR #1: Add login", "Author:    Ann", "login -> main", "Adds the login flow", "Comments (1)", "Looks good"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("detail missing %q:\n%s", want, text)
+		}
+	}
+
+	lines, err = ItemDetail(api.Issues[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = strings.Join(lines, "\n")
+	if !strings.Contains(text, "Reporter:  Cat") || !strings.Contains(text, "Stack trace") {
+		t.Errorf("unexpected issue detail:\n%s", text)
+	}
+}
@@ -0,0 +1,540 @@
+package browse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KeyType identifies a key press decoded from terminal input.
+type KeyType int
+
+// Key types. KeyRune carries a printable character in Key.Rune.
+const (
+	KeyRune KeyType = iota
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPgUp
+	KeyPgDown
+	KeyHome
+	KeyEnd
+	KeyEnter
+	KeyEsc
+	KeyBackspace
+	KeyCtrlC
+)
+
+// Key is a single key press.
+type Key struct {
+	Type KeyType
+	Rune rune
+}
+
+// level is a screen in the browser, from the widest to the narrowest.
+type level int
+
+const (
+	levelProjects level = iota
+	levelRepos
+	levelItems
+	levelDetail
+)
+
+// position is the list state saved when descending a level so going back
+// returns to the same row.
+type position struct {
+	cursor, offset int
+	filter         string
+}
+
+// splitMinWidth is the narrowest terminal that shows the preview pane
+// beside the list.
+const splitMinWidth = 80
+
+// Model is the browser state. It follows the update/view pattern: Update
+// applies a key press and View renders the screen, so the browser can be
+// driven and inspected without a terminal.
+type Model struct {
+	idx     *Index
+	level   level
+	project int
+	repo    int
+	items   []Item
+
+	cursor, offset int
+	filter         string
+	searching      bool
+	stack          []position
+
+	opened Item
+	detail []string
+	scroll int
+
+	width, height int
+	quit          bool
+
+	// previews caches rendered item details by path
+	previews map[string][]string
+}
+
+// NewModel creates a browser positioned on the project list.
+func NewModel(idx *Index) *Model {
+	return &Model{
+		idx:      idx,
+		width:    80,
+		height:   24,
+		previews: make(map[string][]string),
+	}
+}
+
+// SetSize sets the terminal dimensions used by View.
+func (m *Model) SetSize(width, height int) {
+	if width > 0 {
+		m.width = width
+	}
+	if height > 0 {
+		m.height = height
+	}
+}
+
+// Quit reports whether the user asked to exit.
+func (m *Model) Quit() bool {
+	return m.quit
+}
+
+// Update applies a key press.
+func (m *Model) Update(k Key) {
+	if k.Type == KeyCtrlC {
+		m.quit = true
+		return
+	}
+	if m.searching {
+		m.updateSearch(k)
+		return
+	}
+	if m.level == levelDetail {
+		m.updateDetail(k)
+		return
+	}
+
+	switch k.Type {
+	case KeyUp:
+		m.move(-1)
+	case KeyDown:
+		m.move(1)
+	case KeyPgUp:
+		m.move(-m.bodyHeight())
+	case KeyPgDown:
+		m.move(m.bodyHeight())
+	case KeyHome:
+		m.move(-m.cursor)
+	case KeyEnd:
+		m.move(len(m.rows()))
+	case KeyEnter, KeyRight:
+		m.open()
+	case KeyLeft, KeyBackspace:
+		m.back()
+	case KeyEsc:
+		if m.filter != "" {
+			m.setFilter("")
+		} else {
+			m.back()
+		}
+	case KeyRune:
+		switch k.Rune {
+		case 'q':
+			m.quit = true
+		case '/':
+			m.searching = true
+		case 'k':
+			m.move(-1)
+		case 'j':
+			m.move(1)
+		case 'g':
+			m.move(-m.cursor)
+		case 'G':
+			m.move(len(m.rows()))
+		case 'l':
+			m.open()
+		case 'h':
+			m.back()
+		}
+	}
+}
+
+func (m *Model) updateSearch(k Key) {
+	switch k.Type {
+	case KeyRune:
+		m.setFilter(m.filter + string(k.Rune))
+	case KeyBackspace:
+		if r := []rune(m.filter); len(r) > 0 {
+			m.setFilter(string(r[:len(r)-1]))
+		}
+	case KeyEnter:
+		m.searching = false
+	case KeyEsc:
+		m.searching = false
+		m.setFilter("")
+	case KeyUp:
+		m.move(-1)
+	case KeyDown:
+		m.move(1)
+	}
+}
+
+func (m *Model) updateDetail(k Key) {
+	lines := len(m.wrappedDetail())
+	switch k.Type {
+	case KeyUp:
+		m.scroll--
+	case KeyDown:
+		m.scroll++
+	case KeyPgUp:
+		m.scroll -= m.bodyHeight()
+	case KeyPgDown:
+		m.scroll += m.bodyHeight()
+	case KeyHome:
+		m.scroll = 0
+	case KeyEnd:
+		m.scroll = lines
+	case KeyLeft, KeyBackspace, KeyEsc:
+		m.back()
+	case KeyRune:
+		switch k.Rune {
+		case 'q':
+			m.quit = true
+		case 'k':
+			m.scroll--
+		case 'j', ' ':
+			m.scroll++
+		case 'g':
+			m.scroll = 0
+		case 'G':
+			m.scroll = lines
+		case 'h':
+			m.back()
+		}
+	}
+	if m.scroll > lines-m.bodyHeight() {
+		m.scroll = lines - m.bodyHeight()
+	}
+	if m.scroll < 0 {
+		m.scroll = 0
+	}
+}
+
+// setFilter replaces the search text and returns to the top of the list.
+func (m *Model) setFilter(f string) {
+	m.filter = f
+	m.cursor, m.offset = 0, 0
+}
+
+// move shifts the cursor by delta rows, clamped to the filtered list.
+func (m *Model) move(delta int) {
+	n := len(m.rows())
+	m.cursor += delta
+	if m.cursor >= n {
+		m.cursor = n - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	h := m.bodyHeight()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+h {
+		m.offset = m.cursor - h + 1
+	}
+}
+
+// open descends into the selected row.
+func (m *Model) open() {
+	sel, ok := m.selected()
+	if !ok {
+		return
+	}
+	m.stack = append(m.stack, position{cursor: m.cursor, offset: m.offset, filter: m.filter})
+	m.cursor, m.offset, m.filter = 0, 0, ""
+
+	switch m.level {
+	case levelProjects:
+		m.project = sel
+		m.level = levelRepos
+	case levelRepos:
+		m.repo = sel
+		m.items = m.idx.Projects[m.project].Repos[sel].Items()
+		m.level = levelItems
+	case levelItems:
+		m.opened = m.items[sel]
+		m.detail = m.preview(m.opened)
+		m.scroll = 0
+		m.level = levelDetail
+	}
+}
+
+// back returns to the previous level, restoring its list position.
+func (m *Model) back() {
+	if len(m.stack) == 0 {
+		return
+	}
+	pos := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	m.cursor, m.offset, m.filter = pos.cursor, pos.offset, pos.filter
+	m.level--
+}
+
+// rows returns the labels of the current list after filtering, with the
+// index of each in the underlying slice. Repositories also match on their
+// description and README.
+func (m *Model) rows() []row {
+	var all, extra []string
+	switch m.level {
+	case levelProjects:
+		for _, p := range m.idx.Projects {
+			key := p.Key
+			if key == "" {
+				key = "~"
+			}
+			all = append(all, fmt.Sprintf("%-10s %s (%d repos)", key, p.Name, len(p.Repos)))
+		}
+	case levelRepos:
+		for _, r := range m.idx.Projects[m.project].Repos {
+			all = append(all, fmt.Sprintf("%s (%d PRs, %d issues)", r.Slug, len(r.PullRequests), len(r.Issues)))
+			text := r.Description
+			for _, v := range r.Custom {
+				text += "\n" + v
+			}
+			if r.Readme != nil {
+				text += "\n" + r.Readme.Content
+			}
+			extra = append(extra, text)
+		}
+	case levelItems:
+		for _, it := range m.items {
+			label := fmt.Sprintf("%-10s [%s] %s", it.Label(), it.State, it.Title)
+			if it.Author != "" {
+				label += " - " + it.Author
+			}
+			all = append(all, label)
+		}
+	}
+
+	needle := strings.ToLower(m.filter)
+	var rows []row
+	for i, label := range all {
+		match := needle == "" || strings.Contains(strings.ToLower(label), needle)
+		if !match && i < len(extra) {
+			match = strings.Contains(strings.ToLower(extra[i]), needle)
+		}
+		if match {
+			rows = append(rows, row{label: label, index: i})
+		}
+	}
+	return rows
+}
+
+type row struct {
+	label string
+	index int
+}
+
+// selected returns the underlying index of the row under the cursor.
+func (m *Model) selected() (int, bool) {
+	rows := m.rows()
+	if m.cursor < 0 || m.cursor >= len(rows) {
+		return 0, false
+	}
+	return rows[m.cursor].index, true
+}
+
+// preview returns the detail lines for an item, reading its file once.
+func (m *Model) preview(it Item) []string {
+	if lines, ok := m.previews[it.Path]; ok {
+		return lines
+	}
+	lines, err := ItemDetail(it)
+	if err != nil {
+		lines = []string{err.Error()}
+	}
+	m.previews[it.Path] = lines
+	return lines
+}
+
+// previewLines returns the contents of the preview pane for the row under
+// the cursor.
+func (m *Model) previewLines() []string {
+	sel, ok := m.selected()
+	if !ok {
+		return nil
+	}
+	switch m.level {
+	case levelProjects:
+		p := m.idx.Projects[sel]
+		var prs, issues int
+		for _, r := range p.Repos {
+			prs += len(r.PullRequests)
+			issues += len(r.Issues)
+		}
+		lines := []string{p.Name, ""}
+		if p.Key != "" {
+			lines = append(lines, "Key:       "+p.Key)
+		}
+		return append(lines,
+			fmt.Sprintf("Repos:     %d", len(p.Repos)),
+			fmt.Sprintf("PRs:       %d", prs),
+			fmt.Sprintf("Issues:    %d", issues),
+		)
+	case levelRepos:
+		return RepoDetail(m.idx.Projects[m.project].Repos[sel])
+	case levelItems:
+		return m.preview(m.items[sel])
+	}
+	return nil
+}
+
+// bodyHeight is the number of lines between the header and the footer.
+func (m *Model) bodyHeight() int {
+	if h := m.height - 3; h > 1 {
+		return h
+	}
+	return 1
+}
+
+// breadcrumb describes the current location for the header line.
+func (m *Model) breadcrumb() string {
+	parts := []string{m.idx.Workspace}
+	if m.level >= levelRepos {
+		p := m.idx.Projects[m.project]
+		name := p.Key
+		if name == "" {
+			name = p.Name
+		}
+		parts = append(parts, name)
+	}
+	if m.level >= levelItems {
+		parts = append(parts, m.idx.Projects[m.project].Repos[m.repo].Slug)
+	}
+	if m.level == levelDetail {
+		parts = append(parts, m.opened.Label())
+	}
+	return strings.Join(parts, " / ")
+}
+
+func (m *Model) footer() string {
+	if m.searching {
+		return "/" + m.filter + "_"
+	}
+	if m.level == levelDetail {
+		return "up/down scroll  esc back  q quit"
+	}
+	help := "enter open  esc back  / search  q quit"
+	if m.filter != "" {
+		help = fmt.Sprintf("filter %q  ", m.filter) + help
+	}
+	return help
+}
+
+// wrappedDetail returns the detail lines wrapped to the screen width.
+func (m *Model) wrappedDetail() []string {
+	return wrap(m.detail, m.width)
+}
+
+// View renders the screen as exactly height lines of at most width
+// columns.
+func (m *Model) View() []string {
+	out := make([]string, 0, m.height)
+	out = append(out, fit(m.breadcrumb(), m.width), strings.Repeat("─", m.width))
+	h := m.bodyHeight()
+
+	if m.level == levelDetail {
+		lines := m.wrappedDetail()
+		for i := 0; i < h; i++ {
+			line := ""
+			if n := m.scroll + i; n < len(lines) {
+				line = lines[n]
+			}
+			out = append(out, fit(line, m.width))
+		}
+		return append(out, fit(m.footer(), m.width))
+	}
+
+	listWidth := m.width
+	var preview []string
+	if m.width >= splitMinWidth {
+		listWidth = m.width * 2 / 5
+		preview = wrap(m.previewLines(), m.width-listWidth-3)
+	}
+
+	rows := m.rows()
+	for i := 0; i < h; i++ {
+		line := ""
+		n := m.offset + i
+		if n < len(rows) {
+			line = fit(rows[n].label, listWidth)
+			if n == m.cursor {
+				line = "\x1b[7m" + line + "\x1b[0m"
+			}
+		} else {
+			line = fit("", listWidth)
+			if i == 0 && len(rows) == 0 {
+				line = fit("(no matches)", listWidth)
+			}
+		}
+		if m.width >= splitMinWidth {
+			right := ""
+			if i < len(preview) {
+				right = preview[i]
+			}
+			line += " │ " + fit(right, m.width-listWidth-3)
+		}
+		out = append(out, line)
+	}
+	return append(out, fit(m.footer(), m.width))
+}
+
+// sanitize drops control characters so stored text cannot move the cursor
+// or change terminal modes, and expands tabs.
+func sanitize(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			b.WriteString("    ")
+		case r < 0x20, r == 0x7f, r >= 0x80 && r < 0xa0:
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// fit sanitizes s and truncates or pads it to exactly width runes.
+func fit(s string, width int) string {
+	r := []rune(sanitize(s))
+	if len(r) > width {
+		if width > 1 {
+			return string(r[:width-1]) + "…"
+		}
+		return string(r[:width])
+	}
+	return string(r) + strings.Repeat(" ", width-len(r))
+}
+
+// wrap breaks lines longer than width into several.
+func wrap(lines []string, width int) []string {
+	if width < 1 {
+		return nil
+	}
+	var out []string
+	for _, line := range lines {
+		r := []rune(sanitize(line))
+		for len(r) > width {
+			out = append(out, string(r[:width]))
+			r = r[width:]
+		}
+		out = append(out, string(r))
+	}
+	return out
+}
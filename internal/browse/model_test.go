@@ -0,0 +1,140 @@
+package browse
+
+import (
+	"strings"
+	"testing"
+)
+
+func keys(s string) []Key {
+	return parseKeys([]byte(s))
+}
+
+func newTestModel(t *testing.T) *Model {
+	t.Helper()
+	idx, err := Load(writeBackup(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewModel(idx)
+	m.SetSize(100, 20)
+	return m
+}
+
+func update(m *Model, ks []Key) {
+	for _, k := range ks {
+		m.Update(k)
+	}
+}
+
+func TestModel_Navigate(t *testing.T) {
+	m := newTestModel(t)
+
+	view := strings.Join(m.View(), "\n")
+	if !strings.Contains(view, "Core Services (2 repos)") || !strings.Contains(view, "Personal repositories") {
+		t.Fatalf("project list missing entries:\n%s", view)
+	}
+
+	update(m, keys("\r\r"))
+	view = strings.Join(m.View(), "\n")
+	if !strings.Contains(view, "acme / CORE / api") {
+		t.Errorf("breadcrumb missing:\n%s", view)
+	}
+	if !strings.Contains(view, "PR #2") || !strings.Contains(view, "Issue #7") {
+		t.Errorf("item list missing entries:\n%s", view)
+	}
+	// The preview pane shows the selected PR
+	if !strings.Contains(view, "PR #2: Fix timeout") {
+		t.Errorf("preview pane missing:\n%s", view)
+	}
+
+	update(m, keys("j\r"))
+	view = strings.Join(m.View(), "\n")
+	if !strings.Contains(view, "acme / CORE / api / PR #1") || !strings.Contains(view, "Looks good") {
+		t.Errorf("detail view missing content:\n%s", view)
+	}
+
+	// Back restores the cursor on PR #1
+	update(m, []Key{{Type: KeyEsc}})
+	if m.level != levelItems || m.cursor != 1 {
+		t.Errorf("after back: level=%d cursor=%d, want items/1", m.level, m.cursor)
+	}
+
+	update(m, keys("q"))
+	if !m.Quit() {
+		t.Error("expected q to quit")
+	}
+}
+
+func TestModel_Search(t *testing.T) {
+	m := newTestModel(t)
+	update(m, keys("\r\r/crash\r"))
+
+	rows := m.rows()
+	if len(rows) != 1 || !strings.Contains(rows[0].label, "Issue #7") {
+		t.Fatalf("search rows = %+v, want Issue #7", rows)
+	}
+	if !strings.Contains(m.footer(), `filter "crash"`) {
+		t.Errorf("footer = %q, want active filter", m.footer())
+	}
+
+	// Esc clears the filter before going back
+	update(m, []Key{{Type: KeyEsc}})
+	if m.level != levelItems || len(m.rows()) != 3 {
+		t.Errorf("esc should clear filter: level=%d rows=%d", m.level, len(m.rows()))
+	}
+
+	update(m, keys("/nothing"))
+	if view := strings.Join(m.View(), "\n"); !strings.Contains(view, "(no matches)") {
+		t.Errorf("expected no matches:\n%s", view)
+	}
+}
+
+func TestModel_SearchReadme(t *testing.T) {
+	m := newTestModel(t)
+	update(m, keys("\r/htmx\r"))
+
+	rows := m.rows()
+	if len(rows) != 1 || !strings.HasPrefix(rows[0].label, "web ") {
+		t.Fatalf("search rows = %+v, want web", rows)
+	}
+}
+
+func TestModel_ViewSize(t *testing.T) {
+	m := newTestModel(t)
+	for _, width := range []int{40, 120} {
+		m.SetSize(width, 10)
+		lines := m.View()
+		if len(lines) != 10 {
+			t.Errorf("width %d: got %d lines, want 10", width, len(lines))
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("a\x1b[A\x1b[B\r\x7f\x1b\x03é\x1b[6~"))
+	want := []Key{
+		{Type: KeyRune, Rune: 'a'},
+		{Type: KeyUp},
+		{Type: KeyDown},
+		{Type: KeyEnter},
+		{Type: KeyBackspace},
+		{Type: KeyEsc},
+		{Type: KeyCtrlC},
+		{Type: KeyRune, Rune: 'é'},
+		{Type: KeyPgDown},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseKeys() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("key %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	if got := sanitize("a\x1b[2Jb\tc"); got != "a[2Jb    c" {
+		t.Errorf("sanitize() = %q", got)
+	}
+}
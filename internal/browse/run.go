@@ -0,0 +1,127 @@
+package browse
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Escape sequences for the alternate screen and cursor visibility.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+)
+
+// Run shows the browser on the terminal attached to in and out until the
+// user quits. The terminal is restored before returning.
+func Run(idx *Index, in, out *os.File) error {
+	restore, err := makeRaw(int(in.Fd()))
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	fmt.Fprint(out, enterScreen)
+	defer fmt.Fprint(out, leaveScreen)
+
+	m := NewModel(idx)
+	buf := make([]byte, 256)
+	for {
+		// Re-read the size on every frame so resizes apply on the next key
+		if w, h, err := termSize(int(out.Fd())); err == nil {
+			m.SetSize(w, h)
+		}
+		fmt.Fprint(out, clearScreen+strings.Join(m.View(), "\r\n"))
+
+		n, err := in.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("reading input: %w", err)
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			m.Update(k)
+		}
+		if m.Quit() {
+			return nil
+		}
+	}
+}
+
+// escapeKeys maps the CSI and SS3 sequences sent by common terminals.
+var escapeKeys = map[string]KeyType{
+	"\x1b[A":  KeyUp,
+	"\x1b[B":  KeyDown,
+	"\x1b[C":  KeyRight,
+	"\x1b[D":  KeyLeft,
+	"\x1bOA":  KeyUp,
+	"\x1bOB":  KeyDown,
+	"\x1bOC":  KeyRight,
+	"\x1bOD":  KeyLeft,
+	"\x1b[5~": KeyPgUp,
+	"\x1b[6~": KeyPgDown,
+	"\x1b[H":  KeyHome,
+	"\x1b[F":  KeyEnd,
+	"\x1b[1~": KeyHome,
+	"\x1b[4~": KeyEnd,
+	"\x1bOH":  KeyHome,
+	"\x1bOF":  KeyEnd,
+}
+
+// parseKeys decodes a chunk of raw terminal input into key presses.
+// Unrecognized escape sequences are dropped.
+func parseKeys(b []byte) []Key {
+	var keys []Key
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b:
+			n := escapeLen(b)
+			if n == 1 {
+				keys = append(keys, Key{Type: KeyEsc})
+			} else if t, ok := escapeKeys[string(b[:n])]; ok {
+				keys = append(keys, Key{Type: t})
+			}
+			b = b[n:]
+			continue
+		case c == '\r' || c == '\n':
+			keys = append(keys, Key{Type: KeyEnter})
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, Key{Type: KeyBackspace})
+		case c == 0x03:
+			keys = append(keys, Key{Type: KeyCtrlC})
+		case c < 0x20:
+			// other control keys are ignored
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, Key{Type: KeyRune, Rune: r})
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// escapeLen returns the length of the escape sequence at the start of b:
+// 1 for a lone ESC, otherwise up to and including the final byte.
+func escapeLen(b []byte) int {
+	if len(b) < 2 || (b[1] != '[' && b[1] != 'O') {
+		return 1
+	}
+	if b[1] == 'O' {
+		if len(b) < 3 {
+			return len(b)
+		}
+		return 3
+	}
+	for i := 2; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(b)
+}
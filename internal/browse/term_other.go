@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package browse
+
+import "errors"
+
+// makeRaw is not implemented on this platform.
+func makeRaw(int) (func(), error) {
+	return nil, errors.New("interactive browsing is not supported on this platform")
+}
+
+// termSize is not implemented on this platform.
+func termSize(int) (int, int, error) {
+	return 0, 0, errors.New("terminal size not available")
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package browse
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw puts the terminal into raw mode so key presses arrive unbuffered
+// and unechoed, and returns a function that restores the previous mode.
+func makeRaw(fd int) (func(), error) {
+	t, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, fmt.Errorf("reading terminal mode: %w", err)
+	}
+	saved := *t
+
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, t); err != nil {
+		return nil, fmt.Errorf("setting raw mode: %w", err)
+	}
+	return func() { _ = unix.IoctlSetTermios(fd, ioctlSetTermios, &saved) }, nil
+}
+
+// termSize returns the terminal width and height.
+func termSize(fd int) (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -2,12 +2,22 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/andy-wilson/bb-backup/internal/format"
 	"gopkg.in/yaml.v3"
 )
 
@@ -15,11 +25,112 @@ import (
 type Config struct {
 	Workspace   string            `yaml:"workspace"`
 	Auth        AuthConfig        `yaml:"auth"`
+	API         APIConfig         `yaml:"api"`
 	Storage     StorageConfig     `yaml:"storage"`
 	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
 	Parallelism ParallelismConfig `yaml:"parallelism"`
 	Backup      BackupConfig      `yaml:"backup"`
 	Logging     LoggingConfig     `yaml:"logging"`
+	Scan        ScanConfig        `yaml:"scan"`
+	Git         GitConfig         `yaml:"git"`
+	Alerts      AlertsConfig      `yaml:"alerts"`
+	Progress    ProgressConfig    `yaml:"progress"`
+	SLO         SLOConfig         `yaml:"slo"`
+	Privacy     PrivacyConfig     `yaml:"privacy"`
+	Retention   RetentionConfig   `yaml:"retention"`
+	Policy      PolicyConfig      `yaml:"policy"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Listen      ListenConfig      `yaml:"listen"`
+	Throttle    ThrottleConfig    `yaml:"throttle"`
+
+	Notifications NotificationsConfig `yaml:"notifications"`
+
+	// Credentials are extra identities for projects and repositories the
+	// auth credentials cannot read
+	Credentials []CredentialSet `yaml:"credentials"`
+
+	// RepoTokens maps repository slugs to repository access tokens. A
+	// repository listed here is backed up with its own token, which grants
+	// access to that repository only, instead of the credentials it would
+	// otherwise use.
+	RepoTokens map[string]string `yaml:"repo_tokens"`
+
+	// Groups names sets of repository globs that can be backed up on their
+	// own with --group, e.g. critical repos hourly and the rest nightly.
+	Groups map[string][]string `yaml:"groups"`
+
+	// Budgets cap the time a group's or a project's repositories may take
+	// in one run, so slow, unimportant repositories cannot crowd out the
+	// rest of the run window.
+	Budgets []RuntimeBudget `yaml:"budgets"`
+
+	// Workspaces, instead of workspace, backs up several workspaces with
+	// one configuration, e.g. one per team, each into its own output root
+	// with its own retention and notifications. ForWorkspace returns the
+	// configuration for one of them.
+	Workspaces []WorkspaceConfig `yaml:"workspaces"`
+
+	// WorkspaceAlias is the alias of the workspaces entry a config returned
+	// by ForWorkspace was made for, and empty otherwise.
+	WorkspaceAlias string `yaml:"-"`
+}
+
+// WorkspaceConfig is one entry of workspaces. Sections left out are
+// shared with the rest of the configuration; Retention and Notifications
+// given here replace the top-level sections for this workspace rather than
+// adding to them, so one team's notification targets never receive
+// another's runs.
+type WorkspaceConfig struct {
+	Slug  string `yaml:"slug"`
+	Alias string `yaml:"alias"` // Name for --workspace, logs, and notifications (default: the slug)
+	Path  string `yaml:"path"`  // Output root, in place of storage.path
+
+	Retention     *RetentionConfig     `yaml:"retention"`
+	Notifications *NotificationsConfig `yaml:"notifications"`
+}
+
+// UnmarshalYAML starts a workspace's notifications section from the
+// defaults, as the top-level one does.
+func (w *WorkspaceConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain WorkspaceConfig
+	if err := value.Decode((*plain)(w)); err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value == "notifications" {
+			n := Default().Notifications
+			if err := value.Content[i+1].Decode(&n); err != nil {
+				return err
+			}
+			w.Notifications = &n
+		}
+	}
+	return nil
+}
+
+// Name returns the workspace's alias, or its slug without one.
+func (w WorkspaceConfig) Name() string {
+	if w.Alias != "" {
+		return w.Alias
+	}
+	return w.Slug
+}
+
+// RuntimeBudget limits the worker time the repositories of a group or of a
+// project may use in one run. Exactly one of Group and Project is set.
+type RuntimeBudget struct {
+	Group   string `yaml:"group"`   // Name of an entry in groups
+	Project string `yaml:"project"` // Project key
+	Minutes int    `yaml:"minutes"`
+}
+
+// Name returns how the budget is referred to in logs and reports, e.g.
+// "group archive" or "project LEGACY".
+func (b RuntimeBudget) Name() string {
+	if b.Group != "" {
+		return "group " + b.Group
+	}
+	return "project " + b.Project
 }
 
 // AuthConfig holds authentication settings.
@@ -32,12 +143,83 @@ type AuthConfig struct {
 	AccessToken  string `yaml:"access_token"` // Repository/Project/Workspace access token
 	ClientID     string `yaml:"client_id"`
 	ClientSecret string `yaml:"client_secret"`
+	// CredentialCommand prints the secret for the method (or a JSON object
+	// with the token and its expiry) and is re-run before the token
+	// expires, so short-lived tokens can rotate during long runs
+	CredentialCommand string `yaml:"credential_command"`
+	// GitUsername and GitPassword, when set, are used for git over HTTPS
+	// instead of the credentials derived from the method, e.g. a separate
+	// account with read access to code only
+	GitUsername string `yaml:"git_username"`
+	GitPassword string `yaml:"git_password"`
+}
+
+// CredentialSet is an extra identity for repositories the main auth
+// credentials cannot read, such as projects only a particular service
+// account has access to. A repository uses the first set whose projects
+// include its project key or whose repos patterns match its slug, and the
+// main auth otherwise.
+type CredentialSet struct {
+	Name       string   `yaml:"name"`
+	Projects   []string `yaml:"projects"` // Project keys, compared case-insensitively
+	Repos      []string `yaml:"repos"`    // Repository slug globs
+	AuthConfig `yaml:",inline"`
 }
 
 // StorageConfig holds storage backend settings.
 type StorageConfig struct {
 	Type string `yaml:"type"`
 	Path string `yaml:"path"`
+	// VerifyWrites fsyncs each metadata file and reads it back to check
+	// its size and checksum, for NFS/SMB targets that can lose writes
+	VerifyWrites bool `yaml:"verify_writes"`
+	// HighLatency holds metadata writes and state checkpoints in memory
+	// and writes them out together every FlushIntervalSeconds and at the
+	// end of each repository, for SMB and other slow shares
+	HighLatency          bool `yaml:"high_latency"`
+	FlushIntervalSeconds int  `yaml:"flush_interval_seconds"`
+	// MaxWorkspaceSize caps the disk space used by a workspace's backups,
+	// e.g. "500GB". Usage is measured at the end of each run; QuotaAction
+	// says whether a run over the cap only warns ("warn") or refuses to
+	// start ("block").
+	MaxWorkspaceSize string `yaml:"max_workspace_size"`
+	QuotaAction      string `yaml:"quota_action"`
+
+	// Archive packages each completed run directory into <run-id>.tar.gz
+	// or <run-id>.zip (ArchiveTarGz or ArchiveZip) next to it, with a
+	// sha256sum-style checksum file. ArchiveRemove then deletes the run's
+	// directory, except for the run current points at.
+	Archive       string `yaml:"archive"`
+	ArchiveRemove bool   `yaml:"archive_remove"`
+
+	// S3 is the bucket for type "s3". Path is still the working copy:
+	// files are written there and copied to the bucket, and git mirrors,
+	// which need a filesystem, are uploaded as bundles.
+	S3 S3Config `yaml:"s3"`
+}
+
+// S3Config holds an S3-compatible bucket (AWS S3, MinIO, Backblaze B2).
+// Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN.
+type S3Config struct {
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	Endpoint  string `yaml:"endpoint"`   // Service URL; empty for AWS S3 in region
+	Prefix    string `yaml:"prefix"`     // Prepended to every key
+	PathStyle bool   `yaml:"path_style"` // endpoint/bucket/key addressing, for MinIO
+}
+
+// MaxWorkspaceBytes returns storage.max_workspace_size in bytes, or 0 if
+// no cap is set.
+func (s StorageConfig) MaxWorkspaceBytes() int64 {
+	if s.MaxWorkspaceSize == "" {
+		return 0
+	}
+	n, err := format.ParseBytes(s.MaxWorkspaceSize)
+	if err != nil {
+		return 0
+	}
+	return n
 }
 
 // RateLimitConfig holds rate limiting settings.
@@ -48,12 +230,30 @@ type RateLimitConfig struct {
 	RetryBackoffSeconds    int     `yaml:"retry_backoff_seconds"`
 	RetryBackoffMultiplier float64 `yaml:"retry_backoff_multiplier"`
 	MaxBackoffSeconds      int     `yaml:"max_backoff_seconds"`
+	// SharedStateFile, when set, is a token bucket file shared by every
+	// bb-backup process that points at it, so concurrent runs against the
+	// same account divide requests_per_hour instead of each using all of it.
+	SharedStateFile string `yaml:"shared_state_file"`
+	// MaxMaintenanceWaitMinutes is the total time a run pauses for 5xx
+	// responses carrying Retry-After or announced maintenance before
+	// letting requests fail (0 = never pause).
+	MaxMaintenanceWaitMinutes int `yaml:"max_maintenance_wait_minutes"`
+	// StatusPageURL is a Statuspage scheduled-maintenances/active.json
+	// endpoint checked on 5xx responses without Retry-After (optional).
+	StatusPageURL string `yaml:"status_page_url"`
 }
 
 // ParallelismConfig holds parallelism settings.
 type ParallelismConfig struct {
-	GitWorkers int `yaml:"git_workers"`
-	APIWorkers int `yaml:"api_workers"`
+	GitWorkers int  `yaml:"git_workers"`
+	APIWorkers int  `yaml:"api_workers"`
+	AutoTune   bool `yaml:"auto_tune"` // Use git_workers recommended by the last `bb-backup bench`
+
+	// MaxPerProject caps the repositories of one project backed up at the
+	// same time, so a project with many repositories cannot take every
+	// worker (0 = no cap). Repositories outside projects count as one
+	// project.
+	MaxPerProject int `yaml:"max_per_project"`
 }
 
 // BackupConfig holds backup content settings.
@@ -65,7 +265,104 @@ type BackupConfig struct {
 	IncludeIssueComments bool     `yaml:"include_issue_comments"`
 	ExcludeRepos         []string `yaml:"exclude_repos"`
 	IncludeRepos         []string `yaml:"include_repos"`
+	IncludeReposFile     string   `yaml:"include_repos_file"`  // Allow list file: one slug/glob per line, # comments
+	IncludeProjects      []string `yaml:"include_projects"`    // Project keys; only these projects are listed and backed up
 	GitTimeoutMinutes    int      `yaml:"git_timeout_minutes"` // Timeout for git clone/fetch (default: 30)
+	RawMode              bool     `yaml:"raw_mode"`            // Write raw API values for all metadata, bypassing typed structs
+	RawValidate          bool     `yaml:"raw_validate"`        // In raw mode, check values against typed structs and warn on mismatch
+	AtomicLatest         bool     `yaml:"atomic_latest"`       // Stage latest/ updates in latest.tmp and swap them in at the end of the run
+	ArchivedRepos        string   `yaml:"archived_repos"`      // Archived repos: "include", "last" (after active repos), or "skip"
+
+	// IncludeAttachments downloads Bitbucket-hosted images and attachments
+	// linked from pull request and issue descriptions, which disappear
+	// with the workspace, and saves a copy of each description that links
+	// to the local files.
+	IncludeAttachments bool `yaml:"include_attachments"`
+
+	// IncludeWikis mirrors the wiki of each repository that has one, a
+	// separate git repository, to wiki.git next to repo.git in latest/.
+	IncludeWikis bool `yaml:"include_wikis"`
+
+	// IncludeDownloads saves the files in each repository's Downloads
+	// section, such as release artifacts, to downloads/ next to
+	// repository.json in latest/, with an index.json describing them.
+	// Files over DownloadsMaxSize (e.g. "500MB") are skipped, as are
+	// names not matching DownloadsInclude or matching DownloadsExclude
+	// (globs); empty DownloadsInclude matches every file.
+	IncludeDownloads bool     `yaml:"include_downloads"`
+	DownloadsMaxSize string   `yaml:"downloads_max_size"`
+	DownloadsInclude []string `yaml:"downloads_include"`
+	DownloadsExclude []string `yaml:"downloads_exclude"`
+
+	// IncludePolicies writes policies.md next to repository.json: a
+	// readable summary of the repository's branch permissions, merge
+	// checks, and default reviewers for audits. Reading branch
+	// restrictions needs repository admin; other repositories are skipped.
+	IncludePolicies bool `yaml:"include_policies"`
+
+	// IncludeSettings saves the repository's branch restrictions,
+	// branching model, and default reviewers as JSON under settings/, as
+	// the API returns them, since the git mirror does not carry them.
+	IncludeSettings bool `yaml:"include_settings"`
+
+	// IncludeIntegrations saves the repository's deploy keys and webhooks
+	// next to repository.json, so a restore can recreate them. Webhook
+	// secrets and credentials in webhook URLs are redacted. Listing either
+	// needs repository admin; other repositories are skipped.
+	IncludeIntegrations bool `yaml:"include_integrations"`
+
+	// FieldMasks drops fields from saved pull requests, issues, and their
+	// comments, activity, and tasks, keyed by FieldMask kind. Each mask is
+	// a dot-separated path from the entity's root where "*" matches any
+	// one key and "**" any number, e.g. "summary.html" or "**.links".
+	FieldMasks map[string][]string `yaml:"field_masks"`
+
+	// CustomMetadataFile maps repository slugs or globs to operator-provided
+	// fields such as owner, classification, and retention_class. Each
+	// repository's fields are saved as custom.json next to repository.json
+	// and recorded in report.json.
+	CustomMetadataFile string `yaml:"custom_metadata_file"`
+
+	// StrictIssuePermissions treats a 403 from a repository's issue tracker
+	// as a failure. By default restricted trackers are skipped like
+	// disabled ones and noted in report.json.
+	StrictIssuePermissions bool `yaml:"strict_issue_permissions"`
+
+	// The state file is checkpointed every CheckpointRepos finished
+	// repositories and every CheckpointIntervalSeconds, whichever comes
+	// first, so a crash loses little progress. Zero disables either trigger.
+	CheckpointRepos           int `yaml:"checkpoint_repos"`
+	CheckpointIntervalSeconds int `yaml:"checkpoint_interval_seconds"`
+
+	// ShutdownTimeoutSeconds is how long an interrupted run waits for
+	// in-flight repositories to finish before abandoning them (default: 5).
+	ShutdownTimeoutSeconds int `yaml:"shutdown_timeout_seconds"`
+
+	// A repository that fails QuarantineAfter runs in a row is skipped for
+	// the next QuarantineRuns runs, then tried again. Each failure after a
+	// quarantine doubles the cool-down, up to QuarantineMaxRuns. Zero
+	// QuarantineAfter disables automatic quarantine.
+	QuarantineAfter   int `yaml:"quarantine_after"`
+	QuarantineRuns    int `yaml:"quarantine_runs"`
+	QuarantineMaxRuns int `yaml:"quarantine_max_runs"`
+
+	// RunTimestampFormat is the Go time layout of the UTC start time that
+	// begins each run directory's name, e.g. "20060102-150405Z". It must
+	// not produce path separators or colons.
+	RunTimestampFormat string `yaml:"run_timestamp_format"`
+}
+
+// DownloadsMaxBytes returns backup.downloads_max_size in bytes, or 0 if
+// no cap is set.
+func (b BackupConfig) DownloadsMaxBytes() int64 {
+	if b.DownloadsMaxSize == "" {
+		return 0
+	}
+	n, err := format.ParseBytes(b.DownloadsMaxSize)
+	if err != nil {
+		return 0
+	}
+	return n
 }
 
 // LoggingConfig holds logging settings.
@@ -73,8 +370,334 @@ type LoggingConfig struct {
 	Level  string `yaml:"level"`
 	Format string `yaml:"format"`
 	File   string `yaml:"file"`
+	// Bundle also writes the run's log to bb-backup.log in the run
+	// directory, and the errors it logged to errors.json, so a backup
+	// describes itself wherever logging.file points
+	Bundle bool `yaml:"bundle"`
+	// BufferJobs holds each repository job's info and debug lines and
+	// writes them as one block when the job finishes, so parallel workers'
+	// logs don't interleave. Errors are still written immediately.
+	BufferJobs bool `yaml:"buffer_jobs"`
+}
+
+// MetricsConfig holds settings for exposing run internals (worker pool,
+// rate limiter, and API usage) through expvar, and the run's counters in
+// the Prometheus text format.
+type MetricsConfig struct {
+	// Listen serves the expvar JSON at /debug/vars and Prometheus metrics
+	// at /metrics on this address, e.g. "localhost:6060", while a backup
+	// runs
+	Listen string `yaml:"listen"`
+
+	// PushgatewayURL pushes the run's metrics to a Prometheus Pushgateway
+	// when it finishes, grouped by PushgatewayJob (default "bb_backup")
+	// and the workspace
+	PushgatewayURL string `yaml:"pushgateway_url"`
+	PushgatewayJob string `yaml:"pushgateway_job"`
+}
+
+// ListenConfig holds settings for bb-backup listen, which receives
+// Bitbucket webhooks and backs up the repositories they report changes to.
+type ListenConfig struct {
+	Addr   string `yaml:"addr"`   // host:port to receive webhooks on
+	Path   string `yaml:"path"`   // URL path webhooks are POSTed to
+	Secret string `yaml:"secret"` // Webhook secret; deliveries must be signed with it
+	// DelaySeconds gathers the events that arrive this long after the
+	// first one into one backup, so a burst of pushes is backed up once
+	DelaySeconds int `yaml:"delay_seconds"`
+}
+
+// ThrottleConfig makes a run give way to other work on a shared backup
+// host. Every setting is best effort: one the operating system does not
+// support is logged and skipped.
+type ThrottleConfig struct {
+	// IOClass is the I/O scheduling class of the process and its git
+	// processes, as with ionice: "best-effort" (at the lowest priority) or
+	// "idle" (Linux only)
+	IOClass string `yaml:"io_class"`
+	// CPUNice is the CPU niceness of the process and its git processes,
+	// 1-19 (Linux, macOS, BSD)
+	CPUNice int `yaml:"cpu_nice"`
+	// CPUPercent runs fewer workers while the run and its git processes
+	// use more than this percentage of all cores (0 = no cap)
+	CPUPercent int `yaml:"cpu_percent"`
+	// Nice runs fewer workers while the 1-minute load average per core is
+	// above LoadThreshold (Linux only)
+	Nice          bool    `yaml:"nice"`
+	LoadThreshold float64 `yaml:"load_threshold"`
+	// MinWorkers is the fewest workers nice mode and cpu_percent leave
+	// running
+	MinWorkers int `yaml:"min_workers"`
+}
+
+// Adaptive reports whether the worker count follows the load or the CPU
+// use.
+func (t ThrottleConfig) Adaptive() bool {
+	return t.Nice || t.CPUPercent > 0
+}
+
+// GitConfig holds git engine settings.
+type GitConfig struct {
+	Engine      string              `yaml:"engine"`       // "auto" (go-git, CLI fallback), "gogit", or "cli"
+	Overrides   []GitEngineOverride `yaml:"overrides"`    // Per-repository engine overrides (first match wins)
+	SSHKeyPath  string              `yaml:"ssh_key_path"` // Private key for SSH clones
+	SSHFallback bool                `yaml:"ssh_fallback"` // Retry over SSH when HTTPS fails with auth/proxy errors
+
+	// DetectRewrites compares refs before and after each fetch and reports
+	// force-pushes, moved tags, and deleted branches in report.json.
+	DetectRewrites bool `yaml:"detect_rewrites"`
+
+	// PrecheckRefs compares the refs Bitbucket advertises with the mirror's
+	// before each fetch and skips the fetch when they are identical, which
+	// is much cheaper than fetch negotiation for unchanged repositories.
+	PrecheckRefs bool `yaml:"precheck_refs"`
+
+	// BaseURL replaces the scheme and host of HTTPS clone URLs, e.g. to
+	// clone through a proxy or mirror of bitbucket.org.
+	BaseURL string `yaml:"base_url"`
+
+	// ForkAlternates makes the mirror of a fork borrow objects from its
+	// parent's mirror in the same workspace through git alternates, so
+	// the objects they share are stored once. Needs the git CLI.
+	ForkAlternates bool `yaml:"fork_alternates"`
+}
+
+// APIConfig holds Bitbucket API endpoint settings.
+type APIConfig struct {
+	// Type is the kind of Bitbucket to back up: APITypeCloud (the
+	// default) or APITypeServer for Bitbucket Data Center and Server
+	Type string `yaml:"type"`
+
+	// BaseURL replaces https://api.bitbucket.org/2.0 for every request,
+	// including pagination links, e.g. to go through a proxy. With type
+	// server it is the root URL of the instance, e.g.
+	// https://bitbucket.example.com.
+	BaseURL string `yaml:"base_url"`
+}
+
+// Archive formats for storage.archive.
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// API types for api.type.
+const (
+	APITypeCloud  = "cloud"
+	APITypeServer = "server"
+)
+
+// AlertsConfig holds notification settings for security-relevant events
+// such as history rewrites. Both targets receive the same JSON payload.
+type AlertsConfig struct {
+	WebhookURL string `yaml:"webhook_url"` // POST the payload to this URL
+	Command    string `yaml:"command"`     // Run via sh -c with the payload on stdin
+}
+
+// Values of NotificationsConfig.When.
+const (
+	NotifyAlways  = "always"
+	NotifyFailure = "failure"
+	NotifySuccess = "success"
+)
+
+// NotificationsConfig sends a summary of each backup run to Slack, a
+// webhook, or by email when the run finishes or fails. Templates are Go
+// text/template documents executed with the summary; empty ones use the
+// built-in messages.
+type NotificationsConfig struct {
+	When       string `yaml:"when"`        // "always" (default), "failure", or "success"
+	MaxRetries int    `yaml:"max_retries"` // Retries per target after a failed delivery
+
+	Slack   SlackNotification   `yaml:"slack"`
+	Webhook WebhookNotification `yaml:"webhook"`
+	Email   EmailNotification   `yaml:"email"`
+}
+
+// Enabled reports whether any notification target is set.
+func (n NotificationsConfig) Enabled() bool {
+	return n.Slack.WebhookURL != "" || n.Webhook.URL != "" || n.Email.SMTPHost != ""
+}
+
+// SlackNotification posts the summary to a Slack incoming webhook.
+type SlackNotification struct {
+	WebhookURL string `yaml:"webhook_url"`
+	Template   string `yaml:"template"` // Message text
+}
+
+// WebhookNotification POSTs the summary to any HTTP endpoint: as JSON, or
+// as the rendered template.
+type WebhookNotification struct {
+	URL         string            `yaml:"url"`
+	Template    string            `yaml:"template"`     // Request body (default: the summary as JSON)
+	ContentType string            `yaml:"content_type"` // Default: application/json
+	Headers     map[string]string `yaml:"headers"`
+}
+
+// EmailNotification mails the summary through an SMTP server, using
+// STARTTLS when the server offers it.
+type EmailNotification struct {
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"` // Default: 587
+	Username string   `yaml:"username"`  // Empty sends without authenticating
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Subject  string   `yaml:"subject"`  // Template for the subject line
+	Template string   `yaml:"template"` // Template for the plain text body
+}
+
+// ProgressConfig holds settings for pushing progress events to a dashboard
+// while a backup runs. Events are POSTed in batches; a batch that fails is
+// retried, and while the endpoint is slow events queue up to MaxQueued,
+// dropping the oldest beyond that.
+type ProgressConfig struct {
+	WebhookURL           string `yaml:"webhook_url"`            // POST batches of progress events to this URL
+	BatchSize            int    `yaml:"batch_size"`             // Most events per POST
+	FlushIntervalSeconds int    `yaml:"flush_interval_seconds"` // Send a partial batch after this long
+	MaxRetries           int    `yaml:"max_retries"`            // Retries per batch on errors, 408, 429, and 5xx
+	MaxQueued            int    `yaml:"max_queued"`             // Events held while the endpoint is slow
+}
+
+// SLOConfig holds service level objectives for backup runs, evaluated at
+// the end of each run and by `bb-backup slo`. Empty durations and a
+// negative failure budget disable the corresponding check.
+type SLOConfig struct {
+	MaxDuration    string `yaml:"max_duration"`     // Longest acceptable run, e.g. "2h"
+	MaxFailedRepos int    `yaml:"max_failed_repos"` // Most failed repositories allowed in a run
+	MaxStaleness   string `yaml:"max_staleness"`    // Oldest acceptable completed run, e.g. "26h"
+}
+
+// Enabled reports whether any SLO target is set.
+func (s SLOConfig) Enabled() bool {
+	return s.MaxDuration != "" || s.MaxFailedRepos >= 0 || s.MaxStaleness != ""
+}
+
+// PrivacyConfig minimizes personal data in saved metadata. Field patterns
+// are JSON keys ("display_name") or a parent and key ("author.raw"),
+// matched anywhere in pull requests, issues, comments, and other entities.
+type PrivacyConfig struct {
+	DropFields []string `yaml:"drop_fields"` // Removed entirely
+	HashFields []string `yaml:"hash_fields"` // String values replaced by a keyed hash
+	StripHTML  bool     `yaml:"strip_html"`  // Drop "html" renderings next to raw markup
+	HashSalt   string   `yaml:"hash_salt"`   // Key for hash_fields; use ${VAR} to keep it out of the file
+}
+
+// Enabled reports whether any minimization is configured.
+func (p PrivacyConfig) Enabled() bool {
+	return len(p.DropFields) > 0 || len(p.HashFields) > 0 || p.StripHTML
+}
+
+// RetentionConfig controls which run directories `bb-backup prune`
+// deletes. A repository's data in a run is kept for the days of its
+// retention_class (from backup.custom_metadata_file), or KeepDays if it
+// has none. Zero keeps data forever.
+//
+// KeepRuns separately caps the number of run directories. Git mirrors live
+// only in latest/, so runs hold nothing but metadata snapshots and can be
+// rotated far sooner than KeepDays. Runs beyond the newest KeepRuns lose
+// every repository without a configured class; zero disables the cap.
+type RetentionConfig struct {
+	KeepDays int                             `yaml:"keep_days"`
+	KeepRuns int                             `yaml:"keep_runs"`
+	Classes  map[string]RetentionClassConfig `yaml:"classes"`
 }
 
+// RetentionClassConfig is the retention for one retention class.
+type RetentionClassConfig struct {
+	KeepDays int `yaml:"keep_days"`
+}
+
+// KeepDaysFor returns the days a repository of the given retention class
+// is kept, and whether the class is configured. Unknown classes get
+// keep_days.
+func (r RetentionConfig) KeepDaysFor(class string) (int, bool) {
+	if c, ok := r.Classes[class]; ok && class != "" {
+		return c.KeepDays, true
+	}
+	return r.KeepDays, false
+}
+
+// PolicyConfig points at a backup policy file kept in a repository of the
+// workspace, so changes to what is backed up and for how long can be
+// reviewed as pull requests instead of edits to this file. The file is
+// read at the start of each run: its include and exclude patterns add to
+// backup.include_repos and backup.exclude_repos, and its retention
+// settings override retention.
+type PolicyConfig struct {
+	Repository string `yaml:"repository"` // Slug of the repository holding the file; empty disables
+	Path       string `yaml:"path"`       // Path of the file in the repository (default: .bbbackup.yaml)
+	Ref        string `yaml:"ref"`        // Branch, tag, or commit to read (default: the main branch)
+}
+
+// GitEngineOverride selects a git engine for repositories matching a pattern.
+type GitEngineOverride struct {
+	Pattern string `yaml:"pattern"` // Glob matched against the repo slug
+	Engine  string `yaml:"engine"`
+}
+
+// EngineFor returns the git engine to use for a repository slug.
+func (g GitConfig) EngineFor(repoSlug string) string {
+	for _, o := range g.Overrides {
+		if matched, _ := filepath.Match(o.Pattern, repoSlug); matched {
+			return o.Engine
+		}
+	}
+	if g.Engine == "" {
+		return "auto"
+	}
+	return g.Engine
+}
+
+// Entity kinds that backup.field_masks apply to.
+const (
+	FieldMaskPullRequests = "pull_requests"
+	FieldMaskIssues       = "issues"
+	FieldMaskComments     = "comments" // Pull request and issue comments
+	FieldMaskActivity     = "activity" // Pull request activity
+	FieldMaskTasks        = "tasks"    // Pull request tasks
+)
+
+// validFieldMaskKind reports whether kind is a backup.field_masks key.
+func validFieldMaskKind(kind string) bool {
+	switch kind {
+	case FieldMaskPullRequests, FieldMaskIssues, FieldMaskComments, FieldMaskActivity, FieldMaskTasks:
+		return true
+	}
+	return false
+}
+
+// CloneURL rewrites an HTTPS clone URL onto BaseURL, keeping its path.
+// Embedded credentials are dropped, as they are supplied separately.
+// The URL is returned as is when BaseURL is unset or it cannot be parsed.
+func (g GitConfig) CloneURL(cloneURL string) string {
+	if g.BaseURL == "" || cloneURL == "" {
+		return cloneURL
+	}
+	u, err := url.Parse(cloneURL)
+	if err != nil {
+		return cloneURL
+	}
+	return strings.TrimRight(g.BaseURL, "/") + u.EscapedPath()
+}
+
+// ScanConfig holds content policy scanning settings.
+type ScanConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Scanner       string `yaml:"scanner"`          // "secrets" (built-in) or "command"
+	Command       string `yaml:"command"`          // External scanner command (scanner: command)
+	MaxFileSizeKB int    `yaml:"max_file_size_kb"` // Skip blobs larger than this (default: 1024)
+
+	// PackCommand is a malware scanner run against the object files each
+	// fetch adds, whether or not enabled is set. If it flags them the
+	// update is quarantined and the mirror kept as it was.
+	PackCommand string `yaml:"pack_command"`
+}
+
+// DefaultRunTimestampFormat names run directories like
+// 2024-01-15T10-30-00Z, the UTC start time with dashes for colons.
+const DefaultRunTimestampFormat = "2006-01-02T15-04-05Z"
+
 // Default returns a Config with sensible default values.
 func Default() *Config {
 	return &Config{
@@ -82,35 +705,84 @@ func Default() *Config {
 			Method: "app_password",
 		},
 		Storage: StorageConfig{
-			Type: "local",
-			Path: "./backups",
+			Type:                 "local",
+			Path:                 "./backups",
+			FlushIntervalSeconds: 5,
+			QuotaAction:          "warn",
 		},
 		RateLimit: RateLimitConfig{
-			RequestsPerHour:        900,
-			BurstSize:              10,
-			MaxRetries:             5,
-			RetryBackoffSeconds:    5,
-			RetryBackoffMultiplier: 2.0,
-			MaxBackoffSeconds:      300,
+			RequestsPerHour:           900,
+			BurstSize:                 10,
+			MaxRetries:                5,
+			RetryBackoffSeconds:       5,
+			RetryBackoffMultiplier:    2.0,
+			MaxBackoffSeconds:         300,
+			MaxMaintenanceWaitMinutes: 60,
 		},
 		Parallelism: ParallelismConfig{
 			GitWorkers: adaptiveWorkerCount(),
 			APIWorkers: 2,
 		},
 		Backup: BackupConfig{
-			IncludePRs:           true,
-			IncludePRComments:    true,
-			IncludePRActivity:    true,
-			IncludeIssues:        true,
-			IncludeIssueComments: true,
-			ExcludeRepos:         []string{},
-			IncludeRepos:         []string{},
-			GitTimeoutMinutes:    30, // 30 minute default timeout for git operations
+			IncludePRs:                true,
+			IncludePRComments:         true,
+			IncludePRActivity:         true,
+			IncludeIssues:             true,
+			IncludeIssueComments:      true,
+			ExcludeRepos:              []string{},
+			IncludeRepos:              []string{},
+			GitTimeoutMinutes:         30, // 30 minute default timeout for git operations
+			ArchivedRepos:             "last",
+			CheckpointRepos:           50,
+			CheckpointIntervalSeconds: 120,
+			ShutdownTimeoutSeconds:    5,
+			QuarantineRuns:            1,
+			QuarantineMaxRuns:         16,
+			RunTimestampFormat:        DefaultRunTimestampFormat,
 		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "text",
 		},
+		Scan: ScanConfig{
+			Scanner:       "secrets",
+			MaxFileSizeKB: 1024,
+		},
+		Git: GitConfig{
+			Engine:         "auto",
+			DetectRewrites: true,
+		},
+		Progress: ProgressConfig{
+			BatchSize:            50,
+			FlushIntervalSeconds: 5,
+			MaxRetries:           3,
+			MaxQueued:            1000,
+		},
+		SLO: SLOConfig{
+			MaxFailedRepos: -1,
+		},
+		Notifications: NotificationsConfig{
+			When:       NotifyAlways,
+			MaxRetries: 3,
+			Email: EmailNotification{
+				SMTPPort: 587,
+			},
+		},
+		Policy: PolicyConfig{
+			Path: ".bbbackup.yaml",
+		},
+		Metrics: MetricsConfig{
+			PushgatewayJob: "bb_backup",
+		},
+		Listen: ListenConfig{
+			Addr:         ":8080",
+			Path:         "/webhook",
+			DelaySeconds: 30,
+		},
+		Throttle: ThrottleConfig{
+			LoadThreshold: 1.0,
+			MinWorkers:    1,
+		},
 	}
 }
 
@@ -150,6 +822,14 @@ func Parse(data []byte) (*Config, error) {
 // envVarRegex matches ${VAR_NAME} patterns.
 var envVarRegex = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
 
+// projectKeyRegex matches a Bitbucket project key, which is placed in API
+// queries as is.
+var projectKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// repoSlugRegex matches a Bitbucket repository slug, which is always
+// lower case.
+var repoSlugRegex = regexp.MustCompile(`^[a-z0-9._-]+$`)
+
 // adaptiveWorkerCount returns optimal worker count based on CPU cores.
 // Uses 2x CPU cores (git is I/O bound), clamped between 4 and 16.
 func adaptiveWorkerCount() int {
@@ -198,7 +878,11 @@ func (c *Config) GetAPICredentials() (username, password string) {
 
 // GetGitCredentials returns the username and password/token for git operations.
 // For API tokens, git requires the Bitbucket username (not email).
+// auth.git_username and auth.git_password replace them when set.
 func (c *Config) GetGitCredentials() (username, password string) {
+	if c.Auth.GitPassword != "" {
+		return c.Auth.GitUsername, c.Auth.GitPassword
+	}
 	switch c.Auth.Method {
 	case "app_password":
 		return c.Auth.Username, c.Auth.AppPassword
@@ -213,52 +897,357 @@ func (c *Config) GetGitCredentials() (username, password string) {
 	}
 }
 
-// Validate checks that the configuration is valid.
-func (c *Config) Validate() error {
+// repoTokenSetPrefix starts the names of the credential sets made from
+// repo_tokens, so they cannot clash with the credentials entries.
+const repoTokenSetPrefix = "repo:"
+
+// CredentialSetFor returns the name of the credential set for a
+// repository, or "" for the main auth credentials. A repository with a
+// token in repo_tokens uses it ahead of any credentials entry.
+func (c *Config) CredentialSetFor(projectKey, slug string) string {
+	if _, ok := c.RepoTokens[slug]; ok {
+		return repoTokenSetPrefix + slug
+	}
+	for _, set := range c.Credentials {
+		for _, key := range set.Projects {
+			if projectKey != "" && strings.EqualFold(key, projectKey) {
+				return set.Name
+			}
+		}
+		for _, pattern := range set.Repos {
+			if matched, _ := filepath.Match(pattern, slug); matched {
+				return set.Name
+			}
+		}
+	}
+	return ""
+}
+
+// CredentialSetNames returns the names of the credentials entries followed
+// by those of the repo_tokens sets, in slug order.
+func (c *Config) CredentialSetNames() []string {
+	names := make([]string, 0, len(c.Credentials)+len(c.RepoTokens))
+	for _, set := range c.Credentials {
+		names = append(names, set.Name)
+	}
+	for _, slug := range c.repoTokenSlugs() {
+		names = append(names, repoTokenSetPrefix+slug)
+	}
+	return names
+}
+
+// repoTokenSlugs returns the repositories in repo_tokens, sorted.
+func (c *Config) repoTokenSlugs() []string {
+	slugs := make([]string, 0, len(c.RepoTokens))
+	for slug := range c.RepoTokens {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+// RepoTokenSlug returns the repository of a credential set made from
+// repo_tokens, and false for other names.
+func (c *Config) RepoTokenSlug(name string) (string, bool) {
+	slug, ok := strings.CutPrefix(name, repoTokenSetPrefix)
+	if !ok {
+		return "", false
+	}
+	_, ok = c.RepoTokens[slug]
+	return slug, ok
+}
+
+// WithCredentialSet returns a copy of the config whose auth is the named
+// credential set's. The copy shares everything else with c.
+func (c *Config) WithCredentialSet(name string) *Config {
+	if slug, ok := c.RepoTokenSlug(name); ok {
+		copied := *c
+		copied.Auth = AuthConfig{Method: "access_token", AccessToken: c.RepoTokens[slug]}
+		return &copied
+	}
+	for _, set := range c.Credentials {
+		if set.Name == name {
+			copied := *c
+			copied.Auth = set.AuthConfig
+			return &copied
+		}
+	}
+	return c
+}
+
+// ForWorkspace returns the configuration for the workspaces entry whose
+// alias or slug is name: a copy of c with its slug as workspace, its path
+// as storage.path, and its retention and notifications when set. Without
+// workspaces, c is for the single workspace it names, and a non-empty name
+// replaces it.
+func (c *Config) ForWorkspace(name string) (*Config, error) {
+	if len(c.Workspaces) == 0 {
+		if name == "" || name == c.Workspace {
+			return c, nil
+		}
+		copied := *c
+		copied.Workspace = name
+		return &copied, nil
+	}
+	if name == "" {
+		if len(c.Workspaces) > 1 {
+			return nil, fmt.Errorf("the config lists %d workspaces (%s); choose one with --workspace", len(c.Workspaces), strings.Join(c.WorkspaceNames(), ", "))
+		}
+		name = c.Workspaces[0].Name()
+	}
+	for _, w := range c.Workspaces {
+		if w.Name() != name && w.Slug != name {
+			continue
+		}
+		copied := *c
+		copied.Workspaces = nil
+		copied.Workspace = w.Slug
+		copied.WorkspaceAlias = w.Name()
+		if w.Path != "" {
+			copied.Storage.Path = w.Path
+		}
+		if w.Retention != nil {
+			copied.Retention = *w.Retention
+		}
+		if w.Notifications != nil {
+			copied.Notifications = *w.Notifications
+		}
+		return &copied, nil
+	}
+	return nil, fmt.Errorf("workspace '%s' is not in the config's workspaces (%s)", name, strings.Join(c.WorkspaceNames(), ", "))
+}
+
+// WorkspaceConfigs returns the configuration of every workspace c backs
+// up, in the order listed: c itself without workspaces.
+func (c *Config) WorkspaceConfigs() []*Config {
+	if len(c.Workspaces) == 0 {
+		return []*Config{c}
+	}
+	configs := make([]*Config, 0, len(c.Workspaces))
+	for _, w := range c.Workspaces {
+		// Names are validated unique, so every lookup succeeds
+		wc, _ := c.ForWorkspace(w.Name())
+		configs = append(configs, wc)
+	}
+	return configs
+}
+
+// WorkspaceNames returns the names of the workspaces entries.
+func (c *Config) WorkspaceNames() []string {
+	names := make([]string, 0, len(c.Workspaces))
+	for _, w := range c.Workspaces {
+		names = append(names, w.Name())
+	}
+	return names
+}
+
+func (c *Config) validateWorkspaces() []string {
+	var errs []string
+	if c.Workspace != "" {
+		errs = append(errs, "workspace and workspaces cannot both be set; list the workspace under workspaces")
+	}
+	seen := make(map[string]bool)
+	for i, w := range c.Workspaces {
+		key := fmt.Sprintf("workspaces[%d]", i)
+		if w.Slug == "" {
+			errs = append(errs, key+".slug is required")
+			continue
+		}
+		key = "workspaces." + w.Name()
+		if strings.ContainsAny(w.Slug, "/ ") {
+			errs = append(errs, fmt.Sprintf("%s.slug must be a workspace slug, got '%s'", key, w.Slug))
+		}
+		if strings.ContainsAny(w.Alias, "/ ") {
+			errs = append(errs, fmt.Sprintf("%s.alias must not contain '/' or spaces, got '%s'", key, w.Alias))
+		}
+		// Aliases and slugs share a namespace, as --workspace takes either
+		for _, name := range []string{w.Name(), w.Slug} {
+			if seen[name] {
+				errs = append(errs, fmt.Sprintf("workspaces: '%s' names more than one workspace", name))
+			}
+			seen[name] = true
+			if w.Name() == w.Slug {
+				break
+			}
+		}
+		if w.Retention != nil {
+			errs = append(errs, validateRetention(key+".retention", *w.Retention)...)
+		}
+		if w.Notifications != nil {
+			errs = append(errs, validateNotifications(key+".notifications", *w.Notifications)...)
+		}
+	}
+	return errs
+}
+
+func (c *Config) validateCredentialSets() []string {
 	var errs []string
+	seen := make(map[string]bool)
+	for i, set := range c.Credentials {
+		key := fmt.Sprintf("credentials[%d]", i)
+		if set.Name == "" {
+			errs = append(errs, key+".name is required")
+		} else {
+			if seen[set.Name] {
+				errs = append(errs, fmt.Sprintf("credentials: duplicate name '%s'", set.Name))
+			}
+			seen[set.Name] = true
+			key = "credentials." + set.Name
+			if strings.HasPrefix(set.Name, repoTokenSetPrefix) {
+				errs = append(errs, fmt.Sprintf("%s: names starting with '%s' are reserved for repo_tokens", key, repoTokenSetPrefix))
+			}
+		}
+		if len(set.Projects) == 0 && len(set.Repos) == 0 {
+			errs = append(errs, key+" must list projects or repos to use it for")
+		}
+		for j, pattern := range set.Repos {
+			if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+				errs = append(errs, fmt.Sprintf("%s.repos[%d] is not a valid glob: '%s'", key, j, pattern))
+			}
+		}
+		errs = append(errs, validateAuth(key, set.AuthConfig)...)
+	}
 
-	if c.Workspace == "" {
-		errs = append(errs, "workspace is required")
+	for _, slug := range c.repoTokenSlugs() {
+		if !repoSlugRegex.MatchString(slug) {
+			errs = append(errs, fmt.Sprintf("repo_tokens: '%s' is not a repository slug", slug))
+		}
+		if c.RepoTokens[slug] == "" {
+			errs = append(errs, fmt.Sprintf("repo_tokens.%s needs an access token", slug))
+		}
 	}
+	return errs
+}
 
-	// Validate auth
-	switch c.Auth.Method {
+// validateAuth checks the settings for an auth method. key names the
+// section in errors.
+func validateAuth(key string, a AuthConfig) []string {
+	var errs []string
+	switch a.Method {
 	case "app_password":
 		// Deprecated but still supported for backward compatibility
-		if c.Auth.Username == "" {
-			errs = append(errs, "auth.username is required for app_password method")
+		if a.Username == "" {
+			errs = append(errs, key+".username is required for app_password method")
 		}
-		if c.Auth.AppPassword == "" {
-			errs = append(errs, "auth.app_password is required for app_password method")
+		if a.AppPassword == "" && a.CredentialCommand == "" {
+			errs = append(errs, key+".app_password or "+key+".credential_command is required for app_password method")
 		}
 	case "api_token":
 		// Personal API token - requires username for API, email for git
-		if c.Auth.Username == "" {
-			errs = append(errs, "auth.username is required for api_token method")
+		if a.Username == "" {
+			errs = append(errs, key+".username is required for api_token method")
 		}
-		if c.Auth.APIToken == "" {
-			errs = append(errs, "auth.api_token is required for api_token method")
+		if a.APIToken == "" && a.CredentialCommand == "" {
+			errs = append(errs, key+".api_token or "+key+".credential_command is required for api_token method")
 		}
-		if c.Auth.Email == "" {
-			errs = append(errs, "auth.email is required for api_token method (used for git operations)")
+		if a.Email == "" {
+			errs = append(errs, key+".email is required for api_token method (used for git operations)")
 		}
 	case "access_token":
 		// Repository/Project/Workspace access token - no username needed
-		if c.Auth.AccessToken == "" {
-			errs = append(errs, "auth.access_token is required for access_token method")
+		if a.AccessToken == "" && a.CredentialCommand == "" {
+			errs = append(errs, key+".access_token or "+key+".credential_command is required for access_token method")
 		}
 	case "oauth":
-		if c.Auth.ClientID == "" {
-			errs = append(errs, "auth.client_id is required for oauth method")
+		if a.ClientID == "" {
+			errs = append(errs, key+".client_id is required for oauth method")
+		}
+		if a.ClientSecret == "" {
+			errs = append(errs, key+".client_secret is required for oauth method")
 		}
-		if c.Auth.ClientSecret == "" {
-			errs = append(errs, "auth.client_secret is required for oauth method")
+		if a.CredentialCommand != "" {
+			errs = append(errs, key+".credential_command is not supported with the oauth method")
 		}
 	case "":
-		errs = append(errs, "auth.method is required")
+		errs = append(errs, key+".method is required")
+	default:
+		errs = append(errs, fmt.Sprintf("%s.method must be 'app_password', 'api_token', 'access_token', or 'oauth', got '%s'", key, a.Method))
+	}
+	if (a.GitUsername == "") != (a.GitPassword == "") {
+		errs = append(errs, key+".git_username and "+key+".git_password must be set together")
+	}
+	return errs
+}
+
+// validateNotifications checks a notifications section. key names the
+// section in errors.
+func validateNotifications(key string, n NotificationsConfig) []string {
+	var errs []string
+	switch n.When {
+	case NotifyAlways, NotifyFailure, NotifySuccess:
 	default:
-		errs = append(errs, fmt.Sprintf("auth.method must be 'app_password', 'api_token', 'access_token', or 'oauth', got '%s'", c.Auth.Method))
+		errs = append(errs, fmt.Sprintf("%s.when must be '%s', '%s', or '%s', got '%s'", key, NotifyAlways, NotifyFailure, NotifySuccess, n.When))
+	}
+	if n.MaxRetries < 0 {
+		errs = append(errs, key+".max_retries must not be negative")
 	}
+	if u := n.Slack.WebhookURL; u != "" && !httpURL(u) {
+		errs = append(errs, fmt.Sprintf("%s.slack.webhook_url must be an http or https URL, got '%s'", key, u))
+	}
+	if u := n.Webhook.URL; u != "" && !httpURL(u) {
+		errs = append(errs, fmt.Sprintf("%s.webhook.url must be an http or https URL, got '%s'", key, u))
+	}
+	if email := n.Email; email.SMTPHost != "" {
+		if email.SMTPPort < 1 || email.SMTPPort > 65535 {
+			errs = append(errs, fmt.Sprintf("%s.email.smtp_port must be between 1 and 65535, got %d", key, email.SMTPPort))
+		}
+		if _, err := mail.ParseAddress(email.From); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.email.from must be an email address, got '%s'", key, email.From))
+		}
+		if len(email.To) == 0 {
+			errs = append(errs, key+".email.to must list at least one address")
+		}
+		for i, to := range email.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.email.to[%d] must be an email address, got '%s'", key, i, to))
+			}
+		}
+		if email.Password != "" && email.Username == "" {
+			errs = append(errs, key+".email.password requires "+key+".email.username")
+		}
+	}
+	return errs
+}
+
+// validateRetention checks a retention section. key names the section in
+// errors.
+func validateRetention(key string, r RetentionConfig) []string {
+	var errs []string
+	if r.KeepDays < 0 {
+		errs = append(errs, key+".keep_days must be 0 (keep forever) or more")
+	}
+	if r.KeepRuns < 0 {
+		errs = append(errs, key+".keep_runs must be 0 (no limit) or more")
+	}
+	classNames := make([]string, 0, len(r.Classes))
+	for name := range r.Classes {
+		classNames = append(classNames, name)
+	}
+	sort.Strings(classNames)
+	for _, name := range classNames {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, key+".classes must not contain an empty class name")
+		}
+		if r.Classes[name].KeepDays < 0 {
+			errs = append(errs, fmt.Sprintf("%s.classes.%s.keep_days must be 0 (keep forever) or more", key, name))
+		}
+	}
+	return errs
+}
+
+// Validate checks that the configuration is valid.
+func (c *Config) Validate() error {
+	var errs []string
+
+	if len(c.Workspaces) > 0 {
+		errs = append(errs, c.validateWorkspaces()...)
+	} else if c.Workspace == "" {
+		errs = append(errs, "workspace is required")
+	}
+
+	// Validate auth
+	errs = append(errs, validateAuth("auth", c.Auth)...)
+	errs = append(errs, c.validateCredentialSets()...)
 
 	// Validate storage
 	switch c.Storage.Type {
@@ -266,10 +1255,50 @@ func (c *Config) Validate() error {
 		if c.Storage.Path == "" {
 			errs = append(errs, "storage.path is required for local storage")
 		}
+	case "s3":
+		if c.Storage.Path == "" {
+			errs = append(errs, "storage.path is required for s3 storage (it holds the working copy and git mirrors)")
+		}
+		if c.Storage.S3.Bucket == "" {
+			errs = append(errs, "storage.s3.bucket is required for s3 storage")
+		}
+		if c.Storage.S3.Region == "" {
+			errs = append(errs, "storage.s3.region is required for s3 storage")
+		}
+		if c.Storage.S3.Endpoint != "" && !httpURL(c.Storage.S3.Endpoint) {
+			errs = append(errs, fmt.Sprintf("storage.s3.endpoint must be an http or https URL, got '%s'", c.Storage.S3.Endpoint))
+		}
+		if c.Backup.AtomicLatest {
+			// A bucket has no rename to swap latest/ in with
+			errs = append(errs, "backup.atomic_latest is not supported with storage.type 's3'")
+		}
 	case "":
 		errs = append(errs, "storage.type is required")
 	default:
-		errs = append(errs, fmt.Sprintf("storage.type must be 'local', got '%s'", c.Storage.Type))
+		errs = append(errs, fmt.Sprintf("storage.type must be 'local' or 's3', got '%s'", c.Storage.Type))
+	}
+
+	if c.Storage.FlushIntervalSeconds < 0 {
+		errs = append(errs, "storage.flush_interval_seconds must be non-negative")
+	}
+	if c.Storage.MaxWorkspaceSize != "" {
+		if n, err := format.ParseBytes(c.Storage.MaxWorkspaceSize); err != nil || n <= 0 {
+			errs = append(errs, fmt.Sprintf("storage.max_workspace_size must be a size such as '500GB', got '%s'", c.Storage.MaxWorkspaceSize))
+		}
+	}
+	switch c.Storage.QuotaAction {
+	case "warn", "block":
+	default:
+		errs = append(errs, fmt.Sprintf("storage.quota_action must be 'warn' or 'block', got '%s'", c.Storage.QuotaAction))
+	}
+	switch c.Storage.Archive {
+	case "":
+		if c.Storage.ArchiveRemove {
+			errs = append(errs, "storage.archive_remove requires storage.archive")
+		}
+	case ArchiveTarGz, ArchiveZip:
+	default:
+		errs = append(errs, fmt.Sprintf("storage.archive must be 'tar.gz' or 'zip', got '%s'", c.Storage.Archive))
 	}
 
 	// Validate rate limit
@@ -282,6 +1311,9 @@ func (c *Config) Validate() error {
 	if c.RateLimit.MaxRetries < 0 {
 		errs = append(errs, "rate_limit.max_retries must be non-negative")
 	}
+	if c.RateLimit.MaxMaintenanceWaitMinutes < 0 {
+		errs = append(errs, "rate_limit.max_maintenance_wait_minutes must be non-negative")
+	}
 
 	// Validate parallelism
 	if c.Parallelism.GitWorkers <= 0 {
@@ -290,6 +1322,9 @@ func (c *Config) Validate() error {
 	if c.Parallelism.APIWorkers <= 0 {
 		errs = append(errs, "parallelism.api_workers must be positive")
 	}
+	if c.Parallelism.MaxPerProject < 0 {
+		errs = append(errs, "parallelism.max_per_project must not be negative")
+	}
 
 	// Validate logging
 	switch c.Logging.Level {
@@ -306,9 +1341,387 @@ func (c *Config) Validate() error {
 		errs = append(errs, fmt.Sprintf("logging.format must be text/json, got '%s'", c.Logging.Format))
 	}
 
+	switch c.Backup.ArchivedRepos {
+	case "include", "last", "skip":
+	default:
+		errs = append(errs, fmt.Sprintf("backup.archived_repos must be 'include', 'last', or 'skip', got '%s'", c.Backup.ArchivedRepos))
+	}
+
+	if layout := c.Backup.RunTimestampFormat; layout != "" {
+		sample := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC).Format(layout)
+		parsed, err := time.Parse(layout, sample)
+		switch {
+		case strings.ContainsAny(sample, `/\:`):
+			errs = append(errs, fmt.Sprintf("backup.run_timestamp_format must not produce '/', '\\', or ':', got '%s'", sample))
+		case err != nil || parsed.Format(layout) != sample || sample == layout:
+			errs = append(errs, fmt.Sprintf("backup.run_timestamp_format must be a Go time layout such as '%s', got '%s'", DefaultRunTimestampFormat, layout))
+		}
+	}
+
+	kinds := make([]string, 0, len(c.Backup.FieldMasks))
+	for kind := range c.Backup.FieldMasks {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		if !validFieldMaskKind(kind) {
+			errs = append(errs, fmt.Sprintf("backup.field_masks: unknown kind '%s' (want pull_requests, issues, comments, activity, or tasks)", kind))
+			continue
+		}
+		for _, mask := range c.Backup.FieldMasks[kind] {
+			if strings.Contains("."+mask+".", "..") || mask == "**" || strings.HasSuffix(mask, ".**") {
+				errs = append(errs, fmt.Sprintf("backup.field_masks.%s: '%s' is not a field path such as 'summary.html' or '**.links'", kind, mask))
+			}
+		}
+	}
+
+	if c.Backup.DownloadsMaxSize != "" {
+		if n, err := format.ParseBytes(c.Backup.DownloadsMaxSize); err != nil || n <= 0 {
+			errs = append(errs, fmt.Sprintf("backup.downloads_max_size must be a size such as '500MB', got '%s'", c.Backup.DownloadsMaxSize))
+		}
+	}
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"downloads_include", c.Backup.DownloadsInclude}, {"downloads_exclude", c.Backup.DownloadsExclude}} {
+		for _, pattern := range list.patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+				errs = append(errs, fmt.Sprintf("backup.%s: '%s' is not a valid glob", list.name, pattern))
+			}
+		}
+	}
+
+	for _, key := range c.Backup.IncludeProjects {
+		if !projectKeyRegex.MatchString(key) {
+			errs = append(errs, fmt.Sprintf("backup.include_projects: '%s' is not a project key (letters, digits, and underscores; no wildcards)", key))
+		}
+	}
+
+	if c.Backup.CheckpointRepos < 0 {
+		errs = append(errs, "backup.checkpoint_repos must be non-negative")
+	}
+	if c.Backup.CheckpointIntervalSeconds < 0 {
+		errs = append(errs, "backup.checkpoint_interval_seconds must be non-negative")
+	}
+	if c.Backup.ShutdownTimeoutSeconds < 0 {
+		errs = append(errs, "backup.shutdown_timeout_seconds must be non-negative")
+	}
+	if c.Backup.QuarantineAfter < 0 {
+		errs = append(errs, "backup.quarantine_after must be non-negative")
+	}
+	if c.Backup.QuarantineRuns < 1 {
+		errs = append(errs, "backup.quarantine_runs must be at least 1")
+	}
+	if c.Backup.QuarantineMaxRuns < c.Backup.QuarantineRuns {
+		errs = append(errs, "backup.quarantine_max_runs must be at least backup.quarantine_runs")
+	}
+
+	// Validate scan
+	if c.Scan.Enabled {
+		switch c.Scan.Scanner {
+		case "secrets":
+			// valid
+		case "command":
+			if c.Scan.Command == "" {
+				errs = append(errs, "scan.command is required for command scanner")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("scan.scanner must be 'secrets' or 'command', got '%s'", c.Scan.Scanner))
+		}
+	}
+
+	// Validate git engine
+	if !validGitEngine(c.Git.Engine) {
+		errs = append(errs, fmt.Sprintf("git.engine must be 'auto', 'gogit', or 'cli', got '%s'", c.Git.Engine))
+	}
+	for i, o := range c.Git.Overrides {
+		if _, err := filepath.Match(o.Pattern, ""); err != nil || o.Pattern == "" {
+			errs = append(errs, fmt.Sprintf("git.overrides[%d].pattern is not a valid glob: '%s'", i, o.Pattern))
+		}
+		if o.Engine == "" || !validGitEngine(o.Engine) {
+			errs = append(errs, fmt.Sprintf("git.overrides[%d].engine must be 'auto', 'gogit', or 'cli', got '%s'", i, o.Engine))
+		}
+	}
+
+	if c.Git.SSHFallback {
+		if c.Git.SSHKeyPath == "" {
+			errs = append(errs, "git.ssh_key_path is required when git.ssh_fallback is enabled")
+		}
+		if c.Git.Engine == "gogit" {
+			errs = append(errs, "git.ssh_fallback requires the git CLI and cannot be used with git.engine 'gogit'")
+		}
+	}
+	if c.Git.ForkAlternates && c.Git.Engine == "gogit" {
+		errs = append(errs, "git.fork_alternates requires the git CLI and cannot be used with git.engine 'gogit'")
+	}
+
+	if c.Git.BaseURL != "" && !httpURL(c.Git.BaseURL) {
+		errs = append(errs, fmt.Sprintf("git.base_url must be an http or https URL, got '%s'", c.Git.BaseURL))
+	}
+	if c.API.BaseURL != "" && !httpURL(c.API.BaseURL) {
+		errs = append(errs, fmt.Sprintf("api.base_url must be an http or https URL, got '%s'", c.API.BaseURL))
+	}
+	switch c.API.Type {
+	case "", APITypeCloud:
+	case APITypeServer:
+		if c.API.BaseURL == "" {
+			errs = append(errs, "api.base_url is required with api.type 'server'")
+		}
+		if c.Backup.RawMode {
+			errs = append(errs, "backup.raw_mode is not supported with api.type 'server'")
+		}
+		if c.Backup.IncludePolicies {
+			errs = append(errs, "backup.include_policies is not supported with api.type 'server'")
+		}
+		if c.Backup.IncludeSettings {
+			errs = append(errs, "backup.include_settings is not supported with api.type 'server'")
+		}
+		if c.Backup.IncludeIntegrations {
+			errs = append(errs, "backup.include_integrations is not supported with api.type 'server'")
+		}
+		if len(c.RepoTokens) > 0 {
+			errs = append(errs, "repo_tokens is not supported with api.type 'server'")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("api.type must be 'cloud' or 'server', got '%s'", c.API.Type))
+	}
+
+	if c.Alerts.WebhookURL != "" {
+		if u, err := url.Parse(c.Alerts.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("alerts.webhook_url must be an http or https URL, got '%s'", c.Alerts.WebhookURL))
+		}
+	}
+
+	errs = append(errs, validateNotifications("notifications", c.Notifications)...)
+
+	if c.Progress.WebhookURL != "" {
+		if u, err := url.Parse(c.Progress.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("progress.webhook_url must be an http or https URL, got '%s'", c.Progress.WebhookURL))
+		}
+		if c.Progress.BatchSize < 1 {
+			errs = append(errs, "progress.batch_size must be at least 1")
+		}
+		if c.Progress.FlushIntervalSeconds < 1 {
+			errs = append(errs, "progress.flush_interval_seconds must be at least 1")
+		}
+		if c.Progress.MaxRetries < 0 {
+			errs = append(errs, "progress.max_retries must be non-negative")
+		}
+		if c.Progress.MaxQueued < c.Progress.BatchSize {
+			errs = append(errs, "progress.max_queued must be at least progress.batch_size")
+		}
+	}
+
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			errs = append(errs, fmt.Sprintf("metrics.listen must be a host:port address, got '%s'", c.Metrics.Listen))
+		}
+	}
+	if c.Metrics.PushgatewayURL != "" {
+		if !httpURL(c.Metrics.PushgatewayURL) {
+			errs = append(errs, fmt.Sprintf("metrics.pushgateway_url must be an http or https URL, got '%s'", c.Metrics.PushgatewayURL))
+		}
+		if c.Metrics.PushgatewayJob == "" || strings.Contains(c.Metrics.PushgatewayJob, "/") {
+			errs = append(errs, fmt.Sprintf("metrics.pushgateway_job must be a non-empty name without '/', got '%s'", c.Metrics.PushgatewayJob))
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Listen.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("listen.addr must be a host:port address, got '%s'", c.Listen.Addr))
+	}
+	if !strings.HasPrefix(c.Listen.Path, "/") {
+		errs = append(errs, fmt.Sprintf("listen.path must start with '/', got '%s'", c.Listen.Path))
+	}
+	if c.Listen.DelaySeconds < 0 {
+		errs = append(errs, "listen.delay_seconds must be non-negative")
+	}
+
+	switch c.Throttle.IOClass {
+	case "", "best-effort", "idle":
+	default:
+		errs = append(errs, fmt.Sprintf("throttle.io_class must be 'best-effort' or 'idle', got '%s'", c.Throttle.IOClass))
+	}
+	if c.Throttle.CPUNice < 0 || c.Throttle.CPUNice > 19 {
+		errs = append(errs, fmt.Sprintf("throttle.cpu_nice must be between 0 and 19, got %d", c.Throttle.CPUNice))
+	}
+	if c.Throttle.CPUPercent < 0 || c.Throttle.CPUPercent > 100 {
+		errs = append(errs, fmt.Sprintf("throttle.cpu_percent must be between 0 (no cap) and 100, got %d", c.Throttle.CPUPercent))
+	}
+	if c.Throttle.Nice && c.Throttle.LoadThreshold <= 0 {
+		errs = append(errs, "throttle.load_threshold must be positive with throttle.nice")
+	}
+	if c.Throttle.MinWorkers < 1 {
+		errs = append(errs, "throttle.min_workers must be at least 1")
+	}
+
+	for _, slo := range []struct{ name, value string }{
+		{"slo.max_duration", c.SLO.MaxDuration},
+		{"slo.max_staleness", c.SLO.MaxStaleness},
+	} {
+		if slo.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(slo.value); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be a positive duration such as '2h', got '%s'", slo.name, slo.value))
+		}
+	}
+
+	dropped := make(map[string]bool, len(c.Privacy.DropFields))
+	for _, f := range c.Privacy.DropFields {
+		if !validPrivacyField(f) {
+			errs = append(errs, fmt.Sprintf("privacy.drop_fields: '%s' must be a field name or parent.field", f))
+		}
+		dropped[f] = true
+	}
+	for _, f := range c.Privacy.HashFields {
+		if !validPrivacyField(f) {
+			errs = append(errs, fmt.Sprintf("privacy.hash_fields: '%s' must be a field name or parent.field", f))
+		}
+		if dropped[f] {
+			errs = append(errs, fmt.Sprintf("privacy: '%s' is in both drop_fields and hash_fields", f))
+		}
+	}
+	if len(c.Privacy.HashFields) > 0 && c.Privacy.HashSalt == "" {
+		errs = append(errs, "privacy.hash_salt is required with hash_fields; unsalted hashes of names can be reversed by guessing")
+	}
+
+	errs = append(errs, validateRetention("retention", c.Retention)...)
+
+	if c.Policy.Repository != "" {
+		if strings.ContainsAny(c.Policy.Repository, "/ ") {
+			errs = append(errs, fmt.Sprintf("policy.repository must be a repository slug in the workspace, got '%s'", c.Policy.Repository))
+		}
+		if c.Policy.Path == "" || path.IsAbs(c.Policy.Path) || path.Clean(c.Policy.Path) != c.Policy.Path || c.Policy.Path == ".." || strings.HasPrefix(c.Policy.Path, "../") {
+			errs = append(errs, fmt.Sprintf("policy.path must be a relative path in the repository, got '%s'", c.Policy.Path))
+		}
+	}
+
+	groupNames := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for _, name := range groupNames {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, "groups must not contain an empty group name")
+		}
+		if len(c.Groups[name]) == 0 {
+			errs = append(errs, fmt.Sprintf("groups.%s must list at least one pattern", name))
+		}
+		for i, pattern := range c.Groups[name] {
+			if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+				errs = append(errs, fmt.Sprintf("groups.%s[%d] is not a valid glob: '%s'", name, i, pattern))
+			}
+		}
+	}
+
+	budgeted := make(map[string]bool, len(c.Budgets))
+	for i, budget := range c.Budgets {
+		switch {
+		case (budget.Group == "") == (budget.Project == ""):
+			errs = append(errs, fmt.Sprintf("budgets[%d] must set exactly one of group and project", i))
+			continue
+		case budget.Group != "" && c.Groups[budget.Group] == nil:
+			errs = append(errs, fmt.Sprintf("budgets[%d].group '%s' is not defined in groups", i, budget.Group))
+		}
+		if budget.Minutes <= 0 {
+			errs = append(errs, fmt.Sprintf("budgets[%d].minutes must be positive", i))
+		}
+		if budgeted[budget.Name()] {
+			errs = append(errs, fmt.Sprintf("budgets[%d] repeats the budget for %s", i, budget.Name()))
+		}
+		budgeted[budget.Name()] = true
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("config validation failed:\n  - %s", strings.Join(errs, "\n  - "))
 	}
 
 	return nil
 }
+
+// GroupPatterns returns the combined repository patterns of the named
+// groups, in the order given and without duplicates.
+func (c *Config) GroupPatterns(names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var patterns []string
+	for _, name := range names {
+		groupPatterns, ok := c.Groups[name]
+		if !ok {
+			known := make([]string, 0, len(c.Groups))
+			for g := range c.Groups {
+				known = append(known, g)
+			}
+			sort.Strings(known)
+			if len(known) == 0 {
+				return nil, fmt.Errorf("unknown group %q: no groups are defined in the config", name)
+			}
+			return nil, fmt.Errorf("unknown group %q (defined: %s)", name, strings.Join(known, ", "))
+		}
+		for _, p := range groupPatterns {
+			if !seen[p] {
+				seen[p] = true
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// httpURL reports whether s is an absolute http or https URL.
+func httpURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validGitEngine reports whether engine is a known git engine. Empty means auto.
+func validGitEngine(engine string) bool {
+	switch engine {
+	case "", "auto", "gogit", "cli":
+		return true
+	}
+	return false
+}
+
+// validPrivacyField reports whether f is "key" or "parent.key".
+func validPrivacyField(f string) bool {
+	parts := strings.Split(f, ".")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, p := range parts {
+		if strings.TrimSpace(p) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Fingerprint returns a hash of the effective configuration with secrets
+// blanked, so runs can be matched to config changes without the manifest
+// revealing credentials. Rotating a token does not change it.
+func (c *Config) Fingerprint() string {
+	redacted := *c
+	redacted.Auth.AppPassword = ""
+	redacted.Auth.APIToken = ""
+	redacted.Auth.AccessToken = ""
+	redacted.Auth.ClientSecret = ""
+	redacted.Privacy.HashSalt = ""
+	redacted.Notifications.Email.Password = ""
+	redacted.Notifications.Slack.WebhookURL = "" // The URL is the credential
+	redacted.Notifications.Webhook.Headers = nil
+	if len(c.RepoTokens) > 0 {
+		redacted.RepoTokens = make(map[string]string, len(c.RepoTokens))
+		for slug := range c.RepoTokens {
+			redacted.RepoTokens[slug] = ""
+		}
+	}
+
+	// Maps marshal with sorted keys, so equal configs hash the same
+	data, err := yaml.Marshal(&redacted)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
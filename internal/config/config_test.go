@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -480,3 +483,1101 @@ func TestGetGitCredentials_AccessToken(t *testing.T) {
 		t.Errorf("expected password = 'repo-token', got '%s'", password)
 	}
 }
+
+func TestParse_ScanConfig(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+storage:
+  type: "local"
+  path: "/backups"
+`
+	tests := []struct {
+		name    string
+		scan    string
+		wantErr bool
+	}{
+		{"disabled", "scan:\n  enabled: false\n  scanner: bogus\n", false},
+		{"secrets", "scan:\n  enabled: true\n", false},
+		{"command", "scan:\n  enabled: true\n  scanner: command\n  command: ./scan.sh\n", false},
+		{"command missing", "scan:\n  enabled: true\n  scanner: command\n", true},
+		{"invalid scanner", "scan:\n  enabled: true\n  scanner: bogus\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(base + tt.scan))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.Scan.MaxFileSizeKB != 1024 {
+				t.Errorf("expected default scan.max_file_size_kb = 1024, got %d", cfg.Scan.MaxFileSizeKB)
+			}
+		})
+	}
+}
+
+func TestParse_GitEngine(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+storage:
+  type: "local"
+  path: "/backups"
+`
+	tests := []struct {
+		name    string
+		git     string
+		wantErr bool
+	}{
+		{"default", "", false},
+		{"cli", "git:\n  engine: cli\n", false},
+		{"invalid engine", "git:\n  engine: libgit2\n", true},
+		{"override", "git:\n  overrides:\n    - pattern: \"big-*\"\n      engine: cli\n", false},
+		{"override missing engine", "git:\n  overrides:\n    - pattern: \"big-*\"\n", true},
+		{"override bad pattern", "git:\n  overrides:\n    - pattern: \"[\"\n      engine: cli\n", true},
+		{"ssh fallback", "git:\n  ssh_fallback: true\n  ssh_key_path: /keys/id_ed25519\n", false},
+		{"ssh fallback without key", "git:\n  ssh_fallback: true\n", true},
+		{"ssh fallback with gogit", "git:\n  engine: gogit\n  ssh_fallback: true\n  ssh_key_path: /keys/id_ed25519\n", true},
+		{"fork alternates", "git:\n  fork_alternates: true\n", false},
+		{"fork alternates with gogit", "git:\n  engine: gogit\n  fork_alternates: true\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(base + tt.git))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGitConfig_EngineFor(t *testing.T) {
+	g := GitConfig{
+		Engine: "auto",
+		Overrides: []GitEngineOverride{
+			{Pattern: "big-*", Engine: "cli"},
+			{Pattern: "big-legacy", Engine: "gogit"},
+			{Pattern: "tiny", Engine: "gogit"},
+		},
+	}
+
+	tests := map[string]string{
+		"big-monorepo": "cli",
+		"big-legacy":   "cli", // first match wins
+		"tiny":         "gogit",
+		"other":        "auto",
+	}
+	for slug, want := range tests {
+		if got := g.EngineFor(slug); got != want {
+			t.Errorf("EngineFor(%q) = %q, want %q", slug, got, want)
+		}
+	}
+
+	if got := (GitConfig{}).EngineFor("x"); got != "auto" {
+		t.Errorf("expected empty engine to mean auto, got %q", got)
+	}
+}
+
+func TestParse_Groups(t *testing.T) {
+	yaml := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+storage:
+  type: "local"
+  path: "/backups"
+groups:
+  critical: ["core-*", "platform-*"]
+  archive: ["legacy-*", "core-*"]
+`
+	cfg, err := Parse([]byte(yaml))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	patterns, err := cfg.GroupPatterns([]string{"critical", "archive"})
+	if err != nil {
+		t.Fatalf("GroupPatterns() error = %v", err)
+	}
+	want := []string{"core-*", "platform-*", "legacy-*"}
+	if strings.Join(patterns, ",") != strings.Join(want, ",") {
+		t.Errorf("GroupPatterns() = %v, want %v", patterns, want)
+	}
+
+	_, err = cfg.GroupPatterns([]string{"nightly"})
+	if err == nil || !strings.Contains(err.Error(), "archive, critical") {
+		t.Errorf("expected unknown group error listing defined groups, got %v", err)
+	}
+}
+
+func TestValidate_InvalidGroups(t *testing.T) {
+	yaml := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+storage:
+  type: "local"
+  path: "/backups"
+groups:
+  empty: []
+  broken: ["core-["]
+`
+	_, err := Parse([]byte(yaml))
+	if err == nil {
+		t.Fatal("expected error for invalid groups")
+	}
+	for _, want := range []string{"groups.empty", "groups.broken[0]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestParse_Budgets(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+groups:
+  archive: ["legacy-*"]
+`
+	cfg, err := Parse([]byte(base + `
+budgets:
+  - group: archive
+    minutes: 30
+  - project: LEGACY
+    minutes: 60
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(cfg.Budgets) != 2 || cfg.Budgets[0].Name() != "group archive" || cfg.Budgets[1].Name() != "project LEGACY" || cfg.Budgets[1].Minutes != 60 {
+		t.Errorf("Budgets = %+v", cfg.Budgets)
+	}
+
+	for name, tc := range map[string]struct{ yaml, want string }{
+		"neither":       {"budgets:\n  - minutes: 5\n", "exactly one of group and project"},
+		"both":          {"budgets:\n  - group: archive\n    project: X\n    minutes: 5\n", "exactly one of group and project"},
+		"unknown group": {"budgets:\n  - group: nightly\n    minutes: 5\n", "'nightly' is not defined in groups"},
+		"no minutes":    {"budgets:\n  - project: X\n", "budgets[0].minutes must be positive"},
+		"repeated":      {"budgets:\n  - project: X\n    minutes: 5\n  - project: X\n    minutes: 9\n", "budgets[1] repeats the budget for project X"},
+	} {
+		_, err := Parse([]byte(base + tc.yaml))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Parse() error = %v, want it to mention %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestParse_Notifications(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + `
+notifications:
+  when: failure
+  slack:
+    webhook_url: "https://hooks.slack.com/services/T/B/X"
+  email:
+    smtp_host: "smtp.example.com"
+    username: "bot"
+    password: "secret"
+    from: "Backups <backups@example.com>"
+    to: ["ops@example.com"]
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	n := cfg.Notifications
+	if !n.Enabled() || n.When != NotifyFailure || n.MaxRetries != 3 || n.Email.SMTPPort != 587 {
+		t.Errorf("Notifications = %+v, want defaults for max_retries and smtp_port", n)
+	}
+
+	cfg, err = Parse([]byte(base))
+	if err != nil || cfg.Notifications.Enabled() || cfg.Notifications.When != NotifyAlways {
+		t.Errorf("default notifications = %+v, %v", cfg.Notifications, err)
+	}
+
+	for name, tc := range map[string]struct{ yaml, want string }{
+		"when":    {"notifications:\n  when: sometimes\n", "notifications.when"},
+		"retries": {"notifications:\n  max_retries: -1\n", "notifications.max_retries"},
+		"slack":   {"notifications:\n  slack:\n    webhook_url: \"hooks.slack.com/x\"\n", "notifications.slack.webhook_url"},
+		"webhook": {"notifications:\n  webhook:\n    url: \"ftp://example.com\"\n", "notifications.webhook.url"},
+		"to":      {"notifications:\n  email:\n    smtp_host: smtp\n    from: a@example.com\n", "notifications.email.to must list"},
+		"from":    {"notifications:\n  email:\n    smtp_host: smtp\n    from: nobody\n    to: [a@example.com]\n", "notifications.email.from"},
+		"port":    {"notifications:\n  email:\n    smtp_host: smtp\n    smtp_port: 70000\n    from: a@example.com\n    to: [a@example.com]\n", "notifications.email.smtp_port"},
+	} {
+		_, err := Parse([]byte(base + tc.yaml))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Parse() error = %v, want it to mention %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestParse_SLO(t *testing.T) {
+	yaml := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+slo:
+  max_duration: "2h"
+  max_failed_repos: 0
+`
+	cfg, err := Parse([]byte(yaml))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.SLO.MaxDuration != "2h" || cfg.SLO.MaxFailedRepos != 0 || cfg.SLO.MaxStaleness != "" {
+		t.Errorf("unexpected SLO config %+v", cfg.SLO)
+	}
+	if !cfg.SLO.Enabled() {
+		t.Error("expected SLO to be enabled")
+	}
+	if Default().SLO.Enabled() {
+		t.Error("expected SLO to be disabled by default")
+	}
+
+	_, err = Parse([]byte(yaml + "  max_staleness: \"a day\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "slo.max_staleness") {
+		t.Errorf("expected slo.max_staleness error, got %v", err)
+	}
+}
+
+func TestParse_Checkpoints(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backup.CheckpointRepos != 50 || cfg.Backup.CheckpointIntervalSeconds != 120 {
+		t.Errorf("unexpected checkpoint defaults: %d repos, %ds", cfg.Backup.CheckpointRepos, cfg.Backup.CheckpointIntervalSeconds)
+	}
+
+	cfg, err = Parse([]byte(base + "backup:\n  checkpoint_repos: 0\n  checkpoint_interval_seconds: 30\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backup.CheckpointRepos != 0 || cfg.Backup.CheckpointIntervalSeconds != 30 {
+		t.Errorf("checkpoint settings not applied: %+v", cfg.Backup)
+	}
+
+	_, err = Parse([]byte(base + "backup:\n  checkpoint_interval_seconds: -1\n"))
+	if err == nil || !strings.Contains(err.Error(), "backup.checkpoint_interval_seconds") {
+		t.Errorf("expected checkpoint_interval_seconds error, got %v", err)
+	}
+}
+
+func TestParse_ShutdownTimeout(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backup.ShutdownTimeoutSeconds != 5 {
+		t.Errorf("ShutdownTimeoutSeconds = %d, want default 5", cfg.Backup.ShutdownTimeoutSeconds)
+	}
+
+	cfg, err = Parse([]byte(base + "backup:\n  shutdown_timeout_seconds: 60\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backup.ShutdownTimeoutSeconds != 60 {
+		t.Errorf("ShutdownTimeoutSeconds = %d, want 60", cfg.Backup.ShutdownTimeoutSeconds)
+	}
+
+	_, err = Parse([]byte(base + "backup:\n  shutdown_timeout_seconds: -1\n"))
+	if err == nil || !strings.Contains(err.Error(), "backup.shutdown_timeout_seconds") {
+		t.Errorf("expected shutdown_timeout_seconds error, got %v", err)
+	}
+}
+
+func TestParse_Quarantine(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backup.QuarantineAfter != 0 || cfg.Backup.QuarantineRuns != 1 || cfg.Backup.QuarantineMaxRuns != 16 {
+		t.Errorf("unexpected quarantine defaults: %+v", cfg.Backup)
+	}
+
+	cfg, err = Parse([]byte(base + "backup:\n  quarantine_after: 3\n  quarantine_runs: 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Backup.QuarantineAfter != 3 || cfg.Backup.QuarantineRuns != 2 {
+		t.Errorf("quarantine settings not applied: %+v", cfg.Backup)
+	}
+
+	_, err = Parse([]byte(base + "backup:\n  quarantine_runs: 32\n"))
+	if err == nil || !strings.Contains(err.Error(), "backup.quarantine_max_runs") {
+		t.Errorf("expected quarantine_max_runs error, got %v", err)
+	}
+}
+
+func TestParse_ProgressWebhook(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "progress:\n  webhook_url: https://dash.example.com/events\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := cfg.Progress; p.BatchSize != 50 || p.FlushIntervalSeconds != 5 || p.MaxRetries != 3 || p.MaxQueued != 1000 {
+		t.Errorf("unexpected progress defaults: %+v", p)
+	}
+
+	_, err = Parse([]byte(base + "progress:\n  webhook_url: ftp://dash\n  batch_size: 0\n"))
+	if err == nil || !strings.Contains(err.Error(), "progress.webhook_url") || !strings.Contains(err.Error(), "progress.batch_size") {
+		t.Errorf("expected webhook_url and batch_size errors, got %v", err)
+	}
+}
+
+func TestParse_BaseURLs(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "api:\n  base_url: https://proxy.example.com/2.0\ngit:\n  base_url: https://proxy.example.com/git/\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.API.BaseURL != "https://proxy.example.com/2.0" {
+		t.Errorf("API.BaseURL = %q", cfg.API.BaseURL)
+	}
+	if got := cfg.Git.CloneURL("https://user@bitbucket.org/ws/my%20repo.git"); got != "https://proxy.example.com/git/ws/my%20repo.git" {
+		t.Errorf("CloneURL() = %q", got)
+	}
+
+	_, err = Parse([]byte(base + "api:\n  base_url: proxy.example.com\ngit:\n  base_url: ssh://proxy\n"))
+	if err == nil || !strings.Contains(err.Error(), "api.base_url") || !strings.Contains(err.Error(), "git.base_url") {
+		t.Errorf("expected api.base_url and git.base_url errors, got %v", err)
+	}
+}
+
+func TestParse_FieldMasks(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "backup:\n  field_masks:\n    pull_requests: [summary.html, \"**.links\"]\n    comments: [content.html]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Backup.FieldMasks[FieldMaskPullRequests]; len(got) != 2 || got[1] != "**.links" {
+		t.Errorf("pull_requests masks = %v", got)
+	}
+
+	_, err = Parse([]byte(base + "backup:\n  field_masks:\n    commits: [links]\n    issues: [\"content..html\", \"**\"]\n"))
+	if err == nil || !strings.Contains(err.Error(), "unknown kind 'commits'") ||
+		!strings.Contains(err.Error(), "'content..html'") || !strings.Contains(err.Error(), "'**'") {
+		t.Errorf("expected field_masks errors, got %v", err)
+	}
+}
+
+func TestParse_Downloads(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "backup:\n  include_downloads: true\n  downloads_max_size: 500MB\n  downloads_include: [\"*.zip\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Backup.DownloadsMaxBytes(); got != 500<<20 {
+		t.Errorf("DownloadsMaxBytes() = %d", got)
+	}
+
+	_, err = Parse([]byte(base + "backup:\n  downloads_max_size: lots\n  downloads_exclude: [\"[\"]\n"))
+	if err == nil || !strings.Contains(err.Error(), "downloads_max_size") || !strings.Contains(err.Error(), "downloads_exclude") {
+		t.Errorf("expected downloads errors, got %v", err)
+	}
+}
+
+func TestParse_APIType(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "api:\n  type: server\n  base_url: https://bitbucket.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.API.Type != APITypeServer {
+		t.Errorf("API.Type = %q", cfg.API.Type)
+	}
+
+	_, err = Parse([]byte(base + "api:\n  type: server\nbackup:\n  raw_mode: true\n  include_policies: true\n  include_settings: true\n  include_integrations: true\n"))
+	for _, want := range []string{"api.base_url is required", "raw_mode", "include_policies", "include_settings", "include_integrations"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error mentioning %q, got %v", want, err)
+		}
+	}
+
+	_, err = Parse([]byte(base + "api:\n  type: gitlab\n"))
+	if err == nil || !strings.Contains(err.Error(), "api.type") {
+		t.Errorf("expected api.type error, got %v", err)
+	}
+}
+
+func TestGitConfig_CloneURLUnset(t *testing.T) {
+	var g GitConfig
+	if got := g.CloneURL("https://user@bitbucket.org/ws/repo.git"); got != "https://user@bitbucket.org/ws/repo.git" {
+		t.Errorf("CloneURL() = %q, want it unchanged", got)
+	}
+}
+
+func TestParse_MaintenanceWait(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimit.MaxMaintenanceWaitMinutes != 60 || cfg.RateLimit.StatusPageURL != "" {
+		t.Errorf("unexpected maintenance defaults: %+v", cfg.RateLimit)
+	}
+
+	_, err = Parse([]byte(base + "rate_limit:\n  max_maintenance_wait_minutes: -5\n"))
+	if err == nil || !strings.Contains(err.Error(), "rate_limit.max_maintenance_wait_minutes") {
+		t.Errorf("expected max_maintenance_wait_minutes error, got %v", err)
+	}
+}
+
+func TestParse_Privacy(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Privacy.Enabled() {
+		t.Error("privacy should be off by default")
+	}
+
+	cfg, err = Parse([]byte(base + `privacy:
+  drop_fields: ["email"]
+  hash_fields: ["display_name", "author.raw"]
+  strip_html: true
+  hash_salt: "salt"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Privacy.Enabled() || len(cfg.Privacy.HashFields) != 2 {
+		t.Errorf("privacy settings not applied: %+v", cfg.Privacy)
+	}
+
+	tests := map[string]string{
+		"privacy:\n  hash_fields: [display_name]\n":                          "privacy.hash_salt is required",
+		"privacy:\n  drop_fields: [a.b.c]\n":                                 "privacy.drop_fields: 'a.b.c'",
+		"privacy:\n  drop_fields: [x]\n  hash_fields: [x]\n  hash_salt: s\n": "'x' is in both",
+		"privacy:\n  hash_fields: ['author.']\n  hash_salt: s\n":             "privacy.hash_fields: 'author.'",
+	}
+	for extra, want := range tests {
+		if _, err := Parse([]byte(base + extra)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", extra, want, err)
+		}
+	}
+}
+
+func TestParse_HighLatencyStorage(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "storage:\n  type: local\n  path: /mnt/share\n  high_latency: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Storage.HighLatency || cfg.Storage.FlushIntervalSeconds != 5 {
+		t.Errorf("unexpected storage settings: %+v", cfg.Storage)
+	}
+
+	_, err = Parse([]byte(base + "storage:\n  type: local\n  path: /mnt/share\n  flush_interval_seconds: -1\n"))
+	if err == nil || !strings.Contains(err.Error(), "storage.flush_interval_seconds") {
+		t.Errorf("expected flush_interval_seconds error, got %v", err)
+	}
+}
+
+func TestParse_IncludeProjects(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "backup:\n  include_projects: [CORE, web_2]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Backup.IncludeProjects) != 2 {
+		t.Errorf("expected 2 projects, got %v", cfg.Backup.IncludeProjects)
+	}
+
+	_, err = Parse([]byte(base + "backup:\n  include_projects: [\"CORE*\"]\n"))
+	if err == nil || !strings.Contains(err.Error(), "'CORE*' is not a project key") {
+		t.Errorf("expected project key error, got %v", err)
+	}
+}
+
+func TestParse_CredentialCommand(t *testing.T) {
+	cfg, err := Parse([]byte(`
+workspace: "my-workspace"
+auth:
+  method: "access_token"
+  credential_command: "vault read -field=token secret/bitbucket"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Auth.CredentialCommand != "vault read -field=token secret/bitbucket" {
+		t.Errorf("expected credential_command to be set, got %q", cfg.Auth.CredentialCommand)
+	}
+
+	_, err = Parse([]byte(`
+workspace: "my-workspace"
+auth:
+  method: "oauth"
+  client_id: "id"
+  client_secret: "secret"
+  credential_command: "get-token"
+`))
+	if err == nil || !strings.Contains(err.Error(), "credential_command is not supported with the oauth method") {
+		t.Errorf("expected oauth error, got %v", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "api_token"
+  username: "user"
+  email: "user@example.com"
+  api_token: "%s"
+backup:
+  exclude_repos: [%s]
+`
+	parse := func(token, exclude string) *Config {
+		t.Helper()
+		cfg, err := Parse([]byte(fmt.Sprintf(base, token, exclude)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	a := parse("token-1", `"test-*"`).Fingerprint()
+	if !strings.HasPrefix(a, "sha256:") {
+		t.Fatalf("Fingerprint() = %q", a)
+	}
+	if b := parse("token-2", `"test-*"`).Fingerprint(); b != a {
+		t.Error("fingerprint changed with the secret")
+	}
+	if c := parse("token-1", `"old-*"`).Fingerprint(); c == a {
+		t.Error("fingerprint did not change with the config")
+	}
+}
+
+func TestParse_Retention(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + `
+retention:
+  keep_days: 30
+  classes:
+    critical:
+      keep_days: 365
+    legal-hold:
+      keep_days: 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for class, want := range map[string]int{"critical": 365, "legal-hold": 0, "": 30, "unknown": 30} {
+		if got, _ := cfg.Retention.KeepDaysFor(class); got != want {
+			t.Errorf("KeepDaysFor(%q) = %d, want %d", class, got, want)
+		}
+	}
+	if _, known := cfg.Retention.KeepDaysFor("unknown"); known {
+		t.Error("unknown class reported as configured")
+	}
+
+	_, err = Parse([]byte(base + "retention:\n  keep_days: -1\n  keep_runs: -1\n  classes:\n    critical:\n      keep_days: -5\n"))
+	if err == nil || !strings.Contains(err.Error(), "retention.keep_days") || !strings.Contains(err.Error(), "retention.keep_runs") || !strings.Contains(err.Error(), "retention.classes.critical.keep_days") {
+		t.Errorf("expected retention errors, got %v", err)
+	}
+}
+
+func TestParse_Policy(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "policy:\n  repository: backup-policy\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Policy.Path != ".bbbackup.yaml" || cfg.Policy.Ref != "" {
+		t.Errorf("policy = %+v, want the default path and no ref", cfg.Policy)
+	}
+
+	for _, bad := range []string{
+		"policy:\n  repository: other-ws/backup-policy\n",
+		"policy:\n  repository: backup-policy\n  path: /etc/policy.yaml\n",
+		"policy:\n  repository: backup-policy\n  path: ../policy.yaml\n",
+		"policy:\n  repository: backup-policy\n  path: \"\"\n",
+	} {
+		if _, err := Parse([]byte(base + bad)); err == nil || !strings.Contains(err.Error(), "policy.") {
+			t.Errorf("expected a policy error for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestParse_CredentialSets(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + `
+credentials:
+  - name: finance
+    projects: ["FIN"]
+    repos: ["ledger-*"]
+    method: access_token
+    access_token: fin-token
+    git_username: finance-bot
+    git_password: git-pass
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	for _, tt := range []struct {
+		project, slug, want string
+	}{
+		{"fin", "payroll", "finance"},
+		{"OPS", "ledger-core", "finance"},
+		{"OPS", "deploy", ""},
+		{"", "ledger-old", "finance"},
+	} {
+		if got := cfg.CredentialSetFor(tt.project, tt.slug); got != tt.want {
+			t.Errorf("CredentialSetFor(%q, %q) = %q, want %q", tt.project, tt.slug, got, tt.want)
+		}
+	}
+
+	set := cfg.WithCredentialSet("finance")
+	if set.Auth.AccessToken != "fin-token" || set.Workspace != "my-workspace" {
+		t.Errorf("WithCredentialSet() auth = %+v", set.Auth)
+	}
+	if user, pass := set.GetGitCredentials(); user != "finance-bot" || pass != "git-pass" {
+		t.Errorf("GetGitCredentials() = %q, %q", user, pass)
+	}
+	if cfg.WithCredentialSet("missing") != cfg {
+		t.Error("WithCredentialSet() of an unknown set should return the config")
+	}
+
+	for _, bad := range []string{
+		"credentials:\n  - projects: [FIN]\n    method: access_token\n    access_token: t\n",
+		"credentials:\n  - name: a\n    method: access_token\n    access_token: t\n",
+		"credentials:\n  - name: a\n    repos: [\"[\"]\n    method: access_token\n    access_token: t\n",
+		"credentials:\n  - name: a\n    projects: [FIN]\n    method: access_token\n",
+		"credentials:\n  - name: a\n    projects: [FIN]\n    method: access_token\n    access_token: t\n    git_username: bot\n",
+		"credentials:\n  - name: a\n    projects: [FIN]\n    method: access_token\n    access_token: t\n  - name: a\n    projects: [PAY]\n    method: access_token\n    access_token: t\n",
+	} {
+		if _, err := Parse([]byte(base + bad)); err == nil || !strings.Contains(err.Error(), "credentials") {
+			t.Errorf("expected a credentials error for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestParse_RepoTokens(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + `
+credentials:
+  - name: vaults
+    repos: ["vault-*"]
+    method: access_token
+    access_token: set-token
+repo_tokens:
+  vault-keys: keys-token
+  payroll: payroll-token
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	for _, tt := range []struct {
+		slug, want string
+	}{
+		{"vault-keys", "repo:vault-keys"},
+		{"vault-old", "vaults"},
+		{"payroll", "repo:payroll"},
+		{"api", ""},
+	} {
+		if got := cfg.CredentialSetFor("", tt.slug); got != tt.want {
+			t.Errorf("CredentialSetFor(%q) = %q, want %q", tt.slug, got, tt.want)
+		}
+	}
+	if got, want := cfg.CredentialSetNames(), []string{"vaults", "repo:payroll", "repo:vault-keys"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CredentialSetNames() = %q, want %q", got, want)
+	}
+	if slug, ok := cfg.RepoTokenSlug("repo:payroll"); !ok || slug != "payroll" {
+		t.Errorf("RepoTokenSlug() = %q, %v", slug, ok)
+	}
+	if _, ok := cfg.RepoTokenSlug("vaults"); ok {
+		t.Error("RepoTokenSlug() accepted a credentials entry")
+	}
+
+	set := cfg.WithCredentialSet("repo:payroll")
+	if user, pass := set.GetGitCredentials(); user != "x-token-auth" || pass != "payroll-token" {
+		t.Errorf("GetGitCredentials() = %q, %q", user, pass)
+	}
+	if set.Auth.Method != "access_token" || cfg.Auth.Method != "app_password" {
+		t.Errorf("WithCredentialSet() auth = %+v, config auth = %+v", set.Auth, cfg.Auth)
+	}
+
+	for _, bad := range []string{
+		"repo_tokens:\n  Payroll: t\n",
+		"repo_tokens:\n  team/payroll: t\n",
+		"repo_tokens:\n  payroll: \"\"\n",
+		"credentials:\n  - name: \"repo:payroll\"\n    repos: [payroll]\n    method: access_token\n    access_token: t\n",
+		"api:\n  type: server\n  base_url: https://bitbucket.example.com\nrepo_tokens:\n  payroll: t\n",
+	} {
+		if _, err := Parse([]byte(base + bad)); err == nil || !strings.Contains(err.Error(), "repo_tokens") {
+			t.Errorf("expected a repo_tokens error for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestParse_Metrics(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "metrics:\n  listen: \"localhost:6060\"\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Metrics.Listen != "localhost:6060" {
+		t.Errorf("metrics.listen = %q", cfg.Metrics.Listen)
+	}
+	if _, err := Parse([]byte(base + "metrics:\n  listen: \"6060\"\n")); err == nil || !strings.Contains(err.Error(), "metrics.listen") {
+		t.Errorf("expected a metrics.listen error, got %v", err)
+	}
+
+	cfg, err = Parse([]byte(base + "metrics:\n  pushgateway_url: \"http://pushgateway:9091\"\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Metrics.PushgatewayJob != "bb_backup" {
+		t.Errorf("metrics.pushgateway_job = %q, want the default", cfg.Metrics.PushgatewayJob)
+	}
+	if _, err := Parse([]byte(base + "metrics:\n  pushgateway_url: \"pushgateway:9091\"\n  pushgateway_job: \"a/b\"\n")); err == nil ||
+		!strings.Contains(err.Error(), "metrics.pushgateway_url") || !strings.Contains(err.Error(), "metrics.pushgateway_job") {
+		t.Errorf("expected pushgateway errors, got %v", err)
+	}
+}
+
+func TestParse_RunTimestampFormat(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Backup.RunTimestampFormat != DefaultRunTimestampFormat {
+		t.Errorf("run_timestamp_format = %q, want the default", cfg.Backup.RunTimestampFormat)
+	}
+	if _, err := Parse([]byte(base + "backup:\n  run_timestamp_format: \"20060102-150405Z\"\n")); err != nil {
+		t.Errorf("Parse() with a custom layout error = %v", err)
+	}
+
+	for _, bad := range []string{"2006-01-02T15:04:05Z", "2006/01/02", "nightly"} {
+		_, err := Parse([]byte(base + "backup:\n  run_timestamp_format: \"" + bad + "\"\n"))
+		if err == nil || !strings.Contains(err.Error(), "backup.run_timestamp_format") {
+			t.Errorf("expected a run_timestamp_format error for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestParse_S3Storage(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "storage:\n  type: s3\n  path: /var/cache/bb\n  s3:\n    bucket: backups\n    region: us-east-1\n    endpoint: http://minio:9000\n    path_style: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Storage.S3.Bucket != "backups" || !cfg.Storage.S3.PathStyle {
+		t.Errorf("Storage.S3 = %+v", cfg.Storage.S3)
+	}
+
+	_, err = Parse([]byte(base + "storage:\n  type: s3\n  path: /var/cache/bb\n  s3:\n    endpoint: minio:9000\n"))
+	for _, want := range []string{"storage.s3.bucket", "storage.s3.region", "storage.s3.endpoint"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s error, got %v", want, err)
+		}
+	}
+
+	_, err = Parse([]byte(base + "storage:\n  type: s3\n  path: /b\n  s3:\n    bucket: b\n    region: r\nbackup:\n  atomic_latest: true\n"))
+	if err == nil || !strings.Contains(err.Error(), "backup.atomic_latest is not supported") {
+		t.Errorf("expected an atomic_latest error, got %v", err)
+	}
+}
+
+func TestParse_StorageArchive(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base + "storage:\n  type: local\n  path: /backups\n  archive: zip\n  archive_remove: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Storage.Archive != ArchiveZip || !cfg.Storage.ArchiveRemove {
+		t.Errorf("unexpected storage settings: %+v", cfg.Storage)
+	}
+	if _, err := Parse([]byte(base + "storage:\n  type: s3\n  path: /b\n  archive: tar.gz\n  s3:\n    bucket: b\n    region: r\n")); err != nil {
+		t.Errorf("storage.archive with s3 storage: %v", err)
+	}
+
+	for extra, want := range map[string]string{
+		"storage:\n  type: local\n  path: /backups\n  archive: tar.xz\n":      "storage.archive must be",
+		"storage:\n  type: local\n  path: /backups\n  archive_remove: true\n": "storage.archive_remove requires",
+	} {
+		_, err := Parse([]byte(base + extra))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", extra, want, err)
+		}
+	}
+}
+
+func TestParse_Workspaces(t *testing.T) {
+	base := `
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+storage:
+  type: local
+  path: /backups
+retention:
+  keep_days: 30
+notifications:
+  slack:
+    webhook_url: https://hooks.slack.com/services/shared
+`
+	cfg, err := Parse([]byte(base + `
+workspaces:
+  - slug: acme-eng
+    alias: engineering
+    path: /backups/eng
+    retention:
+      keep_runs: 10
+    notifications:
+      when: failure
+      webhook:
+        url: https://eng.example.com/hook
+  - slug: acme-ops
+`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	eng, err := cfg.ForWorkspace("engineering")
+	if err != nil {
+		t.Fatalf("ForWorkspace() error = %v", err)
+	}
+	if eng.Workspace != "acme-eng" || eng.WorkspaceAlias != "engineering" || eng.Storage.Path != "/backups/eng" || eng.Workspaces != nil {
+		t.Errorf("ForWorkspace(engineering) = workspace %q, alias %q, path %q", eng.Workspace, eng.WorkspaceAlias, eng.Storage.Path)
+	}
+	// Sections given for the workspace replace the shared ones entirely
+	if eng.Retention.KeepDays != 0 || eng.Retention.KeepRuns != 10 {
+		t.Errorf("retention = %+v", eng.Retention)
+	}
+	n := eng.Notifications
+	if n.When != NotifyFailure || n.Slack.WebhookURL != "" || n.Webhook.URL == "" || n.MaxRetries != 3 || n.Email.SMTPPort != 587 {
+		t.Errorf("notifications = %+v", n)
+	}
+
+	ops, err := cfg.ForWorkspace("acme-ops")
+	if err != nil {
+		t.Fatalf("ForWorkspace() error = %v", err)
+	}
+	if ops.WorkspaceAlias != "acme-ops" || ops.Storage.Path != "/backups" || ops.Retention.KeepDays != 30 || ops.Notifications.Slack.WebhookURL == "" {
+		t.Errorf("ForWorkspace(acme-ops) shares too little: %+v", ops)
+	}
+	if byslug, _ := cfg.ForWorkspace("acme-eng"); byslug == nil || byslug.WorkspaceAlias != "engineering" {
+		t.Error("ForWorkspace() should find a workspace by its slug")
+	}
+
+	if all := cfg.WorkspaceConfigs(); len(all) != 2 || all[0].Workspace != "acme-eng" || all[1].Workspace != "acme-ops" {
+		t.Errorf("WorkspaceConfigs() = %d configs", len(all))
+	}
+	if _, err := cfg.ForWorkspace(""); err == nil || !strings.Contains(err.Error(), "engineering, acme-ops") {
+		t.Errorf("ForWorkspace(\"\") error = %v, want the names to choose from", err)
+	}
+	if _, err := cfg.ForWorkspace("finance"); err == nil {
+		t.Error("ForWorkspace() of an unknown workspace should fail")
+	}
+
+	single, _ := Parse([]byte(base + "workspace: my-workspace\n"))
+	if got, _ := single.ForWorkspace(""); got != single {
+		t.Error("ForWorkspace(\"\") without workspaces should return the config")
+	}
+	if got, _ := single.ForWorkspace("other"); got.Workspace != "other" || single.Workspace != "my-workspace" {
+		t.Errorf("ForWorkspace(other) = %q; want an overridden copy", got.Workspace)
+	}
+
+	for extra, want := range map[string]string{
+		"workspace: acme\nworkspaces:\n  - slug: acme-eng\n":                                   "cannot both be set",
+		"workspaces:\n  - alias: eng\n":                                                        "workspaces[0].slug is required",
+		"workspaces:\n  - slug: a\n    alias: eng\n  - slug: b\n    alias: eng\n":              "'eng' names more than one workspace",
+		"workspaces:\n  - slug: a\n    alias: b\n  - slug: b\n":                                "'b' names more than one workspace",
+		"workspaces:\n  - slug: a\n    retention:\n      keep_days: -1\n":                      "workspaces.a.retention.keep_days",
+		"workspaces:\n  - slug: a\n    notifications:\n      webhook:\n        url: ftp://x\n": "workspaces.a.notifications.webhook.url",
+	} {
+		_, err := Parse([]byte(base + extra))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", extra, want, err)
+		}
+	}
+}
+
+func TestParse_Throttle(t *testing.T) {
+	base := `
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Throttle.Adaptive() || cfg.Throttle.LoadThreshold != 1.0 || cfg.Throttle.MinWorkers != 1 {
+		t.Errorf("default throttle = %+v", cfg.Throttle)
+	}
+
+	cfg, err = Parse([]byte(base + "throttle:\n  io_class: idle\n  cpu_nice: 10\n  cpu_percent: 50\n  nice: true\n  load_threshold: 0.7\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Throttle.Adaptive() || cfg.Throttle.IOClass != "idle" || cfg.Throttle.LoadThreshold != 0.7 {
+		t.Errorf("throttle = %+v", cfg.Throttle)
+	}
+
+	for extra, want := range map[string]string{
+		"throttle:\n  io_class: realtime\n":                "throttle.io_class",
+		"throttle:\n  cpu_nice: 20\n":                      "throttle.cpu_nice",
+		"throttle:\n  cpu_percent: 150\n":                  "throttle.cpu_percent",
+		"throttle:\n  nice: true\n  load_threshold: 0\n":   "throttle.load_threshold",
+		"throttle:\n  cpu_percent: 50\n  min_workers: 0\n": "throttle.min_workers",
+	} {
+		_, err := Parse([]byte(base + extra))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", extra, want, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package errcode defines stable, machine-readable codes for failures, so
+// reports, JSON progress events, and exit statuses can say what kind of
+// failure occurred without callers matching on error messages.
+//
+// Errors carry a code by implementing Coder, usually by being created with
+// New or Errorf or wrapped with Wrap. Of finds the code of any error,
+// falling back to the standard library's context, network, and
+// out-of-space errors, then to Unknown.
+package errcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Code identifies a class of failure. Codes are part of the output format:
+// once published they are never renamed.
+type Code string
+
+// Failure codes.
+const (
+	AuthFailed       Code = "AUTH_FAILED"       // Credentials rejected or could not be obtained
+	PermissionDenied Code = "PERMISSION_DENIED" // Credentials valid but not allowed (HTTP 403)
+	RateLimited      Code = "RATE_LIMITED"      // API rate limit still exceeded after retries
+	NotFound         Code = "NOT_FOUND"         // API resource does not exist (HTTP 404)
+	RepoNotFound     Code = "REPO_NOT_FOUND"    // Repository gone or not visible to git
+	APIUnavailable   Code = "API_UNAVAILABLE"   // Server errors (HTTP 5xx) or maintenance
+	APIError         Code = "API_ERROR"         // Any other API error response
+	NetworkError     Code = "NETWORK_ERROR"     // Connection, DNS, or TLS failure
+	GitTimeout       Code = "GIT_TIMEOUT"       // Clone or fetch exceeded backup.git_timeout_minutes
+	GitFailed        Code = "GIT_FAILED"        // Any other git failure
+	StorageFull      Code = "STORAGE_FULL"      // No space left on the device, or quota exceeded
+	StorageError     Code = "STORAGE_ERROR"     // Any other failure reading or writing storage
+	PanicRecovered   Code = "PANIC_RECOVERED"   // A panic was recovered while backing up
+	Quarantined      Code = "QUARANTINED"       // Update held back by the pack scan
+	ConfigInvalid    Code = "CONFIG_INVALID"    // Configuration could not be loaded or is invalid
+	Canceled         Code = "CANCELED"          // Interrupted by a signal or shutdown
+	Timeout          Code = "TIMEOUT"           // Any other deadline exceeded
+	ReposFailed      Code = "REPOS_FAILED"      // The run finished but repositories failed
+	Unknown          Code = "UNKNOWN"           // Not classified
+)
+
+// exitStatuses maps codes to process exit statuses. Related codes share a
+// status; codes not listed exit with 1.
+var exitStatuses = map[Code]int{
+	ConfigInvalid:    2,
+	AuthFailed:       3,
+	PermissionDenied: 3,
+	RateLimited:      4,
+	NotFound:         5,
+	RepoNotFound:     5,
+	NetworkError:     6,
+	APIUnavailable:   6,
+	APIError:         6,
+	GitTimeout:       7,
+	GitFailed:        7,
+	StorageFull:      8,
+	StorageError:     8,
+	PanicRecovered:   9,
+	ReposFailed:      10,
+	Canceled:         130,
+}
+
+// Coder is implemented by errors that know their code.
+type Coder interface {
+	ErrorCode() Code
+}
+
+// Error is an error with a code attached.
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error { return e.Err }
+
+// ErrorCode returns the attached code.
+func (e *Error) ErrorCode() Code { return e.Code }
+
+// New returns an error with the given code and message, for use as a
+// sentinel with errors.Is.
+func New(code Code, msg string) error {
+	return &Error{Code: code, Err: errors.New(msg)}
+}
+
+// Errorf formats an error like fmt.Errorf and attaches code to it.
+func Errorf(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Wrap attaches code to err. It returns nil for a nil err.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// Of returns the code of err: the code of the outermost Coder in its
+// chain, else a code inferred from standard library errors, else Unknown.
+// It returns "" for a nil err.
+func Of(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var coder Coder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode()
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	case errors.Is(err, syscall.ENOSPC):
+		return StorageFull
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return NetworkError
+	}
+	return Unknown
+}
+
+// ExitStatus returns the exit status for a command that failed with err:
+// 0 for nil, otherwise the status of its code (see the README for the
+// table), or 1 for codes without their own status.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if status, ok := exitStatuses[Of(err)]; ok {
+		return status
+	}
+	return 1
+}
@@ -0,0 +1,58 @@
+package errcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	sentinel := New(Quarantined, "held back")
+	for name, tc := range map[string]struct {
+		err  error
+		want Code
+	}{
+		"nil":       {nil, ""},
+		"plain":     {errors.New("boom"), Unknown},
+		"coded":     {Errorf(GitTimeout, "fetch timed out after %d minutes", 30), GitTimeout},
+		"wrapped":   {fmt.Errorf("backing up repo: %w", Wrap(AuthFailed, errors.New("401"))), AuthFailed},
+		"outermost": {Wrap(RepoNotFound, Wrap(GitFailed, errors.New("not found"))), RepoNotFound},
+		"sentinel":  {fmt.Errorf("repo: %w", sentinel), Quarantined},
+		"canceled":  {fmt.Errorf("backup cancelled: %w", context.Canceled), Canceled},
+		"deadline":  {context.DeadlineExceeded, Timeout},
+		"no space":  {&os.PathError{Op: "write", Path: "/backups/x", Err: syscall.ENOSPC}, StorageFull},
+		"network":   {&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, NetworkError},
+	} {
+		if got := Of(tc.err); got != tc.want {
+			t.Errorf("%s: Of() = %q, want %q", name, got, tc.want)
+		}
+	}
+
+	if !errors.Is(fmt.Errorf("x: %w", sentinel), sentinel) {
+		t.Error("errors.Is() does not match a New sentinel")
+	}
+	if Wrap(AuthFailed, nil) != nil {
+		t.Error("Wrap(nil) != nil")
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	for err, want := range map[error]int{
+		nil:                          0,
+		errors.New("boom"):           1,
+		New(ConfigInvalid, "bad"):    2,
+		New(PermissionDenied, "403"): 3,
+		New(StorageFull, "full"):     8,
+		New(ReposFailed, "2 failed"): 10,
+		New(Timeout, "deadline"):     1,
+		fmt.Errorf("x: %w", New(RateLimited, "429")): 4,
+	} {
+		if got := ExitStatus(err); got != want {
+			t.Errorf("ExitStatus(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package faults injects artificial failures into API requests, git
+// operations, and workers so retry, fallback, and shutdown paths can be
+// exercised on demand. It is disabled unless a fault spec is supplied via
+// the BB_BACKUP_FAULTS environment variable or the --faults flag.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+// EnvVar is the environment variable holding the default fault spec.
+const EnvVar = "BB_BACKUP_FAULTS"
+
+// Kind identifies a class of injected fault.
+type Kind string
+
+// Supported fault kinds. Each is configured with a rate between 0 and 1.
+const (
+	API429  Kind = "api_429"  // Synthetic 429 Too Many Requests from the API
+	API500  Kind = "api_500"  // Synthetic 500 Internal Server Error from the API
+	APISlow Kind = "api_slow" // Delay an API request by the configured delay
+	Git     Kind = "git"      // Fail a git clone/fetch with a generic error
+	GitAuth Kind = "git_auth" // Fail a git clone/fetch with an authentication error
+	Panic   Kind = "panic"    // Panic inside a backup worker
+)
+
+var kinds = map[Kind]bool{API429: true, API500: true, APISlow: true, Git: true, GitAuth: true, Panic: true}
+
+// DefaultDelay is how long api_slow holds a request when no delay is given.
+const DefaultDelay = 2 * time.Second
+
+// ErrInjected is wrapped by every error produced by an Injector.
+var ErrInjected = errors.New("injected fault")
+
+// Injector decides, at configured rates, whether to inject each fault kind.
+// A nil *Injector is valid and never injects anything.
+type Injector struct {
+	mu     sync.Mutex
+	rates  map[Kind]float64
+	delay  time.Duration
+	rng    *rand.Rand
+	counts map[Kind]int
+}
+
+// Parse builds an Injector from a comma-separated spec such as
+// "api_429=0.1,git=0.05,api_slow=0.2,delay=3s,seed=42". Rates are
+// probabilities in [0, 1]; delay sets the api_slow duration and seed makes
+// runs reproducible. An empty spec returns a nil Injector.
+func Parse(spec string) (*Injector, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	inj := &Injector{
+		rates:  make(map[Kind]float64),
+		delay:  DefaultDelay,
+		counts: make(map[Kind]int),
+	}
+	seed := time.Now().UnixNano()
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q: expected key=value", part)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "delay":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid fault delay %q", value)
+			}
+			inj.delay = d
+		case "seed":
+			s, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault seed %q", value)
+			}
+			seed = s
+		default:
+			if !kinds[Kind(key)] {
+				return nil, fmt.Errorf("unknown fault kind %q", key)
+			}
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid rate %q for fault %s: must be between 0 and 1", value, key)
+			}
+			inj.rates[Kind(key)] = rate
+		}
+	}
+
+	inj.rng = rand.New(rand.NewSource(seed)) //nolint:gosec // fault injection does not need crypto randomness
+	return inj, nil
+}
+
+// Enabled reports whether any fault has a non-zero rate.
+func (i *Injector) Enabled() bool {
+	if i == nil {
+		return false
+	}
+	for _, rate := range i.rates {
+		if rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Should reports whether a fault of kind k should fire now, and records it.
+func (i *Injector) Should(k Kind) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	rate := i.rates[k]
+	if rate <= 0 || i.rng.Float64() >= rate {
+		return false
+	}
+	i.counts[k]++
+	return true
+}
+
+// GitError returns an injected error for a git operation on repo, or nil.
+// Authentication faults use wording the SSH fallback recognizes.
+func (i *Injector) GitError(repo string) error {
+	if i.Should(GitAuth) {
+		return errcode.Errorf(errcode.AuthFailed, "%w: authentication required for %s", ErrInjected, repo)
+	}
+	if i.Should(Git) {
+		return errcode.Errorf(errcode.GitFailed, "%w: git operation failed for %s", ErrInjected, repo)
+	}
+	return nil
+}
+
+// MaybePanic panics with a recognizable message if a panic fault fires.
+func (i *Injector) MaybePanic(where string) {
+	if i.Should(Panic) {
+		panic(fmt.Sprintf("%v: panic in %s", ErrInjected, where))
+	}
+}
+
+// Summary returns a short description of the faults injected so far,
+// e.g. "api_429=3, git=1", or an empty string if none fired.
+func (i *Injector) Summary() string {
+	if i == nil {
+		return ""
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	parts := make([]string, 0, len(i.counts))
+	for k, n := range i.counts {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// Transport wraps base so API requests may be delayed or answered with a
+// synthetic 429 or 500 response instead of reaching the server. A nil
+// Injector returns base unchanged.
+func (i *Injector) Transport(base http.RoundTripper) http.RoundTripper {
+	if i == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &faultTransport{inj: i, base: base}
+}
+
+type faultTransport struct {
+	inj  *Injector
+	base http.RoundTripper
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.inj.Should(APISlow) {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(t.inj.delay):
+		}
+	}
+	if t.inj.Should(API429) {
+		return syntheticResponse(req, http.StatusTooManyRequests), nil
+	}
+	if t.inj.Should(API500) {
+		return syntheticResponse(req, http.StatusInternalServerError), nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+func syntheticResponse(req *http.Request, status int) *http.Response {
+	body := fmt.Sprintf(`{"type":"error","error":{"message":"%s: %s"}}`, ErrInjected, http.StatusText(status))
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	if status == http.StatusTooManyRequests {
+		header.Set("Retry-After", "1")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,163 @@
+package faults
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "empty", spec: "", wantNil: true},
+		{name: "whitespace", spec: "  ", wantNil: true},
+		{name: "rates", spec: "api_429=0.1, git=1,panic=0"},
+		{name: "delay and seed", spec: "api_slow=0.5,delay=10ms,seed=7"},
+		{name: "unknown kind", spec: "disk=0.1", wantErr: true},
+		{name: "rate above one", spec: "git=1.5", wantErr: true},
+		{name: "negative rate", spec: "git=-0.1", wantErr: true},
+		{name: "missing value", spec: "git", wantErr: true},
+		{name: "bad delay", spec: "delay=soon", wantErr: true},
+		{name: "bad seed", spec: "seed=x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inj, err := Parse(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (inj == nil) != tt.wantNil {
+				t.Errorf("Parse(%q) nil = %v, want %v", tt.spec, inj == nil, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestInjector_Nil(t *testing.T) {
+	var inj *Injector
+	if inj.Enabled() || inj.Should(Git) {
+		t.Error("nil injector should never fire")
+	}
+	if err := inj.GitError("repo"); err != nil {
+		t.Errorf("GitError() = %v, want nil", err)
+	}
+	inj.MaybePanic("test")
+	if inj.Summary() != "" {
+		t.Errorf("Summary() = %q, want empty", inj.Summary())
+	}
+	base := http.DefaultTransport
+	if inj.Transport(base) != base {
+		t.Error("nil injector should return the base transport")
+	}
+}
+
+func TestInjector_GitError(t *testing.T) {
+	inj, err := Parse("git_auth=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gitErr := inj.GitError("my-repo")
+	if !errors.Is(gitErr, ErrInjected) {
+		t.Fatalf("expected ErrInjected, got %v", gitErr)
+	}
+	if !strings.Contains(gitErr.Error(), "authentication") {
+		t.Errorf("expected authentication wording, got %q", gitErr)
+	}
+	if got := inj.Summary(); got != "git_auth=1" {
+		t.Errorf("Summary() = %q, want git_auth=1", got)
+	}
+}
+
+func TestInjector_MaybePanic(t *testing.T) {
+	inj, err := Parse("panic=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("expected panic")
+		}
+		if !strings.Contains(r.(string), "worker") {
+			t.Errorf("panic message %q should name the location", r)
+		}
+	}()
+	inj.MaybePanic("worker")
+}
+
+func TestInjector_SeedIsReproducible(t *testing.T) {
+	draw := func() []bool {
+		inj, err := Parse("git=0.5,seed=42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]bool, 20)
+		for i := range out {
+			out[i] = inj.Should(Git)
+		}
+		return out
+	}
+
+	a, b := draw(), draw()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("draw %d differs between runs with the same seed", i)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		spec   string
+		status int
+		hits   int
+	}{
+		{spec: "api_429=1", status: http.StatusTooManyRequests, hits: 0},
+		{spec: "api_500=1", status: http.StatusInternalServerError, hits: 0},
+		{spec: "api_slow=1,delay=1ms", status: http.StatusOK, hits: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			hits = 0
+			inj, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: inj.Transport(nil), Timeout: time.Second}
+
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if hits != tt.hits {
+				t.Errorf("server hits = %d, want %d", hits, tt.hits)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Package format provides human-readable formatting for sizes, durations,
+// rates, and counts.
+//
+// Output is locale-independent: decimals always use '.', thousands are
+// grouped with ',', and only ASCII is produced, so logs and reports read
+// the same regardless of LANG/LC_* settings.
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bytes formats a byte count using binary units, e.g. "512 B", "1.5 KB",
+// "3.2 GB". Negative values (such as size deltas) keep their sign.
+func Bytes(n int64) string {
+	const unit = 1024
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	if n < unit {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%.1f %cB", sign, float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseBytes parses a size such as "500GB", "1.5 TB", or "800 GiB" using
+// the same binary units as Bytes. A bare number is a byte count.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit = strings.TrimSuffix(strings.Replace(unit, "IB", "B", 1), "B")
+	exp := strings.Index("KMGTPE", unit)
+	switch {
+	case unit == "":
+		exp = -1
+	case len(unit) != 1 || exp < 0:
+		return 0, fmt.Errorf("invalid size %q: unknown unit", s)
+	}
+	for ; exp >= 0; exp-- {
+		n *= 1024
+	}
+	return int64(n), nil
+}
+
+// Duration formats a duration compactly: "850ms", "4.2s", "45s", "3m05s",
+// "1h02m03s".
+func Duration(d time.Duration) string {
+	if d < 0 {
+		return "-" + Duration(-d)
+	}
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	if d < 10*time.Second {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	if d < time.Hour {
+		mins := int(d.Minutes())
+		secs := int(d.Seconds()) % 60
+		return fmt.Sprintf("%dm%02ds", mins, secs)
+	}
+	hours := int(d.Hours())
+	mins := int(d.Minutes()) % 60
+	secs := int(d.Seconds()) % 60
+	return fmt.Sprintf("%dh%02dm%02ds", hours, mins, secs)
+}
+
+// Rate formats a byte throughput, e.g. "3.4 MB/s". A zero or negative
+// duration yields "-".
+func Rate(n int64, d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return Bytes(int64(float64(n)/d.Seconds())) + "/s"
+}
+
+// Count formats an integer with thousands separators, e.g. "12,345".
+func Count(n int64) string {
+	s := strconv.FormatInt(n, 10)
+	start := 0
+	if n < 0 {
+		start = 1
+	}
+	digits := len(s) - start
+	if digits <= 3 {
+		return s
+	}
+
+	out := make([]byte, 0, len(s)+digits/3)
+	out = append(out, s[:start]...)
+	first := digits % 3
+	if first == 0 {
+		first = 3
+	}
+	out = append(out, s[start:start+first]...)
+	for i := start + first; i < len(s); i += 3 {
+		out = append(out, ',')
+		out = append(out, s[i:i+3]...)
+	}
+	return string(out)
+}
+
+// Percent formats a percentage with no decimals, e.g. "42%".
+func Percent(p float64) string {
+	return fmt.Sprintf("%.0f%%", p)
+}
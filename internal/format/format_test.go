@@ -0,0 +1,114 @@
+package format
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "0 B"},
+		{100, "100 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{1048576, "1.0 MB"},
+		{1572864, "1.5 MB"},
+		{1073741824, "1.0 GB"},
+		{1099511627776, "1.0 TB"},
+		{-500, "-500 B"},
+		{-2048, "-2.0 KB"},
+	}
+
+	for _, tt := range tests {
+		if got := Bytes(tt.bytes); got != tt.want {
+			t.Errorf("Bytes(%d) = %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"512B", 512},
+		{"1KB", 1024},
+		{"1.5 MB", 1572864},
+		{"500GB", 500 << 30},
+		{"2 TiB", 2 << 40},
+		{"1gb", 1 << 30},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "GB", "-1GB", "10 XB", "10 GBB"} {
+		if _, err := ParseBytes(bad); err == nil {
+			t.Errorf("ParseBytes(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{850 * time.Millisecond, "850ms"},
+		{4200 * time.Millisecond, "4.2s"},
+		{30 * time.Second, "30s"},
+		{90 * time.Second, "1m30s"},
+		{3661 * time.Second, "1h01m01s"},
+		{-90 * time.Second, "-1m30s"},
+	}
+
+	for _, tt := range tests {
+		if got := Duration(tt.d); got != tt.want {
+			t.Errorf("Duration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	if got := Rate(3*1048576, 2*time.Second); got != "1.5 MB/s" {
+		t.Errorf("Rate() = %q, want %q", got, "1.5 MB/s")
+	}
+	if got := Rate(100, 0); got != "-" {
+		t.Errorf("Rate() with zero duration = %q, want %q", got, "-")
+	}
+}
+
+func TestCount(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{12345, "12,345"},
+		{1234567, "1,234,567"},
+		{-1234567, "-1,234,567"},
+		{-999, "-999"},
+	}
+
+	for _, tt := range tests {
+		if got := Count(tt.n); got != tt.want {
+			t.Errorf("Count(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	if got := Percent(41.6); got != "42%" {
+		t.Errorf("Percent() = %q, want %q", got, "42%")
+	}
+}
@@ -0,0 +1,103 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// A fork's mirror can borrow objects from its parent's mirror through
+// objects/info/alternates, so objects the two share are stored once.
+// Alternates are written as absolute paths, which go-git resolves from the
+// filesystem root.
+
+// newStorage returns go-git storage for a mirror's git directory that
+// follows alternates outside it.
+func newStorage(fs billy.Filesystem, objCache cache.Object) *filesystem.Storage {
+	return filesystem.NewStorageWithOptions(fs, objCache, filesystem.Options{AlternatesFS: osfs.New("/")})
+}
+
+// alternatesFile returns the path of a mirror's alternates file.
+func alternatesFile(repoPath string) string {
+	return filepath.Join(gitDir(repoPath), "objects", "info", "alternates")
+}
+
+// ObjectsDir returns the absolute path of a mirror's object directory.
+func ObjectsDir(repoPath string) (string, error) {
+	return filepath.Abs(filepath.Join(gitDir(repoPath), "objects"))
+}
+
+// Alternates returns the object directories a mirror borrows from, or
+// none.
+func Alternates(repoPath string) ([]string, error) {
+	data, err := os.ReadFile(alternatesFile(repoPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading alternates: %w", err)
+	}
+	var dirs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			dirs = append(dirs, line)
+		}
+	}
+	return dirs, nil
+}
+
+// SetAlternates makes the mirror at repoPath borrow objects from the
+// object directory objectsDir, replacing any alternates it had.
+func SetAlternates(repoPath, objectsDir string) error {
+	path := alternatesFile(repoPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("writing alternates: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(objectsDir+"\n"), 0644); err != nil {
+		return fmt.Errorf("writing alternates: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("writing alternates: %w", err)
+	}
+	return nil
+}
+
+// ShareObjects makes the fork mirror at forkPath borrow objects from the
+// mirror at parentPath and drops its own copies of the objects the parent
+// has. The parent is set to keep unreachable objects, since the fork may
+// still need ones the parent no longer references.
+func (c *ShellGitClient) ShareObjects(ctx context.Context, forkPath, parentPath string) error {
+	objects, err := ObjectsDir(parentPath)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, c.gitPath, "-C", parentPath, "config", "gc.pruneExpire", "never").CombinedOutput(); err != nil {
+		return fmt.Errorf("protecting shared objects: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if err := SetAlternates(forkPath, objects); err != nil {
+		return err
+	}
+
+	if c.logFunc != nil {
+		c.logFunc("Git CLI repack -a -d -l %s", forkPath)
+	}
+	cmd := exec.CommandContext(ctx, c.gitPath, "-C", forkPath, "repack", "-a", "-d", "-l", "-q")
+	cmd.Env = c.env()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git repack failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
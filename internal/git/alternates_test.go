@@ -0,0 +1,62 @@
+package git
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestSetAlternates(t *testing.T) {
+	repo := filepath.Join(t.TempDir(), "repo.git")
+	if dirs, err := Alternates(repo); err != nil || len(dirs) != 0 {
+		t.Fatalf("Alternates() = %v, %v; want none", dirs, err)
+	}
+	for _, objects := range []string{"/backups/a/repo.git/objects", "/backups/b/repo.git/objects"} {
+		if err := SetAlternates(repo, objects); err != nil {
+			t.Fatal(err)
+		}
+		dirs, err := Alternates(repo)
+		if err != nil || len(dirs) != 1 || dirs[0] != objects {
+			t.Errorf("Alternates() = %v, %v; want [%s]", dirs, err, objects)
+		}
+	}
+}
+
+func TestShareObjects(t *testing.T) {
+	parent := exportTestMirror(t)
+	fork := filepath.Join(t.TempDir(), "fork.git")
+	if out, err := exec.Command("git", "clone", "-q", "--mirror", "--no-local", parent, fork).CombinedOutput(); err != nil {
+		t.Fatalf("git clone: %v\n%s", err, out)
+	}
+	before := DirSize(filepath.Join(fork, "objects"))
+
+	c := NewShellGitClient()
+	if err := c.ShareObjects(context.Background(), fork, parent); err != nil {
+		t.Fatalf("ShareObjects() error = %v", err)
+	}
+	if after := DirSize(filepath.Join(fork, "objects")); after >= before {
+		t.Errorf("fork objects = %d bytes, want less than %d", after, before)
+	}
+	if out, err := exec.Command("git", "-C", fork, "fsck", "--connectivity-only").CombinedOutput(); err != nil {
+		t.Errorf("git fsck: %v\n%s", err, out)
+	}
+	if out, _ := exec.Command("git", "-C", parent, "config", "gc.pruneExpire").Output(); string(out) != "never\n" {
+		t.Errorf("parent gc.pruneExpire = %q, want never", out)
+	}
+
+	// go-git reads the borrowed objects too
+	r, err := OpenRepository(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := r.Reference(plumbing.NewBranchReferenceName("main"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CommitObject(ref.Hash()); err != nil {
+		t.Errorf("reading commit through alternates: %v", err)
+	}
+}
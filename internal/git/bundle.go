@@ -0,0 +1,167 @@
+// Package git provides git operations for repository backup.
+// This file implements incremental bundles of a mirror clone.
+package git
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrEmptyBundle is returned by CreateBundle when the refs add no objects
+// beyond the prerequisites, so there is nothing to ship.
+var ErrEmptyBundle = errors.New("no new commits to bundle")
+
+// CreateBundle writes a git bundle to dest holding refs, as name to hash,
+// and the objects they need beyond the commits in prerequisites. The refs
+// need not be the mirror's current refs: they are set in a scratch
+// repository borrowing the mirror's objects, so the mirror is only read.
+// Prerequisites missing from the mirror, e.g. after a force push and gc,
+// are left out, which makes the bundle larger but still complete. It
+// needs the git CLI.
+func CreateBundle(ctx context.Context, repoPath string, refs map[string]string, prerequisites []string, dest string) error {
+	if len(refs) == 0 {
+		return ErrEmptyBundle
+	}
+	objects := filepath.Join(gitDir(repoPath), "objects")
+	if _, err := os.Stat(objects); err != nil {
+		return fmt.Errorf("reading mirror objects: %w", err)
+	}
+
+	scratch, err := os.MkdirTemp("", "bb-backup-bundle-")
+	if err != nil {
+		return fmt.Errorf("creating scratch repository: %w", err)
+	}
+	defer os.RemoveAll(scratch) //nolint:errcheck // scratch directory
+
+	if _, err := runGit(ctx, "", "", "init", "--quiet", "--bare", scratch); err != nil {
+		return err
+	}
+	absObjects, err := filepath.Abs(objects)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(scratch, "objects", "info", "alternates"), []byte(absObjects+"\n"), 0644); err != nil {
+		return fmt.Errorf("linking mirror objects: %w", err)
+	}
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var updates strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&updates, "create %s %s\n", name, refs[name])
+	}
+	if _, err := runGit(ctx, scratch, updates.String(), "update-ref", "--stdin"); err != nil {
+		return fmt.Errorf("setting bundle refs: %w", err)
+	}
+
+	present, err := existingObjects(ctx, scratch, prerequisites)
+	if err != nil {
+		return err
+	}
+	var excludes strings.Builder
+	for _, hash := range present {
+		fmt.Fprintf(&excludes, "^%s\n", hash)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("creating bundle directory: %w", err)
+	}
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+	if out, err := runGit(ctx, scratch, excludes.String(), "bundle", "create", "--quiet", absDest, "--all", "--stdin"); err != nil {
+		if strings.Contains(out, "empty bundle") {
+			return ErrEmptyBundle
+		}
+		return err
+	}
+	return nil
+}
+
+// VerifyBundle checks the git bundle at path. When the bundle needs no
+// prerequisites, or repoPath has them, git verifies every object in it and
+// complete is true; otherwise only its header and refs can be read and
+// complete is false. repoPath may be empty. It needs the git CLI.
+func VerifyBundle(ctx context.Context, path, repoPath string) (complete bool, err error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	scratch, err := os.MkdirTemp("", "bb-backup-bundle-")
+	if err != nil {
+		return false, fmt.Errorf("creating scratch repository: %w", err)
+	}
+	defer os.RemoveAll(scratch) //nolint:errcheck // scratch directory
+
+	if _, err := runGit(ctx, "", "", "init", "--quiet", "--bare", scratch); err != nil {
+		return false, err
+	}
+	if repoPath != "" {
+		absObjects, err := filepath.Abs(filepath.Join(gitDir(repoPath), "objects"))
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(filepath.Join(scratch, "objects", "info", "alternates"), []byte(absObjects+"\n"), 0644); err != nil {
+			return false, fmt.Errorf("linking mirror objects: %w", err)
+		}
+	}
+
+	// verify reads the header and checks prerequisites; unbundling into
+	// the scratch repository then indexes, and so checks, every object
+	out, err := runGit(ctx, scratch, "", "bundle", "verify", absPath)
+	if err != nil {
+		if !strings.Contains(out, "prerequisite") {
+			return false, err
+		}
+		if _, err := runGit(ctx, scratch, "", "bundle", "list-heads", absPath); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	if _, err := runGit(ctx, scratch, "", "bundle", "unbundle", absPath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// existingObjects returns the hashes in hashes that repoPath has.
+func existingObjects(ctx context.Context, repoPath string, hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	out, err := runGit(ctx, repoPath, strings.Join(hashes, "\n")+"\n", "cat-file", "--batch-check=%(objectname)")
+	if err != nil {
+		return nil, fmt.Errorf("checking bundle prerequisites: %w", err)
+	}
+	var present []string
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		// Missing objects are reported as "<hash> missing"
+		if line := sc.Text(); line != "" && !strings.HasSuffix(line, " missing") {
+			present = append(present, line)
+		}
+	}
+	return present, nil
+}
+
+// runGit runs the git CLI in dir with stdin and returns its combined output.
+func runGit(ctx context.Context, dir, stdin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
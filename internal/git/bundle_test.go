@@ -0,0 +1,124 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateBundle(t *testing.T) {
+	if !IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+
+	repoDir := filepath.Join(t.TempDir(), "repo")
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(repoDir, "init", "-q", "-b", "main")
+	git(repoDir, "commit", "-q", "--allow-empty", "-m", "one")
+	one := git(repoDir, "rev-parse", "HEAD")
+	git(repoDir, "commit", "-q", "--allow-empty", "-m", "two")
+	two := git(repoDir, "rev-parse", "HEAD")
+	git(repoDir, "commit", "-q", "--allow-empty", "-m", "three")
+
+	// The bundle is cut at two, not at the repository's current main, and
+	// a prerequisite the repository lacks is dropped rather than failing
+	dest := filepath.Join(t.TempDir(), "out", "repo.bundle")
+	refs := map[string]string{"refs/heads/main": two, "refs/tags/v1": one}
+	missing := strings.Repeat("d", 40)
+	if err := CreateBundle(context.Background(), repoDir, refs, []string{one, missing}, dest); err != nil {
+		t.Fatalf("CreateBundle() error = %v", err)
+	}
+	heads := git(repoDir, "bundle", "list-heads", dest)
+	if !strings.Contains(heads, two+" refs/heads/main") || strings.Contains(heads, "refs/tags/v1") {
+		t.Errorf("bundle heads = %q, want main at %s only", heads, two)
+	}
+
+	// The bundle applies on top of a copy that has the prerequisite
+	target := filepath.Join(t.TempDir(), "target.git")
+	git(repoDir, "init", "-q", "--bare", target)
+	git(repoDir, "push", "-q", target, one+":refs/heads/main")
+	git(target, "fetch", "-q", dest, "+refs/*:refs/*")
+	if got := git(target, "rev-parse", "refs/heads/main"); got != two {
+		t.Errorf("main after applying the bundle = %s, want %s", got, two)
+	}
+
+	err := CreateBundle(context.Background(), repoDir, map[string]string{"refs/heads/main": one}, []string{two}, filepath.Join(t.TempDir(), "empty.bundle"))
+	if !errors.Is(err, ErrEmptyBundle) {
+		t.Errorf("CreateBundle() with nothing new = %v, want ErrEmptyBundle", err)
+	}
+}
+
+func TestVerifyBundle(t *testing.T) {
+	if !IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+
+	repoDir := filepath.Join(t.TempDir(), "repo")
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repoDir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "one")
+	one := git("rev-parse", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "two")
+	two := git("rev-parse", "HEAD")
+
+	ctx := context.Background()
+	full := filepath.Join(t.TempDir(), "full.bundle")
+	delta := filepath.Join(t.TempDir(), "delta.bundle")
+	if err := CreateBundle(ctx, repoDir, map[string]string{"refs/heads/main": two}, nil, full); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateBundle(ctx, repoDir, map[string]string{"refs/heads/main": two}, []string{one}, delta); err != nil {
+		t.Fatal(err)
+	}
+
+	if complete, err := VerifyBundle(ctx, full, ""); err != nil || !complete {
+		t.Errorf("VerifyBundle(full) = %v, %v; want complete", complete, err)
+	}
+	if complete, err := VerifyBundle(ctx, delta, ""); err != nil || complete {
+		t.Errorf("VerifyBundle(delta) without the mirror = %v, %v; want readable but incomplete", complete, err)
+	}
+	if complete, err := VerifyBundle(ctx, delta, repoDir); err != nil || !complete {
+		t.Errorf("VerifyBundle(delta) with the mirror = %v, %v; want complete", complete, err)
+	}
+
+	data, err := os.ReadFile(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, data[:len(data)-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyBundle(ctx, full, ""); err == nil {
+		t.Error("VerifyBundle() accepted a truncated bundle")
+	}
+}
@@ -0,0 +1,61 @@
+package git
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// outputCodes maps phrases in git's output, lowercased, to failure codes.
+// The first match wins, so more specific phrases come first.
+var outputCodes = []struct {
+	phrase string
+	code   errcode.Code
+}{
+	{"no space left on device", errcode.StorageFull},
+	{"disk quota exceeded", errcode.StorageFull},
+	{"repository not found", errcode.RepoNotFound},
+	{"does not appear to be a git repository", errcode.RepoNotFound},
+	{"authentication failed", errcode.AuthFailed},
+	{"authentication required", errcode.AuthFailed},
+	{"could not read username", errcode.AuthFailed},
+	{"invalid username or password", errcode.AuthFailed},
+	{"permission denied (publickey", errcode.AuthFailed},
+	{"the requested url returned error: 401", errcode.AuthFailed},
+	{"the requested url returned error: 403", errcode.PermissionDenied},
+	{"the requested url returned error: 404", errcode.RepoNotFound},
+	{"could not resolve host", errcode.NetworkError},
+	{"failed to connect", errcode.NetworkError},
+	{"connection refused", errcode.NetworkError},
+	{"connection reset", errcode.NetworkError},
+	{"connection timed out", errcode.NetworkError},
+	{"ssl certificate", errcode.NetworkError},
+	{"tls handshake", errcode.NetworkError},
+	{"gnutls", errcode.NetworkError},
+}
+
+// codedError attaches a failure code to a clone or fetch error, judged
+// from go-git's sentinel errors and then from git's output, which for the
+// CLI is its stderr. Unrecognized failures are GIT_FAILED.
+func codedError(err error, output string) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired):
+		return errcode.Wrap(errcode.AuthFailed, err)
+	case errors.Is(err, transport.ErrAuthorizationFailed):
+		return errcode.Wrap(errcode.PermissionDenied, err)
+	case errors.Is(err, transport.ErrRepositoryNotFound):
+		return errcode.Wrap(errcode.RepoNotFound, err)
+	}
+	text := strings.ToLower(output + "\n" + err.Error())
+	for _, c := range outputCodes {
+		if strings.Contains(text, c.phrase) {
+			return errcode.Wrap(c.code, err)
+		}
+	}
+	return errcode.Wrap(errcode.GitFailed, err)
+}
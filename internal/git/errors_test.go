@@ -0,0 +1,40 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+func TestCodedError(t *testing.T) {
+	exit := errors.New("exit status 128")
+	for _, tc := range []struct {
+		err    error
+		output string
+		want   errcode.Code
+	}{
+		{exit, "remote: Repository not found.\nfatal: repository 'https://bitbucket.org/ws/gone.git/' not found", errcode.RepoNotFound},
+		{exit, "fatal: Authentication failed for 'https://bitbucket.org/ws/repo.git/'", errcode.AuthFailed},
+		{exit, "git@bitbucket.org: Permission denied (publickey).", errcode.AuthFailed},
+		{exit, "fatal: unable to access '...': The requested URL returned error: 403", errcode.PermissionDenied},
+		{exit, "fatal: unable to access '...': Could not resolve host: bitbucket.org", errcode.NetworkError},
+		{exit, "error: unable to write file objects/pack/tmp_pack: No space left on device", errcode.StorageFull},
+		{exit, "fatal: the remote end hung up unexpectedly", errcode.GitFailed},
+		{fmt.Errorf("git clone failed: %w", transport.ErrAuthenticationRequired), "", errcode.AuthFailed},
+		{fmt.Errorf("fetching from origin: %w", transport.ErrRepositoryNotFound), "", errcode.RepoNotFound},
+	} {
+		err := codedError(tc.err, tc.output)
+		if got := errcode.Of(err); got != tc.want {
+			t.Errorf("codedError(%v, %q) code = %q, want %q", tc.err, tc.output, got, tc.want)
+		}
+		if !errors.Is(err, tc.err) {
+			t.Errorf("codedError(%v) does not wrap the error", tc.err)
+		}
+	}
+	if codedError(nil, "") != nil {
+		t.Error("codedError(nil) != nil")
+	}
+}
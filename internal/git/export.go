@@ -0,0 +1,162 @@
+// Package git provides git operations for repository backup.
+// This file implements exporting a checked-out tree from a mirror clone.
+package git
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrRefNotFound is returned when the ref to export does not exist in a
+// repository, e.g. a branch that only some repositories have.
+var ErrRefNotFound = errors.New("ref not found")
+
+// ExportWorktree writes the files of ref in a mirror clone to destDir, as a
+// checkout would, and returns the commit hash exported. An empty ref
+// exports HEAD (the default branch). Submodules are skipped.
+func ExportWorktree(repoPath, ref, destDir string) (string, error) {
+	return walkWorktree(repoPath, ref, func(f *object.File, _ time.Time) error {
+		dest := filepath.Join(destDir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dest)
+		}
+
+		perm := os.FileMode(0644)
+		if f.Mode == filemode.Executable {
+			perm = 0755
+		}
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// ArchiveWorktree writes the files of ref in a mirror clone to tw under
+// prefix, and returns the commit hash exported. Entries carry the commit
+// time so archives of the same commit are identical.
+func ArchiveWorktree(repoPath, ref, prefix string, tw *tar.Writer) (string, error) {
+	written := make(map[string]bool)
+	return walkWorktree(repoPath, ref, func(f *object.File, modTime time.Time) error {
+		name := path.Join(prefix, f.Name)
+		if err := writeTarDir(tw, path.Dir(name), written, modTime); err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{Name: name, ModTime: modTime, Mode: 0644, Format: tar.FormatPAX}
+		switch f.Mode {
+		case filemode.Symlink:
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+			return tw.WriteHeader(hdr)
+		case filemode.Executable:
+			hdr.Mode = 0755
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = f.Size
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(tw, r)
+		return err
+	})
+}
+
+// writeTarDir writes entries for dir and any parents not yet written, so
+// extractors don't have to guess directory permissions.
+func writeTarDir(tw *tar.Writer, dir string, written map[string]bool, modTime time.Time) error {
+	if dir == "." || dir == "/" || written[dir] {
+		return nil
+	}
+	if err := writeTarDir(tw, path.Dir(dir), written, modTime); err != nil {
+		return err
+	}
+	written[dir] = true
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dir + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+}
+
+// walkWorktree resolves ref in a mirror clone and calls fn for every file
+// in its tree, with the commit time. It returns the commit hash.
+func walkWorktree(repoPath, ref string, fn func(f *object.File, modTime time.Time) error) (string, error) {
+	repo, err := OpenRepository(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return "", fmt.Errorf("%s: %w", ref, ErrRefNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return "", fmt.Errorf("reading commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("reading tree of %s: %w", hash, err)
+	}
+
+	modTime := commit.Committer.When
+	err = tree.Files().ForEach(func(f *object.File) error {
+		// Tree entries come from the remote; refuse anything that would
+		// land outside the destination
+		if !filepath.IsLocal(filepath.FromSlash(f.Name)) {
+			return fmt.Errorf("unsafe path %q in tree", f.Name)
+		}
+		if err := fn(f, modTime); err != nil {
+			return fmt.Errorf("exporting %s: %w", f.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
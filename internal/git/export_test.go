@@ -0,0 +1,161 @@
+package git
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// exportTestMirror builds a mirror with a nested file, an executable, and a
+// symlink on main, and an older tag v1.
+func exportTestMirror(t *testing.T) string {
+	t.Helper()
+	if !IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+	work := filepath.Join(t.TempDir(), "work")
+	run := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name, content string, perm os.FileMode) {
+		t.Helper()
+		path := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "init", "-q", "-b", "main")
+	write("README.md", "v1\n", 0644)
+	run(work, "add", ".")
+	run(work, "commit", "-q", "-m", "one")
+	run(work, "tag", "v1")
+
+	write("README.md", "v2\n", 0644)
+	write("src/app/main.go", "package main\n", 0644)
+	write("build.sh", "#!/bin/sh\n", 0755)
+	if err := os.Symlink("README.md", filepath.Join(work, "LINK")); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "add", ".")
+	run(work, "commit", "-q", "-m", "two")
+
+	mirror := filepath.Join(t.TempDir(), "repo.git")
+	run(work, "clone", "-q", "--mirror", work, mirror)
+	return mirror
+}
+
+func TestExportWorktree(t *testing.T) {
+	mirror := exportTestMirror(t)
+	dest := filepath.Join(t.TempDir(), "out")
+
+	commit, err := ExportWorktree(mirror, "main", dest)
+	if err != nil {
+		t.Fatalf("ExportWorktree() error = %v", err)
+	}
+	if len(commit) != 40 {
+		t.Errorf("commit = %q", commit)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "src", "app", "main.go")); string(data) != "package main\n" {
+		t.Errorf("nested file = %q", data)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "build.sh")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("build.sh not executable: %v %v", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "LINK")); err != nil || target != "README.md" {
+		t.Errorf("LINK = %q, %v", target, err)
+	}
+
+	// Tags and the default branch resolve too
+	tagDest := filepath.Join(t.TempDir(), "tag")
+	if _, err := ExportWorktree(mirror, "v1", tagDest); err != nil {
+		t.Fatalf("export v1: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tagDest, "README.md")); string(data) != "v1\n" {
+		t.Errorf("v1 README = %q", data)
+	}
+	if head, err := ExportWorktree(mirror, "", filepath.Join(t.TempDir(), "head")); err != nil || head != commit {
+		t.Errorf("HEAD export = %s, %v; want %s", head, err, commit)
+	}
+
+	if _, err := ExportWorktree(mirror, "no-such-branch", filepath.Join(t.TempDir(), "x")); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("missing ref error = %v, want ErrRefNotFound", err)
+	}
+}
+
+func TestArchiveWorktree(t *testing.T) {
+	mirror := exportTestMirror(t)
+	path := filepath.Join(t.TempDir(), "out.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	if _, err := ArchiveWorktree(mirror, "main", "projects/CORE/api", tw); err != nil {
+		t.Fatalf("ArchiveWorktree() error = %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := hdr.Name
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			entry += " -> " + hdr.Linkname
+		case tar.TypeReg:
+			if hdr.Mode&0100 != 0 {
+				entry += " (x)"
+			}
+		}
+		names = append(names, entry)
+	}
+	sort.Strings(names)
+	want := strings.Join([]string{
+		"projects/",
+		"projects/CORE/",
+		"projects/CORE/api/",
+		"projects/CORE/api/LINK -> README.md",
+		"projects/CORE/api/README.md",
+		"projects/CORE/api/build.sh (x)",
+		"projects/CORE/api/src/",
+		"projects/CORE/api/src/app/",
+		"projects/CORE/api/src/app/main.go",
+	}, "\n")
+	if got := strings.Join(names, "\n"); got != want {
+		t.Errorf("entries:\n%s\nwant:\n%s", got, want)
+	}
+}
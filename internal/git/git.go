@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/format"
 )
 
 // LogFunc is called to log debug messages.
@@ -34,13 +36,13 @@ func CloneMirrorWithLog(ctx context.Context, repoURL, destPath string, logFunc L
 	cmd := exec.CommandContext(ctx, "git", "clone", "--mirror", repoURL, destPath)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("git clone --mirror failed: %w\nOutput: %s", err, string(output))
+		return codedError(fmt.Errorf("git clone --mirror failed: %w\nOutput: %s", err, string(output)), string(output))
 	}
 
 	if logFunc != nil {
 		elapsed := time.Since(startTime)
 		size := getDirSize(destPath)
-		logFunc("  Clone completed (took %s, %s)", elapsed.Round(time.Millisecond), formatBytes(size))
+		logFunc("  Clone completed (took %s, %s, %s)", format.Duration(elapsed), format.Bytes(size), format.Rate(size, elapsed))
 	}
 
 	return nil
@@ -63,7 +65,7 @@ func FetchWithLog(ctx context.Context, repoPath string, logFunc LogFunc) error {
 	cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "fetch", "--all", "--prune")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("git fetch failed: %w\nOutput: %s", err, string(output))
+		return codedError(fmt.Errorf("git fetch failed: %w\nOutput: %s", err, string(output)), string(output))
 	}
 
 	if logFunc != nil {
@@ -72,11 +74,11 @@ func FetchWithLog(ctx context.Context, repoPath string, logFunc LogFunc) error {
 		delta := sizeAfter - sizeBefore
 		deltaStr := ""
 		if delta > 0 {
-			deltaStr = fmt.Sprintf(", +%s", formatBytes(delta))
+			deltaStr = fmt.Sprintf(", +%s", format.Bytes(delta))
 		} else if delta < 0 {
-			deltaStr = fmt.Sprintf(", %s", formatBytes(delta))
+			deltaStr = fmt.Sprintf(", %s", format.Bytes(delta))
 		}
-		logFunc("  Fetch completed (took %s, %s%s)", elapsed.Round(time.Millisecond), formatBytes(sizeAfter), deltaStr)
+		logFunc("  Fetch completed (took %s, %s%s)", format.Duration(elapsed), format.Bytes(sizeAfter), deltaStr)
 	}
 
 	return nil
@@ -138,6 +140,11 @@ func maskCredentials(repoURL string) string {
 	return parsed.String()
 }
 
+// DirSize returns the total size of a directory in bytes.
+func DirSize(path string) int64 {
+	return getDirSize(path)
+}
+
 // getDirSize returns the total size of a directory in bytes.
 func getDirSize(path string) int64 {
 	var size int64
@@ -152,17 +159,3 @@ func getDirSize(path string) int64 {
 	})
 	return size
 }
-
-// formatBytes formats a byte count as a human-readable string.
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMG"[exp])
-}
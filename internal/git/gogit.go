@@ -12,13 +12,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/andy-wilson/bb-backup/internal/format"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
-	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
 
 	"github.com/go-git/go-billy/v5/osfs"
 )
@@ -29,10 +30,16 @@ type ProgressCallback func(stage string, current, total int64)
 // RateLimitFunc is called before each HTTP request to enforce rate limiting.
 type RateLimitFunc func()
 
+// CredentialFunc returns the username and password for a git operation. It
+// is called before each clone or fetch, with the operation's context, so
+// rotated credentials and per-repository credential sets are used.
+type CredentialFunc func(ctx context.Context) (username, password string, err error)
+
 // GoGitClient provides git operations using go-git.
 type GoGitClient struct {
 	username      string
 	password      string
+	credFunc      CredentialFunc
 	logFunc       LogFunc
 	progressFunc  ProgressCallback
 	rateLimitFunc RateLimitFunc
@@ -52,6 +59,14 @@ func WithCredentials(username, password string) GoGitOption {
 	}
 }
 
+// WithCredentialFunc sets a function that supplies credentials for each
+// operation, overriding WithCredentials.
+func WithCredentialFunc(f CredentialFunc) GoGitOption {
+	return func(c *GoGitClient) {
+		c.credFunc = f
+	}
+}
+
 // WithLogger sets the log function for debug output.
 func WithLogger(logFunc LogFunc) GoGitOption {
 	return func(c *GoGitClient) {
@@ -89,17 +104,23 @@ func NewGoGitClient(opts ...GoGitOption) *GoGitClient {
 	return c
 }
 
-// rateLimitedTransport wraps an http.RoundTripper to add rate limiting.
+// rateLimitedTransport wraps an http.RoundTripper to add rate limiting and
+// count the bytes each operation receives.
 type rateLimitedTransport struct {
 	base          http.RoundTripper
 	rateLimitFunc RateLimitFunc
+	progressFunc  ProgressCallback
 }
 
 func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if t.rateLimitFunc != nil {
 		t.rateLimitFunc()
 	}
-	return t.base.RoundTrip(req)
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		countResponse(req, resp, t.progressFunc)
+	}
+	return resp, err
 }
 
 // setupHTTPClient configures a custom HTTP client with rate limiting.
@@ -108,6 +129,7 @@ func (c *GoGitClient) setupHTTPClient() {
 		transport := &rateLimitedTransport{
 			base:          http.DefaultTransport,
 			rateLimitFunc: c.rateLimitFunc,
+			progressFunc:  c.progressFunc,
 		}
 		c.httpClient = &http.Client{
 			Transport: transport,
@@ -121,16 +143,33 @@ func (c *GoGitClient) setupHTTPClient() {
 
 // getAuth returns the authentication for git operations.
 func (c *GoGitClient) getAuth() transport.AuthMethod {
-	if c.username == "" && c.password == "" {
+	return basicAuth(c.username, c.password)
+}
+
+// resolveAuth returns the authentication for the next git operation, from
+// the credential function when one is set.
+func (c *GoGitClient) resolveAuth(ctx context.Context) (transport.AuthMethod, error) {
+	if c.credFunc == nil {
+		return c.getAuth(), nil
+	}
+	username, password, err := c.credFunc(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting credentials: %w", err)
+	}
+	return basicAuth(username, password), nil
+}
+
+func basicAuth(username, password string) transport.AuthMethod {
+	if username == "" && password == "" {
 		return nil
 	}
 	return &githttp.BasicAuth{
-		Username: c.username,
-		Password: c.password,
+		Username: username,
+		Password: password,
 	}
 }
 
-// progressWriter wraps progress reporting.
+// progressWriter logs the server's progress messages.
 type progressWriter struct {
 	logFunc LogFunc
 }
@@ -142,6 +181,26 @@ func (w *progressWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// progressOutput returns where go-git writes the server's progress
+// messages: the debug log and the operation's progress callback, or nil
+// for neither, in which case the server sends none.
+func (c *GoGitClient) progressOutput(ctx context.Context) io.Writer {
+	var writers []io.Writer
+	if c.logFunc != nil {
+		writers = append(writers, &progressWriter{logFunc: c.logFunc})
+	}
+	if p := progressFor(ctx, c.progressFunc); p != nil {
+		writers = append(writers, &progressParser{progress: p})
+	}
+	switch len(writers) {
+	case 0:
+		return nil
+	case 1:
+		return writers[0]
+	}
+	return io.MultiWriter(writers...)
+}
+
 // CloneMirror performs a mirror clone of a repository.
 func (c *GoGitClient) CloneMirror(ctx context.Context, repoURL, destPath string) error {
 	c.setupHTTPClient()
@@ -163,18 +222,19 @@ func (c *GoGitClient) CloneMirror(ctx context.Context, repoURL, destPath string)
 		// For bare repos, use the root
 		dot = fs
 	}
-	storage := filesystem.NewStorage(dot, nil)
+	storage := newStorage(dot, nil)
 
-	// Progress writer
-	var progress io.Writer
-	if c.logFunc != nil {
-		progress = &progressWriter{logFunc: c.logFunc}
+	progress := c.progressOutput(ctx)
+
+	auth, err := c.resolveAuth(ctx)
+	if err != nil {
+		return err
 	}
 
 	// Clone with mirror option
 	repo, err := git.CloneContext(ctx, storage, nil, &git.CloneOptions{
 		URL:      repoURL,
-		Auth:     c.getAuth(),
+		Auth:     auth,
 		Mirror:   true,
 		Progress: progress,
 	})
@@ -196,7 +256,7 @@ func (c *GoGitClient) CloneMirror(ctx context.Context, repoURL, destPath string)
 		}
 		// Clean up on failure
 		_ = os.RemoveAll(destPath)
-		return fmt.Errorf("git clone failed: %w", err)
+		return codedError(fmt.Errorf("git clone failed: %w", err), "")
 	}
 
 	// Verify the clone worked
@@ -211,10 +271,10 @@ func (c *GoGitClient) CloneMirror(ctx context.Context, repoURL, destPath string)
 	if c.logFunc != nil {
 		elapsed := time.Since(startTime)
 		if c.skipSizeCalc {
-			c.logFunc("  Clone completed (took %s)", elapsed.Round(time.Millisecond))
+			c.logFunc("  Clone completed (took %s)", format.Duration(elapsed))
 		} else {
 			size := getDirSize(destPath)
-			c.logFunc("  Clone completed (took %s, %s)", elapsed.Round(time.Millisecond), formatBytes(size))
+			c.logFunc("  Clone completed (took %s, %s, %s)", format.Duration(elapsed), format.Bytes(size), format.Rate(size, elapsed))
 		}
 	}
 
@@ -235,32 +295,15 @@ func (c *GoGitClient) Fetch(ctx context.Context, repoPath string) error {
 		sizeBefore = getDirSize(repoPath)
 	}
 
-	// Open the existing repository
-	// Check if git data is in .git subdirectory (go-git style) or directly in repoPath (bare style)
-	fs := osfs.New(repoPath)
-	var storage *filesystem.Storage
-	if _, err := os.Stat(repoPath + "/.git"); err == nil {
-		// go-git nested style: repo.git/.git/
-		dot, err := fs.Chroot(".git")
-		if err != nil {
-			return fmt.Errorf("accessing .git directory: %w", err)
-		}
-		storage = filesystem.NewStorage(dot, nil)
-	} else {
-		// Standard bare repo style: repo.git/
-		storage = filesystem.NewStorage(fs, nil)
-	}
-
-	repo, err := git.Open(storage, nil)
+	// Open the existing repository with an object cache: go-git looks up
+	// the objects the mirror already has while negotiating, and panics on
+	// packed objects without one
+	repo, err := OpenRepository(repoPath)
 	if err != nil {
-		return fmt.Errorf("opening repository: %w", err)
+		return err
 	}
 
-	// Progress writer
-	var progress io.Writer
-	if c.logFunc != nil {
-		progress = &progressWriter{logFunc: c.logFunc}
-	}
+	progress := c.progressOutput(ctx)
 
 	// Fetch all remotes
 	remotes, err := repo.Remotes()
@@ -269,8 +312,12 @@ func (c *GoGitClient) Fetch(ctx context.Context, repoPath string) error {
 	}
 
 	for _, remote := range remotes {
-		err := remote.FetchContext(ctx, &git.FetchOptions{
-			Auth:     c.getAuth(),
+		auth, err := c.resolveAuth(ctx)
+		if err != nil {
+			return err
+		}
+		err = remote.FetchContext(ctx, &git.FetchOptions{
+			Auth:     auth,
 			Progress: progress,
 			Prune:    true,
 			RefSpecs: []config.RefSpec{
@@ -278,35 +325,68 @@ func (c *GoGitClient) Fetch(ctx context.Context, repoPath string) error {
 			},
 		})
 		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
-			return fmt.Errorf("fetching from %s: %w", remote.Config().Name, err)
+			return codedError(fmt.Errorf("fetching from %s: %w", remote.Config().Name, err), "")
 		}
 	}
 
 	if c.logFunc != nil {
 		elapsed := time.Since(startTime)
 		if c.skipSizeCalc {
-			c.logFunc("  Fetch completed (took %s)", elapsed.Round(time.Millisecond))
+			c.logFunc("  Fetch completed (took %s)", format.Duration(elapsed))
 		} else {
 			sizeAfter := getDirSize(repoPath)
 			delta := sizeAfter - sizeBefore
 			deltaStr := ""
 			if delta > 0 {
-				deltaStr = fmt.Sprintf(", +%s", formatBytes(delta))
+				deltaStr = fmt.Sprintf(", +%s", format.Bytes(delta))
 			} else if delta < 0 {
-				deltaStr = fmt.Sprintf(", %s", formatBytes(delta))
+				deltaStr = fmt.Sprintf(", %s", format.Bytes(delta))
 			}
-			c.logFunc("  Fetch completed (took %s, %s%s)", elapsed.Round(time.Millisecond), formatBytes(sizeAfter), deltaStr)
+			c.logFunc("  Fetch completed (took %s, %s%s)", format.Duration(elapsed), format.Bytes(sizeAfter), deltaStr)
 		}
 	}
 
 	return nil
 }
 
+// ListRemoteRefs returns the hash references a remote advertises, keyed by
+// name, without fetching anything. Symbolic references such as HEAD and
+// peeled tags are left out, matching ReadRefs on a mirror of the remote.
+func (c *GoGitClient) ListRemoteRefs(ctx context.Context, repoURL string) (map[string]string, error) {
+	c.setupHTTPClient()
+
+	auth, err := c.resolveAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repoURL},
+	})
+	advertised, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:          auth,
+		PeelingOption: git.IgnorePeeled,
+	})
+	refs := make(map[string]string)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing remote refs: %w", err)
+	}
+	for _, ref := range advertised {
+		if ref.Type() == plumbing.HashReference {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+	}
+	return refs, nil
+}
+
 // Fsck verifies repository integrity using go-git.
 func (c *GoGitClient) Fsck(_ context.Context, repoPath string) error {
 	// Open the existing repository
 	fs := osfs.New(repoPath)
-	storage := filesystem.NewStorage(fs, nil)
+	storage := newStorage(fs, nil)
 
 	repo, err := git.Open(storage, nil)
 	if err != nil {
@@ -345,7 +425,7 @@ func maskCredentialsInURL(repoURL string) string {
 func (c *GoGitClient) initEmptyMirror(destPath, repoURL string) error {
 	// Set up filesystem storage for bare repo
 	fs := osfs.New(destPath)
-	storage := filesystem.NewStorage(fs, nil)
+	storage := newStorage(fs, nil)
 
 	// Initialize empty repo
 	repo, err := git.Init(storage, nil)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 )
@@ -271,3 +272,47 @@ func TestMaskCredentialsInURL(t *testing.T) {
 	}
 }
 
+func TestGoGitClient_ListRemoteRefs(t *testing.T) {
+	if !IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	run("init", "-q", "-b", "main", src)
+	run("-C", src, "commit", "-q", "--allow-empty", "-m", "one")
+	run("-C", src, "tag", "-a", "v1", "-m", "v1")
+	mirror := filepath.Join(dir, "mirror.git")
+	run("clone", "-q", "--mirror", src, mirror)
+
+	client := NewGoGitClient()
+	remote, err := client.ListRemoteRefs(context.Background(), src)
+	if err != nil {
+		t.Fatalf("ListRemoteRefs() error = %v", err)
+	}
+	local, err := ReadRefs(mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remote) != 2 || len(DiffRefs(local, remote)) != 0 {
+		t.Errorf("ListRemoteRefs() = %v, want the mirror's refs %v", remote, local)
+	}
+
+	run("-C", src, "commit", "-q", "--allow-empty", "-m", "two")
+	remote, err = client.ListRemoteRefs(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updates := DiffRefs(local, remote); len(updates) != 1 || updates[0].Name != "refs/heads/main" {
+		t.Errorf("DiffRefs() after a commit = %v, want refs/heads/main", updates)
+	}
+}
@@ -0,0 +1,163 @@
+// Package git provides git operations for repository backup.
+// This file implements compact integrity summaries for mirror clones.
+package git
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Integrity is a compact fingerprint of a mirror clone: a hash over its
+// refs and the trailer checksum of each pack. Comparing summaries from
+// consecutive runs shows refs disappearing, which fsck cannot.
+type Integrity struct {
+	GeneratedAt string          `json:"generated_at"`
+	RefCount    int             `json:"ref_count"`
+	RefsSHA256  string          `json:"refs_sha256"`
+	Packs       []PackChecksum  `json:"packs,omitempty"`
+	Previous    *IntegrityPrior `json:"previous,omitempty"`
+}
+
+// IntegrityPrior carries the ref fingerprint from the previous run so a
+// single summary can show what changed between consecutive runs.
+type IntegrityPrior struct {
+	GeneratedAt string `json:"generated_at"`
+	RefCount    int    `json:"ref_count"`
+	RefsSHA256  string `json:"refs_sha256"`
+}
+
+// PackChecksum records a pack file and the SHA-1 trailer git stores at
+// its end, which covers the whole pack.
+type PackChecksum struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// ComputeIntegrity builds the integrity summary of a mirror clone in either
+// the bare or the go-git nested layout.
+func ComputeIntegrity(repoPath string) (*Integrity, error) {
+	refs, err := ReadRefs(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	packs, err := packChecksums(filepath.Join(gitDir(repoPath), "objects", "pack"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Integrity{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		RefCount:    len(refs),
+		RefsSHA256:  HashRefs(refs),
+		Packs:       packs,
+	}, nil
+}
+
+// HashRefs returns the SHA-256 of "hash name" lines for refs sorted by name.
+func HashRefs(refs map[string]string) string {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s %s\n", refs[name], name)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Compare checks the summary against a freshly computed one for the same
+// repository and describes each difference: changed refs and missing or
+// altered packs. New packs are not reported; they cannot lose data.
+func (i *Integrity) Compare(current *Integrity) []string {
+	var problems []string
+	if i.RefsSHA256 != current.RefsSHA256 {
+		problems = append(problems, fmt.Sprintf("refs changed since backup (%d recorded, %d now)", i.RefCount, current.RefCount))
+	}
+
+	now := make(map[string]PackChecksum, len(current.Packs))
+	for _, p := range current.Packs {
+		now[p.Name] = p
+	}
+	for _, p := range i.Packs {
+		got, ok := now[p.Name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("pack %s missing", p.Name))
+		case got.Checksum != p.Checksum || got.Size != p.Size:
+			problems = append(problems, fmt.Sprintf("pack %s altered", p.Name))
+		}
+	}
+	return problems
+}
+
+// gitDir returns the directory holding objects and refs.
+func gitDir(repoPath string) string {
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
+		return filepath.Join(repoPath, ".git")
+	}
+	return repoPath
+}
+
+// packChecksums reads the trailer of each pack in dir, sorted by name.
+func packChecksums(dir string) ([]PackChecksum, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing packs: %w", err)
+	}
+
+	var packs []PackChecksum
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".pack") {
+			continue
+		}
+		pack, err := readPackTrailer(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, pack)
+	}
+	return packs, nil
+}
+
+// readPackTrailer returns the size and 20-byte trailer checksum of a pack.
+func readPackTrailer(path string) (PackChecksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return PackChecksum{}, fmt.Errorf("opening pack: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return PackChecksum{}, fmt.Errorf("reading pack: %w", err)
+	}
+	const trailerLen = 20
+	if info.Size() < trailerLen {
+		return PackChecksum{}, fmt.Errorf("pack %s is truncated", filepath.Base(path))
+	}
+
+	trailer := make([]byte, trailerLen)
+	if _, err := f.ReadAt(trailer, info.Size()-trailerLen); err != nil && err != io.EOF {
+		return PackChecksum{}, fmt.Errorf("reading pack trailer: %w", err)
+	}
+	return PackChecksum{
+		Name:     filepath.Base(path),
+		Size:     info.Size(),
+		Checksum: hex.EncodeToString(trailer),
+	}, nil
+}
@@ -0,0 +1,150 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+)
+
+// Stages reported to a ProgressCallback during a clone or fetch. Object
+// stages count objects, current of total. StageReceived counts the bytes
+// received so far, with total 0 as the size is not known ahead.
+const (
+	StageCounting    = "counting objects"
+	StageCompressing = "compressing objects"
+	StageReceiving   = "receiving objects"
+	StageResolving   = "resolving deltas"
+	StageReceived    = "received"
+)
+
+// progressStages maps the words git starts its progress lines with to
+// stages.
+var progressStages = map[string]string{
+	"Counting":    StageCounting,
+	"Compressing": StageCompressing,
+	"Receiving":   StageReceiving,
+	"Resolving":   StageResolving,
+}
+
+// progressLinePattern matches git's progress lines, from the server over
+// the sideband or printed by the git CLI, e.g.
+//
+//	remote: Compressing objects:  50% (5/10)
+//	Receiving objects:  45% (450/1000), 1.20 MiB | 600.00 KiB/s
+var progressLinePattern = regexp.MustCompile(`^(?:remote: )?(Counting|Compressing|Receiving|Resolving) (?:objects|deltas):\s+\d+% \((\d+)/(\d+)\)(?:, ([\d.]+) (bytes|KiB|MiB|GiB|TiB))?`)
+
+// byteUnits are the multipliers of the sizes in git's progress lines.
+var byteUnits = map[string]float64{
+	"bytes": 1,
+	"KiB":   1 << 10,
+	"MiB":   1 << 20,
+	"GiB":   1 << 30,
+	"TiB":   1 << 40,
+}
+
+type progressKey struct{}
+
+// operationProgress is the progress callback of one repository's git
+// operations and the bytes its HTTP responses have delivered so far.
+type operationProgress struct {
+	report   ProgressCallback
+	received atomic.Int64
+}
+
+// WithProgressFunc returns a context whose clone and fetch operations
+// report transfer progress to f: object counts from the server and the git
+// CLI, and the bytes received. It takes precedence over the client's
+// WithProgress callback, so one client can report per repository. f may be
+// called from several goroutines and should return quickly.
+func WithProgressFunc(ctx context.Context, f ProgressCallback) context.Context {
+	return context.WithValue(ctx, progressKey{}, &operationProgress{report: f})
+}
+
+// progressFor returns the progress of ctx's operation, or one reporting to
+// fallback, or nil when neither is set.
+func progressFor(ctx context.Context, fallback ProgressCallback) *operationProgress {
+	if p, ok := ctx.Value(progressKey{}).(*operationProgress); ok {
+		return p
+	}
+	if fallback != nil {
+		return &operationProgress{report: fallback}
+	}
+	return nil
+}
+
+// parseProgressLine reports a progress line to p. It returns false for
+// lines that are not progress.
+func (p *operationProgress) parseProgressLine(line string) bool {
+	m := progressLinePattern.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	current, _ := strconv.ParseInt(m[2], 10, 64)
+	total, _ := strconv.ParseInt(m[3], 10, 64)
+	p.report(progressStages[m[1]], current, total)
+	if m[4] != "" {
+		size, _ := strconv.ParseFloat(m[4], 64)
+		p.report(StageReceived, int64(size*byteUnits[m[5]]), 0)
+	}
+	return true
+}
+
+// progressParser turns git's progress output, lines ended by \r or \n,
+// into progress reports. Other lines are passed on to out, if set, so the
+// git CLI's errors are kept without the progress lines between them.
+type progressParser struct {
+	progress *operationProgress
+	out      io.Writer
+	partial  []byte
+}
+
+func (w *progressParser) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := w.partial[:i+1]
+		if !w.progress.parseProgressLine(string(bytes.TrimRight(line, "\r\n"))) && w.out != nil {
+			_, _ = w.out.Write(line)
+		}
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes on a final line without a line ending.
+func (w *progressParser) Flush() {
+	if len(w.partial) > 0 && !w.progress.parseProgressLine(string(w.partial)) && w.out != nil {
+		_, _ = w.out.Write(w.partial)
+	}
+	w.partial = nil
+}
+
+// countingBody reports the bytes read from an HTTP response to the
+// operation it belongs to.
+type countingBody struct {
+	io.ReadCloser
+	progress *operationProgress
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.progress.report(StageReceived, b.progress.received.Add(int64(n)), 0)
+	}
+	return n, err
+}
+
+// countResponse wraps resp's body to count what the operation of the
+// request's context receives.
+func countResponse(req *http.Request, resp *http.Response, fallback ProgressCallback) {
+	if p := progressFor(req.Context(), fallback); p != nil && resp.Body != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, progress: p}
+	}
+}
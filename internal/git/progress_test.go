@@ -0,0 +1,95 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type progressReport struct {
+	stage          string
+	current, total int64
+}
+
+func recordProgress(ctx context.Context) (context.Context, *[]progressReport) {
+	var reports []progressReport
+	return WithProgressFunc(ctx, func(stage string, current, total int64) {
+		reports = append(reports, progressReport{stage, current, total})
+	}), &reports
+}
+
+func TestProgressParser(t *testing.T) {
+	ctx, reports := recordProgress(context.Background())
+	var out bytes.Buffer
+	w := &progressParser{progress: progressFor(ctx, nil), out: &out}
+
+	// The git CLI's stderr, written in chunks that split lines
+	stderr := "Cloning into bare repository 'repo.git'...\n" +
+		"remote: Counting objects:  50% (5/10)\rremote: Counting objects: 100% (10/10), done.\n" +
+		"Receiving objects:  45% (450/1000), 1.50 MiB | 600.00 KiB/s\r" +
+		"Resolving deltas: 100% (30/30), done.\n" +
+		"fatal: early EOF"
+	for len(stderr) > 0 {
+		n := min(7, len(stderr))
+		if _, err := w.Write([]byte(stderr[:n])); err != nil {
+			t.Fatal(err)
+		}
+		stderr = stderr[n:]
+	}
+	w.Flush()
+
+	want := []progressReport{
+		{StageCounting, 5, 10},
+		{StageCounting, 10, 10},
+		{StageReceiving, 450, 1000},
+		{StageReceived, 1572864, 0},
+		{StageResolving, 30, 30},
+	}
+	if fmt.Sprint(*reports) != fmt.Sprint(want) {
+		t.Errorf("reports = %v, want %v", *reports, want)
+	}
+	if got := out.String(); got != "Cloning into bare repository 'repo.git'...\nfatal: early EOF" {
+		t.Errorf("passed on %q, want only the lines that are not progress", got)
+	}
+}
+
+func TestRateLimitedTransport_CountsReceivedBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &rateLimitedTransport{base: http.DefaultTransport}}
+	ctx, reports := recordProgress(context.Background())
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	// Bytes add up across the requests of one operation
+	last := (*reports)[len(*reports)-1]
+	if last.stage != StageReceived || last.current != 2000 {
+		t.Errorf("last report = %+v, want 2000 bytes received", last)
+	}
+
+	// Requests outside an operation are not counted
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Body.(*countingBody); ok {
+		t.Error("response counted without a progress callback")
+	}
+	_ = resp.Body.Close()
+}
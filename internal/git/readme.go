@@ -0,0 +1,142 @@
+// Package git provides git operations for repository backup.
+// This file implements reading a repository's README from a mirror clone.
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Readme is the README at the top of a repository's default branch.
+type Readme struct {
+	Path      string `json:"path"`
+	Branch    string `json:"branch,omitempty"`
+	Commit    string `json:"commit"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Binary    bool   `json:"binary,omitempty"` // Content omitted: not UTF-8 text
+	Content   string `json:"content,omitempty"`
+}
+
+// readmeExtensions ranks README names by extension; Bitbucket renders the
+// first one it finds in roughly this order.
+var readmeExtensions = []string{".md", ".markdown", ".rst", ".txt", ""}
+
+// ReadReadme returns the README in the root of the default branch (HEAD) of
+// a mirror clone, with at most maxSize bytes of content. It returns nil
+// without error for empty repositories and ones without a README.
+func ReadReadme(repoPath string, maxSize int64) (*Readme, error) {
+	repo, err := OpenRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolving HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("reading commit %s: %w", head.Hash(), err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("reading tree of %s: %w", head.Hash(), err)
+	}
+
+	entry := findReadme(tree.Entries)
+	if entry == nil {
+		return nil, nil
+	}
+	file, err := tree.TreeEntryFile(entry)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", entry.Name, err)
+	}
+
+	readme := &Readme{
+		Path:   entry.Name,
+		Commit: head.Hash().String(),
+		Size:   file.Size,
+	}
+	if head.Name().IsBranch() {
+		readme.Branch = head.Name().Short()
+	}
+	if err := readContent(readme, file, maxSize); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", entry.Name, err)
+	}
+	return readme, nil
+}
+
+// findReadme picks the README among a tree's top-level entries, matching
+// names case-insensitively and preferring Markdown.
+func findReadme(entries []object.TreeEntry) *object.TreeEntry {
+	var candidates []*object.TreeEntry
+	for i := range entries {
+		e := &entries[i]
+		if !e.Mode.IsFile() || e.Mode == filemode.Symlink {
+			continue
+		}
+		base := strings.ToLower(strings.TrimSuffix(e.Name, path.Ext(e.Name)))
+		if base == "readme" {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	rank := func(e *object.TreeEntry) int {
+		ext := strings.ToLower(path.Ext(e.Name))
+		for i, want := range readmeExtensions {
+			if ext == want {
+				return i
+			}
+		}
+		return len(readmeExtensions)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := rank(candidates[i]), rank(candidates[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates[0]
+}
+
+// readContent fills in the README's content, cut at maxSize bytes on a
+// character boundary.
+func readContent(readme *Readme, file *object.File, maxSize int64) error {
+	r, err := file.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxSize))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < file.Size {
+		readme.Truncated = true
+		// Don't split a multi-byte character at the cut
+		for len(data) > 0 && !utf8.Valid(data) && len(data) > int(maxSize)-utf8.UTFMax {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) {
+		readme.Binary = true
+		return nil
+	}
+	readme.Content = string(data)
+	return nil
+}
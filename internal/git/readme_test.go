@@ -0,0 +1,99 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readmeTestMirror builds a mirror of a repository on main holding files.
+func readmeTestMirror(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if !IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+	work := filepath.Join(t.TempDir(), "work")
+	run := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com",
+			"GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "init", "-q", "-b", "main")
+	for name, content := range files {
+		path := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(files) > 0 {
+		run(work, "add", ".")
+		run(work, "commit", "-q", "-m", "init")
+	}
+
+	mirror := filepath.Join(t.TempDir(), "repo.git")
+	run(work, "clone", "-q", "--mirror", work, mirror)
+	return mirror
+}
+
+func TestReadReadme(t *testing.T) {
+	mirror := readmeTestMirror(t, map[string]string{
+		"readme.txt":     "plain\n",
+		"README.md":      "# Service\n\nDoes things.\n",
+		"docs/README.md": "nested\n",
+		"README.md.orig": "backup\n",
+		"src/main.go":    "package main\n",
+	})
+
+	readme, err := ReadReadme(mirror, 1024)
+	if err != nil {
+		t.Fatalf("ReadReadme() error = %v", err)
+	}
+	if readme == nil {
+		t.Fatal("ReadReadme() = nil")
+	}
+	if readme.Path != "README.md" || readme.Branch != "main" || len(readme.Commit) != 40 {
+		t.Errorf("readme = %+v", readme)
+	}
+	if readme.Content != "# Service\n\nDoes things.\n" || readme.Truncated || readme.Size != int64(len(readme.Content)) {
+		t.Errorf("content = %q, truncated %v, size %d", readme.Content, readme.Truncated, readme.Size)
+	}
+}
+
+func TestReadReadme_Truncated(t *testing.T) {
+	mirror := readmeTestMirror(t, map[string]string{"README": strings.Repeat("é", 10)})
+
+	readme, err := ReadReadme(mirror, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 5 bytes would split the third character
+	if readme.Content != "éé" || !readme.Truncated || readme.Size != 20 {
+		t.Errorf("readme = %+v", readme)
+	}
+}
+
+func TestReadReadme_None(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"no readme": {"main.go": "package main\n"},
+		"empty":     nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			readme, err := ReadReadme(readmeTestMirror(t, files), 1024)
+			if err != nil || readme != nil {
+				t.Errorf("ReadReadme() = %+v, %v; want nil, nil", readme, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Package git provides git operations for repository backup.
+// This file implements reference inspection for mirror clones.
+package git
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// RefUpdate describes how a single reference changed between two snapshots.
+// Old is empty for newly created refs and New is empty for deleted refs.
+type RefUpdate struct {
+	Name string `json:"name"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// OpenRepository opens a mirror clone in either the standard bare layout
+// (repo.git/HEAD) or the go-git nested layout (repo.git/.git/HEAD).
+func OpenRepository(repoPath string) (*git.Repository, error) {
+	fs := osfs.New(repoPath)
+	objCache := cache.NewObjectLRUDefault()
+	var storage *filesystem.Storage
+	if _, err := os.Stat(repoPath + "/.git"); err == nil {
+		dot, err := fs.Chroot(".git")
+		if err != nil {
+			return nil, fmt.Errorf("accessing .git directory: %w", err)
+		}
+		storage = newStorage(dot, objCache)
+	} else {
+		storage = newStorage(fs, objCache)
+	}
+
+	repo, err := git.Open(storage, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening repository: %w", err)
+	}
+	return repo, nil
+}
+
+// ReadRefs returns all hash references in a mirror clone keyed by name.
+// Symbolic references such as HEAD are skipped. A missing repository
+// yields an empty map so callers can snapshot before a first clone.
+func ReadRefs(repoPath string) (map[string]string, error) {
+	refs := make(map[string]string)
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		return refs, nil
+	}
+
+	repo, err := OpenRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("listing references: %w", err)
+	}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			refs[ref.Name().String()] = ref.Hash().String()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading references: %w", err)
+	}
+
+	return refs, nil
+}
+
+// DiffRefs compares two reference snapshots and returns the refs that were
+// created, updated, or deleted, sorted by name.
+func DiffRefs(before, after map[string]string) []RefUpdate {
+	var updates []RefUpdate
+	for name, newHash := range after {
+		if oldHash := before[name]; oldHash != newHash {
+			updates = append(updates, RefUpdate{Name: name, Old: oldHash, New: newHash})
+		}
+	}
+	for name, oldHash := range before {
+		if _, ok := after[name]; !ok {
+			updates = append(updates, RefUpdate{Name: name, Old: oldHash})
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].Name < updates[j].Name
+	})
+	return updates
+}
+
+// Kinds of history rewrite reported by DetectRewrites.
+const (
+	RewriteForcePush = "force_push" // branch moved to a commit that does not contain the old one
+	RewriteDeleted   = "deleted"    // branch or tag removed
+	RewriteTagMoved  = "tag_moved"  // tag re-pointed to a different object
+)
+
+// RefRewrite is a ref update that rewrote or removed history.
+type RefRewrite struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Old  string `json:"old"`
+	New  string `json:"new,omitempty"`
+}
+
+// DetectRewrites returns the branch and tag updates among updates that
+// were not fast-forwards: branches whose old tip is not an ancestor of the
+// new one, moved tags, and deleted branches or tags. Other refs (such as
+// pull request refs) are ignored. The old objects must still be present in
+// the repository, which holds right after a fetch.
+func DetectRewrites(repoPath string, updates []RefUpdate) ([]RefRewrite, error) {
+	var candidates []RefUpdate
+	for _, u := range updates {
+		name := plumbing.ReferenceName(u.Name)
+		if u.Old != "" && (name.IsBranch() || name.IsTag()) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	repo, err := OpenRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var rewrites []RefRewrite
+	for _, u := range candidates {
+		name := plumbing.ReferenceName(u.Name)
+		switch {
+		case u.New == "":
+			rewrites = append(rewrites, RefRewrite{Name: u.Name, Kind: RewriteDeleted, Old: u.Old})
+		case name.IsTag():
+			rewrites = append(rewrites, RefRewrite{Name: u.Name, Kind: RewriteTagMoved, Old: u.Old, New: u.New})
+		default:
+			ff, err := isFastForward(repo, u.Old, u.New)
+			if err != nil {
+				return rewrites, fmt.Errorf("checking %s: %w", u.Name, err)
+			}
+			if !ff {
+				rewrites = append(rewrites, RefRewrite{Name: u.Name, Kind: RewriteForcePush, Old: u.Old, New: u.New})
+			}
+		}
+	}
+	return rewrites, nil
+}
+
+// isFastForward reports whether commit old is an ancestor of commit new.
+// An old commit that is no longer in the repository cannot be reached from
+// new, so it counts as a rewrite.
+func isFastForward(repo *git.Repository, oldHash, newHash string) (bool, error) {
+	newCommit, err := repo.CommitObject(plumbing.NewHash(newHash))
+	if err != nil {
+		return false, fmt.Errorf("reading new commit: %w", err)
+	}
+	oldCommit, err := repo.CommitObject(plumbing.NewHash(oldHash))
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading old commit: %w", err)
+	}
+	return oldCommit.IsAncestor(newCommit)
+}
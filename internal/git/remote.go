@@ -0,0 +1,44 @@
+package git
+
+import (
+	"fmt"
+)
+
+// OriginURL returns the URL of a mirror's origin remote.
+func OriginURL(repoPath string) (string, error) {
+	repo, err := OpenRepository(repoPath)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", fmt.Errorf("reading config: %w", err)
+	}
+	origin, ok := cfg.Remotes["origin"]
+	if !ok || len(origin.URLs) == 0 {
+		return "", fmt.Errorf("repository has no origin remote")
+	}
+	return origin.URLs[0], nil
+}
+
+// SetOriginURL points a mirror's origin remote at repoURL, keeping its
+// fetch refspecs.
+func SetOriginURL(repoPath, repoURL string) error {
+	repo, err := OpenRepository(repoPath)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("reading config: %w", err)
+	}
+	origin, ok := cfg.Remotes["origin"]
+	if !ok {
+		return fmt.Errorf("repository has no origin remote")
+	}
+	origin.URLs = []string{repoURL}
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("setting origin URL: %w", err)
+	}
+	return nil
+}
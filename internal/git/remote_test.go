@@ -0,0 +1,40 @@
+package git
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetOriginURL(t *testing.T) {
+	if !IsGitInstalled() {
+		t.Skip("git not installed")
+	}
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src")
+	mirror := filepath.Join(tmpDir, "repo.git")
+	for _, args := range [][]string{
+		{"init", "-q", src},
+		{"-C", src, "-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+		{"clone", "-q", "--mirror", src, mirror},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	if got, err := OriginURL(mirror); err != nil || got != src {
+		t.Fatalf("OriginURL() = %q, %v; want %q", got, err, src)
+	}
+	const https = "https://bitbucket.org/ws/repo.git"
+	if err := SetOriginURL(mirror, https); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := OriginURL(mirror); err != nil || got != https {
+		t.Errorf("OriginURL() = %q, %v; want %q", got, err, https)
+	}
+	out, err := exec.Command("git", "-C", mirror, "config", "--get-all", "remote.origin.fetch").Output()
+	if err != nil || string(out) != "+refs/*:refs/*\n" {
+		t.Errorf("origin fetch refspec = %q, %v; want the mirror refspec kept", out, err)
+	}
+}
@@ -10,14 +10,18 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/format"
 )
 
 // ShellGitClient provides git operations using the git CLI.
 type ShellGitClient struct {
-	username string
-	password string
-	logFunc  LogFunc
-	gitPath  string
+	username   string
+	password   string
+	credFunc   CredentialFunc
+	logFunc    LogFunc
+	gitPath    string
+	sshKeyPath string
 }
 
 // ShellGitOption configures a ShellGitClient.
@@ -31,6 +35,14 @@ func WithShellCredentials(username, password string) ShellGitOption {
 	}
 }
 
+// WithShellCredentialFunc sets a function that supplies credentials for
+// each operation, overriding WithShellCredentials.
+func WithShellCredentialFunc(f CredentialFunc) ShellGitOption {
+	return func(c *ShellGitClient) {
+		c.credFunc = f
+	}
+}
+
 // WithShellLogger sets the log function for debug output.
 func WithShellLogger(logFunc LogFunc) ShellGitOption {
 	return func(c *ShellGitClient) {
@@ -38,6 +50,13 @@ func WithShellLogger(logFunc LogFunc) ShellGitOption {
 	}
 }
 
+// WithShellSSHKey sets the private key used for SSH remotes.
+func WithShellSSHKey(keyPath string) ShellGitOption {
+	return func(c *ShellGitClient) {
+		c.sshKeyPath = keyPath
+	}
+}
+
 // NewShellGitClient creates a new shell git based client.
 // Returns nil if git is not available.
 func NewShellGitClient(opts ...ShellGitOption) *ShellGitClient {
@@ -61,9 +80,42 @@ func IsGitCLIAvailable() bool {
 	return err == nil
 }
 
+// env returns the environment for git commands. When an SSH key is
+// configured, ssh is pinned to that key and never prompts.
+func (c *ShellGitClient) env() []string {
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0", // Disable interactive prompts
+	)
+	if c.sshKeyPath != "" {
+		env = append(env, fmt.Sprintf(
+			"GIT_SSH_COMMAND=ssh -i '%s' -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=accept-new",
+			strings.ReplaceAll(c.sshKeyPath, "'", `'\''`)))
+	}
+	return env
+}
+
 // buildAuthURL creates an authenticated URL for git operations.
 func (c *ShellGitClient) buildAuthURL(repoURL string) string {
-	if c.username == "" || c.password == "" {
+	return insertCredentials(repoURL, c.username, c.password)
+}
+
+// resolveAuthURL creates an authenticated URL for the next git operation,
+// using the credential function when one is set.
+func (c *ShellGitClient) resolveAuthURL(ctx context.Context, repoURL string) (string, error) {
+	if c.credFunc == nil {
+		return c.buildAuthURL(repoURL), nil
+	}
+	username, password, err := c.credFunc(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting credentials: %w", err)
+	}
+	return insertCredentials(repoURL, username, password), nil
+}
+
+// insertCredentials puts credentials into an HTTPS URL, replacing any
+// already there. Other URLs are returned unchanged.
+func insertCredentials(repoURL, username, password string) string {
+	if username == "" || password == "" {
 		return repoURL
 	}
 
@@ -78,8 +130,8 @@ func (c *ShellGitClient) buildAuthURL(repoURL string) string {
 			urlPart = urlPart[atIndex+1:]
 		}
 		return fmt.Sprintf("https://%s:%s@%s",
-			c.username,
-			c.password,
+			username,
+			password,
 			urlPart)
 	}
 	return repoURL
@@ -93,28 +145,24 @@ func (c *ShellGitClient) CloneMirror(ctx context.Context, repoURL, destPath stri
 	}
 
 	// Build authenticated URL
-	authURL := c.buildAuthURL(repoURL)
+	authURL, err := c.resolveAuthURL(ctx, repoURL)
+	if err != nil {
+		return err
+	}
 
 	// Run git clone --mirror
-	cmd := exec.CommandContext(ctx, c.gitPath, "clone", "--mirror", authURL, destPath)
-	cmd.Env = append(os.Environ(),
-		"GIT_TERMINAL_PROMPT=0", // Disable interactive prompts
-	)
-
 	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
+	err = c.runTransfer(ctx, &stderr, "clone", "--mirror", authURL, destPath)
 	if err != nil {
 		// Clean up on failure
 		_ = os.RemoveAll(destPath)
-		return fmt.Errorf("git clone failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		return codedError(fmt.Errorf("git clone failed: %w: %s", err, strings.TrimSpace(stderr.String())), stderr.String())
 	}
 
 	if c.logFunc != nil {
 		elapsed := time.Since(startTime)
 		size := getDirSize(destPath)
-		c.logFunc("  Clone completed (took %s, %s)", elapsed.Round(time.Millisecond), formatBytes(size))
+		c.logFunc("  Clone completed (took %s, %s, %s)", format.Duration(elapsed), format.Bytes(size), format.Rate(size, elapsed))
 	}
 
 	return nil
@@ -129,18 +177,19 @@ func (c *ShellGitClient) Fetch(ctx context.Context, repoPath string) error {
 
 	sizeBefore := getDirSize(repoPath)
 
-	// Run git fetch --all --prune
-	cmd := exec.CommandContext(ctx, c.gitPath, "-C", repoPath, "fetch", "--all", "--prune")
-	cmd.Env = append(os.Environ(),
-		"GIT_TERMINAL_PROMPT=0", // Disable interactive prompts
-	)
+	// The clone stored the credentials of the time in origin's URL;
+	// replace them with current ones so a rotated token is used
+	if c.credFunc != nil {
+		if err := c.refreshRemoteURL(ctx, repoPath); err != nil {
+			return err
+		}
+	}
 
+	// Run git fetch --all --prune
 	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
+	err := c.runTransfer(ctx, &stderr, "-C", repoPath, "fetch", "--all", "--prune")
 	if err != nil {
-		return fmt.Errorf("git fetch failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		return codedError(fmt.Errorf("git fetch failed: %w: %s", err, strings.TrimSpace(stderr.String())), stderr.String())
 	}
 
 	if c.logFunc != nil {
@@ -149,16 +198,139 @@ func (c *ShellGitClient) Fetch(ctx context.Context, repoPath string) error {
 		delta := sizeAfter - sizeBefore
 		deltaStr := ""
 		if delta > 0 {
-			deltaStr = fmt.Sprintf(", +%s", formatBytes(delta))
+			deltaStr = fmt.Sprintf(", +%s", format.Bytes(delta))
 		} else if delta < 0 {
-			deltaStr = fmt.Sprintf(", %s", formatBytes(delta))
+			deltaStr = fmt.Sprintf(", %s", format.Bytes(delta))
 		}
-		c.logFunc("  Fetch completed (took %s, %s%s)", elapsed.Round(time.Millisecond), formatBytes(sizeAfter), deltaStr)
+		c.logFunc("  Fetch completed (took %s, %s%s)", format.Duration(elapsed), format.Bytes(sizeAfter), deltaStr)
 	}
 
 	return nil
 }
 
+// runTransfer runs a git clone or fetch, collecting its stderr. When ctx's
+// operation reports progress (see WithProgressFunc), git is asked for it
+// with --progress, and its progress lines are reported instead of being
+// collected.
+func (c *ShellGitClient) runTransfer(ctx context.Context, stderr *bytes.Buffer, args ...string) error {
+	progress := progressFor(ctx, nil)
+	if progress != nil {
+		for i, arg := range args {
+			if arg == "clone" || arg == "fetch" {
+				args = append(args[:i+1:i+1], append([]string{"--progress"}, args[i+1:]...)...)
+				break
+			}
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, c.gitPath, args...)
+	cmd.Env = c.env()
+	if progress == nil {
+		cmd.Stderr = stderr
+		return cmd.Run()
+	}
+
+	w := &progressParser{progress: progress, out: stderr}
+	cmd.Stderr = w
+	err := cmd.Run()
+	w.Flush()
+	return err
+}
+
+// refreshRemoteURL rewrites origin's URL with the current credentials.
+func (c *ShellGitClient) refreshRemoteURL(ctx context.Context, repoPath string) error {
+	out, err := exec.CommandContext(ctx, c.gitPath, "-C", repoPath, "remote", "get-url", "origin").Output()
+	if err != nil {
+		return fmt.Errorf("reading origin URL: %w", err)
+	}
+	remoteURL := strings.TrimSpace(string(out))
+	authURL, err := c.resolveAuthURL(ctx, remoteURL)
+	if err != nil {
+		return err
+	}
+	if authURL == remoteURL {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, c.gitPath, "-C", repoPath, "remote", "set-url", "origin", authURL)
+	cmd.Env = c.env()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("updating origin URL: %w: %s", err, maskCredentials(strings.TrimSpace(string(out))))
+	}
+	return nil
+}
+
+// FetchURL updates a mirror clone from an explicit remote URL rather than
+// its configured origin, e.g. to retry over SSH after an HTTPS failure.
+// All refs are fetched with mirror semantics.
+func (c *ShellGitClient) FetchURL(ctx context.Context, repoPath, repoURL string) error {
+	if c.logFunc != nil {
+		c.logFunc("Git CLI fetch --prune %s → %s", maskCredentials(repoURL), repoPath)
+	}
+
+	authURL, err := c.resolveAuthURL(ctx, repoURL)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	if err := c.runTransfer(ctx, &stderr, "-C", repoPath, "fetch", "--prune", authURL, "+refs/*:refs/*"); err != nil {
+		return codedError(fmt.Errorf("git fetch failed: %w: %s", err, strings.TrimSpace(stderr.String())), stderr.String())
+	}
+
+	return nil
+}
+
+// restoreRefspecs are the refs a restore pushes: branches and tags.
+// Other refs in a mirror, such as pull request refs, belong to the host.
+var restoreRefspecs = []string{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+
+// PushMirror makes a remote repository's branches and tags match a mirror
+// clone's: refs are forced to the mirror's commits, and branches and tags
+// the mirror lacks are deleted.
+func (c *ShellGitClient) PushMirror(ctx context.Context, repoPath, repoURL string) error {
+	if c.logFunc != nil {
+		c.logFunc("Git CLI push --prune %s → %s", repoPath, maskCredentials(repoURL))
+	}
+
+	authURL, err := c.resolveAuthURL(ctx, repoURL)
+	if err != nil {
+		return err
+	}
+	args := append([]string{"-C", repoPath, "push", "--prune", authURL}, restoreRefspecs...)
+	cmd := exec.CommandContext(ctx, c.gitPath, args...)
+	cmd.Env = c.env()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := maskCredentials(strings.TrimSpace(stderr.String()))
+		return codedError(fmt.Errorf("git push failed: %w: %s", err, msg), stderr.String())
+	}
+	return nil
+}
+
+// RemoteRefs lists a remote repository's branches and tags, keyed by name.
+func (c *ShellGitClient) RemoteRefs(ctx context.Context, repoURL string) (map[string]string, error) {
+	authURL, err := c.resolveAuthURL(ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, c.gitPath, "ls-remote", "--refs", authURL, "refs/heads/*", "refs/tags/*")
+	cmd.Env = c.env()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := maskCredentials(strings.TrimSpace(stderr.String()))
+		return nil, codedError(fmt.Errorf("git ls-remote failed: %w: %s", err, msg), stderr.String())
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if hash, name, ok := strings.Cut(line, "\t"); ok {
+			refs[name] = hash
+		}
+	}
+	return refs, nil
+}
+
 // Fsck verifies repository integrity using git CLI.
 func (c *ShellGitClient) Fsck(ctx context.Context, repoPath string) error {
 	cmd := exec.CommandContext(ctx, c.gitPath, "-C", repoPath, "fsck", "--no-dangling")
@@ -0,0 +1,366 @@
+// Package notify sends a summary of a finished backup run to Slack, an
+// HTTP webhook, or by email, as configured under notifications. Messages
+// are Go text/template documents executed with the Summary, so operators
+// can reword them; deliveries that fail are retried with backoff.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+	"github.com/andy-wilson/bb-backup/internal/format"
+)
+
+// Events of a Summary.
+const (
+	EventSucceeded = "backup_succeeded"
+	EventFailed    = "backup_failed"
+)
+
+// Delivery timing: each attempt is bounded by sendTimeout, and retries
+// back off from retryBase, doubling.
+const (
+	sendTimeout = 30 * time.Second
+	retryBase   = 2 * time.Second
+)
+
+// Summary describes a finished run. It is the webhook's default JSON body
+// and the data the templates are executed with.
+type Summary struct {
+	Event           string       `json:"event"`
+	Success         bool         `json:"success"` // No error and no failed repositories
+	Workspace       string       `json:"workspace"`
+	Alias           string       `json:"alias,omitempty"` // The workspaces entry's alias
+	RunID           string       `json:"run_id,omitempty"`
+	Error           string       `json:"error,omitempty"`
+	Code            errcode.Code `json:"code,omitempty"`
+	StartedAt       string       `json:"started_at,omitempty"`
+	CompletedAt     string       `json:"completed_at"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Succeeded       int          `json:"succeeded"`
+	Failed          int          `json:"failed"`
+	Skipped         int          `json:"skipped"`
+	Deferred        int          `json:"deferred,omitempty"`
+	Interrupted     int          `json:"interrupted,omitempty"`
+	PullRequests    int          `json:"pull_requests"`
+	Issues          int          `json:"issues"`
+	Bytes           int64        `json:"bytes"`
+	FailedRepos     []FailedRepo `json:"failed_repos,omitempty"`
+}
+
+// FailedRepo is a repository the run failed to back up.
+type FailedRepo struct {
+	Slug    string       `json:"slug"`
+	Project string       `json:"project,omitempty"`
+	Error   string       `json:"error"`
+	Code    errcode.Code `json:"code,omitempty"`
+}
+
+// Default templates.
+const (
+	DefaultText = `{{if .Success}}bb-backup of {{.Workspace}} succeeded{{else}}bb-backup of {{.Workspace}} FAILED{{end}} in {{duration .DurationSeconds}}
+{{.Succeeded}} repositories backed up, {{.Failed}} failed, {{.Skipped}} skipped{{if .Deferred}}, {{.Deferred}} deferred{{end}}{{if .Interrupted}}, {{.Interrupted}} interrupted{{end}}
+{{.PullRequests}} pull requests, {{.Issues}} issues, {{bytes .Bytes}} of git data{{if .RunID}}
+Run: {{.RunID}}{{end}}{{if .Error}}
+Error: {{.Error}}{{if .Code}} ({{.Code}}){{end}}{{end}}{{if .FailedRepos}}
+Failed repositories:{{range $i, $r := .FailedRepos}}{{if lt $i 20}}
+- {{$r.Slug}}: {{$r.Error}}{{end}}{{end}}{{if gt (len .FailedRepos) 20}}
+...and {{sub (len .FailedRepos) 20}} more{{end}}{{end}}
+`
+	DefaultSubject = `[bb-backup] {{.Workspace}}: backup {{if .Success}}succeeded{{else}}failed{{end}}`
+)
+
+var funcs = template.FuncMap{
+	"duration": func(seconds float64) string { return format.Duration(time.Duration(seconds * float64(time.Second))) },
+	"bytes":    format.Bytes,
+	"sub":      func(a, b int) int { return a - b },
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Notifier delivers summaries to the configured targets.
+type Notifier struct {
+	cfg       config.NotificationsConfig
+	slack     *template.Template
+	webhook   *template.Template // nil sends the summary as JSON
+	subject   *template.Template
+	body      *template.Template
+	client    *http.Client
+	retryBase time.Duration
+	sendMail  func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// New returns a Notifier for cfg, or nil if no target is set. It fails if
+// a template does not parse.
+func New(cfg config.NotificationsConfig) (*Notifier, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	n := &Notifier{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: sendTimeout},
+		retryBase: retryBase,
+		sendMail:  sendMail,
+	}
+	var err error
+	parse := func(name, text, fallback string) *template.Template {
+		if text == "" {
+			text = fallback
+		}
+		if text == "" || err != nil {
+			return nil
+		}
+		var t *template.Template
+		if t, err = template.New(name).Funcs(funcs).Parse(text); err != nil {
+			err = fmt.Errorf("notifications.%s: %w", name, err)
+		}
+		return t
+	}
+	n.slack = parse("slack.template", cfg.Slack.Template, DefaultText)
+	n.webhook = parse("webhook.template", cfg.Webhook.Template, "")
+	n.subject = parse("email.subject", cfg.Email.Subject, DefaultSubject)
+	n.body = parse("email.template", cfg.Email.Template, DefaultText)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Wants reports whether a run with the given outcome is notified under
+// notifications.when.
+func (n *Notifier) Wants(success bool) bool {
+	switch n.cfg.When {
+	case config.NotifyFailure:
+		return !success
+	case config.NotifySuccess:
+		return success
+	}
+	return true
+}
+
+// Send delivers the summary to every target, retrying each up to
+// notifications.max_retries times. It returns the errors of the targets
+// that could not be reached.
+func (n *Notifier) Send(ctx context.Context, s Summary) error {
+	var errs []error
+	if endpoint := n.cfg.Slack.WebhookURL; endpoint != "" {
+		errs = append(errs, n.retry(ctx, "slack", func() error { return n.sendSlack(ctx, endpoint, s) }))
+	}
+	if endpoint := n.cfg.Webhook.URL; endpoint != "" {
+		errs = append(errs, n.retry(ctx, "webhook", func() error { return n.sendWebhook(ctx, endpoint, s) }))
+	}
+	if n.cfg.Email.SMTPHost != "" {
+		errs = append(errs, n.retry(ctx, "email", func() error { return n.sendEmail(s) }))
+	}
+	return errors.Join(errs...)
+}
+
+// permanentError marks a delivery failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// retry runs send until it succeeds, fails permanently, or has been
+// retried max_retries times.
+func (n *Notifier) retry(ctx context.Context, target string, send func() error) error {
+	wait := n.retryBase
+	for attempt := 0; ; attempt++ {
+		err := send()
+		var permanent *permanentError
+		if err == nil {
+			return nil
+		}
+		if errors.As(err, &permanent) || attempt >= n.cfg.MaxRetries {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", target, err)
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// render executes a template with the summary.
+func render(t *template.Template, s Summary) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, s); err != nil {
+		return "", &permanentError{err}
+	}
+	return buf.String(), nil
+}
+
+func (n *Notifier) sendSlack(ctx context.Context, endpoint string, s Summary) error {
+	text, err := render(n.slack, s)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return &permanentError{err}
+	}
+	return n.post(ctx, endpoint, "application/json", nil, data)
+}
+
+func (n *Notifier) sendWebhook(ctx context.Context, endpoint string, s Summary) error {
+	var data []byte
+	if n.webhook == nil {
+		var err error
+		if data, err = json.Marshal(s); err != nil {
+			return &permanentError{err}
+		}
+	} else {
+		body, err := render(n.webhook, s)
+		if err != nil {
+			return err
+		}
+		data = []byte(body)
+	}
+	contentType := n.cfg.Webhook.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return n.post(ctx, endpoint, contentType, n.cfg.Webhook.Headers, data)
+}
+
+// post POSTs data and expects a 2xx response. Other 4xx responses, except
+// 408 and 429, are permanent failures.
+func (n *Notifier) post(ctx context.Context, endpoint, contentType string, headers map[string]string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	// Errors leave out the URL: a Slack webhook's URL is its credential
+	resp, err := n.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return &permanentError{err}
+	}
+	return err
+}
+
+func (n *Notifier) sendEmail(s Summary) error {
+	email := n.cfg.Email
+	subject, err := render(n.subject, s)
+	if err != nil {
+		return err
+	}
+	body, err := render(n.body, s)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", email.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.Join(strings.Fields(subject), " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	// The envelope takes bare addresses; config validation parsed them
+	from, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return &permanentError{err}
+	}
+	to := make([]string, len(email.To))
+	for i, addr := range email.To {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return &permanentError{err}
+		}
+		to[i] = parsed.Address
+	}
+
+	var auth smtp.Auth
+	if email.Username != "" {
+		auth = smtp.PlainAuth("", email.Username, email.Password, email.SMTPHost)
+	}
+	addr := net.JoinHostPort(email.SMTPHost, strconv.Itoa(email.SMTPPort))
+	return n.sendMail(addr, auth, from.Address, to, msg.Bytes())
+}
+
+// sendMail is smtp.SendMail with the whole conversation bounded by
+// sendTimeout, so a server that stops answering cannot hold up the end of
+// a run.
+func sendMail(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", addr, sendTimeout)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(sendTimeout))
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return &permanentError{err}
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+func failedSummary() Summary {
+	return Summary{
+		Event:           EventFailed,
+		Workspace:       "ws",
+		RunID:           "run-1",
+		Error:           "2 repositories failed",
+		Code:            errcode.ReposFailed,
+		DurationSeconds: 95,
+		Succeeded:       10,
+		Failed:          2,
+		Bytes:           2048,
+		FailedRepos: []FailedRepo{
+			{Slug: "api", Error: "fetch timed out", Code: errcode.GitTimeout},
+			{Slug: "web", Error: "401", Code: errcode.AuthFailed},
+		},
+	}
+}
+
+func newTestNotifier(t *testing.T, cfg config.NotificationsConfig) *Notifier {
+	t.Helper()
+	n, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	n.retryBase = time.Millisecond
+	return n
+}
+
+func TestSend_Slack(t *testing.T) {
+	var calls atomic.Int32
+	var text string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails and is retried
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body struct{ Text string }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		text = body.Text
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t, config.NotificationsConfig{MaxRetries: 2, Slack: config.SlackNotification{WebhookURL: srv.URL}})
+	if err := n.Send(context.Background(), failedSummary()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("%d deliveries, want 2", calls.Load())
+	}
+	for _, want := range []string{"bb-backup of ws FAILED in 1m35s", "10 repositories backed up, 2 failed", "2.0 KB", "Error: 2 repositories failed (REPOS_FAILED)", "- api: fetch timed out\n- web: 401"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("message missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestSend_WebhookTemplateAndPermanentFailure(t *testing.T) {
+	var calls atomic.Int32
+	var body, contentType, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		data, _ := io.ReadAll(r.Body)
+		body, contentType, auth = string(data), r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t, config.NotificationsConfig{Webhook: config.WebhookNotification{
+		URL:         srv.URL,
+		Template:    `{"summary": {{json .Error}}, "failed": {{.Failed}}}`,
+		ContentType: "application/vnd.custom+json",
+		Headers:     map[string]string{"Authorization": "Bearer t"},
+	}})
+	if err := n.Send(context.Background(), failedSummary()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if body != `{"summary": "2 repositories failed", "failed": 2}` || contentType != "application/vnd.custom+json" || auth != "Bearer t" {
+		t.Errorf("webhook got %q (%s, %q)", body, contentType, auth)
+	}
+
+	// Without a template the summary is sent as JSON
+	n = newTestNotifier(t, config.NotificationsConfig{Webhook: config.WebhookNotification{URL: srv.URL}})
+	if err := n.Send(context.Background(), failedSummary()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	var got Summary
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got.Event != EventFailed || len(got.FailedRepos) != 2 {
+		t.Errorf("webhook JSON = %s (%v)", body, err)
+	}
+
+	// A 4xx response is not retried
+	calls.Store(0)
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer reject.Close()
+	n = newTestNotifier(t, config.NotificationsConfig{MaxRetries: 3, Webhook: config.WebhookNotification{URL: reject.URL}})
+	err := n.Send(context.Background(), failedSummary())
+	if err == nil || !strings.Contains(err.Error(), "webhook: status 404") || calls.Load() != 1 {
+		t.Errorf("Send() = %v after %d deliveries, want one 404", err, calls.Load())
+	}
+}
+
+func TestSend_Email(t *testing.T) {
+	n := newTestNotifier(t, config.NotificationsConfig{MaxRetries: 1, Email: config.EmailNotification{
+		SMTPHost: "smtp.example.com",
+		SMTPPort: 587,
+		Username: "bot",
+		Password: "secret",
+		From:     "Backups <backups@example.com>",
+		To:       []string{"ops@example.com", "Team <team@example.com>"},
+	}})
+	var addr, from, msg string
+	var to []string
+	attempts := 0
+	n.sendMail = func(a string, auth smtp.Auth, f string, t []string, m []byte) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("connection reset")
+		}
+		addr, from, to, msg = a, f, t, string(m)
+		return nil
+	}
+	if err := n.Send(context.Background(), failedSummary()); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if addr != "smtp.example.com:587" || from != "backups@example.com" || strings.Join(to, ",") != "ops@example.com,team@example.com" {
+		t.Errorf("sent via %s from %s to %v", addr, from, to)
+	}
+	for _, want := range []string{"Subject: [bb-backup] ws: backup failed\r\n", "To: ops@example.com, Team <team@example.com>\r\n", "\r\n\r\nbb-backup of ws FAILED", "- api: fetch timed out\r\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if n, err := New(config.NotificationsConfig{}); n != nil || err != nil {
+		t.Errorf("New() with no targets = %v, %v; want nil, nil", n, err)
+	}
+	_, err := New(config.NotificationsConfig{Slack: config.SlackNotification{WebhookURL: "https://hooks.example.com/x", Template: "{{.Nope"}})
+	if err == nil || !strings.Contains(err.Error(), "notifications.slack.template") {
+		t.Errorf("New() with a bad template error = %v", err)
+	}
+
+	n := newTestNotifier(t, config.NotificationsConfig{When: config.NotifyFailure, Slack: config.SlackNotification{WebhookURL: "https://hooks.example.com/x"}})
+	if n.Wants(true) || !n.Wants(false) {
+		t.Error("when: failure should notify failed runs only")
+	}
+}
@@ -0,0 +1,108 @@
+// Package release prepares bb-backup releases: it checks version numbers
+// against semantic versioning and moves the changelog's Unreleased section
+// under the new version.
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// semverPattern matches a release tag such as v1.2.3 or v1.3.0-rc.1.
+var semverPattern = regexp.MustCompile(`^v(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// ValidVersion reports an error unless version is a semantic version tag
+// with a leading v, as Go modules require.
+func ValidVersion(version string) error {
+	if !semverPattern.MatchString(version) {
+		return fmt.Errorf("version %q is not a semantic version like v1.2.3", version)
+	}
+	return nil
+}
+
+const unreleasedHeading = "## [Unreleased]"
+
+// Promote moves the changes under the changelog's Unreleased heading into
+// a section for version, released on date, leaves an empty Unreleased
+// section above it, and updates the compare links at the bottom. It fails
+// when there is nothing unreleased or the version is already listed.
+func Promote(changelog []byte, version string, date time.Time) ([]byte, error) {
+	if err := ValidVersion(version); err != nil {
+		return nil, err
+	}
+	number := strings.TrimPrefix(version, "v")
+	text := string(changelog)
+	if strings.Contains(text, "## ["+number+"]") {
+		return nil, fmt.Errorf("CHANGELOG.md already has a section for %s", number)
+	}
+
+	start := strings.Index(text, unreleasedHeading+"\n")
+	if start < 0 {
+		return nil, fmt.Errorf("CHANGELOG.md has no %q section", unreleasedHeading)
+	}
+	body := start + len(unreleasedHeading) + 1
+	end := strings.Index(text[body:], "\n## [")
+	if end < 0 {
+		end = len(text) - body
+	}
+	if strings.TrimSpace(text[body:body+end]) == "" {
+		return nil, fmt.Errorf("CHANGELOG.md has no unreleased changes to release")
+	}
+
+	var out bytes.Buffer
+	out.WriteString(text[:body])
+	fmt.Fprintf(&out, "\n## [%s] - %s\n", number, date.Format("2006-01-02"))
+	out.WriteString(text[body:])
+	return updateLinks(out.Bytes(), number)
+}
+
+// linkPattern matches the Unreleased compare link.
+var linkPattern = regexp.MustCompile(`(?m)^\[Unreleased\]: (\S+)/compare/(v\S+)\.\.\.HEAD$`)
+
+// updateLinks points the Unreleased link at the new version and adds a
+// link comparing it with the previous one.
+func updateLinks(changelog []byte, number string) ([]byte, error) {
+	m := linkPattern.FindSubmatchIndex(changelog)
+	if m == nil {
+		return nil, fmt.Errorf("CHANGELOG.md has no [Unreleased] compare link")
+	}
+	repo := string(changelog[m[2]:m[3]])
+	previous := string(changelog[m[4]:m[5]])
+	links := fmt.Sprintf("[Unreleased]: %s/compare/v%s...HEAD\n[%s]: %s/compare/%s...v%s",
+		repo, number, number, repo, previous, number)
+
+	var out bytes.Buffer
+	out.Write(changelog[:m[0]])
+	out.WriteString(links)
+	out.Write(changelog[m[1]:])
+	return out.Bytes(), nil
+}
+
+// Notes returns the changelog section for version, without its heading,
+// e.g. as the body of a release page.
+func Notes(changelog []byte, version string) (string, error) {
+	number := strings.TrimPrefix(version, "v")
+	text := string(changelog)
+	heading := "## [" + number + "]"
+	start := strings.Index(text, heading)
+	if start < 0 {
+		return "", fmt.Errorf("CHANGELOG.md has no section for %s", number)
+	}
+	body := strings.Index(text[start:], "\n")
+	if body < 0 {
+		return "", nil
+	}
+	body += start + 1
+	end := strings.Index(text[body:], "\n## [")
+	if end < 0 {
+		// The last release runs up to the links
+		end = len(text) - body
+		if links := strings.Index(text[body:], "\n[Unreleased]: "); links >= 0 {
+			end = links
+		}
+	}
+	return strings.TrimSpace(text[body:body+end]) + "\n", nil
+}
@@ -0,0 +1,79 @@
+package release
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testChangelog = `# Changelog
+
+## [Unreleased]
+
+### Added
+
+- Completion notifications
+
+## [0.4.0] - 2025-12-19
+
+### Added
+
+- Retry failed repositories
+
+[Unreleased]: https://github.com/andy-wilson/bb-backup/compare/v0.4.0...HEAD
+[0.4.0]: https://github.com/andy-wilson/bb-backup/compare/v0.3.0...v0.4.0
+`
+
+func TestValidVersion(t *testing.T) {
+	for _, v := range []string{"v0.5.0", "v1.0.0", "v10.20.30", "v1.3.0-rc.1"} {
+		if err := ValidVersion(v); err != nil {
+			t.Errorf("ValidVersion(%q) error = %v", v, err)
+		}
+	}
+	for _, v := range []string{"0.5.0", "v1.0", "v01.0.0", "v1.0.0+build", "latest", ""} {
+		if err := ValidVersion(v); err == nil {
+			t.Errorf("ValidVersion(%q) accepted an invalid version", v)
+		}
+	}
+}
+
+func TestPromote(t *testing.T) {
+	got, err := Promote([]byte(testChangelog), "v0.5.0", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	for _, want := range []string{
+		"## [Unreleased]\n\n## [0.5.0] - 2026-03-02\n\n### Added\n\n- Completion notifications\n\n## [0.4.0]",
+		"[Unreleased]: https://github.com/andy-wilson/bb-backup/compare/v0.5.0...HEAD\n" +
+			"[0.5.0]: https://github.com/andy-wilson/bb-backup/compare/v0.4.0...v0.5.0\n" +
+			"[0.4.0]: https://github.com/andy-wilson/bb-backup/compare/v0.3.0...v0.4.0\n",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("promoted changelog missing %q:\n%s", want, got)
+		}
+	}
+
+	// Releasing again finds nothing unreleased
+	if _, err := Promote(got, "v0.5.1", time.Now()); err == nil || !strings.Contains(err.Error(), "no unreleased changes") {
+		t.Errorf("Promote() with an empty Unreleased section error = %v", err)
+	}
+	if _, err := Promote([]byte(testChangelog), "v0.4.0", time.Now()); err == nil {
+		t.Error("Promote() accepted a version already released")
+	}
+	if _, err := Promote([]byte(testChangelog), "0.5.0", time.Now()); err == nil {
+		t.Error("Promote() accepted a version without a leading v")
+	}
+}
+
+func TestNotes(t *testing.T) {
+	notes, err := Notes([]byte(testChangelog), "v0.4.0")
+	if err != nil {
+		t.Fatalf("Notes() error = %v", err)
+	}
+	if notes != "### Added\n\n- Retry failed repositories\n" {
+		t.Errorf("Notes() = %q", notes)
+	}
+	if _, err := Notes([]byte(testChangelog), "v0.9.0"); err == nil {
+		t.Error("Notes() found a version that is not listed")
+	}
+}
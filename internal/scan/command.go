@@ -0,0 +1,90 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// CommandScanner runs an external command to scan ref updates.
+//
+// The command is run through "sh -c" with the mirror as its working
+// directory. Ref updates are written to stdin one per line in the same
+// "<old> <new> <ref>" format used by git's pre-receive hook, with an
+// all-zero hash standing in for a missing side. Each non-empty line the
+// command writes to stdout is recorded as a finding. Exit status 0 and 1
+// are both treated as success (1 conventionally meaning "findings");
+// any other status is an error.
+type CommandScanner struct {
+	command string
+}
+
+// zeroHash marks a missing old or new side of a ref update.
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// NewCommandScanner creates a scanner that runs the given shell command.
+func NewCommandScanner(command string) *CommandScanner {
+	return &CommandScanner{command: command}
+}
+
+// Name returns the scanner identifier.
+func (s *CommandScanner) Name() string {
+	return "command"
+}
+
+// Scan runs the external command against the ref updates.
+func (s *CommandScanner) Scan(ctx context.Context, repoPath string, updates []git.RefUpdate) ([]Finding, error) {
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	var stdin bytes.Buffer
+	for _, u := range updates {
+		fmt.Fprintf(&stdin, "%s %s %s\n", hashOrZero(u.Old), hashOrZero(u.New), u.Name)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.command)
+	cmd.Dir = repoPath
+	cmd.Stdin = &stdin
+	cmd.Env = append(os.Environ(), "BB_BACKUP_REPO_PATH="+repoPath)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return nil, fmt.Errorf("scan command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	var findings []Finding
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		findings = append(findings, Finding{
+			Rule:        "command",
+			Description: line,
+		})
+	}
+
+	return findings, nil
+}
+
+func hashOrZero(hash string) string {
+	if hash == "" {
+		return zeroHash
+	}
+	return hash
+}
@@ -0,0 +1,71 @@
+package scan
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+func TestCommandScanner_Scan(t *testing.T) {
+	dir := t.TempDir()
+
+	// Echo the ref name from each stdin line as a finding, exit 1 for "findings"
+	s := NewCommandScanner(`while read old new ref; do echo "suspicious $ref"; done; exit 1`)
+	findings, err := s.Scan(context.Background(), dir, []git.RefUpdate{
+		{Name: "refs/heads/main", New: "abc"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(findings) != 1 {
+		t.Fatalf("expected 1 finding, got %d", len(findings))
+	}
+	if findings[0].Description != "suspicious refs/heads/main" {
+		t.Errorf("Description = %q", findings[0].Description)
+	}
+}
+
+func TestCommandScanner_Error(t *testing.T) {
+	s := NewCommandScanner("echo broken >&2; exit 2")
+	_, err := s.Scan(context.Background(), t.TempDir(), []git.RefUpdate{{Name: "refs/heads/main", New: "abc"}})
+	if err == nil {
+		t.Fatal("expected error for exit status 2")
+	}
+}
+
+func TestCommandScanner_NoUpdates(t *testing.T) {
+	s := NewCommandScanner("exit 2")
+	findings, err := s.Scan(context.Background(), t.TempDir(), nil)
+	if err != nil || findings != nil {
+		t.Errorf("Scan(nil) = %v, %v; want nil, nil", findings, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ScanConfig
+		want    string
+		wantErr bool
+	}{
+		{"default", config.ScanConfig{}, "secrets", false},
+		{"secrets", config.ScanConfig{Scanner: "secrets"}, "secrets", false},
+		{"command", config.ScanConfig{Scanner: "command", Command: "true"}, "command", false},
+		{"command without command", config.ScanConfig{Scanner: "command"}, "", true},
+		{"unknown", config.ScanConfig{Scanner: "bogus"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && s.Name() != tt.want {
+				t.Errorf("Name() = %q, want %q", s.Name(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// maxPackScanOutput caps the scanner output kept for the report.
+const maxPackScanOutput = 4096
+
+// PackScanner runs an external malware scanner, such as clamscan, against
+// the object files a fetch added to a mirror.
+//
+// The command is run through "sh -c" with the mirror as its working
+// directory and the absolute paths of the new files as its arguments
+// ("$@"), and also on stdin one per line. Exit status 0 means clean and 1
+// means the files were flagged, as with clamscan; any other status is an
+// error.
+type PackScanner struct {
+	command string
+}
+
+// NewPackScanner creates a pack scanner that runs the given shell command.
+func NewPackScanner(command string) *PackScanner {
+	return &PackScanner{command: command}
+}
+
+// Scan runs the command against files in the mirror at repoPath. It
+// reports whether the scanner flagged them, with the scanner's output.
+func (s *PackScanner) Scan(ctx context.Context, repoPath string, files []string) (bool, string, error) {
+	if len(files) == 0 {
+		return false, "", nil
+	}
+
+	args := append([]string{"-c", s.command, "sh"}, files...)
+	cmd := exec.CommandContext(ctx, "sh", args...)
+	cmd.Dir = repoPath
+	cmd.Stdin = strings.NewReader(strings.Join(files, "\n") + "\n")
+	cmd.Env = append(os.Environ(), "BB_BACKUP_REPO_PATH="+repoPath)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	text := strings.TrimSpace(output.String())
+	if len(text) > maxPackScanOutput {
+		text = text[:maxPackScanOutput] + "..."
+	}
+	if err == nil {
+		return false, text, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return true, text, nil
+	}
+	return false, text, fmt.Errorf("pack scan command failed: %w: %s", err, text)
+}
@@ -0,0 +1,36 @@
+package scan
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPackScanner_Scan(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{dir + "/objects/pack/pack-1.pack", dir + "/objects/ab/cdef"}
+
+	// Flag when the arguments and stdin name the same files
+	s := NewPackScanner(`stdin=$(cat); [ "$stdin" = "$(printf '%s\n' "$@")" ] || exit 2; echo "$1: Eicar FOUND"; exit 1`)
+	flagged, output, err := s.Scan(context.Background(), dir, files)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !flagged || output != files[0]+": Eicar FOUND" {
+		t.Errorf("Scan() = %v, %q; want flagged with the scanner output", flagged, output)
+	}
+
+	flagged, _, err = NewPackScanner("exit 0").Scan(context.Background(), dir, files)
+	if err != nil || flagged {
+		t.Errorf("Scan() = %v, %v; want clean", flagged, err)
+	}
+
+	_, _, err = NewPackScanner("echo database missing >&2; exit 2").Scan(context.Background(), dir, files)
+	if err == nil || !strings.Contains(err.Error(), "database missing") {
+		t.Errorf("Scan() error = %v, want the scanner's error", err)
+	}
+
+	if flagged, _, err := NewPackScanner("exit 1").Scan(context.Background(), dir, nil); flagged || err != nil {
+		t.Errorf("Scan() without files = %v, %v; want nothing to do", flagged, err)
+	}
+}
@@ -0,0 +1,47 @@
+// Package scan provides content policy scanners that run against newly
+// fetched refs of a backed-up repository.
+package scan
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// Finding is a single policy violation reported by a scanner.
+// Findings never include the matched content to avoid copying secrets
+// into reports and logs.
+type Finding struct {
+	Rule        string `json:"rule"`
+	Ref         string `json:"ref,omitempty"`
+	Commit      string `json:"commit,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Scanner scans the changes introduced by a set of ref updates.
+type Scanner interface {
+	// Name returns a short identifier for the scanner.
+	Name() string
+
+	// Scan inspects the given ref updates in the mirror at repoPath.
+	Scan(ctx context.Context, repoPath string, updates []git.RefUpdate) ([]Finding, error)
+}
+
+// New creates the scanner selected by the configuration.
+func New(cfg config.ScanConfig) (Scanner, error) {
+	switch cfg.Scanner {
+	case "", "secrets":
+		return NewSecretScanner(int64(cfg.MaxFileSizeKB) * 1024), nil
+	case "command":
+		if cfg.Command == "" {
+			return nil, fmt.Errorf("scan.command is required for command scanner")
+		}
+		return NewCommandScanner(cfg.Command), nil
+	default:
+		return nil, fmt.Errorf("unknown scanner: %s", cfg.Scanner)
+	}
+}
@@ -0,0 +1,144 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/andy-wilson/bb-backup/internal/git"
+)
+
+// secretRule is a named pattern that indicates a leaked credential.
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// defaultSecretRules are the built-in credential patterns.
+var defaultSecretRules = []secretRule{
+	{"aws-access-key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|PGP) )?PRIVATE KEY( BLOCK)?-----`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[0-9A-Za-z-]{10,}\b`)},
+	{"atlassian-api-token", regexp.MustCompile(`\bATATT[A-Za-z0-9_\-=]{20,}\b`)},
+	{"generic-secret", regexp.MustCompile(`(?i)\b(password|passwd|secret|api[_-]?key|access[_-]?token)\b\s*[:=]\s*["'][^"'\s]{8,}["']`)},
+}
+
+// SecretScanner is the built-in scanner that looks for credential patterns
+// in files added or modified by each ref update.
+type SecretScanner struct {
+	rules       []secretRule
+	maxFileSize int64
+}
+
+// NewSecretScanner creates a secret-pattern scanner. Blobs larger than
+// maxFileSize bytes are skipped; zero disables the limit.
+func NewSecretScanner(maxFileSize int64) *SecretScanner {
+	return &SecretScanner{
+		rules:       defaultSecretRules,
+		maxFileSize: maxFileSize,
+	}
+}
+
+// Name returns the scanner identifier.
+func (s *SecretScanner) Name() string {
+	return "secrets"
+}
+
+// Scan diffs the old and new tree of each updated ref and scans the
+// resulting file contents. New refs are scanned against an empty tree.
+func (s *SecretScanner) Scan(ctx context.Context, repoPath string, updates []git.RefUpdate) ([]Finding, error) {
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	repo, err := git.OpenRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []Finding
+	seen := make(map[string]bool) // commit:path already scanned via another ref
+
+	for _, u := range updates {
+		if u.New == "" {
+			continue // deleted ref, nothing new to scan
+		}
+		if err := ctx.Err(); err != nil {
+			return findings, err
+		}
+
+		newCommit, err := repo.CommitObject(plumbing.NewHash(u.New))
+		if err != nil {
+			// Tags may point at non-commit objects; skip those
+			continue
+		}
+		newTree, err := newCommit.Tree()
+		if err != nil {
+			return findings, fmt.Errorf("reading tree for %s: %w", u.Name, err)
+		}
+
+		var oldTree *object.Tree
+		if u.Old != "" {
+			if oldCommit, err := repo.CommitObject(plumbing.NewHash(u.Old)); err == nil {
+				oldTree, _ = oldCommit.Tree()
+			}
+		}
+
+		changes, err := object.DiffTreeContext(ctx, oldTree, newTree)
+		if err != nil {
+			return findings, fmt.Errorf("diffing %s: %w", u.Name, err)
+		}
+
+		for _, change := range changes {
+			_, to, err := change.Files()
+			if err != nil || to == nil {
+				continue
+			}
+			key := to.Hash.String() + ":" + to.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if s.maxFileSize > 0 && to.Size > s.maxFileSize {
+				continue
+			}
+			if binary, err := to.IsBinary(); err != nil || binary {
+				continue
+			}
+			content, err := to.Contents()
+			if err != nil {
+				continue
+			}
+			for _, f := range s.scanContent(content) {
+				f.Ref = u.Name
+				f.Commit = u.New
+				f.Path = to.Name
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// scanContent returns one finding per rule match, with 1-based line numbers.
+func (s *SecretScanner) scanContent(content string) []Finding {
+	var findings []Finding
+	for i, line := range strings.Split(content, "\n") {
+		for _, rule := range s.rules {
+			if rule.pattern.MatchString(line) {
+				findings = append(findings, Finding{
+					Rule: rule.name,
+					Line: i + 1,
+				})
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buffered tuning. Flushes write this many files at once so per-file
+// round trips to a slow share overlap; a flush starts early once this
+// much data is waiting.
+const (
+	bufferedFlushWorkers   = 8
+	bufferedMaxPendingSize = 32 << 20
+)
+
+// Buffered wraps a Storage for high-latency targets such as SMB shares.
+// Writes are held in memory and written out together every flush interval,
+// by Flush, or by Close; repeated writes to the same path in between are
+// written once. Reads, Exists, and List see pending writes.
+//
+// A failed background write is kept and returned by the next Flush that
+// covers its path, so callers that flush their own files still see it.
+type Buffered struct {
+	next     Storage
+	interval time.Duration
+
+	mu           sync.Mutex
+	pending      map[string]bufferedWrite
+	pendingBytes int
+	seq          uint64
+	failed       map[string]error
+
+	// flushMu serializes flushes so an entry is never written twice at once
+	flushMu   sync.Mutex
+	kick      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type bufferedWrite struct {
+	data []byte
+	seq  uint64
+}
+
+// NewBuffered returns a Buffered storage that writes through to next. With
+// a positive interval pending writes are also flushed in the background at
+// that interval; otherwise only Flush and Close write them.
+func NewBuffered(next Storage, interval time.Duration) *Buffered {
+	b := &Buffered{
+		next:     next,
+		interval: interval,
+		pending:  make(map[string]bufferedWrite),
+		failed:   make(map[string]error),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+// loop flushes in the background on the interval or when too much data is
+// pending. Errors are kept for Flush to report.
+func (b *Buffered) loop() {
+	defer close(b.done)
+	var tick <-chan time.Time
+	if b.interval > 0 {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-tick:
+		case <-b.kick:
+		}
+		b.flush(nil)
+	}
+}
+
+// Write queues data for path. It does not touch the underlying storage.
+func (b *Buffered) Write(path string, data []byte) error {
+	key := filepath.Clean(path)
+	b.mu.Lock()
+	if old, ok := b.pending[key]; ok {
+		b.pendingBytes -= len(old.data)
+	}
+	b.seq++
+	b.pending[key] = bufferedWrite{data: append([]byte(nil), data...), seq: b.seq}
+	b.pendingBytes += len(data)
+	delete(b.failed, key)
+	full := b.pendingBytes >= bufferedMaxPendingSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// WriteFile stores the local file src underneath at once, replacing any
+// pending write to path. Files passed this way are typically too large to
+// hold in memory.
+func (b *Buffered) WriteFile(path, src string) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	key := filepath.Clean(path)
+	b.mu.Lock()
+	if w, ok := b.pending[key]; ok {
+		b.pendingBytes -= len(w.data)
+		delete(b.pending, key)
+	}
+	delete(b.failed, key)
+	b.mu.Unlock()
+	return WriteFile(b.next, path, src)
+}
+
+// Read returns pending data for path if there is any, otherwise it reads
+// from the underlying storage.
+func (b *Buffered) Read(path string) ([]byte, error) {
+	b.mu.Lock()
+	w, ok := b.pending[filepath.Clean(path)]
+	b.mu.Unlock()
+	if ok {
+		return append([]byte(nil), w.data...), nil
+	}
+	return b.next.Read(path)
+}
+
+// Exists reports whether path has a pending write or exists underneath.
+func (b *Buffered) Exists(path string) (bool, error) {
+	key := filepath.Clean(path)
+	b.mu.Lock()
+	for p := range b.pending {
+		if underPath(p, key) {
+			b.mu.Unlock()
+			return true, nil
+		}
+	}
+	b.mu.Unlock()
+	return b.next.Exists(path)
+}
+
+// Delete drops pending writes under path and deletes it underneath.
+func (b *Buffered) Delete(path string) error {
+	// Wait out a running flush so it can't recreate what is deleted
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	key := filepath.Clean(path)
+	b.mu.Lock()
+	for p, w := range b.pending {
+		if underPath(p, key) {
+			b.pendingBytes -= len(w.data)
+			delete(b.pending, p)
+			delete(b.failed, p)
+		}
+	}
+	b.mu.Unlock()
+	return b.next.Delete(path)
+}
+
+// List returns files under path, including pending writes.
+func (b *Buffered) List(path string) ([]string, error) {
+	files, err := b.next.List(path)
+	if err != nil {
+		return nil, err
+	}
+	key := filepath.Clean(path)
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[f] = true
+	}
+	b.mu.Lock()
+	for p := range b.pending {
+		if underPath(p, key) && !seen[p] {
+			files = append(files, p)
+		}
+	}
+	b.mu.Unlock()
+	sort.Strings(files)
+	return files, nil
+}
+
+// BasePath returns the underlying storage's base path.
+func (b *Buffered) BasePath() string {
+	return b.next.BasePath()
+}
+
+// Pending returns the number of writes not yet written out.
+func (b *Buffered) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.pending)
+}
+
+// Flush writes pending data under the given paths, or everything when no
+// paths are given, and returns the first error for those paths, including
+// errors from earlier background flushes. Reported errors are cleared.
+func (b *Buffered) Flush(paths ...string) error {
+	keys := make([]string, len(paths))
+	for i, p := range paths {
+		keys[i] = filepath.Clean(p)
+	}
+	b.flush(keys)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var failed []string
+	for p := range b.failed {
+		if matchesAny(p, keys) {
+			failed = append(failed, p)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+	err := b.failed[failed[0]]
+	for _, p := range failed {
+		delete(b.failed, p)
+	}
+	return err
+}
+
+// Close stops background flushing and writes everything still pending.
+func (b *Buffered) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return b.Flush()
+}
+
+// flush writes pending entries under keys (all when keys is empty) with a
+// few writers at once. An entry rewritten during the flush stays pending.
+func (b *Buffered) flush(keys []string) {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	type job struct {
+		path string
+		w    bufferedWrite
+	}
+	b.mu.Lock()
+	var jobs []job
+	for p, w := range b.pending {
+		if matchesAny(p, keys) {
+			jobs = append(jobs, job{p, w})
+		}
+	}
+	b.mu.Unlock()
+	if len(jobs) == 0 {
+		return
+	}
+
+	work := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < bufferedFlushWorkers && i < len(jobs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				err := b.next.Write(j.path, j.w.data)
+				b.mu.Lock()
+				if cur, ok := b.pending[j.path]; ok && cur.seq == j.w.seq {
+					b.pendingBytes -= len(cur.data)
+					delete(b.pending, j.path)
+					if err != nil {
+						b.failed[j.path] = err
+					}
+				}
+				b.mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		work <- j
+	}
+	close(work)
+	wg.Wait()
+}
+
+// matchesAny reports whether path is under any of keys, or keys is empty.
+func matchesAny(path string, keys []string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	for _, k := range keys {
+		if underPath(path, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// underPath reports whether path is dir or inside it.
+func underPath(path, dir string) bool {
+	return path == dir || dir == "." || strings.HasPrefix(path, dir+string(os.PathSeparator))
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// countingStorage counts writes per path and can fail writes under a
+// path fragment.
+type countingStorage struct {
+	Storage
+	mu     sync.Mutex
+	writes map[string]int
+	fail   string
+}
+
+func (c *countingStorage) Write(path string, data []byte) error {
+	c.mu.Lock()
+	c.writes[path]++
+	c.mu.Unlock()
+	if c.fail != "" && strings.Contains(path, c.fail) {
+		return errors.New("share went away")
+	}
+	return c.Storage.Write(path, data)
+}
+
+func newCountingBuffered(t *testing.T) (*Buffered, *countingStorage) {
+	t.Helper()
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &countingStorage{Storage: local, writes: make(map[string]int)}
+	b := NewBuffered(next, 0)
+	t.Cleanup(func() { b.Close() })
+	return b, next
+}
+
+func TestBuffered_CoalescesWrites(t *testing.T) {
+	b, next := newCountingBuffered(t)
+
+	for _, v := range []string{"1", "2", "3"} {
+		if err := b.Write("ws/state.json", []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(b.BasePath(), "ws", "state.json")); !os.IsNotExist(err) {
+		t.Fatal("write should be held until flushed")
+	}
+
+	// Pending data is visible before the flush
+	if data, err := b.Read("ws/state.json"); err != nil || string(data) != "3" {
+		t.Errorf("Read() = %q, %v; want pending data", data, err)
+	}
+	if ok, _ := b.Exists("ws"); !ok {
+		t.Error("Exists() should see the pending file's directory")
+	}
+	if files, _ := b.List("ws"); len(files) != 1 || files[0] != filepath.Join("ws", "state.json") {
+		t.Errorf("List() = %v", files)
+	}
+
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := next.writes[filepath.Join("ws", "state.json")]; n != 1 {
+		t.Errorf("expected 1 underlying write, got %d", n)
+	}
+	data, _ := os.ReadFile(filepath.Join(b.BasePath(), "ws", "state.json"))
+	if string(data) != "3" {
+		t.Errorf("expected last write on disk, got %q", data)
+	}
+	if b.Pending() != 0 {
+		t.Errorf("expected nothing pending, got %d", b.Pending())
+	}
+}
+
+func TestBuffered_FlushPaths(t *testing.T) {
+	b, _ := newCountingBuffered(t)
+	b.Write("run/repositories/a/1.json", []byte("a"))
+	b.Write("run/repositories/ab/1.json", []byte("ab"))
+
+	if err := b.Flush("run/repositories/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(b.BasePath(), "run/repositories/a/1.json")); err != nil {
+		t.Errorf("flushed file missing: %v", err)
+	}
+	if b.Pending() != 1 {
+		t.Errorf("a sibling with a shared prefix should stay pending, got %d pending", b.Pending())
+	}
+}
+
+func TestBuffered_FailedWriteReportedToOwner(t *testing.T) {
+	b, next := newCountingBuffered(t)
+	next.fail = "repositories/bad"
+	b.Write("run/repositories/bad/1.json", []byte("x"))
+	b.Write("run/repositories/good/1.json", []byte("y"))
+
+	// A background flush hits the error; only the owner's flush reports it
+	b.flush(nil)
+	if err := b.Flush("run/repositories/good"); err != nil {
+		t.Errorf("unrelated flush failed: %v", err)
+	}
+	if err := b.Flush("run/repositories/bad"); err == nil {
+		t.Error("expected the failed write to be reported")
+	}
+	if err := b.Flush("run/repositories/bad"); err != nil {
+		t.Errorf("error should be reported once, got %v", err)
+	}
+}
+
+func TestBuffered_DeleteDropsPending(t *testing.T) {
+	b, next := newCountingBuffered(t)
+	b.Write("tmp/a.json", []byte("a"))
+
+	if err := b.Delete("tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.writes) != 0 {
+		t.Errorf("deleted writes should never reach storage, got %v", next.writes)
+	}
+}
+
+func TestBuffered_WriteFileReplacesPending(t *testing.T) {
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBuffered(local, 0)
+	defer b.Close()
+
+	if err := b.Write("ws/run.log", []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "run.log")
+	if err := os.WriteFile(src, []byte("fresh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(b, "ws/run.log", src); err != nil {
+		t.Fatal(err)
+	}
+	if b.Pending() != 0 {
+		t.Errorf("Pending() = %d, want the queued write dropped", b.Pending())
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := local.Read("ws/run.log"); string(data) != "fresh" {
+		t.Errorf("file = %q, want the file written directly", data)
+	}
+}
@@ -1,28 +1,62 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
 )
 
+// ErrWriteVerification is returned when a file read back after writing
+// does not match what was written. It points at the storage target (an
+// NFS or SMB mount dropping data, a full quota) rather than the backup.
+var ErrWriteVerification = errcode.New(errcode.StorageError, "write verification failed")
+
 // Local implements Storage for the local filesystem.
 type Local struct {
-	basePath string
+	basePath    string
+	verifyWrite bool
+
+	// readBack reads a file for verification; tests replace it to
+	// simulate storage that loses data
+	readBack func(path string) ([]byte, error)
+}
+
+// LocalOption configures a Local storage backend.
+type LocalOption func(*Local)
+
+// WithVerifyWrites makes every Write fsync the file before renaming it
+// into place and then read it back and compare its size and checksum with
+// the data written.
+func WithVerifyWrites() LocalOption {
+	return func(l *Local) {
+		l.verifyWrite = true
+	}
 }
 
 // NewLocal creates a new Local storage backend.
-func NewLocal(basePath string) (*Local, error) {
+func NewLocal(basePath string, opts ...LocalOption) (*Local, error) {
 	// Convert to absolute path
 	absPath, err := filepath.Abs(basePath)
 	if err != nil {
 		return nil, fmt.Errorf("resolving absolute path: %w", err)
 	}
 
-	return &Local{basePath: absPath}, nil
+	l := &Local{basePath: absPath, readBack: os.ReadFile}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l, nil
 }
 
 // Write writes data to the given path relative to the base path.
+// The file is written to a temporary sibling and renamed into place, so
+// readers never see a partial file and hard-linked copies of the old file
+// are left untouched.
 func (l *Local) Write(path string, data []byte) error {
 	fullPath := filepath.Join(l.basePath, path)
 
@@ -33,13 +67,117 @@ func (l *Local) Write(path string, data []byte) error {
 	}
 
 	// Write the file
-	if err := os.WriteFile(fullPath, data, 0644); err != nil {
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fullPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", fullPath, err)
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil && l.verifyWrite {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, fullPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
 		return fmt.Errorf("writing file %s: %w", fullPath, err)
 	}
 
+	if l.verifyWrite {
+		syncDir(dir)
+		return l.verify(fullPath, data)
+	}
 	return nil
 }
 
+// WriteFile copies the local file src to path. A src that already is the
+// file at path, such as a log appended to in place, is left as it is.
+func (l *Local) WriteFile(path, src string) error {
+	fullPath := filepath.Join(l.basePath, path)
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", fullPath, err)
+	}
+	if info, err := os.Stat(fullPath); err == nil && os.SameFile(info, srcInfo) {
+		return nil
+	}
+
+	dir := filepath.Dir(fullPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating directory %s: %w", dir, err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", fullPath, err)
+	}
+	defer in.Close() //nolint:errcheck // read only
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fullPath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", fullPath, err)
+	}
+	tmpPath := tmp.Name()
+	n, err := io.Copy(tmp, in)
+	if err == nil && l.verifyWrite {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, fullPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("writing file %s: %w", fullPath, err)
+	}
+
+	if l.verifyWrite {
+		syncDir(dir)
+		if info, err := os.Stat(fullPath); err != nil || info.Size() != n {
+			return fmt.Errorf("%w: %s does not have the %d bytes copied", ErrWriteVerification, fullPath, n)
+		}
+	}
+	return nil
+}
+
+// verify reads a written file back and checks it matches data.
+func (l *Local) verify(fullPath string, data []byte) error {
+	got, err := l.readBack(fullPath)
+	if err != nil {
+		return fmt.Errorf("%w: reading back %s: %v", ErrWriteVerification, fullPath, err)
+	}
+	if len(got) != len(data) {
+		return fmt.Errorf("%w: %s has %d bytes on disk, wrote %d", ErrWriteVerification, fullPath, len(got), len(data))
+	}
+	want, have := sha256.Sum256(data), sha256.Sum256(got)
+	if !bytes.Equal(want[:], have[:]) {
+		return fmt.Errorf("%w: %s content differs from what was written", ErrWriteVerification, fullPath)
+	}
+	return nil
+}
+
+// syncDir flushes a directory so a rename into it is durable. Not every
+// filesystem supports syncing directories, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
 // Read reads data from the given path relative to the base path.
 func (l *Local) Read(path string) ([]byte, error) {
 	fullPath := filepath.Join(l.basePath, path)
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -191,3 +193,73 @@ func TestLocal_Read_NonExistent(t *testing.T) {
 		t.Error("expected error reading nonexistent file")
 	}
 }
+
+func TestLocal_WriteVerified(t *testing.T) {
+	tmpDir := t.TempDir()
+	store, err := NewLocal(tmpDir, WithVerifyWrites())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data := []byte(`{"id": 1}`)
+	if err := store.Write("a/b.json", data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(tmpDir, "a", "b.json"))
+	if err != nil || string(got) != string(data) {
+		t.Errorf("expected %q on disk, got %q (%v)", data, got, err)
+	}
+}
+
+func TestLocal_WriteVerificationFails(t *testing.T) {
+	store, _ := NewLocal(t.TempDir(), WithVerifyWrites())
+
+	// Simulate a mount that acknowledges the write but keeps nothing
+	store.readBack = func(string) ([]byte, error) { return nil, nil }
+	err := store.Write("test.json", []byte(`{"id": 1}`))
+	if !errors.Is(err, ErrWriteVerification) {
+		t.Fatalf("expected ErrWriteVerification, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "0 bytes on disk, wrote 9") {
+		t.Errorf("error should give the sizes, got %v", err)
+	}
+
+	// Same size, different content
+	store.readBack = func(string) ([]byte, error) { return []byte(`{"id": 2}`), nil }
+	if err := store.Write("test.json", []byte(`{"id": 1}`)); !errors.Is(err, ErrWriteVerification) {
+		t.Fatalf("expected ErrWriteVerification for corrupted content, got %v", err)
+	}
+
+	// Without verification the read-back is never consulted
+	plain, _ := NewLocal(t.TempDir())
+	plain.readBack = store.readBack
+	if err := plain.Write("test.json", []byte(`{"id": 1}`)); err != nil {
+		t.Errorf("unverified write failed: %v", err)
+	}
+}
+
+func TestLocal_WriteFile(t *testing.T) {
+	l, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := os.WriteFile(src, []byte("archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.WriteFile("ws/archive.tar.gz", src); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := l.Read("ws/archive.tar.gz"); err != nil || string(data) != "archive" {
+		t.Errorf("Read() = %q, %v", data, err)
+	}
+
+	// The file at the path itself is left in place
+	dest := filepath.Join(l.BasePath(), "ws", "archive.tar.gz")
+	if err := l.WriteFile("ws/archive.tar.gz", dest); err != nil {
+		t.Fatalf("WriteFile() of the file at path = %v", err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "archive" {
+		t.Errorf("file = %q after writing it onto itself", data)
+	}
+}
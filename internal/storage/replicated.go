@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// Replicated writes to a local primary and copies every write to a remote
+// replica such as S3. The primary stays the working copy: reads, Exists,
+// List, and BasePath use it, and reads fall back to the replica for files
+// only it has, such as the state file on a fresh machine. A write fails if
+// either copy fails, so a run never reports a file as saved that the
+// replica lacks.
+type Replicated struct {
+	primary Storage
+	replica Storage
+}
+
+// NewReplicated returns a Replicated storage over primary and replica.
+func NewReplicated(primary, replica Storage) *Replicated {
+	return &Replicated{primary: primary, replica: replica}
+}
+
+// Replica returns the remote copy.
+func (r *Replicated) Replica() Storage {
+	return r.replica
+}
+
+// Write writes data to the primary, then the replica.
+func (r *Replicated) Write(path string, data []byte) error {
+	if err := r.primary.Write(path, data); err != nil {
+		return err
+	}
+	if err := r.replica.Write(path, data); err != nil {
+		return fmt.Errorf("replicating %s: %w", path, err)
+	}
+	return nil
+}
+
+// WriteFile stores the local file src in the primary, then the replica.
+func (r *Replicated) WriteFile(path, src string) error {
+	if err := WriteFile(r.primary, path, src); err != nil {
+		return err
+	}
+	if err := WriteFile(r.replica, path, src); err != nil {
+		return fmt.Errorf("replicating %s: %w", path, err)
+	}
+	return nil
+}
+
+// Read reads from the primary, falling back to the replica.
+func (r *Replicated) Read(path string) ([]byte, error) {
+	data, err := r.primary.Read(path)
+	if err == nil {
+		return data, nil
+	}
+	if remote, rerr := r.replica.Read(path); rerr == nil {
+		return remote, nil
+	}
+	return nil, err
+}
+
+// Exists checks the primary.
+func (r *Replicated) Exists(path string) (bool, error) {
+	return r.primary.Exists(path)
+}
+
+// Delete removes path from both copies.
+func (r *Replicated) Delete(path string) error {
+	if err := r.primary.Delete(path); err != nil {
+		return err
+	}
+	if err := r.replica.Delete(path); err != nil {
+		return fmt.Errorf("deleting replica of %s: %w", path, err)
+	}
+	return nil
+}
+
+// List lists the primary.
+func (r *Replicated) List(path string) ([]string, error) {
+	return r.primary.List(path)
+}
+
+// BasePath returns the primary's base path.
+func (r *Replicated) BasePath() string {
+	return r.primary.BasePath()
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplicated(t *testing.T) {
+	primary, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReplicated(primary, replica)
+
+	if err := r.Write("ws/state.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Storage{primary, replica} {
+		if data, err := s.Read("ws/state.json"); err != nil || string(data) != "{}" {
+			t.Errorf("%s: Read() = %q, %v", s.BasePath(), data, err)
+		}
+	}
+
+	// Files only the replica has are read from it
+	if err := os.RemoveAll(filepath.Join(primary.BasePath(), "ws")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := r.Read("ws/state.json"); err != nil || string(data) != "{}" {
+		t.Errorf("Read() = %q, %v; want the replica's copy", data, err)
+	}
+	if _, err := r.Read("ws/missing.json"); err == nil {
+		t.Error("Read() of a missing file should fail")
+	}
+
+	if err := r.Delete("ws"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := replica.Exists("ws/state.json"); ok {
+		t.Error("Delete() left the replica's copy")
+	}
+}
+
+func TestReplicated_WriteFile(t *testing.T) {
+	primary, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReplicated(primary, replica)
+
+	// A log written in place in the working copy is only copied to the replica
+	log := filepath.Join(primary.BasePath(), "ws", "run", "run.log")
+	if err := os.MkdirAll(filepath.Dir(log), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(log, []byte("line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(r, "ws/run/run.log", log); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []Storage{primary, replica} {
+		if data, err := s.Read("ws/run/run.log"); err != nil || string(data) != "line\n" {
+			t.Errorf("%s: Read() = %q, %v", s.BasePath(), data, err)
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Environment variables holding S3 credentials, as read by the AWS CLI and
+// SDKs. The session token is only needed for temporary credentials.
+const (
+	S3AccessKeyEnvVar    = "AWS_ACCESS_KEY_ID"
+	S3SecretKeyEnvVar    = "AWS_SECRET_ACCESS_KEY"
+	S3SessionTokenEnvVar = "AWS_SESSION_TOKEN"
+)
+
+// s3Timeout bounds a single S3 request.
+const s3Timeout = 5 * time.Minute
+
+// s3PartSize is the part size of multipart uploads. WriteFile uploads
+// larger files in parts of this size, holding one part in memory at a
+// time; S3 allows 10,000 parts, so files up to 640 GiB.
+const s3PartSize = 64 << 20
+
+// S3Options configures an S3 storage backend.
+type S3Options struct {
+	Bucket string
+	Region string
+	// Endpoint is the service URL, e.g. http://minio:9000 or
+	// https://s3.us-west-004.backblazeb2.com. Empty means AWS S3 in Region.
+	Endpoint string
+	// Prefix is prepended to every key, so several workspaces or tools can
+	// share a bucket.
+	Prefix string
+	// PathStyle addresses the bucket as a path (endpoint/bucket/key)
+	// rather than a host name (bucket.endpoint/key), as MinIO expects.
+	PathStyle bool
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3 stores files as objects in an S3-compatible bucket (AWS S3, MinIO,
+// Backblaze B2). Paths map to keys under the prefix with forward slashes;
+// directories exist only as key prefixes. Requests are signed with AWS
+// Signature Version 4.
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+	partSize int
+}
+
+// NewS3 returns an S3 storage for the options. Credentials missing from
+// opts are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN.
+func NewS3(opts S3Options) (*S3, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage needs a bucket")
+	}
+	if opts.Region == "" {
+		return nil, fmt.Errorf("s3 storage needs a region")
+	}
+	if opts.AccessKey == "" {
+		opts.AccessKey = os.Getenv(S3AccessKeyEnvVar)
+	}
+	if opts.SecretKey == "" {
+		opts.SecretKey = os.Getenv(S3SecretKeyEnvVar)
+	}
+	if opts.SessionToken == "" {
+		opts.SessionToken = os.Getenv(S3SessionTokenEnvVar)
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, fmt.Errorf("s3 storage needs credentials in %s and %s", S3AccessKeyEnvVar, S3SecretKeyEnvVar)
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	}
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+
+	return &S3{
+		opts:     opts,
+		endpoint: u,
+		client:   &http.Client{Timeout: s3Timeout},
+		now:      time.Now,
+		partSize: s3PartSize,
+	}, nil
+}
+
+// s3Error is the error document S3 returns with a failed request.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// key returns the object key for a storage path.
+func (s *S3) key(p string) string {
+	k := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+	if s.opts.Prefix == "" {
+		return k
+	}
+	if k == "" {
+		return s.opts.Prefix
+	}
+	return s.opts.Prefix + "/" + k
+}
+
+// objectURL returns the URL of an object, or of the bucket for an empty key.
+func (s *S3) objectURL(key string, query url.Values) string {
+	host, rawPath := s.endpoint.Host, s.endpoint.EscapedPath()
+	if s.opts.PathStyle {
+		rawPath += "/" + uriEncode(s.opts.Bucket, true)
+		if key != "" {
+			rawPath += "/" + uriEncode(key, false)
+		}
+	} else {
+		host = s.opts.Bucket + "." + host
+		rawPath += "/" + uriEncode(key, false)
+	}
+	u := s.endpoint.Scheme + "://" + host + rawPath
+	if query != nil {
+		u += "?" + canonicalQuery(query)
+	}
+	return u
+}
+
+// do sends a signed request for key and returns the response. Statuses of
+// 300 and above other than 404 are returned as errors.
+func (s *S3) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("creating s3 request: %w", err)
+	}
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, key, err)
+	}
+	// Buffer the body so the request's timeout can be released
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: reading response: %w", method, key, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		var e s3Error
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("s3 %s %s: %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// Write uploads data to the object at path.
+func (s *S3) Write(p string, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+	if _, err := s.do(http.MethodPut, s.key(p), nil, data); err != nil {
+		return fmt.Errorf("writing file %s: %w", p, err)
+	}
+	return nil
+}
+
+// WriteFile uploads the local file src to the object at path. Files larger
+// than one part are sent as a multipart upload, which is aborted if any
+// part fails so the bucket is not left holding the parts.
+func (s *S3) WriteFile(p, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", p, err)
+	}
+	defer f.Close() //nolint:errcheck // read only
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("writing file %s: %w", p, err)
+	}
+	if info.Size() <= int64(s.partSize) {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return fmt.Errorf("writing file %s: %w", p, err)
+		}
+		return s.Write(p, data)
+	}
+	if err := s.uploadParts(s.key(p), f); err != nil {
+		return fmt.Errorf("writing file %s: %w", p, err)
+	}
+	return nil
+}
+
+// uploadParts sends r to key as a multipart upload.
+func (s *S3) uploadParts(key string, r io.Reader) error {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploads": {""}}, []byte{})
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&initiated); err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("starting multipart upload: no upload ID in response")
+	}
+	uploadID := initiated.UploadID
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []part
+	buf := make([]byte, s.partSize)
+	err = func() error {
+		for n := 1; ; n++ {
+			size, err := io.ReadFull(r, buf)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			query := url.Values{"partNumber": {fmt.Sprint(n)}, "uploadId": {uploadID}}
+			resp, perr := s.do(http.MethodPut, key, query, buf[:size])
+			if perr != nil {
+				return perr
+			}
+			parts = append(parts, part{PartNumber: n, ETag: resp.Header.Get("ETag")})
+			if err == io.ErrUnexpectedEOF {
+				return nil
+			}
+		}
+	}()
+	if err == nil {
+		body, merr := xml.Marshal(struct {
+			XMLName xml.Name `xml:"CompleteMultipartUpload"`
+			Parts   []part   `xml:"Part"`
+		}{Parts: parts})
+		if merr != nil {
+			err = merr
+		} else {
+			err = s.completeUpload(key, uploadID, body)
+		}
+	}
+	if err != nil {
+		_, _ = s.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+		return err
+	}
+	return nil
+}
+
+// completeUpload finishes a multipart upload. S3 can report a failure in
+// the body of a 200 response, so the body is checked too.
+func (s *S3) completeUpload(key, uploadID string, body []byte) error {
+	resp, err := s.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	data, _ := io.ReadAll(resp.Body)
+	var e struct {
+		XMLName xml.Name
+		s3Error
+	}
+	if xml.Unmarshal(data, &e) == nil && e.XMLName.Local == "Error" {
+		return fmt.Errorf("completing multipart upload: %s: %s", e.Code, e.Message)
+	}
+	return nil
+}
+
+// Read downloads the object at path. A missing object returns an error
+// that matches fs.ErrNotExist.
+func (s *S3) Read(p string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, s.key(p), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("reading file %s: %w", p, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("reading file %s: %w", p, fs.ErrNotExist)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Exists reports whether path is an object or a prefix of one.
+func (s *S3) Exists(p string) (bool, error) {
+	resp, err := s.do(http.MethodHead, s.key(p), nil, nil)
+	if err != nil {
+		return false, fmt.Errorf("checking path %s: %w", p, err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return true, nil
+	}
+	keys, _, err := s.listPage(s.key(p)+"/", "", 1)
+	if err != nil {
+		return false, fmt.Errorf("checking path %s: %w", p, err)
+	}
+	return len(keys) > 0, nil
+}
+
+// Delete removes the object at path and every object under it.
+func (s *S3) Delete(p string) error {
+	key := s.key(p)
+	keys, err := s.listAll(key + "/")
+	if err != nil {
+		return fmt.Errorf("deleting %s: %w", p, err)
+	}
+	if key != "" {
+		keys = append(keys, key)
+	}
+	for _, k := range keys {
+		if _, err := s.do(http.MethodDelete, k, nil, nil); err != nil {
+			return fmt.Errorf("deleting %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// List returns the paths of all objects under path, relative to the prefix.
+func (s *S3) List(p string) ([]string, error) {
+	prefix := s.key(p)
+	if prefix != "" {
+		prefix += "/"
+	}
+	keys, err := s.listAll(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", p, err)
+	}
+	files := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if s.opts.Prefix != "" {
+			k = strings.TrimPrefix(k, s.opts.Prefix+"/")
+		}
+		files = append(files, filepath.FromSlash(k))
+	}
+	return files, nil
+}
+
+// BasePath returns the bucket and prefix as an s3:// URL.
+func (s *S3) BasePath() string {
+	return strings.TrimRight("s3://"+s.opts.Bucket+"/"+s.opts.Prefix, "/")
+}
+
+// listAll returns every key starting with prefix.
+func (s *S3) listAll(prefix string) ([]string, error) {
+	var all []string
+	token := ""
+	for {
+		keys, next, err := s.listPage(prefix, token, 0)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, keys...)
+		if next == "" {
+			return all, nil
+		}
+		token = next
+	}
+}
+
+// listPage returns one page of keys starting with prefix and the token
+// for the next page, empty after the last. maxKeys 0 uses the default.
+func (s *S3) listPage(prefix, token string, maxKeys int) ([]string, string, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", fmt.Sprint(maxKeys))
+	}
+	resp, err := s.do(http.MethodGet, "", query, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", fmt.Errorf("bucket %s not found", s.opts.Bucket)
+	}
+	var result struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("parsing bucket listing: %w", err)
+	}
+	keys := make([]string, 0, len(result.Contents))
+	for _, c := range result.Contents {
+		keys = append(keys, c.Key)
+	}
+	if !result.IsTruncated {
+		return keys, "", nil
+	}
+	if result.NextContinuationToken == "" {
+		return nil, "", errors.New("truncated bucket listing without a continuation token")
+	}
+	return keys, result.NextContinuationToken, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.opts.SessionToken)
+	}
+
+	scope := date + "/" + s.opts.Region + "/s3/aws4_request"
+	signedHeaders, canonicalHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), date)
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalHeaders returns the signed header names and the canonical
+// header block of req: the host and every header it carries.
+func canonicalHeaders(req *http.Request) (signed, canonical string) {
+	values := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name + ":" + values[name] + "\n")
+	}
+	return strings.Join(names, ";"), sb.String()
+}
+
+// canonicalQuery encodes query parameters sorted by name as SigV4 expects.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, v := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -1,6 +1,11 @@
 // Package storage provides storage backends for backup data.
 package storage
 
+import (
+	"fmt"
+	"os"
+)
+
 // Storage is the interface for storage backends.
 type Storage interface {
 	// Write writes data to the given path.
@@ -21,3 +26,23 @@ type Storage interface {
 	// BasePath returns the base path for the storage.
 	BasePath() string
 }
+
+// FileWriter is implemented by storage that can store a file from the
+// local filesystem without reading all of it into memory.
+type FileWriter interface {
+	// WriteFile stores the contents of the local file src at path.
+	WriteFile(path, src string) error
+}
+
+// WriteFile stores the local file src at path in s, streaming it when s
+// is a FileWriter and reading it into memory otherwise.
+func WriteFile(s Storage, path, src string) error {
+	if fw, ok := s.(FileWriter); ok {
+		return fw.WriteFile(path, src)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", src, err)
+	}
+	return s.Write(path, data)
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package throttle
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cpuTime adds up the user and system time of the process and its
+// exited children.
+func cpuTime() (time.Duration, error) {
+	var total time.Duration
+	for _, who := range []int{unix.RUSAGE_SELF, unix.RUSAGE_CHILDREN} {
+		var ru unix.Rusage
+		if err := unix.Getrusage(who, &ru); err != nil {
+			return 0, err
+		}
+		total += time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+	}
+	return total, nil
+}
@@ -0,0 +1,24 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package throttle
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// setIOClass is not implemented on this platform.
+func setIOClass(string) error {
+	return errors.ErrUnsupported
+}
+
+// setNice sets the niceness of the process.
+func setNice(nice int) error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, nice)
+}
+
+// loadAverage is not implemented on this platform.
+func loadAverage() (float64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,81 @@
+package throttle
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ioprio_set(2) constants.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioLowestBE   = 7
+)
+
+// setIOClass sets the I/O priority of every thread of the process. Linux
+// keeps it per thread; threads and processes started later inherit it
+// from the thread that creates them.
+func setIOClass(class string) error {
+	var prio int
+	switch class {
+	case IOBestEffort:
+		prio = ioprioClassBE<<ioprioClassShift | ioprioLowestBE
+	case IOIdle:
+		prio = ioprioClassIdle << ioprioClassShift
+	default:
+		return fmt.Errorf("unknown I/O class %q", class)
+	}
+	return eachThread(func(tid int) error {
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// setNice sets the niceness of every thread of the process, which Linux
+// also keeps per thread.
+func setNice(nice int) error {
+	return eachThread(func(tid int) error {
+		return unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// eachThread calls f with the ID of each thread of the process. Threads
+// that exit meanwhile are skipped.
+func eachThread(f func(tid int) error) error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if err := f(tid); err != nil && !errors.Is(err, unix.ESRCH) {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAverage returns the 1-minute load average.
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg: %q", data)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
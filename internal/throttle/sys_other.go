@@ -0,0 +1,28 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package throttle
+
+import (
+	"errors"
+	"time"
+)
+
+// setIOClass is not implemented on this platform.
+func setIOClass(string) error {
+	return errors.ErrUnsupported
+}
+
+// setNice is not implemented on this platform.
+func setNice(int) error {
+	return errors.ErrUnsupported
+}
+
+// loadAverage is not implemented on this platform.
+func loadAverage() (float64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// cpuTime is not implemented on this platform.
+func cpuTime() (time.Duration, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,108 @@
+// Package throttle keeps bb-backup from starving other work on a shared
+// backup host: it lowers the I/O and CPU priority of the process and the
+// git processes it starts, and decides how many workers may run from the
+// system load and the run's own CPU use. Everything here is best effort;
+// what the operating system does not support returns an error wrapping
+// errors.ErrUnsupported.
+package throttle
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// I/O scheduling classes, as with ionice.
+const (
+	IOBestEffort = "best-effort" // Best-effort class at its lowest priority
+	IOIdle       = "idle"        // Disk time only when no one else wants it
+)
+
+// Lower sets the I/O class (IOBestEffort, IOIdle, or "" to leave it) and
+// the CPU niceness (0 to leave it) of the process. Git processes started
+// afterwards inherit both. The errors of the two settings are joined.
+func Lower(ioClass string, nice int) error {
+	var errs []error
+	if ioClass != "" {
+		if err := setIOClass(ioClass); err != nil {
+			errs = append(errs, fmt.Errorf("setting I/O class %s: %w", ioClass, err))
+		}
+	}
+	if nice != 0 {
+		if err := setNice(nice); err != nil {
+			errs = append(errs, fmt.Errorf("setting niceness %d: %w", nice, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sample is what a Probe measured over one interval. A negative value was
+// not available on this system.
+type Sample struct {
+	Load       float64 // 1-minute load average per core
+	CPUPercent float64 // CPU used by the process and its finished children, as a percentage of all cores
+}
+
+// Probe measures the system load and the process's CPU use.
+type Probe struct {
+	lastCPU time.Duration
+	lastAt  time.Time
+}
+
+// Sample measures the load now and the CPU used since the previous call.
+// The first call has no interval to measure CPU use over.
+func (p *Probe) Sample(now time.Time) Sample {
+	s := Sample{Load: -1, CPUPercent: -1}
+	if load, err := loadAverage(); err == nil {
+		s.Load = load / float64(runtime.NumCPU())
+	}
+	if cpu, err := cpuTime(); err == nil {
+		if !p.lastAt.IsZero() && now.After(p.lastAt) {
+			wall := now.Sub(p.lastAt) * time.Duration(runtime.NumCPU())
+			s.CPUPercent = 100 * float64(cpu-p.lastCPU) / float64(wall)
+		}
+		p.lastCPU, p.lastAt = cpu, now
+	}
+	return s
+}
+
+// Governor lowers a worker limit one worker at a time while the load or the
+// CPU use is over its threshold, and raises it again once both are well
+// below, so the run gives way to other work without oscillating.
+type Governor struct {
+	Max, Min      int
+	LoadThreshold float64 // Load average per core; 0 ignores the load
+	CPUPercent    float64 // Percentage of all cores; 0 ignores CPU use
+
+	limit int
+}
+
+// recovery is the share of a threshold a measurement must fall below for
+// the limit to go back up.
+const recovery = 0.8
+
+// Limit returns the current limit.
+func (g *Governor) Limit() int {
+	if g.limit == 0 {
+		return g.Max
+	}
+	return g.limit
+}
+
+// Update adjusts the limit for a sample and returns it.
+func (g *Governor) Update(s Sample) int {
+	limit := g.Limit()
+	over := (g.LoadThreshold > 0 && s.Load > g.LoadThreshold) ||
+		(g.CPUPercent > 0 && s.CPUPercent > g.CPUPercent)
+	under := (g.LoadThreshold <= 0 || s.Load < g.LoadThreshold*recovery) &&
+		(g.CPUPercent <= 0 || s.CPUPercent < g.CPUPercent*recovery)
+	switch {
+	case over && limit > max(g.Min, 1):
+		limit--
+	case under && limit < g.Max:
+		limit++
+	}
+	g.limit = limit
+	return limit
+}
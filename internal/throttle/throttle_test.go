@@ -0,0 +1,67 @@
+package throttle
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestGovernor(t *testing.T) {
+	g := &Governor{Max: 4, Min: 2, LoadThreshold: 1.0, CPUPercent: 50}
+	if got := g.Limit(); got != 4 {
+		t.Fatalf("Limit() = %d, want Max before any sample", got)
+	}
+
+	steps := []struct {
+		name   string
+		sample Sample
+		want   int
+	}{
+		{"load over", Sample{Load: 1.5, CPUPercent: 10}, 3},
+		{"CPU over", Sample{Load: 0.2, CPUPercent: 70}, 2},
+		{"floor at Min", Sample{Load: 3, CPUPercent: 90}, 2},
+		{"between recovery and threshold", Sample{Load: 0.9, CPUPercent: 10}, 2},
+		{"well below", Sample{Load: 0.5, CPUPercent: 30}, 3},
+		{"unknown counts as below", Sample{Load: -1, CPUPercent: -1}, 4},
+		{"ceiling at Max", Sample{Load: 0.1, CPUPercent: 1}, 4},
+	}
+	for _, step := range steps {
+		if got := g.Update(step.sample); got != step.want {
+			t.Errorf("%s: Update() = %d, want %d", step.name, got, step.want)
+		}
+	}
+}
+
+func TestGovernor_IgnoresDisabledThresholds(t *testing.T) {
+	g := &Governor{Max: 3, Min: 1, CPUPercent: 50}
+	if got := g.Update(Sample{Load: 10, CPUPercent: 20}); got != 3 {
+		t.Errorf("Update() = %d; the load should not count without LoadThreshold", got)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	var p Probe
+	start := time.Now()
+	first := p.Sample(start)
+	if first.CPUPercent != -1 {
+		t.Errorf("first sample CPUPercent = %v, want -1 without an interval", first.CPUPercent)
+	}
+	if runtime.GOOS == "linux" && first.Load < 0 {
+		t.Errorf("Load = %v, want the load average on Linux", first.Load)
+	}
+
+	// Burn some CPU so the second sample has something to measure
+	for x, deadline := 0, time.Now().Add(20*time.Millisecond); time.Now().Before(deadline); x++ {
+		_ = x * x
+	}
+	second := p.Sample(start.Add(time.Second))
+	if runtime.GOOS == "linux" && second.CPUPercent <= 0 {
+		t.Errorf("CPUPercent = %v, want the CPU used since the first sample", second.CPUPercent)
+	}
+}
+
+func TestLower_Nothing(t *testing.T) {
+	if err := Lower("", 0); err != nil {
+		t.Errorf("Lower() error = %v, want nil when nothing is set", err)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/format"
 )
 
 // Spinner frames for activity indicator
@@ -32,8 +34,14 @@ type ProgressBar struct {
 	spinnerIdx    int             // Current spinner frame
 	twoLineMode   bool            // Show current repo on separate line above progress bar
 	failedNames   []string        // Names of failed items for display
+	plain         bool            // Heartbeat lines without escape codes
+	eta           time.Duration   // Caller-supplied estimate, counting down from etaSetAt
+	etaSetAt      time.Time
 }
 
+// DefaultHeartbeatInterval is the default time between lines in plain mode.
+const DefaultHeartbeatInterval = 10 * time.Second
+
 // ProgressBarOption configures a ProgressBar.
 type ProgressBarOption func(*ProgressBar)
 
@@ -51,7 +59,8 @@ func WithBarWidth(width int) ProgressBarOption {
 	}
 }
 
-// WithUpdateInterval sets the refresh interval (default: 200ms).
+// WithUpdateInterval sets the refresh interval (default: 200ms, or
+// DefaultHeartbeatInterval in plain mode).
 func WithUpdateInterval(d time.Duration) ProgressBarOption {
 	return func(p *ProgressBar) {
 		p.interval = d
@@ -65,13 +74,21 @@ func WithTwoLineMode() ProgressBarOption {
 	}
 }
 
+// WithPlainMode renders a self-contained "percentage heartbeat" line at each
+// interval instead of redrawing in place. It uses no escape codes, so output
+// stays readable in CI logs and consoles without ANSI support.
+func WithPlainMode() ProgressBarOption {
+	return func(p *ProgressBar) {
+		p.plain = true
+	}
+}
+
 // NewProgressBar creates a new progress bar.
 func NewProgressBar(total int, opts ...ProgressBarOption) *ProgressBar {
 	p := &ProgressBar{
 		writer:        os.Stderr,
 		total:         total,
 		width:         40,
-		interval:      200 * time.Millisecond,
 		startTime:     time.Now(),
 		stop:          make(chan struct{}),
 		done:          make(chan struct{}),
@@ -80,6 +97,12 @@ func NewProgressBar(total int, opts ...ProgressBarOption) *ProgressBar {
 	for _, opt := range opts {
 		opt(p)
 	}
+	if p.interval <= 0 {
+		p.interval = 200 * time.Millisecond
+		if p.plain {
+			p.interval = DefaultHeartbeatInterval
+		}
+	}
 	return p
 }
 
@@ -97,7 +120,7 @@ func (p *ProgressBar) Start() {
 	p.mu.Unlock()
 
 	// In two-line mode, print initial empty lines for status and failed lines
-	if p.twoLineMode {
+	if p.twoLineMode && !p.plain {
 		fmt.Fprintln(p.writer, "") // Status line
 		fmt.Fprintln(p.writer, "") // Failed repos line (will appear below progress)
 	}
@@ -120,7 +143,9 @@ func (p *ProgressBar) Stop() {
 
 	// Print final state and move to next line
 	p.render()
-	fmt.Fprintln(p.writer)
+	if !p.plain {
+		fmt.Fprintln(p.writer)
+	}
 }
 
 // SetCurrent sets the current item being processed.
@@ -154,6 +179,15 @@ func (p *ProgressBar) Fail(name string) {
 	p.mu.Unlock()
 }
 
+// SetETA replaces the average-based ETA with an estimate from the caller,
+// such as one built from earlier runs. It counts down until updated again.
+func (p *ProgressBar) SetETA(remaining time.Duration) {
+	p.mu.Lock()
+	p.eta = remaining
+	p.etaSetAt = time.Now()
+	p.mu.Unlock()
+}
+
 // GetStats returns current statistics.
 func (p *ProgressBar) GetStats() (completed, failed int) {
 	p.mu.Lock()
@@ -188,6 +222,7 @@ func (p *ProgressBar) render() {
 	spinnerIdx := p.spinnerIdx
 	failedNames := make([]string, len(p.failedNames))
 	copy(failedNames, p.failedNames)
+	estimate, estimatedAt := p.eta, p.etaSetAt
 	p.spinnerIdx = (p.spinnerIdx + 1) % len(spinnerFrames)
 	p.mu.Unlock()
 
@@ -205,9 +240,21 @@ func (p *ProgressBar) render() {
 		avgPerItem := elapsed / time.Duration(processed)
 		remaining := total - processed
 		eta = avgPerItem * time.Duration(remaining)
+		if estimate > 0 {
+			// Keep the supplied estimate until it runs out; an overrun
+			// falls back to the average
+			if left := estimate - time.Since(estimatedAt); left > 0 {
+				eta = left
+			}
+		}
 		etaTime = time.Now().Add(eta)
 	}
 
+	if p.plain {
+		p.renderPlain(percent, processed, total, failed, elapsed, eta, etaTime, current)
+		return
+	}
+
 	// Build progress bar
 	bar := p.buildBar(percent)
 
@@ -227,14 +274,14 @@ func (p *ProgressBar) render() {
 		}
 
 		// Build progress line
-		progressLine := fmt.Sprintf("%s %.0f%% (%d/%d", bar, percent, processed, total)
+		progressLine := fmt.Sprintf("%s %s (%d/%d", bar, format.Percent(percent), processed, total)
 		if failed > 0 {
 			progressLine += fmt.Sprintf(", %d failed", failed)
 		}
 		progressLine += ")"
-		progressLine += fmt.Sprintf(" ⏱ %s", formatDuration(elapsed))
+		progressLine += fmt.Sprintf(" ⏱ %s", format.Duration(elapsed))
 		if eta > 0 {
-			progressLine += fmt.Sprintf(" ETA: %s (%s)", formatDuration(eta), etaTime.Format("15:04:05"))
+			progressLine += fmt.Sprintf(" ETA: %s (%s)", format.Duration(eta), etaTime.Format("15:04:05"))
 		}
 
 		// Build failed line
@@ -251,18 +298,18 @@ func (p *ProgressBar) render() {
 		fmt.Fprintf(p.writer, "\033[2F\033[K%s\n\033[K%s\n\033[K%s", statusLine, progressLine, failedLine)
 	} else {
 		// Single-line mode (original behavior)
-		statusLine := fmt.Sprintf("\r%s %.0f%% (%d/%d", bar, percent, processed, total)
+		statusLine := fmt.Sprintf("\r%s %s (%d/%d", bar, format.Percent(percent), processed, total)
 		if failed > 0 {
 			statusLine += fmt.Sprintf(", %d failed", failed)
 		}
 		statusLine += ")"
 
 		// Runtime
-		statusLine += fmt.Sprintf(" ⏱ %s", formatDuration(elapsed))
+		statusLine += fmt.Sprintf(" ⏱ %s", format.Duration(elapsed))
 
 		// ETA
 		if eta > 0 {
-			statusLine += fmt.Sprintf(" ETA: %s (%s)", formatDuration(eta), etaTime.Format("15:04:05"))
+			statusLine += fmt.Sprintf(" ETA: %s (%s)", format.Duration(eta), etaTime.Format("15:04:05"))
 		} else if processed >= total && total > 0 {
 			statusLine += " ✓ Complete"
 		}
@@ -283,6 +330,24 @@ func (p *ProgressBar) render() {
 	}
 }
 
+// renderPlain writes one timestamped heartbeat line.
+func (p *ProgressBar) renderPlain(percent float64, processed, total, failed int, elapsed, eta time.Duration, etaTime time.Time, current string) {
+	line := fmt.Sprintf("[%s] %s (%d/%d", time.Now().Format("15:04:05"), format.Percent(percent), processed, total)
+	if failed > 0 {
+		line += fmt.Sprintf(", %d failed", failed)
+	}
+	line += fmt.Sprintf(") elapsed %s", format.Duration(elapsed))
+	if eta > 0 {
+		line += fmt.Sprintf(", ETA %s (%s)", format.Duration(eta), etaTime.Format("15:04:05"))
+	} else if processed >= total && total > 0 {
+		line += ", complete"
+	}
+	if current != "" {
+		line += " - " + current
+	}
+	fmt.Fprintln(p.writer, line)
+}
+
 func (p *ProgressBar) buildBar(percent float64) string {
 	filled := int(float64(p.width) * percent / 100)
 	if filled > p.width {
@@ -298,19 +363,3 @@ func (p *ProgressBar) buildBar(percent float64) string {
 func (p *ProgressBar) clearLine() {
 	fmt.Fprintf(p.writer, "\r\033[K")
 }
-
-// formatDuration formats a duration in a human-friendly way.
-func formatDuration(d time.Duration) string {
-	if d < time.Minute {
-		return fmt.Sprintf("%ds", int(d.Seconds()))
-	}
-	if d < time.Hour {
-		mins := int(d.Minutes())
-		secs := int(d.Seconds()) % 60
-		return fmt.Sprintf("%dm%02ds", mins, secs)
-	}
-	hours := int(d.Hours())
-	mins := int(d.Minutes()) % 60
-	secs := int(d.Seconds()) % 60
-	return fmt.Sprintf("%dh%02dm%02ds", hours, mins, secs)
-}
@@ -2,6 +2,8 @@ package ui
 
 import (
 	"bytes"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -92,24 +94,6 @@ func TestProgressBarStartStop(t *testing.T) {
 	}
 }
 
-func TestFormatDuration(t *testing.T) {
-	tests := []struct {
-		d    time.Duration
-		want string
-	}{
-		{30 * time.Second, "30s"},
-		{90 * time.Second, "1m30s"},
-		{3661 * time.Second, "1h01m01s"},
-	}
-
-	for _, tt := range tests {
-		got := formatDuration(tt.d)
-		if got != tt.want {
-			t.Errorf("formatDuration(%v) = %q, want %q", tt.d, got, tt.want)
-		}
-	}
-}
-
 func TestProgressBarTwoLineMode(t *testing.T) {
 	var buf bytes.Buffer
 	pb := NewProgressBar(5,
@@ -244,3 +228,39 @@ func TestProgressBarAllComplete(t *testing.T) {
 		t.Errorf("failed = %d, want 0", f)
 	}
 }
+
+func TestProgressBarPlainMode(t *testing.T) {
+	var buf bytes.Buffer
+	pb := NewProgressBar(4, WithBarWriter(&buf), WithPlainMode(), WithTwoLineMode())
+	if pb.interval != DefaultHeartbeatInterval {
+		t.Errorf("expected default heartbeat interval, got %v", pb.interval)
+	}
+
+	pb.SetCurrent("cloning: repo-a")
+	pb.Complete("repo-a")
+	pb.Fail("repo-b")
+	pb.render()
+
+	out := buf.String()
+	if strings.Contains(out, "\033") || strings.Contains(out, "\r") {
+		t.Errorf("plain mode output must not contain control sequences: %q", out)
+	}
+	if !strings.HasSuffix(out, "\n") || strings.Count(out, "\n") != 1 {
+		t.Errorf("expected exactly one line, got %q", out)
+	}
+	if !strings.Contains(out, "50% (2/4, 1 failed)") || !strings.Contains(out, "cloning: repo-a") {
+		t.Errorf("unexpected heartbeat line: %q", out)
+	}
+}
+
+func TestSupportsANSI_NonTerminal(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("creating temp file: %v", err)
+	}
+	defer f.Close()
+
+	if SupportsANSI(f) {
+		t.Error("regular files should not report ANSI support")
+	}
+}
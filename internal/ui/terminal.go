@@ -0,0 +1,30 @@
+package ui
+
+import (
+	"os"
+	"runtime"
+)
+
+// SupportsANSI reports whether f is a terminal that understands ANSI
+// cursor-movement escape codes. Pipes and files (CI logs), TERM=dumb, and
+// legacy Windows consoles report false.
+func SupportsANSI(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	term := os.Getenv("TERM")
+	if term == "dumb" {
+		return false
+	}
+
+	if runtime.GOOS == "windows" {
+		// conhost only interprets escape codes when a modern host or
+		// wrapper has enabled virtual terminal processing
+		return term != "" || os.Getenv("WT_SESSION") != "" ||
+			os.Getenv("ANSICON") != "" || os.Getenv("ConEmuANSI") == "ON"
+	}
+
+	return true
+}
@@ -0,0 +1,304 @@
+// Package webhook receives Bitbucket webhooks for bb-backup listen. It
+// reduces each delivery to the repository that changed and whether its git
+// refs or its pull requests and issues did, and gathers the changes into
+// batches for targeted incremental backups.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBody bounds a delivery; push events list at most a few commits per
+// ref, so real payloads are far smaller.
+const maxBody = 10 << 20
+
+// Event is a webhook delivery reduced to what a targeted backup needs.
+type Event struct {
+	Key       string // X-Event-Key, e.g. "repo:push"
+	Workspace string // Workspace, or project key on Data Center and Server
+	Repo      string // Repository slug
+	Git       bool   // Refs changed
+	Metadata  bool   // A pull request or issue changed
+}
+
+// kind returns whether an event key reports a change to refs or to
+// metadata. Keys of both Bitbucket Cloud and Data Center are recognized;
+// others, such as commit statuses, report neither.
+func kind(key string) (git, metadata bool) {
+	switch {
+	case key == "repo:push", key == "repo:refs_changed":
+		return true, false
+	case strings.HasPrefix(key, "pullrequest:"), strings.HasPrefix(key, "pr:"), strings.HasPrefix(key, "issue:"):
+		return false, true
+	}
+	return false, false
+}
+
+// payload is the part of a delivery's body naming the repository, in the
+// shapes of both Bitbucket Cloud and Data Center.
+type payload struct {
+	Repository struct {
+		FullName string `json:"full_name"` // Cloud: "workspace/slug"
+		Slug     string `json:"slug"`      // Data Center and Server
+		Project  struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	} `json:"repository"`
+
+	// Data Center pull request events name the repository on the PR's
+	// target ref instead
+	PullRequest struct {
+		ToRef struct {
+			Repository struct {
+				Slug    string `json:"slug"`
+				Project struct {
+					Key string `json:"key"`
+				} `json:"project"`
+			} `json:"repository"`
+		} `json:"toRef"`
+	} `json:"pullRequest"`
+}
+
+// Parse reduces a delivery with the given event key to an Event. It
+// returns ok false for events that no backup needs to follow.
+func Parse(key string, body []byte) (ev Event, ok bool, err error) {
+	git, metadata := kind(key)
+	if !git && !metadata {
+		return Event{}, false, nil
+	}
+	var p payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return Event{}, false, fmt.Errorf("parsing %s payload: %w", key, err)
+	}
+	ev = Event{Key: key, Git: git, Metadata: metadata}
+	repo := p.Repository
+	switch {
+	case repo.FullName != "":
+		ws, slug, found := strings.Cut(repo.FullName, "/")
+		if !found {
+			return Event{}, false, fmt.Errorf("%s payload: repository full_name %q is not workspace/slug", key, repo.FullName)
+		}
+		ev.Workspace, ev.Repo = ws, slug
+	case repo.Slug != "":
+		ev.Workspace, ev.Repo = repo.Project.Key, repo.Slug
+	default:
+		target := p.PullRequest.ToRef.Repository
+		ev.Workspace, ev.Repo = target.Project.Key, target.Slug
+	}
+	if ev.Repo == "" {
+		return Event{}, false, fmt.Errorf("%s payload names no repository", key)
+	}
+	return ev, true, nil
+}
+
+// VerifySignature checks the X-Hub-Signature header of a delivery,
+// "sha256=" and the hex HMAC-SHA256 of the body keyed with the webhook's
+// secret.
+func VerifySignature(secret string, body []byte, header string) bool {
+	sig, found := strings.CutPrefix(header, "sha256=")
+	if !found {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// Batch is a set of repositories to back up together: those whose changes
+// all need the same kind of backup.
+type Batch struct {
+	Repos    []string // Sorted slugs
+	Git      bool     // Fetch git
+	Metadata bool     // Fetch pull requests, issues, and other metadata
+}
+
+// Options configures a Receiver.
+type Options struct {
+	// Workspace, when set, is the only workspace (or Data Center project)
+	// whose events are accepted, compared case-insensitively
+	Workspace string
+	// Secret, when set, must have signed every delivery
+	Secret string
+	// Delay gathers the changes reported this long after the first one
+	// into the same backup
+	Delay time.Duration
+	// Backup backs up a batch; batches run one at a time
+	Backup func(ctx context.Context, batch Batch) error
+	// Log receives a line per accepted event and failed backup (nil
+	// logs nothing)
+	Log func(format string, args ...interface{})
+}
+
+// change is what has happened to a repository since its last backup.
+type change struct {
+	git, metadata bool
+}
+
+// Receiver is an http.Handler for webhook deliveries that queues the
+// changes they report, and, while Run is running, backs them up in
+// batches.
+type Receiver struct {
+	opts Options
+
+	mu      sync.Mutex
+	pending map[string]*change // By slug
+	wake    chan struct{}      // Signalled when pending goes from empty to not
+}
+
+// NewReceiver returns a Receiver with the given options.
+func NewReceiver(opts Options) *Receiver {
+	if opts.Log == nil {
+		opts.Log = func(string, ...interface{}) {}
+	}
+	return &Receiver{
+		opts:    opts,
+		pending: make(map[string]*change),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// ServeHTTP accepts a delivery. Deliveries with a bad signature are
+// refused with 401 and unreadable ones with 400; events that need no
+// backup are acknowledged with 200, and queued ones with 202.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBody+1))
+	if err != nil || len(body) > maxBody {
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+	if r.opts.Secret != "" && !VerifySignature(r.opts.Secret, body, req.Header.Get("X-Hub-Signature")) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	key := req.Header.Get("X-Event-Key")
+	ev, ok, err := Parse(key, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok || (r.opts.Workspace != "" && !strings.EqualFold(ev.Workspace, r.opts.Workspace)) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	r.opts.Log("Webhook %s for %s", ev.Key, ev.Repo)
+	r.Add(ev)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Add queues an event's change for the next batch.
+func (r *Receiver) Add(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.pending[ev.Repo]
+	if c == nil {
+		c = &change{}
+		r.pending[ev.Repo] = c
+	}
+	c.git = c.git || ev.Git
+	c.metadata = c.metadata || ev.Metadata
+	if len(r.pending) == 1 {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run backs up queued changes until ctx is done: Delay after the first
+// change arrives, everything queued is taken and backed up in batches,
+// one after another. Changes that arrive during a backup wait for the
+// next round. A failed backup is logged; its repositories are backed up
+// again when they next change.
+func (r *Receiver) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.wake:
+		}
+		if r.opts.Delay > 0 {
+			timer := time.NewTimer(r.opts.Delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		for _, batch := range r.Take() {
+			if err := r.opts.Backup(ctx, batch); err != nil {
+				r.opts.Log("Backup of %s failed: %v", strings.Join(batch.Repos, ", "), err)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Take removes everything queued and returns it as batches, one for each
+// kind of backup needed: git only, metadata only, or both.
+func (r *Receiver) Take() []Batch {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]*change)
+	// Drop a wake-up for changes taken here
+	select {
+	case <-r.wake:
+	default:
+	}
+	r.mu.Unlock()
+
+	byKind := make(map[change]*Batch)
+	var batches []*Batch
+	for slug, c := range pending {
+		b := byKind[*c]
+		if b == nil {
+			b = &Batch{Git: c.git, Metadata: c.metadata}
+			byKind[*c] = b
+			batches = append(batches, b)
+		}
+		b.Repos = append(b.Repos, slug)
+	}
+	result := make([]Batch, 0, len(batches))
+	for _, b := range batches {
+		sort.Strings(b.Repos)
+		result = append(result, *b)
+	}
+	// Both, then git only, then metadata only
+	sort.Slice(result, func(i, j int) bool {
+		return rank(result[i]) < rank(result[j])
+	})
+	return result
+}
+
+func rank(b Batch) int {
+	switch {
+	case b.Git && b.Metadata:
+		return 0
+	case b.Git:
+		return 1
+	}
+	return 2
+}
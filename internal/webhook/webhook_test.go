@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for name, tc := range map[string]struct {
+		key, body string
+		want      Event
+		ok        bool
+	}{
+		"cloud push": {
+			"repo:push", `{"repository": {"full_name": "acme/api", "name": "API"}}`,
+			Event{Key: "repo:push", Workspace: "acme", Repo: "api", Git: true}, true,
+		},
+		"cloud pull request": {
+			"pullrequest:updated", `{"repository": {"full_name": "acme/web"}}`,
+			Event{Key: "pullrequest:updated", Workspace: "acme", Repo: "web", Metadata: true}, true,
+		},
+		"cloud issue comment": {
+			"issue:comment_created", `{"repository": {"full_name": "acme/web"}}`,
+			Event{Key: "issue:comment_created", Workspace: "acme", Repo: "web", Metadata: true}, true,
+		},
+		"server push": {
+			"repo:refs_changed", `{"repository": {"slug": "core", "project": {"key": "PLAT"}}}`,
+			Event{Key: "repo:refs_changed", Workspace: "PLAT", Repo: "core", Git: true}, true,
+		},
+		"server pull request": {
+			"pr:merged", `{"pullRequest": {"toRef": {"repository": {"slug": "core", "project": {"key": "PLAT"}}}}}`,
+			Event{Key: "pr:merged", Workspace: "PLAT", Repo: "core", Metadata: true}, true,
+		},
+		"ignored": {"repo:commit_status_updated", `not even JSON`, Event{}, false},
+	} {
+		got, ok, err := Parse(tc.key, []byte(tc.body))
+		if err != nil || ok != tc.ok || got != tc.want {
+			t.Errorf("%s: Parse() = %+v, %v, %v; want %+v, %v", name, got, ok, err, tc.want, tc.ok)
+		}
+	}
+
+	if _, _, err := Parse("repo:push", []byte(`{"repository": {}}`)); err == nil {
+		t.Error("Parse() of a payload without a repository should fail")
+	}
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestReceiver_ServeHTTP(t *testing.T) {
+	r := NewReceiver(Options{Workspace: "acme", Secret: "s3cret"})
+	body := `{"repository": {"full_name": "ACME/api"}}`
+	deliver := func(method, key, body, signature string) int {
+		req := httptest.NewRequest(method, "/webhook", strings.NewReader(body))
+		req.Header.Set("X-Event-Key", key)
+		req.Header.Set("X-Hub-Signature", signature)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for name, tc := range map[string]struct {
+		method, key, body, signature string
+		want                         int
+	}{
+		"accepted":        {http.MethodPost, "repo:push", body, sign("s3cret", body), http.StatusAccepted},
+		"bad signature":   {http.MethodPost, "repo:push", body, sign("guess", body), http.StatusUnauthorized},
+		"unsigned":        {http.MethodPost, "repo:push", body, "", http.StatusUnauthorized},
+		"ignored event":   {http.MethodPost, "repo:fork", body, sign("s3cret", body), http.StatusOK},
+		"other workspace": {http.MethodPost, "repo:push", `{"repository": {"full_name": "other/x"}}`, sign("s3cret", `{"repository": {"full_name": "other/x"}}`), http.StatusOK},
+		"bad payload":     {http.MethodPost, "repo:push", `{`, sign("s3cret", `{`), http.StatusBadRequest},
+		"get":             {http.MethodGet, "repo:push", "", "", http.StatusMethodNotAllowed},
+	} {
+		if got := deliver(tc.method, tc.key, tc.body, tc.signature); got != tc.want {
+			t.Errorf("%s: status %d, want %d", name, got, tc.want)
+		}
+	}
+
+	if got := r.Take(); len(got) != 1 || !reflect.DeepEqual(got[0], Batch{Repos: []string{"api"}, Git: true}) {
+		t.Errorf("Take() = %+v, want only api's push", got)
+	}
+}
+
+func TestReceiver_Take(t *testing.T) {
+	r := NewReceiver(Options{})
+	r.Add(Event{Repo: "web", Metadata: true})
+	r.Add(Event{Repo: "api", Git: true})
+	r.Add(Event{Repo: "core", Git: true})
+	r.Add(Event{Repo: "core", Metadata: true})
+	r.Add(Event{Repo: "lib", Git: true})
+
+	want := []Batch{
+		{Repos: []string{"core"}, Git: true, Metadata: true},
+		{Repos: []string{"api", "lib"}, Git: true},
+		{Repos: []string{"web"}, Metadata: true},
+	}
+	if got := r.Take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Take() = %+v, want %+v", got, want)
+	}
+	if got := r.Take(); len(got) != 0 {
+		t.Errorf("second Take() = %+v, want nothing", got)
+	}
+}
+
+func TestReceiver_Run(t *testing.T) {
+	var mu sync.Mutex
+	var ran []Batch
+	done := make(chan struct{})
+	r := NewReceiver(Options{
+		Delay: 50 * time.Millisecond,
+		Backup: func(_ context.Context, b Batch) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, b)
+			close(done)
+			return nil
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Run(ctx) }()
+
+	// Both pushes arrive within the delay, so they are backed up together
+	r.Add(Event{Repo: "api", Git: true})
+	r.Add(Event{Repo: "web", Git: true})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no backup ran")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []Batch{{Repos: []string{"api", "web"}, Git: true}}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("backed up %+v, want %+v", ran, want)
+	}
+}
@@ -0,0 +1,189 @@
+// Package bbbackup is the Go API of bb-backup, for services that back up
+// Bitbucket workspaces or inspect them without running the CLI.
+//
+// The package follows semantic versioning along with the module's release
+// tags: within a major version, exported identifiers are not removed or
+// changed incompatibly, configuration files that load keep loading, and
+// new fields and functions only arrive in minor releases. Packages under
+// internal/, including the storage backends and the Bitbucket API client,
+// are not covered; this package is how other modules reach them.
+package bbbackup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/api"
+	"github.com/andy-wilson/bb-backup/internal/backup"
+	"github.com/andy-wilson/bb-backup/internal/config"
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+// Config is a bb-backup configuration, as read from its YAML file. Its
+// fields follow the file's documented keys.
+type Config = config.Config
+
+// DefaultConfig returns a configuration with the defaults the YAML file
+// starts from.
+func DefaultConfig() *Config {
+	return config.Default()
+}
+
+// LoadConfig reads and validates a configuration file, expanding
+// environment variables as the CLI does.
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// ParseConfig reads and validates a configuration from YAML.
+func ParseConfig(data []byte) (*Config, error) {
+	return config.Parse(data)
+}
+
+// Logger receives a run's log lines, formatted as with fmt.Sprintf.
+type Logger interface {
+	Info(msg string, args ...interface{})
+	Debug(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Options configures a backup run. The zero value runs a backup like the
+// CLI's backup command without flags: incremental when a previous backup
+// exists, full otherwise.
+type Options struct {
+	DryRun       bool // List what would be backed up without writing anything
+	Full         bool // Back up everything, ignoring the state of previous runs
+	Incremental  bool // Only fetch changes since the last backup; fails without one
+	GitOnly      bool // Only back up git repositories (skip PRs, issues)
+	MetadataOnly bool // Only back up PRs, issues (skip git operations)
+	MaxRetry     int  // Retry attempts for failed repositories (0 = the config's)
+
+	// Repos restricts the run to exactly these repositories; Groups to the
+	// repositories matched by the named config groups.
+	Repos  []string
+	Groups []string
+
+	// Logger receives the run's log; nil discards it.
+	Logger Logger
+}
+
+// Result is the outcome of a backup run.
+type Result struct {
+	RunID        string        // The run's directory under the workspace
+	Succeeded    int           // Repositories backed up
+	Failed       int           // Repositories that failed after retries
+	Skipped      int           // Resumed, archived, or quarantined repositories not run
+	Interrupted  int           // Repositories cut short by cancellation
+	Deferred     int           // Repositories left for the next run by runtime budgets
+	PullRequests int           // Pull requests saved
+	Issues       int           // Issues saved
+	Bytes        int64         // Size of the mirrors the run fetched
+	Duration     time.Duration // Wall time of the run
+}
+
+// Run backs up the workspace in cfg. A run in which some repositories
+// failed still returns a Result and a nil error; check Result.Failed. The
+// Result is nil when the run could not start, and partial when it stopped
+// early, e.g. because ctx was canceled. A config that lists workspaces is
+// backed up one workspace at a time, with each of cfg.WorkspaceConfigs().
+func Run(ctx context.Context, cfg *Config, opts Options) (*Result, error) {
+	var log backup.Logger = discardLogger{}
+	if opts.Logger != nil {
+		log = opts.Logger
+	}
+	b, err := backup.New(cfg, backup.Options{
+		DryRun:       opts.DryRun,
+		Full:         opts.Full,
+		Incremental:  opts.Incremental,
+		GitOnly:      opts.GitOnly,
+		MetadataOnly: opts.MetadataOnly,
+		MaxRetry:     opts.MaxRetry,
+		Repos:        opts.Repos,
+		Groups:       opts.Groups,
+		Quiet:        true,
+		Logger:       log,
+		Version:      Version(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	err = b.Run(ctx)
+	result := &Result{RunID: b.RunID(), Duration: time.Since(start)}
+	if s := b.Summary(); s != nil {
+		result.Succeeded, result.Failed, result.Skipped = s.Succeeded, s.Failed, s.Skipped
+		result.Interrupted, result.Deferred = s.Interrupted, s.Deferred
+		result.PullRequests, result.Issues, result.Bytes = s.PullRequests, s.Issues, s.Bytes
+	}
+	return result, err
+}
+
+// Repository is a repository a backup of the workspace would include.
+type Repository struct {
+	Slug        string
+	Name        string
+	FullName    string
+	Description string
+	Project     string // Project key; empty for personal repositories
+	Private     bool
+	Archived    bool
+	Size        int64  // Size in bytes as reported by Bitbucket
+	UpdatedOn   string // RFC 3339
+}
+
+// ListRepositories lists the repositories a backup with cfg would include,
+// after its project, include, and exclude filters. Like Run, it takes the
+// config of a single workspace.
+func ListRepositories(ctx context.Context, cfg *Config) ([]Repository, error) {
+	client := api.NewClient(cfg)
+	if cfg.RateLimit.SharedStateFile != "" {
+		if err := client.RateLimiter().UseSharedBucket(cfg.RateLimit.SharedStateFile); err != nil {
+			return nil, fmt.Errorf("enabling shared rate limit: %w", err)
+		}
+	}
+	includePatterns, err := backup.IncludePatterns(cfg)
+	if err != nil {
+		return nil, err
+	}
+	filter := backup.NewRepoFilter(includePatterns, cfg.Backup.ExcludeRepos)
+	repos, err := backup.ListRepositories(ctx, client, cfg, filter)
+	if err != nil {
+		return nil, fmt.Errorf("fetching repositories: %w", err)
+	}
+
+	repos = filter.Filter(repos)
+	list := make([]Repository, 0, len(repos))
+	for _, repo := range repos {
+		r := Repository{
+			Slug:        repo.Slug,
+			Name:        repo.Name,
+			FullName:    repo.FullName,
+			Description: repo.Description,
+			Private:     repo.IsPrivate,
+			Archived:    repo.IsArchived,
+			Size:        repo.Size,
+			UpdatedOn:   repo.UpdatedOn,
+		}
+		if repo.Project != nil {
+			r.Project = repo.Project.Key
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// ErrorCode returns the stable failure code of an error returned by this
+// package, such as "AUTH_FAILED" or "REPOS_FAILED", as listed in the
+// README. It is empty for a nil error.
+func ErrorCode(err error) string {
+	return string(errcode.Of(err))
+}
+
+// discardLogger drops log lines when Options.Logger is nil.
+type discardLogger struct{}
+
+func (discardLogger) Info(string, ...interface{})  {}
+func (discardLogger) Debug(string, ...interface{}) {}
+func (discardLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,115 @@
+package bbbackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/andy-wilson/bb-backup/internal/errcode"
+)
+
+func TestBuildVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		info debug.BuildInfo
+		want string
+	}{
+		{"installed binary", debug.BuildInfo{Main: debug.Module{Path: ModulePath, Version: "v0.5.0"}}, "v0.5.0"},
+		{"source checkout", debug.BuildInfo{Main: debug.Module{Path: ModulePath, Version: "(devel)"}}, "dev"},
+		{"dependency", debug.BuildInfo{
+			Main: debug.Module{Path: "example.com/service"},
+			Deps: []*debug.Module{{Path: "golang.org/x/sys", Version: "v0.1.0"}, {Path: ModulePath, Version: "v0.5.1"}},
+		}, "v0.5.1"},
+		{"replaced dependency", debug.BuildInfo{
+			Main: debug.Module{Path: "example.com/service"},
+			Deps: []*debug.Module{{Path: ModulePath, Version: "v0.5.1", Replace: &debug.Module{Path: "../bb-backup"}}},
+		}, "dev"},
+		{"not linked", debug.BuildInfo{Main: debug.Module{Path: "example.com/service", Version: "v1.0.0"}}, "dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildVersion(&tt.info); got != tt.want {
+				t.Errorf("buildVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	old := version
+	defer func() { version = old }()
+	version = "v1.2.3"
+	if got := Version(); got != "v1.2.3" {
+		t.Errorf("Version() = %q, want the version set at build time", got)
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repositories/my-workspace" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"values": []map[string]interface{}{
+				{"slug": "api", "full_name": "my-workspace/api", "is_private": true, "size": 2048, "project": map[string]string{"key": "CORE"}},
+				{"slug": "test-fixtures", "full_name": "my-workspace/test-fixtures"},
+				{"slug": "notes", "full_name": "my-workspace/notes"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	cfg, err := ParseConfig([]byte(`
+workspace: "my-workspace"
+auth:
+  method: "app_password"
+  username: "user"
+  app_password: "pass"
+storage:
+  type: "local"
+  path: "/backups"
+api:
+  base_url: "` + srv.URL + `"
+backup:
+  exclude_repos: ["test-*"]
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	repos, err := ListRepositories(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("ListRepositories() error = %v", err)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("got %d repositories, want 2 after excludes: %+v", len(repos), repos)
+	}
+	if r := repos[0]; r.Slug != "api" || r.Project != "CORE" || !r.Private || r.Size != 2048 {
+		t.Errorf("repos[0] = %+v", r)
+	}
+	if r := repos[1]; r.Slug != "notes" || r.Project != "" {
+		t.Errorf("repos[1] = %+v", r)
+	}
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	_, err := ParseConfig([]byte("workspace: \"\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "workspace") {
+		t.Errorf("ParseConfig() error = %v, want a workspace error", err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	if got := ErrorCode(errcode.New(errcode.ReposFailed, "2 repositories failed")); got != "REPOS_FAILED" {
+		t.Errorf("ErrorCode() = %q, want REPOS_FAILED", got)
+	}
+	if got := ErrorCode(errors.New("boom")); got != "UNKNOWN" {
+		t.Errorf("ErrorCode() = %q, want UNKNOWN", got)
+	}
+	if got := ErrorCode(nil); got != "" {
+		t.Errorf("ErrorCode(nil) = %q, want empty", got)
+	}
+}
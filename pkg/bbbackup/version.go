@@ -0,0 +1,47 @@
+package bbbackup
+
+import "runtime/debug"
+
+// ModulePath is the Go module bb-backup is released as.
+const ModulePath = "github.com/andy-wilson/bb-backup"
+
+// version is set at build time, e.g.
+//
+//	-ldflags "-X github.com/andy-wilson/bb-backup/pkg/bbbackup.version=v0.5.0"
+var version string
+
+// Version returns the release of bb-backup in use, such as "v0.5.0". It is
+// the version stamped in at build time, or else the module version from the
+// binary's build info, which is set when bb-backup is a dependency of
+// another module or was installed with go install. Builds from a source
+// checkout without either report "dev".
+func Version() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	return buildVersion(info)
+}
+
+// buildVersion finds bb-backup's version in a binary's build info.
+func buildVersion(info *debug.BuildInfo) string {
+	if info.Main.Path == ModulePath && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != ModulePath {
+			continue
+		}
+		// A replaced module is built from the replacement
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			return dep.Replace.Version
+		}
+		if dep.Replace == nil {
+			return dep.Version
+		}
+	}
+	return "dev"
+}
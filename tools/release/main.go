@@ -0,0 +1,56 @@
+// Command release prepares a bb-backup release: it moves the unreleased
+// changes in CHANGELOG.md under the new version, or prints a released
+// version's notes. Run it through make release.
+//
+//	go run ./tools/release -version v0.5.0
+//	go run ./tools/release -version v0.5.0 -notes
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/andy-wilson/bb-backup/internal/release"
+)
+
+func main() {
+	version := flag.String("version", "", "release version, e.g. v0.5.0")
+	changelog := flag.String("changelog", "CHANGELOG.md", "changelog to update")
+	notes := flag.Bool("notes", false, "print the version's changelog section instead of updating it")
+	flag.Parse()
+
+	if err := run(*changelog, *version, *notes); err != nil {
+		fmt.Fprintln(os.Stderr, "release:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path, version string, notes bool) error {
+	if err := release.ValidVersion(version); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if notes {
+		text, err := release.Notes(data, version)
+		if err != nil {
+			return err
+		}
+		fmt.Print(text)
+		return nil
+	}
+
+	updated, err := release.Promote(data, version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, updated, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Moved unreleased changes in %s to %s\n", path, version)
+	return nil
+}